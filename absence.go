@@ -0,0 +1,81 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// absenceCache remembers records confirmed absent from their provider while
+// their creation is held for approval, so each cycle doesn't list them
+// again. An entry holds only as long as the record's desired value stays
+// the same, and is dropped by any write to the record or once it expires.
+// Reloading the configuration starts with an empty cache.
+type absenceCache struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[string]absentRecord
+}
+
+type absentRecord struct {
+	desired string // Desired value when the absence was confirmed
+	until   time.Time
+}
+
+// newAbsenceCache returns a cache whose entries last ttl.
+func newAbsenceCache(ttl time.Duration) *absenceCache {
+	return &absenceCache{ttl: ttl, entries: make(map[string]absentRecord)}
+}
+
+// absenceKey identifies a record in the cache.
+func absenceKey(domain DomainConfig) string {
+	return domain.Provider + " " + strings.ToLower(domain.FQDN()) + "/" + domain.Type
+}
+
+// Add records that domain is absent while it should hold desired.
+func (c *absenceCache) Add(domain DomainConfig, desired string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[absenceKey(domain)] = absentRecord{desired: desired, until: time.Now().Add(c.ttl)}
+}
+
+// Known reports whether domain is known to be absent and still wanted with
+// the value desired.
+func (c *absenceCache) Known(domain DomainConfig, desired string) bool {
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := absenceKey(domain)
+	entry, ok := c.entries[key]
+	if ok && (entry.desired != desired || time.Now().After(entry.until)) {
+		delete(c.entries, key)
+		return false
+	}
+	return ok
+}
+
+// Forget drops what is known about domain, after a write to it.
+func (c *absenceCache) Forget(domain DomainConfig) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, absenceKey(domain))
+}
+
+// Clear drops everything known, so that each record is listed again.
+func (c *absenceCache) Clear() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	clear(c.entries)
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// TestAbsenceCache tests that known absences hold until the desired value changes, a write or expiry
+func TestAbsenceCache(t *testing.T) {
+	home := DomainConfig{Name: "example.com", Record: "home", Type: "A", Provider: DefaultProvider}
+	nas := DomainConfig{Name: "example.com", Record: "nas", Type: "A", Provider: DefaultProvider}
+	c := newAbsenceCache(time.Hour)
+
+	c.Add(home, "192.0.2.1")
+	if !c.Known(DomainConfig{Name: "EXAMPLE.com", Record: "home", Type: "A", Provider: DefaultProvider}, "192.0.2.1") {
+		t.Error("expected the absence to be known regardless of case")
+	}
+	if c.Known(nas, "192.0.2.1") {
+		t.Error("expected another record to be unknown")
+	}
+	if c.Known(home, "192.0.2.2") || c.Known(home, "192.0.2.1") {
+		t.Error("expected a new desired value to drop the entry")
+	}
+
+	c.Add(home, "192.0.2.1")
+	c.Forget(home)
+	if c.Known(home, "192.0.2.1") {
+		t.Error("expected a write to drop the entry")
+	}
+
+	expired := newAbsenceCache(-time.Second)
+	expired.Add(home, "192.0.2.1")
+	if expired.Known(home, "192.0.2.1") {
+		t.Error("expected an expired entry to be unknown")
+	}
+
+	var none *absenceCache
+	none.Add(home, "192.0.2.1")
+	if none.Known(home, "192.0.2.1") {
+		t.Error("expected a nil cache to know nothing")
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import "time"
+
+// StageBackoff is how a stage that is backing off has been failing.
+type StageBackoff struct {
+	ConsecutiveFailures int       `json:"consecutive_failures"` // Failed runs in a row
+	LastFailure         time.Time `json:"last_failure"`         // When the last of them started
+}
+
+// restoreBackoff carries the failures in a row saved in the state over to
+// the stages, so that a daemon restarted while the IP service or a provider
+// is down keeps backing off rather than starting over at retry_interval.
+// Stages that no longer exist are dropped from the state.
+func (d *DDNSUpdater) restoreBackoff() {
+	stages := map[string]*Stage{d.detection.Name: d.detection}
+	for _, h := range d.providers {
+		stages[h.stage.Name] = h.stage
+	}
+	for name, b := range d.state.Backoff {
+		stage := stages[name]
+		if stage == nil {
+			delete(d.state.Backoff, name)
+			continue
+		}
+		stage.Restore(b.ConsecutiveFailures, b.LastFailure)
+		d.logger.Info("Backing off after failures before the restart", "stage", name, "failures", b.ConsecutiveFailures,
+			"next_run", b.LastFailure.Add(stage.retryDelay(b.ConsecutiveFailures)))
+	}
+}
+
+// saveBackoff returns the OnRun of the named stage, which saves its
+// failures in a row in the state while it fails and forgets them once it
+// succeeds.
+func (d *DDNSUpdater) saveBackoff(name string) func(StageMetrics) {
+	return func(m StageMetrics) {
+		d.stateMu.Lock()
+		defer d.stateMu.Unlock()
+		if m.ConsecutiveFailures == 0 {
+			if _, ok := d.state.Backoff[name]; !ok {
+				return
+			}
+			delete(d.state.Backoff, name)
+		} else {
+			if d.state.Backoff == nil {
+				d.state.Backoff = make(map[string]*StageBackoff)
+			}
+			d.state.Backoff[name] = &StageBackoff{ConsecutiveFailures: m.ConsecutiveFailures, LastFailure: m.LastRun}
+		}
+		if err := d.saveState(); err != nil {
+			d.logger.Error("Failed to save state", "error", err)
+		}
+	}
+}
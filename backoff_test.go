@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+// TestBackoffAcrossRestarts tests that a stage's failures in a row are
+// saved in the state, restored by the next daemon so its first run waits
+// out the backoff, and forgotten after a success
+func TestBackoffAcrossRestarts(t *testing.T) {
+	updater := newSupervisedUpdater(t)
+	failing := errors.New("ipinfo.io is down")
+	detect := func(context.Context) error { return failing }
+	updater.detection = NewStage("detection", 5*time.Minute, time.Minute, detect, nil)
+	updater.detection.MaxRetryInterval = time.Hour
+	updater.detection.OnRun = updater.saveBackoff("detection")
+	for range 3 {
+		updater.detection.Execute(context.Background())
+	}
+
+	state, err := stateStoreFor(updater.config).Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := state.Backoff["detection"]
+	if saved == nil || saved.ConsecutiveFailures != 3 {
+		t.Fatalf("expected 3 failures to be saved, got %+v", saved)
+	}
+
+	restarted := newSupervisedUpdater(t)
+	restarted.config.StatePath = updater.config.StatePath
+	restarted.state = state
+	restarted.detection.RetryInterval = time.Minute
+	restarted.detection.MaxRetryInterval = time.Hour
+	restarted.detection.RunOnStart = true
+	restarted.state.Backoff["publication:gone"] = &StageBackoff{ConsecutiveFailures: 1}
+	restarted.restoreBackoff()
+	if _, ok := restarted.state.Backoff["publication:gone"]; ok {
+		t.Error("expected the backoff of a stage that no longer exists to be dropped")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- restarted.detection.Loop(ctx, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	}()
+	deadline := time.Now().Add(2 * time.Second)
+	for restarted.detection.Metrics().NextRun.IsZero() && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-done
+	// The third failure in a row is retried after 4m
+	want := saved.LastFailure.Add(4 * time.Minute)
+	if next := restarted.detection.Metrics().NextRun; next.Sub(want).Abs() > time.Second {
+		t.Errorf("expected the first run at %v, got %v", want, next)
+	}
+
+	restarted.detection.OnRun = restarted.saveBackoff("detection")
+	restarted.detection.run = func(context.Context) error { return nil }
+	restarted.detection.Execute(context.Background())
+	if state, err = stateStoreFor(restarted.config).Load(); err != nil {
+		t.Fatal(err)
+	}
+	if len(state.Backoff) != 0 {
+		t.Errorf("expected a success to end the backoff, got %v", state.Backoff)
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import "context"
+
+// RequestCheck asks the running daemon to check the IP and publish every
+// provider's records right away, outside the stage schedules, as SIGUSR1
+// does. Requests made while a check is pending are coalesced into it.
+func (d *DDNSUpdater) RequestCheck() {
+	select {
+	case d.checkNow <- struct{}{}:
+	default: // A check is already pending
+	}
+}
+
+// serveCheckRequests runs checkAndUpdate for each RequestCheck until ctx is
+// cancelled. Records confirmed absent are listed again too, in case they
+// were created since.
+func (d *DDNSUpdater) serveCheckRequests(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-d.checkNow:
+		}
+		d.logger.Info("Checking now, as requested")
+		d.absent.Clear()
+		if err := d.checkAndUpdate(ctx); err != nil && ctx.Err() == nil {
+			d.logger.Error("Requested check failed", "error", err)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// TestServeCheckRequests tests that a requested check runs detection and
+// publication at once, forgetting the records known to be absent
+func TestServeCheckRequests(t *testing.T) {
+	updater := newSupervisedUpdater(t)
+	updater.checkNow = make(chan struct{}, 1)
+	updater.absent = newAbsenceCache(time.Hour)
+	domain := updater.config.Domains[0]
+	updater.absent.Add(domain, "203.0.113.42")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		updater.serveCheckRequests(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	updater.RequestCheck()
+	publication := updater.providers[DefaultProvider].stage
+	deadline := time.Now().Add(5 * time.Second)
+	for publication.Metrics().Runs == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the requested check")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if runs := updater.detection.Metrics().Runs; runs != 1 {
+		t.Errorf("expected detection to run once, got %d", runs)
+	}
+	if updater.absent.Known(domain, "203.0.113.42") {
+		t.Error("expected the absence cache to be cleared")
+	}
+}
+
+// TestCheckNowSignal tests that the check signal requests a check without
+// stopping or reloading the daemon
+func TestCheckNowSignal(t *testing.T) {
+	if len(checkNowSignals) == 0 {
+		t.Skip("no check signal on this platform")
+	}
+	updater := &DDNSUpdater{logger: slog.New(slog.NewJSONHandler(io.Discard, nil)), checkNow: make(chan struct{}, 1)}
+	load := func() (*DDNSUpdater, error) {
+		t.Error("expected no reload")
+		return nil, nil
+	}
+	signals := make(chan os.Signal, 2)
+	signals <- checkNowSignals[0]
+	signals <- syscall.SIGTERM
+
+	next, err := awaitReload(context.Background(), updater, load, make(chan error), nil, signals)
+	if next != nil || err != nil {
+		t.Errorf("expected the daemon to stop on SIGTERM, got %v, %v", next, err)
+	}
+	if len(updater.checkNow) != 1 {
+		t.Error("expected a check to be requested")
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// checkNowSignals are the signals requesting a check; see RequestCheck.
+var checkNowSignals = []os.Signal{syscall.SIGUSR1}
@@ -0,0 +1,7 @@
+package main
+
+import "os"
+
+// checkNowSignals are the signals requesting a check; Windows has no
+// SIGUSR1, so there are none.
+var checkNowSignals []os.Signal
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// commands maps one-shot subcommand names to their implementations. Each
+// receives the arguments following its name and returns the exit code.
+var commands = map[string]func(args []string) int{
+	"plan":         runPlanCommand,
+	"apply":        runApplyCommand,
+	"smoke":        runSmokeCommand,
+	"cutover":      runCutoverCommand,
+	"doctor":       runDoctorCommand,
+	"validate":     runValidateCommand,
+	"telemetry":    runTelemetryCommand,
+	"print-config": runPrintConfigCommand,
+	"history":      runHistoryCommand,
+	"state":        runStateCommand,
+	"prune":        runPruneCommand,
+	"status":       runStatusCommand,
+	"healthcheck":  runHealthcheckCommand,
+	"service":      runServiceCommand,
+	"version":      runVersionCommand,
+}
+
+// newCommandUpdater creates an updater for a one-shot command. Logs go to
+// stderr so that stdout carries only the command's own output. When stderr
+// is a terminal, the steps of the run are shown there as progress too.
+func newCommandUpdater(configPath string, opts configOptions) (*DDNSUpdater, error) {
+	config, err := loadUpdaterConfig(configPath, opts)
+	if err != nil {
+		return nil, err
+	}
+	// Commands run alongside the daemon, so the state it holds is left
+	// alone; the lock is taken before the state is loaded, which can change it
+	var lock *stateLock
+	var lockErr error
+	if locksState(config) {
+		lock, lockErr = lockState(config.StatePath)
+	}
+
+	p := terminalProgress()
+	updater, err := newUpdaterFor(config, p.Logs(os.Stderr))
+	if err != nil {
+		lock.Unlock()
+		return nil, err
+	}
+	updater.progress = p
+	// Notifications report on the daemon; a command's updates are the user's own
+	updater.notifications = nil
+
+	updater.lock = lock
+	switch {
+	case errors.Is(lockErr, errStateLocked):
+		updater.logger.Warn("Another instance is using the state file; state changes won't be saved", "error", lockErr)
+		updater.stateReadOnly = true
+	case lockErr != nil:
+		updater.logger.Debug("State file not locked; state changes won't be saved", "error", lockErr)
+		updater.stateReadOnly = true
+	}
+	return updater, nil
+}
+
+// settingFlags are the config settings that can be overridden on the
+// command line, by flag name, with the config key each one sets.
+var settingFlags = []struct {
+	flag, key string
+	kind      string // "duration", "bool" or "string"
+	usage     string
+}{
+	{"check-interval", "check_interval", "duration", "how often to check for IP changes"},
+	{"publish-interval", "publish_interval", "duration", "how often to reconcile records without an IP change"},
+	{"retry-interval", "retry_interval", "duration", "how soon a failed detection or publication is retried"},
+	{"log-level", "log_level", "string", "logging level: trace, debug, info, warn or error"},
+	{"state-path", "state_path", "string", "where to store persistent state"},
+	{"pid-file", "pid_file", "string", "where to write the daemon's process ID"},
+	{"dry-run", "dry_run", "bool", "log planned changes without making them"},
+	{"require-approval", "require_approval", "bool", "hold changes until approved with the apply command"},
+	{"watch-config", "watch_config", "bool", "reload when the config file changes"},
+	{"kubernetes", "kubernetes", "bool", "use the defaults for running in a Kubernetes Pod"},
+}
+
+// settingFlag is a flag that sets a config key in configOptions.Set.
+type settingFlag struct {
+	opts *configOptions
+	key  string
+	kind string
+}
+
+func (f *settingFlag) String() string {
+	if f.opts == nil {
+		return ""
+	}
+	return f.opts.Set[f.key]
+}
+
+func (f *settingFlag) Set(value string) error {
+	switch f.kind {
+	case "duration":
+		if _, err := time.ParseDuration(value); err != nil {
+			return errors.New("invalid duration")
+		}
+	case "bool":
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return errors.New("invalid boolean")
+		}
+		value = strconv.FormatBool(b)
+	}
+	if f.opts.Set == nil {
+		f.opts.Set = make(map[string]string)
+	}
+	f.opts.Set[f.key] = value
+	return nil
+}
+
+func (f *settingFlag) IsBoolFlag() bool { return f.kind == "bool" }
+
+// configFlags registers the flags shared by the daemon and the commands
+// that select the config file and control how it is read, including those
+// overriding its settings.
+func configFlags(fs *flag.FlagSet) *configOptions {
+	opts := &configOptions{}
+	fs.String("config", "", "config file (default: the first argument, or "+DefaultConfigPath+")")
+	fs.StringVar(&opts.Profile, "profile", "", "config profile to apply (default: the config's profile setting)")
+	fs.StringVar(&opts.Format, "format", "", "config file format: yaml, json or toml (default: by file extension)")
+	fs.StringVar(&opts.CacheDir, "config-cache-dir", "", "where the last good copy of a remote config is kept (default: "+DefaultConfigCacheDir+")")
+	fs.StringVar(&opts.AgeKeyFile, "age-key-file", "", "age identity for SOPS-encrypted configs and age-encrypted secret files (default: $SOPS_AGE_KEY_FILE or "+DefaultAgeKeyFile+")")
+	for _, s := range settingFlags {
+		fs.Var(&settingFlag{opts: opts, key: s.key, kind: s.kind}, s.flag, s.usage+" (overrides "+s.key+")")
+	}
+	return opts
+}
+
+// timeoutFlag registers the --timeout flag shared by the commands, so that
+// scripted runs are guaranteed to terminate.
+func timeoutFlag(fs *flag.FlagSet) *time.Duration {
+	return fs.Duration("timeout", 0, "give up after this long, e.g. 2m (0 means no limit)")
+}
+
+// commandContext returns the context a command runs under. It is cancelled
+// by SIGINT or SIGTERM and, if timeout is positive, once timeout has passed.
+// Every provider and detector call takes this context, so a timeout stops
+// whichever one is in progress.
+func commandContext(timeout time.Duration) (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	if timeout <= 0 {
+		return ctx, stop
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	return ctx, func() {
+		cancel()
+		stop()
+	}
+}
+
+// commandConfigPath returns the config path given with --config or as the
+// command's first positional argument, or the default path.
+func commandConfigPath(fs *flag.FlagSet) string {
+	if f := fs.Lookup("config"); f != nil && f.Value.String() != "" {
+		return f.Value.String()
+	}
+	if fs.NArg() > 0 {
+		return fs.Arg(0)
+	}
+	return DefaultConfigPath
+}
+
+// detectAndPlan runs detection once and computes a plan from the result.
+// A detection failure is reported but still yields a plan, in which records
+// that depend on the IP are skipped.
+func detectAndPlan(ctx context.Context, updater *DDNSUpdater) (*Plan, error) {
+	updater.progress.Start("detect")
+	err := updater.detect(ctx)
+	updater.progress.Done(err)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+	}
+	return updater.planAll(ctx)
+}
+
+// runPlanCommand prints the changes the daemon would make without making them.
+//
+//	dh-ddns-updater plan [--json] [--profile name] [--timeout duration] [config]
+func runPlanCommand(args []string) int {
+	fs := flag.NewFlagSet("plan", flag.ContinueOnError)
+	jsonOutput := fs.Bool("json", false, "print the plan as JSON")
+	opts := configFlags(fs)
+	timeout := timeoutFlag(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: dh-ddns-updater plan [--json] [--profile name] [--timeout duration] [config]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	updater, err := newCommandUpdater(commandConfigPath(fs), *opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to initialize updater: %v\n", err)
+		return 1
+	}
+
+	ctx, cancel := commandContext(*timeout)
+	defer cancel()
+
+	plan, err := detectAndPlan(ctx, updater)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to compute plan: %v\n", err)
+		return 1
+	}
+
+	if *jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(plan); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to encode plan: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+
+	plan.Print(os.Stdout)
+	return 0
+}
+
+// checkApproved checks that plan makes the changes held awaiting approval,
+// none when held is nil, so that what is applied is what was reviewed.
+func checkApproved(held, plan *Plan) error {
+	if held == nil {
+		held = &Plan{}
+	}
+	switch {
+	case held.sameChanges(plan):
+		return nil
+	case len(held.Changes()) == 0:
+		return errors.New("the daemon holds no changes awaiting approval yet; review them with the status command once it does")
+	default:
+		return errors.New("the changes differ from those awaiting approval; review them again with the status command")
+	}
+}
+
+// runApplyCommand computes a fresh plan and applies it immediately. This is
+// how changes held by require_approval are approved: when the daemon
+// answers on its health listener, the fresh plan must make exactly the
+// changes it holds, so that what is applied is what was reviewed. dry_run
+// and rollback_on_failure from the config are honored.
+//
+//	dh-ddns-updater apply [--profile name] [--timeout duration] [config]
+func runApplyCommand(args []string) int {
+	fs := flag.NewFlagSet("apply", flag.ContinueOnError)
+	opts := configFlags(fs)
+	timeout := timeoutFlag(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: dh-ddns-updater apply [--profile name] [--timeout duration] [config]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	updater, err := newCommandUpdater(commandConfigPath(fs), *opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to initialize updater: %v\n", err)
+		return 1
+	}
+
+	// Without a listener, or with the daemon down, the changes held can't
+	// be asked for, and the plan printed below is what is approved
+	var held *Plan
+	var daemonAnswered bool
+	if updater.config.RequireApproval && updater.config.Health.Listen != "" {
+		if status, err := queryStatus(healthURL(updater.config.Health.Listen, "/status")); err == nil {
+			held, daemonAnswered = status.Pending, true
+		}
+	}
+
+	ctx, cancel := commandContext(*timeout)
+	defer cancel()
+
+	plan, err := detectAndPlan(ctx, updater)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to compute plan: %v\n", err)
+		return 1
+	}
+	plan.Print(os.Stdout)
+	if daemonAnswered {
+		if err := checkApproved(held, plan); err != nil {
+			fmt.Fprintf(os.Stderr, "Not applying: %v\n", err)
+			return 1
+		}
+	}
+
+	policy := updater.applyPolicy()
+	policy.RequireApproval = false
+	if err := updater.apply(ctx, plan, policy); err != nil {
+		fmt.Fprintf(os.Stderr, "Apply failed: %v\n", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestCommandContext tests that --timeout bounds a command's context and that 0 means no limit
+func TestCommandContext(t *testing.T) {
+	ctx, cancel := commandContext(10 * time.Millisecond)
+	defer cancel()
+	select {
+	case <-ctx.Done():
+		if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			t.Errorf("expected deadline exceeded, got %v", ctx.Err())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("context not cancelled after the timeout")
+	}
+
+	ctx, cancel = commandContext(0)
+	defer cancel()
+	if _, ok := ctx.Deadline(); ok {
+		t.Error("expected no deadline without a timeout")
+	}
+	cancel()
+	if ctx.Err() == nil {
+		t.Error("expected cancel to cancel the context")
+	}
+}
+
+// TestCheckApproved tests that apply only makes the changes held awaiting approval
+func TestCheckApproved(t *testing.T) {
+	update := Action{Kind: ActionUpdate, Record: "home.example.com", Type: "A", Current: "198.51.100.1", Desired: "203.0.113.42"}
+	create := Action{Kind: ActionCreate, Record: "vpn.example.com", Type: "A", Desired: "203.0.113.42"}
+	moved := update
+	moved.Desired = "203.0.113.43"
+	noop := Action{Kind: ActionNoop, Record: "www.example.com", Type: "A", Desired: "203.0.113.42"}
+
+	tests := []struct {
+		name    string
+		held    *Plan
+		plan    *Plan
+		wantErr string
+	}{
+		{name: "same changes", held: &Plan{Actions: []Action{update, create}}, plan: &Plan{Actions: []Action{create, noop, update}}},
+		{name: "nothing held or to do", plan: &Plan{Actions: []Action{noop}}},
+		{name: "nothing held", plan: &Plan{Actions: []Action{update}}, wantErr: "no changes awaiting approval"},
+		{name: "changed", held: &Plan{Actions: []Action{update}}, plan: &Plan{Actions: []Action{moved}}, wantErr: "differ"},
+		{name: "more changes", held: &Plan{Actions: []Action{update}}, plan: &Plan{Actions: []Action{update, create}}, wantErr: "differ"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkApproved(tt.held, tt.plan)
+			if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+// TestConfigFlags tests that setting flags override the config file and that --config selects it
+func TestConfigFlags(t *testing.T) {
+	data := []byte("check_interval: 5m\nlog_level: info\nprofile: quiet\nprofiles:\n  quiet:\n    log_level: warn\n")
+
+	tests := []struct {
+		name         string
+		args         []string
+		wantPath     string
+		wantInterval time.Duration
+		wantLogLevel string
+		wantDryRun   bool
+		wantError    bool
+	}{
+		{name: "file values", args: []string{"config.yaml"}, wantPath: "config.yaml", wantInterval: 5 * time.Minute, wantLogLevel: "warn"},
+		{
+			name:         "flags win over file and profile",
+			args:         []string{"--config", "other.yaml", "--check-interval", "30s", "--log-level", "debug", "--dry-run"},
+			wantPath:     "other.yaml",
+			wantInterval: 30 * time.Second,
+			wantLogLevel: "debug",
+			wantDryRun:   true,
+		},
+		{name: "default path", args: []string{"--dry-run=false"}, wantPath: DefaultConfigPath, wantInterval: 5 * time.Minute, wantLogLevel: "warn"},
+		{name: "bad duration", args: []string{"--check-interval", "soon"}, wantError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			fs.SetOutput(io.Discard)
+			opts := configFlags(fs)
+			if err := fs.Parse(tt.args); err != nil {
+				if !tt.wantError {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if tt.wantError {
+				t.Fatal("expected error but got none")
+			}
+			if path := commandConfigPath(fs); path != tt.wantPath {
+				t.Errorf("expected config path %q, got %q", tt.wantPath, path)
+			}
+
+			config, err := parseConfig(data, *opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if config.CheckInterval != tt.wantInterval || config.LogLevel != tt.wantLogLevel || config.DryRun != tt.wantDryRun {
+				t.Errorf("expected check_interval %v, log_level %q, dry_run %v, got %v, %q, %v",
+					tt.wantInterval, tt.wantLogLevel, tt.wantDryRun, config.CheckInterval, config.LogLevel, config.DryRun)
+			}
+		})
+	}
+}
@@ -0,0 +1,678 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// envReference matches ${VAR} and the escaped form $${VAR}.
+var envReference = regexp.MustCompile(`\$?\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// expandEnv replaces ${VAR} references in s with the value of the
+// environment variable. $${VAR} is left in place as a literal ${VAR}. A
+// reference to an unset variable is an error so that a missing secret is
+// caught at startup rather than sent to the API as an empty string.
+func expandEnv(s string, lookup func(string) (string, bool)) (string, error) {
+	var missing []string
+	out := envReference.ReplaceAllStringFunc(s, func(ref string) string {
+		if strings.HasPrefix(ref, "$$") {
+			return ref[1:]
+		}
+		name := ref[2 : len(ref)-1]
+		value, ok := lookup(name)
+		if !ok {
+			missing = append(missing, name)
+		}
+		return value
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("environment variable %s is not set", strings.Join(missing, ", "))
+	}
+	return out, nil
+}
+
+// expandEnvNode expands environment references in every scalar value below
+// node. Expansion happens after parsing, so a variable's value is always
+// taken literally and can't change the structure of the document.
+func expandEnvNode(node *yaml.Node, lookup func(string) (string, bool)) error {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		value, err := expandEnv(node.Value, lookup)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", node.Line, err)
+		}
+		if value != node.Value && node.Style == 0 {
+			// Re-resolve plain scalars so ttl: ${TTL} still decodes as a number
+			node.Tag = ""
+		}
+		node.Value = value
+	case yaml.MappingNode:
+		// Keys are never expanded
+		for i := 1; i < len(node.Content); i += 2 {
+			if err := expandEnvNode(node.Content[i], lookup); err != nil {
+				return err
+			}
+		}
+	default:
+		for _, child := range node.Content {
+			if err := expandEnvNode(child, lookup); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// mappingValue returns the value stored under key in a mapping node, and
+// its index in node.Content, or nil and -1.
+func mappingValue(node *yaml.Node, key string) (*yaml.Node, int) {
+	if node.Kind != yaml.MappingNode {
+		return nil, -1
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1], i + 1
+		}
+	}
+	return nil, -1
+}
+
+// applyProfile overlays the named entry of the profiles section onto the
+// top level of root, replacing each key the profile sets. An empty name
+// selects the config's own profile setting, if any. The profiles section is
+// removed afterwards, so unused profiles are never expanded or validated,
+// and profile is set to the name actually applied.
+func applyProfile(root *yaml.Node, name string) error {
+	profiles, idx := mappingValue(root, "profiles")
+	if idx >= 0 {
+		root.Content = slices.Delete(root.Content, idx-1, idx+1)
+	}
+	if name == "" {
+		if def, _ := mappingValue(root, "profile"); def != nil {
+			name = def.Value
+		}
+	}
+	if name == "" {
+		return nil
+	}
+
+	profile, _ := mappingValue(profiles, name)
+	if profile == nil {
+		return fmt.Errorf("unknown profile %q", name)
+	}
+	if profile.Kind != yaml.MappingNode {
+		return fmt.Errorf("line %d: profile %q must be a mapping", profile.Line, name)
+	}
+	for i := 0; i+1 < len(profile.Content); i += 2 {
+		key, value := profile.Content[i], profile.Content[i+1]
+		if key.Value == "profile" || key.Value == "profiles" {
+			return fmt.Errorf("line %d: profile %q cannot set %s", key.Line, name, key.Value)
+		}
+		if _, j := mappingValue(root, key.Value); j >= 0 {
+			root.Content[j] = value
+		} else {
+			root.Content = append(root.Content, key, value)
+		}
+	}
+
+	selected := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: name}
+	if _, j := mappingValue(root, "profile"); j >= 0 {
+		root.Content[j] = selected
+	} else {
+		root.Content = append(root.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "profile"}, selected)
+	}
+	return nil
+}
+
+// configOptions controls how a config file is read.
+type configOptions struct {
+	Profile string // Profile to apply; "" for the config's profile setting
+	Format  string // yaml, json or toml; "" to go by the file extension
+
+	// AgeKeyFile is the age identity that decrypts SOPS-encrypted configs
+	// and age-encrypted secret files; "" for ageKeyFile's default.
+	AgeKeyFile string
+
+	// Set holds settings given on the command line, by config key. They
+	// take precedence over the file's values, profiles and fragments.
+	Set map[string]string
+
+	// CacheDir holds the last good copy of a remote config; "" for
+	// DefaultConfigCacheDir.
+	CacheDir string
+
+	dir string // Directory of the config file, for conf_dir; "" reads no fragments
+}
+
+// configFormat returns the format of the config at path: format if set,
+// otherwise the one its extension implies, with YAML for anything else.
+func configFormat(path, format string) (string, error) {
+	switch format {
+	case "yaml", "json", "toml":
+		return format, nil
+	case "":
+	default:
+		return "", fmt.Errorf("unsupported config format %q (want yaml, json or toml)", format)
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return "json", nil
+	case ".toml":
+		return "toml", nil
+	}
+	return "yaml", nil
+}
+
+// parseDocument parses a config in the given format into a YAML node tree.
+// JSON is a subset of YAML, but is checked against the stricter JSON syntax
+// first. TOML is converted by parseTOML.
+func parseDocument(data []byte, format string) (*yaml.Node, error) {
+	switch format {
+	case "toml":
+		return parseTOML(data)
+	case "json":
+		var v any
+		if err := json.Unmarshal(data, &v); err != nil {
+			var syntaxErr *json.SyntaxError
+			if errors.As(err, &syntaxErr) {
+				line := 1 + bytes.Count(data[:syntaxErr.Offset], []byte("\n"))
+				return nil, fmt.Errorf("json: line %d: %w", line, err)
+			}
+			return nil, fmt.Errorf("json: %w", err)
+		}
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	return &doc, nil
+}
+
+// parseConfig decodes configuration in the format opts names (YAML if
+// none), applying the selected profile (or the config's default profile
+// when none is), expanding environment references in its values and, when
+// opts has the config's directory, merging in the fragments of conf_dir.
+// Unless strict is turned off, unknown keys are rejected.
+func parseConfig(data []byte, opts configOptions) (*Config, error) {
+	doc, err := parseDocument(data, opts.Format)
+	if err != nil {
+		return nil, err
+	}
+	if len(doc.Content) > 0 {
+		if err := applyProfile(doc.Content[0], opts.Profile); err != nil {
+			return nil, err
+		}
+	} else if opts.Profile != "" {
+		return nil, fmt.Errorf("unknown profile %q", opts.Profile)
+	}
+	if err := expandEnvNode(doc, os.LookupEnv); err != nil {
+		return nil, err
+	}
+
+	if len(doc.Content) == 0 && (opts.dir != "" || len(opts.Set) > 0) {
+		doc = &yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}}}
+	}
+	var root *yaml.Node
+	if len(doc.Content) > 0 {
+		if root = doc.Content[0]; root.Kind != yaml.MappingNode && (opts.dir != "" || len(opts.Set) > 0) {
+			return nil, fmt.Errorf("line %d: expected a mapping", root.Line)
+		}
+	}
+
+	var config Config
+	if include, _ := mappingValue(root, "include"); include != nil {
+		if opts.dir == "" {
+			return nil, fmt.Errorf("line %d: include needs a config file to resolve paths against", include.Line)
+		}
+		paths, globDirs, err := includePaths(include, opts.dir)
+		if err != nil {
+			return nil, err
+		}
+		config.fragments = make(map[*yaml.Node]string)
+		if err := mergeFiles(root, paths, ageKeyFile(opts), config.fragments); err != nil {
+			return nil, err
+		}
+		config.includeFiles = append(paths, globDirs...)
+	}
+	if opts.dir != "" {
+		config.confDir = DefaultConfDir
+		if dir, _ := mappingValue(root, "conf_dir"); dir != nil {
+			config.confDir = dir.Value
+		}
+		if config.confDir != "" {
+			if !filepath.IsAbs(config.confDir) {
+				config.confDir = filepath.Join(opts.dir, config.confDir)
+			}
+			var fragments map[*yaml.Node]string
+			if config.fragmentFiles, fragments, err = mergeFragments(root, config.confDir, ageKeyFile(opts)); err != nil {
+				return nil, err
+			}
+			if config.fragments == nil {
+				config.fragments = fragments
+			} else {
+				maps.Copy(config.fragments, fragments)
+			}
+		}
+	}
+	for _, key := range slices.Sorted(maps.Keys(opts.Set)) {
+		setValue(root, key, opts.Set[key])
+	}
+	if root != nil {
+		if config.fragments == nil {
+			config.fragments = make(map[*yaml.Node]string)
+		}
+		if err := expandRecordLists(root, config.fragments); err != nil {
+			return nil, err
+		}
+		strict := true
+		if node, _ := mappingValue(root, "strict"); node != nil {
+			if err := node.Decode(&strict); err != nil {
+				return nil, err
+			}
+		}
+		if strict {
+			if err := errors.Join(checkKnownFields(root, reflect.TypeFor[Config](), config.fragments)...); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := doc.Decode(&config); err != nil {
+		return nil, describeDecodeError(err)
+	}
+	if len(doc.Content) > 0 {
+		config.doc = doc.Content[0]
+	}
+	return &config, nil
+}
+
+// describeDecodeError adds the expected format to yaml.v3's errors for
+// malformed durations, which otherwise only name the Go type, such as
+// "cannot unmarshal !!int `300` into time.Duration".
+func describeDecodeError(err error) error {
+	var typeErr *yaml.TypeError
+	if !errors.As(err, &typeErr) {
+		return err
+	}
+	described := &yaml.TypeError{Errors: slices.Clone(typeErr.Errors)}
+	for i, msg := range described.Errors {
+		if strings.HasSuffix(msg, "into time.Duration") {
+			described.Errors[i] = msg + " (durations need a unit, such as 90s, 5m or 1h30m)"
+		}
+	}
+	return described
+}
+
+// setValue sets key in the mapping node root to value, replacing any value
+// already there. The value's type is resolved as if it appeared in YAML.
+func setValue(root *yaml.Node, key, value string) {
+	node := &yaml.Node{Kind: yaml.ScalarNode, Value: value}
+	if _, j := mappingValue(root, key); j >= 0 {
+		root.Content[j] = node
+		return
+	}
+	root.Content = append(root.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, node)
+}
+
+// readSecretFile sets *field from the contents of file, if file is set.
+// Relative paths are taken relative to dir (the config file's directory)
+// and surrounding whitespace, such as a trailing newline, is trimmed. An
+// age-encrypted file is decrypted with config.ageKeyFile.
+// Setting both the secret and its file is an error. The path read is
+// recorded in config.secretFiles so the files can be watched for changes.
+func readSecretFile(config *Config, field *string, file, dir, name string) error {
+	if file == "" {
+		return nil
+	}
+	if *field != "" {
+		return fmt.Errorf("%s and %s_file are mutually exclusive", name, name)
+	}
+	if !filepath.IsAbs(file) {
+		file = filepath.Join(dir, file)
+	}
+	config.secretFiles = append(config.secretFiles, file)
+	data, err := os.ReadFile(file)
+	if err == nil && isAgeEncrypted(data) {
+		data, err = decryptAge(file, config.ageKeyFile)
+	}
+	if err != nil {
+		return fmt.Errorf("%s_file: %w", name, err)
+	}
+	*field = strings.TrimSpace(string(data))
+	if *field == "" {
+		return fmt.Errorf("%s_file: %s is empty", name, file)
+	}
+	return nil
+}
+
+// readSecretFiles fills in every secret configured through a *_file
+// setting, and the API keys configured through a *_keyring setting. It runs
+// on every config load, so a rotated secret is picked up on restart or
+// reload.
+func readSecretFiles(config *Config, dir string) error {
+	if err := readSecretFile(config, &config.DreamhostAPIKey, config.DreamhostAPIKeyFile, dir, "dreamhost_api_key"); err != nil {
+		return err
+	}
+	if err := readKeyringSecret(&config.DreamhostAPIKey, config.DreamhostAPIKeyKeyring, config.DreamhostAPIKeyFile, "dreamhost_api_key"); err != nil {
+		return err
+	}
+	for name, pc := range config.Providers {
+		if err := readSecretFile(config, &pc.APIKey, pc.APIKeyFile, dir, "api_key"); err != nil {
+			return fmt.Errorf("provider %q: %w", name, err)
+		}
+		if err := readKeyringSecret(&pc.APIKey, pc.APIKeyKeyring, pc.APIKeyFile, "api_key"); err != nil {
+			return fmt.Errorf("provider %q: %w", name, err)
+		}
+		config.Providers[name] = pc
+	}
+	if err := readSecretFile(config, &config.Webhook.Token, config.Webhook.TokenFile, dir, "token"); err != nil {
+		return fmt.Errorf("webhook: %w", err)
+	}
+	for i := range config.Dyndns2.Users {
+		user := &config.Dyndns2.Users[i]
+		if err := readSecretFile(config, &user.Password, user.PasswordFile, dir, "password"); err != nil {
+			return fmt.Errorf("dyndns2: user %q: %w", user.Username, err)
+		}
+	}
+	for i := range config.RFC2136.Keys {
+		key := &config.RFC2136.Keys[i]
+		if err := readSecretFile(config, &key.Secret, key.SecretFile, dir, "secret"); err != nil {
+			return fmt.Errorf("rfc2136: key %q: %w", key.Name, err)
+		}
+	}
+	if err := readSecretFile(config, &config.Redis.Password, config.Redis.PasswordFile, dir, "password"); err != nil {
+		return fmt.Errorf("redis: %w", err)
+	}
+	if err := readSecretFile(config, &config.Etcd.Password, config.Etcd.PasswordFile, dir, "password"); err != nil {
+		return fmt.Errorf("etcd: %w", err)
+	}
+	if err := readSecretFile(config, &config.Consul.Token, config.Consul.TokenFile, dir, "token"); err != nil {
+		return fmt.Errorf("consul: %w", err)
+	}
+	for i := range config.Notifications.Slack {
+		s := &config.Notifications.Slack[i]
+		if err := readSecretFile(config, &s.URL, s.URLFile, dir, "url"); err != nil {
+			return fmt.Errorf("notifications: slack %d: %w", i, err)
+		}
+	}
+	for i := range config.Notifications.Discord {
+		d := &config.Notifications.Discord[i]
+		if err := readSecretFile(config, &d.URL, d.URLFile, dir, "url"); err != nil {
+			return fmt.Errorf("notifications: discord %d: %w", i, err)
+		}
+	}
+	if err := readSecretFile(config, &config.Heartbeat.URL, config.Heartbeat.URLFile, dir, "url"); err != nil {
+		return fmt.Errorf("heartbeat: %w", err)
+	}
+	if err := readSecretFile(config, &config.GeoIP.Token, config.GeoIP.TokenFile, dir, "token"); err != nil {
+		return fmt.Errorf("geoip: %w", err)
+	}
+	for i := range config.Notifications.Ntfy {
+		n := &config.Notifications.Ntfy[i]
+		if err := readSecretFile(config, &n.Token, n.TokenFile, dir, "token"); err != nil {
+			return fmt.Errorf("notifications: ntfy %d: %w", i, err)
+		}
+	}
+	for i := range config.Notifications.Gotify {
+		g := &config.Notifications.Gotify[i]
+		if err := readSecretFile(config, &g.Token, g.TokenFile, dir, "token"); err != nil {
+			return fmt.Errorf("notifications: gotify %d: %w", i, err)
+		}
+	}
+	for i := range config.Notifications.Pushover {
+		p := &config.Notifications.Pushover[i]
+		if err := readSecretFile(config, &p.Token, p.TokenFile, dir, "token"); err != nil {
+			return fmt.Errorf("notifications: pushover %d: %w", i, err)
+		}
+		if err := readSecretFile(config, &p.UserKey, p.UserKeyFile, dir, "user_key"); err != nil {
+			return fmt.Errorf("notifications: pushover %d: %w", i, err)
+		}
+	}
+	for i := range config.Notifications.MQTT {
+		m := &config.Notifications.MQTT[i]
+		if err := readSecretFile(config, &m.Password, m.PasswordFile, dir, "password"); err != nil {
+			return fmt.Errorf("notifications: mqtt %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// envName converts a provider, user or key name into the suffix of an
+// override variable: upper case, with anything other than letters and
+// digits replaced by underscores. A trailing dot, as in TSIG key names, is
+// dropped.
+func envName(name string) string {
+	name = strings.TrimSuffix(name, ".")
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// applyEnvOverrides replaces secrets in the config with the values of
+// their override variables, so the file itself can be kept free of them:
+//
+//	DREAMHOST_API_KEY                 dreamhost_api_key
+//	DREAMHOST_API_KEY_<PROVIDER>      providers.<provider>.api_key
+//	DH_DDNS_WEBHOOK_TOKEN             webhook.token
+//	DH_DDNS_DYNDNS2_PASSWORD_<USER>   dyndns2.users[].password
+//	DH_DDNS_TSIG_SECRET_<KEY>         rfc2136.keys[].secret
+//	DH_DDNS_REDIS_PASSWORD            redis.password
+//	DH_DDNS_ETCD_PASSWORD             etcd.password
+//	DH_DDNS_CONSUL_TOKEN              consul.token
+//
+// Empty variables are ignored, so an unset value in a container
+// environment doesn't blank out the one in the file.
+func applyEnvOverrides(config *Config, lookup func(string) (string, bool)) {
+	override := func(field *string, name string) {
+		if value, ok := lookup(name); ok && value != "" {
+			*field = value
+		}
+	}
+
+	override(&config.DreamhostAPIKey, "DREAMHOST_API_KEY")
+	for name, pc := range config.Providers {
+		override(&pc.APIKey, "DREAMHOST_API_KEY_"+envName(name))
+		config.Providers[name] = pc
+	}
+	override(&config.Webhook.Token, "DH_DDNS_WEBHOOK_TOKEN")
+	for i := range config.Dyndns2.Users {
+		user := &config.Dyndns2.Users[i]
+		override(&user.Password, "DH_DDNS_DYNDNS2_PASSWORD_"+envName(user.Username))
+	}
+	for i := range config.RFC2136.Keys {
+		key := &config.RFC2136.Keys[i]
+		override(&key.Secret, "DH_DDNS_TSIG_SECRET_"+envName(key.Name))
+	}
+	override(&config.Redis.Password, "DH_DDNS_REDIS_PASSWORD")
+	override(&config.Etcd.Password, "DH_DDNS_ETCD_PASSWORD")
+	override(&config.Consul.Token, "DH_DDNS_CONSUL_TOKEN")
+}
+
+// applyConfigDefaults fills in the settings left unset.
+func applyConfigDefaults(config *Config) {
+	applyKubernetesDefaults(config)
+	if config.CheckInterval == 0 {
+		config.CheckInterval = 5 * time.Minute
+	}
+	if config.PublishInterval == 0 {
+		config.PublishInterval = config.CheckInterval
+	}
+	if config.RetryInterval == 0 {
+		config.RetryInterval = time.Minute
+	}
+	if config.CycleTimeout == 0 {
+		config.CycleTimeout = 5 * time.Minute
+	}
+	if config.ShutdownGracePeriod == 0 {
+		config.ShutdownGracePeriod = DefaultShutdownGracePeriod
+	}
+	if config.MaxRetryInterval == 0 {
+		config.MaxRetryInterval = max(time.Hour, config.RetryInterval)
+	}
+	if config.MinCheckInterval == 0 {
+		config.MinCheckInterval = DefaultMinCheckInterval
+	}
+	if config.HistorySize == 0 {
+		config.HistorySize = DefaultHistorySize
+	}
+	if config.NegativeCacheTTL == 0 {
+		config.NegativeCacheTTL = 30 * time.Minute
+	}
+	if config.RepeatedErrorInterval == 0 {
+		config.RepeatedErrorInterval = DefaultRepeatedErrorInterval
+	}
+	config.HTTP.Timeout = cmp.Or(config.HTTP.Timeout, DefaultHTTPTimeout)
+	config.HTTP.DetectionTimeout = cmp.Or(config.HTTP.DetectionTimeout, config.HTTP.Timeout)
+	config.HTTP.ProviderTimeout = cmp.Or(config.HTTP.ProviderTimeout, config.HTTP.Timeout)
+	config.HTTP.DialTimeout = cmp.Or(config.HTTP.DialTimeout, DefaultDialTimeout)
+	config.HTTP.TLSHandshakeTimeout = cmp.Or(config.HTTP.TLSHandshakeTimeout, DefaultTLSHandshakeTimeout)
+	config.HTTP.MaxIdleConns = cmp.Or(config.HTTP.MaxIdleConns, DefaultMaxIdleConns)
+	config.HTTP.IdleConnTimeout = cmp.Or(config.HTTP.IdleConnTimeout, DefaultIdleConnTimeout)
+	if config.Leader.Enabled {
+		config.Leader.TTL = cmp.Or(config.Leader.TTL, DefaultLeaderTTL)
+		if config.Leader.ID == "" {
+			config.Leader.ID, _ = os.Hostname()
+		}
+	}
+	for _, r := range config.Notifications.routes() {
+		if len(r.route.Events) == 0 {
+			r.route.Events = defaultNotificationEvents
+		}
+		r.route.FailureThreshold = cmp.Or(r.route.FailureThreshold, 1)
+	}
+	for i := range config.Notifications.Ntfy {
+		n := &config.Notifications.Ntfy[i]
+		n.Server = cmp.Or(n.Server, DefaultNtfyServer)
+	}
+	for i := range config.Notifications.MQTT {
+		m := &config.Notifications.MQTT[i]
+		m.Topic = cmp.Or(m.Topic, DefaultMQTTTopic)
+	}
+	if config.Heartbeat.URL != "" {
+		config.Heartbeat.FailURL = cmp.Or(config.Heartbeat.FailURL, defaultFailURL(config.Heartbeat.URL))
+		config.Heartbeat.Interval = cmp.Or(config.Heartbeat.Interval, config.CheckInterval)
+	}
+	config.StatsD.Prefix = cmp.Or(config.StatsD.Prefix, DefaultStatsDPrefix)
+	if config.GeoIP.Source == "ipinfo" {
+		config.GeoIP.URL = cmp.Or(config.GeoIP.URL, DefaultGeoIPURL)
+	}
+	if config.DreamhostAPIBase == "" {
+		config.DreamhostAPIBase = DefaultDreamhostAPIBase
+	}
+	if config.LANDNS.TTL == 0 {
+		config.LANDNS.TTL = 60
+	}
+	if config.StateBackend == "" {
+		config.StateBackend = "json"
+	}
+	switch config.StateBackend {
+	case "redis":
+		config.Redis.Address = cmp.Or(config.Redis.Address, DefaultRedisAddress)
+		config.Redis.Key = cmp.Or(config.Redis.Key, DefaultRedisKey)
+	case "etcd":
+		config.Etcd.Endpoint = cmp.Or(config.Etcd.Endpoint, DefaultEtcdEndpoint)
+		config.Etcd.Key = cmp.Or(config.Etcd.Key, DefaultSharedStateKey)
+	case "consul":
+		config.Consul.Address = cmp.Or(config.Consul.Address, DefaultConsulAddress)
+		config.Consul.Key = cmp.Or(config.Consul.Key, DefaultSharedStateKey)
+	}
+	if config.StatePath == "" {
+		config.StatePath = DefaultStatePath
+		if config.StateBackend == "sqlite" {
+			config.StatePath = DefaultSQLiteStatePath
+		}
+	}
+	if config.LogLevel == "" {
+		config.LogLevel = "info"
+	}
+	if config.LogFile.Path != "" {
+		config.LogFile.MaxSizeMB = cmp.Or(config.LogFile.MaxSizeMB, DefaultLogMaxSizeMB)
+		config.LogFile.MaxBackups = cmp.Or(config.LogFile.MaxBackups, DefaultLogMaxBackups)
+	}
+	if config.Syslog.Address != "" {
+		config.Syslog.Facility = cmp.Or(config.Syslog.Facility, DefaultSyslogFacility)
+		config.Syslog.Tag = cmp.Or(config.Syslog.Tag, DefaultSyslogTag)
+	}
+	if config.Strict == nil {
+		strict := true
+		config.Strict = &strict
+	}
+	if config.WatchConfig == nil {
+		config.WatchConfig = new(bool)
+	}
+	for i := range config.Domains {
+		domain := &config.Domains[i]
+		if domain.Type == "" {
+			domain.Type = config.Defaults.Type
+		}
+		if domain.Provider == "" {
+			domain.Provider = cmp.Or(config.Defaults.Provider, DefaultProvider)
+		}
+	}
+}
+
+// configPosition is where a node was read from: a line of the config file
+// or, for nodes merged from conf_dir, of the named fragment.
+type configPosition struct {
+	File string // Fragment file, or "" for the config file
+	Line int    // 0 when the config wasn't parsed from a file
+}
+
+// position returns where the node at path, a sequence of mapping keys and
+// sequence indexes, or its deepest ancestor that exists was read from.
+func (c *Config) position(path ...any) configPosition {
+	node := c.doc
+	if node == nil {
+		return configPosition{}
+	}
+	pos := configPosition{Line: node.Line}
+	for _, p := range path {
+		var next *yaml.Node
+		switch p := p.(type) {
+		case string:
+			next, _ = mappingValue(node, p)
+		case int:
+			if node.Kind == yaml.SequenceNode && p < len(node.Content) {
+				next = node.Content[p]
+			}
+		}
+		if next == nil {
+			break
+		}
+		node, pos.Line = next, next.Line
+		if file, ok := c.fragments[next]; ok {
+			pos.File = file
+		}
+	}
+	return pos
+}
+
+// atPosition prefixes err with where it was found, if known.
+func atPosition(pos configPosition, err error) error {
+	switch {
+	case pos.Line == 0:
+		return err
+	case pos.File != "":
+		return fmt.Errorf("%s: line %d: %w", pos.File, pos.Line, err)
+	}
+	return fmt.Errorf("line %d: %w", pos.Line, err)
+}
@@ -0,0 +1,401 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestExpandEnv tests ${VAR} substitution, escaping and unset variables
+func TestExpandEnv(t *testing.T) {
+	env := map[string]string{"API_KEY": "secret", "EMPTY": ""}
+	lookup := func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	}
+
+	tests := []struct {
+		name      string
+		in        string
+		want      string
+		wantError bool
+	}{
+		{name: "plain", in: "no references", want: "no references"},
+		{name: "whole value", in: "${API_KEY}", want: "secret"},
+		{name: "embedded", in: "key-${API_KEY}-x", want: "key-secret-x"},
+		{name: "empty but set", in: "${EMPTY}", want: ""},
+		{name: "escaped", in: "$${API_KEY}", want: "${API_KEY}"},
+		{name: "bare dollar", in: "$API_KEY costs $5", want: "$API_KEY costs $5"},
+		{name: "unset", in: "${MISSING}", wantError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := expandEnv(tt.in, lookup)
+			if tt.wantError {
+				if err == nil {
+					t.Error("expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+// TestParseConfigEnv tests that environment references are expanded in values with their types preserved
+func TestParseConfigEnv(t *testing.T) {
+	t.Setenv("DDNS_API_KEY", "from-env")
+	t.Setenv("DDNS_INTERVAL", "2m")
+	t.Setenv("DDNS_TTL", "120")
+	t.Setenv("DDNS_INJECT", "x\nlog_level: debug")
+
+	config, err := parseConfig([]byte(`
+dreamhost_api_key: ${DDNS_API_KEY}
+check_interval: ${DDNS_INTERVAL}
+state_path: "${DDNS_INJECT}"
+lan_dns:
+  ttl: ${DDNS_TTL}
+`), configOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if config.DreamhostAPIKey != "from-env" {
+		t.Errorf("expected API key from environment, got %q", config.DreamhostAPIKey)
+	}
+	if config.CheckInterval != 2*time.Minute {
+		t.Errorf("expected check_interval 2m, got %v", config.CheckInterval)
+	}
+	if config.LANDNS.TTL != 120 {
+		t.Errorf("expected TTL 120, got %d", config.LANDNS.TTL)
+	}
+	if config.StatePath != "x\nlog_level: debug" || config.LogLevel != "" {
+		t.Errorf("expected variable to be taken literally, got state_path %q and log_level %q", config.StatePath, config.LogLevel)
+	}
+
+	if _, err := parseConfig([]byte("dreamhost_api_key: ${DDNS_UNSET_VARIABLE}\n"), configOptions{}); err == nil {
+		t.Error("expected error for unset variable")
+	}
+}
+
+// TestParseConfigProfiles tests selecting profiles and overlaying their keys on the top level
+func TestParseConfigProfiles(t *testing.T) {
+	data := []byte(`
+check_interval: 5m
+profile: home
+dreamhost_api_key: shared
+domains:
+  - {name: example.com, record: home, type: A}
+profiles:
+  home:
+    log_level: debug
+  travel-hotspot:
+    check_interval: 1m
+    webhook:
+      disable_polling: true
+    domains:
+      - {name: example.com, record: laptop, type: A}
+  broken:
+    dreamhost_api_key: ${DDNS_UNSET_VARIABLE}
+`)
+
+	tests := []struct {
+		name          string
+		profile       string
+		wantProfile   string
+		wantRecord    string
+		wantInterval  time.Duration
+		wantLogLevel  string
+		wantNoPolling bool
+		wantError     bool
+	}{
+		{name: "default profile", wantProfile: "home", wantRecord: "home", wantInterval: 5 * time.Minute, wantLogLevel: "debug"},
+		{name: "selected profile", profile: "travel-hotspot", wantProfile: "travel-hotspot", wantRecord: "laptop", wantInterval: time.Minute, wantNoPolling: true},
+		{name: "unknown profile", profile: "office", wantError: true},
+		{name: "unset variable in selected profile", profile: "broken", wantError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := parseConfig(data, configOptions{Profile: tt.profile})
+			if tt.wantError {
+				if err == nil {
+					t.Error("expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if config.Profile != tt.wantProfile {
+				t.Errorf("expected profile %q, got %q", tt.wantProfile, config.Profile)
+			}
+			if len(config.Domains) != 1 || config.Domains[0].Record != tt.wantRecord {
+				t.Errorf("expected only record %q, got %+v", tt.wantRecord, config.Domains)
+			}
+			if config.CheckInterval != tt.wantInterval {
+				t.Errorf("expected check_interval %v, got %v", tt.wantInterval, config.CheckInterval)
+			}
+			if config.LogLevel != tt.wantLogLevel {
+				t.Errorf("expected log_level %q, got %q", tt.wantLogLevel, config.LogLevel)
+			}
+			if config.Webhook.DisablePolling != tt.wantNoPolling {
+				t.Errorf("expected disable_polling %v, got %v", tt.wantNoPolling, config.Webhook.DisablePolling)
+			}
+			if config.DreamhostAPIKey != "shared" {
+				t.Errorf("expected unset keys to keep their top-level value, got %q", config.DreamhostAPIKey)
+			}
+		})
+	}
+}
+
+// TestApplyEnvOverrides tests that override variables take precedence over secrets in the file
+func TestApplyEnvOverrides(t *testing.T) {
+	env := map[string]string{
+		"DREAMHOST_API_KEY":                    "env-key",
+		"DREAMHOST_API_KEY_WORK_ACCOUNT":       "env-work-key",
+		"DH_DDNS_WEBHOOK_TOKEN":                "",
+		"DH_DDNS_DYNDNS2_PASSWORD_ROUTER":      "env-password",
+		"DH_DDNS_TSIG_SECRET_DHCP_EXAMPLE_COM": "ZW52",
+	}
+	lookup := func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	}
+
+	config := &Config{
+		DreamhostAPIKey: "file-key",
+		Providers: map[string]ProviderConfig{
+			"work-account": {APIKey: "file-work-key"},
+			"other":        {APIKey: "file-other-key"},
+		},
+		Webhook: WebhookConfig{Token: "file-token"},
+		Dyndns2: Dyndns2Config{Users: []Dyndns2User{{Username: "router", Password: "file-password"}}},
+		RFC2136: RFC2136Config{Keys: []TSIGKeyConfig{{Name: "dhcp.example.com.", Secret: "ZmlsZQ=="}}},
+	}
+	applyEnvOverrides(config, lookup)
+
+	for field, got := range map[string][2]string{
+		"dreamhost_api_key":     {config.DreamhostAPIKey, "env-key"},
+		"work-account api_key":  {config.Providers["work-account"].APIKey, "env-work-key"},
+		"other api_key":         {config.Providers["other"].APIKey, "file-other-key"},
+		"webhook token (empty)": {config.Webhook.Token, "file-token"},
+		"dyndns2 password":      {config.Dyndns2.Users[0].Password, "env-password"},
+		"rfc2136 secret":        {config.RFC2136.Keys[0].Secret, "ZW52"},
+	} {
+		if got[0] != got[1] {
+			t.Errorf("%s: expected %q, got %q", field, got[1], got[0])
+		}
+	}
+}
+
+// TestReadSecretFiles tests loading secrets from files, relative paths, and conflicts with inline values
+func TestReadSecretFiles(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "api_key"), []byte("file-key\n"), 0600)
+	os.WriteFile(filepath.Join(dir, "token"), []byte("file-token"), 0600)
+	os.WriteFile(filepath.Join(dir, "empty"), nil, 0600)
+
+	tests := []struct {
+		name      string
+		config    Config
+		wantKey   string
+		wantError bool
+	}{
+		{name: "relative path", config: Config{DreamhostAPIKeyFile: "api_key"}, wantKey: "file-key"},
+		{name: "absolute path", config: Config{DreamhostAPIKeyFile: filepath.Join(dir, "api_key")}, wantKey: "file-key"},
+		{name: "inline only", config: Config{DreamhostAPIKey: "inline"}, wantKey: "inline"},
+		{name: "both set", config: Config{DreamhostAPIKey: "inline", DreamhostAPIKeyFile: "api_key"}, wantError: true},
+		{name: "missing file", config: Config{DreamhostAPIKeyFile: "nope"}, wantError: true},
+		{name: "empty file", config: Config{DreamhostAPIKeyFile: "empty"}, wantError: true},
+		{name: "provider key", config: Config{Providers: map[string]ProviderConfig{"work": {APIKeyFile: "api_key"}}}},
+		{name: "webhook token", config: Config{Webhook: WebhookConfig{TokenFile: "token"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := tt.config
+			err := readSecretFiles(&config, dir)
+			if tt.wantError {
+				if err == nil {
+					t.Error("expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if config.DreamhostAPIKey != tt.wantKey {
+				t.Errorf("expected API key %q, got %q", tt.wantKey, config.DreamhostAPIKey)
+			}
+			if pc, ok := config.Providers["work"]; ok && pc.APIKey != "file-key" {
+				t.Errorf("expected provider key from file, got %q", pc.APIKey)
+			}
+			if config.Webhook.TokenFile != "" && config.Webhook.Token != "file-token" {
+				t.Errorf("expected webhook token from file, got %q", config.Webhook.Token)
+			}
+		})
+	}
+}
+
+// TestLoadConfigSecretPrecedence tests that override variables win over *_file secrets
+func TestLoadConfigSecretPrecedence(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "api_key"), []byte("file-key\n"), 0600)
+	path := filepath.Join(dir, "config.yaml")
+	os.WriteFile(path, []byte("dreamhost_api_key_file: api_key\n"), 0600)
+
+	config, err := loadConfig(path, configOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if config.DreamhostAPIKey != "file-key" {
+		t.Errorf("expected key from file, got %q", config.DreamhostAPIKey)
+	}
+
+	t.Setenv("DREAMHOST_API_KEY", "env-key")
+	if config, err = loadConfig(path, configOptions{}); err != nil || config.DreamhostAPIKey != "env-key" {
+		t.Errorf("expected key from environment, got %q (%v)", config.DreamhostAPIKey, err)
+	}
+}
+
+// TestParseConfigFormats tests that the same config reads identically as YAML, JSON and TOML
+func TestParseConfigFormats(t *testing.T) {
+	t.Setenv("DDNS_FORMAT_KEY", "from-env")
+	sources := map[string]string{
+		"yaml": `dreamhost_api_key: ${DDNS_FORMAT_KEY}
+check_interval: 2m
+lan_dns:
+  ttl: 30
+domains:
+  - name: example.com
+    record: home
+    type: A
+  - name: example.com
+    type: TXT
+    value: "v=spf1 -all"
+`,
+		"json": `{
+	"dreamhost_api_key": "${DDNS_FORMAT_KEY}",
+	"check_interval": "2m",
+	"lan_dns": {"ttl": 30},
+	"domains": [
+		{"name": "example.com", "record": "home", "type": "A"},
+		{"name": "example.com", "type": "TXT", "value": "v=spf1 -all"}
+	]
+}
+`,
+		"toml": `dreamhost_api_key = "${DDNS_FORMAT_KEY}"
+check_interval = "2m"
+lan_dns.ttl = 30
+
+[[domains]]
+name = "example.com"
+record = "home"
+type = "A"
+
+[[domains]]
+name = "example.com"
+type = "TXT"
+value = "v=spf1 -all"
+`,
+	}
+
+	var want *Config
+	for _, format := range []string{"yaml", "json", "toml"} {
+		config, err := parseConfig([]byte(sources[format]), configOptions{Format: format})
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		if config.DreamhostAPIKey != "from-env" || config.CheckInterval != 2*time.Minute || config.LANDNS.TTL != 30 || len(config.Domains) != 2 {
+			t.Errorf("%s: unexpected config %+v", format, config)
+		}
+		if want == nil {
+			want = config
+			continue
+		}
+		if !reflect.DeepEqual(config.Domains, want.Domains) {
+			t.Errorf("%s: expected domains %+v, got %+v", format, want.Domains, config.Domains)
+		}
+		// Validation errors point into the file whatever its format
+		if line := config.position("domains", 1).Line; line < 3 {
+			t.Errorf("%s: expected the second domain's line, got %d", format, line)
+		}
+	}
+}
+
+// TestConfigFormat tests format selection by flag and file extension
+func TestConfigFormat(t *testing.T) {
+	tests := []struct {
+		path, format string
+		want         string
+		wantError    bool
+	}{
+		{path: "config.yaml", want: "yaml"},
+		{path: "config.yml", want: "yaml"},
+		{path: "config", want: "yaml"},
+		{path: "config.JSON", want: "json"},
+		{path: "config.toml", want: "toml"},
+		{path: "config.conf", format: "toml", want: "toml"},
+		{path: "config.json", format: "yaml", want: "yaml"},
+		{path: "config.yaml", format: "ini", wantError: true},
+	}
+
+	for _, tt := range tests {
+		got, err := configFormat(tt.path, tt.format)
+		if (err != nil) != tt.wantError || got != tt.want {
+			t.Errorf("configFormat(%q, %q) = %q, %v; expected %q", tt.path, tt.format, got, err, tt.want)
+		}
+	}
+
+	if _, err := parseConfig([]byte("{\n  \"check_interval\": \"1m\",\n}\n"), configOptions{Format: "json"}); err == nil || !strings.Contains(err.Error(), "json: line 3") {
+		t.Errorf("expected a JSON syntax error on line 3, got %v", err)
+	}
+}
+
+// TestDomainDefaults tests that the defaults block fills in what records leave unset, after their group
+func TestDomainDefaults(t *testing.T) {
+	config, err := parseConfig([]byte(`dreamhost_api_key: key
+defaults:
+  type: AAAA
+  ttl: 600
+  provider: backup
+providers:
+  backup:
+    api_key: other
+groups:
+  office:
+    create: {ttl: 300}
+domains:
+  - name: example.com
+  - name: example.org
+    type: A
+    ttl: 60
+    provider: dreamhost
+  - name: example.net
+    group: office
+`), configOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	applyConfigDefaults(config)
+	if errs := validateConfig(config); len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	want := []string{"example.com AAAA 600 backup", "example.org A 60 dreamhost", "example.net AAAA 300 backup"}
+	for i, domain := range config.Domains {
+		if got := fmt.Sprintf("%s %s %d %s", domain.Name, domain.Type, domain.TTL, domain.Provider); got != want[i] {
+			t.Errorf("domain %d: expected %q, got %q", i, want[i], got)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ConsulConfig configures the Consul state backend, which lets several
+// instances share one state.
+type ConsulConfig struct {
+	Address   string `yaml:"address"`    // HTTP API URL (default "http://localhost:8500")
+	Key       string `yaml:"key"`        // KV key holding the state (default "dh-ddns-updater/state")
+	Token     string `yaml:"token"`      // ACL token, if ACLs are enabled
+	TokenFile string `yaml:"token_file"` // File holding token instead
+}
+
+// DefaultConsulAddress is the Consul API used unless the consul block
+// names another.
+const DefaultConsulAddress = "http://localhost:8500"
+
+// consulStore keeps the state in Consul's KV store. The revision is the
+// key's ModifyIndex, and a save is a check-and-set transaction on it.
+type consulStore struct {
+	config ConsulConfig
+	client *http.Client
+}
+
+// consulKV is a KV entry as the API returns it.
+type consulKV struct {
+	Value       string `json:"Value"`
+	ModifyIndex int64  `json:"ModifyIndex"`
+}
+
+func (s consulStore) get(ctx context.Context) ([]byte, int64, error) {
+	var entries []consulKV
+	found, err := s.call(ctx, http.MethodGet, "/v1/kv/"+consulKeyPath(s.config.Key), nil, &entries)
+	if err != nil || !found || len(entries) == 0 {
+		return nil, 0, err
+	}
+	data, err := base64.StdEncoding.DecodeString(entries[0].Value)
+	if err != nil {
+		return nil, 0, fmt.Errorf("consul: decoding %s: %w", s.config.Key, err)
+	}
+	return data, entries[0].ModifyIndex, nil
+}
+
+func (s consulStore) put(ctx context.Context, data []byte, revision int64) (bool, int64, error) {
+	// A check-and-set with index 0 only creates the key
+	ops := []map[string]any{{"KV": map[string]any{
+		"Verb":  "cas",
+		"Key":   s.config.Key,
+		"Value": base64.StdEncoding.EncodeToString(data),
+		"Index": revision,
+	}}}
+	var resp struct {
+		Results []struct {
+			KV consulKV `json:"KV"`
+		} `json:"Results"`
+	}
+	saved, err := s.call(ctx, http.MethodPut, "/v1/txn", ops, &resp)
+	if err != nil || !saved {
+		return false, 0, err
+	}
+	if len(resp.Results) != 1 {
+		return false, 0, fmt.Errorf("consul: unexpected transaction results %+v", resp.Results)
+	}
+	return true, resp.Results[0].KV.ModifyIndex, nil
+}
+
+// call makes an API request and decodes the response into out. It returns
+// false without an error when the API answers 404 (no such key) or 409 (a
+// transaction rolled back).
+func (s consulStore) call(ctx context.Context, method, path string, body, out any) (bool, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return false, err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(s.config.Address, "/")+path, reader)
+	if err != nil {
+		return false, err
+	}
+	if s.config.Token != "" {
+		req.Header.Set("X-Consul-Token", s.config.Token)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("consul: %w", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return false, fmt.Errorf("consul: %w", err)
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound, http.StatusConflict:
+		return false, nil
+	default:
+		return false, fmt.Errorf("consul: %s %s: %s", method, path, cmp.Or(strings.TrimSpace(string(data)), resp.Status))
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return false, fmt.Errorf("consul: %s %s: %w", method, path, err)
+	}
+	return true, nil
+}
+
+// consulKeyPath escapes each segment of a key for the KV endpoint's path.
+func consulKeyPath(key string) string {
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
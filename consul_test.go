@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeConsul is an in-memory stand-in for Consul's KV and transaction API,
+// requiring the given ACL token.
+type fakeConsul struct {
+	mu     sync.Mutex
+	index  int64
+	values map[string]consulKV
+	token  string
+}
+
+func (f *fakeConsul) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if r.Header.Get("X-Consul-Token") != f.token {
+		http.Error(w, "Permission denied", http.StatusForbidden)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/v1/kv/"):
+		kv, ok := f.values[strings.TrimPrefix(r.URL.Path, "/v1/kv/")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode([]consulKV{kv})
+	case r.Method == http.MethodPut && r.URL.Path == "/v1/txn":
+		var ops []struct {
+			KV struct {
+				Verb, Key, Value string
+				Index            int64
+			}
+		}
+		json.NewDecoder(r.Body).Decode(&ops)
+		op := ops[0].KV
+		if op.Verb != "cas" || f.values[op.Key].ModifyIndex != op.Index {
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(map[string]any{"Errors": []map[string]string{{"What": "failed to set key"}}})
+			return
+		}
+		f.index++
+		f.values[op.Key] = consulKV{Value: op.Value, ModifyIndex: f.index}
+		json.NewEncoder(w).Encode(map[string]any{"Results": []map[string]any{{"KV": map[string]any{"Key": op.Key, "ModifyIndex": f.index}}}})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// TestConsulStateStore tests sharing state through Consul's KV store, with an ACL token
+func TestConsulStateStore(t *testing.T) {
+	fake := &fakeConsul{values: make(map[string]consulKV), token: "secret"}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	config := ConsulConfig{Address: server.URL, Key: DefaultSharedStateKey, Token: "secret"}
+	testSharedStateStore(t, sharedStateStore{kv: consulStore{config: config, client: server.Client()}})
+
+	config.Token = "wrong"
+	_, err := sharedStateStore{kv: consulStore{config: config, client: server.Client()}}.Load()
+	if err == nil || !strings.Contains(err.Error(), "Permission denied") {
+		t.Errorf("expected a permission error, got %v", err)
+	}
+}
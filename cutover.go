@@ -0,0 +1,407 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// cutoverRecord is one record switched by a cutover.
+type cutoverRecord struct {
+	Record  string `json:"record"` // Fully qualified name
+	Type    string `json:"type"`
+	From    string `json:"from"`                  // Value before the cutover
+	To      string `json:"to"`                    // Value after it
+	TTL     int    `json:"ttl,omitempty"`         // TTL before the cutover, restored after it; 0 if unknown
+	Lowered int    `json:"lowered_ttl,omitempty"` // TTL held through the switch, if it was lowered
+}
+
+// domain returns the DomainConfig the provider layer uses for the record
+// while it is being switched.
+func (r cutoverRecord) domain() DomainConfig {
+	return DomainConfig{Name: r.Record, Type: r.Type, TTL: cmp.Or(r.Lowered, r.TTL)}
+}
+
+// restored returns the DomainConfig that gives the record its TTL from
+// before the cutover back.
+func (r cutoverRecord) restored() DomainConfig {
+	return DomainConfig{Name: r.Record, Type: r.Type, TTL: r.TTL}
+}
+
+// cutoverJournal is written before a cutover changes anything, so that it
+// can be rolled back with `cutover --rollback`.
+type cutoverJournal struct {
+	Provider  string          `json:"provider"`
+	StartedAt time.Time       `json:"started_at"`
+	Records   []cutoverRecord `json:"records"`
+}
+
+// cutoverJournalPath returns where the journal is kept, next to the state.
+func cutoverJournalPath(statePath string) string {
+	return filepath.Join(filepath.Dir(statePath), "cutover.json")
+}
+
+// cutoverType infers the record type from the new target: A or AAAA for
+// addresses, otherwise CNAME.
+func cutoverType(to string) string {
+	switch ip := net.ParseIP(to); {
+	case ip == nil:
+		return "CNAME"
+	case ip.To4() != nil:
+		return "A"
+	default:
+		return "AAAA"
+	}
+}
+
+// precheckCutover checks that every record currently holds its From value,
+// and notes its TTL. Records that already hold To are reported as done and
+// left out of the result, so an interrupted cutover can be re-run.
+func precheckCutover(ctx context.Context, provider Provider, records []cutoverRecord, out io.Writer) ([]cutoverRecord, error) {
+	var pending []cutoverRecord
+	var errs []error
+	for _, r := range records {
+		current, err := provider.GetRecords(ctx, r.domain())
+		switch {
+		case err != nil:
+			errs = append(errs, fmt.Errorf("%s: %w", r.Record, err))
+		case len(current) != 1:
+			errs = append(errs, fmt.Errorf("%s: found %d %s records, expected 1", r.Record, len(current), r.Type))
+		case recordValuesEqual(r.Type, current[0].Value, r.To):
+			fmt.Fprintf(out, "%s %s already points at %s\n", r.Record, r.Type, r.To)
+		case !recordValuesEqual(r.Type, current[0].Value, r.From):
+			errs = append(errs, fmt.Errorf("%s: holds %q, expected %q", r.Record, current[0].Value, r.From))
+		default:
+			r.TTL = current[0].TTL
+			pending = append(pending, r)
+		}
+	}
+	return pending, errors.Join(errs...)
+}
+
+// planTTLs marks the records whose TTL is above ttl to be lowered to it
+// before the switch, and returns how long to wait after lowering them: the
+// longest TTL lowered, for resolvers to let go of what they cached under it.
+func planTTLs(records []cutoverRecord, ttl int) time.Duration {
+	var wait int
+	for i, r := range records {
+		if ttl > 0 && r.TTL > ttl {
+			records[i].Lowered = ttl
+			wait = max(wait, r.TTL)
+		}
+	}
+	return time.Duration(wait) * time.Second
+}
+
+// lowerTTLs lowers the TTLs planTTLs marked, leaving the values alone.
+func lowerTTLs(ctx context.Context, provider Provider, records []cutoverRecord, out io.Writer) error {
+	for _, r := range records {
+		if r.Lowered == 0 {
+			continue
+		}
+		fmt.Fprintf(out, "Lowering the TTL of %s %s: %d -> %d\n", r.Record, r.Type, r.TTL, r.Lowered)
+		if err := provider.UpdateRecord(ctx, r.domain(), r.From, r.From); err != nil {
+			return fmt.Errorf("lowering the TTL of %s: %w", r.Record, err)
+		}
+	}
+	return nil
+}
+
+// restoreTTLs gives switched records back the TTL lowerTTLs took from them.
+func restoreTTLs(ctx context.Context, provider Provider, records []cutoverRecord, out io.Writer) error {
+	var errs []error
+	for _, r := range records {
+		if r.Lowered == 0 {
+			continue
+		}
+		fmt.Fprintf(out, "Restoring the TTL of %s %s: %d -> %d\n", r.Record, r.Type, r.Lowered, r.TTL)
+		if err := provider.UpdateRecord(ctx, r.restored(), r.To, r.To); err != nil {
+			errs = append(errs, fmt.Errorf("restoring the TTL of %s: %w", r.Record, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// sleepContext waits for d, or until ctx is done.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// checkReachable connects to target on each port, failing on the first
+// port that doesn't accept a connection.
+func checkReachable(ctx context.Context, dial func(ctx context.Context, network, addr string) (net.Conn, error), target string, ports []string) error {
+	for _, port := range ports {
+		addr := net.JoinHostPort(strings.TrimSuffix(target, "."), port)
+		conn, err := dial(ctx, "tcp", addr)
+		if err != nil {
+			return fmt.Errorf("%s is not reachable: %w", addr, err)
+		}
+		conn.Close()
+	}
+	return nil
+}
+
+// switchRecords moves each record from its From value to its To value and
+// verifies the change through the API. If any record fails, it and the
+// records already switched are moved back before the error is returned:
+// a failed update may have removed the old record without adding the new.
+func switchRecords(ctx context.Context, provider Provider, records []cutoverRecord, out io.Writer) error {
+	var switched []cutoverRecord
+	for _, r := range records {
+		fmt.Fprintf(out, "Switching %s %s: %s -> %s\n", r.Record, r.Type, r.From, r.To)
+		err := provider.UpdateRecord(ctx, r.domain(), r.From, r.To)
+		if err == nil {
+			var current []DNSRecord
+			if current, err = provider.GetRecords(ctx, r.domain()); err == nil {
+				err = checkVerifiedRecords(r.domain(), current, r.To)
+			}
+		}
+		if err != nil {
+			err = fmt.Errorf("switching %s: %w", r.Record, err)
+			fmt.Fprintf(out, "Switch failed, reverting %d record(s)\n", len(switched)+1)
+			if rerr := rollbackRecords(context.WithoutCancel(ctx), provider, append(switched, r), out); rerr != nil {
+				err = errors.Join(err, rerr)
+			}
+			return err
+		}
+		switched = append(switched, r)
+	}
+	return nil
+}
+
+// rollbackRecords moves records back to their From values, with the TTL
+// they had before the cutover. Records that already hold From only get a
+// lowered TTL back, and records found missing, removed by a switch that
+// failed to add the new value, are added back; records holding neither
+// value were changed by someone else and are reported rather than
+// overwritten.
+func rollbackRecords(ctx context.Context, provider Provider, records []cutoverRecord, out io.Writer) error {
+	var errs []error
+	for _, r := range records {
+		current, err := provider.GetRecords(ctx, r.domain())
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", r.Record, err))
+			continue
+		}
+		live := ""
+		if len(current) == 1 {
+			live = current[0].Value
+		}
+		switch {
+		case recordValuesEqual(r.Type, live, r.From):
+			fmt.Fprintf(out, "%s %s already points at %s\n", r.Record, r.Type, r.From)
+			if r.Lowered != 0 && current[0].TTL != r.TTL {
+				fmt.Fprintf(out, "Restoring the TTL of %s %s: %d -> %d\n", r.Record, r.Type, current[0].TTL, r.TTL)
+				if err := provider.UpdateRecord(ctx, r.restored(), r.From, r.From); err != nil {
+					errs = append(errs, fmt.Errorf("restoring the TTL of %s: %w", r.Record, err))
+				}
+			}
+		case len(current) == 0:
+			fmt.Fprintf(out, "Re-adding %s %s: %s\n", r.Record, r.Type, r.From)
+			if err := provider.UpdateRecord(ctx, r.restored(), "", r.From); err != nil {
+				errs = append(errs, fmt.Errorf("re-adding %s: %w", r.Record, err))
+			}
+		case len(current) == 1 && recordValuesEqual(r.Type, live, r.To):
+			fmt.Fprintf(out, "Reverting %s %s: %s -> %s\n", r.Record, r.Type, r.To, r.From)
+			if err := provider.UpdateRecord(ctx, r.restored(), r.To, r.From); err != nil {
+				errs = append(errs, fmt.Errorf("reverting %s: %w", r.Record, err))
+			}
+		default:
+			errs = append(errs, fmt.Errorf("%s: found %d records (%q), expected %q; not reverting", r.Record, len(current), live, r.To))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// stringList is a repeatable string flag.
+type stringList []string
+
+func (l *stringList) String() string     { return strings.Join(*l, ",") }
+func (l *stringList) Set(v string) error { *l = append(*l, v); return nil }
+
+// runCutoverCommand switches a set of records from an old target to a new
+// one, or rolls the last cutover back.
+//
+//	dh-ddns-updater cutover --from OLD --to NEW --record NAME [--record NAME...]
+//	    [--ports 443] [--ttl 60] [--provider name] [--dry-run] [--profile name]
+//	    [--timeout duration] [config]
+//	dh-ddns-updater cutover --rollback [--profile name] [--timeout duration] [config]
+func runCutoverCommand(args []string) int {
+	fs := flag.NewFlagSet("cutover", flag.ContinueOnError)
+	var records stringList
+	fs.Var(&records, "record", "fully qualified record to switch (repeatable)")
+	from := fs.String("from", "", "value the records hold now (the old target)")
+	to := fs.String("to", "", "value to switch them to (the new target)")
+	ports := fs.String("ports", "443", "comma-separated TCP ports the new target must accept connections on (empty skips the check)")
+	ttl := fs.Int("ttl", 60, "TTL in seconds to lower the records to, and wait out the old TTL, before switching, with providers that can set TTLs (0 skips lowering)")
+	providerName := fs.String("provider", DefaultProvider, "provider hosting the records")
+	dryRun := fs.Bool("dry-run", false, "run the checks without switching anything")
+	rollback := fs.Bool("rollback", false, "revert the last cutover")
+	opts := configFlags(fs)
+	timeout := timeoutFlag(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: dh-ddns-updater cutover --from OLD --to NEW --record NAME... [flags] [config]")
+		fmt.Fprintln(fs.Output(), "       dh-ddns-updater cutover --rollback [config]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if !*rollback && (*from == "" || *to == "" || len(records) == 0) {
+		fs.Usage()
+		return 2
+	}
+
+	updater, err := newCommandUpdater(commandConfigPath(fs), *opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to initialize updater: %v\n", err)
+		return 1
+	}
+	journalPath := cutoverJournalPath(updater.config.StatePath)
+
+	ctx, cancel := commandContext(*timeout)
+	defer cancel()
+
+	if *rollback {
+		data, err := os.ReadFile(journalPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "No cutover to roll back: %v\n", err)
+			return 1
+		}
+		var journal cutoverJournal
+		if err := json.Unmarshal(data, &journal); err != nil {
+			fmt.Fprintf(os.Stderr, "Reading %s: %v\n", journalPath, err)
+			return 1
+		}
+		provider, ok := updater.providers[journal.Provider]
+		if !ok {
+			fmt.Fprintf(os.Stderr, "Unknown provider %q\n", journal.Provider)
+			return 1
+		}
+		if err := rollbackRecords(ctx, provider, journal.Records, os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "Rollback failed: %v\n", err)
+			return 1
+		}
+		os.Remove(journalPath)
+		fmt.Println("Rollback complete")
+		return 0
+	}
+
+	provider, ok := updater.providers[*providerName]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown provider %q\n", *providerName)
+		return 1
+	}
+	recordType := cutoverType(*to)
+	var planned []cutoverRecord
+	for _, name := range records {
+		name = strings.TrimSuffix(strings.ToLower(name), ".")
+		planned = append(planned, cutoverRecord{Record: name, Type: recordType, From: *from, To: *to})
+		for _, domain := range updater.config.Domains {
+			if strings.EqualFold(domain.FQDN(), name) && domain.Type == recordType {
+				fmt.Fprintf(os.Stderr, "Warning: %s is managed by this config; update its value there too or the daemon will revert the cutover\n", name)
+			}
+		}
+	}
+
+	pending, err := precheckCutover(ctx, provider, planned, os.Stdout)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Pre-check failed: %v\n", err)
+		return 1
+	}
+	if *ports != "" {
+		var dialer net.Dialer
+		dialCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		err := checkReachable(dialCtx, dialer.DialContext, *to, strings.Split(*ports, ","))
+		cancel()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Pre-check failed: %v\n", err)
+			return 1
+		}
+		fmt.Printf("%s accepts connections on port(s) %s\n", *to, *ports)
+	}
+	var wait time.Duration
+	if provider.Capabilities().TTL {
+		wait = planTTLs(pending, *ttl)
+	}
+
+	if len(pending) == 0 {
+		fmt.Println("Nothing to switch")
+		return 0
+	}
+	if *dryRun {
+		for _, r := range pending {
+			if r.Lowered != 0 {
+				fmt.Printf("Would lower the TTL of %s %s: %d -> %d\n", r.Record, r.Type, r.TTL, r.Lowered)
+			}
+		}
+		if wait > 0 {
+			fmt.Printf("Would wait %s for resolvers to drop the old TTL\n", wait)
+		}
+		for _, r := range pending {
+			fmt.Printf("Would switch %s %s: %s -> %s\n", r.Record, r.Type, r.From, r.To)
+		}
+		return 0
+	}
+
+	// The journal keeps each pending record's TTL, for rollback to restore
+	journaled := make([]cutoverRecord, 0, len(planned))
+	for _, r := range planned {
+		for _, p := range pending {
+			if p.Record == r.Record {
+				r = p
+			}
+		}
+		journaled = append(journaled, r)
+	}
+	data, _ := json.MarshalIndent(cutoverJournal{Provider: *providerName, StartedAt: time.Now(), Records: journaled}, "", "  ")
+	if err := os.MkdirAll(filepath.Dir(journalPath), 0755); err != nil {
+		fmt.Fprintf(os.Stderr, "Writing cutover journal: %v\n", err)
+		return 1
+	}
+	if err := os.WriteFile(journalPath, data, 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "Writing cutover journal: %v\n", err)
+		return 1
+	}
+
+	if err := lowerTTLs(ctx, provider, pending, os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "Cutover failed: %v; restore the TTLs with: dh-ddns-updater cutover --rollback\n", err)
+		return 1
+	}
+	if wait > 0 {
+		fmt.Printf("Waiting %s for resolvers to drop the old TTL\n", wait)
+		if err := sleepContext(ctx, wait); err != nil {
+			fmt.Fprintf(os.Stderr, "Cutover interrupted: %v; restore the TTLs with: dh-ddns-updater cutover --rollback\n", err)
+			return 1
+		}
+	}
+	if err := switchRecords(ctx, provider, pending, os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "Cutover failed: %v\n", err)
+		if wait > 0 {
+			fmt.Fprintln(os.Stderr, "Restore the TTLs with: dh-ddns-updater cutover --rollback")
+		}
+		return 1
+	}
+	if err := restoreTTLs(ctx, provider, pending, os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "Cutover complete, but: %v\n", err)
+		return 1
+	}
+	fmt.Println("Cutover complete; revert with: dh-ddns-updater cutover --rollback")
+	return 0
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// failingUpdates wraps a provider and fails updates of one record.
+type failingUpdates struct {
+	Provider
+	record string
+}
+
+func (f failingUpdates) UpdateRecord(ctx context.Context, domain DomainConfig, current, value string) error {
+	if domain.FQDN() == f.record {
+		return errors.New("update rejected")
+	}
+	return f.Provider.UpdateRecord(ctx, domain, current, value)
+}
+
+// droppingUpdates wraps a provider and, like a Dreamhost update whose add
+// fails, removes the old record of one record without adding the new value.
+type droppingUpdates struct {
+	Provider
+	record, value string
+}
+
+func (f droppingUpdates) UpdateRecord(ctx context.Context, domain DomainConfig, current, value string) error {
+	if domain.FQDN() == f.record && value == f.value {
+		f.Provider.RemoveRecord(ctx, domain, current)
+		return errors.New("add rejected")
+	}
+	return f.Provider.UpdateRecord(ctx, domain, current, value)
+}
+
+// TestCutover tests pre-checks, switching, automatic reversal on failure, and rollback
+func TestCutover(t *testing.T) {
+	const oldIP, newIP = "203.0.113.10", "198.51.100.20"
+	newFake := func() *fakeDreamhost {
+		return newFakeDreamhost(
+			DNSRecord{Record: "www.example.com", Type: "A", Value: oldIP, Comment: ManagedComment},
+			DNSRecord{Record: "api.example.com", Type: "A", Value: oldIP, Comment: ManagedComment},
+			DNSRecord{Record: "other.example.com", Type: "A", Value: "192.0.2.1", Comment: ManagedComment},
+		)
+	}
+	records := func(names ...string) []cutoverRecord {
+		var rs []cutoverRecord
+		for _, name := range names {
+			rs = append(rs, cutoverRecord{Record: name, Type: cutoverType(newIP), From: oldIP, To: newIP})
+		}
+		return rs
+	}
+	var out bytes.Buffer
+	ctx := context.Background()
+
+	t.Run("pre-check rejects unexpected values", func(t *testing.T) {
+		fake := newFake()
+		provider := newPlanTestUpdater(t, fake, "").providers[DefaultProvider]
+		if _, err := precheckCutover(ctx, provider, records("www.example.com", "other.example.com", "missing.example.com"), &out); err == nil {
+			t.Error("expected pre-check to fail")
+		}
+	})
+
+	t.Run("switch and roll back", func(t *testing.T) {
+		fake := newFake()
+		provider := newPlanTestUpdater(t, fake, "").providers[DefaultProvider]
+		planned := records("www.example.com", "api.example.com")
+
+		pending, err := precheckCutover(ctx, provider, planned, &out)
+		if err != nil || len(pending) != 2 {
+			t.Fatalf("expected 2 pending records, got %d (%v)", len(pending), err)
+		}
+		if err := switchRecords(ctx, provider, pending, &out); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		for _, name := range []string{"www.example.com", "api.example.com"} {
+			if v := fake.value(name, "A"); v != newIP {
+				t.Errorf("%s: expected %s after cutover, got %s", name, newIP, v)
+			}
+		}
+
+		// Re-running finds nothing left to do
+		if pending, err := precheckCutover(ctx, provider, planned, &out); err != nil || len(pending) != 0 {
+			t.Errorf("expected nothing pending on re-run, got %d (%v)", len(pending), err)
+		}
+
+		if err := rollbackRecords(ctx, provider, planned, &out); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		for _, name := range []string{"www.example.com", "api.example.com"} {
+			if v := fake.value(name, "A"); v != oldIP {
+				t.Errorf("%s: expected %s after rollback, got %s", name, oldIP, v)
+			}
+		}
+	})
+
+	t.Run("failure reverts switched records", func(t *testing.T) {
+		fake := newFake()
+		provider := failingUpdates{Provider: newPlanTestUpdater(t, fake, "").providers[DefaultProvider], record: "api.example.com"}
+		if err := switchRecords(ctx, provider, records("www.example.com", "api.example.com"), &out); err == nil {
+			t.Fatal("expected error but got none")
+		}
+		if v := fake.value("www.example.com", "A"); v != oldIP {
+			t.Errorf("expected www.example.com to be reverted to %s, got %s", oldIP, v)
+		}
+	})
+
+	t.Run("failure restores a removed record", func(t *testing.T) {
+		fake := newFake()
+		provider := droppingUpdates{Provider: newPlanTestUpdater(t, fake, "").providers[DefaultProvider], record: "api.example.com", value: newIP}
+		if err := switchRecords(ctx, provider, records("www.example.com", "api.example.com"), &out); err == nil {
+			t.Fatal("expected error but got none")
+		}
+		for _, name := range []string{"www.example.com", "api.example.com"} {
+			if v := fake.value(name, "A"); v != oldIP {
+				t.Errorf("%s: expected %s after the failed switch, got %q", name, oldIP, v)
+			}
+		}
+	})
+}
+
+// ttlProvider is an in-memory provider that can set TTLs, holding one
+// record per name.
+type ttlProvider struct {
+	Provider
+	records map[string]DNSRecord
+}
+
+func (p *ttlProvider) GetRecords(ctx context.Context, domain DomainConfig) ([]DNSRecord, error) {
+	if r, ok := p.records[domain.FQDN()]; ok {
+		return []DNSRecord{r}, nil
+	}
+	return nil, nil
+}
+
+func (p *ttlProvider) UpdateRecord(ctx context.Context, domain DomainConfig, current, value string) error {
+	r := p.records[domain.FQDN()]
+	r.Value = value
+	if domain.TTL != 0 {
+		r.TTL = domain.TTL
+	}
+	p.records[domain.FQDN()] = r
+	return nil
+}
+
+func (p *ttlProvider) Capabilities() ProviderCapabilities {
+	return ProviderCapabilities{RecordTypes: []string{"A"}, TTL: true}
+}
+
+// TestCutoverTTLs tests lowering TTLs ahead of the switch and restoring them
+// after it or on rollback
+func TestCutoverTTLs(t *testing.T) {
+	const oldIP, newIP = "203.0.113.10", "198.51.100.20"
+	newProvider := func() *ttlProvider {
+		return &ttlProvider{records: map[string]DNSRecord{
+			"www.example.com": {Record: "www.example.com", Type: "A", Value: oldIP, TTL: 3600},
+			"api.example.com": {Record: "api.example.com", Type: "A", Value: oldIP, TTL: 300},
+			"low.example.com": {Record: "low.example.com", Type: "A", Value: oldIP, TTL: 30},
+		}}
+	}
+	planned := []cutoverRecord{
+		{Record: "www.example.com", Type: "A", From: oldIP, To: newIP},
+		{Record: "api.example.com", Type: "A", From: oldIP, To: newIP},
+		{Record: "low.example.com", Type: "A", From: oldIP, To: newIP},
+	}
+	var out bytes.Buffer
+	ctx := context.Background()
+
+	t.Run("lower, switch and restore", func(t *testing.T) {
+		provider := newProvider()
+		pending, err := precheckCutover(ctx, provider, planned, &out)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if wait := planTTLs(pending, 60); wait != time.Hour {
+			t.Errorf("expected to wait out the longest TTL lowered, 1h, got %s", wait)
+		}
+		if pending[2].Lowered != 0 {
+			t.Errorf("expected a TTL already below 60 to be left alone, got %d", pending[2].Lowered)
+		}
+
+		if err := lowerTTLs(ctx, provider, pending, &out); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		for name, want := range map[string]int{"www.example.com": 60, "api.example.com": 60, "low.example.com": 30} {
+			if r := provider.records[name]; r.TTL != want || r.Value != oldIP {
+				t.Errorf("%s: expected %s with TTL %d after lowering, got %s with %d", name, oldIP, want, r.Value, r.TTL)
+			}
+		}
+
+		if err := switchRecords(ctx, provider, pending, &out); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if r := provider.records["www.example.com"]; r.TTL != 60 || r.Value != newIP {
+			t.Errorf("expected the switch to keep the lowered TTL, got %s with %d", r.Value, r.TTL)
+		}
+		if err := restoreTTLs(ctx, provider, pending, &out); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		for name, want := range map[string]int{"www.example.com": 3600, "api.example.com": 300, "low.example.com": 30} {
+			if r := provider.records[name]; r.TTL != want || r.Value != newIP {
+				t.Errorf("%s: expected %s with TTL %d after restoring, got %s with %d", name, newIP, want, r.Value, r.TTL)
+			}
+		}
+	})
+
+	t.Run("rollback restores lowered TTLs", func(t *testing.T) {
+		provider := newProvider()
+		pending, _ := precheckCutover(ctx, provider, planned, &out)
+		planTTLs(pending, 60)
+		if err := lowerTTLs(ctx, provider, pending, &out); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		// Interrupted while waiting, with one record switched already
+		if err := switchRecords(ctx, provider, pending[:1], &out); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if err := rollbackRecords(ctx, provider, pending, &out); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		for name, want := range map[string]int{"www.example.com": 3600, "api.example.com": 300, "low.example.com": 30} {
+			if r := provider.records[name]; r.TTL != want || r.Value != oldIP {
+				t.Errorf("%s: expected %s with TTL %d after rollback, got %s with %d", name, oldIP, want, r.Value, r.TTL)
+			}
+		}
+	})
+
+	t.Run("no lowering", func(t *testing.T) {
+		pending, _ := precheckCutover(ctx, newProvider(), planned, &out)
+		if wait := planTTLs(pending, 0); wait != 0 || pending[0].Lowered != 0 {
+			t.Errorf("expected --ttl 0 to lower nothing, got wait %s", wait)
+		}
+	})
+}
+
+// TestCheckReachable tests the new-target connectivity pre-check
+func TestCheckReachable(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+	_, open, _ := net.SplitHostPort(ln.Addr().String())
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	closed, _ := net.Listen("tcp", "127.0.0.1:0")
+	_, closedPort, _ := net.SplitHostPort(closed.Addr().String())
+	closed.Close()
+
+	var d net.Dialer
+	if err := checkReachable(context.Background(), d.DialContext, "127.0.0.1", []string{open}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := checkReachable(context.Background(), d.DialContext, "127.0.0.1", []string{open, closedPort}); err == nil {
+		t.Error("expected error for a closed port")
+	}
+}
+
+// TestCutoverType tests record type inference from the new target
+func TestCutoverType(t *testing.T) {
+	for to, want := range map[string]string{"198.51.100.20": "A", "2001:db8::1": "AAAA", "new.example.net.": "CNAME"} {
+		if got := cutoverType(to); got != want {
+			t.Errorf("cutoverType(%q): expected %s, got %s", to, want, got)
+		}
+	}
+}
@@ -0,0 +1,533 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// D-Bus message types.
+const (
+	dbusMethodCall   = 1
+	dbusMethodReturn = 2
+	dbusError        = 3
+	dbusSignal       = 4
+)
+
+// dbusMaxMessage bounds the messages read from the bus. The signals and
+// replies the daemon subscribes to are far smaller.
+const dbusMaxMessage = 1 << 20
+
+// dbusMaxDepth bounds the nesting of the values decoded, as the
+// specification does for containers.
+const dbusMaxDepth = 64
+
+// dbusAuthTimeout bounds connecting and authenticating to the bus.
+const dbusAuthTimeout = 10 * time.Second
+
+var errDBusTruncated = errors.New("truncated D-Bus message")
+
+// dbusMessage is a D-Bus message with the header fields the daemon uses.
+type dbusMessage struct {
+	Type        byte
+	Serial      uint32
+	ReplySerial uint32
+	Path        string
+	Interface   string
+	Member      string
+	ErrorName   string
+	Destination string
+	Sender      string
+	Signature   string
+	Body        []any // Decoded as Signature describes; see dbusDecoder
+}
+
+// dbusConn is a connection to a D-Bus message bus. It implements the little
+// of the protocol needed to subscribe to signals: EXTERNAL authentication,
+// method calls with string arguments, and reading messages. There is no
+// D-Bus client in the standard library.
+type dbusConn struct {
+	conn   net.Conn
+	r      *bufio.Reader
+	serial uint32
+}
+
+// dbusSystemBusAddress returns the address of the system bus.
+func dbusSystemBusAddress() string {
+	return cmp.Or(os.Getenv("DBUS_SYSTEM_BUS_ADDRESS"), "unix:path=/var/run/dbus/system_bus_socket")
+}
+
+// parseDBusAddress returns the socket of the first unix transport in a
+// D-Bus server address, such as unix:path=/run/dbus/system_bus_socket. An
+// abstract socket is returned with a leading @, as net expects.
+func parseDBusAddress(address string) (string, error) {
+	for _, addr := range strings.Split(address, ";") {
+		transport, params, ok := strings.Cut(addr, ":")
+		if !ok || transport != "unix" {
+			continue
+		}
+		for _, param := range strings.Split(params, ",") {
+			key, value, _ := strings.Cut(param, "=")
+			switch key {
+			case "path":
+				return value, nil
+			case "abstract":
+				return "@" + value, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("no supported unix transport in D-Bus address %q", address)
+}
+
+// dialDBus connects to the bus at address, authenticates as the user
+// running the daemon and registers with the bus.
+func dialDBus(ctx context.Context, address string) (*dbusConn, error) {
+	socket, err := parseDBusAddress(address)
+	if err != nil {
+		return nil, err
+	}
+	dialer := net.Dialer{Timeout: dbusAuthTimeout}
+	conn, err := dialer.DialContext(ctx, "unix", socket)
+	if err != nil {
+		return nil, err
+	}
+	c := &dbusConn{conn: conn, r: bufio.NewReader(conn)}
+	if err := c.auth(); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("authenticating to D-Bus: %w", err)
+	}
+	if _, err := c.call("org.freedesktop.DBus", "/org/freedesktop/DBus", "org.freedesktop.DBus", "Hello"); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// auth authenticates with the EXTERNAL mechanism, by which the bus checks
+// the uid the connection's credentials carry.
+func (c *dbusConn) auth() error {
+	c.conn.SetDeadline(time.Now().Add(dbusAuthTimeout))
+	defer c.conn.SetDeadline(time.Time{})
+
+	uid := hex.EncodeToString([]byte(strconv.Itoa(os.Getuid())))
+	if _, err := fmt.Fprintf(c.conn, "\x00AUTH EXTERNAL %s\r\n", uid); err != nil {
+		return err
+	}
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(line, "OK ") {
+		return fmt.Errorf("rejected: %s", strings.TrimSpace(line))
+	}
+	_, err = io.WriteString(c.conn, "BEGIN\r\n")
+	return err
+}
+
+// Close closes the connection, which also ends a read in progress.
+func (c *dbusConn) Close() error {
+	return c.conn.Close()
+}
+
+// call calls a method with string arguments and returns its reply.
+// Messages other than the reply, such as signals, are dropped meanwhile.
+func (c *dbusConn) call(destination, path, iface, member string, args ...string) (*dbusMessage, error) {
+	c.serial++
+	msg := &dbusMessage{
+		Type:        dbusMethodCall,
+		Serial:      c.serial,
+		Path:        path,
+		Interface:   iface,
+		Member:      member,
+		Destination: destination,
+		Signature:   strings.Repeat("s", len(args)),
+	}
+	var body dbusEncoder
+	for _, arg := range args {
+		body.string(arg)
+	}
+	if _, err := c.conn.Write(msg.marshal(body.buf)); err != nil {
+		return nil, err
+	}
+	for {
+		reply, err := c.read()
+		if err != nil {
+			return nil, err
+		}
+		if reply.ReplySerial != msg.Serial || (reply.Type != dbusMethodReturn && reply.Type != dbusError) {
+			continue
+		}
+		if reply.Type == dbusError {
+			if len(reply.Body) > 0 {
+				return nil, fmt.Errorf("%s %s: %s: %v", iface, member, reply.ErrorName, reply.Body[0])
+			}
+			return nil, fmt.Errorf("%s %s: %s", iface, member, reply.ErrorName)
+		}
+		return reply, nil
+	}
+}
+
+// read reads the next message from the bus.
+func (c *dbusConn) read() (*dbusMessage, error) {
+	fixed := make([]byte, 16)
+	if _, err := io.ReadFull(c.r, fixed); err != nil {
+		return nil, err
+	}
+	var order binary.ByteOrder
+	switch fixed[0] {
+	case 'l':
+		order = binary.LittleEndian
+	case 'B':
+		order = binary.BigEndian
+	default:
+		return nil, fmt.Errorf("invalid D-Bus byte order %q", fixed[0])
+	}
+	bodyLen := order.Uint32(fixed[4:8])
+	fieldsLen := order.Uint32(fixed[12:16])
+	if bodyLen > dbusMaxMessage || fieldsLen > dbusMaxMessage {
+		return nil, fmt.Errorf("D-Bus message too large")
+	}
+	headerLen := (16 + int(fieldsLen) + 7) &^ 7
+	data := make([]byte, headerLen+int(bodyLen))
+	copy(data, fixed)
+	if _, err := io.ReadFull(c.r, data[16:]); err != nil {
+		return nil, err
+	}
+	return parseDBusMessage(data, order)
+}
+
+// parseDBusMessage parses a whole message, header and body.
+func parseDBusMessage(data []byte, order binary.ByteOrder) (*dbusMessage, error) {
+	msg := &dbusMessage{Type: data[1], Serial: order.Uint32(data[8:12])}
+	bodyLen := int(order.Uint32(data[4:8]))
+	header := &dbusDecoder{data: data[:len(data)-bodyLen], pos: 12, order: order}
+	fields, err := header.value("a(yv)")
+	if err != nil {
+		return nil, err
+	}
+	for _, field := range fields.([]any) {
+		f := field.([]any)
+		switch code, value := f[0].(byte), f[1]; code {
+		case 1:
+			msg.Path, _ = value.(string)
+		case 2:
+			msg.Interface, _ = value.(string)
+		case 3:
+			msg.Member, _ = value.(string)
+		case 4:
+			msg.ErrorName, _ = value.(string)
+		case 5:
+			msg.ReplySerial, _ = value.(uint32)
+		case 6:
+			msg.Destination, _ = value.(string)
+		case 7:
+			msg.Sender, _ = value.(string)
+		case 8:
+			msg.Signature, _ = value.(string)
+		}
+	}
+
+	types, err := splitDBusSignature(msg.Signature)
+	if err != nil {
+		return nil, err
+	}
+	body := &dbusDecoder{data: data[len(data)-bodyLen:], order: order}
+	for _, sig := range types {
+		v, err := body.value(sig)
+		if err != nil {
+			return nil, err
+		}
+		msg.Body = append(msg.Body, v)
+	}
+	return msg, nil
+}
+
+// marshal encodes the message, little-endian, with an encoded body.
+func (m *dbusMessage) marshal(body []byte) []byte {
+	e := &dbusEncoder{}
+	e.buf = append(e.buf, 'l', m.Type, 0, 1)
+	e.uint32(uint32(len(body)))
+	e.uint32(m.Serial)
+
+	lenAt := len(e.buf)
+	e.uint32(0)
+	start := len(e.buf)
+	field := func(code byte, sig, value string) {
+		if value == "" {
+			return
+		}
+		e.align(8)
+		e.buf = append(e.buf, code)
+		e.signature(sig)
+		if sig == "g" {
+			e.signature(value)
+		} else {
+			e.string(value)
+		}
+	}
+	field(1, "o", m.Path)
+	field(2, "s", m.Interface)
+	field(3, "s", m.Member)
+	field(4, "s", m.ErrorName)
+	if m.ReplySerial != 0 {
+		e.align(8)
+		e.buf = append(e.buf, 5)
+		e.signature("u")
+		e.uint32(m.ReplySerial)
+	}
+	field(6, "s", m.Destination)
+	field(7, "s", m.Sender)
+	field(8, "g", m.Signature)
+	binary.LittleEndian.PutUint32(e.buf[lenAt:], uint32(len(e.buf)-start))
+	e.align(8)
+	return append(e.buf, body...)
+}
+
+// dbusEncoder encodes values little-endian, aligned as D-Bus requires.
+type dbusEncoder struct {
+	buf []byte
+}
+
+func (e *dbusEncoder) align(n int) {
+	for len(e.buf)%n != 0 {
+		e.buf = append(e.buf, 0)
+	}
+}
+
+func (e *dbusEncoder) uint32(v uint32) {
+	e.align(4)
+	e.buf = binary.LittleEndian.AppendUint32(e.buf, v)
+}
+
+// string encodes a string or object path.
+func (e *dbusEncoder) string(s string) {
+	e.uint32(uint32(len(s)))
+	e.buf = append(append(e.buf, s...), 0)
+}
+
+func (e *dbusEncoder) signature(s string) {
+	e.buf = append(append(append(e.buf, byte(len(s))), s...), 0)
+}
+
+// dbusTypeLen returns the length of the single complete type sig starts
+// with.
+func dbusTypeLen(sig string) (int, error) {
+	if sig == "" {
+		return 0, errors.New("incomplete D-Bus signature")
+	}
+	switch sig[0] {
+	case 'a':
+		n, err := dbusTypeLen(sig[1:])
+		return n + 1, err
+	case '(', '{':
+		depth := 0
+		for i := range len(sig) {
+			switch sig[i] {
+			case '(', '{':
+				depth++
+			case ')', '}':
+				depth--
+			}
+			if depth == 0 {
+				return i + 1, nil
+			}
+		}
+		return 0, fmt.Errorf("unbalanced D-Bus signature %q", sig)
+	case 'y', 'b', 'n', 'q', 'i', 'u', 'x', 't', 'd', 'h', 's', 'o', 'g', 'v':
+		return 1, nil
+	}
+	return 0, fmt.Errorf("unsupported D-Bus type %q", sig[0])
+}
+
+// splitDBusSignature splits a signature into its complete types.
+func splitDBusSignature(sig string) ([]string, error) {
+	var types []string
+	for sig != "" {
+		n, err := dbusTypeLen(sig)
+		if err != nil {
+			return nil, err
+		}
+		types = append(types, sig[:n])
+		sig = sig[n:]
+	}
+	return types, nil
+}
+
+// dbusAlignment returns the alignment of values of a type.
+func dbusAlignment(t byte) int {
+	switch t {
+	case 'y', 'g', 'v':
+		return 1
+	case 'n', 'q':
+		return 2
+	case 'x', 't', 'd', '(', '{':
+		return 8
+	}
+	return 4
+}
+
+// dbusDecoder decodes the values of a message. Alignment is relative to
+// the start of data, which is where the message or its body starts.
+type dbusDecoder struct {
+	data  []byte
+	pos   int
+	order binary.ByteOrder
+	depth int
+}
+
+func (d *dbusDecoder) read(n int) ([]byte, error) {
+	if n < 0 || d.pos+n > len(d.data) {
+		return nil, errDBusTruncated
+	}
+	b := d.data[d.pos : d.pos+n]
+	d.pos += n
+	return b, nil
+}
+
+func (d *dbusDecoder) align(n int) error {
+	_, err := d.read((n - d.pos%n) % n)
+	return err
+}
+
+// value decodes a value of the single complete type sig. Integers decode
+// to the Go type of their size, strings, object paths and signatures to
+// strings, booleans to bool, variants to the value they hold, and arrays,
+// structs and dict entries to []any.
+func (d *dbusDecoder) value(sig string) (any, error) {
+	if d.depth++; d.depth > dbusMaxDepth {
+		return nil, errors.New("D-Bus value nested too deeply")
+	}
+	defer func() { d.depth-- }()
+
+	if err := d.align(dbusAlignment(sig[0])); err != nil {
+		return nil, err
+	}
+	switch sig[0] {
+	case 'y':
+		b, err := d.read(1)
+		if err != nil {
+			return nil, err
+		}
+		return b[0], nil
+	case 'n', 'q':
+		b, err := d.read(2)
+		if err != nil {
+			return nil, err
+		}
+		if sig[0] == 'n' {
+			return int16(d.order.Uint16(b)), nil
+		}
+		return d.order.Uint16(b), nil
+	case 'b', 'i', 'u', 'h':
+		b, err := d.read(4)
+		if err != nil {
+			return nil, err
+		}
+		v := d.order.Uint32(b)
+		switch sig[0] {
+		case 'b':
+			return v != 0, nil
+		case 'i':
+			return int32(v), nil
+		}
+		return v, nil
+	case 'x', 't', 'd':
+		b, err := d.read(8)
+		if err != nil {
+			return nil, err
+		}
+		v := d.order.Uint64(b)
+		switch sig[0] {
+		case 'x':
+			return int64(v), nil
+		case 'd':
+			return math.Float64frombits(v), nil
+		}
+		return v, nil
+	case 's', 'o':
+		b, err := d.read(4)
+		if err != nil {
+			return nil, err
+		}
+		return d.text(int(d.order.Uint32(b)))
+	case 'g':
+		b, err := d.read(1)
+		if err != nil {
+			return nil, err
+		}
+		return d.text(int(b[0]))
+	case 'v':
+		inner, err := d.value("g")
+		if err != nil {
+			return nil, err
+		}
+		s := inner.(string)
+		if n, err := dbusTypeLen(s); err != nil || n != len(s) {
+			return nil, fmt.Errorf("invalid D-Bus variant signature %q", s)
+		}
+		return d.value(s)
+	case 'a':
+		b, err := d.read(4)
+		if err != nil {
+			return nil, err
+		}
+		n := int(d.order.Uint32(b))
+		if err := d.align(dbusAlignment(sig[1])); err != nil {
+			return nil, err
+		}
+		end := d.pos + n
+		if n < 0 || end > len(d.data) {
+			return nil, errDBusTruncated
+		}
+		items := []any{}
+		for d.pos < end {
+			item, err := d.value(sig[1:])
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		return items, nil
+	case '(', '{':
+		types, err := splitDBusSignature(sig[1 : len(sig)-1])
+		if err != nil {
+			return nil, err
+		}
+		if len(types) == 0 {
+			return nil, errors.New("empty D-Bus struct")
+		}
+		fields := make([]any, 0, len(types))
+		for _, t := range types {
+			field, err := d.value(t)
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, field)
+		}
+		return fields, nil
+	}
+	return nil, fmt.Errorf("unsupported D-Bus type %q", sig[0])
+}
+
+// text reads a string of n bytes and its terminating nul.
+func (d *dbusDecoder) text(n int) (string, error) {
+	b, err := d.read(n + 1)
+	if err != nil {
+		return "", err
+	}
+	if b[n] != 0 {
+		return "", errors.New("unterminated D-Bus string")
+	}
+	return string(b[:n]), nil
+}
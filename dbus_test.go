@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+// TestParseDBusAddress tests finding the socket of a D-Bus server address
+func TestParseDBusAddress(t *testing.T) {
+	tests := []struct {
+		address, want string
+		wantErr       bool
+	}{
+		{"unix:path=/run/dbus/system_bus_socket", "/run/dbus/system_bus_socket", false},
+		{"unix:path=/tmp/bus,guid=0123", "/tmp/bus", false},
+		{"tcp:host=localhost,port=1234;unix:abstract=/tmp/dbus-x", "@/tmp/dbus-x", false},
+		{"tcp:host=localhost,port=1234", "", true},
+		{"", "", true},
+	}
+	for _, tt := range tests {
+		got, err := parseDBusAddress(tt.address)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("%q: expected %q (error %v), got %q, %v", tt.address, tt.want, tt.wantErr, got, err)
+		}
+	}
+}
+
+// encodePropertiesChanged encodes the body of a PropertiesChanged signal
+// with one changed property holding an object path.
+func encodePropertiesChanged(iface, property, path string) []byte {
+	var e dbusEncoder
+	e.string(iface)
+	e.uint32(0)
+	lenAt := len(e.buf) - 4
+	e.align(8)
+	start := len(e.buf)
+	e.align(8)
+	e.string(property)
+	e.signature("o")
+	e.string(path)
+	binary.LittleEndian.PutUint32(e.buf[lenAt:], uint32(len(e.buf)-start))
+	e.uint32(0) // No invalidated properties
+	return e.buf
+}
+
+// TestDBusMessageRoundTrip tests that a marshaled message parses back to
+// its header fields and body
+func TestDBusMessageRoundTrip(t *testing.T) {
+	msg := &dbusMessage{
+		Type:      dbusSignal,
+		Serial:    7,
+		Path:      nmPath,
+		Interface: "org.freedesktop.DBus.Properties",
+		Member:    "PropertiesChanged",
+		Sender:    ":1.5",
+		Signature: "sa{sv}as",
+	}
+	data := msg.marshal(encodePropertiesChanged(nmService, "PrimaryConnection", "/org/freedesktop/NetworkManager/ActiveConnection/3"))
+
+	got, err := parseDBusMessage(data, binary.LittleEndian)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	msg.Body = []any{
+		nmService,
+		[]any{[]any{"PrimaryConnection", "/org/freedesktop/NetworkManager/ActiveConnection/3"}},
+		[]any{},
+	}
+	if !reflect.DeepEqual(got, msg) {
+		t.Errorf("expected %+v, got %+v", msg, got)
+	}
+
+	reply := &dbusMessage{Type: dbusError, Serial: 8, ReplySerial: 2, ErrorName: "org.freedesktop.DBus.Error.AccessDenied", Signature: "s"}
+	var body dbusEncoder
+	body.string("denied")
+	if got, err = parseDBusMessage(reply.marshal(body.buf), binary.LittleEndian); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	reply.Body = []any{"denied"}
+	if !reflect.DeepEqual(got, reply) {
+		t.Errorf("expected %+v, got %+v", reply, got)
+	}
+}
+
+// TestDBusDecoderMalformed tests that malformed values are rejected rather
+// than read past the data
+func TestDBusDecoderMalformed(t *testing.T) {
+	tests := []struct {
+		name string
+		sig  string
+		data []byte
+	}{
+		{"truncated uint32", "u", []byte{1, 2}},
+		{"string past the end", "s", []byte{10, 0, 0, 0, 'a', 0}},
+		{"unterminated string", "s", []byte{1, 0, 0, 0, 'a', 'b'}},
+		{"array past the end", "ai", []byte{16, 0, 0, 0, 1, 0, 0, 0}},
+		{"variant of two types", "v", []byte{2, 'i', 'i', 0, 1, 0, 0, 0, 2, 0, 0, 0}},
+		{"empty struct", "a()", []byte{8, 0, 0, 0, 0, 0, 0, 0}},
+	}
+	for _, tt := range tests {
+		d := &dbusDecoder{data: tt.data, order: binary.LittleEndian}
+		if v, err := d.value(tt.sig); err == nil {
+			t.Errorf("%s: expected an error, got %v", tt.name, v)
+		}
+	}
+}
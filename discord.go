@@ -0,0 +1,152 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+)
+
+// DiscordNotificationConfig is a Discord channel webhook receiving events as
+// embeds.
+type DiscordNotificationConfig struct {
+	URL     string `yaml:"url"`      // Channel webhook URL, which is its credential
+	URLFile string `yaml:"url_file"` // File holding url instead
+	// Mention is put before the embed to ping someone, e.g. @here, <@USER_ID>
+	// or <@&ROLE_ID>.
+	Mention string `yaml:"mention"`
+
+	NotificationRoute `yaml:",inline"` // Which events are sent, and when
+}
+
+// Embed colors by event: green for a change, red for a failure, blue for a
+// recovery.
+var discordColors = map[string]int{
+	EventIPChange:     0x2ecc71,
+	EventUpdateFailed: 0xe74c3c,
+	EventRecovered:    0x3498db,
+}
+
+// discordDescriptionLimit is the most characters Discord takes in an embed
+// description.
+const discordDescriptionLimit = 4096
+
+type discordMessage struct {
+	Content         string              `json:"content,omitempty"`
+	Username        string              `json:"username"`
+	Embeds          []discordEmbed      `json:"embeds"`
+	AllowedMentions discordAllowMention `json:"allowed_mentions"`
+}
+
+type discordEmbed struct {
+	Title       string         `json:"title"`
+	Description string         `json:"description,omitempty"`
+	Color       int            `json:"color"`
+	Fields      []discordField `json:"fields,omitempty"`
+	Footer      *discordFooter `json:"footer,omitempty"`
+	Timestamp   string         `json:"timestamp,omitempty"`
+}
+
+type discordField struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline"`
+}
+
+type discordFooter struct {
+	Text string `json:"text"`
+}
+
+// discordAllowMention lets the mentions in content ping; Discord only pings
+// those it is told to parse.
+type discordAllowMention struct {
+	Parse []string `json:"parse"`
+}
+
+// validateDiscordNotification checks a Discord notification.
+func validateDiscordNotification(d DiscordNotificationConfig) error {
+	if !strings.HasPrefix(d.URL, "https://") {
+		return errors.New("url is not an https URL")
+	}
+	return d.NotificationRoute.validate()
+}
+
+// discordNotifier posts events to a Discord webhook as embeds.
+type discordNotifier DiscordNotificationConfig
+
+func (d discordNotifier) notify(ctx context.Context, client *http.Client, e Event) error {
+	body, err := json.Marshal(d.message(e))
+	if err != nil {
+		return err
+	}
+	return postNotification(ctx, client, d.URL, "application/json", body, nil)
+}
+
+// message returns the message of an event: an embed titled with the event,
+// the old and new IP as fields, and a line per record updated or failed.
+func (d discordNotifier) message(e Event) discordMessage {
+	embed := discordEmbed{Color: discordColors[e.Event]}
+	switch e.Event {
+	case EventIPChange:
+		embed.Title = "IP changed to " + e.NewIP
+		embed.Fields = []discordField{
+			{Name: "Old IP", Value: cmp.Or(e.OldIP, "unknown"), Inline: true},
+			{Name: "New IP", Value: e.NewIP, Inline: true},
+		}
+		if e.Geo != nil {
+			network := cmp.Or(e.Geo.String(), "unknown")
+			if e.Geo.Unexpected {
+				network = "⚠️ " + network + " (unexpected)"
+			}
+			embed.Fields = append(embed.Fields, discordField{Name: "Network", Value: network, Inline: true})
+		}
+	case EventUpdateFailed:
+		embed.Title = "Updating records at " + e.Provider + " failed"
+	case EventRecovered:
+		embed.Title = "Records at " + e.Provider + " are updating again"
+	}
+	if e.Provider != "" {
+		embed.Fields = append(embed.Fields, discordField{Name: "Provider", Value: e.Provider, Inline: true})
+	}
+
+	var lines []string
+	for _, record := range e.Records {
+		lines = append(lines, "✅ `"+record+"` updated")
+	}
+	for _, err := range e.Errors {
+		lines = append(lines, "❌ "+err)
+	}
+	embed.Description = truncateLines(lines, discordDescriptionLimit)
+	if e.Host != "" {
+		embed.Footer = &discordFooter{Text: e.Host}
+	}
+	if !e.Time.IsZero() {
+		embed.Timestamp = e.Time.UTC().Format("2006-01-02T15:04:05.000Z")
+	}
+
+	msg := discordMessage{
+		Content:         d.Mention,
+		Username:        "dh-ddns-updater",
+		Embeds:          []discordEmbed{embed},
+		AllowedMentions: discordAllowMention{Parse: []string{}},
+	}
+	if d.Mention != "" {
+		msg.AllowedMentions.Parse = []string{"users", "roles", "everyone"}
+	}
+	return msg
+}
+
+// truncateLines joins lines with newlines, leaving out as many of the last
+// lines as it takes to keep the text within limit characters and saying how
+// many were.
+func truncateLines(lines []string, limit int) string {
+	text := strings.Join(lines, "\n")
+	for n := len(lines) - 1; n >= 0 && utf8.RuneCountInString(text) > limit; n-- {
+		text = strings.TrimPrefix(strings.Join(lines[:n], "\n")+fmt.Sprintf("\n… and %d more", len(lines)-n), "\n")
+	}
+	return text
+}
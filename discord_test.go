@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestDiscordNotifier tests the embed posted to Discord for an IP change:
+// the old and new IP as fields and a line per record result
+func TestDiscordNotifier(t *testing.T) {
+	var got discordMessage
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	notifier := discordNotifier{URL: server.URL, Mention: "<@&1234>"}
+	e := Event{
+		Event:    EventIPChange,
+		Time:     time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC),
+		Host:     "router",
+		Provider: "dreamhost",
+		OldIP:    "198.51.100.1",
+		NewIP:    "203.0.113.42",
+		Records:  []string{"home.example.com A"},
+		Errors:   []string{"vpn.example.com: boom"},
+	}
+	if err := notifier.notify(context.Background(), server.Client(), e); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got.Content != "<@&1234>" || !slices.Contains(got.AllowedMentions.Parse, "roles") {
+		t.Errorf("expected the mention to ping, got %q allowing %v", got.Content, got.AllowedMentions.Parse)
+	}
+	if len(got.Embeds) != 1 {
+		t.Fatalf("expected 1 embed, got %d", len(got.Embeds))
+	}
+	embed := got.Embeds[0]
+	if embed.Title != "IP changed to 203.0.113.42" || embed.Color != discordColors[EventIPChange] {
+		t.Errorf("unexpected title or color: %q, %#x", embed.Title, embed.Color)
+	}
+	wantFields := []discordField{
+		{Name: "Old IP", Value: "198.51.100.1", Inline: true},
+		{Name: "New IP", Value: "203.0.113.42", Inline: true},
+		{Name: "Provider", Value: "dreamhost", Inline: true},
+	}
+	if !slices.Equal(embed.Fields, wantFields) {
+		t.Errorf("expected fields %v, got %v", wantFields, embed.Fields)
+	}
+	wantDescription := "✅ `home.example.com A` updated\n❌ vpn.example.com: boom"
+	if embed.Description != wantDescription {
+		t.Errorf("expected description %q, got %q", wantDescription, embed.Description)
+	}
+	if embed.Footer == nil || embed.Footer.Text != "router" || embed.Timestamp != "2026-10-16T12:00:00.000Z" {
+		t.Errorf("expected the host and time, got %+v, %q", embed.Footer, embed.Timestamp)
+	}
+
+	// Without a mention, nothing in the message pings
+	msg := discordNotifier{}.message(Event{Event: EventRecovered, Provider: "dreamhost"})
+	if msg.Content != "" || msg.AllowedMentions.Parse == nil || len(msg.AllowedMentions.Parse) != 0 {
+		t.Errorf("expected no mentions allowed, got %+v", msg.AllowedMentions)
+	}
+}
+
+// TestTruncateLines tests that lines past the limit are left out and counted
+func TestTruncateLines(t *testing.T) {
+	var lines []string
+	for i := range 5 {
+		lines = append(lines, fmt.Sprintf("line %d", i))
+	}
+	tests := []struct {
+		limit int
+		want  string
+	}{
+		{100, "line 0\nline 1\nline 2\nline 3\nline 4"},
+		{33, "line 0\nline 1\nline 2\n… and 2 more"},
+		{10, "… and 5 more"},
+	}
+	for _, tt := range tests {
+		if got := truncateLines(lines, tt.limit); got != tt.want {
+			t.Errorf("limit %d: expected %q, got %q", tt.limit, tt.want, got)
+		}
+	}
+}
+
+// TestValidateDiscordNotification tests the checks of Discord notifications
+func TestValidateDiscordNotification(t *testing.T) {
+	tests := []struct {
+		name    string
+		discord DiscordNotificationConfig
+		wantErr string
+	}{
+		{"valid", DiscordNotificationConfig{URL: "https://discord.com/api/webhooks/1/x", NotificationRoute: NotificationRoute{Events: notificationEvents}}, ""},
+		{"plain http", DiscordNotificationConfig{URL: "http://discord.com/api/webhooks/1/x"}, "not an https URL"},
+		{"unknown event", DiscordNotificationConfig{URL: "https://discord.com/api/webhooks/1/x", NotificationRoute: NotificationRoute{Events: []string{"failure"}}}, `unknown event "failure"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateDiscordNotification(tt.discord)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+)
+
+// doctorReport is what the doctor command found about one provider.
+type doctorReport struct {
+	Provider     string
+	Capabilities ProviderCapabilities
+	Zones        []string
+	AccessErr    error
+}
+
+// diagnose checks every provider's access to its zones and collects its
+// capabilities along with any configuration it won't honor.
+func (d *DDNSUpdater) diagnose(ctx context.Context) ([]doctorReport, []string) {
+	var reports []doctorReport
+	for _, name := range d.providerNames() {
+		report := doctorReport{Provider: name, Capabilities: d.providers[name].Capabilities()}
+		for _, domain := range d.providerDomains(name) {
+			if !slices.Contains(report.Zones, domain.Name) {
+				report.Zones = append(report.Zones, domain.Name)
+			}
+		}
+		if len(report.Zones) > 0 {
+			report.AccessErr = d.providers[name].CheckAccess(ctx, report.Zones)
+		}
+		reports = append(reports, report)
+	}
+	_, warnings := d.checkCapabilities()
+	return reports, warnings
+}
+
+// printDoctorReports writes a table of providers, their capabilities and
+// access, followed by the warnings.
+func printDoctorReports(w io.Writer, reports []doctorReport, warnings []string) {
+	yesNo := func(b bool) string {
+		if b {
+			return "yes"
+		}
+		return "no"
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "PROVIDER\tRECORD TYPES\tCOMMENTS\tTTL\tATOMIC UPDATE\tACCESS")
+	for _, r := range reports {
+		access := "ok"
+		switch {
+		case len(r.Zones) == 0:
+			access = "unused"
+		case r.AccessErr != nil:
+			access = "FAILED: " + r.AccessErr.Error()
+		}
+		c := r.Capabilities
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", r.Provider, strings.Join(c.RecordTypes, ","),
+			yesNo(c.Comments), yesNo(c.TTL), yesNo(c.AtomicUpdate), access)
+	}
+	tw.Flush()
+
+	for _, warning := range warnings {
+		fmt.Fprintf(w, "Warning: %s\n", warning)
+	}
+}
+
+// runDoctorCommand checks the configuration against what each provider can
+// do and whether its credentials work.
+//
+//	dh-ddns-updater doctor [--profile name] [--timeout duration] [config]
+func runDoctorCommand(args []string) int {
+	fs := flag.NewFlagSet("doctor", flag.ContinueOnError)
+	opts := configFlags(fs)
+	timeout := timeoutFlag(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: dh-ddns-updater doctor [--profile name] [--timeout duration] [config]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	updater, err := newCommandUpdater(commandConfigPath(fs), *opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Configuration problem: %v\n", err)
+		return 1
+	}
+
+	ctx, cancel := commandContext(*timeout)
+	defer cancel()
+
+	reports, warnings := updater.diagnose(ctx)
+	printDoctorReports(os.Stdout, reports, warnings)
+	for _, r := range reports {
+		if r.AccessErr != nil {
+			return 1
+		}
+	}
+	return 0
+}
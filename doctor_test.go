@@ -0,0 +1,33 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+)
+
+// TestDiagnose tests that the doctor report covers capabilities, access failures and unused providers
+func TestDiagnose(t *testing.T) {
+	fake := newFakeDreamhost(DNSRecord{Record: "www.example.com", Type: "A", Value: "203.0.113.1", Zone: "example.com"})
+	fake.badKeys["test-key"] = true
+	updater := newPlanTestUpdater(t, fake, "", DomainConfig{Name: "example.com", Record: "home", Type: "A"})
+
+	reports, warnings := updater.diagnose(context.Background())
+	if len(reports) != 1 || reports[0].AccessErr == nil {
+		t.Fatalf("expected one report with an access failure, got %+v", reports)
+	}
+
+	var out bytes.Buffer
+	printDoctorReports(&out, reports, warnings)
+	for _, want := range []string{"dreamhost", "A,AAAA,CAA,CNAME,MX,SRV,TXT", "FAILED"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("expected report to contain %q:\n%s", want, out.String())
+		}
+	}
+
+	updater.config.Domains = nil
+	if reports, _ := updater.diagnose(context.Background()); reports[0].AccessErr != nil || len(reports[0].Zones) != 0 {
+		t.Errorf("expected a provider without records to be skipped, got %+v", reports[0])
+	}
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// DreamhostResponse represents the JSON response from Dreamhost API
+type DreamhostResponse struct {
+	Result string `json:"result"` // "success" or "error"
+	Data   string `json:"data"`   // Response message or error details
+}
+
+// DreamhostProvider manages records through the Dreamhost DNS API.
+type DreamhostProvider struct {
+	apiKey     string
+	apiBase    string
+	syncNotes  bool // Append record notes to the managed comment
+	httpClient *http.Client
+	logger     *slog.Logger
+}
+
+// NewDreamhostProvider creates a provider for the Dreamhost account owning
+// apiKey, reached at apiBase (DefaultDreamhostAPIBase if empty).
+func NewDreamhostProvider(apiKey, apiBase string, syncNotes bool, httpClient *http.Client, logger *slog.Logger) *DreamhostProvider {
+	if apiBase == "" {
+		apiBase = DefaultDreamhostAPIBase
+	}
+	return &DreamhostProvider{
+		apiKey:     apiKey,
+		apiBase:    apiBase,
+		syncNotes:  syncNotes,
+		httpClient: httpClient,
+		logger:     logger,
+	}
+}
+
+// errDreamhostAPI is wrapped by errors the Dreamhost API reported itself,
+// as opposed to transport failures.
+var errDreamhostAPI = errors.New("dreamhost API error")
+
+// dreamhostError converts the data of a failed Dreamhost response into an
+// error, recognizing the API's rate-limit reply and wrapping the category
+// of the replies with a known cause.
+func dreamhostError(data string) error {
+	switch {
+	case data == "slow_down_bucko":
+		return fmt.Errorf("%w: %s", ErrRateLimited, data)
+	case data == "no_such_zone":
+		return fmt.Errorf("%w: %w: %s", errDreamhostAPI, ErrNoSuchZone, data)
+	case strings.Contains(data, "not_editable"):
+		return fmt.Errorf("%w: %w: %s", errDreamhostAPI, ErrNotEditable, data)
+	case strings.Contains(data, "key"), strings.Contains(data, "auth"), strings.Contains(data, "permission"):
+		return fmt.Errorf("%w: %w: %s", errDreamhostAPI, ErrUnauthorized, data)
+	}
+	return fmt.Errorf("%w: %s", errDreamhostAPI, data)
+}
+
+// dreamhostHTTPError converts an unexpected HTTP status into an error.
+func dreamhostHTTPError(status int) error {
+	if status == http.StatusUnauthorized || status == http.StatusForbidden {
+		return fmt.Errorf("%w: HTTP %d from Dreamhost API", ErrUnauthorized, status)
+	}
+	return fmt.Errorf("%w: HTTP %d from Dreamhost API", ErrUnavailable, status)
+}
+
+// listRecords fetches the DNS records in the account and passes each one to
+// visit. The Dreamhost API can't filter listings, so the response is
+// stream-decoded instead: only records the caller keeps are ever held in
+// memory, which matters for accounts with hundreds of records.
+func (p *DreamhostProvider) listRecords(ctx context.Context, visit func(DNSRecord)) error {
+	params := url.Values{}
+	params.Set("key", p.apiKey)
+	params.Set("cmd", "dns-list_records")
+	params.Set("format", "json")
+
+	apiURL := p.apiURL(params)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", apiURL, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return redactError(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return dreamhostHTTPError(resp.StatusCode)
+	}
+
+	result, message, err := decodeRecordListing(json.NewDecoder(resp.Body), visit)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrBadResponse, err)
+	}
+	if result != "success" {
+		return dreamhostError(message)
+	}
+	return nil
+}
+
+// decodeRecordListing decodes a dns-list_records response token by token.
+// data is a list of records on success and an error string otherwise; the
+// records are passed to visit as they are decoded.
+func decodeRecordListing(dec *json.Decoder, visit func(DNSRecord)) (result, message string, err error) {
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return "", "", fmt.Errorf("expected object, got %v (%v)", tok, err)
+	}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return "", "", err
+		}
+		switch tok {
+		case "result":
+			if err := dec.Decode(&result); err != nil {
+				return "", "", err
+			}
+		case "data":
+			tok, err := dec.Token()
+			if err != nil {
+				return "", "", err
+			}
+			switch v := tok.(type) {
+			case string:
+				message = v
+			case json.Delim:
+				if v != '[' {
+					return "", "", fmt.Errorf("unexpected data %v", v)
+				}
+				for dec.More() {
+					var record DNSRecord
+					if err := dec.Decode(&record); err != nil {
+						return "", "", fmt.Errorf("decoding record: %w", err)
+					}
+					visit(record)
+				}
+				if _, err := dec.Token(); err != nil { // Closing ]
+					return "", "", err
+				}
+			}
+		default:
+			var skip json.RawMessage
+			if err := dec.Decode(&skip); err != nil {
+				return "", "", err
+			}
+		}
+	}
+	return result, message, nil
+}
+
+// GetRecords fetches the current DNS records matching domain from Dreamhost.
+// Returns an empty slice if the record doesn't exist.
+func (p *DreamhostProvider) GetRecords(ctx context.Context, domain DomainConfig) ([]DNSRecord, error) {
+	return p.ListRecords(ctx, []DomainConfig{domain})
+}
+
+// ListRecords fetches the current DNS records matching any of domains from
+// Dreamhost with a single listing, keeping only those records.
+func (p *DreamhostProvider) ListRecords(ctx context.Context, domains []DomainConfig) ([]DNSRecord, error) {
+	wanted := make(map[string]bool, len(domains))
+	for _, domain := range domains {
+		wanted[stateKey(domain.FQDN(), domain.Type)] = true
+	}
+	var matches []DNSRecord
+	err := p.listRecords(ctx, func(record DNSRecord) {
+		if wanted[stateKey(record.Record, record.Type)] {
+			matches = append(matches, record)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return matches, nil
+}
+
+// Capabilities implements Provider. Dreamhost's API has no TTL control and
+// no in-place edit, so updates remove the old record and add the new one.
+func (p *DreamhostProvider) Capabilities() ProviderCapabilities {
+	return ProviderCapabilities{
+		RecordTypes: []string{"A", "AAAA", "CAA", "CNAME", "MX", "SRV", "TXT"},
+		Comments:    true,
+	}
+}
+
+// CheckAccess lists the account's records once, which fails if the API key
+// is invalid or lacks DNS permissions, and checks that every zone has
+// records in the account.
+func (p *DreamhostProvider) CheckAccess(ctx context.Context, zones []string) error {
+	hosted := make(map[string]bool)
+	err := p.listRecords(ctx, func(record DNSRecord) {
+		hosted[strings.ToLower(record.Zone)] = true
+	})
+	if err != nil {
+		if errors.Is(err, ErrRateLimited) || !errors.Is(err, errDreamhostAPI) {
+			return err
+		}
+		return fmt.Errorf("%w: API key rejected (check it has dns-list_records, dns-add_record and dns-remove_record permissions): %w", ErrMisconfigured, err)
+	}
+
+	var missing []string
+	for _, zone := range zones {
+		if !hosted[strings.ToLower(zone)] {
+			missing = append(missing, zone)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%w (%w): domains not found in the Dreamhost account: %s", ErrMisconfigured, ErrNoSuchZone, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// UpdateRecord updates a single DNS record via the Dreamhost API.
+// It first removes the existing record holding current, if known, then adds
+// a new record with the desired value. Dreamhost has no in-place update, and
+// removal needs the old value, so a brand-new record is simply added.
+func (p *DreamhostProvider) UpdateRecord(ctx context.Context, domain DomainConfig, current, value string) error {
+	if current != "" {
+		if err := p.RemoveRecord(ctx, domain, current); err != nil {
+			p.logger.WarnContext(ctx, "Failed to remove existing record",
+				"domain", domain.Name, "record", domain.Record, "value", current, "error", err)
+		}
+	}
+
+	// Add new record
+	params := p.addRecordParams(domain, value)
+
+	apiURL := p.apiURL(params)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", apiURL, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return redactError(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return dreamhostHTTPError(resp.StatusCode)
+	}
+
+	var dhResp DreamhostResponse
+	if err := json.NewDecoder(resp.Body).Decode(&dhResp); err != nil {
+		return fmt.Errorf("%w: %w", ErrBadResponse, err)
+	}
+
+	if dhResp.Result != "success" {
+		return dreamhostError(dhResp.Data)
+	}
+
+	return nil
+}
+
+// apiURL returns the URL of an API call with the given parameters.
+func (p *DreamhostProvider) apiURL(params url.Values) string {
+	return strings.TrimSuffix(p.apiBase, "/") + "/?" + params.Encode()
+}
+
+// addRecordParams builds the query parameters for a dns-add_record call.
+// Every record is tagged with the managed comment, followed by its group's
+// creation comment and, when notes syncing is enabled, the record's notes.
+func (p *DreamhostProvider) addRecordParams(domain DomainConfig, value string) url.Values {
+	params := url.Values{}
+	params.Set("key", p.apiKey)
+	params.Set("cmd", "dns-add_record")
+	params.Set("record", domain.FQDN())
+	params.Set("type", domain.Type)
+	params.Set("value", value)
+	params.Set("format", "json")
+
+	var extra []string
+	if domain.create.Comment != "" {
+		extra = append(extra, domain.create.Comment)
+	}
+	if p.syncNotes && domain.Notes != "" {
+		extra = append(extra, domain.Notes)
+	}
+	comment := ManagedComment
+	if len(extra) > 0 {
+		comment += ": " + strings.Join(extra, "; ")
+	}
+	params.Set("comment", comment)
+
+	return params
+}
+
+// RemoveRecord removes an existing DNS record via the Dreamhost API.
+// Dreamhost requires the value being removed; it is omitted if unknown.
+// A record that doesn't exist is already in the desired state, so
+// Dreamhost's no_such_record reply is treated as success.
+func (p *DreamhostProvider) RemoveRecord(ctx context.Context, domain DomainConfig, value string) error {
+	params := url.Values{}
+	params.Set("key", p.apiKey)
+	params.Set("cmd", "dns-remove_record")
+	params.Set("record", domain.FQDN())
+	params.Set("type", domain.Type)
+	params.Set("format", "json")
+
+	if value != "" {
+		params.Set("value", value)
+	}
+
+	apiURL := p.apiURL(params)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", apiURL, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return redactError(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return dreamhostHTTPError(resp.StatusCode)
+	}
+
+	var dhResp DreamhostResponse
+	if err := json.NewDecoder(resp.Body).Decode(&dhResp); err != nil {
+		return fmt.Errorf("%w: %w", ErrBadResponse, err)
+	}
+
+	if dhResp.Result != "success" {
+		if dhResp.Data == "no_such_record" {
+			p.logger.DebugContext(ctx, "Record to remove does not exist",
+				"domain", domain.Name, "record", domain.Record, "value", value)
+			return nil
+		}
+		return dreamhostError(dhResp.Data)
+	}
+
+	return nil
+}
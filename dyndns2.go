@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Dyndns2Config configures the inbound server speaking the dyndns2 protocol
+// used by the built-in DDNS clients of consumer routers.
+type Dyndns2Config struct {
+	Listen string        `yaml:"listen"` // HTTP listen address (e.g. ":8245"); empty disables the server
+	Users  []Dyndns2User `yaml:"users"`  // Accounts clients authenticate as
+}
+
+// Dyndns2User is an account allowed to update a set of managed hostnames.
+type Dyndns2User struct {
+	Username     string   `yaml:"username"`
+	Password     string   `yaml:"password"`
+	PasswordFile string   `yaml:"password_file"` // File holding password instead
+	Hostnames    []string `yaml:"hostnames"`     // Fully qualified names of managed A/AAAA records
+}
+
+// maxDyndns2Hostnames bounds how many hostnames one request may update.
+const maxDyndns2Hostnames = 20
+
+// recordSource is the desired-store source holding a value pushed for one
+// record by an inbound protocol. When present it overrides the shared
+// public IP for that record.
+func recordSource(fqdn, recordType string) string {
+	return "record:" + strings.ToLower(fqdn) + "/" + recordType
+}
+
+// validateDyndns2 checks that the server has accounts and that every
+// hostname they may update is a managed address record.
+func validateDyndns2(config *Config) error {
+	if config.Dyndns2.Listen == "" {
+		return nil
+	}
+	if len(config.Dyndns2.Users) == 0 {
+		return errors.New("dyndns2: at least one user is required")
+	}
+	for _, user := range config.Dyndns2.Users {
+		if user.Username == "" || user.Password == "" {
+			return errors.New("dyndns2: users require a username and password")
+		}
+		for _, hostname := range user.Hostnames {
+			if len(addressRecords(config, hostname)) == 0 {
+				return fmt.Errorf("dyndns2: user %s: %s is not a managed A or AAAA record", user.Username, hostname)
+			}
+		}
+	}
+	return nil
+}
+
+// addressRecords returns the configured A and AAAA records named fqdn.
+func addressRecords(config *Config, fqdn string) []DomainConfig {
+	var records []DomainConfig
+	for _, domain := range config.Domains {
+		if isAddressType(domain.Type) && domain.Value == "" && strings.EqualFold(domain.FQDN(), fqdn) {
+			records = append(records, domain)
+		}
+	}
+	return records
+}
+
+// seedPushedValues restores the last published value of every record
+// updated through dyndns2, so a restart doesn't revert those records to the
+// polled IP before the client pushes again.
+func (d *DDNSUpdater) seedPushedValues() {
+	for _, user := range d.config.Dyndns2.Users {
+		for _, hostname := range user.Hostnames {
+			for _, domain := range addressRecords(d.config, hostname) {
+				if value := d.state.Records[stateKey(domain.FQDN(), domain.Type)]; value != "" && ipMatchesType(value, domain.Type) {
+					d.desired.Set(recordSource(domain.FQDN(), domain.Type), value)
+				}
+			}
+		}
+	}
+}
+
+// ipMatchesType reports whether ip belongs in a record of the given address type.
+func ipMatchesType(ip, recordType string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	return (parsed.To4() != nil) == (recordType == "A")
+}
+
+// dyndns2Handler returns the handler serving /nic/update. Responses use
+// the protocol's return codes, one line per hostname: "good <ip>" when the
+// value changed, "nochg <ip>" when it didn't, "badauth", "nohost" for names
+// the user may not update, "notfqdn" for a missing hostname and "dnserr"
+// for an unusable address.
+func (d *DDNSUpdater) dyndns2Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/nic/update", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+
+		user := d.dyndns2User(r)
+		if user == nil {
+			d.logger.Warn("Rejected dyndns2 update with invalid credentials", "remote", r.RemoteAddr)
+			w.Header().Set("WWW-Authenticate", `Basic realm="dh-ddns-updater"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprintln(w, "badauth")
+			return
+		}
+
+		hostnames := strings.Split(r.URL.Query().Get("hostname"), ",")
+		if hostnames[0] == "" || len(hostnames) > maxDyndns2Hostnames {
+			fmt.Fprintln(w, "notfqdn")
+			return
+		}
+
+		myip := r.URL.Query().Get("myip")
+		if myip == "" {
+			myip, _, _ = net.SplitHostPort(r.RemoteAddr)
+		}
+		ip, err := validatePushedIP(myip)
+		if err != nil {
+			d.logger.Warn("Rejected dyndns2 update", "user", user.Username, "remote", r.RemoteAddr, "error", err)
+			fmt.Fprintln(w, "dnserr")
+			return
+		}
+		recordType := "AAAA"
+		if net.ParseIP(ip).To4() != nil {
+			recordType = "A"
+		}
+
+		for _, hostname := range hostnames {
+			hostname = strings.TrimSuffix(strings.TrimSpace(hostname), ".")
+			if !slices.ContainsFunc(user.Hostnames, func(h string) bool { return strings.EqualFold(h, hostname) }) {
+				fmt.Fprintln(w, "nohost")
+				continue
+			}
+
+			changed := false
+			found := false
+			for _, domain := range addressRecords(d.config, hostname) {
+				if domain.Type != recordType {
+					continue
+				}
+				found = true
+				if d.desired.Set(recordSource(domain.FQDN(), domain.Type), ip) {
+					changed = true
+				}
+			}
+			if !found {
+				fmt.Fprintln(w, "nohost")
+				continue
+			}
+
+			if changed {
+				d.logger.Info("IP pushed via dyndns2", "user", user.Username, "hostname", hostname, "ip", ip)
+				fmt.Fprintf(w, "good %s\n", ip)
+			} else {
+				fmt.Fprintf(w, "nochg %s\n", ip)
+			}
+		}
+	})
+	return mux
+}
+
+// dyndns2User returns the account matching the request's basic auth
+// credentials, or nil.
+func (d *DDNSUpdater) dyndns2User(r *http.Request) *Dyndns2User {
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		return nil
+	}
+	for i := range d.config.Dyndns2.Users {
+		user := &d.config.Dyndns2.Users[i]
+		if user.Username == username && subtle.ConstantTimeCompare([]byte(user.Password), []byte(password)) == 1 {
+			return user
+		}
+	}
+	return nil
+}
+
+// serveDyndns2 runs the dyndns2 server until the context is cancelled.
+func (d *DDNSUpdater) serveDyndns2(ctx context.Context, addr string) error {
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           d.dyndns2Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	d.logger.Info("dyndns2 server listening", "address", addr)
+	return serveHTTP(ctx, server)
+}
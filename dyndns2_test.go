@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestDyndns2Handler tests authentication, hostname authorization and return codes
+func TestDyndns2Handler(t *testing.T) {
+	fake := newFakeDreamhost()
+	d := newPlanTestUpdater(t, fake, "198.51.100.1",
+		DomainConfig{Name: "example.com", Record: "home", Type: "A"},
+		DomainConfig{Name: "example.com", Record: "office", Type: "A"},
+	)
+	d.config.Dyndns2 = Dyndns2Config{Users: []Dyndns2User{
+		{Username: "router", Password: "s3cret", Hostnames: []string{"home.example.com"}},
+	}}
+	server := httptest.NewServer(d.dyndns2Handler())
+	defer server.Close()
+
+	tests := []struct {
+		name     string
+		user     string
+		password string
+		query    string
+		want     string
+	}{
+		{name: "bad password", user: "router", password: "guess", query: "hostname=home.example.com&myip=203.0.113.42", want: "badauth"},
+		{name: "missing hostname", user: "router", password: "s3cret", query: "myip=203.0.113.42", want: "notfqdn"},
+		{name: "not allowed", user: "router", password: "s3cret", query: "hostname=office.example.com&myip=203.0.113.42", want: "nohost"},
+		{name: "private IP", user: "router", password: "s3cret", query: "hostname=home.example.com&myip=192.168.1.1", want: "dnserr"},
+		{name: "no AAAA record", user: "router", password: "s3cret", query: "hostname=home.example.com&myip=2001:db8::1", want: "nohost"},
+		{name: "update", user: "router", password: "s3cret", query: "hostname=home.example.com&myip=203.0.113.42", want: "good 203.0.113.42"},
+		{name: "repeat", user: "router", password: "s3cret", query: "hostname=HOME.example.com.&myip=203.0.113.42", want: "nochg 203.0.113.42"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest("GET", server.URL+"/nic/update?"+tt.query, nil)
+			req.SetBasicAuth(tt.user, tt.password)
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("request failed: %v", err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if got := strings.TrimSpace(string(body)); got != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+
+	// The pushed value applies to home only; office still follows the detected IP
+	plan, err := d.planAll(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := map[string]string{"home.example.com": "203.0.113.42", "office.example.com": "198.51.100.1"}
+	for _, a := range plan.Actions {
+		if a.Desired != want[a.Record] {
+			t.Errorf("%s: expected desired value %s, got %s", a.Record, want[a.Record], a.Desired)
+		}
+	}
+}
+
+// TestValidateDyndns2 tests that users may only update managed address records
+func TestValidateDyndns2(t *testing.T) {
+	domains := []DomainConfig{
+		{Name: "example.com", Record: "home", Type: "A"},
+		{Name: "example.com", Record: "_txt", Type: "TXT", Value: "x"},
+	}
+	tests := []struct {
+		name      string
+		users     []Dyndns2User
+		wantError bool
+	}{
+		{name: "valid", users: []Dyndns2User{{Username: "router", Password: "pw", Hostnames: []string{"home.example.com"}}}},
+		{name: "no users", wantError: true},
+		{name: "no password", users: []Dyndns2User{{Username: "router", Hostnames: []string{"home.example.com"}}}, wantError: true},
+		{name: "unmanaged name", users: []Dyndns2User{{Username: "router", Password: "pw", Hostnames: []string{"www.example.com"}}}, wantError: true},
+		{name: "non-address record", users: []Dyndns2User{{Username: "router", Password: "pw", Hostnames: []string{"_txt.example.com"}}}, wantError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &Config{Domains: domains, Dyndns2: Dyndns2Config{Listen: ":8245", Users: tt.users}}
+			err := validateDyndns2(config)
+			if tt.wantError && err == nil {
+				t.Error("expected error but got none")
+			}
+			if !tt.wantError && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
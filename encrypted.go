@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// The external tools that decrypt configs and secret files. Neither format
+// has a decoder in the standard library, and both tools are packaged by
+// every distribution that ships them.
+var (
+	sopsCommand = "sops"
+	ageCommand  = "age"
+)
+
+// secretToolTimeout bounds one run of sops, age or a keyring tool.
+const secretToolTimeout = 30 * time.Second
+
+// ageKeyFile returns the age identity file to decrypt with.
+func ageKeyFile(opts configOptions) string {
+	if opts.AgeKeyFile != "" {
+		return opts.AgeKeyFile
+	}
+	if file := os.Getenv("SOPS_AGE_KEY_FILE"); file != "" {
+		return file
+	}
+	return DefaultAgeKeyFile
+}
+
+// isSOPSEncrypted reports whether a parsed config was encrypted by SOPS,
+// which stores its metadata, including a MAC, under a top-level sops key.
+func isSOPSEncrypted(doc *yaml.Node) bool {
+	if len(doc.Content) == 0 {
+		return false
+	}
+	metadata, _ := mappingValue(doc.Content[0], "sops")
+	if metadata == nil {
+		return false
+	}
+	mac, _ := mappingValue(metadata, "mac")
+	return mac != nil
+}
+
+// isAgeEncrypted reports whether data is an age-encrypted file, binary or
+// armored.
+func isAgeEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, []byte("age-encryption.org/v1\n")) ||
+		bytes.HasPrefix(bytes.TrimSpace(data), []byte("-----BEGIN AGE ENCRYPTED FILE-----"))
+}
+
+// decryptSOPS decrypts the SOPS-encrypted config at path, in the given
+// format, with the age identity in keyFile.
+func decryptSOPS(path, format, keyFile string) ([]byte, error) {
+	if format == "toml" {
+		return nil, errors.New("SOPS-encrypted configs must be YAML or JSON")
+	}
+	cmd := []string{sopsCommand, "--decrypt", "--input-type", format, "--output-type", format, path}
+	data, err := runSecretTool(cmd, "SOPS_AGE_KEY_FILE="+keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("decrypting SOPS-encrypted config with %s: %w", keyFile, err)
+	}
+	return data, nil
+}
+
+// decryptAge decrypts the age-encrypted file at path with the identity in
+// keyFile.
+func decryptAge(path, keyFile string) ([]byte, error) {
+	data, err := runSecretTool([]string{ageCommand, "--decrypt", "--identity", keyFile, path})
+	if err != nil {
+		return nil, fmt.Errorf("decrypting age-encrypted %s with %s: %w", path, keyFile, err)
+	}
+	return data, nil
+}
+
+// runSecretTool runs a command that outputs a secret, such as a decryption
+// or keyring lookup, and returns its output, which is never written
+// anywhere else. The command's error output, which names the problem (a
+// wrong key, a tampered file), is included in the error.
+func runSecretTool(args []string, env ...string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), secretToolTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Env = append(os.Environ(), env...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%w: %s", err, msg)
+		}
+		return nil, err
+	}
+	return out, nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeTool writes an executable script standing in for sops or age.
+func fakeTool(t *testing.T, script string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "tool")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+script), 0700); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// TestLoadSOPSConfig tests that a SOPS-encrypted config is decrypted with the age key file
+func TestLoadSOPSConfig(t *testing.T) {
+	defer func(c string) { sopsCommand = c }(sopsCommand)
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	os.WriteFile(path, []byte(`dreamhost_api_key: ENC[AES256_GCM,data:abc,type:str]
+domains:
+  - {name: example.com, type: A}
+sops:
+  age:
+    - recipient: age1example
+  mac: ENC[AES256_GCM,data:def,type:str]
+  version: 3.9.0
+`), 0600)
+	keyFile := filepath.Join(dir, "age.key")
+
+	tests := []struct {
+		name      string
+		script    string
+		wantKey   string
+		wantError string
+	}{
+		{
+			name:    "decrypted",
+			script:  `[ "$SOPS_AGE_KEY_FILE" = "` + keyFile + `" ] || exit 1` + "\nprintf 'dreamhost_api_key: plain-key\\ndomains:\\n  - {name: example.com, type: A}\\n'\n",
+			wantKey: "plain-key",
+		},
+		{name: "wrong key", script: "echo 'no matching age identity' >&2\nexit 128\n", wantError: "no matching age identity"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sopsCommand = fakeTool(t, tt.script)
+			config, err := loadConfig(path, configOptions{AgeKeyFile: keyFile})
+			if tt.wantError != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantError) {
+					t.Fatalf("expected error containing %q, got %v", tt.wantError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if config.DreamhostAPIKey != tt.wantKey || len(config.Domains) != 1 {
+				t.Errorf("expected decrypted config, got key %q and %d domains", config.DreamhostAPIKey, len(config.Domains))
+			}
+		})
+	}
+}
+
+// TestAgeEncryptedSecretFile tests that an age-encrypted secret file is decrypted and a plain one is read as is
+func TestAgeEncryptedSecretFile(t *testing.T) {
+	defer func(c string) { ageCommand = c }(ageCommand)
+	ageCommand = fakeTool(t, `[ "$3" = /keys/age.key ] || exit 1`+"\necho decrypted-key\n")
+
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "binary.age"), []byte("age-encryption.org/v1\n-> X25519 abc\n"), 0600)
+	os.WriteFile(filepath.Join(dir, "armored.age"), []byte("-----BEGIN AGE ENCRYPTED FILE-----\nYWdl\n-----END AGE ENCRYPTED FILE-----\n"), 0600)
+	os.WriteFile(filepath.Join(dir, "plain"), []byte("plain-key\n"), 0600)
+
+	for file, want := range map[string]string{"binary.age": "decrypted-key", "armored.age": "decrypted-key", "plain": "plain-key"} {
+		config := &Config{DreamhostAPIKeyFile: file, ageKeyFile: "/keys/age.key"}
+		if err := readSecretFiles(config, dir); err != nil {
+			t.Fatalf("%s: %v", file, err)
+		}
+		if config.DreamhostAPIKey != want {
+			t.Errorf("%s: expected %q, got %q", file, want, config.DreamhostAPIKey)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// envConfigPrefix starts the names of the variables that configure the
+// daemon when there is no config file, such as DH_CHECK_INTERVAL for
+// check_interval.
+const envConfigPrefix = "DH_"
+
+// envConfig returns a config document built from the environment, for
+// running without a config file as is usual in containers, or nil when
+// DH_DOMAINS isn't set. DH_DOMAINS lists the records to keep updated as
+// comma-separated name:type pairs (the type defaults to A):
+//
+//	DH_DOMAINS=home.example.com:A,home.example.com:AAAA,example.com
+//
+// DH_API_KEY sets dreamhost_api_key, and every other top-level setting
+// holding a single value is read from DH_ and its key in upper case.
+func envConfig(lookup func(string) (string, bool)) ([]byte, error) {
+	list, ok := lookup(envConfigPrefix + "DOMAINS")
+	if !ok {
+		return nil, nil
+	}
+	root := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+
+	fields := yamlFields(reflect.TypeFor[Config]())
+	for _, key := range slices.Sorted(maps.Keys(fields)) {
+		if key == "domains" || !isScalarSetting(fields[key]) {
+			continue
+		}
+		if value, ok := lookup(envConfigPrefix + strings.ToUpper(key)); ok {
+			setValue(root, key, value)
+		}
+	}
+	if value, ok := lookup(envConfigPrefix + "API_KEY"); ok {
+		if _, i := mappingValue(root, "dreamhost_api_key"); i >= 0 {
+			return nil, errors.New("DH_API_KEY and DH_DREAMHOST_API_KEY are mutually exclusive")
+		}
+		setValue(root, "dreamhost_api_key", value)
+	}
+
+	domains := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, recordType, _ := strings.Cut(entry, ":")
+		if name == "" {
+			return nil, fmt.Errorf("DH_DOMAINS: entry %q has no name", entry)
+		}
+		domain := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		setValue(domain, "name", name)
+		setValue(domain, "type", cmp.Or(recordType, "A"))
+		domains.Content = append(domains.Content, domain)
+	}
+	if len(domains.Content) == 0 {
+		return nil, errors.New("DH_DOMAINS lists no records")
+	}
+	root.Content = append(root.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "domains"}, domains)
+
+	return yaml.Marshal(root)
+}
+
+// isScalarSetting reports whether a setting of type t is written as a
+// single value, and so can be given in one environment variable.
+func isScalarSetting(t reflect.Type) bool {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == reflect.TypeFor[time.Duration]() {
+		return true
+	}
+	switch t.Kind() {
+	case reflect.String, reflect.Bool, reflect.Int, reflect.Int64, reflect.Float64:
+		return true
+	}
+	return false
+}
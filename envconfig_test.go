@@ -0,0 +1,90 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestEnvConfig tests building a config from DH_ variables when there is no config file
+func TestEnvConfig(t *testing.T) {
+	tests := []struct {
+		name        string
+		env         map[string]string
+		wantDomains []string
+		wantError   string
+	}{
+		{
+			name: "records and settings",
+			env: map[string]string{
+				"DH_DOMAINS":        "home.example.com:A, home.example.com:AAAA,example.com",
+				"DH_API_KEY":        "key #1",
+				"DH_CHECK_INTERVAL": "10m",
+				"DH_DRY_RUN":        "true",
+			},
+			wantDomains: []string{"home.example.com A", "home.example.com AAAA", "example.com A"},
+		},
+		{
+			name:      "no records",
+			env:       map[string]string{"DH_DOMAINS": " , "},
+			wantError: "DH_DOMAINS lists no records",
+		},
+		{
+			name:      "entry without name",
+			env:       map[string]string{"DH_DOMAINS": ":A"},
+			wantError: `entry ":A" has no name`,
+		},
+		{
+			name:      "both key variables",
+			env:       map[string]string{"DH_DOMAINS": "example.com", "DH_API_KEY": "a", "DH_DREAMHOST_API_KEY": "b"},
+			wantError: "mutually exclusive",
+		},
+		{
+			name:      "bad duration",
+			env:       map[string]string{"DH_DOMAINS": "example.com", "DH_API_KEY": "a", "DH_CHECK_INTERVAL": "300"},
+			wantError: "configuration from environment",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+			config, err := loadConfig(filepath.Join(t.TempDir(), "config.yaml"), configOptions{})
+			if tt.wantError != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantError) {
+					t.Fatalf("expected error containing %q, got %v", tt.wantError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var domains []string
+			for _, domain := range config.Domains {
+				domains = append(domains, domain.FQDN()+" "+domain.Type)
+			}
+			if strings.Join(domains, ",") != strings.Join(tt.wantDomains, ",") {
+				t.Errorf("expected domains %v, got %v", tt.wantDomains, domains)
+			}
+			if config.DreamhostAPIKey != "key #1" || config.CheckInterval != 10*time.Minute || !config.DryRun {
+				t.Errorf("settings not read from the environment: %+v", config)
+			}
+			applyConfigDefaults(config)
+			if errs := validateConfig(config); len(errs) > 0 {
+				t.Errorf("unexpected validation errors: %v", errs)
+			}
+		})
+	}
+}
+
+// TestEnvConfigNeedsDomains tests that a missing config file is still an error without DH_DOMAINS
+func TestEnvConfigNeedsDomains(t *testing.T) {
+	t.Setenv("DH_API_KEY", "key")
+	if _, err := loadConfig(filepath.Join(t.TempDir(), "config.yaml"), configOptions{}); err == nil {
+		t.Error("expected an error for a missing config file")
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// EtcdConfig configures the etcd state backend, which lets several
+// instances share one state.
+type EtcdConfig struct {
+	Endpoint     string `yaml:"endpoint"`      // Client URL (default "http://localhost:2379")
+	Key          string `yaml:"key"`           // Key holding the state (default "dh-ddns-updater/state")
+	Username     string `yaml:"username"`      // User, if authentication is enabled
+	Password     string `yaml:"password"`      // Password of username
+	PasswordFile string `yaml:"password_file"` // File holding password instead
+}
+
+// DefaultEtcdEndpoint and DefaultSharedStateKey are the etcd settings used
+// unless the etcd block gives others. Consul uses the same default key.
+const (
+	DefaultEtcdEndpoint   = "http://localhost:2379"
+	DefaultSharedStateKey = "dh-ddns-updater/state"
+)
+
+// etcdStore keeps the state in etcd through its JSON gateway (the v3 API).
+// The revision is the key's mod_revision, and a save is a transaction
+// comparing it.
+type etcdStore struct {
+	config EtcdConfig
+	client *http.Client
+}
+
+// etcdKeyValue is a key-value pair as the gateway encodes it: bytes in
+// base64 and 64-bit integers as strings.
+type etcdKeyValue struct {
+	Value       string `json:"value"`
+	ModRevision int64  `json:"mod_revision,string"`
+}
+
+func (s etcdStore) get(ctx context.Context) ([]byte, int64, error) {
+	var resp struct {
+		Kvs []etcdKeyValue `json:"kvs"`
+	}
+	if err := s.call(ctx, "/v3/kv/range", map[string]any{"key": s.key()}, &resp); err != nil {
+		return nil, 0, err
+	}
+	if len(resp.Kvs) == 0 {
+		return nil, 0, nil
+	}
+	data, err := base64.StdEncoding.DecodeString(resp.Kvs[0].Value)
+	if err != nil {
+		return nil, 0, fmt.Errorf("etcd: decoding %s: %w", s.config.Key, err)
+	}
+	return data, resp.Kvs[0].ModRevision, nil
+}
+
+func (s etcdStore) put(ctx context.Context, data []byte, revision int64) (bool, int64, error) {
+	txn := map[string]any{
+		// The mod_revision of a key that doesn't exist is 0
+		"compare": []map[string]any{{
+			"key":          s.key(),
+			"target":       "MOD",
+			"result":       "EQUAL",
+			"mod_revision": strconv.FormatInt(revision, 10),
+		}},
+		"success": []map[string]any{{
+			"request_put": map[string]any{"key": s.key(), "value": base64.StdEncoding.EncodeToString(data)},
+		}},
+	}
+	var resp struct {
+		Header struct {
+			Revision int64 `json:"revision,string"`
+		} `json:"header"`
+		Succeeded bool `json:"succeeded"`
+	}
+	if err := s.call(ctx, "/v3/kv/txn", txn, &resp); err != nil {
+		return false, 0, err
+	}
+	return resp.Succeeded, resp.Header.Revision, nil
+}
+
+func (s etcdStore) key() string {
+	return base64.StdEncoding.EncodeToString([]byte(s.config.Key))
+}
+
+// call posts a request to the gateway and decodes the response into out,
+// authenticating first if a user is configured.
+func (s etcdStore) call(ctx context.Context, path string, body, out any) error {
+	token := ""
+	if s.config.Username != "" {
+		var auth struct {
+			Token string `json:"token"`
+		}
+		if err := s.post(ctx, "/v3/auth/authenticate", "", map[string]string{"name": s.config.Username, "password": s.config.Password}, &auth); err != nil {
+			return err
+		}
+		token = auth.Token
+	}
+	return s.post(ctx, path, token, body, out)
+}
+
+func (s etcdStore) post(ctx context.Context, path, token string, body, out any) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(s.config.Endpoint, "/")+path, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", token)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("etcd: %w", err)
+	}
+	defer resp.Body.Close()
+	data, err = io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("etcd: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Message string `json:"message"`
+		}
+		json.Unmarshal(data, &e)
+		return fmt.Errorf("etcd: %s: %s", path, cmp.Or(e.Message, resp.Status))
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("etcd: %s: %w", path, err)
+	}
+	return nil
+}
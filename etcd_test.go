@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeEtcd is an in-memory stand-in for etcd's JSON gateway, with
+// authentication as the given user.
+type fakeEtcd struct {
+	mu       sync.Mutex
+	revision int64
+	values   map[string]etcdKeyValue
+	user     string
+	password string
+}
+
+func (f *fakeEtcd) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var body map[string]json.RawMessage
+	json.NewDecoder(r.Body).Decode(&body)
+	str := func(raw json.RawMessage) string {
+		var s string
+		json.Unmarshal(raw, &s)
+		return s
+	}
+
+	if r.URL.Path == "/v3/auth/authenticate" {
+		if str(body["name"]) != f.user || str(body["password"]) != f.password {
+			http.Error(w, `{"message": "etcdserver: authentication failed, invalid user ID or password"}`, http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"token": "token-" + f.user})
+		return
+	}
+	if f.user != "" && r.Header.Get("Authorization") != "token-"+f.user {
+		http.Error(w, `{"message": "etcdserver: user name is empty"}`, http.StatusUnauthorized)
+		return
+	}
+
+	switch r.URL.Path {
+	case "/v3/kv/range":
+		resp := map[string]any{}
+		if kv, ok := f.values[str(body["key"])]; ok {
+			resp["kvs"] = []map[string]string{{"value": kv.Value, "mod_revision": strconv.FormatInt(kv.ModRevision, 10)}}
+		}
+		json.NewEncoder(w).Encode(resp)
+	case "/v3/kv/txn":
+		var compare []struct {
+			Key         string `json:"key"`
+			ModRevision int64  `json:"mod_revision,string"`
+		}
+		var success []struct {
+			RequestPut struct{ Key, Value string } `json:"request_put"`
+		}
+		json.Unmarshal(body["compare"], &compare)
+		json.Unmarshal(body["success"], &success)
+		resp := map[string]any{"header": map[string]string{"revision": strconv.FormatInt(f.revision, 10)}}
+		if f.values[compare[0].Key].ModRevision == compare[0].ModRevision {
+			f.revision++
+			put := success[0].RequestPut
+			f.values[put.Key] = etcdKeyValue{Value: put.Value, ModRevision: f.revision}
+			resp = map[string]any{"header": map[string]string{"revision": strconv.FormatInt(f.revision, 10)}, "succeeded": true}
+		}
+		json.NewEncoder(w).Encode(resp)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// TestEtcdStateStore tests sharing state through etcd, with authentication
+func TestEtcdStateStore(t *testing.T) {
+	fake := &fakeEtcd{values: make(map[string]etcdKeyValue), user: "ddns", password: "secret"}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	config := EtcdConfig{Endpoint: server.URL, Key: DefaultSharedStateKey, Username: "ddns", Password: "secret"}
+	testSharedStateStore(t, sharedStateStore{kv: etcdStore{config: config, client: server.Client()}})
+
+	config.Password = "wrong"
+	_, err := sharedStateStore{kv: etcdStore{config: config, client: server.Client()}}.Load()
+	if err == nil || !strings.Contains(err.Error(), "authentication failed") {
+		t.Errorf("expected an authentication error, got %v", err)
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultConfDir is the fragment directory used unless conf_dir says
+// otherwise, relative to the config file.
+const DefaultConfDir = "conf.d"
+
+// fragmentKeys are the settings a fragment may contain. Lists are appended
+// to the config's and mappings are merged into it, so a provisioning tool
+// can drop in one file per host or record without touching the main config.
+var fragmentKeys = []string{"domains", "groups", "providers"}
+
+// fragmentPaths returns the fragment files in dir in the order they are
+// merged: by name, skipping hidden files and extensions other than those of
+// the config formats. A missing directory holds no fragments.
+func fragmentPaths(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || strings.HasPrefix(name, ".") {
+			continue
+		}
+		switch strings.ToLower(filepath.Ext(name)) {
+		case ".yaml", ".yml", ".json", ".toml":
+			paths = append(paths, filepath.Join(dir, name))
+		}
+	}
+	return paths, nil // ReadDir sorts by name
+}
+
+// readFragment parses one fragment, in the format its extension implies
+// and decrypted with keyFile if SOPS-encrypted, and expands its environment
+// references.
+func readFragment(path, keyFile string) (*yaml.Node, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	format, _ := configFormat(path, "")
+	doc, err := parseDocument(data, format)
+	if err == nil && isSOPSEncrypted(doc) {
+		if data, err = decryptSOPS(path, format, keyFile); err == nil {
+			doc, err = parseDocument(data, format)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(doc.Content) == 0 {
+		return nil, nil // Empty file
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("line %d: expected a mapping", root.Line)
+	}
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if key := root.Content[i]; !slices.Contains(fragmentKeys, key.Value) {
+			return nil, fmt.Errorf("line %d: %s can't be set in a fragment (only %s)", key.Line, key.Value, strings.Join(fragmentKeys, ", "))
+		}
+	}
+	if err := expandEnvNode(doc, os.LookupEnv); err != nil {
+		return nil, err
+	}
+	// Catch type errors here, where they can be attributed to the file
+	var probe Config
+	if err := doc.Decode(&probe); err != nil {
+		return nil, err
+	}
+	return root, nil
+}
+
+// mergeFragments merges every fragment in dir into root, the config's top
+// level. It returns the files merged and, for the position of validation
+// errors, the file each merged record, group and provider came from.
+func mergeFragments(root *yaml.Node, dir, keyFile string) ([]string, map[*yaml.Node]string, error) {
+	paths, err := fragmentPaths(dir)
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading fragments: %w", err)
+	}
+	origins := make(map[*yaml.Node]string)
+	if err := mergeFiles(root, paths, keyFile, origins); err != nil {
+		return nil, nil, err
+	}
+	return paths, origins, nil
+}
+
+// mergeFiles merges the fragments at paths into root in order, recording
+// in origins the file each merged entry came from. Lists are appended to
+// and mappings gain entries, which must not already be defined.
+func mergeFiles(root *yaml.Node, paths []string, keyFile string, origins map[*yaml.Node]string) error {
+	for _, path := range paths {
+		fragment, err := readFragment(path, keyFile)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if fragment == nil {
+			continue
+		}
+		for i := 0; i+1 < len(fragment.Content); i += 2 {
+			key, value := fragment.Content[i], fragment.Content[i+1]
+			target, _ := mappingValue(root, key.Value)
+			if target == nil || target.Kind == yaml.ScalarNode && target.Tag == "!!null" {
+				target = &yaml.Node{Kind: value.Kind, Tag: value.Tag}
+				if _, j := mappingValue(root, key.Value); j >= 0 {
+					root.Content[j] = target
+				} else {
+					root.Content = append(root.Content, key, target)
+				}
+			}
+			if target.Kind != value.Kind {
+				return fmt.Errorf("%s: line %d: %s has a different type than in the config", path, key.Line, key.Value)
+			}
+
+			if value.Kind == yaml.SequenceNode {
+				for _, item := range value.Content {
+					origins[item] = path
+				}
+				target.Content = append(target.Content, value.Content...)
+				continue
+			}
+			for j := 0; j+1 < len(value.Content); j += 2 {
+				name, entry := value.Content[j], value.Content[j+1]
+				if existing, _ := mappingValue(target, name.Value); existing != nil {
+					return fmt.Errorf("%s: line %d: %s %q is already defined", path, name.Line, key.Value, name.Value)
+				}
+				origins[entry] = path
+				target.Content = append(target.Content, name, entry)
+			}
+		}
+	}
+	return nil
+}
+
+// includePaths returns the files the include setting names, relative to
+// dir, in the order they are merged: as listed, with the matches of a glob
+// by name. A pattern without glob characters must name an existing file,
+// while a glob may match nothing. It also returns the directories globs
+// are matched in, which are watched for files being added.
+func includePaths(include *yaml.Node, dir string) (paths, globDirs []string, err error) {
+	var patterns []string
+	if err := include.Decode(&patterns); err != nil {
+		return nil, nil, fmt.Errorf("line %d: include must be a list of paths", include.Line)
+	}
+	for _, pattern := range patterns {
+		glob := strings.ContainsAny(pattern, "*?[")
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(dir, pattern)
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, nil, fmt.Errorf("include %q: %w", pattern, err)
+		}
+		if !glob {
+			if _, err := os.Stat(pattern); err != nil {
+				return nil, nil, fmt.Errorf("include: %w", err)
+			}
+		} else {
+			globDirs = append(globDirs, filepath.Dir(pattern))
+		}
+		for _, match := range matches {
+			if !slices.Contains(paths, match) {
+				paths = append(paths, match)
+			}
+		}
+	}
+	return paths, globDirs, nil
+}
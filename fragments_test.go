@@ -0,0 +1,198 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestLoadConfigFragments tests merging conf.d fragments and rejecting the ones that conflict
+func TestLoadConfigFragments(t *testing.T) {
+	tests := []struct {
+		name      string
+		config    string
+		fragments map[string]string
+		wantNames []string
+		wantError string
+	}{
+		{
+			name:   "merged in name order",
+			config: "dreamhost_api_key: key\ndomains:\n  - name: example.com\n    type: A\n",
+			fragments: map[string]string{
+				"20-b.yaml":   "domains:\n  - name: b.example\n    type: A\n",
+				"10-a.json":   `{"domains": [{"name": "a.example", "type": "AAAA", "group": "lab"}], "groups": {"lab": {"create": {"ttl": 300}}}}`,
+				"30-c.toml":   "[[domains]]\nname = \"c.example\"\ntype = \"A\"\n",
+				".hidden.yml": "domains:\n  - name: hidden.example\n",
+				"README":      "not a fragment",
+			},
+			wantNames: []string{"example.com", "a.example", "b.example", "c.example"},
+		},
+		{
+			name:      "no directory",
+			config:    "domains:\n  - name: example.com\n",
+			wantNames: []string{"example.com"},
+		},
+		{
+			name:      "empty config",
+			fragments: map[string]string{"a.yaml": "domains:\n  - name: a.example\n"},
+			wantNames: []string{"a.example"},
+		},
+		{
+			name:      "conf_dir disabled",
+			config:    "conf_dir: \"\"\n",
+			fragments: map[string]string{"a.yaml": "domains:\n  - name: a.example\n"},
+		},
+		{
+			name:      "setting not allowed",
+			fragments: map[string]string{"a.yaml": "domains: []\ndreamhost_api_key: other\n"},
+			wantError: "a.yaml: line 2: dreamhost_api_key can't be set in a fragment",
+		},
+		{
+			name:      "duplicate group",
+			config:    "groups:\n  lab: {}\n",
+			fragments: map[string]string{"a.yaml": "groups:\n  lab: {}\n"},
+			wantError: "a.yaml: line 2: groups \"lab\" is already defined",
+		},
+		{
+			name:      "bad value",
+			fragments: map[string]string{"a.yaml": "providers:\n  other:\n    api_key: [1]\n"},
+			wantError: "a.yaml: yaml: unmarshal errors:\n  line 3",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "config.yaml")
+			os.WriteFile(path, []byte(tt.config), 0600)
+			if tt.fragments != nil {
+				os.Mkdir(filepath.Join(dir, "conf.d"), 0700)
+				for name, data := range tt.fragments {
+					os.WriteFile(filepath.Join(dir, "conf.d", name), []byte(data), 0600)
+				}
+			}
+
+			config, err := loadConfig(path, configOptions{})
+			if tt.wantError != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantError) {
+					t.Fatalf("expected error containing %q, got %v", tt.wantError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var names []string
+			for _, domain := range config.Domains {
+				names = append(names, domain.Name)
+			}
+			if strings.Join(names, ",") != strings.Join(tt.wantNames, ",") {
+				t.Errorf("expected domains %v, got %v", tt.wantNames, names)
+			}
+		})
+	}
+}
+
+// TestFragmentPositions tests that validation errors in a fragment name the fragment and its line
+func TestFragmentPositions(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	os.WriteFile(path, []byte("dreamhost_api_key: key\ndomains:\n  - name: example.com\n    type: A\n"), 0600)
+	os.Mkdir(filepath.Join(dir, "conf.d"), 0700)
+	fragment := filepath.Join(dir, "conf.d", "home.yaml")
+	os.WriteFile(fragment, []byte("domains:\n  - name: example.com\n    type: A\n"), 0600)
+
+	errs := checkConfigFile(path, configOptions{})
+	want := fragment + ": line 2: domain 1 (example.com): duplicate of domain 0"
+	if len(errs) != 1 || !strings.HasPrefix(errs[0].Error(), want) {
+		t.Errorf("expected one error starting with %q, got %v", want, errs)
+	}
+
+	config, err := loadConfig(path, configOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := config.watchedFiles()
+	if !strings.Contains(strings.Join(files, "\n"), fragment) {
+		t.Errorf("expected %s to be watched, got %v", fragment, files)
+	}
+}
+
+// TestLoadConfigInclude tests merging the files include names, globs included, ahead of conf.d fragments
+func TestLoadConfigInclude(t *testing.T) {
+	tests := []struct {
+		name      string
+		config    string
+		files     map[string]string
+		wantNames []string
+		wantError string
+	}{
+		{
+			name:   "files and globs in order",
+			config: "include: [providers.yaml, sites/*.yaml]\ndomains:\n  - name: example.com\n",
+			files: map[string]string{
+				"providers.yaml":     "providers:\n  backup:\n    api_key: key\n",
+				"sites/b.yaml":       "domains:\n  - name: b.example\n    provider: backup\n",
+				"sites/a.yaml":       "domains:\n  - name: a.example\n",
+				"conf.d/late.yaml":   "domains:\n  - name: late.example\n",
+				"sites/ignored.json": `{"domains": [{"name": "ignored.example"}]}`,
+			},
+			wantNames: []string{"example.com", "a.example", "b.example", "late.example"},
+		},
+		{
+			name:      "glob without matches",
+			config:    "include: [sites/*.yaml]\ndomains:\n  - name: example.com\n",
+			wantNames: []string{"example.com"},
+		},
+		{
+			name:      "missing file",
+			config:    "include: [providers.yaml]\n",
+			wantError: "include: stat",
+		},
+		{
+			name:      "not a list",
+			config:    "include:\n  file: providers.yaml\n",
+			wantError: "line 2: include must be a list of paths",
+		},
+		{
+			name:      "setting not allowed",
+			config:    "include: [other.yaml]\n",
+			files:     map[string]string{"other.yaml": "log_level: debug\n"},
+			wantError: "other.yaml: line 1: log_level can't be set in a fragment",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "config.yaml")
+			os.WriteFile(path, []byte(tt.config), 0600)
+			for name, data := range tt.files {
+				os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0700)
+				os.WriteFile(filepath.Join(dir, name), []byte(data), 0600)
+			}
+
+			config, err := loadConfig(path, configOptions{})
+			if tt.wantError != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantError) {
+					t.Fatalf("expected error containing %q, got %v", tt.wantError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var names []string
+			for _, domain := range config.Domains {
+				names = append(names, domain.Name)
+			}
+			if strings.Join(names, ",") != strings.Join(tt.wantNames, ",") {
+				t.Errorf("expected domains %v, got %v", tt.wantNames, names)
+			}
+			if files := strings.Join(config.watchedFiles(), "\n"); !strings.Contains(files, filepath.Join(dir, "sites")) {
+				t.Errorf("expected the glob directory to be watched, got %v", files)
+			}
+		})
+	}
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// DefaultGeoIPURL is the ipinfo.io API the ipinfo GeoIP source queries.
+const DefaultGeoIPURL = "https://ipinfo.io"
+
+// GeoIPConfig configures looking up the network (ASN) and country of each
+// new public IP, for the change logs and notifications. A change to an
+// unexpected network is a strong sign something is wrong, such as a VPN
+// leak or a hijacked IP service.
+type GeoIPConfig struct {
+	// Source is "ipinfo" to ask the ipinfo.io API or "mmdb" to look the IP
+	// up in local MaxMind DB files; empty disables lookups.
+	Source    string `yaml:"source"`
+	URL       string `yaml:"url"`        // API the ipinfo source queries (default DefaultGeoIPURL)
+	Token     string `yaml:"token"`      // ipinfo.io access token, for its higher rate limits
+	TokenFile string `yaml:"token_file"` // File holding token instead
+
+	// Databases are the MMDB files the mmdb source reads, such as
+	// GeoLite2-ASN.mmdb and GeoLite2-Country.mmdb, or ipinfo's
+	// country_asn.mmdb. Each field is taken from the first that has it.
+	Databases []string `yaml:"databases"`
+
+	// ExpectedASNs are the networks the IP is expected to belong to, such
+	// as the ISP's; a change to an IP of another is logged as a warning
+	// and flagged in the notification. Empty expects any.
+	ExpectedASNs []uint32 `yaml:"expected_asns"`
+}
+
+// GeoInfo is what a GeoIP lookup found out about an IP. Fields the source
+// doesn't know are left empty.
+type GeoInfo struct {
+	ASN     uint32 `json:"asn,omitempty"`
+	ASOrg   string `json:"as_org,omitempty"`  // Name of the network's owner
+	Country string `json:"country,omitempty"` // ISO 3166-1 alpha-2 code
+	// Unexpected is set if expected_asns is configured and ASN isn't
+	// among them.
+	Unexpected bool `json:"unexpected,omitempty"`
+}
+
+// String returns the network and country, as in "AS64500 Example Net, NL".
+func (g *GeoInfo) String() string {
+	var parts []string
+	if network := strings.TrimSpace(g.asnText() + " " + g.ASOrg); network != "" {
+		parts = append(parts, network)
+	}
+	if g.Country != "" {
+		parts = append(parts, g.Country)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// asnText returns the ASN as in "AS64500", or "" if it is unknown.
+func (g *GeoInfo) asnText() string {
+	if g.ASN == 0 {
+		return ""
+	}
+	return "AS" + strconv.FormatUint(uint64(g.ASN), 10)
+}
+
+// validateGeoIP checks the geoip block.
+func validateGeoIP(config GeoIPConfig) error {
+	switch config.Source {
+	case "":
+		return nil
+	case "ipinfo":
+		if err := validateHTTPURL("url", config.URL); err != nil {
+			return fmt.Errorf("geoip: %w", err)
+		}
+	case "mmdb":
+		if len(config.Databases) == 0 {
+			return errors.New("geoip: the mmdb source needs databases")
+		}
+	default:
+		return fmt.Errorf("geoip: unknown source %q (want ipinfo or mmdb)", config.Source)
+	}
+	return nil
+}
+
+// geoIP looks up the GeoInfo of IPs from the configured source.
+type geoIP struct {
+	config    GeoIPConfig
+	client    *http.Client
+	databases []*mmdb // For the mmdb source
+}
+
+// newGeoIP returns the lookups config configures, or nil if it has no
+// source. The mmdb source's databases are read now, so that a missing
+// file is found on start.
+func newGeoIP(config GeoIPConfig, client *http.Client) (*geoIP, error) {
+	if config.Source == "" {
+		return nil, nil
+	}
+	g := &geoIP{config: config, client: client}
+	if config.Source == "mmdb" {
+		for _, path := range config.Databases {
+			db, err := openMMDB(path)
+			if err != nil {
+				return nil, fmt.Errorf("geoip: %w", err)
+			}
+			g.databases = append(g.databases, db)
+		}
+	}
+	return g, nil
+}
+
+// lookup returns the GeoInfo of ip.
+func (g *geoIP) lookup(ctx context.Context, ip string) (*GeoInfo, error) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return nil, err
+	}
+	var info *GeoInfo
+	if g.config.Source == "mmdb" {
+		info, err = g.lookupMMDB(addr)
+	} else {
+		info, err = g.lookupIPInfo(ctx, addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(g.config.ExpectedASNs) > 0 {
+		info.Unexpected = !slices.Contains(g.config.ExpectedASNs, info.ASN)
+	}
+	return info, nil
+}
+
+// lookupIPInfo asks the ipinfo.io API about addr. Its org field holds the
+// ASN and the network's owner, as in "AS64500 Example Net".
+func (g *geoIP) lookupIPInfo(ctx context.Context, addr netip.Addr) (*GeoInfo, error) {
+	u := strings.TrimRight(g.config.URL, "/") + "/" + addr.String() + "/json"
+	if g.config.Token != "" {
+		u += "?token=" + url.QueryEscape(g.config.Token)
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return nil, redactError(err) // The URL carries the token
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP %d from %s", resp.StatusCode, req.URL.Host)
+	}
+
+	var body struct {
+		Country string `json:"country"`
+		Org     string `json:"org"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("decoding response: %w", err)
+	}
+	info := &GeoInfo{Country: body.Country, ASOrg: body.Org}
+	if asn, org, ok := strings.Cut(body.Org, " "); ok {
+		if n, ok := parseASN(asn); ok {
+			info.ASN, info.ASOrg = n, org
+		}
+	}
+	return info, nil
+}
+
+// lookupMMDB looks addr up in each database, taking each field from the
+// first that has it. Both MaxMind's GeoLite2 fields and ipinfo's are
+// understood.
+func (g *geoIP) lookupMMDB(addr netip.Addr) (*GeoInfo, error) {
+	info := &GeoInfo{}
+	for _, db := range g.databases {
+		value, err := db.lookup(addr)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", db.path, err)
+		}
+		m, _ := value.(map[string]any)
+		if info.ASN == 0 {
+			if n, ok := m["autonomous_system_number"].(uint64); ok {
+				info.ASN = uint32(n)
+			} else if s, ok := m["asn"].(string); ok {
+				info.ASN, _ = parseASN(s)
+			}
+		}
+		info.ASOrg = cmp.Or(info.ASOrg, mmdbString(m, "autonomous_system_organization"), mmdbString(m, "as_name"))
+		info.Country = cmp.Or(info.Country, mmdbString(m, "country", "iso_code"), mmdbString(m, "country_code"), mmdbString(m, "country"))
+	}
+	return info, nil
+}
+
+// mmdbString returns the string at path in the nested maps of m, or "" if
+// there is none.
+func mmdbString(m map[string]any, path ...string) string {
+	var value any = m
+	for _, key := range path {
+		m, ok := value.(map[string]any)
+		if !ok {
+			return ""
+		}
+		value = m[key]
+	}
+	s, _ := value.(string)
+	return s
+}
+
+// parseASN parses an ASN written as in "AS64500".
+func parseASN(s string) (uint32, bool) {
+	n, err := strconv.ParseUint(strings.TrimPrefix(s, "AS"), 10, 32)
+	return uint32(n), err == nil && strings.HasPrefix(s, "AS")
+}
+
+// lookupGeo looks up ip if GeoIP lookups are configured, keeping what was
+// found for the notification of the records being moved to it; see
+// geoInfo. A failed lookup is logged and leaves the change unenriched.
+func (d *DDNSUpdater) lookupGeo(ctx context.Context, ip string) *GeoInfo {
+	if d.geoip == nil {
+		return nil
+	}
+	info, err := d.geoip.lookup(withRequestID(ctx), ip)
+	if err != nil {
+		d.logger.WarnContext(ctx, "GeoIP lookup failed", "ip", ip, "error", err)
+		return nil
+	}
+	d.geoMu.Lock()
+	d.geoFor, d.geo = ip, info
+	d.geoMu.Unlock()
+	return info
+}
+
+// geoInfo returns what the last lookup found about ip, or nil if the last
+// lookup was of another IP or failed.
+func (d *DDNSUpdater) geoInfo(ip string) *GeoInfo {
+	d.geoMu.Lock()
+	defer d.geoMu.Unlock()
+	if d.geoFor != ip {
+		return nil
+	}
+	return d.geo
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestGeoIPLookup tests lookups through the ipinfo API and in MaxMind's and
+// ipinfo's MMDB layouts, and the expected_asns check
+func TestGeoIPLookup(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/203.0.113.42/json" || r.URL.Query().Get("token") != "secret" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"ip":"203.0.113.42","city":"Amsterdam","country":"NL","org":"AS64500 Example Net"}`))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	prefix := netip.MustParsePrefix("203.0.113.0/24")
+	databases := map[string]map[string]any{
+		"GeoLite2-ASN.mmdb":     {"autonomous_system_number": uint32(64500), "autonomous_system_organization": "Example Net"},
+		"GeoLite2-Country.mmdb": {"country": map[string]any{"iso_code": "NL"}},
+		"country_asn.mmdb":      {"asn": "AS64500", "as_name": "Example Net", "country": "NL"},
+	}
+	for name, data := range databases {
+		b := writeMMDB(t, 6, 28, map[netip.Prefix]map[string]any{prefix: data})
+		if err := os.WriteFile(filepath.Join(dir, name), b, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := GeoInfo{ASN: 64500, ASOrg: "Example Net", Country: "NL"}
+	tests := []struct {
+		name   string
+		config GeoIPConfig
+		want   GeoInfo
+	}{
+		{"ipinfo", GeoIPConfig{Source: "ipinfo", URL: server.URL, Token: "secret"}, want},
+		{"GeoLite2", GeoIPConfig{Source: "mmdb", Databases: []string{filepath.Join(dir, "GeoLite2-ASN.mmdb"), filepath.Join(dir, "GeoLite2-Country.mmdb")}}, want},
+		{"ipinfo mmdb", GeoIPConfig{Source: "mmdb", Databases: []string{filepath.Join(dir, "country_asn.mmdb")}}, want},
+		{"expected", GeoIPConfig{Source: "mmdb", Databases: []string{filepath.Join(dir, "country_asn.mmdb")}, ExpectedASNs: []uint32{64501, 64500}}, want},
+		{"unexpected", GeoIPConfig{Source: "mmdb", Databases: []string{filepath.Join(dir, "country_asn.mmdb")}, ExpectedASNs: []uint32{64501}},
+			GeoInfo{ASN: 64500, ASOrg: "Example Net", Country: "NL", Unexpected: true}},
+	}
+	for _, tt := range tests {
+		g, err := newGeoIP(tt.config, server.Client())
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.name, err)
+		}
+		info, err := g.lookup(context.Background(), "203.0.113.42")
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+		} else if *info != tt.want {
+			t.Errorf("%s: expected %+v, got %+v", tt.name, tt.want, *info)
+		}
+	}
+
+	g, _ := newGeoIP(GeoIPConfig{Source: "ipinfo", URL: server.URL, Token: "wrong"}, server.Client())
+	if _, err := g.lookup(context.Background(), "203.0.113.42"); err == nil || strings.Contains(err.Error(), "wrong") {
+		t.Errorf("expected an error without the token, got %v", err)
+	}
+	if _, err := newGeoIP(GeoIPConfig{Source: "mmdb", Databases: []string{filepath.Join(dir, "missing.mmdb")}}, nil); err == nil {
+		t.Error("expected an error for a missing database")
+	}
+}
+
+// TestGeoInfoString tests the network and country as shown in summaries
+func TestGeoInfoString(t *testing.T) {
+	tests := []struct {
+		info GeoInfo
+		want string
+	}{
+		{GeoInfo{ASN: 64500, ASOrg: "Example Net", Country: "NL"}, "AS64500 Example Net, NL"},
+		{GeoInfo{ASN: 64500}, "AS64500"},
+		{GeoInfo{Country: "NL"}, "NL"},
+		{GeoInfo{}, ""},
+	}
+	for _, tt := range tests {
+		if got := tt.info.String(); got != tt.want {
+			t.Errorf("%+v: expected %q, got %q", tt.info, tt.want, got)
+		}
+	}
+}
+
+// TestValidateGeoIP tests the geoip block checks
+func TestValidateGeoIP(t *testing.T) {
+	tests := []struct {
+		config  GeoIPConfig
+		wantErr bool
+	}{
+		{GeoIPConfig{}, false},
+		{GeoIPConfig{Source: "ipinfo", URL: DefaultGeoIPURL}, false},
+		{GeoIPConfig{Source: "ipinfo", URL: "ipinfo.io"}, true},
+		{GeoIPConfig{Source: "mmdb", Databases: []string{"/var/lib/GeoIP/GeoLite2-ASN.mmdb"}}, false},
+		{GeoIPConfig{Source: "mmdb"}, true},
+		{GeoIPConfig{Source: "maxmind"}, true},
+	}
+	for _, tt := range tests {
+		if err := validateGeoIP(tt.config); (err != nil) != tt.wantErr {
+			t.Errorf("%+v: expected error %v, got %v", tt.config, tt.wantErr, err)
+		}
+	}
+}
+
+// TestIPChangeGeo tests that a change to an unexpected network is logged
+// as a warning, and that the notification of the records moved to the new
+// IP carries its network
+func TestIPChangeGeo(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "country_asn.mmdb")
+	b := writeMMDB(t, 4, 24, map[netip.Prefix]map[string]any{
+		netip.MustParsePrefix("203.0.113.0/24"): {"asn": "AS64666", "as_name": "VPN Corp", "country": "SE"},
+	})
+	if err := os.WriteFile(path, b, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	fake := newFakeDreamhost(DNSRecord{Record: "home.example.com", Type: "A", Value: "198.51.100.1", Comment: ManagedComment})
+	updater := newPlanTestUpdater(t, fake, "", DomainConfig{Name: "example.com", Record: "home", Type: "A"})
+	var logs bytes.Buffer
+	updater.logger = newLogger(&logs, "info")
+	updater.startupIP = "198.51.100.1"
+	var err error
+	if updater.geoip, err = newGeoIP(GeoIPConfig{Source: "mmdb", Databases: []string{path}, ExpectedASNs: []uint32{64500}}, nil); err != nil {
+		t.Fatal(err)
+	}
+	n, recorder := newTestNotifications(t, EventIPChange)
+	updater.notifications = n
+
+	if !updater.setPublicIP(context.Background(), "203.0.113.42", ipSourceName) {
+		t.Fatal("expected the desired value to change")
+	}
+	var entry struct {
+		Level string `json:"level"`
+		Msg   string `json:"msg"`
+		ASN   uint32 `json:"asn"`
+	}
+	if err := json.Unmarshal(logs.Bytes(), &entry); err != nil {
+		t.Fatalf("decoding %s: %v", logs.String(), err)
+	}
+	if entry.Level != "WARN" || entry.Msg != "IP changed to an unexpected network" || entry.ASN != 64666 {
+		t.Errorf("expected a warning with the ASN, got %s", logs.String())
+	}
+
+	plan, err := updater.planAll(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := updater.apply(context.Background(), plan, ApplyPolicy{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	n.wait()
+	events := recorder.received()
+	if len(events) != 1 || events[0].Geo == nil {
+		t.Fatalf("expected an event with the network, got %+v", events)
+	}
+	if want := (GeoInfo{ASN: 64666, ASOrg: "VPN Corp", Country: "SE", Unexpected: true}); *events[0].Geo != want {
+		t.Errorf("expected %+v, got %+v", want, *events[0].Geo)
+	}
+	if want := "to 203.0.113.42 (AS64666 VPN Corp, SE), an unexpected network; updated 1 records"; !strings.Contains(events[0].Summary, want) {
+		t.Errorf("expected the summary to contain %q, got %q", want, events[0].Summary)
+	}
+}
@@ -1,5 +1,8 @@
 module dh-ddns-updater
 
-go 1.24
+go 1.24.0
 
-require gopkg.in/yaml.v3 v3.0.1
+require (
+	golang.org/x/sys v0.41.0
+	gopkg.in/yaml.v3 v3.0.1
+)
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"text/template"
+
+	"gopkg.in/yaml.v3"
+)
+
+// GroupConfig holds settings shared by the records that name the group.
+type GroupConfig struct {
+	Create CreationDefaults `yaml:"create"` // Metadata for records the daemon creates
+}
+
+// CreationDefaults is metadata given to every record the daemon creates (or
+// re-creates when updating it) in a group, so new records carry the same
+// organization-standard metadata without repeating it on each one.
+type CreationDefaults struct {
+	TTL     int               `yaml:"ttl"`     // Default TTL of the group's records, in seconds
+	Comment string            `yaml:"comment"` // Template appended to the managed comment; see creationData
+	Labels  map[string]string `yaml:"labels"`  // Labels for the comment template; without one they make up the comment
+}
+
+// creationData is what a comment template can refer to.
+type creationData struct {
+	Record string            // Fully qualified record name
+	Name   string            // Zone
+	Type   string            // Record type
+	Notes  string            // The record's notes
+	Labels map[string]string // The group's labels
+}
+
+// resolveCreationDefaults checks the record's group and stores its creation
+// defaults in the record, with the comment rendered. The group's TTL
+// becomes the record's unless the record sets its own, and otherwise the
+// TTL of the config's defaults.
+func resolveCreationDefaults(config *Config, domain *DomainConfig) error {
+	if domain.Group == "" {
+		if domain.TTL == 0 {
+			domain.TTL = config.Defaults.TTL
+		}
+		return nil
+	}
+	group, ok := config.Groups[domain.Group]
+	if !ok {
+		return fmt.Errorf("unknown group %q", domain.Group)
+	}
+
+	defaults := group.Create
+	if defaults.TTL < 0 {
+		return fmt.Errorf("group %q: ttl must not be negative", domain.Group)
+	}
+	if defaults.Comment != "" {
+		tmpl, err := template.New("comment").Option("missingkey=zero").Parse(defaults.Comment)
+		if err != nil {
+			return fmt.Errorf("group %q: parsing comment template: %w", domain.Group, err)
+		}
+		var b strings.Builder
+		data := creationData{Record: domain.FQDN(), Name: domain.Name, Type: domain.Type, Notes: domain.Notes, Labels: defaults.Labels}
+		if err := tmpl.Execute(&b, data); err != nil {
+			return fmt.Errorf("group %q: rendering comment template: %w", domain.Group, err)
+		}
+		defaults.Comment = b.String()
+	} else if len(defaults.Labels) > 0 {
+		var labels []string
+		for _, key := range slices.Sorted(maps.Keys(defaults.Labels)) {
+			labels = append(labels, key+"="+defaults.Labels[key])
+		}
+		defaults.Comment = strings.Join(labels, " ")
+	}
+	domain.create = defaults
+	if domain.TTL == 0 {
+		domain.TTL = cmp.Or(defaults.TTL, config.Defaults.TTL)
+	}
+	return nil
+}
+
+// expandRecordLists expands every domains entry listing several record
+// names under records into one entry per name, each with the entry's other
+// settings, so records sharing a domain, type and options are written once:
+//
+//   - name: example.com
+//     type: A
+//     records: [www, mail, vpn]
+//
+// Each expanded entry is positioned at its name in the list and, through
+// origins, keeps the fragment it came from.
+func expandRecordLists(root *yaml.Node, origins map[*yaml.Node]string) error {
+	domains, _ := mappingValue(root, "domains")
+	if domains == nil || domains.Kind != yaml.SequenceNode {
+		return nil
+	}
+
+	var expanded []*yaml.Node
+	for _, entry := range domains.Content {
+		records, j := mappingValue(entry, "records")
+		if records == nil {
+			expanded = append(expanded, entry)
+			continue
+		}
+		fail := func(line int, msg string) error {
+			return atPosition(configPosition{File: origins[entry], Line: line}, fmt.Errorf("domains: %s", msg))
+		}
+		if record, _ := mappingValue(entry, "record"); record != nil {
+			return fail(record.Line, "record and records are mutually exclusive")
+		}
+		if records.Kind != yaml.SequenceNode || len(records.Content) == 0 {
+			return fail(records.Line, "records must be a non-empty list of record names")
+		}
+
+		// Everything but the records key, which the clones replace with record
+		rest := slices.Concat(entry.Content[:j-1], entry.Content[j+1:])
+		for _, name := range records.Content {
+			if name.Kind != yaml.ScalarNode {
+				return fail(name.Line, "records must be a non-empty list of record names")
+			}
+			clone := *entry
+			clone.Line, clone.Column = name.Line, name.Column
+			key := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "record", Line: name.Line}
+			clone.Content = append(slices.Clone(rest), key, name)
+			if file, ok := origins[entry]; ok {
+				origins[&clone] = file
+			}
+			expanded = append(expanded, &clone)
+		}
+	}
+	domains.Content = expanded
+	return nil
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"cmp"
+	"strings"
+	"testing"
+)
+
+// TestResolveCreationDefaults tests that a record's group supplies its creation metadata
+func TestResolveCreationDefaults(t *testing.T) {
+	config := &Config{Groups: map[string]GroupConfig{
+		"office": {Create: CreationDefaults{
+			TTL:     300,
+			Comment: "{{.Record}} ({{.Type}}) owned by {{.Labels.team}}{{with .Notes}}: {{.}}{{end}}",
+			Labels:  map[string]string{"team": "netops"},
+		}},
+		"labels":    {Create: CreationDefaults{Labels: map[string]string{"team": "netops", "env": "prod"}}},
+		"bad":       {Create: CreationDefaults{Comment: "{{.Record"}},
+		"wrong ttl": {Create: CreationDefaults{TTL: -1}},
+	}}
+
+	tests := []struct {
+		name          string
+		domain        DomainConfig
+		wantTTL       int
+		wantRecordTTL int // Default wantTTL
+		wantComment   string
+		wantError     bool
+	}{
+		{name: "no group", domain: DomainConfig{Name: "example.com", Type: "A"}},
+		{name: "comment template", domain: DomainConfig{Name: "example.com", Record: "vpn", Type: "A", Notes: "router", Group: "office"},
+			wantTTL: 300, wantComment: "vpn.example.com (A) owned by netops: router"},
+		{name: "labels only", domain: DomainConfig{Name: "example.com", Type: "A", Group: "labels"}, wantComment: "env=prod team=netops"},
+		{name: "unknown group", domain: DomainConfig{Name: "example.com", Type: "A", Group: "nope"}, wantError: true},
+		{name: "bad template", domain: DomainConfig{Name: "example.com", Type: "A", Group: "bad"}, wantError: true},
+		{name: "negative ttl", domain: DomainConfig{Name: "example.com", Type: "A", Group: "wrong ttl"}, wantError: true},
+		{name: "record ttl wins", domain: DomainConfig{Name: "example.com", Record: "vpn", Type: "A", TTL: 60, Group: "office"},
+			wantTTL: 300, wantRecordTTL: 60, wantComment: "vpn.example.com (A) owned by netops"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			domain := tt.domain
+			err := resolveCreationDefaults(config, &domain)
+			if (err != nil) != tt.wantError {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if domain.create.TTL != tt.wantTTL || domain.create.Comment != tt.wantComment {
+				t.Errorf("expected ttl %d and comment %q, got %d and %q", tt.wantTTL, tt.wantComment, domain.create.TTL, domain.create.Comment)
+			}
+			wantRecordTTL := cmp.Or(tt.wantRecordTTL, tt.wantTTL)
+			if !tt.wantError && domain.TTL != wantRecordTTL {
+				t.Errorf("expected record ttl %d, got %d", wantRecordTTL, domain.TTL)
+			}
+		})
+	}
+}
+
+// TestAddRecordComment tests that group comments and synced notes follow the managed marker
+func TestAddRecordComment(t *testing.T) {
+	tests := []struct {
+		name      string
+		syncNotes bool
+		create    CreationDefaults
+		want      string
+	}{
+		{name: "plain", want: ManagedComment},
+		{name: "notes", syncNotes: true, want: ManagedComment + ": port 51820"},
+		{name: "group", create: CreationDefaults{Comment: "team=netops"}, want: ManagedComment + ": team=netops"},
+		{name: "group and notes", syncNotes: true, create: CreationDefaults{Comment: "team=netops"}, want: ManagedComment + ": team=netops; port 51820"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &DreamhostProvider{syncNotes: tt.syncNotes}
+			domain := DomainConfig{Name: "example.com", Record: "vpn", Type: "A", Notes: "port 51820", create: tt.create}
+			if got := p.addRecordParams(domain, "192.0.2.1").Get("comment"); got != tt.want {
+				t.Errorf("expected comment %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+// TestExpandRecordLists tests that an entry listing several records becomes one entry per record
+func TestExpandRecordLists(t *testing.T) {
+	tests := []struct {
+		name        string
+		yaml        string
+		wantRecords []string
+		wantError   string
+	}{
+		{
+			name: "expanded in place",
+			yaml: `domains:
+  - {name: example.com, type: A, record: home}
+  - name: example.com
+    type: AAAA
+    notes: shared
+    records: [www, "", vpn]
+  - {name: example.org, type: A}
+`,
+			wantRecords: []string{"home.example.com A", "www.example.com AAAA shared", "example.com AAAA shared", "vpn.example.com AAAA shared", "example.org A"},
+		},
+		{name: "both record and records", yaml: "domains:\n  - {name: example.com, type: A, record: a, records: [b]}\n", wantError: "line 2: domains: record and records are mutually exclusive"},
+		{name: "empty list", yaml: "domains:\n  - name: example.com\n    records: []\n", wantError: "line 3: domains: records must be a non-empty list"},
+		{name: "not names", yaml: "domains:\n  - name: example.com\n    records:\n      - {record: a}\n", wantError: "line 4: domains: records must be"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := parseConfig([]byte(tt.yaml), configOptions{})
+			if tt.wantError != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantError) {
+					t.Fatalf("expected error containing %q, got %v", tt.wantError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var records []string
+			for _, domain := range config.Domains {
+				records = append(records, strings.TrimSpace(domain.FQDN()+" "+domain.Type+" "+domain.Notes))
+			}
+			if strings.Join(records, "\n") != strings.Join(tt.wantRecords, "\n") {
+				t.Errorf("expected records %q, got %q", tt.wantRecords, records)
+			}
+		})
+	}
+}
+
+// TestExpandRecordListsPositions tests that problems with an expanded record point at its name in the list
+func TestExpandRecordListsPositions(t *testing.T) {
+	config, err := parseConfig([]byte(`dreamhost_api_key: key
+domains:
+  - name: example.com
+    type: A
+    records:
+      - www
+      - www
+`), configOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	applyConfigDefaults(config)
+	errs := validateConfig(config)
+	if len(errs) != 1 || !strings.HasPrefix(errs[0].Error(), "line 7: domain 1 (example.com): duplicate of domain 0") {
+		t.Errorf("expected the duplicate reported at line 7, got %v", errs)
+	}
+}
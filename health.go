@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// HealthConfig configures the endpoints probes use to tell whether the
+// daemon is alive and keeping the records up to date.
+type HealthConfig struct {
+	Listen string `yaml:"listen"` // HTTP listen address (e.g. ":8080"); empty disables the endpoints
+}
+
+// readiness returns why the daemon isn't ready at now, or nothing if it is:
+// every stage that runs on a schedule must have run within twice its
+// interval, and its last run must have succeeded.
+func (d *DDNSUpdater) readiness(now time.Time) []string {
+	var stages []*Stage
+	if !d.config.Webhook.DisablePolling {
+		stages = append(stages, d.detection)
+	}
+	for _, name := range d.providerNames() {
+		stages = append(stages, d.providers[name].stage)
+	}
+
+	var problems []string
+	for _, stage := range stages {
+		m := stage.Metrics()
+		switch {
+		case m.LastRun.IsZero():
+			problems = append(problems, fmt.Sprintf("%s: hasn't run yet", stage.Name))
+		case now.Sub(m.LastRun) > 2*stage.Interval:
+			problems = append(problems, fmt.Sprintf("%s: last ran %s ago, more than twice its interval of %s",
+				stage.Name, now.Sub(m.LastRun).Round(time.Second), stage.Interval))
+		case m.ConsecutiveFailures > 0:
+			problems = append(problems, fmt.Sprintf("%s: last run failed: %s", stage.Name, m.LastError))
+		}
+	}
+	return problems
+}
+
+// healthHandler returns the handler serving the probe endpoints:
+//
+//	GET /healthz  200 while the process is serving
+//	GET /readyz   200 when ready, otherwise 503 listing why not; see readiness
+//	GET /status   the daemon's status as JSON; see Status
+func (d *DDNSUpdater) healthHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("GET /readyz", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		problems := d.readiness(time.Now())
+		if len(problems) > 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintln(w, strings.Join(problems, "\n"))
+			return
+		}
+		fmt.Fprintln(w, "ready")
+	})
+	mux.HandleFunc("GET /status", d.serveStatus)
+	return mux
+}
+
+// serveHealth serves the probe endpoints on addr until ctx is cancelled.
+func (d *DDNSUpdater) serveHealth(ctx context.Context, addr string) error {
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           d.healthHandler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	d.logger.Info("Health endpoints listening", "address", addr)
+	return serveHTTP(ctx, server)
+}
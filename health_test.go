@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestHealthEndpoints tests that /readyz reports stages that haven't run, are overdue or failed, while /healthz stays up
+func TestHealthEndpoints(t *testing.T) {
+	updater := newPlanTestUpdater(t, newFakeDreamhost(), "", DomainConfig{Name: "example.com", Record: "home", Type: "A"})
+	var detectErr error
+	updater.detection = NewStage("detection", time.Minute, time.Minute, func(context.Context) error { return detectErr }, nil)
+	updater.providers[DefaultProvider].stage = NewStage("publication:dreamhost", time.Hour, time.Minute, func(context.Context) error { return nil }, nil)
+	handler := updater.healthHandler()
+
+	get := func(path string) (int, string) {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec.Code, rec.Body.String()
+	}
+
+	if code, _ := get("/healthz"); code != http.StatusOK {
+		t.Errorf("expected /healthz to answer 200, got %d", code)
+	}
+	if code, body := get("/readyz"); code != http.StatusServiceUnavailable || !strings.Contains(body, "detection: hasn't run yet") {
+		t.Errorf("expected not ready before the first check, got %d: %s", code, body)
+	}
+
+	updater.detection.Execute(context.Background())
+	updater.providers[DefaultProvider].stage.Execute(context.Background())
+	if code, body := get("/readyz"); code != http.StatusOK {
+		t.Errorf("expected ready, got %d: %s", code, body)
+	}
+
+	detectErr = errors.New("getting current IP: timeout")
+	updater.detection.Execute(context.Background())
+	if code, body := get("/readyz"); code != http.StatusServiceUnavailable || !strings.Contains(body, "detection: last run failed: getting current IP: timeout") {
+		t.Errorf("expected not ready after a failed check, got %d: %s", code, body)
+	}
+
+	detectErr = nil
+	updater.detection.Execute(context.Background())
+	problems := updater.readiness(time.Now().Add(3 * time.Minute))
+	if len(problems) != 1 || !strings.Contains(problems[0], "more than twice its interval of 1m0s") {
+		t.Errorf("expected the overdue check reported, got %q", problems)
+	}
+
+	updater.config.Webhook.DisablePolling = true
+	if problems := updater.readiness(time.Now().Add(3 * time.Minute)); len(problems) != 0 {
+		t.Errorf("expected detection ignored when polling is disabled, got %q", problems)
+	}
+	if code, _ := get("/update"); code != http.StatusNotFound {
+		t.Errorf("expected other paths not found, got %d", code)
+	}
+}
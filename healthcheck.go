@@ -0,0 +1,82 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// runHealthcheckCommand asks the running daemon whether it is healthy, for
+// a container's HEALTHCHECK, which then needs no curl or wget in the
+// image. It exits 0 if the daemon is ready (or, with --live, serving) and 1
+// if it isn't or doesn't answer. The daemon is asked on health.listen, or
+// at --url.
+//
+//	dh-ddns-updater healthcheck [--live] [--url url] [--profile name] [config]
+func runHealthcheckCommand(args []string) int {
+	fs := flag.NewFlagSet("healthcheck", flag.ContinueOnError)
+	opts := configFlags(fs)
+	live := fs.Bool("live", false, "only check that the daemon is serving (/healthz), not that it is ready (/readyz)")
+	url := fs.String("url", "", "ask the health endpoint at this URL instead of health.listen's, e.g. http://localhost:8080/readyz")
+	timeout := fs.Duration("timeout", statusQueryTimeout, "give up waiting for the daemon after this long")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: dh-ddns-updater healthcheck [--live] [--url url] [--profile name] [config]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	if *url == "" {
+		config, err := loadConfig(commandConfigPath(fs), *opts)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to load config: %v\n", err)
+			return 1
+		}
+		applyConfigDefaults(config)
+		if config.Health.Listen == "" {
+			fmt.Fprintln(os.Stderr, "health.listen isn't set, so the daemon has no health endpoint to ask")
+			return 1
+		}
+		path := "/readyz"
+		if *live {
+			path = "/healthz"
+		}
+		*url = healthURL(config.Health.Listen, path)
+	}
+
+	answer, err := checkHealth(*url, *timeout)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unhealthy: %v\n", err)
+		return 1
+	}
+	fmt.Println(answer)
+	return 0
+}
+
+// checkHealth asks the health endpoint at url and returns its answer, or
+// an error holding it unless the endpoint answered 200.
+func checkHealth(url string, timeout time.Duration) (string, error) {
+	client := &http.Client{Timeout: timeout}
+	resp, err := client.Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return "", err
+	}
+	answer := strings.TrimSpace(string(body))
+	if resp.StatusCode != http.StatusOK {
+		if answer == "" {
+			return "", fmt.Errorf("%s answered %s", url, resp.Status)
+		}
+		return "", fmt.Errorf("%s answered %s:\n%s", url, resp.Status, answer)
+	}
+	return answer, nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestCheckHealth tests that only a 200 answer is healthy, and that the
+// problems the daemon lists are passed on
+func TestCheckHealth(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/readyz":
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintln(w, "detection: last run failed: timeout")
+		case "/healthz":
+			fmt.Fprintln(w, "ok")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	if answer, err := checkHealth(server.URL+"/healthz", time.Second); err != nil || answer != "ok" {
+		t.Errorf("expected ok, got %q, %v", answer, err)
+	}
+	if _, err := checkHealth(server.URL+"/readyz", time.Second); err == nil || !strings.Contains(err.Error(), "detection: last run failed") {
+		t.Errorf("expected the readiness problems, got %v", err)
+	}
+
+	server.Close()
+	if _, err := checkHealth(server.URL+"/healthz", time.Second); err == nil {
+		t.Error("expected an error when the daemon doesn't answer")
+	}
+}
+
+// TestRunHealthcheckCommand tests the exit codes of the healthcheck
+// command asking the endpoint health.listen names
+func TestRunHealthcheckCommand(t *testing.T) {
+	ready := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/readyz" && !ready {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	config := filepath.Join(dir, "config.yaml")
+	os.WriteFile(config, []byte(`dreamhost_api_key: key
+health:
+  listen: `+strings.TrimPrefix(server.URL, "http://")+`
+domains:
+  - name: example.com
+    type: A
+`), 0600)
+	noHealth := filepath.Join(dir, "no-health.yaml")
+	os.WriteFile(noHealth, []byte("dreamhost_api_key: key\ndomains:\n  - name: example.com\n    type: A\n"), 0600)
+
+	if code := runHealthcheckCommand([]string{config}); code != 0 {
+		t.Errorf("expected 0 while ready, got %d", code)
+	}
+	ready = false
+	if code := runHealthcheckCommand([]string{config}); code != 1 {
+		t.Errorf("expected 1 while not ready, got %d", code)
+	}
+	if code := runHealthcheckCommand([]string{"--live", config}); code != 0 {
+		t.Errorf("expected 0 for --live while serving, got %d", code)
+	}
+	if code := runHealthcheckCommand([]string{noHealth}); code != 1 {
+		t.Errorf("expected 1 without health.listen, got %d", code)
+	}
+	if code := runHealthcheckCommand([]string{"--url", server.URL + "/healthz"}); code != 0 {
+		t.Errorf("expected 0 for --url, got %d", code)
+	}
+	if code := runHealthcheckCommand([]string{"--bogus"}); code != 2 {
+		t.Errorf("expected 2 for a bad flag, got %d", code)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// HeartbeatConfig configures dead man's switch pings to a monitoring
+// service such as healthchecks.io or an Uptime Kuma push monitor, which
+// alerts when the pings stop: when the daemon, or the machine it runs on,
+// dies.
+type HeartbeatConfig struct {
+	URL     string `yaml:"url"`      // Pinged while the daemon is healthy; empty disables heartbeats
+	URLFile string `yaml:"url_file"` // File holding url instead
+	// FailURL is pinged instead, with the problems as the body, while the
+	// IP can't be checked or records can't be published (default url with
+	// /fail appended to its path, as healthchecks.io expects).
+	FailURL  string        `yaml:"fail_url"`
+	Interval time.Duration `yaml:"interval"` // Time between pings (default check_interval)
+}
+
+// defaultFailURL returns url with /fail appended to its path.
+func defaultFailURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL + "/fail"
+	}
+	u.Path = strings.TrimRight(u.Path, "/") + "/fail"
+	return u.String()
+}
+
+// validateHeartbeat checks the heartbeat block.
+func validateHeartbeat(config HeartbeatConfig) error {
+	if config.URL == "" {
+		return nil
+	}
+	if err := validateHTTPURL("url", config.URL); err != nil {
+		return fmt.Errorf("heartbeat: %w", err)
+	}
+	if err := validateHTTPURL("fail_url", config.FailURL); err != nil {
+		return fmt.Errorf("heartbeat: %w", err)
+	}
+	if config.Interval < 0 {
+		return errors.New("heartbeat: interval must not be negative")
+	}
+	return nil
+}
+
+// sendHeartbeat pings the heartbeat URL if the daemon is ready, and
+// otherwise the fail URL with why it isn't; see readiness.
+func (d *DDNSUpdater) sendHeartbeat(ctx context.Context) error {
+	target, body := d.config.Heartbeat.URL, "ok"
+	if problems := d.readiness(time.Now()); len(problems) > 0 {
+		target, body = d.config.Heartbeat.FailURL, strings.Join(problems, "\n")
+	}
+	if err := postNotification(ctx, d.httpClient, target, "text/plain; charset=utf-8", []byte(body), nil); err != nil {
+		return fmt.Errorf("sending heartbeat: %w", err)
+	}
+	return nil
+}
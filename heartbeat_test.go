@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestDefaultFailURL tests that /fail is appended to the path of the URL
+func TestDefaultFailURL(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"https://hc-ping.com/0f3c2b9e-1d2a-4c55-9e7b-6f1a2b3c4d5e", "https://hc-ping.com/0f3c2b9e-1d2a-4c55-9e7b-6f1a2b3c4d5e/fail"},
+		{"https://hc-ping.com/key/home-dns/", "https://hc-ping.com/key/home-dns/fail"},
+		{"https://kuma.lan/api/push/abc?status=up", "https://kuma.lan/api/push/abc/fail?status=up"},
+	}
+	for _, tt := range tests {
+		if got := defaultFailURL(tt.url); got != tt.want {
+			t.Errorf("%s: expected %s, got %s", tt.url, tt.want, got)
+		}
+	}
+}
+
+// TestSendHeartbeat tests that the heartbeat pings the URL while the
+// daemon is ready and the fail URL with its problems while it isn't
+func TestSendHeartbeat(t *testing.T) {
+	var path, body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		path, body = r.URL.Path, string(b)
+	}))
+	defer server.Close()
+
+	updater := newPlanTestUpdater(t, newFakeDreamhost(), "", DomainConfig{Name: "example.com", Record: "home", Type: "A"})
+	updater.config.Heartbeat = HeartbeatConfig{URL: server.URL + "/ping/key", FailURL: defaultFailURL(server.URL + "/ping/key")}
+	var detectErr error
+	updater.detection = NewStage("detection", time.Minute, time.Minute, func(context.Context) error { return detectErr }, nil)
+	updater.providers[DefaultProvider].stage = NewStage("publication:dreamhost", time.Hour, time.Minute, func(context.Context) error { return nil }, nil)
+	updater.detection.Execute(context.Background())
+	updater.providers[DefaultProvider].stage.Execute(context.Background())
+
+	if err := updater.sendHeartbeat(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if path != "/ping/key" || body != "ok" {
+		t.Errorf("expected a ping while ready, got %s: %q", path, body)
+	}
+
+	detectErr = errors.New("getting current IP: timeout")
+	updater.detection.Execute(context.Background())
+	if err := updater.sendHeartbeat(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if path != "/ping/key/fail" || !strings.Contains(body, "detection: last run failed: getting current IP: timeout") {
+		t.Errorf("expected a failure ping with the problem, got %s: %q", path, body)
+	}
+
+	server.Close()
+	err := updater.sendHeartbeat(context.Background())
+	if err == nil || strings.Contains(err.Error(), "/ping/key") {
+		t.Errorf("expected an error without the URL, got %v", err)
+	}
+}
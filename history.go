@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// DefaultHistorySize is how many IP changes the state keeps unless
+// history_size says otherwise.
+const DefaultHistorySize = 100
+
+// IPChange is an entry of the IP change history kept in the state.
+type IPChange struct {
+	Time           time.Time `json:"time"`            // When the records were first updated to the new IP
+	OldIP          string    `json:"old_ip"`          // Public IP before the change
+	NewIP          string    `json:"new_ip"`          // Public IP after the change
+	RecordsUpdated int       `json:"records_updated"` // Records updated to the new IP
+}
+
+// recordIPChange notes in the history that updated records were brought
+// up to ip by a successful apply. A new entry is added when ip differs
+// from the last known IP; otherwise the updates are counted towards the
+// current entry, since every provider's publication applies the same
+// change. The history keeps the newest history_size entries. The caller
+// holds stateMu.
+func (d *DDNSUpdater) recordIPChange(ip string, updated int, now time.Time) {
+	history := d.state.History
+	switch {
+	case ip != d.state.LastIP && d.state.LastIP != "":
+		history = append(history, IPChange{Time: now, OldIP: d.state.LastIP, NewIP: ip, RecordsUpdated: updated})
+	case ip == d.state.LastIP && len(history) > 0 && history[len(history)-1].NewIP == ip:
+		history[len(history)-1].RecordsUpdated += updated
+	}
+	if size := d.config.HistorySize; size > 0 && len(history) > size {
+		history = history[len(history)-size:]
+	}
+	d.state.History = history
+}
+
+// printHistory writes the IP change history as a table, followed by how
+// often the IP changed.
+func printHistory(w io.Writer, history []IPChange) {
+	if len(history) == 0 {
+		fmt.Fprintln(w, "No IP changes recorded.")
+		return
+	}
+	for _, change := range history {
+		fmt.Fprintf(w, "%s  %s -> %s  (%d records updated)\n",
+			change.Time.Local().Format(time.DateTime), change.OldIP, change.NewIP, change.RecordsUpdated)
+	}
+
+	first, last := history[0].Time, history[len(history)-1].Time
+	fmt.Fprintf(w, "\n%d changes since %s", len(history), first.Local().Format(time.DateTime))
+	if len(history) > 1 {
+		average := last.Sub(first) / time.Duration(len(history)-1)
+		fmt.Fprintf(w, ", on average every %s", average.Round(time.Minute))
+	}
+	fmt.Fprintln(w)
+}
+
+// runHistoryCommand prints the IP change history kept in the state.
+//
+//	dh-ddns-updater history [--json] [--profile name] [config]
+func runHistoryCommand(args []string) int {
+	fs := flag.NewFlagSet("history", flag.ContinueOnError)
+	opts := configFlags(fs)
+	asJSON := fs.Bool("json", false, "print the history as JSON")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: dh-ddns-updater history [--json] [--profile name] [config]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	config, err := loadConfig(commandConfigPath(fs), *opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load config: %v\n", err)
+		return 1
+	}
+	applyConfigDefaults(config)
+	state, err := stateStoreFor(config).Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load state: %v\n", err)
+		return 1
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(append([]IPChange{}, state.History...))
+		return 0
+	}
+	printHistory(os.Stdout, state.History)
+	return 0
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestRecordIPChange tests adding, counting towards and trimming IP change history entries
+func TestRecordIPChange(t *testing.T) {
+	start := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	d := &DDNSUpdater{config: &Config{HistorySize: 2}, state: &State{}}
+	apply := func(ip string, updated int, at time.Duration) {
+		d.recordIPChange(ip, updated, start.Add(at))
+		d.state.LastIP = ip
+	}
+
+	apply("203.0.113.1", 2, 0)         // First IP: not a change
+	apply("203.0.113.2", 2, time.Hour) // Change
+	apply("203.0.113.2", 1, time.Hour) // Another provider's records
+	apply("203.0.113.2", 0, 2*time.Hour)
+	apply("203.0.113.3", 3, 3*time.Hour)
+	apply("203.0.113.4", 3, 4*time.Hour) // Trims the oldest entry
+
+	want := []IPChange{
+		{Time: start.Add(3 * time.Hour), OldIP: "203.0.113.2", NewIP: "203.0.113.3", RecordsUpdated: 3},
+		{Time: start.Add(4 * time.Hour), OldIP: "203.0.113.3", NewIP: "203.0.113.4", RecordsUpdated: 3},
+	}
+	if len(d.state.History) != len(want) {
+		t.Fatalf("expected %d entries, got %+v", len(want), d.state.History)
+	}
+	for i := range want {
+		if d.state.History[i] != want[i] {
+			t.Errorf("entry %d: expected %+v, got %+v", i, want[i], d.state.History[i])
+		}
+	}
+
+	d = &DDNSUpdater{config: &Config{HistorySize: 10}, state: &State{LastIP: "203.0.113.1"}}
+	apply("203.0.113.2", 2, 0)
+	apply("203.0.113.2", 1, time.Minute)
+	if got := d.state.History[0].RecordsUpdated; got != 3 {
+		t.Errorf("expected updates by every provider counted, got %d", got)
+	}
+}
+
+// TestPrintHistory tests the history table and its summary of how often the IP changes
+func TestPrintHistory(t *testing.T) {
+	start := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	var out bytes.Buffer
+	printHistory(&out, []IPChange{
+		{Time: start, OldIP: "203.0.113.1", NewIP: "203.0.113.2", RecordsUpdated: 2},
+		{Time: start.Add(36 * time.Hour), OldIP: "203.0.113.2", NewIP: "203.0.113.3", RecordsUpdated: 2},
+		{Time: start.Add(72 * time.Hour), OldIP: "203.0.113.3", NewIP: "203.0.113.4", RecordsUpdated: 1},
+	})
+	for _, want := range []string{"203.0.113.1 -> 203.0.113.2  (2 records updated)", "3 changes since", "on average every 36h0m0s"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("expected %q in:\n%s", want, out.String())
+		}
+	}
+
+	out.Reset()
+	printHistory(&out, nil)
+	if !strings.Contains(out.String(), "No IP changes recorded") {
+		t.Errorf("unexpected output for no history:\n%s", out.String())
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// DefaultDiscoveryPrefix is Home Assistant's MQTT discovery prefix unless
+// discovery_prefix says otherwise.
+const DefaultDiscoveryPrefix = "homeassistant"
+
+// homeAssistantState is the status published for Home Assistant's sensors,
+// retained at <topic>/status.
+type homeAssistantState struct {
+	IP          string   `json:"ip"`           // The public IP as last detected, or else as records were last updated to
+	LastUpdated string   `json:"last_updated"` // When records were last updated, RFC 3339; empty if never
+	Problem     string   `json:"problem"`      // ON while the updater isn't ready, as the binary sensor expects
+	Problems    []string `json:"problems"`     // Why not; see readiness
+}
+
+// homeAssistantEntity is an entity announced through MQTT discovery.
+type homeAssistantEntity struct {
+	component  string // sensor or binary_sensor
+	id         string
+	attributes bool           // Show the whole state as the entity's attributes
+	config     map[string]any // Settings of the entity besides those every entity has
+}
+
+var homeAssistantEntities = []homeAssistantEntity{
+	{"sensor", "public_ip", false, map[string]any{
+		"name":           "Public IP",
+		"icon":           "mdi:ip-network",
+		"value_template": "{{ value_json.ip or None }}",
+	}},
+	{"sensor", "last_updated", false, map[string]any{
+		"name":           "Last update",
+		"device_class":   "timestamp",
+		"value_template": "{{ value_json.last_updated or None }}",
+	}},
+	{"binary_sensor", "problem", true, map[string]any{
+		"name":           "Problem",
+		"device_class":   "problem",
+		"value_template": "{{ value_json.problem }}",
+	}},
+}
+
+// homeAssistantMessages returns the retained messages announcing the
+// updater's entities to Home Assistant and reporting their state.
+// expireAfter is how long Home Assistant keeps the state without hearing
+// from the updater again before showing the entities as unavailable.
+func (m *mqttNotifier) homeAssistantMessages(state homeAssistantState, expireAfter time.Duration) ([]mqttMessage, error) {
+	prefix := m.config.DiscoveryPrefix
+	if prefix == "" {
+		prefix = DefaultDiscoveryPrefix
+	}
+	node := homeAssistantNodeID(m.clientID)
+	stateTopic := m.config.Topic + "/status"
+	host, _ := os.Hostname()
+	device := map[string]any{
+		"identifiers": []string{node},
+		"name":        "DDNS updater " + host,
+		"model":       "dh-ddns-updater",
+		"sw_version":  version,
+	}
+
+	var messages []mqttMessage
+	for _, entity := range homeAssistantEntities {
+		config := map[string]any{
+			"unique_id":    node + "_" + entity.id,
+			"state_topic":  stateTopic,
+			"expire_after": int(expireAfter.Seconds()),
+			"device":       device,
+		}
+		for key, value := range entity.config {
+			config[key] = value
+		}
+		if entity.attributes {
+			config["json_attributes_topic"] = stateTopic
+		}
+		payload, err := json.Marshal(config)
+		if err != nil {
+			return nil, err
+		}
+		topic := fmt.Sprintf("%s/%s/%s/%s/config", prefix, entity.component, node, entity.id)
+		messages = append(messages, mqttMessage{topic: topic, payload: payload, retain: true})
+	}
+
+	payload, err := json.Marshal(state)
+	if err != nil {
+		return nil, err
+	}
+	return append(messages, mqttMessage{topic: stateTopic, payload: payload, retain: true}), nil
+}
+
+// homeAssistantNodeID returns clientID made safe as a discovery node ID,
+// which allows only letters, digits, - and _.
+func homeAssistantNodeID(clientID string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+			return r
+		}
+		return '_'
+	}, clientID)
+}
+
+// homeAssistantState returns the state to report to Home Assistant at now.
+func (d *DDNSUpdater) homeAssistantState(now time.Time) homeAssistantState {
+	state := homeAssistantState{Problem: "OFF", Problems: d.readiness(now)}
+	d.stateMu.Lock()
+	state.IP = d.state.LastIP
+	if !d.state.LastUpdated.IsZero() {
+		state.LastUpdated = d.state.LastUpdated.Format(time.RFC3339)
+	}
+	d.stateMu.Unlock()
+	if desired, ok := d.desired.Get(DefaultSource); ok && desired.Value != "" {
+		state.IP = desired.Value
+	}
+	if len(state.Problems) > 0 {
+		state.Problem = "ON"
+	} else {
+		state.Problems = []string{}
+	}
+	return state
+}
+
+// publishHomeAssistant announces the updater's entities and reports their
+// state to each MQTT broker with home_assistant set. The announcements are
+// repeated every time, so the entities come back after Home Assistant or
+// the broker loses them.
+func (d *DDNSUpdater) publishHomeAssistant(ctx context.Context) error {
+	state := d.homeAssistantState(time.Now())
+	// Long enough to ride out a missed run or a restart
+	expireAfter := 3 * d.homeAssistant.Interval
+	var errs []error
+	for _, m := range d.homeAssistantNotifiers() {
+		messages, err := m.homeAssistantMessages(state, expireAfter)
+		if err == nil {
+			m.mu.Lock()
+			err = m.publish(ctx, messages...)
+			m.mu.Unlock()
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("mqtt %s: %w", m.config.Broker, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// homeAssistantNotifiers returns the MQTT notifiers with home_assistant
+// set. They are the notifiers events go through, so that the two never
+// connect at once with the same client ID.
+func (d *DDNSUpdater) homeAssistantNotifiers() []*mqttNotifier {
+	if d.notifications == nil {
+		return nil
+	}
+	var notifiers []*mqttNotifier
+	for _, t := range d.notifications.targets {
+		if m, ok := t.notifier.(*mqttNotifier); ok && m.config.HomeAssistant {
+			notifiers = append(notifiers, m)
+		}
+	}
+	return notifiers
+}
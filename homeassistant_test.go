@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+)
+
+// TestHomeAssistantMessages tests the discovery configs announcing the
+// sensors and the retained state they read
+func TestHomeAssistantMessages(t *testing.T) {
+	notifier := newMQTTNotifier(MQTTNotificationConfig{ClientID: "ddns.home", Topic: "home/ddns", HomeAssistant: true})
+	state := homeAssistantState{IP: "203.0.113.42", Problem: "OFF", Problems: []string{}}
+
+	messages, err := notifier.homeAssistantMessages(state, 15*time.Minute)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var topics []string
+	for _, msg := range messages {
+		if !msg.retain {
+			t.Errorf("%s: expected a retained message", msg.topic)
+		}
+		topics = append(topics, msg.topic)
+	}
+	want := []string{
+		"homeassistant/sensor/ddns_home/public_ip/config",
+		"homeassistant/sensor/ddns_home/last_updated/config",
+		"homeassistant/binary_sensor/ddns_home/problem/config",
+		"home/ddns/status",
+	}
+	if !slices.Equal(topics, want) {
+		t.Fatalf("expected topics %v, got %v", want, topics)
+	}
+
+	var config struct {
+		UniqueID            string `json:"unique_id"`
+		StateTopic          string `json:"state_topic"`
+		ExpireAfter         int    `json:"expire_after"`
+		DeviceClass         string `json:"device_class"`
+		JSONAttributesTopic string `json:"json_attributes_topic"`
+		Device              struct {
+			Identifiers []string `json:"identifiers"`
+		} `json:"device"`
+	}
+	if err := json.Unmarshal(messages[2].payload, &config); err != nil {
+		t.Fatalf("decoding config: %v", err)
+	}
+	if config.UniqueID != "ddns_home_problem" || config.StateTopic != "home/ddns/status" || config.ExpireAfter != 900 ||
+		config.DeviceClass != "problem" || config.JSONAttributesTopic != "home/ddns/status" || !slices.Equal(config.Device.Identifiers, []string{"ddns_home"}) {
+		t.Errorf("unexpected problem sensor config %s", messages[2].payload)
+	}
+	if got := string(messages[3].payload); got != `{"ip":"203.0.113.42","last_updated":"","problem":"OFF","problems":[]}` {
+		t.Errorf("unexpected state %s", got)
+	}
+}
+
+// TestPublishHomeAssistant tests that the state published reports the
+// detected IP, the last update and the updater's problems
+func TestPublishHomeAssistant(t *testing.T) {
+	broker, addr := newFakeMQTT(t, "")
+	updater := newPlanTestUpdater(t, newFakeDreamhost(), "203.0.113.42", DomainConfig{Name: "example.com", Record: "home", Type: "A"})
+	var err error
+	updater.notifications, err = newNotifications(NotificationsConfig{MQTT: []MQTTNotificationConfig{
+		{Broker: addr, Topic: "ddns", QoS: 1, HomeAssistant: true},
+		{Broker: addr, Topic: "events-only"},
+	}}, &http.Client{}, slog.New(slog.NewJSONHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	updater.state.LastUpdated = time.Date(2026, 10, 16, 8, 30, 0, 0, time.UTC)
+	updater.detection = NewStage("detection", time.Minute, time.Minute, func(context.Context) error { return errors.New("getting current IP: timeout") }, nil)
+	updater.providers[DefaultProvider].stage = NewStage("publication:dreamhost", time.Hour, time.Minute, func(context.Context) error { return nil }, nil)
+	updater.detection.Execute(context.Background())
+	updater.providers[DefaultProvider].stage.Execute(context.Background())
+	updater.homeAssistant = NewStage("home_assistant", 5*time.Minute, time.Minute, updater.publishHomeAssistant, nil)
+
+	if err := updater.homeAssistant.Execute(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	messages, _ := broker.published(4)
+	if len(messages) != 4 {
+		t.Fatalf("expected only the broker with home_assistant to get 4 messages, got %+v", messages)
+	}
+	var state homeAssistantState
+	if err := json.Unmarshal([]byte(messages[3].payload), &state); err != nil {
+		t.Fatalf("decoding state: %v", err)
+	}
+	want := homeAssistantState{
+		IP:          "203.0.113.42",
+		LastUpdated: "2026-10-16T08:30:00Z",
+		Problem:     "ON",
+		Problems:    []string{"detection: last run failed: getting current IP: timeout"},
+	}
+	if messages[3].topic != "ddns/status" || state.IP != want.IP || state.LastUpdated != want.LastUpdated || state.Problem != want.Problem || !slices.Equal(state.Problems, want.Problems) {
+		t.Errorf("expected %+v at ddns/status, got %+v at %s", want, state, messages[3].topic)
+	}
+}
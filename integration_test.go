@@ -21,13 +21,14 @@ func TestRealIPService(t *testing.T) {
 	}
 
 	updater := &DDNSUpdater{
-		httpClient: &http.Client{Timeout: 10 * time.Second},
+		httpClient:      &http.Client{Timeout: 10 * time.Second},
+		detectionClient: &http.Client{Timeout: 10 * time.Second},
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
 	defer cancel()
 
-	ip, err := updater.getCurrentIP(ctx)
+	ip, err := updater.getCurrentIP(ctx, updater.detectionClient)
 	if err != nil {
 		t.Fatalf("failed to get current IP: %v", err)
 	}
@@ -56,7 +57,7 @@ func TestConfigValidation(t *testing.T) {
 		t.Skip("config.yaml not found, skipping config validation test")
 	}
 
-	config, err := loadConfig(configPath)
+	config, err := loadConfig(configPath, configOptions{})
 	if err != nil {
 		t.Fatalf("failed to load config: %v", err)
 	}
@@ -121,17 +122,18 @@ func TestFullCycle(t *testing.T) {
 	}
 
 	updater := &DDNSUpdater{
-		config:     config,
-		state:      state,
-		httpClient: &http.Client{Timeout: 30 * time.Second},
-		logger:     slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug})),
+		config:          config,
+		state:           state,
+		httpClient:      &http.Client{Timeout: 30 * time.Second},
+		detectionClient: &http.Client{Timeout: 30 * time.Second},
+		logger:          slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug})),
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
 	defer cancel()
 
 	// Test IP fetching
-	ip, err := updater.getCurrentIP(ctx)
+	ip, err := updater.getCurrentIP(ctx, updater.detectionClient)
 	if err != nil {
 		t.Fatalf("failed to get current IP: %v", err)
 	}
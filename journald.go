@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// JournaldConfig configures logging to the systemd journal through its
+// native protocol, which keeps each log attribute as a journal field (IP,
+// RECORD, ...) that journalctl can filter on.
+type JournaldConfig struct {
+	Enabled bool `yaml:"enabled"` // Log to the journal
+	Stdout  bool `yaml:"stdout"`  // Log JSON lines to stdout as well
+}
+
+// journalSocket is where journald receives entries.
+var journalSocket = "/run/systemd/journal/socket"
+
+// journalIdentifier is the SYSLOG_IDENTIFIER of the daemon's entries, which
+// journalctl -t selects.
+const journalIdentifier = "dh-ddns-updater"
+
+// journalHandler is a slog handler sending each record to the journal as
+// an entry: the message as MESSAGE, the level as PRIORITY and every
+// attribute as a field named after its key, uppercased, with groups
+// joined by underscores.
+type journalHandler struct {
+	journal *journalWriter
+	level   slog.Leveler
+	prefix  string   // Field name prefix of the open groups
+	fields  []string // Fields of the attributes given to WithAttrs, encoded
+}
+
+func (h *journalHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *journalHandler) Handle(_ context.Context, r slog.Record) error {
+	var entry bytes.Buffer
+	appendJournalField(&entry, "MESSAGE", r.Message)
+	appendJournalField(&entry, "PRIORITY", strconv.Itoa(syslogSeverity(r.Level.String())))
+	appendJournalField(&entry, "SYSLOG_IDENTIFIER", journalIdentifier)
+	if r.Level == LevelTrace {
+		appendJournalField(&entry, "LEVEL", "TRACE")
+	} else {
+		appendJournalField(&entry, "LEVEL", r.Level.String())
+	}
+	for _, field := range h.fields {
+		entry.WriteString(field)
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		appendJournalAttr(&entry, h.prefix, a)
+		return true
+	})
+	h.journal.send(entry.Bytes())
+	return nil
+}
+
+func (h *journalHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	var b bytes.Buffer
+	for _, a := range attrs {
+		appendJournalAttr(&b, h.prefix, a)
+	}
+	h2 := *h
+	h2.fields = append(h.fields[:len(h.fields):len(h.fields)], b.String())
+	return &h2
+}
+
+func (h *journalHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	h2 := *h
+	h2.prefix = h.prefix + name + "_"
+	return &h2
+}
+
+// appendJournalAttr appends the fields of an attribute, one per member if it
+// is a group.
+func appendJournalAttr(b *bytes.Buffer, prefix string, a slog.Attr) {
+	v := a.Value.Resolve()
+	switch v.Kind() {
+	case slog.KindGroup:
+		if a.Key != "" {
+			prefix += a.Key + "_"
+		}
+		for _, member := range v.Group() {
+			appendJournalAttr(b, prefix, member)
+		}
+	case slog.KindTime:
+		appendJournalField(b, prefix+a.Key, v.Time().Format(time.RFC3339Nano))
+	default:
+		appendJournalField(b, prefix+a.Key, v.String())
+	}
+}
+
+// appendJournalField appends a field to an entry in the journal's native
+// format: NAME=value and a newline, or for values spanning lines, the name,
+// a newline, the value's length as a little-endian 64-bit integer, the
+// value and a newline. Names are made valid field names, and fields whose
+// name can't be are dropped.
+func appendJournalField(b *bytes.Buffer, name, value string) {
+	name = journalFieldName(name)
+	if name == "" {
+		return
+	}
+	b.WriteString(name)
+	if !strings.Contains(value, "\n") {
+		b.WriteByte('=')
+		b.WriteString(value)
+		b.WriteByte('\n')
+		return
+	}
+	b.WriteByte('\n')
+	binary.Write(b, binary.LittleEndian, uint64(len(value)))
+	b.WriteString(value)
+	b.WriteByte('\n')
+}
+
+// journalFieldName returns name as a journal field name: uppercase
+// letters, digits and underscores, not starting with an underscore (which
+// journald reserves) or a digit, and at most 64 characters.
+func journalFieldName(name string) string {
+	var b strings.Builder
+	for _, c := range strings.ToUpper(name) {
+		switch {
+		case c >= 'A' && c <= 'Z', c >= '0' && c <= '9' && b.Len() > 0:
+			b.WriteRune(c)
+		case b.Len() > 0:
+			b.WriteByte('_')
+		}
+	}
+	return strings.TrimRight(b.String()[:min(b.Len(), 64)], "_")
+}
+
+// journalWriter sends entries to journald. Like syslogWriter, it connects
+// when first used and drops entries for a while after a failure, which it
+// reports on stderr.
+type journalWriter struct {
+	mu      sync.Mutex
+	conn    net.Conn
+	failing bool
+	retryAt time.Time
+}
+
+func (w *journalWriter) send(entry []byte) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.conn == nil && time.Now().Before(w.retryAt) {
+		return
+	}
+	err := w.write(entry)
+	if err != nil {
+		if !w.failing {
+			fmt.Fprintf(os.Stderr, "Failed to send logs to journald, will retry: %v\n", err)
+		}
+		if w.conn != nil {
+			w.conn.Close()
+			w.conn = nil
+		}
+		w.retryAt = time.Now().Add(syslogRetryInterval)
+	}
+	w.failing = err != nil
+}
+
+func (w *journalWriter) write(entry []byte) error {
+	if w.conn == nil {
+		conn, err := net.Dial("unixgram", journalSocket)
+		if err != nil {
+			return fmt.Errorf("connecting to journald: %w", err)
+		}
+		w.conn = conn
+	}
+	_, err := w.conn.Write(entry)
+	return err
+}
+
+// Close closes the connection.
+func (w *journalWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.conn == nil {
+		return nil
+	}
+	err := w.conn.Close()
+	w.conn = nil
+	return err
+}
+
+// fanoutHandler passes each record to all of its handlers.
+type fanoutHandler []slog.Handler
+
+func (h fanoutHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, handler := range h {
+		if handler.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (h fanoutHandler) Handle(ctx context.Context, r slog.Record) error {
+	var errs []error
+	for _, handler := range h {
+		if handler.Enabled(ctx, r.Level) {
+			if err := handler.Handle(ctx, r.Clone()); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (h fanoutHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	h2 := make(fanoutHandler, len(h))
+	for i, handler := range h {
+		h2[i] = handler.WithAttrs(attrs)
+	}
+	return h2
+}
+
+func (h fanoutHandler) WithGroup(name string) slog.Handler {
+	h2 := make(fanoutHandler, len(h))
+	for i, handler := range h {
+		h2[i] = handler.WithGroup(name)
+	}
+	return h2
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"log/slog"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// parseJournalEntry decodes an entry in the journal's native format.
+func parseJournalEntry(t *testing.T, data []byte) map[string]string {
+	t.Helper()
+	fields := make(map[string]string)
+	for len(data) > 0 {
+		i := bytes.IndexAny(data, "=\n")
+		if i < 0 {
+			t.Fatalf("malformed entry %q", data)
+		}
+		name := string(data[:i])
+		if data[i] == '=' {
+			end := bytes.IndexByte(data, '\n')
+			fields[name] = string(data[i+1 : end])
+			data = data[end+1:]
+			continue
+		}
+		n := binary.LittleEndian.Uint64(data[i+1:])
+		fields[name] = string(data[i+9 : i+9+int(n)])
+		data = data[i+9+int(n)+1:]
+	}
+	return fields
+}
+
+// TestJournalHandler tests that log records are sent to journald with their attributes as fields
+func TestJournalHandler(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "socket")
+	conn, err := net.ListenPacket("unixgram", socket)
+	if err != nil {
+		t.Skipf("no unix datagram sockets: %v", err)
+	}
+	defer conn.Close()
+	defer func(s string) { journalSocket = s }(journalSocket)
+	journalSocket = socket
+
+	journal := &journalWriter{}
+	defer journal.Close()
+	logger := slog.New(&journalHandler{journal: journal, level: slog.LevelInfo}).With("provider", "dreamhost")
+	logger.Debug("Not sent")
+	logger.Error("Failed to update record", "record", "home.example.com", "ip", "203.0.113.7",
+		"error", errors.New("line one\nline two"), slog.Group("cycle", "changes", 2, "started", time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)))
+	logger.WithGroup("http").Info("Request", "status", 200, "2fa", true)
+
+	buf := make([]byte, 65536)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var entries []map[string]string
+	for range 2 {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, parseJournalEntry(t, buf[:n]))
+	}
+
+	want := map[string]string{
+		"MESSAGE":           "Failed to update record",
+		"PRIORITY":          "3",
+		"SYSLOG_IDENTIFIER": "dh-ddns-updater",
+		"LEVEL":             "ERROR",
+		"PROVIDER":          "dreamhost",
+		"RECORD":            "home.example.com",
+		"IP":                "203.0.113.7",
+		"ERROR":             "line one\nline two",
+		"CYCLE_CHANGES":     "2",
+		"CYCLE_STARTED":     "2026-10-01T12:00:00Z",
+	}
+	for name, value := range want {
+		if entries[0][name] != value {
+			t.Errorf("expected %s=%q, got %q", name, value, entries[0][name])
+		}
+	}
+	if entries[1]["PRIORITY"] != "6" || entries[1]["HTTP_STATUS"] != "200" || entries[1]["HTTP_2FA"] != "true" || entries[1]["PROVIDER"] != "dreamhost" {
+		t.Errorf("unexpected entry %q", entries[1])
+	}
+}
+
+// TestJournalFieldName tests that attribute keys become valid journal field names
+func TestJournalFieldName(t *testing.T) {
+	tests := map[string]string{
+		"ip":                    "IP",
+		"check_interval":        "CHECK_INTERVAL",
+		"provider.name":         "PROVIDER_NAME",
+		"_private":              "PRIVATE",
+		"2fa":                   "FA",
+		"---":                   "",
+		strings.Repeat("a", 70): strings.Repeat("A", 64),
+	}
+	for name, want := range tests {
+		if got := journalFieldName(name); got != want {
+			t.Errorf("journalFieldName(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+// TestDaemonLogJournald tests that enabling journald logs there instead of stdout unless asked for both
+func TestDaemonLogJournald(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "socket")
+	if conn, err := net.ListenPacket("unixgram", socket); err == nil {
+		defer conn.Close()
+	}
+	defer func(s string) { journalSocket = s }(journalSocket)
+	journalSocket = socket
+
+	var stdout bytes.Buffer
+	logs := newDaemonLog(&stdout)
+	defer logs.Close()
+
+	logger, err := logs.logger(&Config{LogLevel: "info", Journald: JournaldConfig{Enabled: true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := logger.Handler().(contextHandler).Handler
+	if _, ok := handler.(*journalHandler); !ok {
+		t.Errorf("expected only the journal logged to, got %T", handler)
+	}
+
+	logger, err = logs.logger(&Config{LogLevel: "info", Journald: JournaldConfig{Enabled: true, Stdout: true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.Info("both")
+	if !strings.Contains(stdout.String(), `"msg":"both"`) {
+		t.Errorf("expected the line on stdout too, got %q", stdout.String())
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// keyringService is the service (or, on Windows, the target name prefix)
+// under which secrets are looked up in the platform keyring.
+const keyringService = "dh-ddns-updater"
+
+// readKeyringSecret sets *field from the platform keyring entry for
+// account, if account is set: the Secret Service on Linux and BSD, the
+// Keychain on macOS and the Credential Manager on Windows; see
+// keyringLookup. Setting the secret or its file as well is an error.
+func readKeyringSecret(field *string, account, file, name string) error {
+	if account == "" {
+		return nil
+	}
+	if *field != "" || file != "" {
+		return fmt.Errorf("%s_keyring can't be combined with %s or %s_file", name, name, name)
+	}
+	secret, err := keyringLookup(account)
+	if err != nil {
+		return fmt.Errorf("%s_keyring: reading %q from the keyring: %w", name, account, err)
+	}
+	*field = strings.TrimSpace(secret)
+	if *field == "" {
+		return fmt.Errorf("%s_keyring: keyring entry %q is empty", name, account)
+	}
+	return nil
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+)
+
+// securityCommand queries the Keychain.
+var securityCommand = "security"
+
+// keyringLookup returns the password of the Keychain generic password item
+// for service dh-ddns-updater and account, as stored by
+//
+//	security add-generic-password -s dh-ddns-updater -a <account> -w
+func keyringLookup(account string) (string, error) {
+	out, err := runSecretTool([]string{securityCommand, "find-generic-password", "-s", keyringService, "-a", account, "-w"})
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return "", fmt.Errorf("no Keychain item with service %s and account %s (%w)", keyringService, account, err)
+	}
+	return string(out), err
+}
@@ -0,0 +1,25 @@
+//go:build !windows && !darwin
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+)
+
+// secretToolCommand queries the Secret Service (GNOME Keyring, KWallet).
+var secretToolCommand = "secret-tool"
+
+// keyringLookup returns the Secret Service secret stored with the
+// attributes service=dh-ddns-updater and account, as by
+//
+//	secret-tool store --label='Dreamhost API key' service dh-ddns-updater account <account>
+func keyringLookup(account string) (string, error) {
+	out, err := runSecretTool([]string{secretToolCommand, "lookup", "service", keyringService, "account", account})
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return "", fmt.Errorf("no secret with service %s and account %s (%w)", keyringService, account, err)
+	}
+	return string(out), err
+}
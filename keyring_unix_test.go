@@ -0,0 +1,60 @@
+//go:build !windows && !darwin
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestKeyringSecret tests reading API keys from the Secret Service with secret-tool
+func TestKeyringSecret(t *testing.T) {
+	defer func(c string) { secretToolCommand = c }(secretToolCommand)
+	secretToolCommand = fakeTool(t, `[ "$*" = "lookup service dh-ddns-updater account dreamhost" ] || exit 1`+"\necho keyring-key\n")
+
+	tests := []struct {
+		name      string
+		config    *Config
+		wantKey   string
+		wantError string
+	}{
+		{
+			name:    "main key",
+			config:  &Config{DreamhostAPIKeyKeyring: "dreamhost"},
+			wantKey: "keyring-key",
+		},
+		{
+			name:      "missing entry",
+			config:    &Config{DreamhostAPIKeyKeyring: "other"},
+			wantError: "no secret with service dh-ddns-updater and account other",
+		},
+		{
+			name:      "combined with key",
+			config:    &Config{DreamhostAPIKey: "inline", DreamhostAPIKeyKeyring: "dreamhost"},
+			wantError: "dreamhost_api_key_keyring can't be combined",
+		},
+		{
+			name:      "provider key",
+			config:    &Config{Providers: map[string]ProviderConfig{"backup": {APIKeyKeyring: "other"}}},
+			wantError: `provider "backup": api_key_keyring`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := readSecretFiles(tt.config, t.TempDir())
+			if tt.wantError != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantError) {
+					t.Fatalf("expected error containing %q, got %v", tt.wantError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.config.DreamhostAPIKey != tt.wantKey {
+				t.Errorf("expected key %q, got %q", tt.wantKey, tt.config.DreamhostAPIKey)
+			}
+		})
+	}
+}
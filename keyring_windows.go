@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"syscall"
+	"unicode/utf16"
+	"unsafe"
+)
+
+var (
+	advapi32     = syscall.NewLazyDLL("advapi32.dll")
+	procCredRead = advapi32.NewProc("CredReadW")
+	procCredFree = advapi32.NewProc("CredFree")
+)
+
+// credTypeGeneric is CRED_TYPE_GENERIC.
+const credTypeGeneric = 1
+
+// credential mirrors the Win32 CREDENTIALW structure.
+type credential struct {
+	Flags              uint32
+	Type               uint32
+	TargetName         *uint16
+	Comment            *uint16
+	LastWritten        syscall.Filetime
+	CredentialBlobSize uint32
+	CredentialBlob     *byte
+	Persist            uint32
+	AttributeCount     uint32
+	Attributes         uintptr
+	TargetAlias        *uint16
+	UserName           *uint16
+}
+
+// keyringLookup returns the password of the Credential Manager generic
+// credential named dh-ddns-updater:<account>, as stored by
+//
+//	cmdkey /generic:dh-ddns-updater:<account> /user:<account> /pass
+func keyringLookup(account string) (string, error) {
+	target := keyringService + ":" + account
+	name, err := syscall.UTF16PtrFromString(target)
+	if err != nil {
+		return "", err
+	}
+	var cred *credential
+	if r, _, err := procCredRead.Call(uintptr(unsafe.Pointer(name)), credTypeGeneric, 0, uintptr(unsafe.Pointer(&cred))); r == 0 {
+		return "", fmt.Errorf("no credential %s: %w", target, err)
+	}
+	defer procCredFree.Call(uintptr(unsafe.Pointer(cred)))
+
+	blob := unsafe.Slice(cred.CredentialBlob, cred.CredentialBlobSize)
+	if len(blob)%2 != 0 {
+		return string(blob), nil
+	}
+	// cmdkey and the Credential Manager store passwords as UTF-16
+	units := make([]uint16, len(blob)/2)
+	for i := range units {
+		units[i] = uint16(blob[2*i]) | uint16(blob[2*i+1])<<8
+	}
+	return string(utf16.Decode(units)), nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import "time"
+
+// Defaults applied by the kubernetes setting.
+const (
+	// DefaultKubernetesHealthListen serves the probe endpoints to the
+	// kubelet, which reaches the Pod on its own IP.
+	DefaultKubernetesHealthListen = ":8080"
+
+	// DefaultKubernetesShutdownGracePeriod leaves an update in progress
+	// time to finish within the 30 seconds Kubernetes gives a container to
+	// exit after SIGTERM (terminationGracePeriodSeconds) before killing it.
+	DefaultKubernetesShutdownGracePeriod = 20 * time.Second
+)
+
+// applyKubernetesDefaults tunes the settings left unset for running in a
+// Pod, when kubernetes is set: the config and its secret files, mounted
+// from a ConfigMap and a Secret, are watched for the updates Kubernetes
+// makes to them; the probe endpoints are served; the state is kept only in
+// memory, the records being read from the provider on each start, unless
+// state_backend or state_path (such as an emptyDir) says where to keep it;
+// and an update in progress when the Pod stops is cut short before the
+// kubelet kills the container. It runs before the other defaults.
+func applyKubernetesDefaults(config *Config) {
+	if !config.Kubernetes {
+		return
+	}
+	if config.WatchConfig == nil {
+		watch := true
+		config.WatchConfig = &watch
+	}
+	if config.Health.Listen == "" {
+		config.Health.Listen = DefaultKubernetesHealthListen
+	}
+	if config.StateBackend == "" && config.StatePath == "" {
+		config.StateBackend = "none"
+	}
+	if config.ShutdownGracePeriod == 0 {
+		config.ShutdownGracePeriod = DefaultKubernetesShutdownGracePeriod
+	}
+}
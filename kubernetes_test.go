@@ -0,0 +1,76 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// TestApplyKubernetesDefaults tests the defaults kubernetes sets and that
+// settings given explicitly are kept
+func TestApplyKubernetesDefaults(t *testing.T) {
+	tests := []struct {
+		name        string
+		config      Config
+		wantBackend string
+		wantListen  string
+		wantGrace   time.Duration
+		wantWatch   bool
+	}{
+		{
+			name:        "disabled",
+			config:      Config{},
+			wantBackend: "json",
+			wantGrace:   DefaultShutdownGracePeriod,
+		},
+		{
+			name:        "enabled",
+			config:      Config{Kubernetes: true},
+			wantBackend: "none",
+			wantListen:  ":8080",
+			wantGrace:   20 * time.Second,
+			wantWatch:   true,
+		},
+		{
+			name:        "state in an emptyDir",
+			config:      Config{Kubernetes: true, StatePath: "/var/lib/dh-ddns-updater/state.json"},
+			wantBackend: "json",
+			wantListen:  ":8080",
+			wantGrace:   20 * time.Second,
+			wantWatch:   true,
+		},
+		{
+			name: "explicit settings",
+			config: Config{Kubernetes: true, StateBackend: "redis", Health: HealthConfig{Listen: ":9090"},
+				ShutdownGracePeriod: 50 * time.Second},
+			wantBackend: "redis",
+			wantListen:  ":9090",
+			wantGrace:   50 * time.Second,
+			wantWatch:   true,
+		},
+		{
+			name:        "watch_config turned off",
+			config:      Config{Kubernetes: true, WatchConfig: new(bool)},
+			wantBackend: "none",
+			wantListen:  ":8080",
+			wantGrace:   20 * time.Second,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := tt.config
+			applyConfigDefaults(&config)
+			if config.StateBackend != tt.wantBackend {
+				t.Errorf("expected state_backend %q, got %q", tt.wantBackend, config.StateBackend)
+			}
+			if config.Health.Listen != tt.wantListen {
+				t.Errorf("expected health.listen %q, got %q", tt.wantListen, config.Health.Listen)
+			}
+			if config.ShutdownGracePeriod != tt.wantGrace {
+				t.Errorf("expected shutdown_grace_period %s, got %s", tt.wantGrace, config.ShutdownGracePeriod)
+			}
+			if *config.WatchConfig != tt.wantWatch {
+				t.Errorf("expected watch_config %v, got %v", tt.wantWatch, *config.WatchConfig)
+			}
+		})
+	}
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LANDNSConfig configures the optional embedded DNS responder that answers
+// queries for managed names from LAN clients (split-horizon DNS).
+type LANDNSConfig struct {
+	Listen string `yaml:"listen"` // UDP and TCP listen address (e.g. ":53"); empty disables the responder
+	TTL    uint32 `yaml:"ttl"`    // TTL of answers in seconds (default 60)
+}
+
+// DNS wire-format constants used by the responder
+const (
+	dnsTypeA    = 1
+	dnsTypeAAAA = 28
+	dnsTypeANY  = 255
+	dnsClassIN  = 1
+
+	dnsRcodeSuccess  = 0
+	dnsRcodeFormErr  = 1
+	dnsRcodeNotImp   = 4
+	dnsRcodeRefused  = 5
+	dnsHeaderLen     = 12
+	dnsMaxUDPMessage = 512
+)
+
+// dnsQuestion is the single question of a query.
+type dnsQuestion struct {
+	Name  string // Lower-case name without the trailing dot
+	Type  uint16
+	Class uint16
+	raw   []byte // Wire form of the question, echoed in the response
+}
+
+// lanDNSServer answers A and AAAA queries for managed names. Records with a
+// lan_address answer with it; other address records answer with the value
+// the daemon last published. Names the daemon doesn't manage are refused.
+type lanDNSServer struct {
+	updater *DDNSUpdater
+	ttl     uint32
+}
+
+// Serve answers queries on UDP and TCP until the context is cancelled.
+func (s *lanDNSServer) Serve(ctx context.Context, addr string) error {
+	return serveDNS(ctx, addr, s.respond, func() {
+		s.updater.logger.Info("LAN DNS responder listening", "address", addr)
+	})
+}
+
+// serveDNS listens on UDP and TCP and passes every message to respond until
+// the context is cancelled. A nil response sends nothing. listening is
+// called once both sockets are bound.
+func serveDNS(ctx context.Context, addr string, respond func([]byte) []byte, listening func()) error {
+	pc, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return fmt.Errorf("listening on UDP %s: %w", addr, err)
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		pc.Close()
+		return fmt.Errorf("listening on TCP %s: %w", addr, err)
+	}
+
+	listening()
+
+	g, gctx := newTaskGroup(ctx)
+	g.Go(func() error {
+		<-gctx.Done()
+		pc.Close()
+		ln.Close()
+		return nil
+	})
+	g.Go(func() error {
+		serveDNSTCP(gctx, ln, respond)
+		return nil
+	})
+	g.Go(func() error {
+		serveDNSUDP(pc, respond)
+		return nil
+	})
+	g.Wait()
+
+	return ctx.Err()
+}
+
+// dnsMaxUDPHandlers bounds how many UDP messages are handled at once.
+const dnsMaxUDPHandlers = 64
+
+// serveDNSUDP reads messages until pc is closed, handling each on its own
+// goroutine so that one slow to answer, such as an update waiting on a
+// provider's API, doesn't hold up the others. It returns once the handlers
+// in progress have.
+func serveDNSUDP(pc net.PacketConn, respond func([]byte) []byte) {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	handlers := make(chan struct{}, dnsMaxUDPHandlers)
+	for {
+		buf := make([]byte, 4096)
+		n, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		handlers <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-handlers
+				wg.Done()
+			}()
+			resp := respond(buf[:n])
+			if resp == nil {
+				return
+			}
+			if len(resp) > dnsMaxUDPMessage {
+				resp = truncateDNSResponse(resp)
+			}
+			pc.WriteTo(resp, addr)
+		}()
+	}
+}
+
+// serveDNSTCP accepts connections until the listener is closed, then closes
+// any that are still open and waits for their handlers to return.
+func serveDNSTCP(ctx context.Context, ln net.Listener, respond func([]byte) []byte) {
+	g, gctx := newTaskGroup(ctx)
+	defer g.Wait()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		g.Go(func() error {
+			stop := context.AfterFunc(gctx, func() { conn.Close() })
+			defer stop()
+			handleDNSTCP(conn, respond)
+			return nil
+		})
+	}
+}
+
+// handleDNSTCP answers length-prefixed messages on one connection until the
+// client closes it or goes idle.
+func handleDNSTCP(conn net.Conn, respond func([]byte) []byte) {
+	defer conn.Close()
+	for {
+		conn.SetDeadline(time.Now().Add(10 * time.Second))
+		var length uint16
+		if err := binary.Read(conn, binary.BigEndian, &length); err != nil {
+			return
+		}
+		msg := make([]byte, length)
+		if _, err := io.ReadFull(conn, msg); err != nil {
+			return
+		}
+		resp := respond(msg)
+		if resp == nil {
+			return
+		}
+		out := binary.BigEndian.AppendUint16(nil, uint16(len(resp)))
+		if _, err := conn.Write(append(out, resp...)); err != nil {
+			return
+		}
+	}
+}
+
+// respond builds the response to one query, or returns nil if the message
+// is too malformed to answer at all.
+func (s *lanDNSServer) respond(msg []byte) []byte {
+	if len(msg) < dnsHeaderLen {
+		return nil
+	}
+	id := binary.BigEndian.Uint16(msg[0:2])
+	flags := binary.BigEndian.Uint16(msg[2:4])
+	if flags&0x8000 != 0 { // Not a query
+		return nil
+	}
+	opcode := (flags >> 11) & 0xF
+	rd := flags & 0x0100
+
+	if opcode != 0 {
+		return buildDNSResponse(id, rd, dnsRcodeNotImp, nil, nil, 0)
+	}
+	if binary.BigEndian.Uint16(msg[4:6]) != 1 {
+		return buildDNSResponse(id, rd, dnsRcodeFormErr, nil, nil, 0)
+	}
+
+	q, err := parseDNSQuestion(msg[dnsHeaderLen:])
+	if err != nil {
+		return buildDNSResponse(id, rd, dnsRcodeFormErr, nil, nil, 0)
+	}
+
+	addrs, managed := s.updater.lanAddresses(q.Name)
+	if !managed || q.Class != dnsClassIN {
+		return buildDNSResponse(id, rd, dnsRcodeRefused, &q, nil, 0)
+	}
+
+	var answers []net.IP
+	for _, ip := range addrs {
+		isV4 := ip.To4() != nil
+		if q.Type == dnsTypeANY || (q.Type == dnsTypeA && isV4) || (q.Type == dnsTypeAAAA && !isV4) {
+			answers = append(answers, ip)
+		}
+	}
+	return buildDNSResponse(id, rd, dnsRcodeSuccess, &q, answers, s.ttl)
+}
+
+// parseDNSQuestion parses the question section that starts at b.
+// Compression pointers are not expected in queries and are rejected.
+func parseDNSQuestion(b []byte) (dnsQuestion, error) {
+	var labels []string
+	i := 0
+	for {
+		if i >= len(b) {
+			return dnsQuestion{}, errors.New("truncated name")
+		}
+		l := int(b[i])
+		if l == 0 {
+			i++
+			break
+		}
+		if l&0xC0 != 0 {
+			return dnsQuestion{}, errors.New("unsupported label")
+		}
+		if i+1+l > len(b) {
+			return dnsQuestion{}, errors.New("truncated label")
+		}
+		labels = append(labels, string(b[i+1:i+1+l]))
+		i += 1 + l
+	}
+	if i+4 > len(b) {
+		return dnsQuestion{}, errors.New("truncated question")
+	}
+
+	return dnsQuestion{
+		Name:  strings.ToLower(strings.Join(labels, ".")),
+		Type:  binary.BigEndian.Uint16(b[i : i+2]),
+		Class: binary.BigEndian.Uint16(b[i+2 : i+4]),
+		raw:   b[:i+4],
+	}, nil
+}
+
+// buildDNSResponse encodes an authoritative response carrying the given
+// address answers for the question.
+func buildDNSResponse(id, rd uint16, rcode uint16, q *dnsQuestion, answers []net.IP, ttl uint32) []byte {
+	flags := uint16(0x8000) | uint16(0x0400) | rd | rcode // QR, AA, RD as asked
+	var qdcount uint16
+	if q != nil {
+		qdcount = 1
+	}
+
+	b := make([]byte, 0, 512)
+	b = binary.BigEndian.AppendUint16(b, id)
+	b = binary.BigEndian.AppendUint16(b, flags)
+	b = binary.BigEndian.AppendUint16(b, qdcount)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(answers)))
+	b = binary.BigEndian.AppendUint16(b, 0) // NSCOUNT
+	b = binary.BigEndian.AppendUint16(b, 0) // ARCOUNT
+	if q == nil {
+		return b
+	}
+	b = append(b, q.raw...)
+
+	for _, ip := range answers {
+		rtype, rdata := uint16(dnsTypeAAAA), ip.To16()
+		if v4 := ip.To4(); v4 != nil {
+			rtype, rdata = dnsTypeA, v4
+		}
+		b = append(b, 0xC0, dnsHeaderLen) // Pointer to the question name
+		b = binary.BigEndian.AppendUint16(b, rtype)
+		b = binary.BigEndian.AppendUint16(b, dnsClassIN)
+		b = binary.BigEndian.AppendUint32(b, ttl)
+		b = binary.BigEndian.AppendUint16(b, uint16(len(rdata)))
+		b = append(b, rdata...)
+	}
+	return b
+}
+
+// truncateDNSResponse drops the answers from a response that doesn't fit in
+// a UDP datagram and sets TC so the client retries over TCP.
+func truncateDNSResponse(resp []byte) []byte {
+	q, err := parseDNSQuestion(resp[dnsHeaderLen:])
+	if err != nil {
+		return resp[:dnsHeaderLen]
+	}
+	out := append([]byte(nil), resp[:dnsHeaderLen+len(q.raw)]...)
+	out[2] |= 0x02                          // TC
+	binary.BigEndian.PutUint16(out[6:8], 0) // ANCOUNT
+	return out
+}
+
+// lanAddresses returns the addresses LAN clients should see for a managed
+// name, and whether the name is managed at all.
+func (d *DDNSUpdater) lanAddresses(name string) ([]net.IP, bool) {
+	var addrs []net.IP
+	managed := false
+	for _, domain := range d.config.Domains {
+		if !strings.EqualFold(domain.FQDN(), name) {
+			continue
+		}
+		managed = true
+
+		value := domain.LANAddress
+		if value == "" && isAddressType(domain.Type) {
+			value = d.recordState(domain.FQDN(), domain.Type)
+		}
+		if ip := net.ParseIP(value); ip != nil {
+			addrs = append(addrs, ip)
+		}
+	}
+	return addrs, managed
+}
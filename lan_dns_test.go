@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/binary"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// buildDNSQuery encodes a recursive query for name and type.
+func buildDNSQuery(id uint16, name string, qtype uint16) []byte {
+	b := binary.BigEndian.AppendUint16(nil, id)
+	b = binary.BigEndian.AppendUint16(b, 0x0100) // RD
+	b = binary.BigEndian.AppendUint16(b, 1)
+	b = append(b, 0, 0, 0, 0, 0, 0)
+	for _, label := range strings.Split(name, ".") {
+		b = append(b, byte(len(label)))
+		b = append(b, label...)
+	}
+	b = append(b, 0)
+	b = binary.BigEndian.AppendUint16(b, qtype)
+	return binary.BigEndian.AppendUint16(b, dnsClassIN)
+}
+
+// TestLANDNSRespond tests answers for LAN addresses, published values, other types and unmanaged names
+func TestLANDNSRespond(t *testing.T) {
+	d := &DDNSUpdater{
+		config: &Config{Domains: []DomainConfig{
+			{Name: "example.com", Record: "nas", Type: "A", LANAddress: "192.168.1.10"},
+			{Name: "example.com", Record: "vpn", Type: "A"},
+			{Name: "example.com", Record: "vpn", Type: "AAAA", LANAddress: "fd00::1"},
+		}},
+		state:  &State{Records: map[string]string{"vpn.example.com/A": "203.0.113.42"}},
+		logger: slog.New(slog.NewJSONHandler(io.Discard, nil)),
+	}
+	server := &lanDNSServer{updater: d, ttl: 60}
+
+	tests := []struct {
+		name      string
+		qname     string
+		qtype     uint16
+		wantRcode uint16
+		wantIPs   []string
+	}{
+		{name: "LAN address", qname: "NAS.example.com", qtype: dnsTypeA, wantIPs: []string{"192.168.1.10"}},
+		{name: "published value", qname: "vpn.example.com", qtype: dnsTypeA, wantIPs: []string{"203.0.113.42"}},
+		{name: "IPv6 LAN address", qname: "vpn.example.com", qtype: dnsTypeAAAA, wantIPs: []string{"fd00::1"}},
+		{name: "no data", qname: "nas.example.com", qtype: dnsTypeAAAA},
+		{name: "unmanaged name", qname: "www.example.com", qtype: dnsTypeA, wantRcode: dnsRcodeRefused},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := server.respond(buildDNSQuery(0x1234, tt.qname, tt.qtype))
+			if len(resp) < dnsHeaderLen {
+				t.Fatalf("short response: %x", resp)
+			}
+			if id := binary.BigEndian.Uint16(resp[0:2]); id != 0x1234 {
+				t.Errorf("expected ID 0x1234, got %#x", id)
+			}
+			flags := binary.BigEndian.Uint16(resp[2:4])
+			if flags&0x8000 == 0 || flags&0x0400 == 0 {
+				t.Errorf("expected an authoritative response, got flags %#x", flags)
+			}
+			if rcode := flags & 0xF; rcode != tt.wantRcode {
+				t.Fatalf("expected rcode %d, got %d", tt.wantRcode, rcode)
+			}
+
+			q, err := parseDNSQuestion(resp[dnsHeaderLen:])
+			if err != nil {
+				t.Fatalf("parsing echoed question: %v", err)
+			}
+			if ancount := int(binary.BigEndian.Uint16(resp[6:8])); ancount != len(tt.wantIPs) {
+				t.Fatalf("expected %d answers, got %d", len(tt.wantIPs), ancount)
+			}
+
+			// Each answer is a name pointer, type, class, TTL and length before the data
+			rest := resp[dnsHeaderLen+len(q.raw):]
+			for _, want := range tt.wantIPs {
+				length := int(binary.BigEndian.Uint16(rest[10:12]))
+				ip := net.IP(rest[12 : 12+length])
+				if !ip.Equal(net.ParseIP(want)) {
+					t.Errorf("expected answer %s, got %s", want, ip)
+				}
+				if ttl := binary.BigEndian.Uint32(rest[6:10]); ttl != 60 {
+					t.Errorf("expected TTL 60, got %d", ttl)
+				}
+				rest = rest[12+length:]
+			}
+		})
+	}
+}
+
+// TestLANDNSMalformed tests that garbage is dropped and bad questions get FORMERR
+func TestLANDNSMalformed(t *testing.T) {
+	server := &lanDNSServer{updater: &DDNSUpdater{config: &Config{}}}
+
+	if resp := server.respond([]byte{1, 2, 3}); resp != nil {
+		t.Errorf("expected no response to a short message, got %x", resp)
+	}
+
+	query := buildDNSQuery(1, "example.com", dnsTypeA)
+	resp := server.respond(query[:dnsHeaderLen+3])
+	if resp == nil || binary.BigEndian.Uint16(resp[2:4])&0xF != dnsRcodeFormErr {
+		t.Errorf("expected FORMERR for a truncated question, got %x", resp)
+	}
+}
+
+// TestServeDNSUDPConcurrently tests that a message slow to answer doesn't
+// hold up the others
+func TestServeDNSUDPConcurrently(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	release := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		serveDNSUDP(pc, func(msg []byte) []byte {
+			if msg[0] == 1 {
+				<-release // Such as an update waiting on a provider
+			}
+			return msg
+		})
+		close(done)
+	}()
+
+	conn, err := net.Dial("udp", pc.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte{1})
+	conn.Write([]byte{2})
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 16)
+	if n, err := conn.Read(buf); err != nil || n != 1 || buf[0] != 2 {
+		t.Fatalf("expected the second message answered first, got %x (%v)", buf[:n], err)
+	}
+	close(release)
+	if n, err := conn.Read(buf); err != nil || n != 1 || buf[0] != 1 {
+		t.Fatalf("expected the first message answered once released, got %x (%v)", buf[:n], err)
+	}
+
+	pc.Close()
+	<-done
+}
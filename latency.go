@@ -0,0 +1,114 @@
+package main
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// latencyWindow is how many of a dependency's most recent calls its
+// percentiles and error rate are computed over.
+const latencyWindow = 100
+
+// DependencyMetrics is how calls to an external dependency, the IP source
+// or a DNS provider, have gone, so that a problem can be told to be with
+// detection or with a provider.
+type DependencyMetrics struct {
+	Name   string `json:"name"`   // "ip_source:<name>" or "provider:<name>"
+	Calls  int64  `json:"calls"`  // Since startup
+	Errors int64  `json:"errors"` // Since startup
+	// ErrorRate and the latencies are over the most recent calls, up to
+	// latencyWindow of them.
+	ErrorRate  float64       `json:"error_rate"`
+	LatencyP50 time.Duration `json:"latency_p50"`
+	LatencyP90 time.Duration `json:"latency_p90"`
+	LatencyP99 time.Duration `json:"latency_p99"`
+}
+
+// latencyTracker records the duration and outcome of calls to one
+// dependency. The zero value is ready to use.
+type latencyTracker struct {
+	mu      sync.Mutex
+	calls   int64
+	errors  int64
+	samples [latencyWindow]latencySample // Ring of the most recent calls
+	next    int                          // Where the next call is recorded
+}
+
+// latencySample is one call.
+type latencySample struct {
+	duration time.Duration
+	failed   bool
+}
+
+// record adds a call that took d and failed if err isn't nil.
+func (t *latencyTracker) record(d time.Duration, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.calls++
+	if err != nil {
+		t.errors++
+	}
+	t.samples[t.next%latencyWindow] = latencySample{duration: d, failed: err != nil}
+	t.next++
+}
+
+// metrics returns the calls' metrics, named name.
+func (t *latencyTracker) metrics(name string) DependencyMetrics {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	m := DependencyMetrics{Name: name, Calls: t.calls, Errors: t.errors}
+	recent := t.samples[:min(t.next, latencyWindow)]
+	if len(recent) == 0 {
+		return m
+	}
+	durations := make([]time.Duration, len(recent))
+	failed := 0
+	for i, s := range recent {
+		durations[i] = s.duration
+		if s.failed {
+			failed++
+		}
+	}
+	slices.Sort(durations)
+	m.ErrorRate = float64(failed) / float64(len(recent))
+	m.LatencyP50 = percentile(durations, 50)
+	m.LatencyP90 = percentile(durations, 90)
+	m.LatencyP99 = percentile(durations, 99)
+	return m
+}
+
+// percentile returns the pth percentile of sorted durations, by the
+// nearest-rank method.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	rank := (p*len(sorted) + 99) / 100 // ceil(p/100 * n)
+	return sorted[max(rank, 1)-1]
+}
+
+// observeIPSource accounts for a call to the IP source that took
+// duration.
+func (d *DDNSUpdater) observeIPSource(duration time.Duration, err error) {
+	d.ipSourceLatency.record(duration, err)
+	d.stats.timing("ip_source.latency", duration, "source", ipSourceName, "outcome", outcomeTag(err))
+}
+
+// outcomeTag returns how a call went, as metrics tag it.
+func outcomeTag(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
+
+// Dependencies reports how calls to the IP source and to each provider
+// have gone.
+func (d *DDNSUpdater) Dependencies() []DependencyMetrics {
+	var deps []DependencyMetrics
+	if !d.config.Webhook.DisablePolling {
+		deps = append(deps, d.ipSourceLatency.metrics("ip_source:"+ipSourceName))
+	}
+	for _, name := range d.providerNames() {
+		deps = append(deps, d.providers[name].latency.metrics("provider:"+name))
+	}
+	return deps
+}
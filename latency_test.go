@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// TestLatencyTracker tests the percentiles and error rate over the most
+// recent calls, and the totals since startup
+func TestLatencyTracker(t *testing.T) {
+	var tracker latencyTracker
+	if m := tracker.metrics("provider:dreamhost"); m != (DependencyMetrics{Name: "provider:dreamhost"}) {
+		t.Errorf("expected empty metrics without calls, got %+v", m)
+	}
+
+	// 50 old failing calls, pushed out of the window by 100 newer ones
+	// taking 1ms to 100ms, every tenth failing
+	for range 50 {
+		tracker.record(time.Minute, errors.New("timeout"))
+	}
+	for i := 1; i <= 100; i++ {
+		var err error
+		if i%10 == 0 {
+			err = errors.New("HTTP 500")
+		}
+		tracker.record(time.Duration(i)*time.Millisecond, err)
+	}
+
+	want := DependencyMetrics{
+		Name:       "provider:dreamhost",
+		Calls:      150,
+		Errors:     60,
+		ErrorRate:  0.1,
+		LatencyP50: 50 * time.Millisecond,
+		LatencyP90: 90 * time.Millisecond,
+		LatencyP99: 99 * time.Millisecond,
+	}
+	if m := tracker.metrics("provider:dreamhost"); m != want {
+		t.Errorf("expected %+v, got %+v", want, m)
+	}
+}
+
+// TestPercentile tests nearest-rank percentiles of few samples
+func TestPercentile(t *testing.T) {
+	tests := []struct {
+		sorted []time.Duration
+		p      int
+		want   time.Duration
+	}{
+		{[]time.Duration{7}, 50, 7},
+		{[]time.Duration{7}, 99, 7},
+		{[]time.Duration{1, 2}, 50, 1},
+		{[]time.Duration{1, 2}, 90, 2},
+		{[]time.Duration{1, 2, 3, 4}, 50, 2},
+		{[]time.Duration{1, 2, 3, 4}, 99, 4},
+	}
+	for _, tt := range tests {
+		if got := percentile(tt.sorted, tt.p); got != tt.want {
+			t.Errorf("p%d of %v: expected %v, got %v", tt.p, tt.sorted, tt.want, got)
+		}
+	}
+}
+
+// TestDependencies tests that calls to a provider are tracked apart from
+// the IP source, leaving out those cancelled
+func TestDependencies(t *testing.T) {
+	fake := newFakeDreamhost()
+	updater := newPlanTestUpdater(t, fake, "", DomainConfig{Name: "example.com", Record: "home", Type: "A"})
+	domain := updater.config.Domains[0]
+	h := updater.providers[DefaultProvider]
+
+	if _, err := h.GetRecords(context.Background(), domain); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	h.GetRecords(ctx, domain)
+	updater.observeIPSource(80*time.Millisecond, errors.New("HTTP 503 from ipinfo.io"))
+
+	deps := updater.Dependencies()
+	if len(deps) != 2 {
+		t.Fatalf("expected the IP source and one provider, got %+v", deps)
+	}
+	if deps[0].Name != "ip_source:ipinfo" || deps[0].Calls != 1 || deps[0].ErrorRate != 1 || deps[0].LatencyP50 != 80*time.Millisecond {
+		t.Errorf("unexpected IP source metrics %+v", deps[0])
+	}
+	if deps[1].Name != "provider:dreamhost" || deps[1].Calls != 1 || deps[1].Errors != 0 || deps[1].LatencyP50 <= 0 {
+		t.Errorf("unexpected provider metrics %+v", deps[1])
+	}
+}
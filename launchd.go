@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+)
+
+// launchdLabel names the daemon's LaunchDaemon job, for launchctl.
+const launchdLabel = "com.github.lritter.dh-ddns-updater"
+
+// launchdLogPath is where launchd writes the daemon's stdout and stderr:
+// its logs unless log_file, syslog or journald says otherwise, and why it
+// failed to start.
+const launchdLogPath = "/var/log/dh-ddns-updater.log"
+
+// launchdThrottleInterval is how many seconds launchd waits before
+// restarting the daemon, so that a broken config isn't retried in a tight
+// loop.
+const launchdThrottleInterval = 30
+
+// launchdPlist returns the LaunchDaemon property list running exe with the
+// config at configPath. launchd starts it at boot and restarts it if it
+// fails, but not after a clean stop.
+func launchdPlist(exe, configPath string) []byte {
+	var b bytes.Buffer
+	str := func(s string) {
+		b.WriteString("<string>")
+		xml.EscapeText(&b, []byte(s))
+		b.WriteString("</string>")
+	}
+	b.WriteString(xml.Header)
+	b.WriteString(`<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">` + "\n")
+	b.WriteString("<plist version=\"1.0\">\n<dict>\n")
+	b.WriteString("\t<key>Label</key>")
+	str(launchdLabel)
+	b.WriteString("\n\t<key>ProgramArguments</key>\n\t<array>")
+	for _, arg := range []string{exe, "--config", configPath} {
+		b.WriteString("\n\t\t")
+		str(arg)
+	}
+	b.WriteString("\n\t</array>\n")
+	b.WriteString("\t<key>RunAtLoad</key><true/>\n")
+	b.WriteString("\t<key>KeepAlive</key>\n\t<dict>\n\t\t<key>SuccessfulExit</key><false/>\n\t</dict>\n")
+	fmt.Fprintf(&b, "\t<key>ThrottleInterval</key><integer>%d</integer>\n", launchdThrottleInterval)
+	b.WriteString("\t<key>StandardOutPath</key>")
+	str(launchdLogPath)
+	b.WriteString("\n\t<key>StandardErrorPath</key>")
+	str(launchdLogPath)
+	b.WriteString("\n</dict>\n</plist>\n")
+	return b.Bytes()
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"strings"
+	"testing"
+)
+
+// TestLaunchdPlist tests that the LaunchDaemon property list is well-formed
+// and runs the daemon with the config, escaping the paths.
+func TestLaunchdPlist(t *testing.T) {
+	plist := launchdPlist("/usr/local/bin/dh-ddns-updater", "/Users/a&b/<ddns>.yaml")
+
+	var strs []string
+	dec := xml.NewDecoder(bytes.NewReader(plist))
+	inString := false
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("plist isn't well-formed: %v\n%s", err, plist)
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			inString = tok.Name.Local == "string"
+		case xml.EndElement:
+			inString = false
+		case xml.CharData:
+			if inString {
+				strs = append(strs, string(tok))
+			}
+		}
+	}
+	want := []string{launchdLabel, "/usr/local/bin/dh-ddns-updater", "--config", "/Users/a&b/<ddns>.yaml", launchdLogPath, launchdLogPath}
+	if strings.Join(strs, "\n") != strings.Join(want, "\n") {
+		t.Errorf("plist strings = %q, want %q", strs, want)
+	}
+	for _, s := range []string{"<key>RunAtLoad</key><true/>", "<key>SuccessfulExit</key><false/>", "<key>ThrottleInterval</key><integer>30</integer>"} {
+		if !bytes.Contains(plist, []byte(s)) {
+			t.Errorf("plist lacks %s:\n%s", s, plist)
+		}
+	}
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LeaderConfig configures leader election between redundant instances,
+// such as one on the router and a standby on another box, all configured
+// for the same records. Only the instance holding the lease publishes; the
+// others keep detecting and take the lease over when it expires.
+type LeaderConfig struct {
+	Enabled   bool          `yaml:"enabled"`
+	LeaseFile string        `yaml:"lease_file"` // Lease on storage the instances share; empty keeps it in the shared state_backend
+	TTL       time.Duration `yaml:"ttl"`        // How long the lease lasts unless renewed (default 30s)
+	ID        string        `yaml:"id"`         // Names this instance in the lease (default the hostname); must differ between instances
+}
+
+// DefaultLeaderTTL is the lease's lifetime unless leader_election.ttl sets
+// another. The leader renews it every third of it.
+const DefaultLeaderTTL = 30 * time.Second
+
+// leaderLease is the lease as stored.
+type leaderLease struct {
+	Holder  string    `json:"holder"`
+	Expires time.Time `json:"expires"`
+}
+
+// leaderElection campaigns for the lease on behalf of this instance. The
+// lease is taken when nobody holds it or its holder let it expire, and
+// renewed while held; each write is a check-and-set on the revision read,
+// so of two instances taking it at once only one succeeds.
+type leaderElection struct {
+	kv     casStore
+	id     string
+	ttl    time.Duration
+	logger *slog.Logger
+	now    func() time.Time
+
+	mu      sync.Mutex
+	holder  string    // The holder last seen, this instance included
+	leading bool      // Whether this instance took or renewed the lease
+	until   time.Time // When the lease this instance holds runs out, by its own clock
+}
+
+// newLeaderElection returns the election leader_election configures, or
+// nil if it isn't enabled.
+func newLeaderElection(config *Config, logger *slog.Logger) *leaderElection {
+	if !config.Leader.Enabled {
+		return nil
+	}
+	return &leaderElection{
+		kv:     leaseStoreFor(config),
+		id:     config.Leader.ID,
+		ttl:    config.Leader.TTL,
+		logger: logger,
+		now:    time.Now,
+	}
+}
+
+// leaseStoreFor returns where config keeps the lease: the lease file, or
+// beside the state in the shared state backend.
+func leaseStoreFor(config *Config) casStore {
+	if config.Leader.LeaseFile != "" {
+		return leaseFile{path: config.Leader.LeaseFile}
+	}
+	switch config.StateBackend {
+	case "redis":
+		redis := config.Redis
+		redis.Key += ":leader"
+		return redisStore{config: redis}
+	case "etcd":
+		etcd := config.Etcd
+		etcd.Key += "/leader"
+		return etcdStore{config: etcd, client: &http.Client{Timeout: sharedStateTimeout}}
+	case "consul":
+		consul := config.Consul
+		consul.Key += "/leader"
+		return consulStore{config: consul, client: &http.Client{Timeout: sharedStateTimeout}}
+	}
+	return nil // Rejected by validateLeader
+}
+
+// validateLeader checks that an enabled election has somewhere to keep
+// the lease.
+func validateLeader(config *Config) error {
+	if !config.Leader.Enabled {
+		return nil
+	}
+	if config.Leader.TTL < 0 {
+		return errors.New("leader_election: ttl must not be negative")
+	}
+	if config.Leader.ID == "" {
+		return errors.New("leader_election: id is required when the hostname can't be read")
+	}
+	if config.Leader.LeaseFile != "" {
+		return nil
+	}
+	switch config.StateBackend {
+	case "redis", "etcd", "consul":
+		return nil
+	}
+	return fmt.Errorf("leader_election: lease_file is required with state_backend %s; only redis, etcd and consul can hold the lease", config.StateBackend)
+}
+
+// campaign takes or renews the lease if it can, and reports whether this
+// instance leads. On an error, ctx's end included, the lease held, if any,
+// is kept until it runs out, since the store may only be out of reach for a
+// moment.
+func (e *leaderElection) campaign(ctx context.Context) (bool, error) {
+	now := e.now()
+	data, revision, err := e.kv.get(ctx)
+	if err != nil {
+		return e.update(false, "", now, err)
+	}
+	var lease leaderLease
+	if data != nil {
+		if err := json.Unmarshal(data, &lease); err != nil {
+			return e.update(false, "", now, fmt.Errorf("decoding the leader lease: %w", err))
+		}
+	}
+	if lease.Holder != "" && lease.Holder != e.id && now.Before(lease.Expires) {
+		return e.update(false, lease.Holder, now, nil)
+	}
+
+	data, err = json.Marshal(leaderLease{Holder: e.id, Expires: now.Add(e.ttl)})
+	if err != nil {
+		return false, err
+	}
+	saved, _, err := e.kv.put(ctx, data, revision)
+	if err != nil || !saved {
+		// Someone else took it first; it is theirs until the next look
+		return e.update(false, "", now, err)
+	}
+	return e.update(true, e.id, now, nil)
+}
+
+// update records the outcome of a campaign started at now and logs a change
+// of leader. With an error, only the lease's running out ends leadership.
+func (e *leaderElection) update(took bool, holder string, now time.Time, err error) (bool, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	was := e.leading && now.Before(e.until)
+	switch {
+	case took:
+		e.leading, e.until = true, now.Add(e.ttl)
+	case err != nil:
+		e.leading = was
+	default:
+		e.leading = false
+	}
+	if holder != "" {
+		e.holder = holder
+	} else if !e.leading && e.holder == e.id {
+		e.holder = ""
+	}
+
+	switch {
+	case e.leading && !was:
+		e.logger.Info("Elected leader; this instance now updates the records", "id", e.id, "ttl", e.ttl)
+	case !e.leading && was && err != nil:
+		e.logger.Warn("Lost the leader lease, which couldn't be renewed; leaving the records to another instance", "id", e.id, "error", err)
+	case !e.leading && was:
+		e.logger.Warn("Lost the leader lease; leaving the records to another instance", "id", e.id, "leader", e.holder)
+	}
+	return e.leading, err
+}
+
+// Leading reports whether this instance holds the lease.
+func (e *leaderElection) Leading() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.leading && e.now().Before(e.until)
+}
+
+// Leader returns the instance last seen holding the lease, or "" if none
+// was.
+func (e *leaderElection) Leader() string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.holder
+}
+
+// release gives the lease up if this instance holds it, so a standby takes
+// over without waiting for it to run out.
+func (e *leaderElection) release(ctx context.Context) error {
+	if !e.Leading() {
+		return nil
+	}
+	e.mu.Lock()
+	e.leading = false
+	e.holder = ""
+	e.mu.Unlock()
+
+	data, revision, err := e.kv.get(ctx)
+	if err != nil {
+		return err
+	}
+	var lease leaderLease
+	if err := json.Unmarshal(data, &lease); err != nil || lease.Holder != e.id {
+		return nil // Taken over already
+	}
+	data, err = json.Marshal(leaderLease{})
+	if err != nil {
+		return err
+	}
+	_, _, err = e.kv.put(ctx, data, revision)
+	return err
+}
+
+// leading reports whether this instance may update the records: it holds
+// the lease, or leader election isn't enabled.
+func (d *DDNSUpdater) leading() bool {
+	return d.election == nil || d.election.Leading()
+}
+
+// elect is the leader election stage, campaigning every third of the
+// lease's lifetime. Becoming leader publishes right away, in case the
+// previous leader left changes unmade.
+func (d *DDNSUpdater) elect(ctx context.Context) error {
+	was := d.election.Leading()
+	leading, err := d.election.campaign(ctx)
+	if leading && !was && ctx.Err() == nil {
+		d.RequestCheck()
+	}
+	if err != nil {
+		return fmt.Errorf("leader election: %w", err)
+	}
+	return nil
+}
+
+// leaseFile keeps the lease in a file on storage the instances share, such
+// as an NFS or SMB mount, as the revision on the first line followed by the
+// lease. A write takes a lock on a file beside it and replaces the file by
+// renaming, so a reader never sees half a lease.
+type leaseFile struct {
+	path string
+}
+
+func (f leaseFile) get(context.Context) ([]byte, int64, error) {
+	content, err := os.ReadFile(f.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	first, data, _ := strings.Cut(string(content), "\n")
+	revision, err := strconv.ParseInt(first, 10, 64)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%s: bad revision %q", f.path, first)
+	}
+	return []byte(data), revision, nil
+}
+
+func (f leaseFile) put(ctx context.Context, data []byte, revision int64) (bool, int64, error) {
+	if err := ctx.Err(); err != nil {
+		return false, 0, err
+	}
+	lock, err := os.OpenFile(f.path+".lock", os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return false, 0, err
+	}
+	defer lock.Close()
+	if err := lockFile(lock); errors.Is(err, errLockHeld) {
+		return false, 0, nil // Another instance is writing it
+	} else if err != nil {
+		return false, 0, err
+	}
+
+	if _, current, err := f.get(ctx); err != nil {
+		return false, 0, err
+	} else if current != revision {
+		return false, 0, nil
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), filepath.Base(f.path)+".*.tmp")
+	if err != nil {
+		return false, 0, err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = fmt.Fprintf(tmp, "%d\n%s", revision+1, data)
+	if err = errors.Join(err, tmp.Close()); err != nil {
+		return false, 0, err
+	}
+	if err := os.Rename(tmp.Name(), f.path); err != nil {
+		return false, 0, err
+	}
+	return true, revision + 1, nil
+}
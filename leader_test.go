@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"io"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestElection returns an election for id over the lease file at path,
+// on a clock the test moves through now.
+func newTestElection(path, id string, now *time.Time) *leaderElection {
+	return &leaderElection{
+		kv:     leaseFile{path: path},
+		id:     id,
+		ttl:    30 * time.Second,
+		logger: newLogger(io.Discard, "error"),
+		now:    func() time.Time { return *now },
+	}
+}
+
+// TestLeaderElection tests that one instance leads while it renews the
+// lease and that the standby takes over once it runs out or is released
+func TestLeaderElection(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "leader.lease")
+	now := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	primary := newTestElection(path, "router", &now)
+	standby := newTestElection(path, "backup", &now)
+
+	campaign := func(e *leaderElection, want bool) {
+		t.Helper()
+		leading, err := e.campaign(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if leading != want || e.Leading() != want {
+			t.Fatalf("%s: expected leading %v, got %v", e.id, want, leading)
+		}
+	}
+
+	campaign(primary, true)
+	campaign(standby, false)
+	if got := standby.Leader(); got != "router" {
+		t.Errorf("expected the standby to see router leading, got %q", got)
+	}
+
+	// Renewed within the lease, the primary keeps it
+	now = now.Add(20 * time.Second)
+	campaign(primary, true)
+	now = now.Add(20 * time.Second)
+	campaign(standby, false)
+
+	// The primary stops renewing; the standby takes over once it runs out
+	now = now.Add(15 * time.Second)
+	if primary.Leading() {
+		t.Error("expected the primary to stop leading once its lease ran out")
+	}
+	campaign(standby, true)
+	campaign(primary, false)
+
+	// Released on shutdown, the lease is taken over right away
+	if err := standby.release(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if standby.Leading() {
+		t.Error("expected the standby to stop leading once it released the lease")
+	}
+	campaign(primary, true)
+}
+
+// TestLeaderElectionRace tests that of instances taking an unheld lease at
+// once only one leads
+func TestLeaderElectionRace(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "leader.lease")
+	now := time.Now()
+	var wg sync.WaitGroup
+	leaders := make(chan string, 8)
+	for _, id := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			e := newTestElection(path, id, &now)
+			if leading, _ := e.campaign(context.Background()); leading {
+				leaders <- id
+			}
+		}()
+	}
+	wg.Wait()
+	close(leaders)
+	var got []string
+	for id := range leaders {
+		got = append(got, id)
+	}
+	if len(got) != 1 {
+		t.Errorf("expected one leader, got %v", got)
+	}
+}
+
+// TestValidateLeader tests where leader election can keep its lease
+func TestValidateLeader(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  Config
+		wantErr bool
+	}{
+		{"disabled", Config{StateBackend: "json"}, false},
+		{"lease file", Config{StateBackend: "json", Leader: LeaderConfig{Enabled: true, ID: "a", LeaseFile: "/mnt/shared/lease"}}, false},
+		{"shared backend", Config{StateBackend: "redis", Leader: LeaderConfig{Enabled: true, ID: "a"}}, false},
+		{"local backend", Config{StateBackend: "sqlite", Leader: LeaderConfig{Enabled: true, ID: "a"}}, true},
+		{"no id", Config{StateBackend: "etcd", Leader: LeaderConfig{Enabled: true}}, true},
+		{"negative ttl", Config{StateBackend: "etcd", Leader: LeaderConfig{Enabled: true, ID: "a", TTL: -time.Second}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateLeader(&tt.config); (err != nil) != tt.wantErr {
+				t.Errorf("expected error %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// shutdownTimeout bounds how long servers wait for in-flight requests to
+// finish once the daemon is stopping.
+const shutdownTimeout = 5 * time.Second
+
+// taskGroup runs a set of goroutines that share a derived context, in the
+// manner of errgroup: the first task to fail cancels the context for the
+// others, and Wait returns that first error once every task has exited.
+// Every long-running goroutine in the daemon belongs to a group, so
+// cancelling the parent context is enough to stop all in-flight work.
+type taskGroup struct {
+	wg     sync.WaitGroup
+	cancel context.CancelCauseFunc
+
+	once sync.Once
+	err  error
+}
+
+// newTaskGroup returns a group and the context its tasks should use.
+func newTaskGroup(ctx context.Context) (*taskGroup, context.Context) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	return &taskGroup{cancel: cancel}, ctx
+}
+
+// Go runs fn in a new goroutine. A non-nil error cancels the group.
+func (g *taskGroup) Go(fn func() error) {
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		if err := fn(); err != nil {
+			g.once.Do(func() {
+				g.err = err
+				g.cancel(err)
+			})
+		}
+	}()
+}
+
+// Wait blocks until every task has returned and reports the first error.
+func (g *taskGroup) Wait() error {
+	g.wg.Wait()
+	g.cancel(context.Canceled)
+	return g.err
+}
+
+// withGrace returns a context carrying ctx's values that is cancelled grace
+// after ctx is, for work that must not be cut short midway, such as a DNS
+// update removing a record before adding its replacement. Calling cancel
+// releases it.
+func withGrace(ctx context.Context, grace time.Duration) (context.Context, context.CancelFunc) {
+	graceCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	stop := context.AfterFunc(ctx, func() {
+		select {
+		case <-graceCtx.Done():
+		case <-time.After(grace):
+			cancel()
+		}
+	})
+	return graceCtx, func() {
+		stop()
+		cancel()
+	}
+}
+
+// serveHTTP runs server until the context is cancelled, then gives in-flight
+// requests shutdownTimeout to complete. Request contexts derive from ctx,
+// so handlers doing outbound work are cancelled along with the server.
+func serveHTTP(ctx context.Context, server *http.Server) error {
+	server.BaseContext = func(net.Listener) context.Context { return ctx }
+
+	g, gctx := newTaskGroup(ctx)
+	g.Go(func() error {
+		<-gctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), shutdownTimeout)
+		defer cancel()
+		return server.Shutdown(shutdownCtx)
+	})
+	g.Go(func() error {
+		if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+			return err
+		}
+		return nil
+	})
+	if err := g.Wait(); err != nil {
+		return err
+	}
+	return ctx.Err()
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// TestTaskGroup tests that the first failure cancels the remaining tasks and is returned by Wait
+func TestTaskGroup(t *testing.T) {
+	boom := errors.New("boom")
+	g, ctx := newTaskGroup(context.Background())
+
+	g.Go(func() error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	g.Go(func() error { return boom })
+
+	if err := g.Wait(); !errors.Is(err, boom) {
+		t.Errorf("expected boom, got %v", err)
+	}
+	if !errors.Is(context.Cause(ctx), boom) {
+		t.Errorf("expected context cause boom, got %v", context.Cause(ctx))
+	}
+}
+
+// TestWithGrace tests that the grace context outlives its parent by the grace period only
+func TestWithGrace(t *testing.T) {
+	parent, cancelParent := context.WithCancel(context.Background())
+	ctx, cancel := withGrace(parent, 50*time.Millisecond)
+	defer cancel()
+
+	cancelParent()
+	if ctx.Err() != nil {
+		t.Fatal("expected the grace context to outlive its parent")
+	}
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the grace context cancelled after the grace period")
+	}
+
+	ctx, cancel = withGrace(context.Background(), time.Hour)
+	cancel()
+	if ctx.Err() == nil {
+		t.Error("expected cancel to cancel the grace context")
+	}
+}
+
+// TestServeHTTPCancelsRequests tests that shutting down cancels the context of in-flight requests
+func TestServeHTTPCancelsRequests(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	started := make(chan struct{})
+	cancelled := make(chan struct{})
+	server := &http.Server{Addr: addr, Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-r.Context().Done()
+		close(cancelled)
+	})}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- serveHTTP(ctx, server) }()
+
+	go func() {
+		for i := 0; i < 100; i++ {
+			if resp, err := http.Get("http://" + addr); err == nil {
+				resp.Body.Close()
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+
+	select {
+	case <-started:
+	case <-time.After(2 * time.Second):
+		t.Fatal("request never reached the handler")
+	}
+	cancel()
+
+	select {
+	case <-cancelled:
+	case <-time.After(2 * time.Second):
+		t.Fatal("in-flight request was not cancelled")
+	}
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
+
+// TestServeHTTPListenError tests that a listener failure is returned rather than hidden
+func TestServeHTTPListenError(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+
+	server := &http.Server{Addr: ln.Addr().String()}
+	if err := serveHTTP(context.Background(), server); err == nil {
+		t.Error("expected an error for an address already in use")
+	}
+}
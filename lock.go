@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// errStateLocked is returned by lockState when another instance holds the
+// lock.
+var errStateLocked = errors.New("state file is in use by another instance")
+
+// stateLock is an exclusive lock on a state file, which keeps two daemons
+// pointed at the same state from racing each other and fighting over the
+// records. The lock is held on a file beside the state, which names the
+// process holding it, and is released when the process exits, however it
+// exits.
+type stateLock struct {
+	file *os.File
+}
+
+// lockState takes the lock on the state file at statePath without waiting,
+// creating the state directory if it doesn't exist. It returns an error
+// wrapping errStateLocked, naming the holder's process ID where it can be
+// read, if another instance holds the lock.
+func lockState(statePath string) (*stateLock, error) {
+	path := statePath + ".lock"
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("locking state file: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("locking state file: %w", err)
+	}
+	if err := lockFile(f); err != nil {
+		f.Close()
+		if !errors.Is(err, errLockHeld) {
+			return nil, fmt.Errorf("locking state file: %w", err)
+		}
+		if pid, _ := os.ReadFile(path); len(strings.TrimSpace(string(pid))) > 0 {
+			return nil, fmt.Errorf("%w (pid %s holds %s)", errStateLocked, strings.TrimSpace(string(pid)), path)
+		}
+		return nil, fmt.Errorf("%w (%s is locked)", errStateLocked, path)
+	}
+	f.Truncate(0)
+	f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	return &stateLock{file: f}, nil
+}
+
+// Unlock releases the lock, if one was taken.
+func (l *stateLock) Unlock() error {
+	if l == nil {
+		return nil
+	}
+	return l.file.Close()
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestLockState tests that a second instance is refused the state lock until the first releases it
+func TestLockState(t *testing.T) {
+	statePath := filepath.Join(t.TempDir(), "state.json")
+
+	first, err := lockState(statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = lockState(statePath)
+	if !errors.Is(err, errStateLocked) || !strings.Contains(err.Error(), fmt.Sprintf("pid %d", os.Getpid())) {
+		t.Fatalf("expected the lock to be refused naming pid %d, got %v", os.Getpid(), err)
+	}
+
+	if err := first.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	second, err := lockState(statePath)
+	if err != nil {
+		t.Fatalf("expected the released lock to be taken, got %v", err)
+	}
+	second.Unlock()
+}
+
+// TestDaemonLoaderLocksFirst tests that the daemon takes the state lock before loading the state, and holds it across reloads
+func TestDaemonLoaderLocksFirst(t *testing.T) {
+	dir := t.TempDir()
+	statePath := filepath.Join(dir, "state", "state.json")
+	configPath := filepath.Join(dir, "config.yaml")
+	config := fmt.Sprintf("dreamhost_api_key: key\nstate_path: %s\ndomains:\n  - name: example.com\n    record: home\n    type: A\n", statePath)
+	if err := os.WriteFile(configPath, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	logs := newDaemonLog(io.Discard)
+	defer logs.Close()
+
+	// Another instance holds the lock: the state is neither created nor moved aside
+	other, err := lockState(statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = daemonLoader(configPath, configOptions{}, logs)()
+	if !errors.Is(err, errStateLocked) {
+		t.Fatalf("expected the lock to be refused, got %v", err)
+	}
+	if errors.As(err, new(configError)) {
+		t.Errorf("expected the lock held not taken for an invalid config, got %v", err)
+	}
+	if _, err := os.Stat(statePath); !os.IsNotExist(err) {
+		t.Errorf("expected no state file created while locked, got %v", err)
+	}
+	if err := os.WriteFile(statePath, []byte(`{"last_ip": "203.0.1`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := daemonLoader(configPath, configOptions{}, logs)(); !errors.Is(err, errStateLocked) {
+		t.Fatalf("expected the lock to be refused, got %v", err)
+	}
+	if backups, _ := filepath.Glob(statePath + ".corrupt-*"); len(backups) != 0 {
+		t.Errorf("expected the corrupt state left alone while locked, got %v", backups)
+	}
+	other.Unlock()
+
+	load := daemonLoader(configPath, configOptions{}, logs)
+	updater, err := load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer updater.lock.Unlock()
+	if updater.lock == nil {
+		t.Fatal("expected the updater to hold the state lock")
+	}
+	reloaded, err := load()
+	if err != nil {
+		t.Fatalf("unexpected error reloading: %v", err)
+	}
+	if reloaded.lock != updater.lock {
+		t.Error("expected a reload to keep the lock rather than take it again")
+	}
+
+	if err := os.WriteFile(configPath, []byte("check_interval: soon\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := load(); !errors.As(err, new(configError)) {
+		t.Errorf("expected an invalid config reported as one, got %v", err)
+	}
+}
+
+// TestSaveStateReadOnly tests that a command running beside the daemon leaves its state file alone
+func TestSaveStateReadOnly(t *testing.T) {
+	statePath := filepath.Join(t.TempDir(), "state.json")
+	d := &DDNSUpdater{
+		config:        &Config{StatePath: statePath},
+		state:         &State{LastIP: "203.0.113.1"},
+		logger:        newLogger(io.Discard, "info"),
+		stateReadOnly: true,
+	}
+	if err := d.saveState(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(statePath); !os.IsNotExist(err) {
+		t.Errorf("expected no state file, got %v", err)
+	}
+}
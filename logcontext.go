@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+)
+
+// logIDs identify what a line was logged for, so that the lines of
+// concurrently running stages can be told apart: the cycle, a run of a
+// pipeline stage or of checkAndUpdate, and within it the request, a call to
+// the IP source or a provider. Lines logged with a context carrying them
+// get them as cycle_id and request_id.
+type logIDs struct {
+	cycle   string
+	request string
+}
+
+type logIDsKey struct{}
+
+// newLogID returns a random ID for logIDs.
+func newLogID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// withCycleID returns ctx with a new cycle ID, unless it is already in a
+// cycle, as the stages run by checkAndUpdate are.
+func withCycleID(ctx context.Context) context.Context {
+	ids, _ := ctx.Value(logIDsKey{}).(logIDs)
+	if ids.cycle != "" {
+		return ctx
+	}
+	ids.cycle = newLogID()
+	return context.WithValue(ctx, logIDsKey{}, ids)
+}
+
+// withRequestID returns ctx with a new request ID.
+func withRequestID(ctx context.Context) context.Context {
+	ids, _ := ctx.Value(logIDsKey{}).(logIDs)
+	ids.request = newLogID()
+	return context.WithValue(ctx, logIDsKey{}, ids)
+}
+
+// contextHandler adds the logIDs of the context a line is logged with to
+// the line.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if ids, ok := ctx.Value(logIDsKey{}).(logIDs); ok {
+		if ids.cycle != "" {
+			r.AddAttrs(slog.String("cycle_id", ids.cycle))
+		}
+		if ids.request != "" {
+			r.AddAttrs(slog.String("request_id", ids.request))
+		}
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// TestContextHandler tests that the IDs of the context a line is logged
+// with are added to it, and that a cycle keeps its ID
+func TestContextHandler(t *testing.T) {
+	var buf bytes.Buffer
+	logger := newLogger(&buf, "info").With("provider", "dreamhost")
+
+	ctx := withCycleID(context.Background())
+	if withCycleID(ctx) != ctx {
+		t.Error("expected a context in a cycle to keep its cycle ID")
+	}
+	logger.InfoContext(withRequestID(ctx), "in request")
+	logger.InfoContext(ctx, "in cycle")
+	logger.Info("without context")
+
+	var lines []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var entry map[string]any
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("decoding %s: %v", line, err)
+		}
+		lines = append(lines, entry)
+	}
+	if lines[0]["cycle_id"] == nil || lines[0]["request_id"] == nil || lines[0]["provider"] != "dreamhost" {
+		t.Errorf("expected both IDs on the line logged in a request, got %v", lines[0])
+	}
+	if lines[1]["cycle_id"] != lines[0]["cycle_id"] || lines[1]["request_id"] != nil {
+		t.Errorf("expected only the cycle ID on the line logged in the cycle, got %v", lines[1])
+	}
+	if lines[2]["cycle_id"] != nil || lines[2]["request_id"] != nil {
+		t.Errorf("expected no IDs without a context, got %v", lines[2])
+	}
+}
+
+// TestLogIDsThroughPublication tests that the lines of a publication run
+// share its cycle ID, and the API requests of each provider call their
+// request ID
+func TestLogIDsThroughPublication(t *testing.T) {
+	fake := newFakeDreamhost()
+	updater := newPlanTestUpdater(t, fake, "203.0.113.42", DomainConfig{Name: "example.com", Record: "home", Type: "A"})
+	var buf bytes.Buffer
+	updater.logger = newLogger(&buf, "trace")
+	updater.httpClient = &http.Client{Transport: &tracingTransport{base: http.DefaultTransport, logger: updater.logger}}
+	providers, err := updater.buildProviders()
+	if err != nil {
+		t.Fatal(err)
+	}
+	updater.providers = providers
+	h := updater.providers[DefaultProvider]
+	stage := NewStage("publication:dreamhost", 0, 0, func(ctx context.Context) error { return updater.publish(ctx, h) }, nil)
+
+	for range 2 {
+		if err := stage.Execute(context.Background()); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	cycles := map[any]bool{}
+	requests := map[any]bool{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var entry map[string]any
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("decoding %s: %v", line, err)
+		}
+		if entry["cycle_id"] == nil {
+			t.Errorf("expected a cycle ID on %s", line)
+		}
+		cycles[entry["cycle_id"]] = true
+		if strings.HasPrefix(entry["msg"].(string), "API ") {
+			if entry["request_id"] == nil {
+				t.Errorf("expected a request ID on %s", line)
+			}
+			requests[entry["request_id"]] = true
+		}
+	}
+	if len(cycles) != 2 {
+		t.Errorf("expected the lines of 2 cycles, got %d", len(cycles))
+	}
+	// Each cycle reads the record, and the first updates and verifies it
+	if len(requests) < 4 {
+		t.Errorf("expected a request ID per provider call, got %d", len(requests))
+	}
+}
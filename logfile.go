@@ -0,0 +1,314 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LogFileConfig configures logging to a file, for installs without
+// journald or a container runtime collecting stdout. The daemon rotates the
+// file itself.
+type LogFileConfig struct {
+	Path        string        `yaml:"path"`         // File to log to; empty logs to stdout only
+	MaxSizeMB   int           `yaml:"max_size_mb"`  // Size at which the file is rotated (default 10)
+	RotateEvery time.Duration `yaml:"rotate_every"` // Also rotate the file once it has been written to this long; 0 rotates by size only
+	MaxBackups  int           `yaml:"max_backups"`  // Rotated files kept (default 5)
+	MaxAge      time.Duration `yaml:"max_age"`      // Rotated files older than this are deleted; 0 keeps them regardless of age
+	Stdout      bool          `yaml:"stdout"`       // Log to stdout as well
+}
+
+// DefaultLogMaxSizeMB and DefaultLogMaxBackups are the rotation settings
+// used unless log_file gives others.
+const (
+	DefaultLogMaxSizeMB  = 10
+	DefaultLogMaxBackups = 5
+)
+
+// backupTimeLayout is the suffix of rotated log files, the UTC time they
+// were rotated, so that they sort in order.
+const backupTimeLayout = "20060102-150405.000"
+
+// daemonLog is the daemon's log output. It is passed to newDDNSUpdater in
+// place of stdout, which then logs wherever the config's log_file, syslog
+// and journald say. Log files and connections are kept across reloads, so
+// a reload doesn't rotate or reconnect them.
+type daemonLog struct {
+	stdout io.Writer
+
+	mu      sync.Mutex
+	files   map[string]*rotatingFile       // By path
+	syslogs map[SyslogConfig]*syslogWriter // By their settings
+	journal *journalWriter                 // Opened when journald is first enabled
+}
+
+func newDaemonLog(stdout io.Writer) *daemonLog {
+	return &daemonLog{
+		stdout:  stdout,
+		files:   make(map[string]*rotatingFile),
+		syslogs: make(map[SyslogConfig]*syslogWriter),
+	}
+}
+
+// logger returns the logger for config, writing JSON lines to the outputs
+// writer returns and, if journald is enabled, entries to the journal.
+func (l *daemonLog) logger(config *Config) (*slog.Logger, error) {
+	w, err := l.writer(config)
+	if err != nil {
+		return nil, err
+	}
+	if !config.Journald.Enabled {
+		return newLogger(w, config.LogLevel), nil
+	}
+
+	l.mu.Lock()
+	if l.journal == nil {
+		l.journal = &journalWriter{}
+	}
+	level := parseLogLevel(config.LogLevel)
+	var handler slog.Handler = &journalHandler{journal: l.journal, level: level}
+	l.mu.Unlock()
+	if w != nil {
+		handler = fanoutHandler{newLogHandler(w, level), handler}
+	}
+	return slog.New(contextHandler{handler}), nil
+}
+
+// writer returns where to write JSON log lines under config: the log file
+// and syslog if set, and stdout unless one of them or journald is set
+// without asking for stdout too. It returns nil if journald is the only
+// output.
+func (l *daemonLog) writer(config *Config) (io.Writer, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var writers []io.Writer
+	if path := config.LogFile.Path; path != "" {
+		f := l.files[path]
+		if f == nil {
+			f = &rotatingFile{path: path}
+			if err := f.open(time.Now()); err != nil {
+				return nil, err
+			}
+			l.files[path] = f
+		}
+		f.setLimits(config.LogFile)
+		writers = append(writers, f)
+	}
+	elsewhere := config.LogFile.Path != "" || config.Syslog.Address != "" || config.Journald.Enabled
+	if !elsewhere || config.LogFile.Stdout || config.Syslog.Stdout || config.Journald.Stdout {
+		writers = append(writers, l.stdout)
+	}
+	if config.Syslog.Address != "" {
+		w := l.syslogs[config.Syslog]
+		if w == nil {
+			w = newSyslogWriter(config.Syslog)
+			l.syslogs[config.Syslog] = w
+		}
+		writers = append(writers, w)
+	}
+	switch len(writers) {
+	case 0:
+		return nil, nil
+	case 1:
+		return writers[0], nil
+	}
+	return io.MultiWriter(writers...), nil
+}
+
+// Write writes to stdout, where the daemon logs until its config is
+// loaded.
+func (l *daemonLog) Write(p []byte) (int, error) {
+	return l.stdout.Write(p)
+}
+
+// Close closes the open log files and connections.
+func (l *daemonLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for path, f := range l.files {
+		f.Close()
+		delete(l.files, path)
+	}
+	for config, w := range l.syslogs {
+		w.Close()
+		delete(l.syslogs, config)
+	}
+	if l.journal != nil {
+		l.journal.Close()
+	}
+	return nil
+}
+
+// rotatingFile is a log file that is renamed aside, with the time as a
+// suffix, when it grows past its maximum size or has been written to for
+// longer than its rotation interval. Backups past the limits are deleted.
+type rotatingFile struct {
+	path string
+	now  func() time.Time // For tests; nil means time.Now
+
+	mu            sync.Mutex
+	config        LogFileConfig
+	file          *os.File
+	size          int64
+	openedAt      time.Time
+	rotateFailing error // Why the last rotation failed, so a failure is reported once
+	closed        bool
+}
+
+func (f *rotatingFile) setLimits(config LogFileConfig) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.config = config
+}
+
+func (f *rotatingFile) time() time.Time {
+	if f.now != nil {
+		return f.now()
+	}
+	return time.Now()
+}
+
+// open opens the file for appending, creating it and its directory if
+// needed.
+func (f *rotatingFile) open(now time.Time) error {
+	if err := os.MkdirAll(filepath.Dir(f.path), 0755); err != nil {
+		return fmt.Errorf("creating log directory: %w", err)
+	}
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return fmt.Errorf("opening log file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("opening log file: %w", err)
+	}
+	f.file, f.size, f.openedAt = file, info.Size(), now
+	return nil
+}
+
+// Write writes one log line, rotating the file first if the line would
+// take it past its size or it is due to be rotated. A failed rotation is
+// retried on the next write; the line goes to the current file meanwhile.
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+	now := f.time()
+	if f.file == nil {
+		// A rotation couldn't open the new file
+		if err := f.open(now); err != nil {
+			return 0, err
+		}
+	}
+
+	maxSize := int64(f.config.MaxSizeMB) << 20
+	tooBig := maxSize > 0 && f.size+int64(len(p)) > maxSize
+	tooOld := f.config.RotateEvery > 0 && now.Sub(f.openedAt) >= f.config.RotateEvery
+	if f.size > 0 && (tooBig || tooOld) {
+		err := f.rotate(now)
+		if err != nil && f.rotateFailing == nil {
+			// The logger is what failed, so stderr is left to report it
+			fmt.Fprintf(os.Stderr, "Failed to rotate the log file, will retry: %v\n", err)
+		}
+		f.rotateFailing = err
+		if f.file == nil {
+			return 0, err
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// rotate renames the file aside and opens a new one, then deletes the
+// backups past max_backups or max_age. The file is closed first, as
+// Windows can't rename an open file.
+func (f *rotatingFile) rotate(now time.Time) error {
+	backup := f.path + "." + now.UTC().Format(backupTimeLayout)
+	f.file.Close()
+	if err := os.Rename(f.path, backup); err != nil {
+		openedAt := f.openedAt
+		if err := f.open(now); err != nil {
+			f.file = nil
+		}
+		f.openedAt = openedAt
+		return fmt.Errorf("rotating log file: %w", err)
+	}
+	if err := f.open(now); err != nil {
+		f.file = nil
+		return err
+	}
+	return f.removeOldBackups(now)
+}
+
+// removeOldBackups deletes the oldest backups beyond max_backups and those
+// rotated more than max_age before now.
+func (f *rotatingFile) removeOldBackups(now time.Time) error {
+	backups, err := f.backups()
+	if err != nil {
+		return err
+	}
+	for i, backup := range backups {
+		keep := f.config.MaxBackups <= 0 || i < f.config.MaxBackups
+		if f.config.MaxAge > 0 && now.Sub(backup.rotated) > f.config.MaxAge {
+			keep = false
+		}
+		if !keep {
+			if err := os.Remove(backup.path); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("removing old log file: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+type logBackup struct {
+	path    string
+	rotated time.Time
+}
+
+// backups lists the file's backups, newest first.
+func (f *rotatingFile) backups() ([]logBackup, error) {
+	entries, err := os.ReadDir(filepath.Dir(f.path))
+	if err != nil {
+		return nil, fmt.Errorf("listing old log files: %w", err)
+	}
+	prefix := filepath.Base(f.path) + "."
+	var backups []logBackup
+	for _, entry := range entries {
+		suffix, ok := strings.CutPrefix(entry.Name(), prefix)
+		if !ok || entry.IsDir() {
+			continue
+		}
+		rotated, err := time.Parse(backupTimeLayout, suffix)
+		if err != nil {
+			continue // Not one of ours
+		}
+		backups = append(backups, logBackup{filepath.Join(filepath.Dir(f.path), entry.Name()), rotated})
+	}
+	slices.SortFunc(backups, func(a, b logBackup) int { return b.rotated.Compare(a.rotated) })
+	return backups, nil
+}
+
+// Close closes the file. Later writes fail.
+func (f *rotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.closed = true
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
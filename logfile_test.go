@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// logFiles returns the names of the files in dir, sorted.
+func logFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	slices.Sort(names)
+	return names
+}
+
+// TestRotatingFileSize tests that the log file is rotated before a line would take it past its size, keeping max_backups backups
+func TestRotatingFileSize(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	f := &rotatingFile{path: filepath.Join(dir, "logs", "ddns.log"), now: func() time.Time { return now }}
+	f.setLimits(LogFileConfig{MaxSizeMB: 1, MaxBackups: 2})
+	if err := f.open(now); err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	line := []byte(strings.Repeat("x", 400<<10) + "\n")
+	for range 8 {
+		if _, err := f.Write(line); err != nil {
+			t.Fatal(err)
+		}
+		now = now.Add(time.Second)
+	}
+
+	// Two lines fit in each file, so 4 files were written and the oldest dropped
+	want := []string{"ddns.log", "ddns.log.20261001-120004.000", "ddns.log.20261001-120006.000"}
+	if got := logFiles(t, filepath.Join(dir, "logs")); !slices.Equal(got, want) {
+		t.Fatalf("expected files %q, got %q", want, got)
+	}
+	for _, name := range want {
+		info, err := os.Stat(filepath.Join(dir, "logs", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() != 2*int64(len(line)) {
+			t.Errorf("expected %s to hold two lines, got %d bytes", name, info.Size())
+		}
+	}
+}
+
+// TestRotatingFileAge tests rotation every rotate_every, deleting backups older than max_age and keeping files that aren't backups
+func TestRotatingFileAge(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "ddns.log")
+	os.WriteFile(path, []byte("before the restart\n"), 0640)
+	os.WriteFile(path+".old", nil, 0640)
+	now := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	f := &rotatingFile{path: path, now: func() time.Time { return now }}
+	f.setLimits(LogFileConfig{MaxSizeMB: 10, RotateEvery: 24 * time.Hour, MaxAge: 36 * time.Hour})
+	if err := f.open(now); err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	for _, step := range []time.Duration{time.Hour, 23 * time.Hour, 24 * time.Hour, 24 * time.Hour, time.Minute} {
+		now = now.Add(step)
+		io.WriteString(f, now.Format(time.DateTime)+"\n")
+	}
+
+	want := []string{"ddns.log", "ddns.log.20261003-120000.000", "ddns.log.20261004-120000.000", "ddns.log.old"}
+	if got := logFiles(t, dir); !slices.Equal(got, want) {
+		t.Fatalf("expected files %q, got %q", want, got)
+	}
+	data, _ := os.ReadFile(path)
+	if string(data) != "2026-10-04 12:00:00\n2026-10-04 12:01:00\n" {
+		t.Errorf("unexpected current file %q", data)
+	}
+}
+
+// TestDaemonLog tests that the daemon logs to stdout, the file, syslog or several as configured, keeping the file open across reloads
+func TestDaemonLog(t *testing.T) {
+	var stdout bytes.Buffer
+	logs := newDaemonLog(&stdout)
+	defer logs.Close()
+	path := filepath.Join(t.TempDir(), "ddns.log")
+
+	w, err := logs.writer(&Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(w, "stdout only\n")
+
+	w, err = logs.writer(&Config{LogFile: LogFileConfig{Path: path, MaxSizeMB: 10}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(w, "file only\n")
+
+	reloaded, err := logs.writer(&Config{LogFile: LogFileConfig{Path: path, MaxSizeMB: 10, Stdout: true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(reloaded, "both\n")
+	if len(logs.files) != 1 {
+		t.Errorf("expected the file opened once, got %d", len(logs.files))
+	}
+
+	w2, err := logs.writer(&Config{Syslog: SyslogConfig{Address: "udp://127.0.0.1:9", Facility: "daemon", Tag: "ddns"}})
+	if _, ok := w2.(*syslogWriter); !ok || err != nil {
+		t.Errorf("expected only syslog written to, got %T, %v", w2, err)
+	}
+
+	if stdout.String() != "stdout only\nboth\n" {
+		t.Errorf("unexpected stdout %q", stdout.String())
+	}
+	logs.Close()
+	if data, _ := os.ReadFile(path); string(data) != "file only\nboth\n" {
+		t.Errorf("unexpected log file %q", data)
+	}
+	if _, err := io.WriteString(w, "after closing\n"); err == nil {
+		t.Error("expected writes after closing to fail")
+	}
+}
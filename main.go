@@ -3,52 +3,289 @@
 package main
 
 import (
+	"cmp"
 	"context"
+	"crypto/sha256"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
-	"net/url"
 	"os"
 	"os/signal"
 	"path/filepath"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
 	"gopkg.in/yaml.v3"
 )
 
-// Default configuration and state file paths
+// The default configuration and state file paths, which differ on Windows,
+// are in paths_unix.go and paths_windows.go.
 const (
-	DefaultConfigPath = "/etc/dh-ddns-updater/config.yaml"
-	DefaultStatePath  = "/var/lib/dh-ddns-updater/state.json"
-	IPInfoURL         = "https://ipinfo.io/ip"
-	DreamhostAPIBase  = "https://api.dreamhost.com/"
+	IPInfoURL    = "https://ipinfo.io/ip"
+	ipSourceName = "ipinfo" // What IPInfoURL is called in logs and metrics
+
+	// DefaultDreamhostAPIBase is the Dreamhost API endpoint used unless
+	// dreamhost_api_base points elsewhere (e.g. a mock or proxy).
+	DefaultDreamhostAPIBase = "https://api.dreamhost.com/"
+
+	// DefaultMinCheckInterval is the shortest interval accepted unless
+	// min_check_interval lowers it.
+	DefaultMinCheckInterval = 30 * time.Second
+
+	// DefaultShutdownGracePeriod is how long a DNS update in progress is
+	// given to finish once it is cancelled, unless shutdown_grace_period
+	// says otherwise.
+	DefaultShutdownGracePeriod = 30 * time.Second
+
+	// ManagedComment is written to the comment of every record the daemon
+	// creates and marks it as owned by this daemon.
+	ManagedComment = "managed by dh-ddns-updater"
 )
 
+// version is the release, set at build time with -ldflags "-X main.version=...".
+// The rest of the build information is in version.go.
+var version = "dev"
+
 // Config holds the daemon configuration loaded from YAML
 type Config struct {
-	CheckInterval   time.Duration  `yaml:"check_interval"`    // How often to check for IP changes
-	Domains         []DomainConfig `yaml:"domains"`           // List of domains/records to update
-	DreamhostAPIKey string         `yaml:"dreamhost_api_key"` // API key for Dreamhost
-	StatePath       string         `yaml:"state_path"`        // Where to store persistent state
-	LogLevel        string         `yaml:"log_level"`         // Logging level (debug, info, warn, error)
+	CheckInterval    time.Duration  `yaml:"check_interval"`     // How often to check for IP changes
+	PublishInterval  time.Duration  `yaml:"publish_interval"`   // How often to reconcile records even without an IP change
+	RetryInterval    time.Duration  `yaml:"retry_interval"`     // How soon a failed detection or publication is retried
+	CycleTimeout     time.Duration  `yaml:"cycle_timeout"`      // How long one detection or publication may run before it is cancelled
+	Domains          []DomainConfig `yaml:"domains"`            // List of domains/records to update
+	DreamhostAPIKey  string         `yaml:"dreamhost_api_key"`  // API key for the default Dreamhost provider
+	DreamhostAPIBase string         `yaml:"dreamhost_api_base"` // Dreamhost API endpoint (default DefaultDreamhostAPIBase)
+	StatePath        string         `yaml:"state_path"`         // Where to store persistent state
+	PIDFile          string         `yaml:"pid_file"`           // Where to write the daemon's process ID while it runs (optional)
+	LogLevel         string         `yaml:"log_level"`          // Logging level (trace, debug, info, warn, error)
+	Profile          string         `yaml:"profile"`            // Entry of the profiles section to apply; see applyProfile
+
+	// DreamhostAPIKeyFile names a file holding dreamhost_api_key, such as a
+	// container secret mount, so the key needn't appear in the config.
+	DreamhostAPIKeyFile string `yaml:"dreamhost_api_key_file"`
+
+	// DreamhostAPIKeyKeyring names the platform keyring entry holding
+	// dreamhost_api_key; see readKeyringSecret.
+	DreamhostAPIKeyKeyring string `yaml:"dreamhost_api_key_keyring"`
+
+	// WatchConfig reloads the configuration automatically when the config
+	// file or any secret file it references changes (default false, or
+	// true with kubernetes).
+	WatchConfig *bool `yaml:"watch_config"`
+
+	// Kubernetes tunes the defaults for running in a Pod; see
+	// applyKubernetesDefaults.
+	Kubernetes bool `yaml:"kubernetes"`
+
+	// WatchNetwork checks right away when NetworkManager reports a network
+	// change, such as switching to another Wi-Fi network; see watchNetwork.
+	WatchNetwork bool `yaml:"watch_network"`
+
+	// DetectionNetwork is the stack the public IP is detected over: tcp4,
+	// tcp6 or tcp for either. Unset, it follows the address records, tcp4
+	// if they are all A records and tcp6 if all AAAA; see detectionNetwork.
+	DetectionNetwork string `yaml:"detection_network"`
+
+	// MinCheckInterval is the shortest check_interval, publish_interval or
+	// retry_interval accepted, so a typo such as 5s instead of 5m doesn't
+	// hammer the IP service and provider APIs (default 30s).
+	MinCheckInterval time.Duration `yaml:"min_check_interval"`
+
+	// Jitter is the longest random delay added to the first check and to
+	// every scheduled run of detection, publication and the heartbeat, so
+	// devices restarted together after a power cut don't all call the IP
+	// service and provider APIs at once (default 0, no delay).
+	Jitter time.Duration `yaml:"jitter"`
+
+	// MaxRetryInterval caps the retry delay of detection and publication,
+	// which doubles from retry_interval with every failure in a row
+	// (default 1h, or retry_interval if longer).
+	MaxRetryInterval time.Duration `yaml:"max_retry_interval"`
+
+	// ShutdownGracePeriod is how long a DNS update in progress when the
+	// daemon is stopped, or its cycle times out, may take to finish, so
+	// that a record isn't left removed but not yet re-added (default 30s).
+	ShutdownGracePeriod time.Duration `yaml:"shutdown_grace_period"`
+
+	// QuietHours are recurring windows during which DNS changes are
+	// deferred, for when a brief resolution gap must not happen during
+	// business hours; see QuietWindow.
+	QuietHours []QuietWindow `yaml:"quiet_hours"`
+
+	// StateBackend selects how the state is stored: "json" (the default),
+	// "sqlite", "redis", "etcd" or "consul" to share it between instances,
+	// or "none" to keep it only in memory; see StateStore.
+	StateBackend string `yaml:"state_backend"`
+
+	// Redis, Etcd and Consul configure the server holding the state when
+	// state_backend selects it.
+	Redis  RedisConfig  `yaml:"redis"`
+	Etcd   EtcdConfig   `yaml:"etcd"`
+	Consul ConsulConfig `yaml:"consul"`
+
+	// HistorySize is how many IP changes the state keeps (default
+	// DefaultHistorySize).
+	HistorySize int `yaml:"history_size"`
+
+	// NegativeCacheTTL is how long a record confirmed absent while its
+	// creation awaits approval is assumed to stay absent (default 30m).
+	NegativeCacheTTL time.Duration `yaml:"negative_cache_ttl"`
+
+	// LogFile, Syslog and Journald have the daemon log to a file it
+	// rotates, a syslog server or the systemd journal, instead of or as well
+	// as stdout.
+	LogFile  LogFileConfig  `yaml:"log_file"`
+	Syslog   SyslogConfig   `yaml:"syslog"`
+	Journald JournaldConfig `yaml:"journald"`
+
+	// RepeatedErrorInterval is how often an error that recurs every cycle,
+	// such as an API being down, is logged again with how many times it
+	// repeated; the repeats in between aren't logged (default 1h).
+	RepeatedErrorInterval time.Duration `yaml:"repeated_error_interval"`
+
+	// Notifications are sent when records are updated to a new IP, and
+	// when updating a provider's records starts failing or recovers.
+	Notifications NotificationsConfig `yaml:"notifications"`
+
+	configPath  string            // File the config was loaded from
+	secretFiles []string          // Secret files read while loading, for watch_config
+	ageKeyFile  string            // Identity that decrypts age-encrypted secret files
+	fingerprint [sha256.Size]byte // Contents of those files as loaded; see fileFingerprint
+	doc         *yaml.Node        // Parsed document, for the line numbers in validation errors
+	remoteError error             // Why a remote config's cached copy was used instead of a fetched one
+
+	// Strict rejects keys the daemon doesn't know, such as misspelled or
+	// misindented settings, instead of ignoring them (default true).
+	Strict *bool `yaml:"strict"`
+
+	// Include lists files, relative to the config file and possibly globs,
+	// whose records, groups and providers are merged into the config
+	// before the fragments of ConfDir; see includePaths.
+	Include []string `yaml:"include"`
+
+	// ConfDir is a directory of fragments, each adding records, groups or
+	// providers, merged into the config as it is loaded. Relative paths are
+	// taken relative to the config file; empty disables fragments (default
+	// DefaultConfDir).
+	ConfDir string `yaml:"conf_dir"`
+
+	confDir       string                // ConfDir resolved, or "" when fragments weren't read
+	fragmentFiles []string              // Fragments merged, for watch_config
+	includeFiles  []string              // Files and glob directories of include, for watch_config
+	fragments     map[*yaml.Node]string // Merged nodes to the fragment they came from
+
+	// SyncNotesToComment copies each record's notes into the Dreamhost
+	// record comment when the record is (re)created.
+	SyncNotesToComment bool `yaml:"sync_notes_to_comment"`
+
+	// ForceOverwrite allows replacing records that were not created by this
+	// daemon (i.e. whose comment lacks the managed marker).
+	ForceOverwrite bool `yaml:"force_overwrite"`
+
+	DryRun            bool `yaml:"dry_run"`             // Log planned changes without making them
+	RequireApproval   bool `yaml:"require_approval"`    // Hold changes until approved with the apply command
+	RollbackOnFailure bool `yaml:"rollback_on_failure"` // Revert a cycle's changes if any of them fails
+
+	// Providers configures additional provider accounts by name. Records
+	// without a provider use DefaultProvider.
+	Providers map[string]ProviderConfig `yaml:"providers"`
+
+	// Groups holds settings shared by the records that name a group.
+	Groups map[string]GroupConfig `yaml:"groups"`
+
+	// Defaults holds settings for every record that doesn't set its own.
+	Defaults DomainDefaults `yaml:"defaults"`
+
+	LANDNS  LANDNSConfig  `yaml:"lan_dns"` // Embedded DNS responder for LAN clients
+	Webhook WebhookConfig `yaml:"webhook"` // Endpoint for externally pushed IP updates
+	Dyndns2 Dyndns2Config `yaml:"dyndns2"` // dyndns2 server for router DDNS clients
+	RFC2136 RFC2136Config `yaml:"rfc2136"` // Listener for TSIG-signed DNS UPDATE messages
+	Health  HealthConfig  `yaml:"health"`  // Liveness and readiness endpoints for probes
+
+	Telemetry TelemetryConfig `yaml:"telemetry"` // Opt-in anonymous usage report
+	Heartbeat HeartbeatConfig `yaml:"heartbeat"` // Dead man's switch pings to a monitoring service
+	StatsD    StatsDConfig    `yaml:"statsd"`    // Metrics pushed to a StatsD or DogStatsD server
+	GeoIP     GeoIPConfig     `yaml:"geoip"`     // Network and country of new IPs, for change logs and notifications
+
+	HTTP HTTPConfig `yaml:"http"` // Timeouts and connection reuse of outgoing HTTP requests
+
+	// Leader lets redundant instances share the records, only the one
+	// holding the lease updating them; see LeaderConfig.
+	Leader LeaderConfig `yaml:"leader_election"`
 }
 
 // DomainConfig represents a single DNS record to manage
 type DomainConfig struct {
 	Name   string `yaml:"name"`   // Domain name (e.g., "example.com")
-	Type   string `yaml:"type"`   // Record type (e.g., "A", "AAAA")
+	Type   string `yaml:"type"`   // Record type (e.g., "A", "AAAA", "TXT", "CNAME", "MX", "SRV", "CAA")
 	Record string `yaml:"record"` // Subdomain/record name (e.g., "home" for home.example.com, "" for apex)
+	Value  string `yaml:"value"`  // Static or templated value; empty means "the current IP"
+	Notes  string `yaml:"notes"`  // Operator-facing context (e.g., "port-forward 51820 on router")
+	TTL    int    `yaml:"ttl"`    // TTL in seconds, for providers that can set TTLs (default: the group's, else the provider's)
+
+	Provider string `yaml:"provider"` // Name of the provider hosting the record (default "dreamhost")
+
+	LANAddress string `yaml:"lan_address"` // Internal address served to LAN clients by the embedded DNS responder
+
+	Schedule []ScheduleEntry `yaml:"schedule"` // Time windows in which the record takes a different value
+
+	Group  string           `yaml:"group"` // Entry of groups whose settings apply to the record
+	create CreationDefaults // The group's creation defaults, resolved by validateConfig
+}
+
+// DomainDefaults holds the settings a record takes when it doesn't set
+// them, so a long list of records needn't repeat them.
+type DomainDefaults struct {
+	Type     string `yaml:"type"`     // Record type
+	TTL      int    `yaml:"ttl"`      // TTL in seconds, after the record's group's
+	Provider string `yaml:"provider"` // Name of the provider hosting the record
+}
+
+// FQDN returns the fully qualified record name, e.g. "home.example.com",
+// or just the domain name for apex records.
+func (dc DomainConfig) FQDN() string {
+	if dc.Record == "" {
+		return dc.Name
+	}
+	return fmt.Sprintf("%s.%s", dc.Record, dc.Name)
 }
 
 // State holds persistent data between daemon runs
 type State struct {
-	LastIP      string            `json:"last_ip"`      // Last known public IP address
-	LastUpdated time.Time         `json:"last_updated"` // When records were last updated
-	Records     map[string]string `json:"records"`      // Map of record names to their current IP values
+	LastIP      string            `json:"last_ip"`           // Last known public IP address
+	LastUpdated time.Time         `json:"last_updated"`      // When records were last updated
+	Records     map[string]string `json:"records"`           // Current value of each record, by stateKey
+	Version     string            `json:"version,omitempty"` // Release that last ran with this state; see checkUpgrade
+	History     []IPChange        `json:"history,omitempty"` // Newest IP changes, oldest first; see recordIPChange
+
+	// RecordStatus tracks how each record's updates have gone, by
+	// stateKey. Releases before 1.1.0, which keyed Records by name alone,
+	// find none of the records after a downgrade and list them from DNS
+	// again; upgrading migrates what they wrote.
+	RecordStatus map[string]*RecordStatus `json:"record_status,omitempty"`
+
+	// Backoff holds the failures in a row of each stage that is backing
+	// off, by stage name, so that a restart doesn't reset it; see
+	// restoreBackoff.
+	Backoff map[string]*StageBackoff `json:"backoff,omitempty"`
+
+	revision int64 // Revision loaded from a shared store; see sharedStateStore
+}
+
+// RecordStatus is how the updates of one record have gone, for status
+// reporting and per-record decisions.
+type RecordStatus struct {
+	LastUpdated         time.Time `json:"last_updated,omitzero"`          // Last successful update
+	LastVerified        time.Time `json:"last_verified,omitzero"`         // Last time the record was seen holding its desired value
+	LastFailure         time.Time `json:"last_failure,omitzero"`          // Last failed update
+	ConsecutiveFailures int       `json:"consecutive_failures,omitempty"` // Failed updates since the last success
+	LastError           string    `json:"last_error,omitempty"`           // Why the last update failed
 }
 
 // IPInfoResponse represents the JSON response from ipinfo.io
@@ -56,10 +293,19 @@ type IPInfoResponse struct {
 	IP string `json:"ip"`
 }
 
-// DreamhostResponse represents the JSON response from Dreamhost API
-type DreamhostResponse struct {
-	Result string `json:"result"` // "success" or "error"
-	Data   string `json:"data"`   // Response message or error details
+// DNSRecord is a single record as returned by dns-list_records
+type DNSRecord struct {
+	Record  string `json:"record"`        // Fully qualified record name
+	Type    string `json:"type"`          // Record type (e.g., "A")
+	Value   string `json:"value"`         // Record value
+	Comment string `json:"comment"`       // Free-form comment set in the panel or by us
+	Zone    string `json:"zone"`          // Zone (domain) the record belongs to
+	TTL     int    `json:"ttl,omitempty"` // TTL in seconds, from providers that can set TTLs; 0 if unknown
+}
+
+// Managed reports whether the record was created by this daemon.
+func (r *DNSRecord) Managed() bool {
+	return strings.HasPrefix(r.Comment, ManagedComment)
 }
 
 // DDNSUpdater is the main daemon struct that orchestrates IP checking and DNS updates
@@ -68,246 +314,643 @@ type DDNSUpdater struct {
 	state      *State
 	httpClient *http.Client
 	logger     *slog.Logger
+
+	// detectionClient fetches the public IP, over detection_network with
+	// its own connections so none opened over the other stack is reused.
+	detectionClient *http.Client
+
+	// detectionClient6 detects the IPv6 address over tcp6 when the config is
+	// dual-stack, detectionClient then detecting the IPv4 one; nil otherwise.
+	detectionClient6 *http.Client
+
+	// The run loop is split into a detection stage, which writes the public
+	// IP into the desired store, and one publication stage per provider,
+	// which reconciles that provider's records against it. Each stage is
+	// scheduled independently. The schedule stage, which only runs if a
+	// record has a schedule or quiet_hours are set, writes scheduled values
+	// and whether quiet hours are in effect the same way.
+	desired       *DesiredStore
+	detection     *Stage
+	schedule      *Stage
+	telemetry     *Stage // nil unless telemetry.enabled is set
+	heartbeat     *Stage // nil unless heartbeat.url is set
+	homeAssistant *Stage // nil unless an MQTT notification has home_assistant set
+	leader        *Stage // nil unless leader_election.enabled is set
+	providers     map[string]*providerHandle
+	rfc2136       *rfc2136Server // nil unless rfc2136.listen is set
+	startupIP     string         // state.LastIP as loaded, so detection never reads live state
+	rebuild       bool           // The state file was corrupt, so the records are read from DNS on start
+	started       time.Time      // When Run started, for the uptime in status reports
+
+	stateMu sync.Mutex // Guards state, which publication stages update concurrently
+
+	checkNow chan struct{} // Receives RequestCheck's requests; see serveCheckRequests
+
+	pendingMu sync.Mutex
+	pending   map[string]*Plan // Per-provider plans awaiting approval when require_approval is set
+	deferred  map[string]*Plan // Per-provider plans last held back during quiet hours
+	absent    *absenceCache    // Records whose pending creation needn't be listed again
+
+	repeats       *errorRepeats   // Collapses errors repeating every cycle in the logs
+	notifications *notifications  // nil unless notifications are configured
+	stats         *statsd         // nil unless statsd.address is set
+	election      *leaderElection // nil unless leader_election.enabled is set
+
+	ipSourceLatency latencyTracker // Calls to the IP source; see Dependencies
+
+	geoip  *geoIP // nil unless geoip.source is set
+	geoMu  sync.Mutex
+	geoFor string   // IP geo was looked up for
+	geo    *GeoInfo // Last lookup; see geoInfo
+
+	progress *progress // Status line for interactive commands; nil otherwise
+
+	// stateReadOnly keeps the state from being saved, for a command run
+	// while another instance holds the state lock.
+	stateReadOnly bool
+	lock          *stateLock // Held for as long as the updater may save state
 }
 
 // NewDDNSUpdater creates and initializes a new DDNSUpdater instance.
 // It loads configuration from the specified path, sets up logging, and loads
 // any existing state from disk. Returns an error if configuration is invalid.
 func NewDDNSUpdater(configPath string) (*DDNSUpdater, error) {
-	config, err := loadConfig(configPath)
+	return newDDNSUpdater(configPath, configOptions{}, os.Stdout)
+}
+
+// newDDNSUpdater is NewDDNSUpdater with the config read as opts says and
+// logs written to logOutput, or where log_file, syslog and journald say if
+// it is a daemonLog.
+func newDDNSUpdater(configPath string, opts configOptions, logOutput io.Writer) (*DDNSUpdater, error) {
+	config, err := loadUpdaterConfig(configPath, opts)
+	if err != nil {
+		return nil, err
+	}
+	return newUpdaterFor(config, logOutput)
+}
+
+// loadUpdaterConfig loads the config at configPath as opts says, applies
+// the defaults and validates it.
+func loadUpdaterConfig(configPath string, opts configOptions) (*Config, error) {
+	config, err := loadConfig(configPath, opts)
 	if err != nil {
 		return nil, fmt.Errorf("loading config: %w", err)
 	}
 
-	// Set defaults
-	if config.CheckInterval == 0 {
-		config.CheckInterval = 5 * time.Minute
+	applyConfigDefaults(config)
+	if err := errors.Join(validateConfig(config)...); err != nil {
+		return nil, err
 	}
-	if config.StatePath == "" {
-		config.StatePath = DefaultStatePath
+	return config, nil
+}
+
+// newUpdaterFor creates the updater for a config loadUpdaterConfig
+// returned, loading its state. Loading can create the state file or move a
+// corrupt one aside, so a caller taking the state lock takes it first.
+func newUpdaterFor(config *Config, logOutput io.Writer) (*DDNSUpdater, error) {
+	var logger *slog.Logger
+	var err error
+	if logs, ok := logOutput.(*daemonLog); ok {
+		if logger, err = logs.logger(config); err != nil {
+			return nil, err
+		}
+	} else {
+		logger = newLogger(logOutput, config.LogLevel)
 	}
-	if config.LogLevel == "" {
-		config.LogLevel = "info"
+	if config.remoteError != nil {
+		logger.Warn("Fetching remote config failed; using the cached copy", "url", redactConfigURL(config.configPath), "error", config.remoteError)
 	}
 
-	// Setup logging
-	var level slog.Level
-	switch strings.ToLower(config.LogLevel) {
-	case "debug":
-		level = slog.LevelDebug
-	case "info":
-		level = slog.LevelInfo
-	case "warn":
-		level = slog.LevelWarn
-	case "error":
-		level = slog.LevelError
-	default:
-		level = slog.LevelInfo
+	state, err := stateStoreFor(config).Load()
+	var corrupt *stateCorruptError
+	if errors.As(err, &corrupt) {
+		backup, err := backupStateFile(corrupt.path)
+		if err != nil {
+			return nil, fmt.Errorf("loading state: %w; backing it up: %w", corrupt, err)
+		}
+		logger.Error("State file is corrupt; it was moved aside and the records will be read from DNS", "error", corrupt, "backup", backup)
+		state = &State{Records: make(map[string]string)}
+	} else if err != nil {
+		return nil, fmt.Errorf("loading state: %w", err)
 	}
 
-	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
-		Level: level,
-	}))
+	d := &DDNSUpdater{
+		config:    config,
+		state:     state,
+		logger:    logger,
+		desired:   NewDesiredStore(),
+		absent:    newAbsenceCache(config.NegativeCacheTTL),
+		checkNow:  make(chan struct{}, 1),
+		repeats:   newErrorRepeats(config.RepeatedErrorInterval),
+		stats:     newStatsD(config.StatsD, logger),
+		election:  newLeaderElection(config, logger),
+		startupIP: state.LastIP,
+		rebuild:   corrupt != nil,
+	}
+
+	if d.httpClient, err = newHTTPClient(config.HTTP, "tcp", logger); err != nil {
+		return nil, err
+	}
+	detection := config.HTTP
+	detection.Timeout = config.HTTP.DetectionTimeout
+	if d.detectionClient, err = newHTTPClient(detection, config.detectionNetwork(), logger); err != nil {
+		return nil, err
+	}
+	if config.dualStack() {
+		if d.detectionClient6, err = newHTTPClient(detection, "tcp6", logger); err != nil {
+			return nil, err
+		}
+	}
+	if config.HTTP.InsecureSkipVerify {
+		logger.Warn("TLS certificates aren't verified (http.insecure_skip_verify); the API key can be intercepted")
+	}
+	if d.notifications, err = newNotifications(config.Notifications, d.httpClient, logger); err != nil {
+		return nil, err
+	}
+	if d.geoip, err = newGeoIP(config.GeoIP, d.httpClient); err != nil {
+		return nil, err
+	}
+	d.seedPushedValues()
+	d.evaluateSchedules(time.Now())
+	d.evaluateQuietHours(time.Now())
+
+	d.detection = NewStage("detection", config.CheckInterval, config.RetryInterval, d.detect, nil)
+	d.detection.RunOnStart = true
+	d.detection.Timeout = config.CycleTimeout
+	d.detection.Repeats = d.repeats
+	d.detection.Stats = d.stats
+	d.detection.Jitter = config.Jitter
+	d.detection.MaxRetryInterval = config.MaxRetryInterval
+	d.detection.OnRun = d.saveBackoff(d.detection.Name)
+	d.schedule = NewStage("schedule", time.Minute, time.Minute, d.runSchedules, nil)
+	if config.Telemetry.Enabled {
+		d.telemetry = NewStage("telemetry", telemetryInterval, telemetryInterval, d.reportUsage, nil)
+		d.telemetry.RunOnStart = true
+	}
+	if config.Heartbeat.URL != "" {
+		// Not run on start: the stages it reports on haven't run yet
+		d.heartbeat = NewStage("heartbeat", config.Heartbeat.Interval, config.Heartbeat.Interval, d.sendHeartbeat, nil)
+		d.heartbeat.Repeats = d.repeats
+		d.heartbeat.Jitter = config.Jitter
+	}
+	if len(d.homeAssistantNotifiers()) > 0 {
+		// Not run on start either, so the entities don't show a problem
+		// until the stages have had a chance to run
+		d.homeAssistant = NewStage("home_assistant", config.CheckInterval, config.RetryInterval, d.publishHomeAssistant, nil)
+		d.homeAssistant.Repeats = d.repeats
+	}
+	if d.election != nil {
+		// Renewed every third of the lease, so that two renewals can fail
+		// before it runs out
+		renew := config.Leader.TTL / 3
+		d.leader = NewStage("leader_election", renew, renew, d.elect, nil)
+		d.leader.Timeout = renew
+		d.leader.Repeats = d.repeats
+	}
 
-	state, err := loadState(config.StatePath)
+	d.providers, err = d.buildProviders()
 	if err != nil {
-		return nil, fmt.Errorf("loading state: %w", err)
+		return nil, err
+	}
+	problems, warnings := d.checkCapabilities()
+	if err := errors.Join(problems...); err != nil {
+		return nil, err
+	}
+	for _, warning := range warnings {
+		d.logger.Warn("Configuration not supported by provider", "warning", warning)
+	}
+	if config.RFC2136.Listen != "" {
+		if d.rfc2136, err = newRFC2136Server(d); err != nil {
+			return nil, err
+		}
 	}
+	for _, h := range d.providers {
+		h.stage = NewStage("publication:"+h.name, config.PublishInterval, config.RetryInterval, func(ctx context.Context) error {
+			if !d.leading() {
+				d.logger.DebugContext(ctx, "Not the leader; leaving the records to it", "provider", h.name, "leader", d.election.Leader())
+				return nil
+			}
+			// Recovered here, not only by the stage, so that a panic is
+			// notified as a failure too
+			err := recoverPanic(func() error { return d.publish(ctx, h) })
+			if ctx.Err() == nil {
+				// Shutting down isn't a failure worth notifying
+				d.notifyOutcome(h, err)
+			}
+			return err
+		}, d.desired.Subscribe())
+		h.stage.Timeout = config.CycleTimeout
+		h.stage.Repeats = d.repeats
+		h.stage.Stats = d.stats
+		h.stage.Jitter = config.Jitter
+		h.stage.MaxRetryInterval = config.MaxRetryInterval
+		h.stage.OnRun = d.saveBackoff(h.stage.Name)
+	}
+	d.restoreBackoff()
+
+	return d, nil
+}
+
+// newLogger creates the JSON logger used throughout the daemon, writing to w
+// at the given level (debug, info, warn, error; anything else means info).
+func newLogger(w io.Writer, logLevel string) *slog.Logger {
+	return slog.New(contextHandler{newLogHandler(w, parseLogLevel(logLevel))})
+}
+
+// newLogHandler returns the handler writing JSON log lines to w.
+func newLogHandler(w io.Writer, level slog.Leveler) slog.Handler {
+	return slog.NewJSONHandler(w, &slog.HandlerOptions{
+		Level:       level,
+		ReplaceAttr: traceLevelName,
+	})
+}
 
-	return &DDNSUpdater{
-		config: config,
-		state:  state,
-		httpClient: &http.Client{
-			Timeout: 30 * time.Second,
-		},
-		logger: logger,
-	}, nil
+// parseLogLevel returns the level log_level names, or info if it names
+// none.
+func parseLogLevel(logLevel string) slog.Level {
+	switch strings.ToLower(logLevel) {
+	case "trace":
+		return LevelTrace
+	case "debug":
+		return slog.LevelDebug
+	case "warn":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	}
+	return slog.LevelInfo
 }
 
-// Run starts the main daemon loop. It performs an initial IP check, then runs
-// on a timer checking for IP changes at the configured interval. The loop continues
-// until the context is cancelled (typically by a signal handler).
+// Run starts the detection and publication stages and blocks until the
+// context is cancelled (typically by a signal handler). Detection runs
+// immediately and then every check interval; publication runs whenever the
+// detected IP changes and otherwise every publish interval. Run returns early
+// with an error if one of the configured listeners fails.
 func (d *DDNSUpdater) Run(ctx context.Context) error {
+	d.started = time.Now()
+	build := currentBuild()
 	d.logger.Info("Starting DDNS updater",
+		"version", build.Version,
+		"commit", build.ShortCommit(),
+		"go_version", build.GoVersion,
+		"profile", d.config.Profile,
 		"check_interval", d.config.CheckInterval,
+		"publish_interval", d.config.PublishInterval,
 		"domains", len(d.config.Domains))
 
-	ticker := time.NewTicker(d.config.CheckInterval)
-	defer ticker.Stop()
-
-	// Do initial check
-	if err := d.checkAndUpdate(ctx); err != nil {
-		d.logger.Error("Initial check failed", "error", err)
+	if err := d.checkUpgrade(); err != nil {
+		return err
 	}
-
-	for {
-		select {
-		case <-ctx.Done():
-			d.logger.Info("Shutting down")
-			return ctx.Err()
-		case <-ticker.C:
-			if err := d.checkAndUpdate(ctx); err != nil {
-				d.logger.Error("Check and update failed", "error", err)
-			}
-		}
+	if err := d.pruneOnStart(); err != nil {
+		d.logger.Error("Failed to save state", "error", err)
 	}
-}
 
-// checkAndUpdate performs one cycle of IP checking and DNS updating.
-// It fetches the current public IP, compares it to the last known IP,
-// and updates all configured DNS records if the IP has changed.
-// Returns an error if any critical operations fail.
-func (d *DDNSUpdater) checkAndUpdate(ctx context.Context) error {
-	currentIP, err := d.getCurrentIP(ctx)
-	if err != nil {
-		return fmt.Errorf("getting current IP: %w", err)
+	for _, domain := range d.config.Domains {
+		d.logger.Debug("Managing DNS record",
+			"domain", domain.Name,
+			"record", domain.Record,
+			"type", domain.Type,
+			"notes", domain.Notes)
 	}
 
-	d.logger.Debug("Current IP", "ip", currentIP)
+	// The watchdog keepalives start before the checks below, which may take
+	// a while on a slow network
+	watchdogCtx, stopWatchdog := context.WithCancel(ctx)
+	defer stopWatchdog()
+	go d.superviseSystemd(watchdogCtx)
 
-	// Log IP change if it occurred, but don't exit early
-	if currentIP != d.state.LastIP {
-		d.logger.Info("IP changed", "old", d.state.LastIP, "new", currentIP)
+	if err := d.checkProviders(ctx); err != nil {
+		return err
+	}
+	if d.rebuild {
+		d.rebuildState(ctx)
+	}
+	if d.leader != nil {
+		// Settled before the stages start, so the leader publishes on its
+		// first detection and a standby doesn't
+		if err := d.leader.Execute(ctx); err != nil {
+			d.logger.Error("Leader election failed; standing by", "error", err)
+		} else if !d.election.Leading() {
+			d.logger.Info("Standing by; another instance is the leader", "id", d.config.Leader.ID, "leader", d.election.Leader())
+		}
 	}
 
-	var updateErrors []error
-	updatedAnyRecord := false
+	// Every listener and stage runs in one group: cancelling ctx stops them
+	// all, and a listener that fails to start or dies stops the daemon
+	// rather than leaving it running without it.
+	g, gctx := newTaskGroup(ctx)
+	serve := func(what string, fn func(ctx context.Context, addr string) error, addr string) {
+		g.Go(func() error {
+			if err := fn(gctx, addr); err != nil && gctx.Err() == nil {
+				return fmt.Errorf("%s: %w", what, err)
+			}
+			return nil
+		})
+	}
+	if d.config.LANDNS.Listen != "" {
+		server := &lanDNSServer{updater: d, ttl: d.config.LANDNS.TTL}
+		serve("LAN DNS responder", server.Serve, d.config.LANDNS.Listen)
+	}
+	if d.config.Webhook.Listen != "" {
+		serve("webhook receiver", d.serveWebhook, d.config.Webhook.Listen)
+	}
+	if d.config.Dyndns2.Listen != "" {
+		serve("dyndns2 server", d.serveDyndns2, d.config.Dyndns2.Listen)
+	}
+	if d.rfc2136 != nil {
+		serve("RFC 2136 update listener", d.rfc2136.Serve, d.config.RFC2136.Listen)
+	}
+	if d.config.Health.Listen != "" {
+		serve("health endpoints", d.serveHealth, d.config.Health.Listen)
+	}
+	for _, stage := range d.stages() {
+		g.Go(func() error {
+			stage.Loop(gctx, d.logger)
+			return nil
+		})
+	}
+	g.Go(func() error {
+		d.serveCheckRequests(gctx)
+		return nil
+	})
+	if d.config.WatchNetwork {
+		g.Go(func() error {
+			d.watchNetwork(gctx)
+			return nil
+		})
+	}
 
-	for _, domain := range d.config.Domains {
-		recordKey := fmt.Sprintf("%s.%s", domain.Record, domain.Name)
-		if domain.Record == "" {
-			recordKey = domain.Name
-		}
+	d.notifySystemd(ctx, "READY=1\nSTATUS="+d.systemdStatus())
 
-		// Always check current DNS record value
-		currentRecordIP, err := d.getCurrentDNSRecord(ctx, domain)
+	err := g.Wait()
+	d.logger.Info("Shutting down")
+	if d.election != nil {
+		releaseCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), sharedStateTimeout)
+		err := d.election.release(releaseCtx)
+		cancel()
 		if err != nil {
-			d.logger.Warn("Failed to get current DNS record, will update anyway",
-				"domain", domain.Name,
-				"record", domain.Record,
-				"error", err)
-			currentRecordIP = "" // Force update if we can't check
+			d.logger.Warn("Failed to release the leader lease; a standby takes over once it runs out", "error", err)
 		}
+	}
+	d.notifications.wait() // Deliver the last notifications before exiting
+	d.stats.Close()
+	if err != nil {
+		return err
+	}
+	return ctx.Err()
+}
 
-		// If the record already has the correct IP, just move on.
-		if currentRecordIP == currentIP {
-			d.logger.Debug("DNS record already up to date",
-				"domain", domain.Name,
-				"record", domain.Record,
-				"ip", currentIP)
-			d.state.Records[recordKey] = currentIP
-			continue
+// RunOnce runs a single cycle, detection followed by publication for every
+// provider, and returns why it failed if it did; see checkAndUpdate. It is
+// the daemon run by --once, as cron or a systemd timer would, so the state
+// is prepared as Run prepares it and notifications are delivered before it
+// returns.
+func (d *DDNSUpdater) RunOnce(ctx context.Context) error {
+	d.started = time.Now()
+	if err := d.checkUpgrade(); err != nil {
+		return err
+	}
+	if err := d.pruneOnStart(); err != nil {
+		d.logger.Error("Failed to save state", "error", err)
+	}
+	if d.rebuild {
+		d.rebuildState(ctx)
+	}
+	if d.election != nil {
+		// The lease is kept when the run ends, so that a standby's run
+		// starting meanwhile leaves the records alone
+		if leading, err := d.election.campaign(ctx); err != nil {
+			return fmt.Errorf("leader election: %w", err)
+		} else if !leading {
+			d.logger.Info("Another instance is the leader; not updating", "leader", d.election.Leader())
+			return nil
 		}
-
-		d.logger.Info("Updating DNS record",
-			"domain", domain.Name,
-			"record", domain.Record,
-			"old_ip", currentRecordIP,
-			"new_ip", currentIP)
-
-		if err := d.updateDNSRecord(ctx, domain, currentIP); err != nil {
-			d.logger.Error("Failed to update DNS record",
-				"domain", domain.Name,
-				"record", domain.Record,
-				"error", err)
-			updateErrors = append(updateErrors, err)
-		} else {
-			d.logger.Info("Successfully updated DNS record",
-				"domain", domain.Name,
-				"record", domain.Record,
-				"ip", currentIP)
-			d.state.Records[recordKey] = currentIP
-			updatedAnyRecord = true
+	}
+	// Spreads runs that cron starts on every device at the same minute
+	if delay := jitter(d.config.Jitter); delay > 0 {
+		d.logger.Debug("Delaying the check", "jitter", delay)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
 		}
 	}
 
-	// Update state if we successfully processed everything
-	if len(updateErrors) == 0 {
-		d.state.LastIP = currentIP
-		if updatedAnyRecord {
-			d.state.LastUpdated = time.Now()
+	err := d.checkAndUpdate(ctx)
+	d.notifications.wait()
+	d.stats.Close()
+	return err
+}
+
+// stages returns the stages Run runs: detection unless polling is
+// disabled, the optional ones configured and every provider's publication.
+func (d *DDNSUpdater) stages() []*Stage {
+	var stages []*Stage
+	if !d.config.Webhook.DisablePolling {
+		stages = append(stages, d.detection)
+	}
+	if d.hasSchedules() || len(d.config.QuietHours) > 0 {
+		stages = append(stages, d.schedule)
+	}
+	if d.telemetry != nil {
+		stages = append(stages, d.telemetry)
+	}
+	if d.heartbeat != nil {
+		stages = append(stages, d.heartbeat)
+	}
+	if d.homeAssistant != nil {
+		stages = append(stages, d.homeAssistant)
+	}
+	if d.leader != nil {
+		stages = append(stages, d.leader)
+	}
+	for _, name := range d.providerNames() {
+		stages = append(stages, d.providers[name].stage)
+	}
+	return stages
+}
+
+// checkAndUpdate performs one synchronous cycle of detection followed by
+// publication for every provider, outside of the stage schedules. Detection
+// is skipped if polling is disabled, leaving the IP last pushed.
+func (d *DDNSUpdater) checkAndUpdate(ctx context.Context) error {
+	ctx = withCycleID(ctx)
+	if !d.config.Webhook.DisablePolling {
+		if err := d.detection.Execute(ctx); err != nil {
+			return err
 		}
+	}
 
-		if err := d.saveState(); err != nil {
-			d.logger.Error("Failed to save state", "error", err)
+	var errs []error
+	for _, name := range d.providerNames() {
+		if err := d.providers[name].stage.Execute(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
 		}
 	}
+	return errors.Join(errs...)
+}
 
-	if len(updateErrors) > 0 {
-		return fmt.Errorf("failed to update %d records", len(updateErrors))
+// detect is the detection stage. It fetches the current public IP, and the
+// IPv6 address too when the config is dual-stack, and records them in the
+// desired store, which wakes the publication stage when either changes.
+func (d *DDNSUpdater) detect(ctx context.Context) error {
+	start := time.Now()
+	currentIP, err := d.getCurrentIP(withRequestID(ctx), d.detectionClient)
+	if ctx.Err() == nil {
+		// A cancelled call says nothing about the source
+		d.observeIPSource(time.Since(start), err)
+	}
+	if err != nil {
+		return fmt.Errorf("getting current IP: %w", err)
 	}
 
+	d.logger.DebugContext(ctx, "Current IP", "ip", currentIP)
+	d.setPublicIP(ctx, currentIP, ipSourceName)
+
+	if d.detectionClient6 != nil {
+		currentIP, err := d.getCurrentIP(withRequestID(ctx), d.detectionClient6)
+		if err != nil {
+			return fmt.Errorf("getting current IPv6 address: %w", err)
+		}
+		d.logger.DebugContext(ctx, "Current IPv6 address", "ip", currentIP)
+		d.setPublicIP(ctx, currentIP, ipSourceName)
+	}
 	return nil
 }
 
-// getCurrentDNSRecord fetches the current value of a DNS record from Dreamhost.
-// Returns the current IP address for the record, or an empty string if the record
-// doesn't exist or if there's an error fetching it.
-func (d *DDNSUpdater) getCurrentDNSRecord(ctx context.Context, domain DomainConfig) (string, error) {
-	params := url.Values{}
-	params.Set("key", d.config.DreamhostAPIKey)
-	params.Set("cmd", "dns-list_records")
-	params.Set("format", "json")
-
-	apiURL := DreamhostAPIBase + "?" + params.Encode()
+// publicIPSource returns the desired-store source a public IP is kept
+// under: IPv6Source for an IPv6 address when the config is dual-stack,
+// DefaultSource otherwise.
+func (d *DDNSUpdater) publicIPSource(ip string) string {
+	if d.config.dualStack() && !ipMatchesType(ip, "A") {
+		return IPv6Source
+	}
+	return DefaultSource
+}
 
-	req, err := http.NewRequestWithContext(ctx, "GET", apiURL, nil)
-	if err != nil {
-		return "", err
+// setPublicIP records the public IP reported by via (ipinfo or the
+// webhook) and logs when it differs from the last known one. It returns
+// true if the desired value changed.
+func (d *DDNSUpdater) setPublicIP(ctx context.Context, ip, via string) bool {
+	source := d.publicIPSource(ip)
+	previous, known := d.desired.Get(source)
+	var geo *GeoInfo
+	if !known || previous.Value != ip {
+		// Looked up before the value is set, which wakes the publication
+		// stages, so that their notifications have it
+		geo = d.lookupGeo(ctx, ip)
+	}
+	if !d.desired.Set(source, ip) {
+		return false
+	}
+	var old string
+	if source == DefaultSource {
+		old = d.startupIP
+	}
+	if known {
+		old = previous.Value
+	}
+	if old == ip {
+		return true
+	}
+	attrs := []any{"old", old, "new", ip, "via", via}
+	if geo != nil {
+		attrs = append(attrs, "asn", geo.ASN, "as_org", geo.ASOrg, "country", geo.Country)
+	}
+	if geo != nil && geo.Unexpected {
+		d.logger.WarnContext(ctx, "IP changed to an unexpected network", attrs...)
+	} else {
+		d.logger.InfoContext(ctx, "IP changed", attrs...)
 	}
+	return true
+}
 
-	resp, err := d.httpClient.Do(req)
+// publish is a provider's publication stage. It plans the changes needed to
+// bring the provider's records to their desired values and applies them
+// under the configured policy. When approval is required, a plan with
+// changes is held as pending instead of being applied; during quiet hours
+// it is deferred until they end.
+func (d *DDNSUpdater) publish(ctx context.Context, h *providerHandle) error {
+	plan, err := d.plan(ctx, d.providerDomains(h.name))
 	if err != nil {
-		return "", err
+		return fmt.Errorf("planning: %w", err)
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("HTTP %d from Dreamhost API", resp.StatusCode)
-	}
+	policy := d.applyPolicy()
+	if policy.RequireApproval && len(plan.Changes()) > 0 {
+		d.pendingMu.Lock()
+		if d.pending == nil {
+			d.pending = make(map[string]*Plan)
+		}
+		previous := d.pending[h.name]
+		d.pending[h.name] = plan
+		d.pendingMu.Unlock()
 
-	var dhResp struct {
-		Result string `json:"result"`
-		Data   []struct {
-			Record string `json:"record"`
-			Type   string `json:"type"`
-			Value  string `json:"value"`
-		} `json:"data"`
-	}
+		for _, a := range plan.Changes() {
+			if a.Kind == ActionCreate {
+				d.absent.Add(a.Domain, a.Desired)
+			}
+		}
+		if previous != nil && previous.sameChanges(plan) {
+			// Already reported; don't repeat it every cycle
+			return nil
+		}
 
-	if err := json.NewDecoder(resp.Body).Decode(&dhResp); err != nil {
-		return "", fmt.Errorf("decoding response: %w", err)
+		d.logger.WarnContext(ctx, "Changes are awaiting approval; run the apply command to make them",
+			"provider", h.name,
+			"changes", len(plan.Changes()))
+		for _, a := range plan.Changes() {
+			d.logger.InfoContext(ctx, "Pending DNS change",
+				"record", a.Record,
+				"type", a.Type,
+				"kind", a.Kind,
+				"old_value", a.Current,
+				"new_value", a.Desired)
+		}
+		return nil
 	}
 
-	if dhResp.Result != "success" {
-		return "", fmt.Errorf("dreamhost API error")
+	if len(plan.Changes()) > 0 && d.inQuietHours(time.Now()) {
+		d.deferChanges(ctx, h, plan)
+		return nil
 	}
 
-	// Find the matching record
-	targetRecord := domain.Name
-	if domain.Record != "" {
-		targetRecord = fmt.Sprintf("%s.%s", domain.Record, domain.Name)
-	}
+	d.pendingMu.Lock()
+	delete(d.pending, h.name)
+	delete(d.deferred, h.name)
+	d.pendingMu.Unlock()
+	return d.apply(ctx, plan, policy)
+}
+
+// PendingPlan returns the changes held for approval across all providers,
+// or nil if there are none.
+func (d *DDNSUpdater) PendingPlan() *Plan {
+	d.pendingMu.Lock()
+	defer d.pendingMu.Unlock()
 
-	for _, record := range dhResp.Data {
-		if record.Record == targetRecord && record.Type == domain.Type {
-			return record.Value, nil
+	var merged *Plan
+	for _, name := range d.providerNames() {
+		plan, ok := d.pending[name]
+		if !ok {
+			continue
+		}
+		if merged == nil {
+			merged = &Plan{CreatedAt: plan.CreatedAt, IP: plan.IP}
 		}
+		merged.Actions = append(merged.Actions, plan.Changes()...)
 	}
-
-	// Record not found
-	return "", nil
+	return merged
 }
 
-// Returns the IP as a string, or an error if the request fails or
-// returns an unexpected response.
-func (d *DDNSUpdater) getCurrentIP(ctx context.Context) (string, error) {
+// Returns the IP detected through client as a string, or an error if the
+// request fails or returns an unexpected response.
+func (d *DDNSUpdater) getCurrentIP(ctx context.Context, client *http.Client) (string, error) {
 	req, err := http.NewRequestWithContext(ctx, "GET", IPInfoURL, nil)
 	if err != nil {
 		return "", err
 	}
 
-	resp, err := d.httpClient.Do(req)
+	resp, err := client.Do(req)
 	if err != nil {
 		return "", err
 	}
@@ -330,131 +973,89 @@ func (d *DDNSUpdater) getCurrentIP(ctx context.Context) (string, error) {
 	return ip, nil
 }
 
-// updateDNSRecord updates a single DNS record via the Dreamhost API.
-// It first attempts to remove any existing record with the same name and type,
-// then adds a new record with the current IP address. This approach handles
-// cases where the record already exists with a different IP.
-func (d *DDNSUpdater) updateDNSRecord(ctx context.Context, domain DomainConfig, ip string) error {
-	// First, remove existing record if it exists
-	if err := d.removeDNSRecord(ctx, domain); err != nil {
-		d.logger.Warn("Failed to remove existing record (might not exist)",
-			"domain", domain.Name, "record", domain.Record, "error", err)
-	}
-
-	// Add new record
-	params := url.Values{}
-	params.Set("key", d.config.DreamhostAPIKey)
-	params.Set("cmd", "dns-add_record")
-	params.Set("record", domain.Record)
-	params.Set("type", domain.Type)
-	params.Set("value", ip)
-	params.Set("format", "json")
-
-	if domain.Record != "" {
-		params.Set("record", fmt.Sprintf("%s.%s", domain.Record, domain.Name))
-	} else {
-		params.Set("record", domain.Name)
-	}
-
-	apiURL := DreamhostAPIBase + "?" + params.Encode()
-
-	req, err := http.NewRequestWithContext(ctx, "GET", apiURL, nil)
-	if err != nil {
-		return err
-	}
-
-	resp, err := d.httpClient.Do(req)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("HTTP %d from Dreamhost API", resp.StatusCode)
-	}
-
-	var dhResp DreamhostResponse
-	if err := json.NewDecoder(resp.Body).Decode(&dhResp); err != nil {
-		return fmt.Errorf("decoding response: %w", err)
-	}
-
-	if dhResp.Result != "success" {
-		return fmt.Errorf("dreamhost API error: %s", dhResp.Data)
+// saveState persists the current state through the configured state
+// backend. The state includes the last known IP and timestamp to avoid
+// unnecessary API calls.
+func (d *DDNSUpdater) saveState() error {
+	if d.stateReadOnly {
+		d.logger.Debug("Not saving state held by another instance")
+		return nil
 	}
-
-	return nil
+	return stateStoreFor(d.config).Save(d.state)
 }
 
-// removeDNSRecord attempts to remove an existing DNS record via the Dreamhost API.
-// This is called before adding a new record to ensure we don't have duplicates.
-// Failures are not considered fatal since the record might not exist.
-func (d *DDNSUpdater) removeDNSRecord(ctx context.Context, domain DomainConfig) error {
-	params := url.Values{}
-	params.Set("key", d.config.DreamhostAPIKey)
-	params.Set("cmd", "dns-remove_record")
-	params.Set("record", domain.Record)
-	params.Set("type", domain.Type)
-	params.Set("format", "json")
-
-	if domain.Record != "" {
-		params.Set("record", fmt.Sprintf("%s.%s", domain.Record, domain.Name))
-	} else {
-		params.Set("record", domain.Name)
-	}
-
-	// Get current value first
-	currentIP, exists := d.state.Records[fmt.Sprintf("%s.%s", domain.Record, domain.Name)]
-	if exists {
-		params.Set("value", currentIP)
+// loadConfig reads and parses the configuration file in the format opts
+// names or its extension implies, decrypting it if SOPS-encrypted, applying
+// the selected profile, expanding
+// ${VAR} references from the environment, and filling in secrets from
+// *_file settings and override variables. A path that is an HTTP(S) URL is
+// fetched; see loadRemoteConfig.
+// Returns a Config struct or an error if the file cannot be read or parsed.
+func loadConfig(path string, opts configOptions) (*Config, error) {
+	if isRemoteConfig(path) {
+		return loadRemoteConfig(path, opts)
 	}
-
-	apiURL := DreamhostAPIBase + "?" + params.Encode()
-
-	req, err := http.NewRequestWithContext(ctx, "GET", apiURL, nil)
-	if err != nil {
-		return err
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		if env, envErr := envConfig(os.LookupEnv); envErr != nil {
+			return nil, envErr
+		} else if env != nil {
+			return loadEnvConfig(path, env, opts)
+		}
 	}
-
-	resp, err := d.httpClient.Do(req)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer resp.Body.Close()
-
-	// Don't treat this as fatal - record might not exist
-	return nil
+	opts.dir = filepath.Dir(path)
+	return decodeConfigFile(path, data, opts)
 }
 
-// saveState persists the current state to disk as JSON.
-// Creates the state directory if it doesn't exist. The state includes
-// the last known IP and timestamp to avoid unnecessary API calls.
-func (d *DDNSUpdater) saveState() error {
-	if err := os.MkdirAll(strings.TrimSuffix(d.config.StatePath, "/state.json"), 0755); err != nil {
-		return err
+// decodeConfigFile is loadConfig for the contents of the file at path.
+// Relative secret files are taken relative to opts.dir, or to the working
+// directory without one.
+func decodeConfigFile(path string, data []byte, opts configOptions) (*Config, error) {
+	var err error
+	if opts.Format, err = configFormat(path, opts.Format); err != nil {
+		return nil, err
 	}
-
-	data, err := json.MarshalIndent(d.state, "", "  ")
+	if doc, err := parseDocument(data, opts.Format); err == nil && isSOPSEncrypted(doc) {
+		if data, err = decryptSOPS(path, opts.Format, ageKeyFile(opts)); err != nil {
+			return nil, err
+		}
+	}
+	config, err := parseConfig(data, opts)
 	if err != nil {
-		return err
+		return nil, err
 	}
-
-	return os.WriteFile(d.config.StatePath, data, 0644)
+	config.configPath = path
+	config.ageKeyFile = ageKeyFile(opts)
+	if err := readSecretFiles(config, cmp.Or(opts.dir, ".")); err != nil {
+		return nil, err
+	}
+	applyEnvOverrides(config, os.LookupEnv)
+	config.fingerprint = fileFingerprint(config.watchedFiles())
+	return config, nil
 }
 
-// loadConfig reads and parses the YAML configuration file.
-// Returns a Config struct or an error if the file cannot be read or parsed.
-func loadConfig(path string) (*Config, error) {
-	data, err := os.ReadFile(path)
+// loadEnvConfig finishes loading a config built by envConfig because there
+// is no file at path. Fragments aren't read, and validation errors have no
+// lines to point to. The missing file is still watched, so creating it
+// switches to it with watch_config.
+func loadEnvConfig(path string, data []byte, opts configOptions) (*Config, error) {
+	opts.Format = "yaml"
+	config, err := parseConfig(data, opts)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("configuration from environment: %w", err)
 	}
-
-	var config Config
-	if err := yaml.Unmarshal(data, &config); err != nil {
+	config.doc = nil
+	config.configPath = path
+	config.ageKeyFile = ageKeyFile(opts)
+	if err := readSecretFiles(config, "."); err != nil {
 		return nil, err
 	}
-
-	return &config, nil
+	applyEnvOverrides(config, os.LookupEnv)
+	config.fingerprint = fileFingerprint(config.watchedFiles())
+	return config, nil
 }
 
 // loadState reads and parses the JSON state file.
@@ -479,12 +1080,7 @@ func loadState(path string) (*State, error) {
 			}
 
 			// Write default state to file
-			data, err := json.MarshalIndent(defaultState, "", "  ")
-			if err != nil {
-				return nil, fmt.Errorf("marshaling default state: %w", err)
-			}
-
-			if err := os.WriteFile(path, data, 0644); err != nil {
+			if err := (jsonStateStore{path: path}).Save(defaultState); err != nil {
 				return nil, fmt.Errorf("creating state file: %w", err)
 			}
 
@@ -494,10 +1090,15 @@ func loadState(path string) (*State, error) {
 		return nil, fmt.Errorf("reading state file: %w", err)
 	}
 
-	// Parse existing state file
+	// Parse existing state file, which is corrupt if it doesn't parse or
+	// match its checksum
+	data, err = verifyStateChecksum(data)
+	if err != nil {
+		return nil, &stateCorruptError{path: path, err: err}
+	}
 	var state State
 	if err := json.Unmarshal(data, &state); err != nil {
-		return nil, fmt.Errorf("parsing state file: %w", err)
+		return nil, &stateCorruptError{path: path, err: err}
 	}
 
 	// Ensure Records map is initialized
@@ -508,35 +1109,124 @@ func loadState(path string) (*State, error) {
 	return &state, nil
 }
 
+// daemonLoader returns the function building the daemon from the config
+// at configPath, when it starts and on every reload. The first load takes
+// the state lock before loading the state, and every updater it builds
+// holds that lock as its lock. A reload can't move the state or the PID
+// file, which the running daemon holds.
+func daemonLoader(configPath string, opts configOptions, logs *daemonLog) func() (*DDNSUpdater, error) {
+	var first *Config
+	var lock *stateLock
+	return func() (*DDNSUpdater, error) {
+		config, err := loadUpdaterConfig(configPath, opts)
+		if err != nil {
+			return nil, configError{err}
+		}
+		if first != nil && (config.StatePath != first.StatePath || config.StateBackend != first.StateBackend || config.PIDFile != first.PIDFile) {
+			return nil, configError{errors.New("state_path, state_backend and pid_file can't be changed by a reload; restart instead")}
+		}
+		if first == nil && locksState(config) {
+			if lock, err = lockState(config.StatePath); err != nil {
+				return nil, err
+			}
+		}
+
+		updater, err := newUpdaterFor(config, logs)
+		if err != nil {
+			if first == nil {
+				lock.Unlock()
+				lock = nil
+			}
+			return nil, err
+		}
+		first = config
+		updater.lock = lock
+		return updater, nil
+	}
+}
+
+// configError is an error in the config itself, which running again
+// repeats, as opposed to one that can pass, such as the state being locked
+// by a running daemon.
+type configError struct{ error }
+
+func (e configError) Unwrap() error { return e.error }
+
 // main is the entry point for the daemon. It initializes the updater,
-// sets up signal handling for graceful shutdown, and starts the main run loop.
-// Takes an optional config file path as the first command line argument.
+// sets up signal handling for graceful shutdown and reloads, and starts the
+// main run loop.
+// Takes optional --profile and --format flags and a config file path,
+// unless the first argument names a one-shot command (see commands).
 func main() {
-	configPath := DefaultConfigPath
 	if len(os.Args) > 1 {
-		configPath = os.Args[1]
+		if cmd, ok := commands[os.Args[1]]; ok {
+			os.Exit(cmd(os.Args[2:]))
+		}
+	}
+
+	fs := flag.NewFlagSet("dh-ddns-updater", flag.ExitOnError)
+	opts := configFlags(fs)
+	once := fs.Bool("once", false, "check and update once, then exit: 0 if that succeeded, 1 if it failed, 2 if the config is invalid")
+	showVersion := fs.Bool("version", false, "print the version and build information, then exit")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: dh-ddns-updater [flags] [config]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(os.Args[1:])
+	if *showVersion {
+		os.Exit(runVersionCommand(nil))
 	}
 
-	updater, err := NewDDNSUpdater(configPath)
+	logs := newDaemonLog(os.Stdout)
+	defer logs.Close()
+	load := daemonLoader(commandConfigPath(fs), *opts, logs)
+	updater, err := load()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to initialize updater: %v\n", err)
+		if *once && errors.As(err, new(configError)) {
+			// Told apart from a failed cycle, which the next run may not repeat
+			os.Exit(2)
+		}
 		os.Exit(1)
 	}
+	first, lock := updater.config, updater.lock
+	defer lock.Unlock()
+
+	if *once {
+		code := 0
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		if err := updater.RunOnce(ctx); err != nil {
+			attrs := []any{"error", err}
+			if p := (*panicError)(nil); errors.As(err, &p) {
+				attrs = append(attrs, "stack", string(p.stack))
+			}
+			updater.logger.Error("Update failed", attrs...)
+			code = 1
+		}
+		stop()
+		lock.Unlock()
+		logs.Close()
+		os.Exit(code)
+	}
 
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-
-	// Handle signals
+	// Handle signals: SIGHUP reloads the configuration, SIGUSR1 (where
+	// there is one) requests a check, the others stop
 	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-
-	go func() {
-		sig := <-sigChan
-		updater.logger.Info("Received signal", "signal", sig)
-		cancel()
-	}()
+	signal.Notify(sigChan, append([]os.Signal{syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP}, checkNowSignals...)...)
 
-	if err := updater.Run(ctx); err != nil && err != context.Canceled {
+	if first.PIDFile != "" {
+		if err := writePIDFile(first.PIDFile); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to write PID file: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	err = runWithReload(context.Background(), updater, load, sigChan)
+	if first.PIDFile != "" {
+		if err := removePIDFile(first.PIDFile); err != nil {
+			updater.logger.Warn("Failed to remove PID file", "path", first.PIDFile, "error", err)
+		}
+	}
+	if err != nil && err != context.Canceled {
 		updater.logger.Error("Updater failed", "error", err)
 		os.Exit(1)
 	}
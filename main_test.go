@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
 	"os"
@@ -30,6 +31,7 @@ domains:
   - name: "example.com"
     record: "home"
     type: "A"
+    notes: "port-forward 51820 on router"
 `,
 			wantError: false,
 		},
@@ -61,7 +63,7 @@ domains:
 			}
 			tmpfile.Close()
 
-			config, err := loadConfig(tmpfile.Name())
+			config, err := loadConfig(tmpfile.Name(), configOptions{})
 			if tt.wantError {
 				if err == nil {
 					t.Error("expected error but got none")
@@ -82,7 +84,11 @@ domains:
 			}
 
 			if len(config.Domains) != 1 {
-				t.Errorf("expected 1 domain, got %d", len(config.Domains))
+				t.Fatalf("expected 1 domain, got %d", len(config.Domains))
+			}
+
+			if config.Domains[0].Notes != "port-forward 51820 on router" {
+				t.Errorf("expected notes to be loaded, got %q", config.Domains[0].Notes)
 			}
 		})
 	}
@@ -108,7 +114,7 @@ func TestState(t *testing.T) {
 	originalState := &State{
 		LastIP:      "192.168.1.100",
 		LastUpdated: time.Now(),
-		Records:     map[string]string{"home.example.com": "192.168.1.100"},
+		Records:     map[string]string{"home.example.com/A": "192.168.1.100"},
 	}
 
 	// Create a mock updater to test saveState
@@ -241,7 +247,7 @@ func TestDreamhostAPI(t *testing.T) {
 				Data:   "record_added",
 			},
 			expectedError: false,
-			expectedCalls: 3, // list + remove + add
+			expectedCalls: 2, // list + add (nothing to remove)
 		},
 		{
 			name: "no update needed - record already correct",
@@ -302,13 +308,9 @@ func TestDreamhostAPI(t *testing.T) {
 			}))
 			defer server.Close()
 
-			// Create updater with mock API base URL
-			updater := &DDNSUpdater{
-				config: &Config{
-					DreamhostAPIKey: "test-key",
-				},
-				httpClient: &http.Client{Timeout: 5 * time.Second},
-			}
+			// Point a provider at the mock API
+			provider := NewDreamhostProvider("test-key", server.URL, false,
+				&http.Client{Timeout: 5 * time.Second}, slog.New(slog.NewJSONHandler(io.Discard, nil)))
 
 			domain := DomainConfig{
 				Name:   "example.com",
@@ -318,14 +320,18 @@ func TestDreamhostAPI(t *testing.T) {
 
 			ctx := context.Background()
 
-			// Test getCurrentDNSRecord first
-			currentIP, err := updater.getCurrentDNSRecordWithURL(ctx, domain, server.URL)
+			// Test GetRecords first
+			records, err := provider.GetRecords(ctx, domain)
 			if err != nil && tt.expectedError {
 				return // Expected error
 			}
 			if err != nil {
 				t.Fatalf("unexpected error getting current record: %v", err)
 			}
+			var currentIP string
+			if len(records) > 0 {
+				currentIP = records[0].Value
+			}
 
 			// Check if we need to update based on current record
 			newIP := "203.0.113.42"
@@ -338,7 +344,7 @@ func TestDreamhostAPI(t *testing.T) {
 			}
 
 			// Record needs update, test the update
-			err = updater.updateDNSRecordWithURL(ctx, domain, newIP, server.URL)
+			err = provider.UpdateRecord(ctx, domain, currentIP, newIP)
 
 			if tt.expectedError {
 				if err == nil {
@@ -426,12 +432,13 @@ func TestCheckAndUpdate(t *testing.T) {
 
 	configContent := fmt.Sprintf(`
 dreamhost_api_key: "test-key"
+dreamhost_api_base: "%s"
 state_path: "%s/state.json"
 domains:
   - name: "example.com"
     record: "home"
     type: "A"
-`, tempDir)
+`, apiServer.URL, tempDir)
 
 	if _, err := configFile.WriteString(configContent); err != nil {
 		t.Fatal(err)
@@ -461,17 +468,18 @@ domains:
 
 	// Test 2: DNS record lookup
 	domain := DomainConfig{Name: "example.com", Record: "home", Type: "A"}
-	recordIP, err := updater.getCurrentDNSRecordWithURL(ctx, domain, apiServer.URL)
+	provider := updater.providers[DefaultProvider]
+	records, err := provider.GetRecords(ctx, domain)
 	if err != nil {
 		t.Fatalf("failed to get DNS record: %v", err)
 	}
-	if recordIP != "" { // Should be empty since we return empty list
-		t.Errorf("expected empty record IP, got %s", recordIP)
+	if len(records) != 0 { // Should be empty since we return empty list
+		t.Errorf("expected no records, got %+v", records)
 	}
 
-	// Test 3: DNS record update (since IPs don't match)
-	if recordIP != currentIP {
-		err = updater.updateDNSRecordWithURL(ctx, domain, currentIP, apiServer.URL)
+	// Test 3: DNS record update (since the record doesn't exist)
+	if len(records) == 0 {
+		err = provider.UpdateRecord(ctx, domain, "", currentIP)
 		if err != nil {
 			t.Fatalf("failed to update DNS record: %v", err)
 		}
@@ -481,8 +489,8 @@ domains:
 	if listCallCount != 1 {
 		t.Errorf("expected 1 list call, got %d", listCallCount)
 	}
-	if removeCallCount != 1 {
-		t.Errorf("expected 1 remove call, got %d", removeCallCount)
+	if removeCallCount != 0 { // Nothing to remove for a new record
+		t.Errorf("expected no remove calls, got %d", removeCallCount)
 	}
 	if addCallCount != 1 {
 		t.Errorf("expected 1 add call, got %d", addCallCount)
@@ -530,10 +538,15 @@ domains:
 	defer apiServer2.Close()
 
 	// Test that no update is made when record is already correct
-	recordIP2, err := updater.getCurrentDNSRecordWithURL(ctx, domain, apiServer2.URL)
+	provider2 := NewDreamhostProvider("test-key", apiServer2.URL, false, updater.httpClient, updater.logger)
+	records2, err := provider2.GetRecords(ctx, domain)
 	if err != nil {
 		t.Fatalf("failed to get DNS record: %v", err)
 	}
+	var recordIP2 string
+	if len(records2) > 0 {
+		recordIP2 = records2[0].Value
+	}
 
 	if recordIP2 == currentIP {
 		// Should not call update since they match
@@ -589,7 +602,7 @@ domains:
 	testIP := "203.0.113.42"
 	updater.state.LastIP = testIP
 	updater.state.Records = map[string]string{
-		"home.example.com": testIP,
+		"home.example.com/A": testIP,
 	}
 
 	// The key test: even with same IP, the new logic should still check DNS records
@@ -659,6 +672,14 @@ domains:
 	if updater.config.LogLevel != "info" {
 		t.Errorf("expected default log level 'info', got %s", updater.config.LogLevel)
 	}
+
+	if updater.config.DreamhostAPIBase != DefaultDreamhostAPIBase {
+		t.Errorf("expected default API base %s, got %s", DefaultDreamhostAPIBase, updater.config.DreamhostAPIBase)
+	}
+
+	if updater.config.CycleTimeout != 5*time.Minute || updater.detection.Timeout != 5*time.Minute {
+		t.Errorf("expected default cycle timeout 5m, got %v", updater.config.CycleTimeout)
+	}
 }
 
 func TestDefaultStatePath(t *testing.T) {
@@ -682,7 +703,7 @@ domains:
 	tmpfile.Close()
 
 	// Load config directly to test default setting
-	config, err := loadConfig(tmpfile.Name())
+	config, err := loadConfig(tmpfile.Name(), configOptions{})
 	if err != nil {
 		t.Fatalf("failed to load config: %v", err)
 	}
@@ -732,109 +753,62 @@ func TestDomainRecordFormatting(t *testing.T) {
 	}
 }
 
-// Helper method for testing - in real implementation you'd use dependency injection
-// or make URLs configurable to avoid needing separate test methods
-func (d *DDNSUpdater) getCurrentIPFromURL(ctx context.Context, url string) (string, error) {
-	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
-	if err != nil {
-		return "", err
-	}
+// TestAddRecordParams tests that records are tagged as managed and notes are synced only when enabled
+func TestAddRecordParams(t *testing.T) {
+	domain := DomainConfig{Name: "example.com", Record: "vpn", Type: "A", Notes: "port-forward 51820 on router"}
 
-	resp, err := d.httpClient.Do(req)
-	if err != nil {
-		return "", err
+	tests := []struct {
+		name            string
+		syncNotes       bool
+		expectedComment string
+	}{
+		{name: "sync disabled", syncNotes: false, expectedComment: "managed by dh-ddns-updater"},
+		{name: "sync enabled", syncNotes: true, expectedComment: "managed by dh-ddns-updater: port-forward 51820 on router"},
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("HTTP %d", resp.StatusCode)
-	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := NewDreamhostProvider("test-key", "", tt.syncNotes, nil, nil)
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", err
-	}
+			params := provider.addRecordParams(domain, "203.0.113.42")
 
-	ip := strings.TrimSpace(string(body))
-	if ip == "" {
-		return "", fmt.Errorf("empty response")
+			if params.Get("record") != "vpn.example.com" {
+				t.Errorf("expected record vpn.example.com, got %q", params.Get("record"))
+			}
+			if params.Get("value") != "203.0.113.42" {
+				t.Errorf("expected value 203.0.113.42, got %q", params.Get("value"))
+			}
+			if params.Get("comment") != tt.expectedComment {
+				t.Errorf("expected comment %q, got %q", tt.expectedComment, params.Get("comment"))
+			}
+		})
 	}
-
-	return ip, nil
 }
 
-// Helper method for testing DNS API with custom URL
-func (d *DDNSUpdater) updateDNSRecordWithURL(ctx context.Context, domain DomainConfig, ip, baseURL string) error {
-	// Remove existing record first
-	if err := d.removeDNSRecordWithURL(ctx, domain, baseURL); err != nil {
-		// Don't fail on remove errors
-	}
-
-	// Add new record
-	params := map[string]string{
-		"key":    d.config.DreamhostAPIKey,
-		"cmd":    "dns-add_record",
-		"type":   domain.Type,
-		"value":  ip,
-		"format": "json",
-	}
-
-	if domain.Record != "" {
-		params["record"] = fmt.Sprintf("%s.%s", domain.Record, domain.Name)
-	} else {
-		params["record"] = domain.Name
-	}
-
-	// Build URL
-	var parts []string
-	for k, v := range params {
-		parts = append(parts, fmt.Sprintf("%s=%s", k, v))
-	}
-	apiURL := baseURL + "/?" + strings.Join(parts, "&")
-
-	req, err := http.NewRequestWithContext(ctx, "GET", apiURL, nil)
-	if err != nil {
-		return err
-	}
-
-	resp, err := d.httpClient.Do(req)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("HTTP %d", resp.StatusCode)
-	}
-
-	var dhResp DreamhostResponse
-	if err := json.NewDecoder(resp.Body).Decode(&dhResp); err != nil {
-		return fmt.Errorf("decoding response: %w", err)
+// TestDNSRecordManaged tests detection of the ownership marker in record comments
+func TestDNSRecordManaged(t *testing.T) {
+	tests := []struct {
+		comment  string
+		expected bool
+	}{
+		{comment: "managed by dh-ddns-updater", expected: true},
+		{comment: "managed by dh-ddns-updater: port-forward 51820 on router", expected: true},
+		{comment: "", expected: false},
+		{comment: "mail server - do not touch", expected: false},
 	}
 
-	if dhResp.Result != "success" {
-		return fmt.Errorf("API error: %s", dhResp.Data)
+	for _, tt := range tests {
+		record := DNSRecord{Record: "home.example.com", Type: "A", Value: "203.0.113.42", Comment: tt.comment}
+		if got := record.Managed(); got != tt.expected {
+			t.Errorf("Managed() for comment %q = %v, want %v", tt.comment, got, tt.expected)
+		}
 	}
-
-	return nil
 }
 
-// Helper method for testing DNS record lookup with custom URL
-func (d *DDNSUpdater) getCurrentDNSRecordWithURL(ctx context.Context, domain DomainConfig, baseURL string) (string, error) {
-	params := map[string]string{
-		"key":    d.config.DreamhostAPIKey,
-		"cmd":    "dns-list_records",
-		"format": "json",
-	}
-
-	// Build URL
-	var parts []string
-	for k, v := range params {
-		parts = append(parts, fmt.Sprintf("%s=%s", k, v))
-	}
-	apiURL := baseURL + "/?" + strings.Join(parts, "&")
-
-	req, err := http.NewRequestWithContext(ctx, "GET", apiURL, nil)
+// Helper method for testing - in real implementation you'd use dependency injection
+// or make URLs configurable to avoid needing separate test methods
+func (d *DDNSUpdater) getCurrentIPFromURL(ctx context.Context, url string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return "", err
 	}
@@ -849,68 +823,51 @@ func (d *DDNSUpdater) getCurrentDNSRecordWithURL(ctx context.Context, domain Dom
 		return "", fmt.Errorf("HTTP %d", resp.StatusCode)
 	}
 
-	var dhResp struct {
-		Result string `json:"result"`
-		Data   []struct {
-			Record string `json:"record"`
-			Type   string `json:"type"`
-			Value  string `json:"value"`
-		} `json:"data"`
-	}
-
-	if err := json.NewDecoder(resp.Body).Decode(&dhResp); err != nil {
-		return "", fmt.Errorf("decoding response: %w", err)
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
 	}
 
-	if dhResp.Result != "success" {
-		return "", fmt.Errorf("API error")
+	ip := strings.TrimSpace(string(body))
+	if ip == "" {
+		return "", fmt.Errorf("empty response")
 	}
 
-	// Find the matching record
-	targetRecord := domain.Name
-	if domain.Record != "" {
-		targetRecord = fmt.Sprintf("%s.%s", domain.Record, domain.Name)
-	}
+	return ip, nil
+}
 
-	for _, record := range dhResp.Data {
-		if record.Record == targetRecord && record.Type == domain.Type {
-			return record.Value, nil
+// TestRunOnce tests that a single cycle updates the records and fails if
+// the IP can't be detected, without publishing
+func TestRunOnce(t *testing.T) {
+	fake := newFakeDreamhost(DNSRecord{Record: "home.example.com", Type: "A", Value: "198.51.100.1", Comment: ManagedComment})
+	updater := newPlanTestUpdater(t, fake, "", DomainConfig{Name: "example.com", Record: "home", Type: "A"})
+	detected := "203.0.113.42"
+	updater.detection = NewStage("detection", time.Minute, time.Minute, func(ctx context.Context) error {
+		if detected == "" {
+			return fmt.Errorf("getting current IP: timeout")
 		}
-	}
-
-	// Record not found
-	return "", nil
-}
-func (d *DDNSUpdater) removeDNSRecordWithURL(ctx context.Context, domain DomainConfig, baseURL string) error {
-	params := map[string]string{
-		"key":    d.config.DreamhostAPIKey,
-		"cmd":    "dns-remove_record",
-		"type":   domain.Type,
-		"format": "json",
-	}
+		updater.setPublicIP(ctx, detected, ipSourceName)
+		return nil
+	}, nil)
+	h := updater.providers[DefaultProvider]
+	publications := 0
+	h.stage = NewStage("publication:dreamhost", time.Hour, time.Minute, func(ctx context.Context) error {
+		publications++
+		return updater.publish(ctx, h)
+	}, nil)
 
-	if domain.Record != "" {
-		params["record"] = fmt.Sprintf("%s.%s", domain.Record, domain.Name)
-	} else {
-		params["record"] = domain.Name
+	if err := updater.RunOnce(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
 	}
-
-	var parts []string
-	for k, v := range params {
-		parts = append(parts, fmt.Sprintf("%s=%s", k, v))
+	if got := fake.value("home.example.com", "A"); got != "203.0.113.42" {
+		t.Errorf("expected the record to be updated, got %s", got)
 	}
-	apiURL := baseURL + "/?" + strings.Join(parts, "&")
 
-	req, err := http.NewRequestWithContext(ctx, "GET", apiURL, nil)
-	if err != nil {
-		return err
+	detected = ""
+	if err := updater.RunOnce(context.Background()); err == nil || !strings.Contains(err.Error(), "timeout") {
+		t.Errorf("expected the detection error, got %v", err)
 	}
-
-	resp, err := d.httpClient.Do(req)
-	if err != nil {
-		return err
+	if publications != 1 {
+		t.Errorf("expected no publication after detection failed, got %d in all", publications)
 	}
-	defer resp.Body.Close()
-
-	return nil
 }
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net/netip"
+	"os"
+)
+
+// mmdbMetadataMarker precedes the metadata at the end of an MMDB file.
+var mmdbMetadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+// mmdb is a MaxMind DB file, the format of the GeoLite2 and ipinfo
+// databases, read into memory. Only what looking an address up needs is
+// supported; see https://maxmind.github.io/MaxMind-DB/.
+type mmdb struct {
+	path       string
+	tree       []byte // Search tree
+	data       []byte // Data section
+	nodeCount  uint
+	recordSize uint // Bits per record: 24, 28 or 32
+	ipVersion  uint // 4 or 6
+	ipv4Start  uint // Node of ::/96 in an IPv6 tree, where IPv4 addresses start
+}
+
+// openMMDB reads the MMDB file at path.
+func openMMDB(path string) (*mmdb, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	db, err := parseMMDB(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	db.path = path
+	return db, nil
+}
+
+// parseMMDB parses an MMDB file's contents.
+func parseMMDB(b []byte) (*mmdb, error) {
+	i := bytes.LastIndex(b, mmdbMetadataMarker)
+	if i < 0 {
+		return nil, errors.New("not a MaxMind DB file")
+	}
+	metadata := b[i+len(mmdbMetadataMarker):]
+	value, _, err := (&mmdbDecoder{section: metadata}).decode(0, 0)
+	if err != nil {
+		return nil, fmt.Errorf("reading metadata: %w", err)
+	}
+	m, ok := value.(map[string]any)
+	if !ok {
+		return nil, errors.New("metadata isn't a map")
+	}
+	uintField := func(key string) uint {
+		v, _ := m[key].(uint64)
+		return uint(v)
+	}
+	db := &mmdb{
+		nodeCount:  uintField("node_count"),
+		recordSize: uintField("record_size"),
+		ipVersion:  uintField("ip_version"),
+	}
+	if db.recordSize != 24 && db.recordSize != 28 && db.recordSize != 32 {
+		return nil, fmt.Errorf("unsupported record size %d", db.recordSize)
+	}
+	if db.ipVersion != 4 && db.ipVersion != 6 {
+		return nil, fmt.Errorf("unsupported IP version %d", db.ipVersion)
+	}
+	treeSize := db.nodeCount * db.recordSize / 4
+	if treeSize+16 > uint(i) {
+		return nil, errors.New("search tree exceeds the file")
+	}
+	db.tree = b[:treeSize]
+	db.data = b[treeSize+16 : i] // After the 16 zero bytes ending the tree
+	if db.ipVersion == 6 {
+		node := uint(0)
+		for range 96 {
+			if node >= db.nodeCount {
+				break
+			}
+			node = db.record(node, 0)
+		}
+		db.ipv4Start = node
+	}
+	return db, nil
+}
+
+// record returns the bit'th record (0 for left, 1 for right) of node.
+func (db *mmdb) record(node, bit uint) uint {
+	n := db.tree[node*db.recordSize/4:]
+	switch db.recordSize {
+	case 24:
+		n = n[bit*3:]
+		return uint(n[0])<<16 | uint(n[1])<<8 | uint(n[2])
+	case 28:
+		if bit == 0 {
+			return uint(n[3]&0xf0)<<20 | uint(n[0])<<16 | uint(n[1])<<8 | uint(n[2])
+		}
+		return uint(n[3]&0x0f)<<24 | uint(n[4])<<16 | uint(n[5])<<8 | uint(n[6])
+	default:
+		return uint(binary.BigEndian.Uint32(n[bit*4:]))
+	}
+}
+
+// lookup returns the data of the network holding addr, or nil if the
+// database has none.
+func (db *mmdb) lookup(addr netip.Addr) (any, error) {
+	addr = addr.Unmap()
+	node := uint(0)
+	if addr.Is4() && db.ipVersion == 6 {
+		node = db.ipv4Start
+	} else if addr.Is6() && db.ipVersion == 4 {
+		return nil, errors.New("IPv6 address in an IPv4 database")
+	}
+	bits := addr.AsSlice()
+	for i := 0; i < len(bits)*8 && node < db.nodeCount; i++ {
+		node = db.record(node, uint(bits[i/8]>>(7-i%8)&1))
+	}
+	switch {
+	case node == db.nodeCount:
+		return nil, nil
+	case node < db.nodeCount:
+		return nil, errors.New("search tree is deeper than the address")
+	}
+	value, _, err := (&mmdbDecoder{section: db.data}).decode(node-db.nodeCount-16, 0)
+	return value, err
+}
+
+// mmdbDecoder decodes values of an MMDB data section, or of its metadata.
+type mmdbDecoder struct {
+	section []byte
+}
+
+// mmdbMaxDepth bounds the nesting of maps, arrays and pointers, so that a
+// corrupt file can't recurse without end.
+const mmdbMaxDepth = 32
+
+// decode returns the value at offset and the offset after it. Maps decode
+// to map[string]any, arrays to []any, unsigned integers to uint64, signed
+// ones to int64 and floats to float64.
+func (d *mmdbDecoder) decode(offset uint, depth int) (any, uint, error) {
+	if depth > mmdbMaxDepth {
+		return nil, 0, errors.New("data nested too deeply")
+	}
+	b, err := d.bytes(offset, 1)
+	if err != nil {
+		return nil, 0, err
+	}
+	ctrl := b[0]
+	offset++
+	kind := uint(ctrl >> 5)
+	if kind == 1 {
+		return d.pointer(ctrl, offset, depth)
+	}
+	if kind == 0 {
+		if b, err = d.bytes(offset, 1); err != nil {
+			return nil, 0, err
+		}
+		kind = 7 + uint(b[0])
+		offset++
+	}
+	size := uint(ctrl & 0x1f)
+	if size >= 29 {
+		n := size - 28
+		if b, err = d.bytes(offset, n); err != nil {
+			return nil, 0, err
+		}
+		offset += n
+		switch n {
+		case 1:
+			size = 29 + uint(b[0])
+		case 2:
+			size = 285 + uint(uintBytes(b))
+		default:
+			size = 65821 + uint(uintBytes(b))
+		}
+	}
+
+	switch kind {
+	case 2: // UTF-8 string
+		b, err := d.bytes(offset, size)
+		return string(b), offset + size, err
+	case 3: // double
+		b, err := d.bytes(offset, 8)
+		if err != nil || size != 8 {
+			return nil, 0, malformed(err, "double of size %d", size)
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), offset + 8, nil
+	case 4: // bytes
+		b, err := d.bytes(offset, size)
+		return bytes.Clone(b), offset + size, err
+	case 5, 6, 9, 10: // uint16, uint32, uint64, uint128
+		if size > 8 {
+			return nil, 0, fmt.Errorf("unsigned integer of %d bytes is too large", size)
+		}
+		b, err := d.bytes(offset, size)
+		return uintBytes(b), offset + size, err
+	case 7: // map
+		m := make(map[string]any, min(size, 64)) // A corrupt size needn't allocate
+		for range size {
+			var key, value any
+			if key, offset, err = d.decode(offset, depth+1); err != nil {
+				return nil, 0, err
+			}
+			k, ok := key.(string)
+			if !ok {
+				return nil, 0, fmt.Errorf("map key %v isn't a string", key)
+			}
+			if value, offset, err = d.decode(offset, depth+1); err != nil {
+				return nil, 0, err
+			}
+			m[k] = value
+		}
+		return m, offset, nil
+	case 8: // int32
+		b, err := d.bytes(offset, size)
+		if err != nil || size > 4 {
+			return nil, 0, malformed(err, "int32 of size %d", size)
+		}
+		return int64(int32(uint32(uintBytes(b))<<(32-8*size)) >> (32 - 8*size)), offset + size, nil
+	case 11: // array
+		a := make([]any, 0, min(size, 64))
+		for range size {
+			var value any
+			if value, offset, err = d.decode(offset, depth+1); err != nil {
+				return nil, 0, err
+			}
+			a = append(a, value)
+		}
+		return a, offset, nil
+	case 14: // boolean, whose size is its value
+		return size != 0, offset, nil
+	case 15: // float
+		b, err := d.bytes(offset, 4)
+		if err != nil || size != 4 {
+			return nil, 0, malformed(err, "float of size %d", size)
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), offset + 4, nil
+	}
+	return nil, 0, fmt.Errorf("unsupported data type %d", kind)
+}
+
+// pointer decodes the value a pointer with control byte ctrl points to.
+// The offset returned is the one after the pointer, not the value.
+func (d *mmdbDecoder) pointer(ctrl byte, offset uint, depth int) (any, uint, error) {
+	n := uint(ctrl>>3&3) + 1
+	b, err := d.bytes(offset, n)
+	if err != nil {
+		return nil, 0, err
+	}
+	v := uint64(ctrl & 7)
+	var target uint64
+	switch n {
+	case 1:
+		target = v<<8 | uintBytes(b)
+	case 2:
+		target = (v<<16 | uintBytes(b)) + 2048
+	case 3:
+		target = (v<<24 | uintBytes(b)) + 526336
+	default:
+		target = uintBytes(b)
+	}
+	value, _, err := d.decode(uint(target), depth+1)
+	return value, offset + n, err
+}
+
+// bytes returns the n bytes of the section at offset.
+func (d *mmdbDecoder) bytes(offset, n uint) ([]byte, error) {
+	if offset+n > uint(len(d.section)) || offset+n < offset {
+		return nil, errors.New("data exceeds its section")
+	}
+	return d.section[offset : offset+n], nil
+}
+
+// uintBytes returns the big-endian unsigned integer b holds.
+func uintBytes(b []byte) uint64 {
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v
+}
+
+// malformed returns err, or if it is nil an error saying the data is a
+// malformed value as format describes.
+func malformed(err error, format string, args ...any) error {
+	if err != nil {
+		return err
+	}
+	return fmt.Errorf("malformed "+format, args...)
+}
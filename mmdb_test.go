@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"maps"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// writeMMDB returns an MMDB file mapping each prefix to its data, with
+// ipVersion 4 or 6 and recordSize bits per record. IPv4 prefixes go under
+// ::/96 of an IPv6 tree.
+func writeMMDB(t *testing.T, ipVersion, recordSize int, networks map[netip.Prefix]map[string]any) []byte {
+	t.Helper()
+	const empty = -1
+	nodes := [][2]int{{empty, empty}}
+	leaves := map[[2]int]int{} // Record of a node to the data offset it points to
+	var data []byte
+
+	for _, prefix := range slices.SortedFunc(maps.Keys(networks), func(a, b netip.Prefix) int { return a.Addr().Compare(b.Addr()) }) {
+		bits := prefix.Addr().AsSlice()
+		n := prefix.Bits()
+		if ipVersion == 6 && prefix.Addr().Is4() {
+			bits = append(make([]byte, 12), bits...)
+			n += 96
+		}
+		node := 0
+		for i := range n {
+			bit := int(bits[i/8] >> (7 - i%8) & 1)
+			if i == n-1 {
+				leaves[[2]int{node, bit}] = len(data)
+				data = append(data, encodeMMDB(networks[prefix])...)
+				break
+			}
+			if nodes[node][bit] == empty {
+				nodes = append(nodes, [2]int{empty, empty})
+				nodes[node][bit] = len(nodes) - 1
+			}
+			node = nodes[node][bit]
+		}
+	}
+
+	var b bytes.Buffer
+	count := len(nodes)
+	for i, node := range nodes {
+		var records [2]uint32
+		for bit, next := range node {
+			switch offset, leaf := leaves[[2]int{i, bit}]; {
+			case leaf:
+				records[bit] = uint32(count + 16 + offset)
+			case next == empty:
+				records[bit] = uint32(count)
+			default:
+				records[bit] = uint32(next)
+			}
+		}
+		switch recordSize {
+		case 24:
+			b.Write([]byte{byte(records[0] >> 16), byte(records[0] >> 8), byte(records[0])})
+			b.Write([]byte{byte(records[1] >> 16), byte(records[1] >> 8), byte(records[1])})
+		case 28:
+			b.Write([]byte{byte(records[0] >> 16), byte(records[0] >> 8), byte(records[0])})
+			b.WriteByte(byte(records[0]>>24)<<4 | byte(records[1]>>24))
+			b.Write([]byte{byte(records[1] >> 16), byte(records[1] >> 8), byte(records[1])})
+		case 32:
+			binary.Write(&b, binary.BigEndian, records)
+		}
+	}
+	b.Write(make([]byte, 16))
+	b.Write(data)
+	b.Write(mmdbMetadataMarker)
+	b.Write(encodeMMDB(map[string]any{
+		"node_count":    uint32(count),
+		"record_size":   uint16(recordSize),
+		"ip_version":    uint16(ipVersion),
+		"database_type": "Test",
+	}))
+	return b.Bytes()
+}
+
+// encodeMMDB encodes strings, uint16s, uint32s and maps of them, each of
+// fewer than 285 bytes or entries, in the MMDB data format.
+func encodeMMDB(value any) []byte {
+	control := func(kind, size int) []byte {
+		if size >= 29 {
+			return []byte{byte(kind<<5 | 29), byte(size - 29)}
+		}
+		return []byte{byte(kind<<5 | size)}
+	}
+	switch v := value.(type) {
+	case string:
+		return append(control(2, len(v)), v...)
+	case uint16:
+		return append(control(5, 2), byte(v>>8), byte(v))
+	case uint32:
+		return append(control(6, 4), byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+	case map[string]any:
+		b := control(7, len(v))
+		for _, key := range slices.Sorted(maps.Keys(v)) {
+			b = append(b, encodeMMDB(key)...)
+			b = append(b, encodeMMDB(v[key])...)
+		}
+		return b
+	}
+	panic("unsupported type for the test encoder")
+}
+
+// TestMMDBLookup tests lookups in IPv4 and IPv6 trees of each record size,
+// including IPv4 addresses in an IPv6 tree
+func TestMMDBLookup(t *testing.T) {
+	networks := map[netip.Prefix]map[string]any{
+		netip.MustParsePrefix("203.0.113.0/24"):  {"autonomous_system_number": uint32(64500)},
+		netip.MustParsePrefix("198.51.100.0/25"): {"autonomous_system_number": uint32(64501)},
+	}
+	tests := []struct {
+		ipVersion, recordSize int
+	}{
+		{4, 24}, {4, 28}, {4, 32}, {6, 24}, {6, 28}, {6, 32},
+	}
+	for _, tt := range tests {
+		v6 := map[netip.Prefix]map[string]any{netip.MustParsePrefix("2001:db8::/32"): {"country": map[string]any{"iso_code": "NL"}}}
+		all := networks
+		if tt.ipVersion == 6 {
+			all = maps.Clone(networks)
+			maps.Copy(all, v6)
+		}
+		db, err := parseMMDB(writeMMDB(t, tt.ipVersion, tt.recordSize, all))
+		if err != nil {
+			t.Fatalf("IPv%d/%d: unexpected error: %v", tt.ipVersion, tt.recordSize, err)
+		}
+
+		lookups := map[string]any{
+			"203.0.113.42":   map[string]any{"autonomous_system_number": uint64(64500)},
+			"198.51.100.1":   map[string]any{"autonomous_system_number": uint64(64501)},
+			"198.51.100.200": nil, // Outside the /25
+			"192.0.2.1":      nil,
+		}
+		if tt.ipVersion == 6 {
+			lookups["2001:db8::1"] = map[string]any{"country": map[string]any{"iso_code": "NL"}}
+			lookups["::ffff:203.0.113.42"] = map[string]any{"autonomous_system_number": uint64(64500)}
+		}
+		for ip, want := range lookups {
+			got, err := db.lookup(netip.MustParseAddr(ip))
+			if err != nil {
+				t.Errorf("IPv%d/%d: %s: unexpected error: %v", tt.ipVersion, tt.recordSize, ip, err)
+			}
+			if want == nil && got != nil || want != nil && !reflect.DeepEqual(got, want) {
+				t.Errorf("IPv%d/%d: %s: expected %v, got %v", tt.ipVersion, tt.recordSize, ip, want, got)
+			}
+		}
+	}
+
+	db, _ := parseMMDB(writeMMDB(t, 4, 24, networks))
+	if _, err := db.lookup(netip.MustParseAddr("2001:db8::1")); err == nil {
+		t.Error("expected an error looking up IPv6 in an IPv4 database")
+	}
+}
+
+// TestMMDBDecode tests the data types lookups don't need to write, and
+// pointers
+func TestMMDBDecode(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want any
+	}{
+		{"pointer", []byte{0x20, 0x02, 0x43, 'a', 'b', 'c'}, "abc"},
+		{"extended size", append([]byte{0x5d, 0x01}, bytes.Repeat([]byte{'x'}, 30)...), string(bytes.Repeat([]byte{'x'}, 30))},
+		{"int32", []byte{0x02, 0x01, 0xff, 0xfe}, int64(-2)},
+		{"true", []byte{0x01, 0x07}, true},
+		{"false", []byte{0x00, 0x07}, false},
+		{"array", []byte{0x02, 0x04, 0xa1, 0x07, 0xa1, 0x08}, []any{uint64(7), uint64(8)}},
+		{"double", []byte{0x68, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0}, 1.5},
+	}
+	for _, tt := range tests {
+		got, _, err := (&mmdbDecoder{section: tt.data}).decode(0, 0)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+		} else if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: expected %#v, got %#v", tt.name, tt.want, got)
+		}
+	}
+
+	// A pointer to itself
+	if _, _, err := (&mmdbDecoder{section: []byte{0x20, 0x00}}).decode(0, 0); err == nil {
+		t.Error("expected an error for a pointer loop")
+	}
+	if _, _, err := (&mmdbDecoder{section: []byte{0x44, 'a'}}).decode(0, 0); err == nil {
+		t.Error("expected an error for a string exceeding the data")
+	}
+}
+
+// TestOpenMMDB tests that a file that isn't an MMDB is rejected with its
+// name
+func TestOpenMMDB(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "GeoLite2-ASN.mmdb")
+	if err := os.WriteFile(path, []byte("<html>Not Found</html>"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := openMMDB(path); err == nil || !strings.Contains(err.Error(), path) {
+		t.Errorf("expected an error naming %s, got %v", path, err)
+	}
+}
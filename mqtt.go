@@ -0,0 +1,309 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// MQTTNotificationConfig is an MQTT broker receiving events, for home
+// automation systems to react to, with the current IP kept retained.
+type MQTTNotificationConfig struct {
+	Broker       string `yaml:"broker"`        // host:port of the broker
+	TLS          bool   `yaml:"tls"`           // Connect over TLS
+	ClientID     string `yaml:"client_id"`     // Client identifier (default dh-ddns-updater-<hostname>)
+	Username     string `yaml:"username"`      // Username, if the broker requires one
+	Password     string `yaml:"password"`      // Password, if the broker requires one
+	PasswordFile string `yaml:"password_file"` // File holding password instead
+	// Topic prefixes the topics published to: each event is published as
+	// JSON to <topic>/events/<event>, and the new IP of an ip_change,
+	// retained, to <topic>/ip (default "dh-ddns-updater").
+	Topic string `yaml:"topic"`
+	QoS   int    `yaml:"qos"` // Quality of service, 0, 1 or 2 (default 0)
+	// HomeAssistant announces sensors of the public IP, the last update
+	// and whether the updater has a problem through Home Assistant's MQTT
+	// discovery, and publishes their state to <topic>/status every
+	// check_interval.
+	HomeAssistant   bool   `yaml:"home_assistant"`
+	DiscoveryPrefix string `yaml:"discovery_prefix"` // Home Assistant's discovery prefix (default "homeassistant")
+
+	NotificationRoute `yaml:",inline"` // Which events are sent, and when
+}
+
+// DefaultMQTTTopic is the topic prefix used unless topic says otherwise.
+const DefaultMQTTTopic = "dh-ddns-updater"
+
+// validateMQTTNotification checks an MQTT notification.
+func validateMQTTNotification(m MQTTNotificationConfig) error {
+	if _, _, err := net.SplitHostPort(m.Broker); err != nil {
+		return fmt.Errorf("broker %q is not host:port", m.Broker)
+	}
+	if m.Topic == "" || strings.ContainsAny(m.Topic, "+#") || strings.HasSuffix(m.Topic, "/") {
+		return fmt.Errorf("topic %q is not a topic prefix", m.Topic)
+	}
+	if m.QoS < 0 || m.QoS > 2 {
+		return fmt.Errorf("qos %d is not 0, 1 or 2", m.QoS)
+	}
+	if m.Password != "" && m.Username == "" {
+		return errors.New("password requires username")
+	}
+	if m.DiscoveryPrefix != "" && !m.HomeAssistant {
+		return errors.New("discovery_prefix requires home_assistant")
+	}
+	if strings.ContainsAny(m.DiscoveryPrefix, "+#") || strings.HasSuffix(m.DiscoveryPrefix, "/") {
+		return fmt.Errorf("discovery_prefix %q is not a topic prefix", m.DiscoveryPrefix)
+	}
+	return m.NotificationRoute.validate()
+}
+
+// mqttNotifier publishes events to an MQTT broker, connecting for each
+// event. mu keeps two events from being published at once, as the broker
+// would drop one connection for the other reusing its client ID.
+type mqttNotifier struct {
+	config   MQTTNotificationConfig
+	clientID string
+	mu       sync.Mutex
+}
+
+func newMQTTNotifier(config MQTTNotificationConfig) *mqttNotifier {
+	host, _ := os.Hostname()
+	return &mqttNotifier{config: config, clientID: cmp.Or(config.ClientID, "dh-ddns-updater-"+host)}
+}
+
+func (m *mqttNotifier) notify(ctx context.Context, _ *http.Client, e Event) error {
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	messages := []mqttMessage{{topic: m.config.Topic + "/events/" + e.Event, payload: payload}}
+	if e.Event == EventIPChange {
+		messages = append(messages, mqttMessage{topic: m.config.Topic + "/ip", payload: []byte(e.NewIP), retain: true})
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.publish(ctx, messages...)
+}
+
+// publish connects to the broker and publishes messages at the configured
+// QoS.
+func (m *mqttNotifier) publish(ctx context.Context, messages ...mqttMessage) error {
+	conn, err := dialMQTT(ctx, m.config, m.clientID)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	for _, msg := range messages {
+		if err := conn.publish(msg, m.config.QoS); err != nil {
+			return err
+		}
+	}
+	return conn.disconnect()
+}
+
+// mqttMessage is a message to publish.
+type mqttMessage struct {
+	topic   string
+	payload []byte
+	retain  bool
+}
+
+// MQTT control packet types, shifted into the first byte of a packet.
+const (
+	mqttConnect    = 1 << 4
+	mqttConnAck    = 2 << 4
+	mqttPublish    = 3 << 4
+	mqttPubAck     = 4 << 4
+	mqttPubRec     = 5 << 4
+	mqttPubRel     = 6 << 4
+	mqttPubComp    = 7 << 4
+	mqttDisconnect = 14 << 4
+)
+
+// mqttConn is a connection speaking MQTT 3.1.1 as a client. Only
+// publishing is implemented; there is no MQTT client in the standard
+// library.
+type mqttConn struct {
+	conn     net.Conn
+	r        *bufio.Reader
+	packetID uint16
+}
+
+// mqttConnectErrors explains the CONNACK return codes refusing a
+// connection.
+var mqttConnectErrors = map[byte]string{
+	1: "unacceptable protocol version",
+	2: "client identifier rejected",
+	3: "server unavailable",
+	4: "bad username or password",
+	5: "not authorized",
+}
+
+// dialMQTT connects to the broker config names as clientID, with a clean
+// session. The connection's deadline is ctx's.
+func dialMQTT(ctx context.Context, config MQTTNotificationConfig, clientID string) (*mqttConn, error) {
+	var conn net.Conn
+	var err error
+	if config.TLS {
+		host, _, _ := net.SplitHostPort(config.Broker)
+		dialer := &tls.Dialer{Config: &tls.Config{ServerName: host}}
+		conn, err = dialer.DialContext(ctx, "tcp", config.Broker)
+	} else {
+		var dialer net.Dialer
+		conn, err = dialer.DialContext(ctx, "tcp", config.Broker)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("connecting to mqtt broker: %w", err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	c := &mqttConn{conn: conn, r: bufio.NewReader(conn)}
+
+	flags := byte(0x02) // Clean session
+	payload := mqttString(clientID)
+	if config.Username != "" {
+		flags |= 0x80
+		payload = append(payload, mqttString(config.Username)...)
+	}
+	if config.Password != "" {
+		flags |= 0x40
+		payload = append(payload, mqttString(config.Password)...)
+	}
+	body := append(mqttString("MQTT"), 4, flags, 0, 60) // Protocol level 4 (3.1.1); keep alive 60s
+	if err := c.write(mqttConnect, append(body, payload...)); err != nil {
+		c.Close()
+		return nil, err
+	}
+	header, ack, err := c.read()
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	if header&0xf0 != mqttConnAck || len(ack) != 2 {
+		c.Close()
+		return nil, fmt.Errorf("mqtt: unexpected packet %#x instead of CONNACK", header)
+	}
+	if ack[1] != 0 {
+		c.Close()
+		return nil, fmt.Errorf("mqtt: connection refused: %s", cmp.Or(mqttConnectErrors[ack[1]], fmt.Sprintf("code %d", ack[1])))
+	}
+	return c, nil
+}
+
+// Close closes the connection.
+func (c *mqttConn) Close() error {
+	return c.conn.Close()
+}
+
+// publish publishes msg, waiting for the broker to acknowledge it at QoS 1
+// and 2.
+func (c *mqttConn) publish(msg mqttMessage, qos int) error {
+	header := byte(mqttPublish | qos<<1)
+	if msg.retain {
+		header |= 0x01
+	}
+	body := mqttString(msg.topic)
+	if qos > 0 {
+		c.packetID++
+		body = binary.BigEndian.AppendUint16(body, c.packetID)
+	}
+	if err := c.write(header, append(body, msg.payload...)); err != nil {
+		return err
+	}
+	switch qos {
+	case 1:
+		return c.expect(mqttPubAck)
+	case 2:
+		if err := c.expect(mqttPubRec); err != nil {
+			return err
+		}
+		if err := c.write(mqttPubRel|0x02, binary.BigEndian.AppendUint16(nil, c.packetID)); err != nil {
+			return err
+		}
+		return c.expect(mqttPubComp)
+	}
+	return nil
+}
+
+// expect reads the acknowledgement of kind for the last packet published.
+func (c *mqttConn) expect(kind byte) error {
+	got, body, err := c.read()
+	if err != nil {
+		return err
+	}
+	if got&0xf0 != kind || len(body) != 2 || binary.BigEndian.Uint16(body) != c.packetID {
+		return fmt.Errorf("mqtt: unexpected packet %#x acknowledging publish", got)
+	}
+	return nil
+}
+
+// disconnect ends the session cleanly.
+func (c *mqttConn) disconnect() error {
+	return c.write(mqttDisconnect, nil)
+}
+
+// write writes a packet with its first byte and body.
+func (c *mqttConn) write(header byte, body []byte) error {
+	packet := []byte{header}
+	for n := len(body); ; {
+		// The remaining length takes 7 bits a byte, the high bit saying
+		// another follows
+		b := byte(n % 128)
+		if n /= 128; n > 0 {
+			b |= 0x80
+		}
+		packet = append(packet, b)
+		if n == 0 {
+			break
+		}
+	}
+	if _, err := c.conn.Write(append(packet, body...)); err != nil {
+		return fmt.Errorf("mqtt: %w", err)
+	}
+	return nil
+}
+
+// read reads a packet, returning its first byte, the type and flags, and
+// its body.
+func (c *mqttConn) read() (byte, []byte, error) {
+	header, err := c.r.ReadByte()
+	if err != nil {
+		return 0, nil, fmt.Errorf("mqtt: %w", err)
+	}
+	length := 0
+	for shift := 0; ; shift += 7 {
+		b, err := c.r.ReadByte()
+		if err != nil {
+			return 0, nil, fmt.Errorf("mqtt: %w", err)
+		}
+		if shift > 21 {
+			return 0, nil, errors.New("mqtt: bad remaining length")
+		}
+		length |= int(b&0x7f) << shift
+		if b&0x80 == 0 {
+			break
+		}
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(c.r, body); err != nil {
+		return 0, nil, fmt.Errorf("mqtt: %w", err)
+	}
+	return header, body, nil
+}
+
+// mqttString encodes s as MQTT does: its length in two bytes, then s.
+func mqttString(s string) []byte {
+	return append(binary.BigEndian.AppendUint16(nil, uint16(len(s))), s...)
+}
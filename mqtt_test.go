@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeMQTT is a stand-in for an MQTT broker, accepting connections with
+// the expected password and recording what is published.
+type fakeMQTT struct {
+	password string
+
+	mu        sync.Mutex
+	clientIDs []string
+	messages  []fakeMQTTMessage
+}
+
+// fakeMQTTMessage is a message published to the fake broker.
+type fakeMQTTMessage struct {
+	topic   string
+	payload string
+	qos     int
+	retain  bool
+}
+
+func newFakeMQTT(t *testing.T, password string) (*fakeMQTT, string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	f := &fakeMQTT{password: password}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return f, ln.Addr().String()
+}
+
+func (f *fakeMQTT) serve(conn net.Conn) {
+	defer conn.Close()
+	c := &mqttConn{conn: conn, r: bufio.NewReader(conn)}
+	for {
+		header, body, err := c.read()
+		if err != nil {
+			return
+		}
+		switch header & 0xf0 {
+		case mqttConnect:
+			// Protocol name, level, flags and keep alive, then the client ID
+			flags := body[7]
+			clientID, rest := readMQTTString(body[10:])
+			var password string
+			if flags&0x80 != 0 {
+				_, rest = readMQTTString(rest)
+			}
+			if flags&0x40 != 0 {
+				password, _ = readMQTTString(rest)
+			}
+			code := byte(0)
+			if password != f.password {
+				code = 4
+			}
+			f.mu.Lock()
+			f.clientIDs = append(f.clientIDs, clientID)
+			f.mu.Unlock()
+			c.write(mqttConnAck, []byte{0, code})
+		case mqttPublish:
+			msg := fakeMQTTMessage{qos: int(header>>1) & 0x03, retain: header&0x01 != 0}
+			var rest []byte
+			msg.topic, rest = readMQTTString(body)
+			var id []byte
+			if msg.qos > 0 {
+				id, rest = rest[:2], rest[2:]
+			}
+			msg.payload = string(rest)
+			f.mu.Lock()
+			f.messages = append(f.messages, msg)
+			f.mu.Unlock()
+			switch msg.qos {
+			case 1:
+				c.write(mqttPubAck, id)
+			case 2:
+				c.write(mqttPubRec, id)
+			}
+		case mqttPubRel:
+			c.write(mqttPubComp, body)
+		case mqttDisconnect:
+			return
+		}
+	}
+}
+
+// published waits until n messages were published, as at QoS 0 the client
+// doesn't wait for the broker, and returns them with the client IDs that
+// connected.
+func (f *fakeMQTT) published(n int) ([]fakeMQTTMessage, []string) {
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		f.mu.Lock()
+		done := len(f.messages) >= n
+		f.mu.Unlock()
+		if done {
+			break
+		}
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]fakeMQTTMessage(nil), f.messages...), append([]string(nil), f.clientIDs...)
+}
+
+func readMQTTString(b []byte) (string, []byte) {
+	n := int(binary.BigEndian.Uint16(b))
+	return string(b[2 : 2+n]), b[2+n:]
+}
+
+// TestMQTTNotifier tests that events are published as JSON at the
+// configured QoS, with the new IP of a change retained
+func TestMQTTNotifier(t *testing.T) {
+	for _, qos := range []int{0, 1, 2} {
+		broker, addr := newFakeMQTT(t, "secret")
+		notifier := newMQTTNotifier(MQTTNotificationConfig{Broker: addr, ClientID: "ddns-test", Username: "ddns", Password: "secret", Topic: "home/ddns", QoS: qos})
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		err := notifier.notify(ctx, nil, Event{Event: EventIPChange, OldIP: "198.51.100.7", NewIP: "203.0.113.42", Provider: "dreamhost"})
+		if err == nil {
+			err = notifier.notify(ctx, nil, Event{Event: EventUpdateFailed, Provider: "dreamhost", Failures: 1})
+		}
+		cancel()
+		if err != nil {
+			t.Fatalf("qos %d: unexpected error: %v", qos, err)
+		}
+
+		got, clientIDs := broker.published(3)
+		if len(got) != 3 {
+			t.Fatalf("qos %d: expected 3 messages, got %+v", qos, got)
+		}
+		var e Event
+		if err := json.Unmarshal([]byte(got[0].payload), &e); err != nil || got[0].topic != "home/ddns/events/ip_change" || e.NewIP != "203.0.113.42" || got[0].retain {
+			t.Errorf("qos %d: unexpected event message %+v", qos, got[0])
+		}
+		if want := (fakeMQTTMessage{topic: "home/ddns/ip", payload: "203.0.113.42", qos: qos, retain: true}); got[1] != want {
+			t.Errorf("qos %d: expected %+v, got %+v", qos, want, got[1])
+		}
+		if got[2].topic != "home/ddns/events/update_failed" || got[2].qos != qos {
+			t.Errorf("qos %d: unexpected event message %+v", qos, got[2])
+		}
+		if clientIDs[0] != "ddns-test" {
+			t.Errorf("qos %d: expected client ID ddns-test, got %s", qos, clientIDs[0])
+		}
+	}
+}
+
+// TestMQTTConnectRefused tests that a broker refusing the credentials is
+// reported
+func TestMQTTConnectRefused(t *testing.T) {
+	_, addr := newFakeMQTT(t, "secret")
+	notifier := newMQTTNotifier(MQTTNotificationConfig{Broker: addr, Username: "ddns", Password: "wrong", Topic: DefaultMQTTTopic})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := notifier.notify(ctx, nil, Event{Event: EventIPChange, NewIP: "203.0.113.42"})
+	if err == nil || !strings.Contains(err.Error(), "bad username or password") {
+		t.Errorf("expected the connection to be refused, got %v", err)
+	}
+}
+
+// TestMQTTRemainingLength tests the encoding of packet lengths over
+// several bytes
+func TestMQTTRemainingLength(t *testing.T) {
+	for _, n := range []int{0, 127, 128, 16383, 16384, 70000} {
+		client, server := net.Pipe()
+		c := &mqttConn{conn: client}
+		go func() {
+			c.write(mqttPublish, make([]byte, n))
+			client.Close()
+		}()
+		s := &mqttConn{conn: server, r: bufio.NewReader(server)}
+		header, body, err := s.read()
+		if err != nil || header != mqttPublish || len(body) != n {
+			t.Errorf("%d bytes: got %#x with %d bytes, %v", n, header, len(body), err)
+		}
+		server.Close()
+	}
+}
+
+// TestValidateMQTTNotification tests the checks of an MQTT notification
+func TestValidateMQTTNotification(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  MQTTNotificationConfig
+		wantErr string
+	}{
+		{"valid", MQTTNotificationConfig{Broker: "mqtt.lan:1883", Topic: DefaultMQTTTopic, QoS: 1}, ""},
+		{"no port", MQTTNotificationConfig{Broker: "mqtt.lan", Topic: DefaultMQTTTopic}, "not host:port"},
+		{"wildcard topic", MQTTNotificationConfig{Broker: "mqtt.lan:1883", Topic: "home/#"}, "not a topic prefix"},
+		{"qos", MQTTNotificationConfig{Broker: "mqtt.lan:1883", Topic: DefaultMQTTTopic, QoS: 3}, "qos 3 is not 0, 1 or 2"},
+		{"password without username", MQTTNotificationConfig{Broker: "mqtt.lan:1883", Topic: DefaultMQTTTopic, Password: "p"}, "password requires username"},
+		{"home assistant", MQTTNotificationConfig{Broker: "mqtt.lan:1883", Topic: DefaultMQTTTopic, HomeAssistant: true, DiscoveryPrefix: "ha"}, ""},
+		{"discovery prefix without home assistant", MQTTNotificationConfig{Broker: "mqtt.lan:1883", Topic: DefaultMQTTTopic, DiscoveryPrefix: "ha"}, "discovery_prefix requires home_assistant"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateMQTTNotification(tt.config)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// NetworkManager's D-Bus names, and its state of full connectivity.
+const (
+	nmService         = "org.freedesktop.NetworkManager"
+	nmPath            = "/org/freedesktop/NetworkManager"
+	nmConnectedGlobal = 70
+)
+
+// nmMatchRules select the NetworkManager signals watchNetwork reacts to:
+// its connectivity state changing, and its properties, among them the
+// primary connection, changing.
+var nmMatchRules = []string{
+	"type='signal',sender='" + nmService + "',path='" + nmPath + "',interface='" + nmService + "',member='StateChanged'",
+	"type='signal',sender='" + nmService + "',path='" + nmPath + "',interface='org.freedesktop.DBus.Properties',member='PropertiesChanged',arg0='" + nmService + "'",
+}
+
+// networkSettleDelay is how long NetworkManager must report no further
+// change before the check runs, so that the new connection has settled and
+// a burst of changes is checked once.
+var networkSettleDelay = 2 * time.Second
+
+// networkRetryDelay is how long after losing the system bus watchNetwork
+// connects to it again.
+var networkRetryDelay = 30 * time.Second
+
+// watchNetwork requests a check whenever NetworkManager reports that the
+// machine is fully connected again or that its primary connection
+// changed, as after switching networks, instead of leaving the change to
+// the next check_interval. It runs until ctx is cancelled, connecting to
+// the system bus again if the connection is lost.
+func (d *DDNSUpdater) watchNetwork(ctx context.Context) {
+	for {
+		err := d.followNetworkManager(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		d.repeats.Error(ctx, d.logger, "network_manager", err.Error(), "Watching NetworkManager failed", "error", err, "retry_in", networkRetryDelay)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(networkRetryDelay):
+		}
+	}
+}
+
+// followNetworkManager subscribes to NetworkManager's signals on the system
+// bus and requests checks for them until the connection fails or ctx is
+// cancelled.
+func (d *DDNSUpdater) followNetworkManager(ctx context.Context) error {
+	bus, err := dialDBus(ctx, dbusSystemBusAddress())
+	if err != nil {
+		return fmt.Errorf("connecting to the system bus: %w", err)
+	}
+	defer bus.Close()
+	stop := context.AfterFunc(ctx, func() { bus.Close() })
+	defer stop()
+
+	for _, rule := range nmMatchRules {
+		if _, err := bus.call("org.freedesktop.DBus", "/org/freedesktop/DBus", "org.freedesktop.DBus", "AddMatch", rule); err != nil {
+			return fmt.Errorf("subscribing to NetworkManager: %w", err)
+		}
+	}
+	d.repeats.Recovered(ctx, d.logger, "network_manager", "Watching NetworkManager again")
+	d.logger.Debug("Watching NetworkManager for network changes")
+
+	settled := time.AfterFunc(networkSettleDelay, func() {
+		d.logger.Info("Network changed; checking now")
+		d.RequestCheck()
+	})
+	settled.Stop()
+	defer settled.Stop()
+	for {
+		msg, err := bus.read()
+		if err != nil {
+			return err
+		}
+		if change := networkChange(msg); change != "" {
+			d.logger.Debug("NetworkManager reported a change", "change", change)
+			settled.Reset(networkSettleDelay)
+		}
+	}
+}
+
+// networkChange returns the change a NetworkManager signal reports that
+// calls for a check, or "" if it reports none.
+func networkChange(msg *dbusMessage) string {
+	if msg.Type != dbusSignal || msg.Path != nmPath {
+		return ""
+	}
+	switch {
+	case msg.Interface == nmService && msg.Member == "StateChanged":
+		if len(msg.Body) == 1 && msg.Body[0] == uint32(nmConnectedGlobal) {
+			return "connected"
+		}
+	case msg.Interface == "org.freedesktop.DBus.Properties" && msg.Member == "PropertiesChanged":
+		if len(msg.Body) < 2 || msg.Body[0] != nmService {
+			return ""
+		}
+		changed, _ := msg.Body[1].([]any)
+		for _, entry := range changed {
+			// "/" is no connection at all, which there's no point checking
+			if kv, ok := entry.([]any); ok && len(kv) == 2 && kv[0] == "PrimaryConnection" && kv[1] != "/" {
+				return "primary connection"
+			}
+		}
+	}
+	return ""
+}
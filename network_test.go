@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestNetworkChange tests which NetworkManager signals call for a check
+func TestNetworkChange(t *testing.T) {
+	properties := func(property, path string) *dbusMessage {
+		msg, err := parseDBusMessage((&dbusMessage{
+			Type:      dbusSignal,
+			Path:      nmPath,
+			Interface: "org.freedesktop.DBus.Properties",
+			Member:    "PropertiesChanged",
+			Signature: "sa{sv}as",
+		}).marshal(encodePropertiesChanged(nmService, property, path)), binary.LittleEndian)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return msg
+	}
+	state := func(s uint32) *dbusMessage {
+		return &dbusMessage{Type: dbusSignal, Path: nmPath, Interface: nmService, Member: "StateChanged", Body: []any{s}}
+	}
+	tests := []struct {
+		name string
+		msg  *dbusMessage
+		want string
+	}{
+		{"fully connected", state(nmConnectedGlobal), "connected"},
+		{"connecting", state(40), ""},
+		{"primary connection", properties("PrimaryConnection", "/org/freedesktop/NetworkManager/ActiveConnection/3"), "primary connection"},
+		{"no primary connection", properties("PrimaryConnection", "/"), ""},
+		{"other property", properties("Devices", "/org/freedesktop/NetworkManager/Devices/2"), ""},
+		{"method call", &dbusMessage{Type: dbusMethodCall, Path: nmPath, Interface: nmService, Member: "StateChanged", Body: []any{uint32(70)}}, ""},
+	}
+	for _, tt := range tests {
+		if got := networkChange(tt.msg); got != tt.want {
+			t.Errorf("%s: expected %q, got %q", tt.name, tt.want, got)
+		}
+	}
+}
+
+// serveFakeBus accepts one connection on a socket DBUS_SYSTEM_BUS_ADDRESS
+// points at, answers its authentication and the calls expected, then sends
+// signal.
+func serveFakeBus(t *testing.T, calls int, signal *dbusMessage, body []byte) <-chan []string {
+	t.Helper()
+	// Kept short: socket paths are limited to about 100 bytes
+	dir, err := os.MkdirTemp("", "bus")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	socket := filepath.Join(dir, "bus")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Skipf("unix sockets unavailable: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	t.Setenv("DBUS_SYSTEM_BUS_ADDRESS", "unix:path="+socket)
+
+	received := make(chan []string, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		bus := &dbusConn{conn: conn, r: bufio.NewReader(conn)}
+		if line, err := bus.r.ReadString('\n'); err != nil || !strings.HasPrefix(line, "\x00AUTH EXTERNAL ") {
+			return
+		}
+		io.WriteString(conn, "OK 0123456789abcdef\r\n")
+		if line, _ := bus.r.ReadString('\n'); line != "BEGIN\r\n" {
+			return
+		}
+		var members []string
+		for range calls {
+			msg, err := bus.read()
+			if err != nil {
+				return
+			}
+			members = append(members, msg.Member)
+			conn.Write((&dbusMessage{Type: dbusMethodReturn, Serial: msg.Serial + 100, ReplySerial: msg.Serial}).marshal(nil))
+		}
+		received <- members
+		conn.Write(signal.marshal(body))
+		io.Copy(io.Discard, conn)
+	}()
+	return received
+}
+
+// TestWatchNetwork tests that NetworkManager reporting full connectivity
+// requests a check
+func TestWatchNetwork(t *testing.T) {
+	defer func(d time.Duration) { networkSettleDelay = d }(networkSettleDelay)
+	networkSettleDelay = time.Millisecond
+	var body dbusEncoder
+	body.uint32(nmConnectedGlobal)
+	signal := &dbusMessage{Type: dbusSignal, Serial: 1, Path: nmPath, Interface: nmService, Member: "StateChanged", Signature: "u"}
+	received := serveFakeBus(t, 1+len(nmMatchRules), signal, body.buf)
+
+	updater := &DDNSUpdater{logger: slog.New(slog.NewJSONHandler(io.Discard, nil)), checkNow: make(chan struct{}, 1)}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		updater.watchNetwork(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	select {
+	case members := <-received:
+		if strings.Join(members, ",") != "Hello,AddMatch,AddMatch" {
+			t.Errorf("unexpected calls %v", members)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the subscription")
+	}
+	select {
+	case <-updater.checkNow:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected a check to be requested")
+	}
+}
@@ -0,0 +1,453 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The events notifications are sent for.
+const (
+	EventIPChange     = "ip_change"     // Records were updated to a new IP
+	EventUpdateFailed = "update_failed" // A provider's records couldn't be brought up to date
+	EventRecovered    = "recovered"     // They could again after failing
+)
+
+// notificationEvents lists the events, and defaultNotificationEvents those
+// a notification target receives unless it lists its own.
+var (
+	notificationEvents        = []string{EventIPChange, EventUpdateFailed, EventRecovered}
+	defaultNotificationEvents = []string{EventIPChange, EventUpdateFailed}
+)
+
+// notificationTimeout bounds how long delivering a notification may take.
+const notificationTimeout = 30 * time.Second
+
+// NotificationsConfig configures where the daemon reports IP changes and
+// update outcomes.
+type NotificationsConfig struct {
+	Webhooks []WebhookNotificationConfig  `yaml:"webhooks"` // URLs POSTed a JSON Event
+	Slack    []SlackNotificationConfig    `yaml:"slack"`    // Slack incoming webhooks posted a message
+	Discord  []DiscordNotificationConfig  `yaml:"discord"`  // Discord webhooks posted an embed
+	Ntfy     []NtfyNotificationConfig     `yaml:"ntfy"`     // ntfy topics published to
+	Gotify   []GotifyNotificationConfig   `yaml:"gotify"`   // Gotify applications sent messages
+	Pushover []PushoverNotificationConfig `yaml:"pushover"` // Pushover users sent push notifications
+	MQTT     []MQTTNotificationConfig     `yaml:"mqtt"`     // MQTT brokers published to
+}
+
+// NotificationRoute is the part of a notification target's settings saying
+// which events it receives, so that, say, failures page someone while
+// changes only go to a chat channel.
+type NotificationRoute struct {
+	Events    []string `yaml:"events"`    // Events sent (default ip_change and update_failed)
+	Providers []string `yaml:"providers"` // Only send events concerning these providers; empty sends all
+	// FailureThreshold is how many publication cycles of a provider must
+	// fail in a row before update_failed is sent (default 1). recovered is
+	// only sent after update_failed was.
+	FailureThreshold int `yaml:"failure_threshold"`
+	// SuppressDuplicates drops an event identical to one sent less than
+	// this long before, such as a provider failing the same way again after
+	// briefly recovering; 0 sends every event.
+	SuppressDuplicates time.Duration `yaml:"suppress_duplicates"`
+}
+
+// validate checks the route's events and thresholds; validateConfig checks
+// its providers, which the route alone doesn't know.
+func (r NotificationRoute) validate() error {
+	if err := validateNotificationEvents(r.Events); err != nil {
+		return err
+	}
+	if r.FailureThreshold < 0 {
+		return errors.New("failure_threshold must not be negative")
+	}
+	if r.SuppressDuplicates < 0 {
+		return errors.New("suppress_duplicates must not be negative")
+	}
+	return nil
+}
+
+// wants reports whether an event is for the route: one of its events,
+// concerning one of its providers, and for a failure, the one reaching its
+// threshold or the recovery from it.
+func (r NotificationRoute) wants(e Event) bool {
+	if !slices.Contains(r.Events, e.Event) {
+		return false
+	}
+	if len(r.Providers) > 0 && !slices.Contains(r.Providers, e.Provider) {
+		return false
+	}
+	switch e.Event {
+	case EventUpdateFailed:
+		return e.Failures == max(r.FailureThreshold, 1)
+	case EventRecovered:
+		return e.Failures >= max(r.FailureThreshold, 1)
+	}
+	return true
+}
+
+// notificationRoute is the route of a target, with where it is configured.
+type notificationRoute struct {
+	key   string // Key of the target's list in the notifications block
+	label string // What errors call the target
+	index int
+	route *NotificationRoute
+}
+
+// routes returns the routes of all the targets, in the order
+// newNotifications builds them.
+func (c *NotificationsConfig) routes() []notificationRoute {
+	var routes []notificationRoute
+	add := func(key, label string, index int, route *NotificationRoute) {
+		routes = append(routes, notificationRoute{key, label, index, route})
+	}
+	for i := range c.Webhooks {
+		add("webhooks", "webhook", i, &c.Webhooks[i].NotificationRoute)
+	}
+	for i := range c.Slack {
+		add("slack", "slack", i, &c.Slack[i].NotificationRoute)
+	}
+	for i := range c.Discord {
+		add("discord", "discord", i, &c.Discord[i].NotificationRoute)
+	}
+	for i := range c.Ntfy {
+		add("ntfy", "ntfy", i, &c.Ntfy[i].NotificationRoute)
+	}
+	for i := range c.Gotify {
+		add("gotify", "gotify", i, &c.Gotify[i].NotificationRoute)
+	}
+	for i := range c.Pushover {
+		add("pushover", "pushover", i, &c.Pushover[i].NotificationRoute)
+	}
+	for i := range c.MQTT {
+		add("mqtt", "mqtt", i, &c.MQTT[i].NotificationRoute)
+	}
+	return routes
+}
+
+// WebhookNotificationConfig is a URL receiving events as JSON.
+type WebhookNotificationConfig struct {
+	URL     string            `yaml:"url"`
+	Headers map[string]string `yaml:"headers"` // Extra request headers, e.g. Authorization
+
+	NotificationRoute `yaml:",inline"` // Which events are sent, and when
+}
+
+// Event is a notification, as webhooks receive it.
+type Event struct {
+	Event    string    `json:"event"` // One of the Event* constants
+	Time     time.Time `json:"time"`
+	Host     string    `json:"host"`               // Host the daemon runs on
+	Provider string    `json:"provider,omitempty"` // Provider whose records the event concerns
+	OldIP    string    `json:"old_ip,omitempty"`
+	NewIP    string    `json:"new_ip,omitempty"`
+	Geo      *GeoInfo  `json:"geo,omitempty"`     // Network and country of NewIP, if geoip is configured
+	Records  []string  `json:"records,omitempty"` // Records changed, as "name type"
+	Errors   []string  `json:"errors,omitempty"`
+	Failures int       `json:"failures,omitempty"` // Cycles failed in a row, for update_failed and recovered
+	Summary  string    `json:"summary"`            // The event in a sentence, for people
+}
+
+// summarize returns the event's summary.
+func (e Event) summarize() string {
+	var b strings.Builder
+	switch e.Event {
+	case EventIPChange:
+		fmt.Fprintf(&b, "IP changed from %s to %s", cmp.Or(e.OldIP, "unknown"), e.NewIP)
+		if e.Geo != nil {
+			if geo := e.Geo.String(); geo != "" {
+				fmt.Fprintf(&b, " (%s)", geo)
+			}
+			if e.Geo.Unexpected {
+				b.WriteString(", an unexpected network")
+			}
+		}
+		fmt.Fprintf(&b, "; updated %d records at %s", len(e.Records), e.Provider)
+	case EventUpdateFailed:
+		fmt.Fprintf(&b, "Updating records at %s failed", e.Provider)
+		if e.Failures > 1 {
+			fmt.Fprintf(&b, " %d times in a row", e.Failures)
+		}
+	case EventRecovered:
+		fmt.Fprintf(&b, "Records at %s are updating again after %d failed attempts", e.Provider, e.Failures)
+	}
+	if len(e.Errors) > 0 {
+		fmt.Fprintf(&b, ": %s", strings.Join(e.Errors, "; "))
+	}
+	return b.String()
+}
+
+// notifier delivers events to one destination.
+type notifier interface {
+	notify(ctx context.Context, client *http.Client, e Event) error
+}
+
+// notificationTarget is a notifier with the route of the events it
+// receives.
+type notificationTarget struct {
+	name     string // For logs
+	route    NotificationRoute
+	notifier notifier
+	sent     map[string]time.Time // When each event was last sent, by eventKey, if duplicates are suppressed
+}
+
+// duplicate reports whether e repeats an event sent to the target less than
+// suppress_duplicates before now, and otherwise records that it was sent.
+// Only send calls it, under the notifications' lock.
+func (t *notificationTarget) duplicate(e Event, now time.Time) bool {
+	if t.route.SuppressDuplicates <= 0 {
+		return false
+	}
+	if t.sent == nil {
+		t.sent = make(map[string]time.Time)
+	}
+	for key, at := range t.sent {
+		if now.Sub(at) >= t.route.SuppressDuplicates {
+			delete(t.sent, key)
+		}
+	}
+	key := e.key()
+	if _, ok := t.sent[key]; ok {
+		return true
+	}
+	t.sent[key] = now
+	return false
+}
+
+// key identifies an event by what it reports, leaving out when it
+// happened.
+func (e Event) key() string {
+	e.Time, e.Failures, e.Summary = time.Time{}, 0, ""
+	b, _ := json.Marshal(e)
+	return string(b)
+}
+
+// notifications sends events to the configured targets in the background,
+// logging failures. A nil *notifications sends nothing.
+type notifications struct {
+	targets []notificationTarget
+	client  *http.Client
+	logger  *slog.Logger
+	host    string
+	wg      sync.WaitGroup
+
+	mu  sync.Mutex       // Guards the targets' sent events
+	now func() time.Time // For tests; nil means time.Now
+}
+
+// newNotifications returns the notifications config asks for, or nil if
+// there are none.
+func newNotifications(config NotificationsConfig, client *http.Client, logger *slog.Logger) (*notifications, error) {
+	var targets []notificationTarget
+	for _, w := range config.Webhooks {
+		targets = append(targets, notificationTarget{
+			name:     "webhook " + redactConfigURL(w.URL),
+			route:    w.NotificationRoute,
+			notifier: webhookNotifier(w),
+		})
+	}
+	for i, s := range config.Slack {
+		notifier, err := newSlackNotifier(s)
+		if err != nil {
+			return nil, fmt.Errorf("notifications: slack %d: %w", i, err)
+		}
+		targets = append(targets, notificationTarget{
+			name:     fmt.Sprintf("slack %d", i), // The URL is a secret
+			route:    s.NotificationRoute,
+			notifier: notifier,
+		})
+	}
+	for i, d := range config.Discord {
+		targets = append(targets, notificationTarget{
+			name:     fmt.Sprintf("discord %d", i),
+			route:    d.NotificationRoute,
+			notifier: discordNotifier(d),
+		})
+	}
+	for _, n := range config.Ntfy {
+		targets = append(targets, notificationTarget{
+			name:     "ntfy " + n.Topic,
+			route:    n.NotificationRoute,
+			notifier: ntfyNotifier(n),
+		})
+	}
+	for _, g := range config.Gotify {
+		targets = append(targets, notificationTarget{
+			name:     "gotify " + redactConfigURL(g.Server),
+			route:    g.NotificationRoute,
+			notifier: gotifyNotifier(g),
+		})
+	}
+	for i, p := range config.Pushover {
+		targets = append(targets, notificationTarget{
+			name:     fmt.Sprintf("pushover %d", i),
+			route:    p.NotificationRoute,
+			notifier: pushoverNotifier(p),
+		})
+	}
+	for _, m := range config.MQTT {
+		targets = append(targets, notificationTarget{
+			name:     "mqtt " + m.Broker,
+			route:    m.NotificationRoute,
+			notifier: newMQTTNotifier(m),
+		})
+	}
+	if len(targets) == 0 {
+		return nil, nil
+	}
+	host, _ := os.Hostname()
+	return &notifications{targets: targets, client: client, logger: logger, host: host}, nil
+}
+
+// send delivers e to every target whose route wants it, unless it repeats
+// an event sent to the target recently.
+func (n *notifications) send(e Event) {
+	if n == nil {
+		return
+	}
+	e.Time, e.Host = time.Now(), n.host
+	if n.now != nil {
+		e.Time = n.now()
+	}
+	e.Summary = e.summarize()
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for i := range n.targets {
+		target := &n.targets[i]
+		if !target.route.wants(e) || target.duplicate(e, e.Time) {
+			continue
+		}
+		n.wg.Add(1)
+		go func() {
+			defer n.wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), notificationTimeout)
+			defer cancel()
+			if err := target.notifier.notify(ctx, n.client, e); err != nil {
+				n.logger.Warn("Failed to send notification", "target", target.name, "event", e.Event, "error", err)
+			}
+		}()
+	}
+}
+
+// wait waits for the notifications being sent.
+func (n *notifications) wait() {
+	if n != nil {
+		n.wg.Wait()
+	}
+}
+
+// webhookNotifier POSTs events as JSON.
+type webhookNotifier WebhookNotificationConfig
+
+func (w webhookNotifier) notify(ctx context.Context, client *http.Client, e Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return postNotification(ctx, client, w.URL, "application/json", body, w.Headers)
+}
+
+// postNotification POSTs body to target, failing unless the answer is a 2xx.
+// Errors leave out the URL, which may hold a secret.
+func postNotification(ctx context.Context, client *http.Client, target, contentType string, body []byte, headers map[string]string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("User-Agent", "dh-ddns-updater/"+version)
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := client.Do(req)
+	if urlErr, ok := err.(*url.Error); ok {
+		return urlErr.Err
+	} else if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// validateWebhookNotification checks a webhook notification.
+func validateWebhookNotification(w WebhookNotificationConfig) error {
+	if !strings.HasPrefix(w.URL, "http://") && !strings.HasPrefix(w.URL, "https://") {
+		return fmt.Errorf("url %q is not an http or https URL", redactConfigURL(w.URL))
+	}
+	return w.NotificationRoute.validate()
+}
+
+// validateNotificationEvents checks that events are all known.
+func validateNotificationEvents(events []string) error {
+	for _, event := range events {
+		if !slices.Contains(notificationEvents, event) {
+			return fmt.Errorf("unknown event %q (want %s)", event, strings.Join(notificationEvents, ", "))
+		}
+	}
+	return nil
+}
+
+// notifyIPChange notifies of the records a cycle updated if any address
+// record among them was moved to the plan's IP. errs are the cycle's
+// failures, as a change can come with them.
+func (d *DDNSUpdater) notifyIPChange(plan *Plan, applied []Action, errs []error) {
+	if plan.IP == "" {
+		return
+	}
+	e := Event{Event: EventIPChange, NewIP: plan.IP, Geo: d.geoInfo(plan.IP), Errors: errorTexts(errs...)}
+	changed := false
+	for _, a := range applied {
+		e.Records = append(e.Records, a.Record+" "+a.Type)
+		e.Provider = a.Domain.Provider // A cycle publishes one provider's records
+		if isAddressType(a.Type) && a.Desired == plan.IP {
+			changed = true
+			e.OldIP = cmp.Or(e.OldIP, a.Current)
+		}
+	}
+	if changed {
+		d.notifications.send(e)
+	}
+}
+
+// notifyOutcome reports each failed publication of h's records with the
+// number of cycles failed in a row, for the targets to notify when it
+// reaches their failure_threshold, and the recovery from them. Only the
+// stage of h calls it.
+func (d *DDNSUpdater) notifyOutcome(h *providerHandle, err error) {
+	switch {
+	case err != nil:
+		h.failures++
+		d.notifications.send(Event{Event: EventUpdateFailed, Provider: h.name, Failures: h.failures, Errors: errorTexts(err)})
+	case h.failures > 0:
+		d.notifications.send(Event{Event: EventRecovered, Provider: h.name, Failures: h.failures})
+		h.failures = 0
+	}
+}
+
+// errorTexts returns the texts of errs, listing those a joined or
+// applyError holds separately.
+func errorTexts(errs ...error) []string {
+	var texts []string
+	for _, err := range errs {
+		var joined interface{ Unwrap() []error }
+		if errors.As(err, &joined) {
+			texts = append(texts, errorTexts(joined.Unwrap()...)...)
+		} else {
+			texts = append(texts, err.Error())
+		}
+	}
+	return texts
+}
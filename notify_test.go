@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// eventRecorder is a webhook notification target keeping the events it
+// receives.
+type eventRecorder struct {
+	mu      sync.Mutex
+	events  []Event
+	headers []http.Header
+}
+
+func (r *eventRecorder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	var e Event
+	if err := json.NewDecoder(req.Body).Decode(&e); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, e)
+	r.headers = append(r.headers, req.Header)
+}
+
+func (r *eventRecorder) received() []Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Clone(r.events)
+}
+
+// newTestNotifications returns notifications sent to a recorder, as a
+// webhook receiving events.
+func newTestNotifications(t *testing.T, events ...string) (*notifications, *eventRecorder) {
+	t.Helper()
+	recorder := &eventRecorder{}
+	server := httptest.NewServer(recorder)
+	t.Cleanup(server.Close)
+	config := NotificationsConfig{Webhooks: []WebhookNotificationConfig{{
+		URL:               server.URL,
+		NotificationRoute: NotificationRoute{Events: events},
+		Headers:           map[string]string{"Authorization": "Bearer secret"},
+	}}}
+	n, err := newNotifications(config, &http.Client{Timeout: 5 * time.Second}, slog.New(slog.NewJSONHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return n, recorder
+}
+
+// TestNotifyIPChange tests that updating records to a new IP notifies of
+// the change, with the records updated and the failures alongside it
+func TestNotifyIPChange(t *testing.T) {
+	fake := newFakeDreamhost(
+		DNSRecord{Record: "home.example.com", Type: "A", Value: "198.51.100.1", Comment: ManagedComment},
+		DNSRecord{Record: "manual.example.com", Type: "A", Value: "198.51.100.1", Comment: "hand-made"},
+	)
+	updater := newPlanTestUpdater(t, fake, "203.0.113.42",
+		DomainConfig{Name: "example.com", Record: "home", Type: "A"},
+		DomainConfig{Name: "example.com", Record: "manual", Type: "A"},
+	)
+	n, recorder := newTestNotifications(t, defaultNotificationEvents...)
+	updater.notifications = n
+
+	plan, err := updater.planAll(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err = updater.apply(context.Background(), plan, ApplyPolicy{})
+	if err == nil {
+		t.Fatal("expected an error for the skipped record")
+	}
+	n.wait()
+
+	events := recorder.received()
+	if len(events) != 1 {
+		t.Fatalf("expected 1 event, got %d: %+v", len(events), events)
+	}
+	e := events[0]
+	if e.Event != EventIPChange || e.OldIP != "198.51.100.1" || e.NewIP != "203.0.113.42" || e.Provider != DefaultProvider {
+		t.Errorf("unexpected event: %+v", e)
+	}
+	if !slices.Equal(e.Records, []string{"home.example.com A"}) {
+		t.Errorf("expected the updated record, got %v", e.Records)
+	}
+	if len(e.Errors) != 1 || !strings.HasPrefix(e.Errors[0], "manual.example.com: ") {
+		t.Errorf("expected the skipped record's error, got %v", e.Errors)
+	}
+	if e.Host == "" || e.Time.IsZero() || !strings.Contains(e.Summary, "198.51.100.1 to 203.0.113.42") {
+		t.Errorf("expected host, time and summary to be set: %+v", e)
+	}
+	if got := recorder.headers[0].Get("Authorization"); got != "Bearer secret" {
+		t.Errorf("expected the configured header, got %q", got)
+	}
+
+	// Nothing changed the second time round
+	plan, err = updater.planAll(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	updater.apply(context.Background(), plan, ApplyPolicy{})
+	n.wait()
+	if events := recorder.received(); len(events) != 1 {
+		t.Errorf("expected no event without a change, got %+v", events[1:])
+	}
+}
+
+// TestNotifyOutcome tests that a provider's failures notify once when they
+// start, and that recovering notifies only targets asking for it
+func TestNotifyOutcome(t *testing.T) {
+	updater := newPlanTestUpdater(t, newFakeDreamhost(), "203.0.113.42")
+	n, recorder := newTestNotifications(t, notificationEvents...)
+	updater.notifications = n
+	h := &providerHandle{name: "dreamhost"}
+
+	failure := &applyError{errs: []error{errors.New("a.example.com: boom"), errors.New("b.example.com: bang")}}
+	updater.notifyOutcome(h, failure)
+	updater.notifyOutcome(h, failure)
+	n.wait() // Notifications are sent concurrently, and cycles apart in the daemon
+	updater.notifyOutcome(h, nil)
+	updater.notifyOutcome(h, nil)
+	n.wait()
+
+	events := recorder.received()
+	var kinds []string
+	for _, e := range events {
+		kinds = append(kinds, e.Event)
+	}
+	if !slices.Equal(kinds, []string{EventUpdateFailed, EventRecovered}) {
+		t.Fatalf("expected a failure then a recovery, got %v", kinds)
+	}
+	if !slices.Equal(events[0].Errors, []string{"a.example.com: boom", "b.example.com: bang"}) {
+		t.Errorf("expected each record's error, got %v", events[0].Errors)
+	}
+	if events[0].Provider != "dreamhost" || events[1].Provider != "dreamhost" {
+		t.Errorf("expected the provider to be named, got %+v", events)
+	}
+
+	// By default, recoveries aren't sent
+	updater.notifications, recorder = newTestNotifications(t, defaultNotificationEvents...)
+	updater.notifyOutcome(h, failure)
+	updater.notifyOutcome(h, nil)
+	updater.notifications.wait()
+	if events := recorder.received(); len(events) != 1 || events[0].Event != EventUpdateFailed {
+		t.Errorf("expected only the failure, got %+v", events)
+	}
+}
+
+// TestValidateWebhookNotification tests the checks of webhook notifications
+func TestValidateWebhookNotification(t *testing.T) {
+	tests := []struct {
+		name    string
+		webhook WebhookNotificationConfig
+		wantErr string
+	}{
+		{"valid", WebhookNotificationConfig{URL: "https://hooks.example.com/ddns", NotificationRoute: NotificationRoute{Events: notificationEvents}}, ""},
+		{"not http", WebhookNotificationConfig{URL: "ftp://hooks.example.com", NotificationRoute: NotificationRoute{Events: defaultNotificationEvents}}, "not an http or https URL"},
+		{"unknown event", WebhookNotificationConfig{URL: "http://localhost/hook", NotificationRoute: NotificationRoute{Events: []string{"ip_changed"}}}, `unknown event "ip_changed"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateWebhookNotification(tt.webhook)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+// TestNotificationRouteWants tests which events a route lets through
+func TestNotificationRouteWants(t *testing.T) {
+	route := NotificationRoute{Events: notificationEvents, Providers: []string{"work"}, FailureThreshold: 3}
+	tests := []struct {
+		name  string
+		route NotificationRoute
+		event Event
+		want  bool
+	}{
+		{"listed event", NotificationRoute{Events: []string{EventIPChange}}, Event{Event: EventIPChange}, true},
+		{"unlisted event", NotificationRoute{Events: []string{EventIPChange}}, Event{Event: EventRecovered, Failures: 1}, false},
+		{"first failure", NotificationRoute{Events: notificationEvents}, Event{Event: EventUpdateFailed, Failures: 1}, true},
+		{"repeated failure", NotificationRoute{Events: notificationEvents}, Event{Event: EventUpdateFailed, Failures: 2}, false},
+		{"other provider", route, Event{Event: EventUpdateFailed, Provider: "dreamhost", Failures: 3}, false},
+		{"below threshold", route, Event{Event: EventUpdateFailed, Provider: "work", Failures: 2}, false},
+		{"at threshold", route, Event{Event: EventUpdateFailed, Provider: "work", Failures: 3}, true},
+		{"past threshold", route, Event{Event: EventUpdateFailed, Provider: "work", Failures: 4}, false},
+		{"recovery below threshold", route, Event{Event: EventRecovered, Provider: "work", Failures: 2}, false},
+		{"recovery past threshold", route, Event{Event: EventRecovered, Provider: "work", Failures: 5}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.route.wants(tt.event); got != tt.want {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+// TestNotificationRouting tests that each target only gets the events
+// routed to it, after its failure threshold
+func TestNotificationRouting(t *testing.T) {
+	pager, chat := &eventRecorder{}, &eventRecorder{}
+	pagerServer, chatServer := httptest.NewServer(pager), httptest.NewServer(chat)
+	defer pagerServer.Close()
+	defer chatServer.Close()
+	config := NotificationsConfig{Webhooks: []WebhookNotificationConfig{
+		{URL: pagerServer.URL, NotificationRoute: NotificationRoute{Events: []string{EventUpdateFailed, EventRecovered}, FailureThreshold: 3}},
+		{URL: chatServer.URL, NotificationRoute: NotificationRoute{Events: []string{EventIPChange}}},
+	}}
+	n, err := newNotifications(config, http.DefaultClient, slog.New(slog.NewJSONHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	updater := &DDNSUpdater{notifications: n}
+	h := &providerHandle{name: "dreamhost"}
+
+	// Two failures and a recovery never reach the pager
+	updater.notifyOutcome(h, errors.New("boom"))
+	updater.notifyOutcome(h, errors.New("boom"))
+	updater.notifyOutcome(h, nil)
+	n.wait()
+	if events := pager.received(); len(events) != 0 {
+		t.Fatalf("expected no page below the threshold, got %+v", events)
+	}
+
+	for range 4 {
+		updater.notifyOutcome(h, errors.New("boom"))
+	}
+	n.wait()
+	updater.notifyOutcome(h, nil)
+	n.wait()
+	events := pager.received()
+	if len(events) != 2 || events[0].Event != EventUpdateFailed || events[0].Failures != 3 || events[1].Event != EventRecovered || events[1].Failures != 4 {
+		t.Errorf("expected a page on the third failure and the recovery, got %+v", events)
+	}
+	if !strings.Contains(events[0].Summary, "3 times in a row") {
+		t.Errorf("expected the summary to count the failures, got %q", events[0].Summary)
+	}
+	if events := chat.received(); len(events) != 0 {
+		t.Errorf("expected failures not to reach the chat, got %+v", events)
+	}
+}
+
+// TestSuppressDuplicates tests that an event repeating one sent within
+// suppress_duplicates is dropped
+func TestSuppressDuplicates(t *testing.T) {
+	n, recorder := newTestNotifications(t, notificationEvents...)
+	n.targets[0].route.SuppressDuplicates = time.Hour
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	n.now = func() time.Time { return now }
+
+	failed := Event{Event: EventUpdateFailed, Provider: "dreamhost", Failures: 1, Errors: []string{"boom"}}
+	n.send(failed)
+	now = now.Add(10 * time.Minute)
+	n.send(failed)                                             // Suppressed
+	n.send(Event{Event: EventIPChange, NewIP: "203.0.113.42"}) // Different
+	now = now.Add(time.Hour)
+	n.send(failed) // The hour is up
+	n.wait()
+
+	var kinds []string
+	for _, e := range recorder.received() {
+		kinds = append(kinds, e.Event)
+	}
+	slices.Sort(kinds)
+	if want := []string{EventIPChange, EventUpdateFailed, EventUpdateFailed}; !slices.Equal(kinds, want) {
+		t.Errorf("expected %v, got %v", want, kinds)
+	}
+}
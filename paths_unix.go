@@ -0,0 +1,22 @@
+//go:build !windows
+
+package main
+
+// Default configuration and state file paths
+const (
+	DefaultConfigPath = "/etc/dh-ddns-updater/config.yaml"
+	DefaultStatePath  = "/var/lib/dh-ddns-updater/state.json"
+
+	// DefaultSQLiteStatePath is where the SQLite state backend keeps its
+	// database unless state_path names another file.
+	DefaultSQLiteStatePath = "/var/lib/dh-ddns-updater/state.db"
+
+	// DefaultConfigCacheDir holds the last good copy of remote configs
+	// unless --config-cache-dir names another directory.
+	DefaultConfigCacheDir = "/var/lib/dh-ddns-updater/config-cache"
+
+	// DefaultAgeKeyFile is the age identity used to decrypt SOPS-encrypted
+	// configs and age-encrypted secret files unless --age-key-file or
+	// SOPS_AGE_KEY_FILE names another.
+	DefaultAgeKeyFile = "/etc/dh-ddns-updater/age.key"
+)
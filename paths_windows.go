@@ -0,0 +1,20 @@
+package main
+
+import (
+	"cmp"
+	"os"
+	"path/filepath"
+)
+
+// programDataDir is the daemon's directory under ProgramData, which holds
+// its config and state as /etc and /var/lib do elsewhere.
+var programDataDir = filepath.Join(cmp.Or(os.Getenv("ProgramData"), `C:\ProgramData`), "dh-ddns-updater")
+
+// Default configuration and state file paths; see paths_unix.go.
+var (
+	DefaultConfigPath      = filepath.Join(programDataDir, "config.yaml")
+	DefaultStatePath       = filepath.Join(programDataDir, "state.json")
+	DefaultSQLiteStatePath = filepath.Join(programDataDir, "state.db")
+	DefaultConfigCacheDir  = filepath.Join(programDataDir, "config-cache")
+	DefaultAgeKeyFile      = filepath.Join(programDataDir, "age.key")
+)
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"strings"
+)
+
+// writePIDFile writes the daemon's process ID to path, for init scripts
+// and monitoring tools that supervise it by PID. The file is replaced
+// whole, so a reader never sees it half-written; one left behind by a
+// daemon that didn't exit cleanly is overwritten.
+func writePIDFile(path string) error {
+	tmp := path + ".new"
+	if err := os.WriteFile(tmp, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// removePIDFile removes the PID file at path if it still holds the
+// daemon's process ID, leaving one another instance has since written.
+func removePIDFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(string(data)) != strconv.Itoa(os.Getpid()) {
+		return nil
+	}
+	return os.Remove(path)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+// TestPIDFile tests that the PID file holds the process ID, and is only
+// removed while it still does
+func TestPIDFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dh-ddns-updater.pid")
+	if err := os.WriteFile(path, []byte("1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := writePIDFile(path); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := strconv.Itoa(os.Getpid()) + "\n"; string(data) != want {
+		t.Errorf("expected %q, got %q", want, data)
+	}
+	if err := removePIDFile(path); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("expected the PID file to be removed")
+	}
+
+	// Another instance's
+	if err := os.WriteFile(path, []byte("1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := removePIDFile(path); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Error("expected another instance's PID file to be kept")
+	}
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// DefaultSource is the name under which the public IP, whether detected via
+// ipinfo.io or pushed to the webhook, is recorded in the desired-state store.
+const DefaultSource = "ipinfo"
+
+// IPv6Source holds the public IPv6 address, detected or pushed, when the
+// config is dual-stack: DefaultSource then holds the IPv4 address.
+const IPv6Source = "ipinfo6"
+
+// DesiredValue is a value produced by the detection stage for the
+// publication stage to reconcile DNS records against.
+type DesiredValue struct {
+	Value      string    // The desired record value (e.g., the public IP)
+	DetectedAt time.Time // When the value was last confirmed by detection
+	ChangedAt  time.Time // When the value last differed from the previous one
+}
+
+// DesiredStore connects the detection and publication stages. Detection
+// writes the values it observes; publication stages read them and are
+// notified through their subscriptions whenever any value differs from what
+// was stored before.
+type DesiredStore struct {
+	mu          sync.RWMutex
+	values      map[string]DesiredValue
+	subscribers []chan struct{}
+}
+
+// NewDesiredStore creates an empty desired-state store.
+func NewDesiredStore() *DesiredStore {
+	return &DesiredStore{
+		values: make(map[string]DesiredValue),
+	}
+}
+
+// Set records the value observed for a source. It returns true and notifies
+// every subscriber if the value differs from the one previously stored.
+func (s *DesiredStore) Set(source, value string) bool {
+	s.mu.Lock()
+	now := time.Now()
+	prev, ok := s.values[source]
+	changed := !ok || prev.Value != value
+	entry := DesiredValue{Value: value, DetectedAt: now, ChangedAt: prev.ChangedAt}
+	if changed {
+		entry.ChangedAt = now
+	}
+	s.values[source] = entry
+	subscribers := s.subscribers
+	s.mu.Unlock()
+
+	if changed {
+		for _, ch := range subscribers {
+			select {
+			case ch <- struct{}{}:
+			default: // A notification is already pending
+			}
+		}
+	}
+	return changed
+}
+
+// Get returns the desired value for a source, if detection has produced one.
+func (s *DesiredStore) Get(source string) (DesiredValue, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	v, ok := s.values[source]
+	return v, ok
+}
+
+// Subscribe returns a new channel that receives after any desired value
+// changes. Notifications coalesce while the subscriber is busy.
+func (s *DesiredStore) Subscribe() <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ch := make(chan struct{}, 1)
+	s.subscribers = append(s.subscribers, ch)
+	return ch
+}
+
+// StageMetrics summarizes the run history of a pipeline stage.
+type StageMetrics struct {
+	Runs                int64         `json:"runs"`
+	Failures            int64         `json:"failures"`
+	ConsecutiveFailures int           `json:"consecutive_failures"`
+	LastRun             time.Time     `json:"last_run"`
+	LastSuccess         time.Time     `json:"last_success"`
+	LastError           string        `json:"last_error,omitempty"`
+	LastDuration        time.Duration `json:"last_duration"`
+	NextRun             time.Time     `json:"next_run,omitzero"` // When the stage is next due; zero unless its loop is running
+}
+
+// Stage is an independently scheduled step of the update pipeline. It runs
+// every Interval, retries after RetryInterval when a run fails, backing off
+// up to MaxRetryInterval while failures continue, and can be woken early
+// through its trigger channel.
+type Stage struct {
+	Name             string
+	Interval         time.Duration
+	RetryInterval    time.Duration
+	MaxRetryInterval time.Duration      // Doubles the retry delay after each further failure up to this; 0 doesn't back off
+	Timeout          time.Duration      // Cancels a run that takes longer; 0 means no limit
+	RunOnStart       bool               // Run immediately instead of waiting for the first tick or trigger
+	Repeats          *errorRepeats      // Collapses a failure repeating every run in the logs; nil logs each one
+	Stats            *statsd            // Receives the stage's runs, failures and durations; nil sends none
+	Jitter           time.Duration      // Longest random delay added to the first run and each scheduled one
+	OnRun            func(StageMetrics) // Called with the metrics after each run; nil does nothing
+
+	run     func(ctx context.Context) error
+	trigger <-chan struct{}
+	runMu   sync.Mutex // Serializes runs of the loop with those of checkAndUpdate
+
+	mu      sync.Mutex
+	metrics StageMetrics
+}
+
+// NewStage creates a pipeline stage. trigger may be nil if the stage only
+// runs on its own schedule.
+func NewStage(name string, interval, retryInterval time.Duration, run func(ctx context.Context) error, trigger <-chan struct{}) *Stage {
+	return &Stage{
+		Name:          name,
+		Interval:      interval,
+		RetryInterval: retryInterval,
+		run:           run,
+		trigger:       trigger,
+	}
+}
+
+// Loop runs the stage until the context is cancelled.
+func (s *Stage) Loop(ctx context.Context, logger *slog.Logger) error {
+	first := s.Interval
+	if s.RunOnStart {
+		first = 0
+	}
+	if m := s.Metrics(); m.ConsecutiveFailures > 0 {
+		// Failures restored from before a restart; see Restore
+		first = max(time.Until(m.LastRun.Add(s.retryDelay(m.ConsecutiveFailures))), 0)
+	}
+	first += jitter(s.Jitter)
+	timer := time.NewTimer(first)
+	defer timer.Stop()
+	s.scheduled(first)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+		case <-s.trigger:
+			timer.Stop()
+		}
+
+		next := s.Interval
+		runCtx := withCycleID(ctx)
+		if err := s.Execute(runCtx); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			next = s.retryDelay(s.Metrics().ConsecutiveFailures)
+			if p := (*panicError)(nil); errors.As(err, &p) {
+				logger.ErrorContext(runCtx, "Pipeline stage panicked", "stage", s.Name, "panic", fmt.Sprint(p.value), "stack", string(p.stack))
+			}
+			s.Repeats.Error(runCtx, logger, "stage:"+s.Name, err.Error(), "Pipeline stage failed", "stage", s.Name, "error", err, "retry_in", next)
+		} else {
+			s.Repeats.Recovered(runCtx, logger, "stage:"+s.Name, "Pipeline stage recovered", "stage", s.Name)
+		}
+		next += jitter(s.Jitter)
+		timer.Reset(next)
+		s.scheduled(next)
+	}
+}
+
+// retryDelay returns how long the stage waits to run again after its
+// failures-th failure in a row: RetryInterval if shorter than Interval,
+// doubled for every failure after the first up to MaxRetryInterval.
+func (s *Stage) retryDelay(failures int) time.Duration {
+	delay := s.Interval
+	if s.RetryInterval > 0 && s.RetryInterval < delay {
+		delay = s.RetryInterval
+	}
+	for range failures - 1 {
+		if delay >= s.MaxRetryInterval {
+			break
+		}
+		delay = min(2*delay, s.MaxRetryInterval)
+	}
+	return delay
+}
+
+// Restore records failures in a row up to one at lastFailure, run before a
+// restart, so that Loop keeps backing off instead of retrying at once.
+func (s *Stage) Restore(failures int, lastFailure time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.metrics.ConsecutiveFailures = failures
+	s.metrics.LastRun = lastFailure
+}
+
+// panicError is a run of a stage that panicked, such as on an API response
+// the code didn't expect.
+type panicError struct {
+	value any
+	stack []byte // Of the goroutine that panicked
+}
+
+func (e *panicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.value)
+}
+
+// recoverPanic calls fn, turning a panic in it into a panicError.
+func recoverPanic(fn func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &panicError{value: r, stack: debug.Stack()}
+		}
+	}()
+	return fn()
+}
+
+// jitter returns a random delay shorter than max, or 0 if max isn't
+// positive.
+func jitter(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	return rand.N(max)
+}
+
+// scheduled records that the stage's next run is after d.
+func (s *Stage) scheduled(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.metrics.NextRun = time.Now().Add(d)
+}
+
+// Execute runs the stage once and records the outcome in its metrics. The
+// run's context is cancelled after Timeout so a hung request cannot stall
+// the stage indefinitely. A panic fails the run with a panicError instead of
+// killing the daemon. Runs of a stage never overlap: one started while
+// another is in progress waits for it. The run is a cycle of its own unless
+// ctx is in one already; see withCycleID.
+func (s *Stage) Execute(ctx context.Context) error {
+	s.runMu.Lock()
+	defer s.runMu.Unlock()
+	ctx = withCycleID(ctx)
+	if s.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.Timeout)
+		defer cancel()
+	}
+
+	start := time.Now()
+	err := recoverPanic(func() error { return s.run(ctx) })
+	s.emit(time.Since(start), err)
+	m := s.record(start, err)
+	if s.OnRun != nil {
+		s.OnRun(m)
+	}
+	return err
+}
+
+// record adds a run that started at start to the metrics and returns them.
+func (s *Stage) record(start time.Time, err error) StageMetrics {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.metrics.Runs++
+	s.metrics.LastRun = start
+	s.metrics.LastDuration = time.Since(start)
+	if err != nil {
+		s.metrics.Failures++
+		s.metrics.ConsecutiveFailures++
+		s.metrics.LastError = err.Error()
+	} else {
+		s.metrics.ConsecutiveFailures = 0
+		s.metrics.LastSuccess = start
+		s.metrics.LastError = ""
+	}
+	return s.metrics
+}
+
+// emit sends the outcome of a run to StatsD.
+func (s *Stage) emit(d time.Duration, err error) {
+	s.Stats.count("stage.runs", 1, "stage", s.Name)
+	if err != nil {
+		s.Stats.count("stage.failures", 1, "stage", s.Name)
+	}
+	s.Stats.timing("stage.duration", d, "stage", s.Name)
+}
+
+// Metrics returns a snapshot of the stage's run history.
+func (s *Stage) Metrics() StageMetrics {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.metrics
+}
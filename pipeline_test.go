@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestDesiredStore tests that only changed values signal the publication stage
+func TestDesiredStore(t *testing.T) {
+	store := NewDesiredStore()
+	changed := store.Subscribe()
+
+	if _, ok := store.Get(DefaultSource); ok {
+		t.Fatal("expected empty store")
+	}
+
+	if !store.Set(DefaultSource, "203.0.113.42") {
+		t.Error("expected first value to be reported as changed")
+	}
+
+	select {
+	case <-changed:
+	default:
+		t.Error("expected change notification after first value")
+	}
+
+	if store.Set(DefaultSource, "203.0.113.42") {
+		t.Error("expected identical value to be reported as unchanged")
+	}
+
+	select {
+	case <-changed:
+		t.Error("unexpected change notification for identical value")
+	default:
+	}
+
+	store.Set(DefaultSource, "203.0.113.99")
+	v, ok := store.Get(DefaultSource)
+	if !ok || v.Value != "203.0.113.99" {
+		t.Errorf("expected desired value 203.0.113.99, got %+v", v)
+	}
+	if v.ChangedAt.IsZero() || v.DetectedAt.Before(v.ChangedAt) {
+		t.Errorf("unexpected timestamps: %+v", v)
+	}
+}
+
+// TestStageExecuteMetrics tests that stage runs are reflected in its metrics
+func TestStageExecuteMetrics(t *testing.T) {
+	fail := true
+	stage := NewStage("test", time.Minute, time.Second, func(ctx context.Context) error {
+		if fail {
+			return errors.New("boom")
+		}
+		return nil
+	}, nil)
+
+	stage.Execute(context.Background())
+	stage.Execute(context.Background())
+
+	m := stage.Metrics()
+	if m.Runs != 2 || m.Failures != 2 || m.ConsecutiveFailures != 2 {
+		t.Errorf("unexpected metrics after failures: %+v", m)
+	}
+	if m.LastError != "boom" {
+		t.Errorf("expected last error 'boom', got %q", m.LastError)
+	}
+
+	fail = false
+	stage.Execute(context.Background())
+
+	m = stage.Metrics()
+	if m.Runs != 3 || m.Failures != 2 || m.ConsecutiveFailures != 0 {
+		t.Errorf("unexpected metrics after success: %+v", m)
+	}
+	if m.LastError != "" || m.LastSuccess.IsZero() {
+		t.Errorf("expected success to clear error and set LastSuccess: %+v", m)
+	}
+}
+
+// TestStageLoopRecoversPanic tests that a panicking run is logged with its
+// stack and counted as a failure, and that the stage runs again
+func TestStageLoopRecoversPanic(t *testing.T) {
+	var runs atomic.Int32
+	recovered := make(chan struct{})
+	stage := NewStage("test", time.Hour, 10*time.Millisecond, func(ctx context.Context) error {
+		if runs.Add(1) == 1 {
+			var payload map[string]any
+			_ = payload["data"].([]any) // An unexpected API response
+		}
+		close(recovered)
+		return nil
+	}, nil)
+	stage.RunOnStart = true
+	stage.MaxRetryInterval = time.Second
+
+	var logs bytes.Buffer
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- stage.Loop(ctx, slog.New(slog.NewJSONHandler(&logs, nil)))
+	}()
+	select {
+	case <-recovered:
+	case <-time.After(2 * time.Second):
+		t.Fatal("stage did not run again after panicking")
+	}
+	cancel()
+	<-done
+
+	if m := stage.Metrics(); m.Failures != 1 || m.Runs != 2 {
+		t.Errorf("expected the panic counted as a failure, got %+v", m)
+	}
+	out := logs.String()
+	if !strings.Contains(out, "Pipeline stage panicked") || !strings.Contains(out, "TestStageLoopRecoversPanic") {
+		t.Errorf("expected the panic logged with its stack, got %s", out)
+	}
+}
+
+// TestStageExecuteRecoversPanic tests that a panic fails the run with a panicError
+func TestStageExecuteRecoversPanic(t *testing.T) {
+	stage := NewStage("test", time.Hour, time.Hour, func(ctx context.Context) error {
+		panic("boom")
+	}, nil)
+
+	err := stage.Execute(context.Background())
+	var p *panicError
+	if !errors.As(err, &p) || p.value != "boom" || len(p.stack) == 0 {
+		t.Fatalf("expected a panicError with a stack, got %v", err)
+	}
+	if m := stage.Metrics(); m.Failures != 1 || m.LastError != "panic: boom" {
+		t.Errorf("expected the panic recorded as a failure, got %+v", m)
+	}
+}
+
+// TestStageLoopTrigger tests that a trigger wakes a stage before its interval elapses
+func TestStageLoopTrigger(t *testing.T) {
+	var runs atomic.Int32
+	trigger := make(chan struct{}, 1)
+	stage := NewStage("test", time.Hour, time.Hour, func(ctx context.Context) error {
+		runs.Add(1)
+		return nil
+	}, trigger)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- stage.Loop(ctx, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	}()
+
+	trigger <- struct{}{}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for runs.Load() == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+
+	if runs.Load() != 1 {
+		t.Errorf("expected 1 triggered run, got %d", runs.Load())
+	}
+}
+
+// TestStageLoopRunOnStart tests that RunOnStart runs the stage without waiting for the interval
+func TestStageLoopRunOnStart(t *testing.T) {
+	ran := make(chan struct{}, 1)
+	stage := NewStage("test", time.Hour, time.Hour, func(ctx context.Context) error {
+		ran <- struct{}{}
+		return nil
+	}, nil)
+	stage.RunOnStart = true
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go stage.Loop(ctx, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	select {
+	case <-ran:
+	case <-time.After(2 * time.Second):
+		t.Fatal("stage did not run on start")
+	}
+}
+
+// TestStageExecuteTimeout tests that a run exceeding Timeout is cancelled and recorded as a failure
+func TestStageExecuteTimeout(t *testing.T) {
+	stage := NewStage("test", time.Hour, time.Hour, func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}, nil)
+	stage.Timeout = 10 * time.Millisecond
+
+	if err := stage.Execute(context.Background()); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected context.DeadlineExceeded, got %v", err)
+	}
+	if m := stage.Metrics(); m.Failures != 1 {
+		t.Errorf("expected the timeout to count as a failure: %+v", m)
+	}
+}
+
+// TestStageLoopJitter tests that Jitter delays a stage's scheduled runs by
+// no more than the jitter
+func TestStageLoopJitter(t *testing.T) {
+	for range 100 {
+		if d := jitter(time.Second); d < 0 || d >= time.Second {
+			t.Fatalf("expected a jitter in [0, 1s), got %v", d)
+		}
+	}
+	if d := jitter(0); d != 0 {
+		t.Errorf("expected no jitter, got %v", d)
+	}
+
+	stage := NewStage("test", time.Hour, time.Hour, func(ctx context.Context) error { return nil }, nil)
+	stage.Jitter = time.Minute
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	start := time.Now()
+	go func() {
+		done <- stage.Loop(ctx, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	}()
+	deadline := time.Now().Add(2 * time.Second)
+	for stage.Metrics().NextRun.IsZero() && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	if next := stage.Metrics().NextRun.Sub(start); next < time.Hour || next > time.Hour+time.Minute+time.Second {
+		t.Errorf("expected the first run within the jitter after the interval, got %v", next)
+	}
+}
+
+// TestStageRetryDelay tests the retry delay doubling with each failure in
+// a row up to MaxRetryInterval, and never exceeding Interval without it
+func TestStageRetryDelay(t *testing.T) {
+	tests := []struct {
+		retry, max time.Duration
+		failures   int
+		want       time.Duration
+	}{
+		{time.Minute, 0, 1, time.Minute},
+		{time.Minute, 0, 5, time.Minute},
+		{time.Minute, time.Hour, 1, time.Minute},
+		{time.Minute, time.Hour, 2, 2 * time.Minute},
+		{time.Minute, time.Hour, 4, 8 * time.Minute},
+		{time.Minute, time.Hour, 7, time.Hour},
+		{time.Minute, time.Hour, 1000, time.Hour},
+		{time.Hour, 0, 1, 5 * time.Minute},
+		{0, 0, 3, 5 * time.Minute},
+	}
+	for _, tt := range tests {
+		stage := NewStage("test", 5*time.Minute, tt.retry, nil, nil)
+		stage.MaxRetryInterval = tt.max
+		if got := stage.retryDelay(tt.failures); got != tt.want {
+			t.Errorf("retry %v, max %v, %d failures: expected %v, got %v", tt.retry, tt.max, tt.failures, tt.want, got)
+		}
+	}
+}
@@ -0,0 +1,643 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"time"
+)
+
+// ActionKind describes what applying an action will do to a record.
+type ActionKind string
+
+const (
+	ActionNoop   ActionKind = "noop"   // Record already holds the desired value
+	ActionCreate ActionKind = "create" // Record does not exist yet
+	ActionUpdate ActionKind = "update" // Record exists (or could not be read) with another value
+	ActionSkip   ActionKind = "skip"   // Record cannot be reconciled; see Reason
+)
+
+// Action is a single planned change to one DNS record.
+type Action struct {
+	Kind    ActionKind   `json:"kind"`
+	Record  string       `json:"record"`            // Fully qualified record name
+	Type    string       `json:"type"`              // Record type
+	Current string       `json:"current,omitempty"` // Live value, empty if absent or unknown
+	Desired string       `json:"desired,omitempty"` // Value the record should hold
+	Reason  string       `json:"reason,omitempty"`  // Why the action was chosen, for skips and blind updates
+	Domain  DomainConfig `json:"-"`
+}
+
+// IsChange reports whether applying the action mutates DNS.
+func (a Action) IsChange() bool {
+	return a.Kind == ActionCreate || a.Kind == ActionUpdate
+}
+
+// Plan is the set of actions needed to bring every configured record to its
+// desired value. Computing a plan never mutates DNS or state.
+type Plan struct {
+	CreatedAt time.Time `json:"created_at"`
+	IP        string    `json:"ip,omitempty"` // Detected public IP the plan was computed from
+	Actions   []Action  `json:"actions"`
+}
+
+// Changes returns the actions that would mutate DNS.
+func (p *Plan) Changes() []Action {
+	var changes []Action
+	for _, a := range p.Actions {
+		if a.IsChange() {
+			changes = append(changes, a)
+		}
+	}
+	return changes
+}
+
+// sameChanges reports whether p and other hold the same changes, in any
+// order.
+func (p *Plan) sameChanges(other *Plan) bool {
+	byRecord := func(a, b Action) int {
+		return cmp.Or(cmp.Compare(a.Record, b.Record), cmp.Compare(a.Type, b.Type), cmp.Compare(a.Desired, b.Desired))
+	}
+	ours, theirs := p.Changes(), other.Changes()
+	slices.SortFunc(ours, byRecord)
+	slices.SortFunc(theirs, byRecord)
+	return slices.EqualFunc(ours, theirs, func(a, b Action) bool {
+		return a.Kind == b.Kind && a.Record == b.Record && a.Type == b.Type && a.Current == b.Current && a.Desired == b.Desired
+	})
+}
+
+// Print writes a human-readable summary of the plan, one line per action.
+func (p *Plan) Print(w io.Writer) {
+	symbols := map[ActionKind]string{
+		ActionNoop:   "=",
+		ActionCreate: "+",
+		ActionUpdate: "~",
+		ActionSkip:   "!",
+	}
+
+	for _, a := range p.Actions {
+		line := fmt.Sprintf("%s %-6s %s %s", symbols[a.Kind], a.Kind, a.Record, a.Type)
+		switch a.Kind {
+		case ActionNoop, ActionCreate:
+			line += " " + a.Desired
+		case ActionUpdate:
+			current := a.Current
+			if current == "" {
+				current = "?"
+			}
+			line += fmt.Sprintf(" %s -> %s", current, a.Desired)
+		}
+		if a.Reason != "" {
+			line += fmt.Sprintf(" (%s)", a.Reason)
+		}
+		fmt.Fprintln(w, line)
+	}
+
+	fmt.Fprintf(w, "%d to change, %d up to date, %d skipped\n",
+		len(p.Changes()), p.count(ActionNoop), p.count(ActionSkip))
+}
+
+func (p *Plan) count(kind ActionKind) int {
+	n := 0
+	for _, a := range p.Actions {
+		if a.Kind == kind {
+			n++
+		}
+	}
+	return n
+}
+
+// Post-update verification re-reads a record up to verifyAttempts times,
+// verifyDelay apart, or longer apart for records with a TTL; see
+// verifyWait.
+const verifyAttempts = 3
+
+// maxVerifyDelay caps the wait between verification attempts.
+const maxVerifyDelay = 30 * time.Second
+
+var verifyDelay = 2 * time.Second
+
+// ApplyPolicy controls how a plan is executed.
+type ApplyPolicy struct {
+	DryRun          bool // Log changes without making them
+	RequireApproval bool // Hold changes as a pending plan until approved via `apply`
+	Rollback        bool // On the first failure, revert changes already made in this apply
+}
+
+// applyPolicy returns the policy configured for the publication stage.
+func (d *DDNSUpdater) applyPolicy() ApplyPolicy {
+	return ApplyPolicy{
+		DryRun:          d.config.DryRun,
+		RequireApproval: d.config.RequireApproval,
+		Rollback:        d.config.RollbackOnFailure,
+	}
+}
+
+// planAll computes a plan covering the records of every provider.
+func (d *DDNSUpdater) planAll(ctx context.Context) (*Plan, error) {
+	return d.plan(ctx, d.config.Domains)
+}
+
+// plan computes the actions needed to reconcile the given records against
+// their desired values. In order of precedence, a record's desired value
+// comes from the schedule entry in effect, the value last pushed for it
+// through an inbound protocol, or its configured value given the most
+// recently detected IP.
+func (d *DDNSUpdater) plan(ctx context.Context, domains []DomainConfig) (*Plan, error) {
+	desired, _ := d.desired.Get(DefaultSource)
+	plan := &Plan{CreatedAt: time.Now(), IP: desired.Value}
+
+	// Each provider's records are listed at most once per plan, as listing
+	// all of them is a single API call whether one record or all are needed
+	listings := make(map[string]recordListing)
+	list := func(provider string) recordListing {
+		listing, ok := listings[provider]
+		if !ok {
+			var names []DomainConfig
+			for _, domain := range domains {
+				if domain.Provider == provider {
+					names = append(names, domain)
+				}
+			}
+			listing.records, listing.err = d.providers[provider].ListRecords(ctx, names)
+			listings[provider] = listing
+		}
+		return listing
+	}
+
+	d.progress.Start("list")
+	for _, domain := range domains {
+		action := Action{Record: domain.FQDN(), Type: domain.Type, Domain: domain}
+		d.progress.Update(action.Record)
+
+		ip := desired.Value
+		if domain.Type == "AAAA" && d.config.dualStack() {
+			detected, _ := d.desired.Get(IPv6Source)
+			ip = detected.Value
+		}
+		if pushed, ok := d.desired.Get(recordSource(domain.FQDN(), domain.Type)); ok {
+			ip = pushed.Value
+		}
+		source := domain
+		if scheduled, ok := d.desired.Get(scheduleSource(domain.FQDN(), domain.Type)); ok && scheduled.Value != "" {
+			source.Value = scheduled.Value
+		}
+
+		value, err := desiredRecordValue(source, ip)
+		if err != nil {
+			action.Kind = ActionSkip
+			action.Reason = fmt.Sprintf("computing desired value: %v", err)
+			plan.Actions = append(plan.Actions, action)
+			continue
+		}
+		action.Desired = value
+		if isAddressType(domain.Type) && !ipMatchesType(value, domain.Type) {
+			// Such as an IPv6 address pushed or detected for an A record
+			action.Kind = ActionSkip
+			action.Reason = fmt.Sprintf("desired value %q is not an %s address", value, map[string]string{"A": "IPv4", "AAAA": "IPv6"}[domain.Type])
+			plan.Actions = append(plan.Actions, action)
+			continue
+		}
+
+		// Check the current DNS record value, unless the record is known to
+		// be absent while its creation awaits approval
+		var existing *DNSRecord
+		var records []DNSRecord
+		written := d.recordState(action.Record, action.Type)
+		if !d.absent.Known(domain, value) {
+			listing := list(domain.Provider)
+			records, err = matchingRecords(domain, listing.records), listing.err
+		}
+		if err == nil {
+			existing = managedRecord(domain, records, value, written)
+		}
+		switch {
+		case err != nil:
+			if ctx.Err() != nil {
+				d.progress.Done(ctx.Err())
+				return nil, ctx.Err()
+			}
+			// Update blindly if we can't check
+			action.Kind = ActionUpdate
+			action.Reason = fmt.Sprintf("could not read current record: %v", err)
+		case existing == nil:
+			action.Kind = ActionCreate
+		case recordValuesEqual(domain.Type, existing.Value, value):
+			action.Kind = ActionNoop
+			action.Current = existing.Value
+		case !existing.Managed() && !adoptable(domain, existing, written) && !d.config.ForceOverwrite:
+			// Never clobber a record someone created by hand unless told to.
+			action.Kind = ActionSkip
+			action.Current = existing.Value
+			action.Reason = fmt.Sprintf("record not managed by dh-ddns-updater (comment %q)", existing.Comment)
+		default:
+			action.Kind = ActionUpdate
+			action.Current = existing.Value
+		}
+
+		plan.Actions = append(plan.Actions, action)
+	}
+	d.progress.Update(fmt.Sprintf("%d records", len(domains)))
+	d.progress.Start("plan")
+	d.progress.Update(fmt.Sprintf("%d changes", len(plan.Changes())))
+	d.progress.Done(nil)
+
+	return plan, nil
+}
+
+// apply executes a plan under the given policy and persists the outcome.
+// Returns an error if any record could not be brought up to date.
+func (d *DDNSUpdater) apply(ctx context.Context, plan *Plan, policy ApplyPolicy) error {
+	var updateErrors []error
+	var applied []Action
+	updatedAnyRecord := false
+
+	d.progress.Start("apply")
+	for _, a := range plan.Actions {
+		domain := a.Domain
+		provider := d.providers[domain.Provider]
+
+		switch a.Kind {
+		case ActionNoop:
+			d.logger.DebugContext(ctx, "DNS record already up to date",
+				"domain", domain.Name,
+				"record", domain.Record,
+				"value", a.Desired)
+			d.setRecordState(a.Record, a.Type, a.Desired)
+			d.setRecordStatus(a.Record, a.Type, nil, false)
+			d.repeats.Recovered(ctx, d.logger, recordRepeatKey(domain), "DNS record recovered",
+				"domain", domain.Name,
+				"record", domain.Record)
+			continue
+
+		case ActionSkip:
+			d.repeats.Error(ctx, d.logger, recordRepeatKey(domain), a.Reason, "Skipping DNS record",
+				"domain", domain.Name,
+				"record", domain.Record,
+				"current_value", a.Current,
+				"reason", a.Reason)
+			err := fmt.Errorf("%s: %s", a.Record, a.Reason)
+			updateErrors = append(updateErrors, err)
+			d.setRecordStatus(a.Record, a.Type, err, false)
+			continue
+		}
+
+		if policy.DryRun {
+			d.logger.InfoContext(ctx, "Dry run: would update DNS record",
+				"domain", domain.Name,
+				"record", domain.Record,
+				"old_value", a.Current,
+				"new_value", a.Desired)
+			continue
+		}
+
+		if ctx.Err() != nil {
+			// Stopping; the next run makes the updates not yet started
+			err := fmt.Errorf("%s: not updated: %w", a.Record, ctx.Err())
+			updateErrors = append(updateErrors, err)
+			d.setRecordStatus(a.Record, a.Type, err, false)
+			continue
+		}
+
+		if a.Reason != "" {
+			d.logger.WarnContext(ctx, "Updating DNS record without knowing its current value",
+				"domain", domain.Name,
+				"record", domain.Record,
+				"reason", a.Reason)
+		}
+
+		d.logger.InfoContext(ctx, "Updating DNS record",
+			"domain", domain.Name,
+			"record", domain.Record,
+			"provider", domain.Provider,
+			"old_value", a.Current,
+			"new_value", a.Desired,
+			"notes", domain.Notes)
+
+		// Without a live value, remove whatever we last wrote.
+		current := a.Current
+		if current == "" {
+			current = d.recordState(a.Record, a.Type)
+		}
+
+		d.progress.Update(a.Record)
+		err := d.updateRecord(ctx, provider, domain, current, a.Desired)
+		d.absent.Forget(domain)
+		switch {
+		case err == nil && ctx.Err() != nil:
+			d.logger.WarnContext(ctx, "Updated DNS record but stopping before verifying it",
+				"domain", domain.Name,
+				"record", domain.Record)
+		case err == nil:
+			d.progress.Update(a.Record + " (verifying)")
+			err = d.verifyRecord(ctx, provider, domain, a.Desired)
+		}
+		if err != nil {
+			d.repeats.Error(ctx, d.logger, recordRepeatKey(domain), err.Error(), "Failed to update DNS record",
+				"domain", domain.Name,
+				"record", domain.Record,
+				"category", errorCategory(err),
+				"error", err)
+			updateErrors = append(updateErrors, err)
+			d.setRecordStatus(a.Record, a.Type, err, false)
+			d.stats.count("records.failed", 1, "provider", domain.Provider, "type", domain.Type)
+
+			if policy.Rollback {
+				// The failed update may have removed the old record already.
+				rollbackCtx, cancel := withGrace(ctx, d.config.ShutdownGracePeriod)
+				d.rollback(rollbackCtx, append(applied, a))
+				cancel()
+				applied = nil
+				break
+			}
+			continue
+		}
+
+		d.logger.InfoContext(ctx, "Successfully updated DNS record",
+			"domain", domain.Name,
+			"record", domain.Record,
+			"value", a.Desired)
+		d.repeats.Recovered(ctx, d.logger, recordRepeatKey(domain), "DNS record recovered",
+			"domain", domain.Name,
+			"record", domain.Record)
+		d.setRecordState(a.Record, a.Type, a.Desired)
+		d.setRecordStatus(a.Record, a.Type, nil, true)
+		d.stats.count("records.updated", 1, "provider", domain.Provider, "type", domain.Type)
+		applied = append(applied, a)
+		updatedAnyRecord = true
+	}
+
+	d.progress.Update(fmt.Sprintf("%d updated, %d failed", len(applied), len(updateErrors)))
+	d.progress.Done(errors.Join(updateErrors...))
+
+	if policy.DryRun {
+		return errors.Join(updateErrors...)
+	}
+	d.notifyIPChange(plan, applied, updateErrors)
+
+	d.stateMu.Lock()
+	// Update state if we successfully processed everything
+	if len(updateErrors) == 0 {
+		if plan.IP != "" {
+			d.recordIPChange(plan.IP, len(applied), time.Now())
+			d.state.LastIP = plan.IP
+		}
+		if updatedAnyRecord {
+			d.state.LastUpdated = time.Now()
+		}
+	}
+
+	if len(updateErrors) == 0 || updatedAnyRecord {
+		if err := d.saveState(); err != nil {
+			d.logger.ErrorContext(ctx, "Failed to save state", "error", err)
+		}
+	}
+	d.stateMu.Unlock()
+
+	if len(updateErrors) > 0 {
+		return &applyError{errs: updateErrors}
+	}
+
+	return nil
+}
+
+// updateRecord updates a record through provider. Cancelling ctx doesn't
+// cut the update short, which could leave the record removed but not yet
+// re-added: it is given shutdown_grace_period more to finish.
+func (d *DDNSUpdater) updateRecord(ctx context.Context, provider Provider, domain DomainConfig, current, desired string) error {
+	updateCtx, cancel := withGrace(ctx, d.config.ShutdownGracePeriod)
+	defer cancel()
+	stop := context.AfterFunc(ctx, func() {
+		d.logger.InfoContext(ctx, "Cancelled; finishing the DNS record update in progress",
+			"domain", domain.Name,
+			"record", domain.Record,
+			"grace_period", d.config.ShutdownGracePeriod)
+	})
+	defer stop()
+	return provider.UpdateRecord(updateCtx, domain, current, desired)
+}
+
+// rollback restores records changed by an apply to the values they held
+// before it, newest first. Records that did not exist before are removed.
+func (d *DDNSUpdater) rollback(ctx context.Context, actions []Action) {
+	for i := len(actions) - 1; i >= 0; i-- {
+		a := actions[i]
+		domain := a.Domain
+		provider := d.providers[domain.Provider]
+
+		var err error
+		if a.Current == "" {
+			if a.Kind != ActionCreate {
+				d.logger.ErrorContext(ctx, "Cannot roll back DNS record with unknown previous value",
+					"domain", domain.Name,
+					"record", domain.Record)
+				continue
+			}
+			err = provider.RemoveRecord(ctx, domain, a.Desired)
+			d.setRecordState(a.Record, a.Type, "")
+		} else {
+			err = provider.UpdateRecord(ctx, domain, a.Desired, a.Current)
+			d.setRecordState(a.Record, a.Type, a.Current)
+		}
+
+		if err != nil {
+			d.logger.ErrorContext(ctx, "Failed to roll back DNS record",
+				"domain", domain.Name,
+				"record", domain.Record,
+				"error", err)
+			continue
+		}
+		d.logger.InfoContext(ctx, "Rolled back DNS record",
+			"domain", domain.Name,
+			"record", domain.Record,
+			"value", a.Current)
+	}
+}
+
+// verifyWait returns how long to wait between verification attempts for
+// a record with the given TTL. Providers that honor TTLs typically serve
+// changes through caches and secondaries bound by the TTL, so a record with
+// a longer TTL is given longer to reflect an update: a tenth of the TTL,
+// between verifyDelay and maxVerifyDelay.
+func verifyWait(ttl int) time.Duration {
+	wait := time.Duration(ttl) * time.Second / 10
+	return min(max(wait, verifyDelay), maxVerifyDelay)
+}
+
+// recordListing is the result of listing a provider's records for a plan.
+type recordListing struct {
+	records []DNSRecord
+	err     error
+}
+
+// matchingRecords returns the records with the domain's name and type.
+func matchingRecords(domain DomainConfig, records []DNSRecord) []DNSRecord {
+	var matches []DNSRecord
+	for _, record := range records {
+		if record.Record == domain.FQDN() && record.Type == domain.Type {
+			matches = append(matches, record)
+		}
+	}
+	return matches
+}
+
+// verifyRecord re-reads a record after an update and checks that exactly
+// one record with the desired value exists. Dreamhost can take a moment to
+// reflect changes, so a mismatch is retried a few times before failing.
+func (d *DDNSUpdater) verifyRecord(ctx context.Context, provider Provider, domain DomainConfig, value string) error {
+	var err error
+	wait := verifyWait(domain.TTL)
+	for attempt := 1; attempt <= verifyAttempts; attempt++ {
+		if attempt > 1 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(wait):
+			}
+		}
+
+		var records []DNSRecord
+		records, err = provider.GetRecords(ctx, domain)
+		if err == nil {
+			err = checkVerifiedRecords(domain, records, value)
+		}
+		if err == nil {
+			return nil
+		}
+		d.logger.DebugContext(ctx, "DNS record not verified yet",
+			"domain", domain.Name,
+			"record", domain.Record,
+			"attempt", attempt,
+			"error", err)
+	}
+	return fmt.Errorf("verifying update: %w", err)
+}
+
+// managedRecord returns the record among records, those found at domain's
+// name and type, that domain configures, or nil if there is none yet. A
+// name holds one record of most types, and that is it. Of the types that
+// can hold several (see isMultiValueType) it is the one holding value or
+// the value last written to it, or else the one tagged as managed; the
+// others belong to someone else and are left alone, the record being
+// created beside them.
+func managedRecord(domain DomainConfig, records []DNSRecord, value, written string) *DNSRecord {
+	if !isMultiValueType(domain.Type) {
+		if len(records) == 0 {
+			return nil
+		}
+		return &records[0]
+	}
+	for _, match := range []func(r *DNSRecord) bool{
+		func(r *DNSRecord) bool { return value != "" && recordValuesEqual(domain.Type, r.Value, value) },
+		func(r *DNSRecord) bool { return written != "" && recordValuesEqual(domain.Type, r.Value, written) },
+		(*DNSRecord).Managed,
+	} {
+		for i := range records {
+			if match(&records[i]) {
+				return &records[i]
+			}
+		}
+	}
+	return nil
+}
+
+// adoptable reports whether existing, though untagged, holds the value the
+// state says the daemon last wrote to it. Versions before records were
+// tagged left them without the managed comment; such a record is updated
+// as a managed one, which tags it.
+func adoptable(domain DomainConfig, existing *DNSRecord, written string) bool {
+	return written != "" && recordValuesEqual(domain.Type, existing.Value, written)
+}
+
+// checkVerifiedRecords checks the records found after an update: value
+// must be there, and be the only value at a name holding a single record
+// of the type.
+func checkVerifiedRecords(domain DomainConfig, records []DNSRecord, value string) error {
+	if isMultiValueType(domain.Type) {
+		for _, r := range records {
+			if recordValuesEqual(domain.Type, r.Value, value) {
+				return nil
+			}
+		}
+		return fmt.Errorf("no %s record for %s holds %q after update", domain.Type, domain.FQDN(), value)
+	}
+	switch {
+	case len(records) == 0:
+		return fmt.Errorf("record not found after update")
+	case len(records) > 1:
+		return fmt.Errorf("found %d %s records for %s, expected 1", len(records), domain.Type, domain.FQDN())
+	case !recordValuesEqual(domain.Type, records[0].Value, value):
+		return fmt.Errorf("record holds %q, expected %q", records[0].Value, value)
+	}
+	return nil
+}
+
+// recordState returns the value last written to the record with the given
+// name and type according to state.
+func (d *DDNSUpdater) recordState(record, recordType string) string {
+	d.stateMu.Lock()
+	defer d.stateMu.Unlock()
+	return d.state.Records[stateKey(record, recordType)]
+}
+
+// setRecordState records the value a record holds; an empty value forgets
+// it, along with its status.
+func (d *DDNSUpdater) setRecordState(record, recordType, value string) {
+	d.stateMu.Lock()
+	defer d.stateMu.Unlock()
+	key := stateKey(record, recordType)
+	if value == "" {
+		delete(d.state.Records, key)
+		delete(d.state.RecordStatus, key)
+		return
+	}
+	d.state.Records[key] = value
+}
+
+// setRecordStatus records the outcome of applying a record: err if it
+// failed, and otherwise that it was seen holding its desired value, having
+// been updated to it if updated is set.
+func (d *DDNSUpdater) setRecordStatus(record, recordType string, err error, updated bool) {
+	d.stateMu.Lock()
+	defer d.stateMu.Unlock()
+	if d.state.RecordStatus == nil {
+		d.state.RecordStatus = make(map[string]*RecordStatus)
+	}
+	key := stateKey(record, recordType)
+	status := d.state.RecordStatus[key]
+	if status == nil {
+		status = &RecordStatus{}
+		d.state.RecordStatus[key] = status
+	}
+
+	now := time.Now()
+	if err != nil {
+		status.LastFailure = now
+		status.ConsecutiveFailures++
+		status.LastError = err.Error()
+		return
+	}
+	if updated {
+		status.LastUpdated = now
+	}
+	status.LastVerified = now
+	status.ConsecutiveFailures = 0
+	status.LastError = ""
+}
+
+// applyError is the error of an apply that couldn't bring every record up
+// to date, holding the failure of each record.
+type applyError struct {
+	errs []error
+}
+
+func (e *applyError) Error() string {
+	return fmt.Sprintf("failed to update %d records", len(e.errs))
+}
+
+func (e *applyError) Unwrap() []error {
+	return e.errs
+}
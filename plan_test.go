@@ -0,0 +1,617 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeDreamhost is an in-memory stand-in for the Dreamhost DNS API.
+type fakeDreamhost struct {
+	mu          sync.Mutex
+	records     []DNSRecord
+	calls       map[string]int
+	failAdds    map[string]bool // Values whose dns-add_record calls fail
+	dropAdds    map[string]bool // Values whose dns-add_record calls succeed without adding anything
+	rateLimited map[string]bool // API keys whose calls are rejected as rate limited
+	badKeys     map[string]bool // API keys the API rejects as invalid
+}
+
+func newFakeDreamhost(records ...DNSRecord) *fakeDreamhost {
+	return &fakeDreamhost{
+		records:     records,
+		calls:       make(map[string]int),
+		failAdds:    make(map[string]bool),
+		dropAdds:    make(map[string]bool),
+		rateLimited: make(map[string]bool),
+		badKeys:     make(map[string]bool),
+	}
+}
+
+func (f *fakeDreamhost) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	q := r.URL.Query()
+	cmd := q.Get("cmd")
+	f.calls[cmd]++
+
+	if f.rateLimited[q.Get("key")] {
+		json.NewEncoder(w).Encode(DreamhostResponse{Result: "error", Data: "slow_down_bucko"})
+		return
+	}
+	if f.badKeys[q.Get("key")] {
+		json.NewEncoder(w).Encode(DreamhostResponse{Result: "error", Data: "invalid_api_key"})
+		return
+	}
+
+	switch cmd {
+	case "dns-list_records":
+		json.NewEncoder(w).Encode(map[string]any{"result": "success", "data": f.records})
+	case "dns-add_record":
+		if f.failAdds[q.Get("value")] {
+			json.NewEncoder(w).Encode(DreamhostResponse{Result: "error", Data: "internal_error"})
+			return
+		}
+		if f.dropAdds[q.Get("value")] {
+			json.NewEncoder(w).Encode(DreamhostResponse{Result: "success", Data: "record_added"})
+			return
+		}
+		f.records = append(f.records, DNSRecord{Record: q.Get("record"), Type: q.Get("type"), Value: q.Get("value"), Comment: q.Get("comment")})
+		json.NewEncoder(w).Encode(DreamhostResponse{Result: "success", Data: "record_added"})
+	case "dns-remove_record":
+		for i, rec := range f.records {
+			if rec.Record == q.Get("record") && rec.Type == q.Get("type") && rec.Value == q.Get("value") {
+				f.records = append(f.records[:i], f.records[i+1:]...)
+				json.NewEncoder(w).Encode(DreamhostResponse{Result: "success", Data: "record_removed"})
+				return
+			}
+		}
+		json.NewEncoder(w).Encode(DreamhostResponse{Result: "error", Data: "no_such_record"})
+	default:
+		http.Error(w, "unknown command", 400)
+	}
+}
+
+// value returns the value of the named record, or "" if it doesn't exist.
+func (f *fakeDreamhost) value(record, recordType string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, rec := range f.records {
+		if rec.Record == record && rec.Type == recordType {
+			return rec.Value
+		}
+	}
+	return ""
+}
+
+// newPlanTestUpdater creates an updater whose API calls go to the fake and
+// whose desired store already holds the given IP.
+func newPlanTestUpdater(t *testing.T, fake *fakeDreamhost, ip string, domains ...DomainConfig) *DDNSUpdater {
+	t.Helper()
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	d := &DDNSUpdater{
+		config: &Config{
+			DreamhostAPIKey:  "test-key",
+			DreamhostAPIBase: server.URL,
+			StatePath:        filepath.Join(t.TempDir(), "state.json"),
+			Domains:          domains,
+		},
+		state:      &State{Records: make(map[string]string)},
+		httpClient: &http.Client{Timeout: 5 * time.Second},
+		logger:     slog.New(slog.NewJSONHandler(io.Discard, nil)),
+		desired:    NewDesiredStore(),
+	}
+	for i := range d.config.Domains {
+		if d.config.Domains[i].Provider == "" {
+			d.config.Domains[i].Provider = DefaultProvider
+		}
+	}
+	providers, err := d.buildProviders()
+	if err != nil {
+		t.Fatalf("building providers: %v", err)
+	}
+	d.providers = providers
+	if ip != "" {
+		d.desired.Set(DefaultSource, ip)
+	}
+	return d
+}
+
+// TestPlan tests that each record state maps to the right action kind
+func TestPlan(t *testing.T) {
+	fake := newFakeDreamhost(
+		DNSRecord{Record: "same.example.com", Type: "A", Value: "203.0.113.42", Comment: ManagedComment},
+		DNSRecord{Record: "stale.example.com", Type: "A", Value: "198.51.100.1", Comment: ManagedComment},
+		DNSRecord{Record: "manual.example.com", Type: "A", Value: "198.51.100.1", Comment: "hand-made"},
+	)
+	updater := newPlanTestUpdater(t, fake, "203.0.113.42",
+		DomainConfig{Name: "example.com", Record: "same", Type: "A"},
+		DomainConfig{Name: "example.com", Record: "stale", Type: "A"},
+		DomainConfig{Name: "example.com", Record: "manual", Type: "A"},
+		DomainConfig{Name: "example.com", Record: "new", Type: "A"},
+	)
+
+	plan, err := updater.planAll(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []ActionKind{ActionNoop, ActionUpdate, ActionSkip, ActionCreate}
+	if len(plan.Actions) != len(expected) {
+		t.Fatalf("expected %d actions, got %d", len(expected), len(plan.Actions))
+	}
+	for i, kind := range expected {
+		if plan.Actions[i].Kind != kind {
+			t.Errorf("action %d (%s): expected %s, got %s", i, plan.Actions[i].Record, kind, plan.Actions[i].Kind)
+		}
+	}
+
+	if len(plan.Changes()) != 2 {
+		t.Errorf("expected 2 changes, got %d", len(plan.Changes()))
+	}
+	if fake.calls["dns-add_record"] != 0 || fake.calls["dns-remove_record"] != 0 {
+		t.Error("planning must not mutate DNS")
+	}
+
+	var out bytes.Buffer
+	plan.Print(&out)
+	if !strings.Contains(out.String(), "~ update stale.example.com A 198.51.100.1 -> 203.0.113.42") {
+		t.Errorf("unexpected plan output:\n%s", out.String())
+	}
+}
+
+// TestPlanAdoptsUntaggedRecords tests that an untagged record holding the
+// value last written to it is updated and tagged, while one holding any
+// other value is left alone
+func TestPlanAdoptsUntaggedRecords(t *testing.T) {
+	fake := newFakeDreamhost(
+		DNSRecord{Record: "legacy.example.com", Type: "A", Value: "198.51.100.1"},
+		DNSRecord{Record: "manual.example.com", Type: "A", Value: "198.51.100.9"},
+	)
+	updater := newPlanTestUpdater(t, fake, "203.0.113.42",
+		DomainConfig{Name: "example.com", Record: "legacy", Type: "A"},
+		DomainConfig{Name: "example.com", Record: "manual", Type: "A"},
+	)
+	updater.setRecordState("legacy.example.com", "A", "198.51.100.1")
+	updater.setRecordState("manual.example.com", "A", "198.51.100.1")
+
+	plan, err := updater.planAll(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a := plan.Actions[0]; a.Kind != ActionUpdate || a.Reason != "" {
+		t.Errorf("expected the record last written by the daemon adopted, got %s (%s)", a.Kind, a.Reason)
+	}
+	if a := plan.Actions[1]; a.Kind != ActionSkip {
+		t.Errorf("expected the record holding another value skipped, got %s", a.Kind)
+	}
+
+	updater.apply(context.Background(), plan, ApplyPolicy{})
+	adopted := func(r DNSRecord) bool {
+		return r.Record == "legacy.example.com" && r.Value == "203.0.113.42" && r.Managed()
+	}
+	if !slices.ContainsFunc(fake.records, adopted) {
+		t.Errorf("expected the adopted record updated and tagged, got %+v", fake.records)
+	}
+}
+
+// TestPlanAddressFamilies tests that a dual-stack config publishes each
+// family's address to its records, and that an address of the other family
+// is never published
+func TestPlanAddressFamilies(t *testing.T) {
+	fake := newFakeDreamhost()
+	updater := newPlanTestUpdater(t, fake, "203.0.113.42",
+		DomainConfig{Name: "example.com", Record: "home", Type: "A"},
+		DomainConfig{Name: "example.com", Record: "home", Type: "AAAA"},
+		DomainConfig{Name: "example.com", Record: "pushed", Type: "A"},
+	)
+	updater.setPublicIP(context.Background(), "2001:db8::42", ipSourceName)
+	updater.desired.Set(recordSource("pushed.example.com", "A"), "2001:db8::7")
+
+	plan, err := updater.planAll(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		kind    ActionKind
+		desired string
+	}{
+		{ActionCreate, "203.0.113.42"},
+		{ActionCreate, "2001:db8::42"},
+		{ActionSkip, "2001:db8::7"},
+	}
+	for i, w := range want {
+		if a := plan.Actions[i]; a.Kind != w.kind || a.Desired != w.desired {
+			t.Errorf("%s %s: expected %s to %q, got %s to %q (%s)", a.Record, a.Type, w.kind, w.desired, a.Kind, a.Desired, a.Reason)
+		}
+	}
+	if got, _ := updater.desired.Get(DefaultSource); got.Value != "203.0.113.42" {
+		t.Errorf("expected the IPv4 address kept beside the IPv6 one, got %q", got.Value)
+	}
+}
+
+// TestPlanPinnedRecords tests that records pinned to an address are reconciled even before an IP is detected
+func TestPlanPinnedRecords(t *testing.T) {
+	fake := newFakeDreamhost(
+		DNSRecord{Record: "nas.example.com", Type: "A", Value: "198.51.100.1", Comment: ManagedComment},
+	)
+	updater := newPlanTestUpdater(t, fake, "",
+		DomainConfig{Name: "example.com", Record: "nas", Type: "A", Value: "198.51.100.7"},
+		DomainConfig{Name: "example.com", Record: "home", Type: "A"},
+	)
+
+	plan, err := updater.planAll(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if a := plan.Actions[0]; a.Kind != ActionUpdate || a.Desired != "198.51.100.7" {
+		t.Errorf("expected the pinned record updated to 198.51.100.7, got %s to %q", a.Kind, a.Desired)
+	}
+	if a := plan.Actions[1]; a.Kind != ActionSkip {
+		t.Errorf("expected the record following the IP skipped, got %s", a.Kind)
+	}
+}
+
+// TestPlanMultiValueRecords tests that a TXT record is reconciled beside
+// other values at its name, which are left alone even with force_overwrite
+func TestPlanMultiValueRecords(t *testing.T) {
+	spf := DNSRecord{Record: "example.com", Type: "TXT", Value: "v=spf1 mx -all", Comment: "hand-made"}
+	fake := newFakeDreamhost(
+		spf,
+		DNSRecord{Record: "home.example.com", Type: "TXT", Value: "v=spf1 -all"},
+		DNSRecord{Record: "home.example.com", Type: "TXT", Value: "ip=198.51.100.1", Comment: ManagedComment},
+	)
+	updater := newPlanTestUpdater(t, fake, "203.0.113.42",
+		DomainConfig{Name: "example.com", Type: "TXT", Value: "site-verification=abc"},
+		DomainConfig{Name: "example.com", Record: "home", Type: "TXT", Value: "ip={{.IP}}"},
+	)
+	updater.config.ForceOverwrite = true
+
+	plan, err := updater.planAll(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a := plan.Actions[0]; a.Kind != ActionCreate || a.Current != "" {
+		t.Errorf("expected the verification record created beside the SPF one, got %s from %q", a.Kind, a.Current)
+	}
+	if a := plan.Actions[1]; a.Kind != ActionUpdate || a.Current != "ip=198.51.100.1" {
+		t.Errorf("expected the managed record updated, got %s from %q", a.Kind, a.Current)
+	}
+
+	if err := updater.apply(context.Background(), plan, ApplyPolicy{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := map[string]bool{
+		"example.com v=spf1 mx -all": true, "example.com site-verification=abc": true,
+		"home.example.com v=spf1 -all": true, "home.example.com ip=203.0.113.42": true,
+	}
+	got := make(map[string]bool)
+	for _, r := range fake.records {
+		got[r.Record+" "+r.Value] = true
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("expected records %v, got %v", want, got)
+	}
+
+	plan, err = updater.planAll(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Changes()) != 0 {
+		t.Errorf("expected no changes once applied, got %+v", plan.Changes())
+	}
+}
+
+// TestApplyDryRun tests that a dry run leaves DNS and state untouched
+func TestApplyDryRun(t *testing.T) {
+	fake := newFakeDreamhost()
+	updater := newPlanTestUpdater(t, fake, "203.0.113.42", DomainConfig{Name: "example.com", Record: "home", Type: "A"})
+
+	plan, err := updater.planAll(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := updater.apply(context.Background(), plan, ApplyPolicy{DryRun: true}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if fake.calls["dns-add_record"] != 0 {
+		t.Errorf("dry run made %d add calls", fake.calls["dns-add_record"])
+	}
+	if updater.state.LastIP != "" || len(updater.state.Records) != 0 {
+		t.Errorf("dry run modified state: %+v", updater.state)
+	}
+}
+
+// TestApplyRollback tests that a failure reverts changes made earlier in the same apply
+func TestApplyRollback(t *testing.T) {
+	fake := newFakeDreamhost(
+		DNSRecord{Record: "a.example.com", Type: "TXT", Value: "old-a", Comment: ManagedComment},
+		DNSRecord{Record: "b.example.com", Type: "TXT", Value: "old-b", Comment: ManagedComment},
+	)
+	fake.failAdds["new-b"] = true
+
+	updater := newPlanTestUpdater(t, fake, "",
+		DomainConfig{Name: "example.com", Record: "a", Type: "TXT", Value: "new-a"},
+		DomainConfig{Name: "example.com", Record: "b", Type: "TXT", Value: "new-b"},
+	)
+
+	plan, err := updater.planAll(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := updater.apply(context.Background(), plan, ApplyPolicy{Rollback: true}); err == nil {
+		t.Fatal("expected apply to fail")
+	}
+
+	if v := fake.value("a.example.com", "TXT"); v != "old-a" {
+		t.Errorf("expected a.example.com rolled back to old-a, got %q", v)
+	}
+	if v := fake.value("b.example.com", "TXT"); v != "old-b" {
+		t.Errorf("expected b.example.com restored to old-b, got %q", v)
+	}
+}
+
+// cancelDuringUpdate wraps a provider and cancels the apply's context, as a
+// SIGTERM would, once an update has started.
+type cancelDuringUpdate struct {
+	Provider
+	cancel context.CancelFunc
+}
+
+func (c cancelDuringUpdate) UpdateRecord(ctx context.Context, domain DomainConfig, current, value string) error {
+	c.cancel()
+	return c.Provider.UpdateRecord(ctx, domain, current, value)
+}
+
+// TestApplyFinishesUpdateWhenCancelled tests that an update in progress
+// when the apply is cancelled is finished within the grace period, that no
+// further update is started, and that the cycle isn't recorded as complete
+func TestApplyFinishesUpdateWhenCancelled(t *testing.T) {
+	fake := newFakeDreamhost(
+		DNSRecord{Record: "a.example.com", Type: "A", Value: "203.0.113.10", Comment: ManagedComment},
+		DNSRecord{Record: "b.example.com", Type: "A", Value: "203.0.113.10", Comment: ManagedComment},
+	)
+	updater := newPlanTestUpdater(t, fake, "203.0.113.42",
+		DomainConfig{Name: "example.com", Record: "a", Type: "A"},
+		DomainConfig{Name: "example.com", Record: "b", Type: "A"},
+	)
+	updater.config.ShutdownGracePeriod = 5 * time.Second
+
+	plan, err := updater.planAll(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	h := updater.providers[DefaultProvider]
+	h.provider = cancelDuringUpdate{Provider: h.provider, cancel: cancel}
+
+	if err := updater.apply(ctx, plan, ApplyPolicy{}); err == nil {
+		t.Fatal("expected apply to report the record it didn't update")
+	}
+	if v := fake.value("a.example.com", "A"); v != "203.0.113.42" {
+		t.Errorf("expected the update in progress to finish, a.example.com = %q", v)
+	}
+	if v := fake.value("b.example.com", "A"); v != "203.0.113.10" {
+		t.Errorf("expected no update after cancellation, b.example.com = %q", v)
+	}
+	if fake.calls["dns-add_record"] != 1 {
+		t.Errorf("expected 1 add call, got %d", fake.calls["dns-add_record"])
+	}
+	if updater.state.LastIP != "" {
+		t.Errorf("expected the cancelled cycle not to record the IP, got %q", updater.state.LastIP)
+	}
+	if updater.state.Records["a.example.com/A"] != "203.0.113.42" {
+		t.Errorf("expected the finished update saved in the state, got %v", updater.state.Records)
+	}
+}
+
+// TestApplyRecordStatus tests that failures are counted per record until an update succeeds
+func TestApplyRecordStatus(t *testing.T) {
+	fake := newFakeDreamhost(DNSRecord{Record: "same.example.com", Type: "A", Value: "203.0.113.42", Comment: ManagedComment})
+	fake.failAdds["203.0.113.42"] = true
+	updater := newPlanTestUpdater(t, fake, "203.0.113.42",
+		DomainConfig{Name: "example.com", Record: "home", Type: "A"},
+		DomainConfig{Name: "example.com", Record: "same", Type: "A"},
+	)
+
+	for range 2 {
+		plan, err := updater.planAll(context.Background())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := updater.apply(context.Background(), plan, ApplyPolicy{}); err == nil {
+			t.Fatal("expected apply to fail")
+		}
+	}
+	status := updater.state.RecordStatus["home.example.com/A"]
+	if status == nil || status.ConsecutiveFailures != 2 || status.LastError == "" || status.LastFailure.IsZero() || !status.LastUpdated.IsZero() {
+		t.Fatalf("expected 2 failures recorded, got %+v", status)
+	}
+	if same := updater.state.RecordStatus["same.example.com/A"]; same == nil || same.LastVerified.IsZero() || same.ConsecutiveFailures != 0 {
+		t.Errorf("expected the unchanged record verified, got %+v", same)
+	}
+
+	fake.failAdds["203.0.113.42"] = false
+	plan, err := updater.planAll(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := updater.apply(context.Background(), plan, ApplyPolicy{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if status.ConsecutiveFailures != 0 || status.LastError != "" || status.LastUpdated.IsZero() || status.LastVerified.IsZero() {
+		t.Errorf("expected the failures cleared by the update, got %+v", status)
+	}
+}
+
+// TestPublishRequiresApproval tests that changes are held as pending instead of applied
+func TestPublishRequiresApproval(t *testing.T) {
+	fake := newFakeDreamhost()
+	updater := newPlanTestUpdater(t, fake, "203.0.113.42", DomainConfig{Name: "example.com", Record: "home", Type: "A"})
+	updater.config.RequireApproval = true
+
+	if err := updater.publish(context.Background(), updater.providers[DefaultProvider]); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if fake.calls["dns-add_record"] != 0 {
+		t.Error("changes were applied without approval")
+	}
+	pending := updater.PendingPlan()
+	if pending == nil || len(pending.Changes()) != 1 {
+		t.Fatalf("expected a pending plan with 1 change, got %+v", pending)
+	}
+}
+
+// TestPublishCachesPendingAbsence tests that a creation held for approval isn't listed again until its desired value changes
+func TestPublishCachesPendingAbsence(t *testing.T) {
+	fake := newFakeDreamhost()
+	updater := newPlanTestUpdater(t, fake, "203.0.113.42", DomainConfig{Name: "example.com", Record: "home", Type: "A"})
+	updater.config.RequireApproval = true
+	updater.absent = newAbsenceCache(time.Hour)
+	h := updater.providers[DefaultProvider]
+
+	for range 3 {
+		if err := updater.publish(context.Background(), h); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if n := fake.calls["dns-list_records"]; n != 1 {
+		t.Errorf("expected the absent record to be listed once, got %d", n)
+	}
+	if pending := updater.PendingPlan(); pending == nil || pending.Changes()[0].Kind != ActionCreate {
+		t.Fatalf("expected a pending creation, got %+v", pending)
+	}
+
+	updater.desired.Set(DefaultSource, "203.0.113.43")
+	if err := updater.publish(context.Background(), h); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n := fake.calls["dns-list_records"]; n != 2 {
+		t.Errorf("expected a new desired value to list the record again, got %d lists", n)
+	}
+}
+
+// TestPlanListsOncePerProvider tests that a plan lists the records once however many it plans, and reads each one from that listing
+func TestPlanListsOncePerProvider(t *testing.T) {
+	fake := newFakeDreamhost(
+		DNSRecord{Record: "a.example.com", Type: "A", Value: "203.0.113.42", Comment: ManagedComment},
+		DNSRecord{Record: "b.example.com", Type: "A", Value: "203.0.113.10", Comment: ManagedComment},
+		DNSRecord{Record: "b.example.com", Type: "TXT", Value: "unrelated"},
+	)
+	updater := newPlanTestUpdater(t, fake, "203.0.113.42",
+		DomainConfig{Name: "example.com", Record: "a", Type: "A"},
+		DomainConfig{Name: "example.com", Record: "b", Type: "A"},
+		DomainConfig{Name: "example.com", Record: "c", Type: "A"},
+	)
+
+	plan, err := updater.planAll(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n := fake.calls["dns-list_records"]; n != 1 {
+		t.Errorf("expected 1 list call for 3 records, got %d", n)
+	}
+	want := map[string]ActionKind{"a.example.com": ActionNoop, "b.example.com": ActionUpdate, "c.example.com": ActionCreate}
+	for _, action := range plan.Actions {
+		if action.Kind != want[action.Record] {
+			t.Errorf("%s: expected %v, got %v (%s)", action.Record, want[action.Record], action.Kind, action.Reason)
+		}
+	}
+	if action := plan.Actions[1]; action.Current != "203.0.113.10" {
+		t.Errorf("expected b.example.com's current value from the listing, got %q", action.Current)
+	}
+}
+
+// TestApplyVerifiesUpdates tests that an update the API silently drops is reported as a failure
+func TestApplyVerifiesUpdates(t *testing.T) {
+	defer func(d time.Duration) { verifyDelay = d }(verifyDelay)
+	verifyDelay = time.Millisecond
+
+	fake := newFakeDreamhost()
+	fake.dropAdds["203.0.113.42"] = true
+	updater := newPlanTestUpdater(t, fake, "203.0.113.42", DomainConfig{Name: "example.com", Record: "home", Type: "A"})
+
+	plan, err := updater.planAll(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := updater.apply(context.Background(), plan, ApplyPolicy{}); err == nil {
+		t.Fatal("expected verification failure")
+	}
+
+	if _, ok := updater.state.Records["home.example.com/A"]; ok {
+		t.Error("unverified record must not be recorded in state")
+	}
+	// One list for planning plus one per verification attempt
+	if fake.calls["dns-list_records"] != 1+verifyAttempts {
+		t.Errorf("expected %d list calls, got %d", 1+verifyAttempts, fake.calls["dns-list_records"])
+	}
+}
+
+// TestVerifyWait tests that records with longer TTLs are given longer to verify, within bounds
+func TestVerifyWait(t *testing.T) {
+	tests := []struct {
+		ttl  int
+		want time.Duration
+	}{
+		{ttl: 0, want: verifyDelay},
+		{ttl: 5, want: verifyDelay},
+		{ttl: 120, want: 12 * time.Second},
+		{ttl: 86400, want: maxVerifyDelay},
+	}
+	for _, tt := range tests {
+		if got := verifyWait(tt.ttl); got != tt.want {
+			t.Errorf("verifyWait(%d) = %v, want %v", tt.ttl, got, tt.want)
+		}
+	}
+}
+
+// TestCheckVerifiedRecords tests detection of missing, duplicate and wrong records
+func TestCheckVerifiedRecords(t *testing.T) {
+	domain := DomainConfig{Name: "example.com", Record: "home", Type: "A"}
+	record := func(value string) DNSRecord {
+		return DNSRecord{Record: "home.example.com", Type: "A", Value: value}
+	}
+
+	if err := checkVerifiedRecords(domain, []DNSRecord{record("203.0.113.42")}, "203.0.113.42"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := checkVerifiedRecords(domain, nil, "203.0.113.42"); err == nil {
+		t.Error("expected error for missing record")
+	}
+	if err := checkVerifiedRecords(domain, []DNSRecord{record("203.0.113.42"), record("198.51.100.1")}, "203.0.113.42"); err == nil {
+		t.Error("expected error for duplicate records")
+	}
+	if err := checkVerifiedRecords(domain, []DNSRecord{record("198.51.100.1")}, "203.0.113.42"); err == nil {
+		t.Error("expected error for wrong value")
+	}
+
+	txt := DomainConfig{Name: "example.com", Type: "TXT", Value: "site-verification=abc"}
+	records := []DNSRecord{{Type: "TXT", Value: "v=spf1 mx -all"}, {Type: "TXT", Value: "site-verification=abc"}}
+	if err := checkVerifiedRecords(txt, records, "site-verification=abc"); err != nil {
+		t.Errorf("expected a TXT value beside another verified, got %v", err)
+	}
+	if err := checkVerifiedRecords(txt, records[:1], "site-verification=abc"); err == nil {
+		t.Error("expected error for a missing TXT value")
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+
+	"gopkg.in/yaml.v3"
+)
+
+// redacted replaces secrets in the output of print-config.
+const redacted = "REDACTED"
+
+// redactSecrets returns a copy of config with every secret that is set
+// replaced by redacted, leaving config itself untouched.
+func redactSecrets(config *Config) *Config {
+	redact := func(s *string) {
+		if *s != "" {
+			*s = redacted
+		}
+	}
+
+	c := *config
+	redact(&c.DreamhostAPIKey)
+	c.Providers = maps.Clone(config.Providers)
+	for name, pc := range c.Providers {
+		redact(&pc.APIKey)
+		c.Providers[name] = pc
+	}
+	redact(&c.Webhook.Token)
+	c.Dyndns2.Users = slices.Clone(config.Dyndns2.Users)
+	for i := range c.Dyndns2.Users {
+		redact(&c.Dyndns2.Users[i].Password)
+	}
+	c.RFC2136.Keys = slices.Clone(config.RFC2136.Keys)
+	for i := range c.RFC2136.Keys {
+		redact(&c.RFC2136.Keys[i].Secret)
+	}
+	redact(&c.Redis.Password)
+	redact(&c.Etcd.Password)
+	redact(&c.Consul.Token)
+	redact(&c.Heartbeat.URL)
+	redact(&c.Heartbeat.FailURL)
+	redact(&c.GeoIP.Token)
+	c.Notifications.Webhooks = slices.Clone(config.Notifications.Webhooks)
+	for i, w := range c.Notifications.Webhooks {
+		// Headers typically carry the credentials, e.g. Authorization
+		w.Headers = maps.Clone(w.Headers)
+		for name := range w.Headers {
+			w.Headers[name] = redacted
+		}
+		c.Notifications.Webhooks[i] = w
+	}
+	c.Notifications.Slack = slices.Clone(config.Notifications.Slack)
+	for i := range c.Notifications.Slack {
+		redact(&c.Notifications.Slack[i].URL)
+	}
+	c.Notifications.Discord = slices.Clone(config.Notifications.Discord)
+	for i := range c.Notifications.Discord {
+		redact(&c.Notifications.Discord[i].URL)
+	}
+	c.Notifications.Ntfy = slices.Clone(config.Notifications.Ntfy)
+	for i := range c.Notifications.Ntfy {
+		redact(&c.Notifications.Ntfy[i].Token)
+	}
+	c.Notifications.Gotify = slices.Clone(config.Notifications.Gotify)
+	for i := range c.Notifications.Gotify {
+		redact(&c.Notifications.Gotify[i].Token)
+	}
+	c.Notifications.Pushover = slices.Clone(config.Notifications.Pushover)
+	for i := range c.Notifications.Pushover {
+		redact(&c.Notifications.Pushover[i].Token)
+		redact(&c.Notifications.Pushover[i].UserKey)
+	}
+	c.Notifications.MQTT = slices.Clone(config.Notifications.MQTT)
+	for i := range c.Notifications.MQTT {
+		redact(&c.Notifications.MQTT[i].Password)
+	}
+	return &c
+}
+
+// runPrintConfigCommand prints the configuration the daemon would run
+// with: the file with its profile, fragments, environment overrides, flag
+// overrides and defaults applied, and secrets redacted. Problems that would
+// stop the daemon are reported after it.
+//
+//	dh-ddns-updater print-config [--profile name] [--setting value...] [config]
+func runPrintConfigCommand(args []string) int {
+	fs := flag.NewFlagSet("print-config", flag.ContinueOnError)
+	opts := configFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: dh-ddns-updater print-config [--profile name] [--setting value...] [config]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	path := commandConfigPath(fs)
+
+	config, err := loadConfig(path, *opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+		return 1
+	}
+	applyConfigDefaults(config)
+	errs := validateConfig(config)
+
+	printed := redactSecrets(config)
+	printed.ConfDir = config.confDir // As resolved, or "" if no fragments were read
+	enc := yaml.NewEncoder(os.Stdout)
+	enc.SetIndent(2)
+	if err := enc.Encode(printed); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to print config: %v\n", err)
+		return 1
+	}
+	enc.Close()
+
+	for _, err := range errs {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+	}
+	if len(errs) > 0 {
+		return 1
+	}
+	return 0
+}
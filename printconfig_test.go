@@ -0,0 +1,45 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// TestRedactSecrets tests that printed configs carry no secrets and the loaded config keeps them
+func TestRedactSecrets(t *testing.T) {
+	config, err := parseConfig([]byte(`
+dreamhost_api_key: secret-main
+providers:
+  backup: {api_key: secret-backup}
+  empty: {api_key_file: /run/secrets/key}
+webhook: {listen: ":8080", token: secret-token}
+dyndns2:
+  users: [{username: router, password: secret-password}]
+rfc2136:
+  keys: [{name: dhcp-key, secret: secret-tsig}]
+`), configOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := yaml.Marshal(redactSecrets(config))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "secret-") {
+		t.Errorf("printed config contains a secret:\n%s", data)
+	}
+	if n := strings.Count(string(data), redacted); n != 5 {
+		t.Errorf("expected 5 redacted secrets, got %d:\n%s", n, data)
+	}
+	if !strings.Contains(string(data), "/run/secrets/key") {
+		t.Errorf("expected secret file paths to be kept:\n%s", data)
+	}
+
+	if config.DreamhostAPIKey != "secret-main" || config.Providers["backup"].APIKey != "secret-backup" ||
+		config.Dyndns2.Users[0].Password != "secret-password" || config.RFC2136.Keys[0].Secret != "secret-tsig" {
+		t.Error("expected the loaded config to keep its secrets")
+	}
+}
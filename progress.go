@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// progressInterval is how often the status line is redrawn. Updates in
+// between only replace what the next redraw shows, so a run that touches
+// many records doesn't flood the terminal.
+var progressInterval = 100 * time.Millisecond
+
+// progressFrames are the spinner frames.
+var progressFrames = []rune("⠋⠙⠹⠸⠼⠴⠦⠧⠇⠏")
+
+// progress shows the steps of an interactive run (detect, list, plan, apply)
+// as a spinner line on a terminal, leaving a line per finished step. It is
+// separate from the logs, which it keeps from tearing the status line when
+// they share the terminal. All methods are no-ops on a nil *progress, which
+// is what non-interactive runs and the daemon use.
+type progress struct {
+	out      io.Writer
+	interval time.Duration
+
+	mu      sync.Mutex
+	step    string // Current step, "" when none is running
+	detail  string
+	started time.Time
+	frame   int
+	drawn   bool // Whether the status line is on screen
+
+	stop, done chan struct{} // Control the redraw goroutine of the current step
+}
+
+// newProgress returns a progress writing to out, redrawn every interval.
+func newProgress(out io.Writer, interval time.Duration) *progress {
+	return &progress{out: out, interval: interval}
+}
+
+// isTerminal reports whether f is an interactive terminal.
+func isTerminal(f *os.File) bool {
+	if os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// terminalProgress returns a progress on stderr if it is a terminal, and nil
+// otherwise.
+func terminalProgress() *progress {
+	if !isTerminal(os.Stderr) {
+		return nil
+	}
+	return newProgress(os.Stderr, progressInterval)
+}
+
+// Start begins a step, finishing the previous one if it is still running.
+func (p *progress) Start(step string) {
+	if p == nil {
+		return
+	}
+	p.Done(nil)
+
+	p.mu.Lock()
+	p.step, p.detail, p.started, p.frame = step, "", time.Now(), 0
+	p.draw()
+	p.mu.Unlock()
+
+	p.stop, p.done = make(chan struct{}), make(chan struct{})
+	go p.animate(p.stop, p.done)
+}
+
+// Update sets the detail shown next to the current step, such as the record
+// being worked on. It appears on the next redraw.
+func (p *progress) Update(detail string) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	p.detail = detail
+	p.mu.Unlock()
+}
+
+// Done finishes the current step, replacing the status line with a mark, the
+// step's last detail and how long it took.
+func (p *progress) Done(err error) {
+	if p == nil || p.stop == nil {
+		return
+	}
+	close(p.stop)
+	<-p.done
+	p.stop, p.done = nil, nil
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	mark := "✓"
+	if err != nil {
+		mark = "✗"
+	}
+	p.clear()
+	line := mark + " " + p.step
+	if p.detail != "" {
+		line += "  " + p.detail
+	}
+	fmt.Fprintf(p.out, "%s (%s)\n", line, time.Since(p.started).Round(time.Millisecond))
+	p.step, p.detail = "", ""
+}
+
+// Logs returns a writer for log output sharing p's terminal. It clears the
+// status line before each write and redraws it after. With a nil p it
+// returns w.
+func (p *progress) Logs(w io.Writer) io.Writer {
+	if p == nil {
+		return w
+	}
+	return &progressLogWriter{p: p, w: w}
+}
+
+// animate redraws the status line every interval until stop is closed.
+func (p *progress) animate(stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		p.mu.Lock()
+		p.frame++
+		p.draw()
+		p.mu.Unlock()
+	}
+}
+
+// draw writes the status line over the current one. p.mu must be held.
+func (p *progress) draw() {
+	line := fmt.Sprintf("\r\033[K%c %s", progressFrames[p.frame%len(progressFrames)], p.step)
+	if p.detail != "" {
+		line += "  " + p.detail
+	}
+	io.WriteString(p.out, line)
+	p.drawn = true
+}
+
+// clear erases the status line if it is on screen. p.mu must be held.
+func (p *progress) clear() {
+	if p.drawn {
+		io.WriteString(p.out, "\r\033[K")
+		p.drawn = false
+	}
+}
+
+// progressLogWriter writes logs around a progress status line.
+type progressLogWriter struct {
+	p *progress
+	w io.Writer
+}
+
+func (l *progressLogWriter) Write(b []byte) (int, error) {
+	l.p.mu.Lock()
+	defer l.p.mu.Unlock()
+	l.p.clear()
+	n, err := l.w.Write(b)
+	if l.p.step != "" {
+		l.p.draw()
+	}
+	return n, err
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestProgress tests that finished steps leave one line each and that logs clear the status line
+func TestProgress(t *testing.T) {
+	var buf bytes.Buffer
+	p := newProgress(&buf, time.Millisecond)
+	logs := p.Logs(&buf)
+
+	p.Start("detect")
+	time.Sleep(5 * time.Millisecond)
+	p.Start("list") // Finishes detect
+	p.Update("home.example.com")
+	fmt.Fprintln(logs, `{"msg":"log line"}`)
+	p.Update("2 records")
+	p.Done(nil)
+	p.Start("apply")
+	p.Done(errors.New("boom"))
+	p.Done(nil) // No step running
+
+	out := buf.String()
+	var lines []string
+	for _, line := range strings.Split(out, "\n") {
+		// Keep what's left on screen after the last carriage return
+		if i := strings.LastIndex(line, "\r\033[K"); i >= 0 {
+			line = line[i+len("\r\033[K"):]
+		}
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+
+	want := []string{"✓ detect (", `{"msg":"log line"}`, "✓ list  2 records (", "✗ apply ("}
+	if len(lines) != len(want) {
+		t.Fatalf("expected %d lines, got %q", len(want), lines)
+	}
+	for i, prefix := range want {
+		if !strings.HasPrefix(lines[i], prefix) {
+			t.Errorf("line %d: expected prefix %q, got %q", i, prefix, lines[i])
+		}
+	}
+	if !strings.Contains(out, "\r\033[K"+string(progressFrames[0])+" detect") {
+		t.Errorf("expected a spinner line for detect, got %q", out)
+	}
+}
+
+// TestProgressNil tests that a nil progress does nothing and passes logs through
+func TestProgressNil(t *testing.T) {
+	var p *progress
+	p.Start("detect")
+	p.Update("detail")
+	p.Done(nil)
+
+	var buf bytes.Buffer
+	if w := p.Logs(&buf); w != &buf {
+		t.Errorf("expected logs to go straight to the writer, got %T", w)
+	}
+}
@@ -0,0 +1,552 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultProvider is the provider used by records that don't name one. It
+// is built from the top-level dreamhost_api_key unless configured explicitly.
+const DefaultProvider = "dreamhost"
+
+// ErrRateLimited is returned (wrapped) when a provider refuses requests
+// because too many were made, or while the daemon is backing off from it.
+var ErrRateLimited = errors.New("rate limited by provider")
+
+// ErrMisconfigured is returned (wrapped) when a provider definitively
+// rejects the configuration, e.g. an invalid API key or a zone the account
+// doesn't host, as opposed to a transient failure.
+var ErrMisconfigured = errors.New("provider misconfigured")
+
+// ErrCircuitOpen is returned (wrapped) for calls refused because the
+// provider has failed too many times in a row.
+var ErrCircuitOpen = errors.New("provider circuit open")
+
+// These errors are wrapped by provider errors with a recognized cause, so
+// that errors can be counted by category; see errorCategory.
+var (
+	ErrUnauthorized = errors.New("credentials rejected by provider")
+	ErrNoSuchZone   = errors.New("zone not hosted by provider")
+	ErrNotEditable  = errors.New("record not editable")
+	ErrBadResponse  = errors.New("unreadable response from provider")
+	ErrUnavailable  = errors.New("provider unavailable")
+)
+
+// errorCategory classifies a provider error for the per-category error
+// counts: auth, rate_limit, no_such_zone, not_editable, network (transport
+// failures and HTTP errors), decode, or other for anything unrecognized.
+func errorCategory(err error) string {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, ErrRateLimited):
+		return "rate_limit"
+	case errors.Is(err, ErrUnauthorized):
+		return "auth"
+	case errors.Is(err, ErrNoSuchZone):
+		return "no_such_zone"
+	case errors.Is(err, ErrNotEditable):
+		return "not_editable"
+	case errors.Is(err, ErrBadResponse):
+		return "decode"
+	case errors.Is(err, ErrUnavailable), errors.As(err, &netErr):
+		return "network"
+	}
+	return "other"
+}
+
+// Provider is a DNS hosting service whose records the daemon manages.
+type Provider interface {
+	// GetRecords returns the live records with the domain's name and type;
+	// normally there is at most one.
+	GetRecords(ctx context.Context, domain DomainConfig) ([]DNSRecord, error)
+	// ListRecords returns the live records with the name and type of any of
+	// domains, read in one go rather than one call per record.
+	ListRecords(ctx context.Context, domains []DomainConfig) ([]DNSRecord, error)
+	// UpdateRecord replaces the record holding current (if known) with value.
+	UpdateRecord(ctx context.Context, domain DomainConfig, current, value string) error
+	// RemoveRecord removes the record holding value.
+	RemoveRecord(ctx context.Context, domain DomainConfig, value string) error
+	// CheckAccess verifies that the credentials work and that the account
+	// hosts every zone.
+	CheckAccess(ctx context.Context, zones []string) error
+	// Capabilities describes what the provider supports.
+	Capabilities() ProviderCapabilities
+}
+
+// ProviderCapabilities describes what a provider can do, so that
+// configuration it can't honor is reported at startup rather than failing
+// at apply time.
+type ProviderCapabilities struct {
+	RecordTypes  []string `json:"record_types"`  // Record types it can manage
+	Comments     bool     `json:"comments"`      // Records carry a comment, used for the managed marker and notes
+	TTL          bool     `json:"ttl"`           // Record TTLs can be set
+	AtomicUpdate bool     `json:"atomic_update"` // Values are replaced in one call rather than by remove and add
+}
+
+// ProviderConfig configures one provider account that records can refer to
+// by name.
+type ProviderConfig struct {
+	Type               string        `yaml:"type"`                 // Provider implementation; only "dreamhost" is supported
+	APIKey             string        `yaml:"api_key"`              // Credentials for the account
+	APIKeyFile         string        `yaml:"api_key_file"`         // File holding api_key instead
+	APIKeyKeyring      string        `yaml:"api_key_keyring"`      // Platform keyring entry holding api_key instead
+	APIBase            string        `yaml:"api_base"`             // API endpoint (default dreamhost_api_base)
+	MinRequestInterval time.Duration `yaml:"min_request_interval"` // Minimum spacing between API calls (0 = unlimited)
+	RateLimitCooldown  time.Duration `yaml:"rate_limit_cooldown"`  // How long to stop calling after being rate limited
+
+	FailureThreshold     int           `yaml:"failure_threshold"`      // Consecutive failures that open the circuit (default 5)
+	CircuitProbeInterval time.Duration `yaml:"circuit_probe_interval"` // How often an open circuit lets a probe call through (default 5m)
+}
+
+// ProviderHealth summarizes how a provider has been behaving.
+type ProviderHealth struct {
+	Name                string    `json:"name"`
+	Healthy             bool      `json:"healthy"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+	LastError           string    `json:"last_error,omitempty"`
+	LastSuccess         time.Time `json:"last_success"`
+	CooldownUntil       time.Time `json:"cooldown_until,omitempty"`
+	CircuitOpen         bool      `json:"circuit_open"`
+
+	// Errors counts the errors the provider returned since startup, by
+	// errorCategory.
+	Errors map[string]int `json:"errors,omitempty"`
+}
+
+// rateLimiter spaces out requests to one provider and enforces cooldowns
+// after the provider reports rate limiting.
+type rateLimiter struct {
+	interval time.Duration
+
+	mu            sync.Mutex
+	next          time.Time // Earliest time the next request may start
+	cooldownUntil time.Time
+}
+
+// Wait blocks until a request may be made. It fails immediately, rather
+// than blocking, while the provider is cooling down.
+func (l *rateLimiter) Wait(ctx context.Context) error {
+	l.mu.Lock()
+	now := time.Now()
+	if now.Before(l.cooldownUntil) {
+		until := l.cooldownUntil
+		l.mu.Unlock()
+		return fmt.Errorf("%w: cooling down until %s", ErrRateLimited, until.Format(time.RFC3339))
+	}
+	start := now
+	if l.next.After(now) {
+		start = l.next
+	}
+	l.next = start.Add(l.interval)
+	l.mu.Unlock()
+
+	if wait := time.Until(start); wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+	return nil
+}
+
+// Cooldown stops all requests for d.
+func (l *rateLimiter) Cooldown(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.cooldownUntil = time.Now().Add(d)
+}
+
+// CooldownUntil returns the end of the current cooldown, or the zero time.
+func (l *rateLimiter) CooldownUntil() time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if time.Now().After(l.cooldownUntil) {
+		return time.Time{}
+	}
+	return l.cooldownUntil
+}
+
+// circuitBreaker stops calls to a provider after a run of consecutive
+// failures and lets a single probe call through every probe interval until
+// one succeeds.
+type circuitBreaker struct {
+	threshold     int
+	probeInterval time.Duration
+
+	mu       sync.Mutex
+	failures int
+	open     bool
+	openedAt time.Time
+	probing  bool // A probe call is in flight
+}
+
+// Allow returns nil if a call may be made. While the circuit is open it
+// fails fast, except for one probe per probe interval.
+func (b *circuitBreaker) Allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.open {
+		return nil
+	}
+	next := b.openedAt.Add(b.probeInterval)
+	if b.probing || time.Now().Before(next) {
+		return fmt.Errorf("%w after %d consecutive failures; next probe at %s", ErrCircuitOpen, b.failures, next.Format(time.RFC3339))
+	}
+	b.probing = true
+	return nil
+}
+
+// Record counts the outcome of an allowed call and reports whether it
+// opened or closed the circuit.
+func (b *circuitBreaker) Record(err error) (opened, closed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+	if err == nil {
+		b.failures = 0
+		closed = b.open
+		b.open = false
+		return false, closed
+	}
+	b.failures++
+	if b.open {
+		b.openedAt = time.Now() // Failed probe; wait another interval
+		return false, false
+	}
+	if b.failures >= b.threshold {
+		b.open = true
+		b.openedAt = time.Now()
+		return true, false
+	}
+	return false, false
+}
+
+// Abort ends an allowed call whose outcome says nothing about the
+// provider's health, such as a cancelled context.
+func (b *circuitBreaker) Abort() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
+
+// IsOpen reports whether calls are currently being refused.
+func (b *circuitBreaker) IsOpen() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.open
+}
+
+// providerHandle wraps a Provider with what keeps it isolated from the
+// others: its own request limiter, circuit breaker and publication stage, so
+// a slow, failing or rate-limited provider never holds up records on
+// another one.
+type providerHandle struct {
+	name     string
+	provider Provider
+	limiter  *rateLimiter
+	breaker  *circuitBreaker
+	cooldown time.Duration
+	stage    *Stage // Publication stage for this provider's records
+	logger   *slog.Logger
+	failures int     // Publications failed in a row, for notifications; only the stage uses it
+	stats    *statsd // Receives the latency of calls to the provider; nil sends none
+
+	mu      sync.Mutex
+	errors  map[string]int // Errors returned by the provider, by errorCategory
+	latency latencyTracker // Calls to the provider, except cancelled ones; see Dependencies
+}
+
+// admit waits until a call may be made to the provider.
+func (h *providerHandle) admit(ctx context.Context) error {
+	if err := h.limiter.Wait(ctx); err != nil {
+		return err
+	}
+	return h.breaker.Allow()
+}
+
+// observe accounts for the outcome of an admitted call of op, made at
+// start.
+func (h *providerHandle) observe(ctx context.Context, op string, start time.Time, err error) {
+	duration := time.Since(start)
+	h.stats.timing("provider.latency", duration, "provider", h.name, "operation", op, "outcome", outcomeTag(err))
+	if ctx.Err() == nil {
+		h.latency.record(duration, err)
+	}
+
+	if errors.Is(err, ErrRateLimited) {
+		h.limiter.Cooldown(h.cooldown)
+	}
+	// A cancelled call says nothing about the provider
+	if err != nil && ctx.Err() == nil {
+		h.countError(err)
+	}
+	// Rate limiting has its own cooldown
+	if errors.Is(err, ErrRateLimited) || ctx.Err() != nil {
+		h.breaker.Abort()
+		return
+	}
+
+	opened, closed := h.breaker.Record(err)
+	if opened {
+		h.logger.ErrorContext(ctx, "Provider keeps failing; pausing calls and probing periodically",
+			"failures", h.breaker.threshold,
+			"probe_interval", h.breaker.probeInterval,
+			"category", errorCategory(err),
+			"error", err)
+	}
+	if closed {
+		h.logger.InfoContext(ctx, "Provider recovered; resuming calls")
+	}
+}
+
+// countError adds err to the provider's error counts.
+func (h *providerHandle) countError(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.errors == nil {
+		h.errors = make(map[string]int)
+	}
+	h.errors[errorCategory(err)]++
+}
+
+// GetRecords implements Provider.
+func (h *providerHandle) GetRecords(ctx context.Context, domain DomainConfig) ([]DNSRecord, error) {
+	ctx = withRequestID(ctx)
+	if err := h.admit(ctx); err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	records, err := h.provider.GetRecords(ctx, domain)
+	h.observe(ctx, "get_records", start, err)
+	return records, err
+}
+
+// ListRecords implements Provider.
+func (h *providerHandle) ListRecords(ctx context.Context, domains []DomainConfig) ([]DNSRecord, error) {
+	ctx = withRequestID(ctx)
+	if err := h.admit(ctx); err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	records, err := h.provider.ListRecords(ctx, domains)
+	h.observe(ctx, "list_records", start, err)
+	return records, err
+}
+
+// UpdateRecord implements Provider.
+func (h *providerHandle) UpdateRecord(ctx context.Context, domain DomainConfig, current, value string) error {
+	ctx = withRequestID(ctx)
+	if err := h.admit(ctx); err != nil {
+		return err
+	}
+	start := time.Now()
+	err := h.provider.UpdateRecord(ctx, domain, current, value)
+	h.observe(ctx, "update_record", start, err)
+	return err
+}
+
+// RemoveRecord implements Provider.
+func (h *providerHandle) RemoveRecord(ctx context.Context, domain DomainConfig, value string) error {
+	ctx = withRequestID(ctx)
+	if err := h.admit(ctx); err != nil {
+		return err
+	}
+	start := time.Now()
+	err := h.provider.RemoveRecord(ctx, domain, value)
+	h.observe(ctx, "remove_record", start, err)
+	return err
+}
+
+// CheckAccess implements Provider.
+func (h *providerHandle) CheckAccess(ctx context.Context, zones []string) error {
+	ctx = withRequestID(ctx)
+	if err := h.admit(ctx); err != nil {
+		return err
+	}
+	start := time.Now()
+	err := h.provider.CheckAccess(ctx, zones)
+	h.observe(ctx, "check_access", start, err)
+	return err
+}
+
+// Capabilities implements Provider.
+func (h *providerHandle) Capabilities() ProviderCapabilities {
+	return h.provider.Capabilities()
+}
+
+// Health reports the provider's recent behavior, derived from its
+// publication stage, limiter and circuit breaker.
+func (h *providerHandle) Health() ProviderHealth {
+	health := ProviderHealth{Name: h.name, CooldownUntil: h.limiter.CooldownUntil(), CircuitOpen: h.breaker.IsOpen()}
+	h.mu.Lock()
+	if len(h.errors) > 0 {
+		health.Errors = maps.Clone(h.errors)
+	}
+	h.mu.Unlock()
+	if h.stage != nil {
+		m := h.stage.Metrics()
+		health.ConsecutiveFailures = m.ConsecutiveFailures
+		health.LastError = m.LastError
+		health.LastSuccess = m.LastSuccess
+	}
+	health.Healthy = health.ConsecutiveFailures == 0 && health.CooldownUntil.IsZero() && !health.CircuitOpen
+	return health
+}
+
+// buildProviders creates a handle for every configured provider, adding the
+// default Dreamhost provider from the top-level API key if it isn't
+// configured explicitly, and checks that every record names a known provider.
+func (d *DDNSUpdater) buildProviders() (map[string]*providerHandle, error) {
+	configs := make(map[string]ProviderConfig, len(d.config.Providers)+1)
+	for name, pc := range d.config.Providers {
+		configs[name] = pc
+	}
+	if _, ok := configs[DefaultProvider]; !ok {
+		configs[DefaultProvider] = ProviderConfig{Type: "dreamhost", APIKey: d.config.DreamhostAPIKey, APIBase: d.config.DreamhostAPIBase}
+	}
+
+	handles := make(map[string]*providerHandle, len(configs))
+	for name, pc := range configs {
+		var provider Provider
+		switch pc.Type {
+		case "dreamhost", "":
+			apiBase := pc.APIBase
+			if apiBase == "" {
+				apiBase = d.config.DreamhostAPIBase
+			}
+			provider = NewDreamhostProvider(pc.APIKey, apiBase, d.config.SyncNotesToComment, d.clientWithTimeout(d.config.HTTP.ProviderTimeout), d.logger.With("provider", name))
+		default:
+			return nil, fmt.Errorf("provider %q: unsupported type %q", name, pc.Type)
+		}
+
+		cooldown := pc.RateLimitCooldown
+		if cooldown == 0 {
+			cooldown = 10 * time.Minute
+		}
+		threshold := pc.FailureThreshold
+		if threshold == 0 {
+			threshold = 5
+		}
+		probeInterval := pc.CircuitProbeInterval
+		if probeInterval == 0 {
+			probeInterval = 5 * time.Minute
+		}
+		handles[name] = &providerHandle{
+			name:     name,
+			provider: provider,
+			limiter:  &rateLimiter{interval: pc.MinRequestInterval},
+			breaker:  &circuitBreaker{threshold: threshold, probeInterval: probeInterval},
+			cooldown: cooldown,
+			logger:   d.logger.With("provider", name),
+			stats:    d.stats,
+		}
+	}
+
+	for i, domain := range d.config.Domains {
+		if _, ok := handles[domain.Provider]; !ok {
+			return nil, fmt.Errorf("domain %d (%s): unknown provider %q", i, domain.Name, domain.Provider)
+		}
+	}
+
+	return handles, nil
+}
+
+// providerNames returns the names of all providers in a stable order.
+func (d *DDNSUpdater) providerNames() []string {
+	names := make([]string, 0, len(d.providers))
+	for name := range d.providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// providerDomains returns the configured records hosted by the named provider.
+func (d *DDNSUpdater) providerDomains(name string) []DomainConfig {
+	var domains []DomainConfig
+	for _, domain := range d.config.Domains {
+		if domain.Provider == name {
+			domains = append(domains, domain)
+		}
+	}
+	return domains
+}
+
+// checkProviders verifies every provider's credentials and zones before
+// the daemon starts publishing, so a bad key or a typo in a domain name
+// fails startup with a clear error. Transient failures, such as the
+// network not being up yet at boot, are only logged.
+func (d *DDNSUpdater) checkProviders(ctx context.Context) error {
+	var errs []error
+	for _, name := range d.providerNames() {
+		var zones []string
+		for _, domain := range d.providerDomains(name) {
+			if !slices.Contains(zones, domain.Name) {
+				zones = append(zones, domain.Name)
+			}
+		}
+		if len(zones) == 0 {
+			continue
+		}
+
+		err := d.providers[name].CheckAccess(ctx, zones)
+		switch {
+		case err == nil:
+			d.logger.Debug("Provider access verified", "provider", name, "zones", zones)
+		case errors.Is(err, ErrMisconfigured):
+			errs = append(errs, fmt.Errorf("provider %q: %w", name, err))
+		default:
+			d.logger.Warn("Could not verify provider access at startup; continuing", "provider", name, "error", err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// checkCapabilities compares the configuration with what each record's
+// provider supports. Problems are configuration the provider cannot carry
+// out at all; warnings are settings it will silently not honor.
+func (d *DDNSUpdater) checkCapabilities() (problems []error, warnings []string) {
+	for _, name := range d.providerNames() {
+		caps := d.providers[name].Capabilities()
+		domains := d.providerDomains(name)
+		for _, domain := range domains {
+			if !slices.Contains(caps.RecordTypes, domain.Type) {
+				problems = append(problems, fmt.Errorf("%s: provider %q does not support %s records", domain.FQDN(), name, domain.Type))
+			}
+			if domain.TTL != 0 && !caps.TTL {
+				warnings = append(warnings, fmt.Sprintf("%s: provider %q cannot set TTLs; ttl has no effect", domain.FQDN(), name))
+			}
+		}
+		if len(domains) == 0 || caps.Comments {
+			continue
+		}
+		warnings = append(warnings, fmt.Sprintf("provider %q does not support record comments, so records can't be marked as managed by dh-ddns-updater", name))
+		if d.config.SyncNotesToComment {
+			warnings = append(warnings, fmt.Sprintf("provider %q does not support record comments; sync_notes_to_comment has no effect", name))
+		}
+		if slices.ContainsFunc(domains, func(domain DomainConfig) bool { return domain.create.Comment != "" }) {
+			warnings = append(warnings, fmt.Sprintf("provider %q does not support record comments; group comments and labels have no effect", name))
+		}
+	}
+	return problems, warnings
+}
+
+// ProviderHealth returns the health of every provider, sorted by name.
+func (d *DDNSUpdater) ProviderHealth() []ProviderHealth {
+	var health []ProviderHealth
+	for _, name := range d.providerNames() {
+		health = append(health, d.providers[name].Health())
+	}
+	return health
+}
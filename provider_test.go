@@ -0,0 +1,393 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestRateLimiterSpacing tests that requests are spaced by the minimum interval
+func TestRateLimiterSpacing(t *testing.T) {
+	limiter := &rateLimiter{interval: 50 * time.Millisecond}
+	ctx := context.Background()
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := limiter.Wait(ctx); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("expected 3 requests to take at least 100ms, took %v", elapsed)
+	}
+}
+
+// TestRateLimiterCooldown tests that a cooldown fails requests fast instead of blocking
+func TestRateLimiterCooldown(t *testing.T) {
+	limiter := &rateLimiter{}
+	limiter.Cooldown(time.Hour)
+
+	err := limiter.Wait(context.Background())
+	if !errors.Is(err, ErrRateLimited) {
+		t.Errorf("expected ErrRateLimited during cooldown, got %v", err)
+	}
+	if limiter.CooldownUntil().IsZero() {
+		t.Error("expected cooldown end to be reported")
+	}
+}
+
+// TestCircuitBreaker tests opening after repeated failures, fast failure, probing and recovery
+func TestCircuitBreaker(t *testing.T) {
+	b := &circuitBreaker{threshold: 3, probeInterval: 50 * time.Millisecond}
+	failure := errors.New("boom")
+
+	for i := 0; i < 2; i++ {
+		if opened, _ := b.Record(failure); opened {
+			t.Fatalf("circuit opened after %d failures", i+1)
+		}
+	}
+	if opened, _ := b.Record(failure); !opened {
+		t.Fatal("expected circuit to open at the threshold")
+	}
+	if err := b.Allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected ErrCircuitOpen, got %v", err)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if err := b.Allow(); err != nil {
+		t.Fatalf("expected a probe to be allowed, got %v", err)
+	}
+	if err := b.Allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Error("expected only one probe at a time")
+	}
+	b.Record(failure)
+	if err := b.Allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Error("expected a failed probe to keep the circuit open")
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if err := b.Allow(); err != nil {
+		t.Fatalf("expected a probe to be allowed, got %v", err)
+	}
+	if _, closed := b.Record(nil); !closed {
+		t.Error("expected a successful probe to close the circuit")
+	}
+	if err := b.Allow(); err != nil {
+		t.Errorf("expected calls to resume, got %v", err)
+	}
+}
+
+// TestProviderCircuitOpens tests that a failing provider stops being called
+func TestProviderCircuitOpens(t *testing.T) {
+	fake := newFakeDreamhost()
+	fake.badKeys["test-key"] = true
+	updater := newPlanTestUpdater(t, fake, "")
+	h := updater.providers[DefaultProvider]
+	domain := DomainConfig{Name: "example.com", Record: "home", Type: "A"}
+
+	for i := 0; i < h.breaker.threshold+3; i++ {
+		h.GetRecords(context.Background(), domain)
+	}
+
+	if fake.calls["dns-list_records"] != h.breaker.threshold {
+		t.Errorf("expected %d API calls before the circuit opened, got %d", h.breaker.threshold, fake.calls["dns-list_records"])
+	}
+	health := h.Health()
+	if !health.CircuitOpen || health.Healthy {
+		t.Errorf("expected open circuit in health, got %+v", health)
+	}
+	if len(health.Errors) != 1 || health.Errors["auth"] != h.breaker.threshold {
+		t.Errorf("expected %d auth errors and no others, got %v", h.breaker.threshold, health.Errors)
+	}
+}
+
+// TestDreamhostError tests recognition of Dreamhost's rate-limit reply
+func TestDreamhostError(t *testing.T) {
+	if err := dreamhostError("slow_down_bucko"); !errors.Is(err, ErrRateLimited) {
+		t.Errorf("expected ErrRateLimited, got %v", err)
+	}
+	if err := dreamhostError("no_such_zone"); errors.Is(err, ErrRateLimited) || !errors.Is(err, errDreamhostAPI) {
+		t.Errorf("expected ordinary API error, got %v", err)
+	}
+}
+
+// TestErrorCategory tests classifying provider errors for the error counts
+func TestErrorCategory(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{name: "rate limited", err: dreamhostError("slow_down_bucko"), want: "rate_limit"},
+		{name: "invalid key", err: dreamhostError("invalid_api_key"), want: "auth"},
+		{name: "forbidden", err: dreamhostHTTPError(http.StatusForbidden), want: "auth"},
+		{name: "no such zone", err: dreamhostError("no_such_zone"), want: "no_such_zone"},
+		{name: "not editable", err: dreamhostError("record_already_exists_not_editable"), want: "not_editable"},
+		{name: "server error", err: dreamhostHTTPError(http.StatusBadGateway), want: "network"},
+		{name: "transport", err: &url.Error{Op: "Get", URL: "https://api.example", Err: errors.New("connection refused")}, want: "network"},
+		{name: "timeout", err: &url.Error{Op: "Get", URL: "https://api.example", Err: context.DeadlineExceeded}, want: "network"},
+		{name: "decode", err: fmt.Errorf("%w: unexpected EOF", ErrBadResponse), want: "decode"},
+		{name: "wrapped", err: fmt.Errorf("publishing: %w", dreamhostError("invalid_api_key")), want: "auth"},
+		{name: "unknown reply", err: dreamhostError("internal_error"), want: "other"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := errorCategory(tt.err); got != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+// TestDreamhostRemoveRecord tests that removing a missing record succeeds while real failures are reported
+func TestDreamhostRemoveRecord(t *testing.T) {
+	fake := newFakeDreamhost(DNSRecord{Record: "home.example.com", Type: "A", Value: "198.51.100.1"})
+	updater := newPlanTestUpdater(t, fake, "")
+	provider := NewDreamhostProvider("test-key", updater.config.DreamhostAPIBase, false, updater.httpClient, updater.logger)
+	domain := DomainConfig{Name: "example.com", Record: "home", Type: "A"}
+	ctx := context.Background()
+
+	if err := provider.RemoveRecord(ctx, domain, "198.51.100.1"); err != nil {
+		t.Errorf("unexpected error removing existing record: %v", err)
+	}
+	if err := provider.RemoveRecord(ctx, domain, "198.51.100.1"); err != nil {
+		t.Errorf("expected no_such_record to be treated as success, got %v", err)
+	}
+
+	fake.rateLimited["test-key"] = true
+	if err := provider.RemoveRecord(ctx, domain, "198.51.100.1"); !errors.Is(err, ErrRateLimited) {
+		t.Errorf("expected ErrRateLimited, got %v", err)
+	}
+}
+
+// TestDreamhostCreateSkipsRemove tests that a brand-new record is added without a remove call
+func TestDreamhostCreateSkipsRemove(t *testing.T) {
+	fake := newFakeDreamhost()
+	updater := newPlanTestUpdater(t, fake, "")
+	provider := NewDreamhostProvider("test-key", updater.config.DreamhostAPIBase, false, updater.httpClient, updater.logger)
+
+	domain := DomainConfig{Name: "example.com", Record: "home", Type: "A"}
+	if err := provider.UpdateRecord(context.Background(), domain, "", "203.0.113.42"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if fake.calls["dns-remove_record"] != 0 {
+		t.Errorf("expected no remove calls, got %d", fake.calls["dns-remove_record"])
+	}
+	if v := fake.value("home.example.com", "A"); v != "203.0.113.42" {
+		t.Errorf("expected record to be added, got %q", v)
+	}
+}
+
+// TestCheckProviders tests that bad keys and unknown domains fail startup while transient errors don't
+func TestCheckProviders(t *testing.T) {
+	tests := []struct {
+		name      string
+		domain    string
+		setup     func(f *fakeDreamhost)
+		wantError bool
+	}{
+		{name: "valid", domain: "example.com"},
+		{name: "unknown domain", domain: "example.org", wantError: true},
+		{name: "invalid key", domain: "example.com", setup: func(f *fakeDreamhost) { f.badKeys["test-key"] = true }, wantError: true},
+		{name: "rate limited", domain: "example.com", setup: func(f *fakeDreamhost) { f.rateLimited["test-key"] = true }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := newFakeDreamhost(DNSRecord{Record: "www.example.com", Type: "A", Value: "203.0.113.1", Zone: "example.com"})
+			if tt.setup != nil {
+				tt.setup(fake)
+			}
+			updater := newPlanTestUpdater(t, fake, "", DomainConfig{Name: tt.domain, Record: "home", Type: "A"})
+
+			err := updater.checkProviders(context.Background())
+			if tt.wantError {
+				if !errors.Is(err, ErrMisconfigured) {
+					t.Errorf("expected ErrMisconfigured, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+// TestDecodeRecordListing tests stream decoding of listings in either key order and of errors
+func TestDecodeRecordListing(t *testing.T) {
+	tests := []struct {
+		name        string
+		body        string
+		wantResult  string
+		wantMessage string
+		wantRecords int
+		wantError   bool
+	}{
+		{
+			name:        "result first",
+			body:        `{"result":"success","data":[{"record":"a.example.com","type":"A","value":"203.0.113.1","zone":"example.com"},{"record":"b.example.com","type":"A","value":"203.0.113.2"}]}`,
+			wantResult:  "success",
+			wantRecords: 2,
+		},
+		{
+			name:        "data first with extra keys",
+			body:        `{"data":[{"record":"a.example.com","type":"A","value":"203.0.113.1","editable":"1"}],"meta":{"x":1},"result":"success"}`,
+			wantResult:  "success",
+			wantRecords: 1,
+		},
+		{name: "error", body: `{"result":"error","data":"invalid_api_key"}`, wantResult: "error", wantMessage: "invalid_api_key"},
+		{name: "truncated", body: `{"result":"success","data":[{"record":"a.exa`, wantError: true},
+		{name: "not an object", body: `[]`, wantError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var records []DNSRecord
+			result, message, err := decodeRecordListing(json.NewDecoder(strings.NewReader(tt.body)), func(r DNSRecord) {
+				records = append(records, r)
+			})
+			if tt.wantError {
+				if err == nil {
+					t.Error("expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if result != tt.wantResult || message != tt.wantMessage || len(records) != tt.wantRecords {
+				t.Errorf("got result=%q message=%q records=%d", result, message, len(records))
+			}
+		})
+	}
+}
+
+// TestBuildProvidersUnknown tests that records must name a configured provider
+func TestBuildProvidersUnknown(t *testing.T) {
+	updater := &DDNSUpdater{
+		config: &Config{
+			DreamhostAPIKey: "test-key",
+			Domains:         []DomainConfig{{Name: "example.com", Type: "A", Provider: "nope"}},
+		},
+		logger: newLogger(io.Discard, "error"),
+	}
+
+	if _, err := updater.buildProviders(); err == nil {
+		t.Error("expected error for unknown provider")
+	}
+}
+
+// TestProviderIsolation tests that a rate-limited provider doesn't affect records on another
+func TestProviderIsolation(t *testing.T) {
+	fake := newFakeDreamhost()
+	fake.rateLimited["limited-key"] = true
+
+	updater := newPlanTestUpdater(t, fake, "203.0.113.42")
+	updater.config.Providers = map[string]ProviderConfig{
+		"limited": {Type: "dreamhost", APIKey: "limited-key", RateLimitCooldown: time.Hour},
+	}
+	updater.config.Domains = []DomainConfig{
+		{Name: "example.com", Record: "home", Type: "A", Provider: DefaultProvider},
+		{Name: "example.org", Record: "home", Type: "A", Provider: "limited"},
+	}
+	providers, err := updater.buildProviders()
+	if err != nil {
+		t.Fatalf("building providers: %v", err)
+	}
+	updater.providers = providers
+	for _, h := range providers {
+		h.stage = NewStage("publication:"+h.name, time.Minute, time.Minute, func(ctx context.Context) error {
+			return updater.publish(ctx, h)
+		}, nil)
+	}
+
+	if err := providers[DefaultProvider].stage.Execute(context.Background()); err != nil {
+		t.Fatalf("unexpected error from default provider: %v", err)
+	}
+	if err := providers["limited"].stage.Execute(context.Background()); err == nil {
+		t.Fatal("expected the limited provider to fail")
+	}
+
+	if v := fake.value("home.example.com", "A"); v != "203.0.113.42" {
+		t.Errorf("expected default provider's record to be updated, got %q", v)
+	}
+
+	health := updater.ProviderHealth()
+	if len(health) != 2 {
+		t.Fatalf("expected 2 providers, got %d", len(health))
+	}
+	for _, h := range health {
+		switch h.Name {
+		case DefaultProvider:
+			if !h.Healthy {
+				t.Errorf("expected default provider healthy, got %+v", h)
+			}
+		case "limited":
+			if h.Healthy || h.CooldownUntil.IsZero() {
+				t.Errorf("expected limited provider unhealthy and cooling down, got %+v", h)
+			}
+		}
+	}
+}
+
+// limitedProvider wraps a provider and reports reduced capabilities.
+type limitedProvider struct {
+	Provider
+	caps ProviderCapabilities
+}
+
+func (p limitedProvider) Capabilities() ProviderCapabilities { return p.caps }
+
+// TestCheckCapabilities tests that configuration a provider can't honor is reported
+func TestCheckCapabilities(t *testing.T) {
+	tests := []struct {
+		name         string
+		caps         ProviderCapabilities
+		syncNotes    bool
+		ttl          int              // Given to the A record
+		create       CreationDefaults // Given to the A record
+		wantProblems int
+		wantWarnings int
+	}{
+		{name: "dreamhost", caps: (&DreamhostProvider{}).Capabilities(), syncNotes: true},
+		{name: "unsupported type", caps: ProviderCapabilities{RecordTypes: []string{"A"}, Comments: true}, wantProblems: 1},
+		{name: "no comments", caps: ProviderCapabilities{RecordTypes: []string{"A", "TXT"}}, wantWarnings: 1},
+		{name: "no comments with notes sync", caps: ProviderCapabilities{RecordTypes: []string{"A", "TXT"}}, syncNotes: true, wantWarnings: 2},
+		{name: "record ttl", caps: (&DreamhostProvider{}).Capabilities(), ttl: 300, wantWarnings: 1},
+		{name: "record ttl supported", caps: ProviderCapabilities{RecordTypes: []string{"A", "TXT"}, Comments: true, TTL: true}, ttl: 300},
+		{name: "group defaults", caps: (&DreamhostProvider{}).Capabilities(), ttl: 300, create: CreationDefaults{TTL: 300, Comment: "netops"}, wantWarnings: 1},
+		{name: "group comment without comments", caps: ProviderCapabilities{RecordTypes: []string{"A", "TXT"}}, create: CreationDefaults{Comment: "netops"}, wantWarnings: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			updater := newPlanTestUpdater(t, newFakeDreamhost(), "",
+				DomainConfig{Name: "example.com", Record: "home", Type: "A"},
+				DomainConfig{Name: "example.com", Record: "_hb", Type: "TXT", Value: "x"},
+			)
+			updater.config.SyncNotesToComment = tt.syncNotes
+			updater.config.Domains[0].TTL = tt.ttl
+			updater.config.Domains[0].create = tt.create
+			h := updater.providers[DefaultProvider]
+			h.provider = limitedProvider{Provider: h.provider, caps: tt.caps}
+
+			problems, warnings := updater.checkCapabilities()
+			if len(problems) != tt.wantProblems {
+				t.Errorf("expected %d problems, got %v", tt.wantProblems, problems)
+			}
+			if len(warnings) != tt.wantWarnings {
+				t.Errorf("expected %d warnings, got %v", tt.wantWarnings, warnings)
+			}
+		})
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+)
+
+// staleRecords returns the records the state holds a value or status for
+// that no configured domain names any more, by state key, with the value
+// held.
+func staleRecords(state *State, config *Config) map[string]string {
+	configured := make(map[string]bool)
+	for _, domain := range config.Domains {
+		configured[stateKey(domain.FQDN(), domain.Type)] = true
+	}
+	stale := make(map[string]string)
+	for name, value := range state.Records {
+		if !configured[name] {
+			stale[name] = value
+		}
+	}
+	for name := range state.RecordStatus {
+		if _, ok := stale[name]; !ok && !configured[name] {
+			stale[name] = ""
+		}
+	}
+	return stale
+}
+
+// pruneState drops the state of records removed from the config, which
+// would otherwise stay in it forever, and returns them with the values
+// they held. The records themselves are left in DNS.
+func (d *DDNSUpdater) pruneState() map[string]string {
+	d.stateMu.Lock()
+	defer d.stateMu.Unlock()
+	stale := staleRecords(d.state, d.config)
+	for name := range stale {
+		delete(d.state.Records, name)
+		delete(d.state.RecordStatus, name)
+	}
+	return stale
+}
+
+// pruneOnStart is pruneState for the daemon's start, logging what was
+// dropped.
+func (d *DDNSUpdater) pruneOnStart() error {
+	stale := d.pruneState()
+	if len(stale) == 0 {
+		return nil
+	}
+	for _, key := range slices.Sorted(maps.Keys(stale)) {
+		name, recordType := splitStateKey(key)
+		d.logger.Info("Dropped state of a record no longer configured; run the prune command to delete it from DNS too",
+			"record", name, "type", recordType, "value", stale[key])
+	}
+	return d.saveState()
+}
+
+func runPruneCommand(args []string) int {
+	fs := flag.NewFlagSet("prune", flag.ContinueOnError)
+	opts := configFlags(fs)
+	timeout := timeoutFlag(fs)
+	deleteRecords := fs.Bool("delete-records", false, "offer to delete each orphaned record from DNS too")
+	yes := fs.Bool("yes", false, "with --delete-records, delete without asking")
+	provider := fs.String("provider", DefaultProvider, "with --delete-records, the provider hosting the orphaned records")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: dh-ddns-updater prune [--delete-records [--yes] [--provider name]] [--profile name] [config]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	updater, err := newCommandUpdater(commandConfigPath(fs), *opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to initialize updater: %v\n", err)
+		return 1
+	}
+	if updater.stateReadOnly {
+		fmt.Fprintln(os.Stderr, "The state is in use by another instance; stop the daemon first (it prunes the state itself when it starts)")
+		return 1
+	}
+	h := updater.providers[*provider]
+	if *deleteRecords && h == nil {
+		fmt.Fprintf(os.Stderr, "Unknown provider %q\n", *provider)
+		return 2
+	}
+
+	stale := updater.pruneState()
+	if len(stale) == 0 {
+		fmt.Println("The state holds nothing for records no longer configured.")
+		return 0
+	}
+	for _, key := range slices.Sorted(maps.Keys(stale)) {
+		name, recordType := splitStateKey(key)
+		fmt.Printf("- %s %s %s\n", name, recordType, stale[key])
+	}
+	if err := updater.saveState(); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to save state: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Dropped %d records from the state.\n", len(stale))
+	if !*deleteRecords {
+		return 0
+	}
+
+	ctx, cancel := commandContext(*timeout)
+	defer cancel()
+	confirm := func(string) bool { return true }
+	if !*yes {
+		confirm = promptYesNo(bufio.NewReader(os.Stdin), os.Stdout)
+	}
+	if err := deleteOrphanedRecords(ctx, h, stale, confirm, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// deleteOrphanedRecords deletes from DNS the records whose state was
+// pruned, where they still hold the value the state did, are managed by
+// the daemon and confirm agrees. Progress is written to w.
+func deleteOrphanedRecords(ctx context.Context, h *providerHandle, stale map[string]string, confirm func(question string) bool, w io.Writer) error {
+	var errs []error
+	for _, key := range slices.Sorted(maps.Keys(stale)) {
+		value := stale[key]
+		if value == "" {
+			continue // Only a status was held
+		}
+		name, recordType := splitStateKey(key)
+		domain := DomainConfig{Name: name, Type: recordType, Provider: h.name}
+		records, err := h.GetRecords(ctx, domain)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("reading %s %s: %w", name, domain.Type, err))
+			continue
+		}
+		i := slices.IndexFunc(records, func(r DNSRecord) bool { return r.Value == value })
+		switch {
+		case i < 0:
+			fmt.Fprintf(w, "%s %s %s is no longer in DNS\n", name, domain.Type, value)
+			continue
+		case !records[i].Managed():
+			fmt.Fprintf(w, "Not deleting %s %s %s: it isn't managed by dh-ddns-updater\n", name, domain.Type, value)
+			continue
+		case !confirm(fmt.Sprintf("Delete %s %s %s from DNS?", name, domain.Type, value)):
+			continue
+		}
+		if err := h.RemoveRecord(ctx, domain, value); err != nil {
+			errs = append(errs, fmt.Errorf("deleting %s %s: %w", name, domain.Type, err))
+			continue
+		}
+		fmt.Fprintf(w, "Deleted %s %s %s\n", name, domain.Type, value)
+	}
+	return errors.Join(errs...)
+}
+
+// promptYesNo returns a function asking questions on w and reading the
+// answers from r, which are no unless they start with y.
+func promptYesNo(r *bufio.Reader, w io.Writer) func(question string) bool {
+	return func(question string) bool {
+		fmt.Fprintf(w, "%s [y/N] ", question)
+		answer, _ := r.ReadString('\n')
+		return strings.HasPrefix(strings.ToLower(strings.TrimSpace(answer)), "y")
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+)
+
+// TestPruneState tests that the state of records no longer configured is dropped and that of configured records kept
+func TestPruneState(t *testing.T) {
+	updater := newPlanTestUpdater(t, newFakeDreamhost(), "", DomainConfig{Name: "example.com", Record: "home", Type: "A"})
+	updater.state.Records = map[string]string{"home.example.com/A": "203.0.113.1", "old.example.com/A": "203.0.113.2"}
+	updater.state.RecordStatus = map[string]*RecordStatus{"home.example.com/A": {}, "failed.example.com/A": {ConsecutiveFailures: 3}}
+
+	if err := updater.pruneOnStart(); err != nil {
+		t.Fatal(err)
+	}
+	if len(updater.state.Records) != 1 || len(updater.state.RecordStatus) != 1 {
+		t.Errorf("expected only home.example.com left, got %v and %v", updater.state.Records, updater.state.RecordStatus)
+	}
+	loaded, err := loadState(updater.config.StatePath)
+	if err != nil || loaded.Records["old.example.com/A"] != "" {
+		t.Errorf("expected the pruned state saved, got %+v (%v)", loaded, err)
+	}
+	if stale := updater.pruneState(); len(stale) != 0 {
+		t.Errorf("expected nothing left to prune, got %v", stale)
+	}
+}
+
+// TestDeleteOrphanedRecords tests which orphaned records are deleted from DNS
+func TestDeleteOrphanedRecords(t *testing.T) {
+	fake := newFakeDreamhost(
+		DNSRecord{Record: "old.example.com", Type: "A", Value: "203.0.113.2", Comment: ManagedComment},
+		DNSRecord{Record: "declined.example.com", Type: "AAAA", Value: "2001:db8::1", Comment: ManagedComment},
+		DNSRecord{Record: "manual.example.com", Type: "A", Value: "203.0.113.3", Comment: "hand-made"},
+		DNSRecord{Record: "spf.example.com", Type: "TXT", Value: "v=spf1 mx -all", Comment: ManagedComment},
+	)
+	updater := newPlanTestUpdater(t, fake, "")
+	stale := map[string]string{
+		"old.example.com/A":         "203.0.113.2",
+		"declined.example.com/AAAA": "2001:db8::1",
+		"manual.example.com/A":      "203.0.113.3",
+		"gone.example.com/A":        "203.0.113.4",
+		"spf.example.com/TXT":       "v=spf1 mx -all",
+		"txt.example.com/TXT":       "v=spf1 -all",
+	}
+
+	var out bytes.Buffer
+	confirm := promptYesNo(bufio.NewReader(strings.NewReader("n\ny\ny\n")), &out)
+	if err := deleteOrphanedRecords(context.Background(), updater.providers[DefaultProvider], stale, confirm, &out); err != nil {
+		t.Fatal(err)
+	}
+
+	if fake.value("old.example.com", "A") != "" || fake.value("spf.example.com", "TXT") != "" {
+		t.Error("expected the confirmed records deleted")
+	}
+	for _, kept := range []struct{ name, recordType string }{{"declined.example.com", "AAAA"}, {"manual.example.com", "A"}} {
+		if fake.value(kept.name, kept.recordType) == "" {
+			t.Errorf("expected %s kept", kept.name)
+		}
+	}
+	for _, want := range []string{
+		"Delete declined.example.com AAAA 2001:db8::1 from DNS? [y/N]",
+		"gone.example.com A 203.0.113.4 is no longer in DNS",
+		"Not deleting manual.example.com A 203.0.113.3: it isn't managed",
+		"Deleted old.example.com A 203.0.113.2",
+		"txt.example.com TXT v=spf1 -all is no longer in DNS",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("expected %q in:\n%s", want, out.String())
+		}
+	}
+}
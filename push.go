@@ -0,0 +1,213 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// NtfyNotificationConfig is an ntfy topic receiving events as push
+// notifications.
+type NtfyNotificationConfig struct {
+	Server     string         `yaml:"server"`     // ntfy server (default https://ntfy.sh)
+	Topic      string         `yaml:"topic"`      // Topic to publish to
+	Token      string         `yaml:"token"`      // Access token, for protected topics
+	TokenFile  string         `yaml:"token_file"` // File holding token instead
+	Priorities map[string]int `yaml:"priorities"` // Priority by event, 1 to 5 (default 4 for update_failed, 3 otherwise)
+
+	NotificationRoute `yaml:",inline"` // Which events are sent, and when
+}
+
+// GotifyNotificationConfig is a Gotify application receiving events as
+// messages.
+type GotifyNotificationConfig struct {
+	Server     string         `yaml:"server"`     // Gotify server URL
+	Token      string         `yaml:"token"`      // Application token
+	TokenFile  string         `yaml:"token_file"` // File holding token instead
+	Priorities map[string]int `yaml:"priorities"` // Priority by event, 0 to 10 (default 8 for update_failed, 5 otherwise)
+
+	NotificationRoute `yaml:",inline"` // Which events are sent, and when
+}
+
+// PushoverNotificationConfig is a Pushover user or group receiving events as
+// push notifications.
+type PushoverNotificationConfig struct {
+	Token       string         `yaml:"token"`         // Application API token
+	TokenFile   string         `yaml:"token_file"`    // File holding token instead
+	UserKey     string         `yaml:"user_key"`      // User or group key
+	UserKeyFile string         `yaml:"user_key_file"` // File holding user_key instead
+	Device      string         `yaml:"device"`        // Device to notify; empty notifies all of the user's
+	Priorities  map[string]int `yaml:"priorities"`    // Priority by event, -2 to 2 (default 1 for update_failed, 0 otherwise)
+
+	NotificationRoute `yaml:",inline"` // Which events are sent, and when
+}
+
+// DefaultNtfyServer is the ntfy server used unless server says otherwise.
+const DefaultNtfyServer = "https://ntfy.sh"
+
+// pushoverAPI is where Pushover messages are sent; tests replace it.
+var pushoverAPI = "https://api.pushover.net/1/messages.json"
+
+// priorityScale is the range of a push service's priorities, with those
+// events get unless configured otherwise: failures stand out, while changes
+// and recoveries are informational.
+type priorityScale struct {
+	min, max      int
+	info, failure int
+}
+
+var (
+	ntfyPriorities     = priorityScale{min: 1, max: 5, info: 3, failure: 4}
+	gotifyPriorities   = priorityScale{min: 0, max: 10, info: 5, failure: 8}
+	pushoverPriorities = priorityScale{min: -2, max: 2, info: 0, failure: 1}
+)
+
+// of returns the priority of event, configured or by default.
+func (s priorityScale) of(event string, configured map[string]int) int {
+	if p, ok := configured[event]; ok {
+		return p
+	}
+	if event == EventUpdateFailed {
+		return s.failure
+	}
+	return s.info
+}
+
+// validate checks that configured gives priorities of known events within
+// the scale.
+func (s priorityScale) validate(configured map[string]int) error {
+	for _, event := range slices.Sorted(maps.Keys(configured)) {
+		if err := validateNotificationEvents([]string{event}); err != nil {
+			return fmt.Errorf("priorities: %w", err)
+		}
+		if p := configured[event]; p < s.min || p > s.max {
+			return fmt.Errorf("priorities: %s: %d is not between %d and %d", event, p, s.min, s.max)
+		}
+	}
+	return nil
+}
+
+// eventTitle returns the title of an event's push notification.
+func eventTitle(e Event) string {
+	switch e.Event {
+	case EventIPChange:
+		return "IP changed to " + e.NewIP
+	case EventUpdateFailed:
+		return "DNS updates failing at " + e.Provider
+	case EventRecovered:
+		return "DNS updates recovered at " + e.Provider
+	}
+	return e.Event
+}
+
+// validateHTTPURL checks that the value of key is an http or https URL.
+func validateHTTPURL(key, value string) error {
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%s %q is not an http or https URL", key, redactConfigURL(value))
+	}
+	return nil
+}
+
+// validateNtfyNotification checks an ntfy notification.
+func validateNtfyNotification(n NtfyNotificationConfig) error {
+	if err := validateHTTPURL("server", n.Server); err != nil {
+		return err
+	}
+	if n.Topic == "" || strings.Contains(n.Topic, "/") {
+		return fmt.Errorf("topic %q is not a topic name", n.Topic)
+	}
+	if err := n.NotificationRoute.validate(); err != nil {
+		return err
+	}
+	return ntfyPriorities.validate(n.Priorities)
+}
+
+// ntfyNotifier publishes events to an ntfy topic.
+type ntfyNotifier NtfyNotificationConfig
+
+func (n ntfyNotifier) notify(ctx context.Context, client *http.Client, e Event) error {
+	headers := map[string]string{
+		"Title":    eventTitle(e),
+		"Priority": strconv.Itoa(ntfyPriorities.of(e.Event, n.Priorities)),
+		"Tags":     e.Event,
+	}
+	if n.Token != "" {
+		headers["Authorization"] = "Bearer " + n.Token
+	}
+	target := strings.TrimRight(n.Server, "/") + "/" + url.PathEscape(n.Topic)
+	return postNotification(ctx, client, target, "text/plain; charset=utf-8", []byte(e.Summary), headers)
+}
+
+// validateGotifyNotification checks a Gotify notification.
+func validateGotifyNotification(g GotifyNotificationConfig) error {
+	if err := validateHTTPURL("server", g.Server); err != nil {
+		return err
+	}
+	if g.Token == "" {
+		return errors.New("token is required")
+	}
+	if err := g.NotificationRoute.validate(); err != nil {
+		return err
+	}
+	return gotifyPriorities.validate(g.Priorities)
+}
+
+// gotifyNotifier sends events to a Gotify server as messages of an
+// application.
+type gotifyNotifier GotifyNotificationConfig
+
+func (g gotifyNotifier) notify(ctx context.Context, client *http.Client, e Event) error {
+	body, err := json.Marshal(map[string]any{
+		"title":    eventTitle(e),
+		"message":  e.Summary,
+		"priority": gotifyPriorities.of(e.Event, g.Priorities),
+	})
+	if err != nil {
+		return err
+	}
+	target := strings.TrimRight(g.Server, "/") + "/message"
+	return postNotification(ctx, client, target, "application/json", body, map[string]string{"X-Gotify-Key": g.Token})
+}
+
+// validatePushoverNotification checks a Pushover notification.
+func validatePushoverNotification(p PushoverNotificationConfig) error {
+	if p.Token == "" || p.UserKey == "" {
+		return errors.New("token and user_key are required")
+	}
+	if err := p.NotificationRoute.validate(); err != nil {
+		return err
+	}
+	return pushoverPriorities.validate(p.Priorities)
+}
+
+// pushoverNotifier sends events to Pushover.
+type pushoverNotifier PushoverNotificationConfig
+
+func (p pushoverNotifier) notify(ctx context.Context, client *http.Client, e Event) error {
+	priority := pushoverPriorities.of(e.Event, p.Priorities)
+	form := url.Values{
+		"token":    {p.Token},
+		"user":     {p.UserKey},
+		"title":    {eventTitle(e)},
+		"message":  {cmp.Or(e.Summary, e.Event)},
+		"priority": {strconv.Itoa(priority)},
+	}
+	if p.Device != "" {
+		form.Set("device", p.Device)
+	}
+	if priority == 2 {
+		// Emergency priority repeats until acknowledged, which requires these
+		form.Set("retry", "300")
+		form.Set("expire", "3600")
+	}
+	return postNotification(ctx, client, pushoverAPI, "application/x-www-form-urlencoded", []byte(form.Encode()), nil)
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// pushRequest is a request a push service received.
+type pushRequest struct {
+	path   string
+	header http.Header
+	body   string
+}
+
+// newPushServer returns a server recording the request it receives.
+func newPushServer(t *testing.T) (*httptest.Server, *pushRequest) {
+	t.Helper()
+	got := &pushRequest{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		*got = pushRequest{path: r.URL.Path, header: r.Header, body: string(body)}
+	}))
+	t.Cleanup(server.Close)
+	return server, got
+}
+
+// TestNtfyNotifier tests that events are published to the topic with the
+// priority of their event
+func TestNtfyNotifier(t *testing.T) {
+	server, got := newPushServer(t)
+	notifier := ntfyNotifier{Server: server.URL + "/", Topic: "home-dns", Token: "tk_secret", Priorities: map[string]int{EventIPChange: 2}}
+
+	tests := []struct {
+		event        Event
+		wantPriority string
+	}{
+		{Event{Event: EventIPChange, NewIP: "203.0.113.42", Summary: "IP changed"}, "2"},
+		{Event{Event: EventUpdateFailed, Provider: "dreamhost", Summary: "failed"}, "4"},
+		{Event{Event: EventRecovered, Provider: "dreamhost", Summary: "recovered"}, "3"},
+	}
+	for _, tt := range tests {
+		if err := notifier.notify(context.Background(), server.Client(), tt.event); err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.event.Event, err)
+		}
+		if got.path != "/home-dns" || got.body != tt.event.Summary {
+			t.Errorf("%s: unexpected request to %s: %q", tt.event.Event, got.path, got.body)
+		}
+		if p := got.header.Get("Priority"); p != tt.wantPriority {
+			t.Errorf("%s: expected priority %s, got %s", tt.event.Event, tt.wantPriority, p)
+		}
+		if got.header.Get("Title") != eventTitle(tt.event) || got.header.Get("Authorization") != "Bearer tk_secret" {
+			t.Errorf("%s: unexpected headers %v", tt.event.Event, got.header)
+		}
+	}
+}
+
+// TestGotifyNotifier tests that events are sent as messages of the
+// application, failures with a higher priority
+func TestGotifyNotifier(t *testing.T) {
+	server, got := newPushServer(t)
+	notifier := gotifyNotifier{Server: server.URL, Token: "app-token"}
+
+	for event, want := range map[string]int{EventIPChange: 5, EventUpdateFailed: 8} {
+		if err := notifier.notify(context.Background(), server.Client(), Event{Event: event, Summary: "summary"}); err != nil {
+			t.Fatalf("%s: unexpected error: %v", event, err)
+		}
+		var msg struct {
+			Title    string
+			Message  string
+			Priority int
+		}
+		if err := json.Unmarshal([]byte(got.body), &msg); err != nil {
+			t.Fatalf("%s: decoding message: %v", event, err)
+		}
+		if got.path != "/message" || got.header.Get("X-Gotify-Key") != "app-token" {
+			t.Errorf("%s: unexpected request to %s with %v", event, got.path, got.header)
+		}
+		if msg.Priority != want || msg.Message != "summary" || msg.Title == "" {
+			t.Errorf("%s: unexpected message %+v", event, msg)
+		}
+	}
+}
+
+// TestPushoverNotifier tests the form sent to Pushover, including the
+// parameters emergency priority needs
+func TestPushoverNotifier(t *testing.T) {
+	server, got := newPushServer(t)
+	defer func(api string) { pushoverAPI = api }(pushoverAPI)
+	pushoverAPI = server.URL + "/1/messages.json"
+	notifier := pushoverNotifier{Token: "app", UserKey: "user", Device: "phone", Priorities: map[string]int{EventUpdateFailed: 2}}
+
+	if err := notifier.notify(context.Background(), server.Client(), Event{Event: EventIPChange, NewIP: "203.0.113.42", Summary: "IP changed"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	form, err := url.ParseQuery(got.body)
+	if err != nil {
+		t.Fatalf("decoding form: %v", err)
+	}
+	want := url.Values{
+		"token":    {"app"},
+		"user":     {"user"},
+		"device":   {"phone"},
+		"title":    {"IP changed to 203.0.113.42"},
+		"message":  {"IP changed"},
+		"priority": {"0"},
+	}
+	if form.Encode() != want.Encode() {
+		t.Errorf("expected form %v, got %v", want, form)
+	}
+
+	if err := notifier.notify(context.Background(), server.Client(), Event{Event: EventUpdateFailed, Summary: "failed"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	form, _ = url.ParseQuery(got.body)
+	if form.Get("priority") != "2" || form.Get("retry") == "" || form.Get("expire") == "" {
+		t.Errorf("expected emergency priority with retry and expire, got %v", form)
+	}
+}
+
+// TestValidatePushNotifications tests the checks of ntfy, Gotify and
+// Pushover notifications
+func TestValidatePushNotifications(t *testing.T) {
+	tests := []struct {
+		name    string
+		err     error
+		wantErr string
+	}{
+		{"ntfy valid", validateNtfyNotification(NtfyNotificationConfig{Server: DefaultNtfyServer, Topic: "home", Priorities: map[string]int{EventUpdateFailed: 5}}), ""},
+		{"ntfy no topic", validateNtfyNotification(NtfyNotificationConfig{Server: DefaultNtfyServer}), "not a topic name"},
+		{"ntfy bad server", validateNtfyNotification(NtfyNotificationConfig{Server: "ntfy.sh", Topic: "home"}), "not an http or https URL"},
+		{"ntfy priority range", validateNtfyNotification(NtfyNotificationConfig{Server: DefaultNtfyServer, Topic: "home", Priorities: map[string]int{EventUpdateFailed: 8}}), "8 is not between 1 and 5"},
+		{"gotify valid", validateGotifyNotification(GotifyNotificationConfig{Server: "https://gotify.lan", Token: "t"}), ""},
+		{"gotify no token", validateGotifyNotification(GotifyNotificationConfig{Server: "https://gotify.lan"}), "token is required"},
+		{"gotify priority event", validateGotifyNotification(GotifyNotificationConfig{Server: "https://gotify.lan", Token: "t", Priorities: map[string]int{"failure": 9}}), `priorities: unknown event "failure"`},
+		{"pushover valid", validatePushoverNotification(PushoverNotificationConfig{Token: "t", UserKey: "u", Priorities: map[string]int{EventIPChange: -1}}), ""},
+		{"pushover no user", validatePushoverNotification(PushoverNotificationConfig{Token: "t"}), "user_key are required"},
+		{"pushover priority range", validatePushoverNotification(PushoverNotificationConfig{Token: "t", UserKey: "u", Priorities: map[string]int{EventIPChange: 3}}), "3 is not between -2 and 2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			switch {
+			case tt.wantErr == "" && tt.err != nil:
+				t.Errorf("unexpected error: %v", tt.err)
+			case tt.wantErr != "" && (tt.err == nil || !strings.Contains(tt.err.Error(), tt.wantErr)):
+				t.Errorf("expected error containing %q, got %v", tt.wantErr, tt.err)
+			}
+		})
+	}
+}
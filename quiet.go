@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// quietHoursSource is the desired-state source recording whether quiet
+// hours are in effect, so that their end wakes the publication stages to
+// make the changes deferred meanwhile.
+const quietHoursSource = "quiet_hours"
+
+// QuietWindow is a recurring window during which DNS changes are deferred.
+// Detection carries on as usual; the changes it calls for are made as soon
+// as no window is open.
+type QuietWindow struct {
+	Days     []string `yaml:"days"`     // Days the window opens on (mon, tue, ...); empty means every day
+	From     string   `yaml:"from"`     // Time of day the window opens, HH:MM (default 00:00)
+	Until    string   `yaml:"until"`    // Time of day it closes, HH:MM (default 24:00); earlier than from spans midnight
+	Start    string   `yaml:"start"`    // First date the window applies, YYYY-MM-DD (optional)
+	End      string   `yaml:"end"`      // Last date the window applies, YYYY-MM-DD (optional)
+	Timezone string   `yaml:"timezone"` // IANA time zone for days, times and dates (default local time)
+
+	window *scheduleWindow // Parsed form, set by validateQuietWindow
+}
+
+// validateQuietWindow checks a quiet window and parses it.
+func validateQuietWindow(q *QuietWindow) error {
+	w, err := parseWindow(q.Days, q.From, q.Until, q.Start, q.End, q.Timezone)
+	if err != nil {
+		return err
+	}
+	q.window = w
+	return nil
+}
+
+// inQuietHours reports whether a quiet window is open at t.
+func (d *DDNSUpdater) inQuietHours(t time.Time) bool {
+	for _, q := range d.config.QuietHours {
+		if q.window != nil && q.window.Contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// evaluateQuietHours records whether quiet hours are in effect at now,
+// logging when they begin and end.
+func (d *DDNSUpdater) evaluateQuietHours(now time.Time) {
+	if len(d.config.QuietHours) == 0 {
+		return
+	}
+	value := ""
+	if d.inQuietHours(now) {
+		value = "quiet"
+	}
+	_, seen := d.desired.Get(quietHoursSource)
+	if !d.desired.Set(quietHoursSource, value) {
+		return
+	}
+	switch {
+	case value != "":
+		d.logger.Info("Quiet hours began; DNS changes are deferred until they end")
+	case seen:
+		d.logger.Info("Quiet hours ended; making the deferred DNS changes")
+	}
+}
+
+// deferChanges holds back a provider's plan during quiet hours, logging
+// its changes unless the same ones were deferred already.
+func (d *DDNSUpdater) deferChanges(ctx context.Context, h *providerHandle, plan *Plan) {
+	d.pendingMu.Lock()
+	if d.deferred == nil {
+		d.deferred = make(map[string]*Plan)
+	}
+	previous := d.deferred[h.name]
+	d.deferred[h.name] = plan
+	d.pendingMu.Unlock()
+	if previous != nil && previous.sameChanges(plan) {
+		return
+	}
+
+	d.logger.InfoContext(ctx, "DNS changes deferred until the quiet hours end",
+		"provider", h.name,
+		"changes", len(plan.Changes()))
+	for _, a := range plan.Changes() {
+		d.logger.InfoContext(ctx, "Deferred DNS change",
+			"record", a.Record,
+			"type", a.Type,
+			"kind", a.Kind,
+			"old_value", a.Current,
+			"new_value", a.Desired)
+	}
+}
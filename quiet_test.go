@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// TestPublishDefersDuringQuietHours tests that changes are held back while
+// a quiet window is open, and made once its end wakes publication
+func TestPublishDefersDuringQuietHours(t *testing.T) {
+	fake := newFakeDreamhost()
+	updater := newPlanTestUpdater(t, fake, "203.0.113.42", DomainConfig{Name: "example.com", Record: "home", Type: "A"})
+	updater.config.QuietHours = []QuietWindow{{}} // All day, every day
+	if err := validateQuietWindow(&updater.config.QuietHours[0]); err != nil {
+		t.Fatal(err)
+	}
+	h := updater.providers[DefaultProvider]
+	woken := updater.desired.Subscribe()
+
+	updater.evaluateQuietHours(time.Now())
+	<-woken
+	for range 2 {
+		if err := updater.publish(context.Background(), h); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if fake.calls["dns-add_record"] != 0 {
+		t.Error("changes were made during quiet hours")
+	}
+	if plan := updater.deferred[h.name]; plan == nil || len(plan.Changes()) != 1 {
+		t.Fatalf("expected a deferred plan with 1 change, got %+v", plan)
+	}
+
+	// A window that was only open on a day long past
+	updater.config.QuietHours[0] = QuietWindow{Start: "2000-01-01", End: "2000-01-01"}
+	if err := validateQuietWindow(&updater.config.QuietHours[0]); err != nil {
+		t.Fatal(err)
+	}
+	updater.evaluateQuietHours(time.Now())
+	select {
+	case <-woken:
+	default:
+		t.Fatal("expected the end of the quiet hours to wake publication")
+	}
+	if err := updater.publish(context.Background(), h); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if fake.calls["dns-add_record"] != 1 {
+		t.Errorf("expected the deferred change to be made, got %d adds", fake.calls["dns-add_record"])
+	}
+	if _, ok := updater.deferred[h.name]; ok {
+		t.Error("expected the deferred plan to be cleared")
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// supportedRecordTypes lists the record types the daemon knows how to manage.
+// Address types follow the detected public IP unless a value is configured;
+// every other type requires an explicit value.
+var supportedRecordTypes = map[string]bool{
+	"A":     true,
+	"AAAA":  true,
+	"TXT":   true,
+	"CNAME": true,
+	"MX":    true,
+	"SRV":   true,
+	"CAA":   true,
+}
+
+// isAddressType reports whether records of this type hold an IP address.
+func isAddressType(recordType string) bool {
+	return recordType == "A" || recordType == "AAAA"
+}
+
+// isMultiValueType reports whether a name can hold several records of this
+// type, such as an SPF TXT record beside a site verification one. Only one
+// of them is the daemon's; see managedRecord.
+func isMultiValueType(recordType string) bool {
+	switch recordType {
+	case "TXT", "MX", "SRV", "CAA":
+		return true
+	}
+	return false
+}
+
+// recordTemplateData is the data available to value templates, e.g.
+// `value: "heartbeat {{.Timestamp}} from {{.IP}}"`.
+type recordTemplateData struct {
+	ip  string
+	now time.Time
+}
+
+// IP returns the detected public IP, failing the template if none is known yet.
+func (t recordTemplateData) IP() (string, error) {
+	if t.ip == "" {
+		return "", errors.New("no IP has been detected yet")
+	}
+	return t.ip, nil
+}
+
+// Timestamp returns the current time in RFC 3339 format.
+func (t recordTemplateData) Timestamp() string {
+	return t.now.UTC().Format(time.RFC3339)
+}
+
+// Unix returns the current time as seconds since the epoch.
+func (t recordTemplateData) Unix() int64 {
+	return t.now.Unix()
+}
+
+// Hostname returns the name of the host the daemon is running on.
+func (t recordTemplateData) Hostname() string {
+	name, _ := os.Hostname()
+	return name
+}
+
+// validateDomain checks that a record has a supported type and that its
+// value, if any, is a valid template. Types are normalized to upper case.
+func validateDomain(domain *DomainConfig) error {
+	domain.Type = strings.ToUpper(domain.Type)
+	if domain.Type == "" {
+		return fmt.Errorf("type is required")
+	}
+	if !supportedRecordTypes[domain.Type] {
+		return fmt.Errorf("unsupported record type %q", domain.Type)
+	}
+
+	if domain.LANAddress != "" {
+		ip := net.ParseIP(domain.LANAddress)
+		switch {
+		case !isAddressType(domain.Type):
+			return fmt.Errorf("lan_address is only supported for A and AAAA records")
+		case ip == nil:
+			return fmt.Errorf("invalid lan_address %q", domain.LANAddress)
+		case (ip.To4() != nil) != (domain.Type == "A"):
+			return fmt.Errorf("lan_address %q does not match record type %s", domain.LANAddress, domain.Type)
+		}
+	}
+
+	if err := validateSchedule(domain); err != nil {
+		return err
+	}
+
+	if domain.Value == "" {
+		if !isAddressType(domain.Type) {
+			return fmt.Errorf("%s records require a value", domain.Type)
+		}
+		return nil
+	}
+
+	if _, err := template.New("value").Parse(domain.Value); err != nil {
+		return fmt.Errorf("parsing value template: %w", err)
+	}
+	// An address record with a plain value is pinned to that address
+	if isAddressType(domain.Type) && !strings.Contains(domain.Value, "{{") && !ipMatchesType(domain.Value, domain.Type) {
+		return fmt.Errorf("value %q is not an %s address", domain.Value, domain.Type)
+	}
+	return nil
+}
+
+// desiredRecordValue computes the value a record should hold given the
+// detected public IP. Records without a configured value track the IP;
+// configured values are rendered as templates.
+func desiredRecordValue(domain DomainConfig, ip string) (string, error) {
+	data := recordTemplateData{ip: ip, now: time.Now()}
+	if domain.Value == "" {
+		return data.IP()
+	}
+
+	tmpl, err := template.New("value").Parse(domain.Value)
+	if err != nil {
+		return "", fmt.Errorf("parsing value template: %w", err)
+	}
+
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("rendering value template: %w", err)
+	}
+	return b.String(), nil
+}
+
+// recordValuesEqual compares a live record value with a desired one,
+// ignoring representation differences Dreamhost may introduce: trailing
+// dots and letter case on host names, and quoting of TXT strings.
+func recordValuesEqual(recordType, live, desired string) bool {
+	live, desired = strings.TrimSpace(live), strings.TrimSpace(desired)
+	switch recordType {
+	case "CNAME", "MX", "SRV":
+		return strings.EqualFold(strings.TrimSuffix(live, "."), strings.TrimSuffix(desired, "."))
+	case "TXT":
+		return strings.Trim(live, `"`) == strings.Trim(desired, `"`)
+	default:
+		return live == desired
+	}
+}
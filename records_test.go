@@ -0,0 +1,111 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestValidateDomain tests record type and value validation
+func TestValidateDomain(t *testing.T) {
+	tests := []struct {
+		name      string
+		domain    DomainConfig
+		wantError bool
+	}{
+		{name: "A without value", domain: DomainConfig{Name: "example.com", Type: "A"}},
+		{name: "lower case type", domain: DomainConfig{Name: "example.com", Type: "aaaa"}},
+		{name: "TXT with value", domain: DomainConfig{Name: "example.com", Type: "TXT", Value: "v=spf1 -all"}},
+		{name: "TXT with template", domain: DomainConfig{Name: "example.com", Type: "TXT", Value: "heartbeat {{.Timestamp}}"}},
+		{name: "TXT without value", domain: DomainConfig{Name: "example.com", Type: "TXT"}, wantError: true},
+		{name: "CNAME without value", domain: DomainConfig{Name: "example.com", Type: "CNAME"}, wantError: true},
+		{name: "missing type", domain: DomainConfig{Name: "example.com"}, wantError: true},
+		{name: "unsupported type", domain: DomainConfig{Name: "example.com", Type: "PTR"}, wantError: true},
+		{name: "bad template", domain: DomainConfig{Name: "example.com", Type: "TXT", Value: "{{.IP"}, wantError: true},
+		{name: "A pinned to an address", domain: DomainConfig{Name: "example.com", Type: "A", Value: "198.51.100.7"}},
+		{name: "AAAA pinned to an address", domain: DomainConfig{Name: "example.com", Type: "AAAA", Value: "2001:db8::7"}},
+		{name: "A pinned to an IPv6 address", domain: DomainConfig{Name: "example.com", Type: "A", Value: "2001:db8::7"}, wantError: true},
+		{name: "A pinned to a host name", domain: DomainConfig{Name: "example.com", Type: "A", Value: "nas.example.com"}, wantError: true},
+		{name: "A with template", domain: DomainConfig{Name: "example.com", Type: "A", Value: "{{.IP}}"}},
+		{name: "A with LAN address", domain: DomainConfig{Name: "example.com", Type: "A", LANAddress: "192.168.1.10"}},
+		{name: "AAAA with IPv4 LAN address", domain: DomainConfig{Name: "example.com", Type: "AAAA", LANAddress: "192.168.1.10"}, wantError: true},
+		{name: "TXT with LAN address", domain: DomainConfig{Name: "example.com", Type: "TXT", Value: "x", LANAddress: "192.168.1.10"}, wantError: true},
+		{name: "invalid LAN address", domain: DomainConfig{Name: "example.com", Type: "A", LANAddress: "nas.local"}, wantError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			domain := tt.domain
+			err := validateDomain(&domain)
+			if tt.wantError {
+				if err == nil {
+					t.Error("expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if domain.Type != strings.ToUpper(tt.domain.Type) {
+				t.Errorf("expected type to be normalized, got %q", domain.Type)
+			}
+		})
+	}
+}
+
+// TestDesiredRecordValue tests how desired values are derived from config and the detected IP
+func TestDesiredRecordValue(t *testing.T) {
+	tests := []struct {
+		name      string
+		domain    DomainConfig
+		ip        string
+		expected  string
+		wantError bool
+	}{
+		{name: "address follows IP", domain: DomainConfig{Type: "A"}, ip: "203.0.113.42", expected: "203.0.113.42"},
+		{name: "address without IP", domain: DomainConfig{Type: "A"}, ip: "", wantError: true},
+		{name: "static CNAME", domain: DomainConfig{Type: "CNAME", Value: "home.example.com."}, ip: "", expected: "home.example.com."},
+		{name: "templated TXT", domain: DomainConfig{Type: "TXT", Value: "ip={{.IP}}"}, ip: "203.0.113.42", expected: "ip=203.0.113.42"},
+		{name: "templated TXT without IP", domain: DomainConfig{Type: "TXT", Value: "ip={{.IP}}"}, ip: "", wantError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value, err := desiredRecordValue(tt.domain, tt.ip)
+			if tt.wantError {
+				if err == nil {
+					t.Errorf("expected error but got value %q", value)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if value != tt.expected {
+				t.Errorf("expected value %q, got %q", tt.expected, value)
+			}
+		})
+	}
+}
+
+// TestRecordValuesEqual tests type-aware comparison of live and desired values
+func TestRecordValuesEqual(t *testing.T) {
+	tests := []struct {
+		recordType string
+		live       string
+		desired    string
+		expected   bool
+	}{
+		{"A", "203.0.113.42", "203.0.113.42", true},
+		{"A", "203.0.113.42", "203.0.113.43", false},
+		{"CNAME", "Home.Example.com.", "home.example.com", true},
+		{"MX", "10 mail.example.com.", "10 mail.example.com", true},
+		{"TXT", `"v=spf1 -all"`, "v=spf1 -all", true},
+		{"TXT", "Hello", "hello", false},
+	}
+
+	for _, tt := range tests {
+		if got := recordValuesEqual(tt.recordType, tt.live, tt.desired); got != tt.expected {
+			t.Errorf("recordValuesEqual(%s, %q, %q) = %v, want %v", tt.recordType, tt.live, tt.desired, got, tt.expected)
+		}
+	}
+}
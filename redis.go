@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RedisConfig configures the Redis state backend, which lets several
+// instances share one state.
+type RedisConfig struct {
+	Address      string `yaml:"address"`       // host:port (default "localhost:6379")
+	Username     string `yaml:"username"`      // ACL user; empty uses the default user
+	Password     string `yaml:"password"`      // Password, if the server requires one
+	PasswordFile string `yaml:"password_file"` // File holding password instead
+	DB           int    `yaml:"db"`            // Database number (default 0)
+	Key          string `yaml:"key"`           // Prefix of the keys used (default "dh-ddns-updater")
+	TLS          bool   `yaml:"tls"`           // Connect over TLS
+}
+
+// DefaultRedisAddress and DefaultRedisKey are the Redis settings used
+// unless the redis block gives others.
+const (
+	DefaultRedisAddress = "localhost:6379"
+	DefaultRedisKey     = "dh-ddns-updater"
+)
+
+// redisStore keeps the state in Redis as JSON under <key>:state, with
+// <key>:revision counting the saves. A save is made in a transaction
+// watching the revision.
+type redisStore struct {
+	config RedisConfig
+}
+
+func (s redisStore) get(ctx context.Context) ([]byte, int64, error) {
+	conn, err := dialRedis(ctx, s.config)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer conn.Close()
+	return s.read(conn)
+}
+
+// read reads the stored state and its revision.
+func (s redisStore) read(conn *redisConn) ([]byte, int64, error) {
+	reply, err := conn.do("MGET", s.key("state"), s.key("revision"))
+	if err != nil {
+		return nil, 0, err
+	}
+	values, _ := reply.([]any)
+	if len(values) != 2 {
+		return nil, 0, fmt.Errorf("redis: unexpected MGET reply %v", reply)
+	}
+	var data []byte
+	if value, ok := values[0].(string); ok {
+		data = []byte(value)
+	}
+	var revision int64
+	if value, ok := values[1].(string); ok {
+		revision, _ = strconv.ParseInt(value, 10, 64)
+	}
+	return data, revision, nil
+}
+
+func (s redisStore) put(ctx context.Context, data []byte, revision int64) (bool, int64, error) {
+	conn, err := dialRedis(ctx, s.config)
+	if err != nil {
+		return false, 0, err
+	}
+	defer conn.Close()
+
+	if _, err := conn.do("WATCH", s.key("revision")); err != nil {
+		return false, 0, err
+	}
+	reply, err := conn.do("GET", s.key("revision"))
+	if err != nil {
+		return false, 0, err
+	}
+	current, _ := reply.(string)
+	if stored, _ := strconv.ParseInt(current, 10, 64); stored != revision {
+		return false, 0, nil
+	}
+
+	if _, err := conn.do("MULTI"); err != nil {
+		return false, 0, err
+	}
+	if _, err := conn.do("SET", s.key("state"), string(data)); err != nil {
+		return false, 0, err
+	}
+	if _, err := conn.do("INCR", s.key("revision")); err != nil {
+		return false, 0, err
+	}
+	reply, err = conn.do("EXEC")
+	if err != nil {
+		return false, 0, err
+	}
+	results, ok := reply.([]any)
+	if !ok {
+		// The revision changed after it was read
+		return false, 0, nil
+	}
+	if len(results) != 2 {
+		return false, 0, fmt.Errorf("redis: unexpected EXEC reply %v", reply)
+	}
+	saved, _ := results[1].(int64)
+	return true, saved, nil
+}
+
+func (s redisStore) key(name string) string {
+	return s.config.Key + ":" + name
+}
+
+// redisConn is a connection speaking the Redis protocol (RESP). Only what
+// the state backend needs is implemented; there is no Redis client in the
+// standard library.
+type redisConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+	stop func() bool // Stops the cancellation of ctx from ending the connection
+}
+
+// redisError is an error reply from the server.
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+// dialRedis connects to the server config names, authenticating and
+// selecting the database. The connection's deadline is sharedStateTimeout
+// away, or ctx's deadline if sooner, and the cancellation of ctx ends the
+// command in progress.
+func dialRedis(ctx context.Context, config RedisConfig) (*redisConn, error) {
+	address := config.Address
+	dialer := &net.Dialer{Timeout: sharedStateTimeout}
+	var conn net.Conn
+	var err error
+	if config.TLS {
+		host, _, _ := net.SplitHostPort(address)
+		tlsDialer := &tls.Dialer{NetDialer: dialer, Config: &tls.Config{ServerName: host}}
+		conn, err = tlsDialer.DialContext(ctx, "tcp", address)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", address)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("connecting to redis: %w", err)
+	}
+	deadline := time.Now().Add(sharedStateTimeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetDeadline(deadline)
+
+	c := &redisConn{conn: conn, r: bufio.NewReader(conn)}
+	c.stop = context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	if config.Password != "" {
+		args := []string{"AUTH", config.Password}
+		if config.Username != "" {
+			args = []string{"AUTH", config.Username, config.Password}
+		}
+		if _, err := c.do(args...); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	if config.DB != 0 {
+		if _, err := c.do("SELECT", strconv.Itoa(config.DB)); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// Close closes the connection.
+func (c *redisConn) Close() error {
+	c.stop()
+	return c.conn.Close()
+}
+
+// do sends a command and returns its reply: a string for simple and bulk
+// strings, an int64 for integers, a []any for arrays, or nil for a null
+// bulk string or array. An error reply is returned as a redisError.
+func (c *redisConn) do(args ...string) (any, error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := io.WriteString(c.conn, b.String()); err != nil {
+		return nil, fmt.Errorf("redis: %w", err)
+	}
+	reply, err := c.read()
+	if err != nil {
+		return nil, err
+	}
+	if e, ok := reply.(redisError); ok {
+		return nil, e
+	}
+	return reply, nil
+}
+
+// read reads one reply. Error replies nested in arrays, as EXEC returns
+// them, are returned as redisError values.
+func (c *redisConn) read() (any, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, fmt.Errorf("redis: %w", err)
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("redis: empty reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return redisError(line[1:]), nil
+	case ':':
+		n, err := strconv.ParseInt(line[1:], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("redis: bad integer reply %q", line)
+		}
+		return n, nil
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("redis: bad bulk reply %q", line)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, data); err != nil {
+			return nil, fmt.Errorf("redis: %w", err)
+		}
+		return string(data[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("redis: bad array reply %q", line)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		values := make([]any, n)
+		for i := range values {
+			if values[i], err = c.read(); err != nil {
+				return nil, err
+			}
+		}
+		return values, nil
+	}
+	return nil, fmt.Errorf("redis: unexpected reply %q", line)
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRedis is an in-memory stand-in for a Redis server, implementing the
+// commands the state backend sends.
+type fakeRedis struct {
+	mu       sync.Mutex
+	values   map[string]string
+	versions map[string]int // Bumped on every write, for WATCH
+	password string
+	onWatch  func() // Called after a WATCH, to simulate another instance
+}
+
+func newFakeRedis(t *testing.T, password string) (*fakeRedis, string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	f := &fakeRedis{values: make(map[string]string), versions: make(map[string]int), password: password}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return f, ln.Addr().String()
+}
+
+func (f *fakeRedis) set(key, value string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.values[key] = value
+	f.versions[key]++
+}
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	authed := f.password == ""
+	watched := map[string]int{}
+	var queued [][]string
+	inMulti := false
+
+	for {
+		args, err := readRESPCommand(r)
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(args[0])
+		if !authed && cmd != "AUTH" {
+			io.WriteString(conn, "-NOAUTH Authentication required.\r\n")
+			continue
+		}
+		if inMulti && cmd != "EXEC" {
+			queued = append(queued, args)
+			io.WriteString(conn, "+QUEUED\r\n")
+			continue
+		}
+
+		switch cmd {
+		case "AUTH":
+			if args[len(args)-1] != f.password {
+				io.WriteString(conn, "-WRONGPASS invalid password\r\n")
+				continue
+			}
+			authed = true
+			io.WriteString(conn, "+OK\r\n")
+		case "WATCH":
+			f.mu.Lock()
+			for _, key := range args[1:] {
+				watched[key] = f.versions[key]
+			}
+			onWatch := f.onWatch
+			f.mu.Unlock()
+			io.WriteString(conn, "+OK\r\n")
+			if onWatch != nil {
+				onWatch()
+			}
+		case "MULTI":
+			inMulti, queued = true, nil
+			io.WriteString(conn, "+OK\r\n")
+		case "EXEC":
+			inMulti = false
+			f.mu.Lock()
+			changed := false
+			for key, version := range watched {
+				changed = changed || f.versions[key] != version
+			}
+			watched = map[string]int{}
+			if changed {
+				f.mu.Unlock()
+				io.WriteString(conn, "*-1\r\n")
+				continue
+			}
+			replies := make([]string, len(queued))
+			for i, q := range queued {
+				replies[i] = f.exec(q)
+			}
+			f.mu.Unlock()
+			fmt.Fprintf(conn, "*%d\r\n%s", len(replies), strings.Join(replies, ""))
+		default:
+			f.mu.Lock()
+			reply := f.exec(args)
+			f.mu.Unlock()
+			io.WriteString(conn, reply)
+		}
+	}
+}
+
+// exec runs a data command with f.mu held and returns its encoded reply.
+func (f *fakeRedis) exec(args []string) string {
+	switch strings.ToUpper(args[0]) {
+	case "GET", "MGET":
+		var b strings.Builder
+		if args[0] == "MGET" {
+			fmt.Fprintf(&b, "*%d\r\n", len(args)-1)
+		}
+		for _, key := range args[1:] {
+			if value, ok := f.values[key]; ok {
+				fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(value), value)
+			} else {
+				b.WriteString("$-1\r\n")
+			}
+		}
+		return b.String()
+	case "SET":
+		f.values[args[1]] = args[2]
+		f.versions[args[1]]++
+		return "+OK\r\n"
+	case "INCR":
+		n, _ := strconv.Atoi(f.values[args[1]])
+		n++
+		f.values[args[1]] = strconv.Itoa(n)
+		f.versions[args[1]]++
+		return fmt.Sprintf(":%d\r\n", n)
+	}
+	return "-ERR unknown command\r\n"
+}
+
+// readRESPCommand reads a command sent as an array of bulk strings.
+func readRESPCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+	args := make([]string, n)
+	for i := range args {
+		if _, err := r.ReadString('\n'); err != nil {
+			return nil, err
+		}
+		arg, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		args[i] = strings.TrimSuffix(arg, "\r\n")
+	}
+	return args, nil
+}
+
+// TestRedisStateStore tests saving and loading state in Redis, and that concurrent saves by two instances are merged
+func TestRedisStateStore(t *testing.T) {
+	fake, addr := newFakeRedis(t, "secret")
+	config := RedisConfig{Address: addr, Password: "secret", Key: "ddns"}
+	store := sharedStateStore{kv: redisStore{config: config}, historySize: 10}
+
+	state, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.LastIP != "" || state.Records == nil || len(state.Records) != 0 {
+		t.Fatalf("expected an empty state, got %+v", state)
+	}
+
+	start := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	a := &State{
+		LastIP:       "203.0.113.2",
+		LastUpdated:  start,
+		Records:      map[string]string{"a.example.com/A": "203.0.113.2"},
+		RecordStatus: map[string]*RecordStatus{"a.example.com/A": {LastUpdated: start, LastVerified: start}},
+		History:      []IPChange{{Time: start, OldIP: "203.0.113.1", NewIP: "203.0.113.2", RecordsUpdated: 1}},
+	}
+	if err := store.Save(a); err != nil {
+		t.Fatal(err)
+	}
+	if a.revision != 1 {
+		t.Errorf("expected revision 1, got %d", a.revision)
+	}
+
+	// A second instance loaded the state before the first one saved again
+	b, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Records["a.example.com/A"] = "203.0.113.3"
+	a.RecordStatus["a.example.com/A"] = &RecordStatus{LastUpdated: start.Add(time.Hour), LastVerified: start.Add(time.Hour)}
+	a.History = append(a.History, IPChange{Time: start.Add(time.Hour), OldIP: "203.0.113.2", NewIP: "203.0.113.3", RecordsUpdated: 1})
+	a.LastIP, a.LastUpdated = "203.0.113.3", start.Add(time.Hour)
+	if err := store.Save(a); err != nil {
+		t.Fatal(err)
+	}
+
+	b.Records["b.example.com/A"] = "203.0.113.3"
+	b.RecordStatus["b.example.com/A"] = &RecordStatus{LastUpdated: start.Add(time.Hour), LastVerified: start.Add(time.Hour)}
+	b.History = append(b.History, IPChange{Time: start.Add(time.Hour + time.Minute), OldIP: "203.0.113.2", NewIP: "203.0.113.3", RecordsUpdated: 1})
+	b.LastIP = "203.0.113.3"
+	// Another save slips in between reading the revision and writing, once
+	saves := 0
+	fake.mu.Lock()
+	fake.onWatch = func() {
+		if saves++; saves == 1 {
+			fake.set("ddns:revision", "5")
+		}
+	}
+	fake.mu.Unlock()
+	if err := store.Save(b); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"a.example.com/A": "203.0.113.3", "b.example.com/A": "203.0.113.3"}
+	if fmt.Sprint(loaded.Records) != fmt.Sprint(want) {
+		t.Errorf("expected records %v, got %v", want, loaded.Records)
+	}
+	if len(loaded.History) != 2 || loaded.History[1].RecordsUpdated != 2 {
+		t.Errorf("expected the change seen by both instances counted once with both updates, got %+v", loaded.History)
+	}
+	if loaded.LastIP != "203.0.113.3" || !loaded.LastUpdated.Equal(start.Add(time.Hour)) {
+		t.Errorf("expected the last update kept, got %s at %s", loaded.LastIP, loaded.LastUpdated)
+	}
+	if b.revision != loaded.revision || fmt.Sprint(b.Records) != fmt.Sprint(want) {
+		t.Errorf("expected the saving instance to hold the merged state, got %+v", b)
+	}
+
+	wrong := sharedStateStore{kv: redisStore{config: RedisConfig{Address: addr, Password: "wrong", Key: "ddns"}}}
+	if _, err := wrong.Load(); err == nil || !strings.Contains(err.Error(), "WRONGPASS") {
+		t.Errorf("expected an authentication error, got %v", err)
+	}
+}
+
+// TestRedisStoreCancel tests that cancelling the context ends a call the server never answers
+func TestRedisStoreCancel(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close() // Read nothing and answer nothing
+		}
+	}()
+
+	store := redisStore{config: RedisConfig{Address: ln.Addr().String(), Key: "ddns"}}
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+	if _, _, err := store.get(ctx); err == nil {
+		t.Fatal("expected the cancelled call to fail")
+	}
+	if took := time.Since(start); took > sharedStateTimeout/2 {
+		t.Errorf("expected the call to end when cancelled, took %s", took)
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"os"
+	"slices"
+	"syscall"
+	"time"
+)
+
+// Config watching polls file contents rather than relying on inotify, so it
+// works on every platform and sees the symlink swaps Kubernetes uses to
+// update mounted ConfigMaps and Secrets.
+var (
+	watchInterval = 2 * time.Second // How often watched files are read
+	watchDebounce = time.Second     // How long files must stay unchanged before a reload
+)
+
+// fileFingerprint returns a digest of the contents of the files, and of
+// the names in any directories among them. Missing or unreadable files
+// contribute nothing, so their appearance counts as a change too.
+func fileFingerprint(paths []string) [sha256.Size]byte {
+	h := sha256.New()
+	for _, path := range paths {
+		h.Write([]byte(path))
+		if entries, err := os.ReadDir(path); err == nil {
+			// A directory changes when files are added or removed
+			for _, entry := range entries {
+				h.Write([]byte(entry.Name() + "\n"))
+			}
+			continue
+		}
+		data, _ := os.ReadFile(path)
+		h.Write(data)
+	}
+	var sum [sha256.Size]byte
+	h.Sum(sum[:0])
+	return sum
+}
+
+// watchedFiles returns the config file, the secret files it references,
+// the files it includes and its fragment directory and fragments.
+func (c *Config) watchedFiles() []string {
+	files := append([]string{c.configPath}, c.secretFiles...)
+	files = append(files, c.includeFiles...)
+	if c.confDir != "" {
+		files = append(files, c.confDir)
+		files = append(files, c.fragmentFiles...)
+	}
+	return files
+}
+
+// watchFiles polls paths until the context is cancelled and notifies
+// changed when their contents differ from the last notification (or from
+// loaded, their fingerprint when the config was read) and have then stayed
+// the same for debounce, so an editor writing a file in several steps
+// causes one reload.
+func watchFiles(ctx context.Context, paths []string, loaded [sha256.Size]byte, interval, debounce time.Duration, changed chan<- struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	notified := loaded
+	last, lastChange := fileFingerprint(paths), time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		current := fileFingerprint(paths)
+		if current != last {
+			last, lastChange = current, time.Now()
+			continue
+		}
+		if current != notified && time.Since(lastChange) >= debounce {
+			notified = current
+			select {
+			case changed <- struct{}{}:
+			default: // A reload is already pending
+			}
+		}
+	}
+}
+
+// runWithReload runs the updater until it fails or a signal other than
+// SIGHUP arrives. SIGHUP, and with watch_config a change to the config or
+// its secret files, loads a fresh updater; if that succeeds the running one
+// is shut down and replaced, otherwise it keeps running unchanged.
+func runWithReload(ctx context.Context, updater *DDNSUpdater, load func() (*DDNSUpdater, error), signals <-chan os.Signal) error {
+	for {
+		runCtx, stop := context.WithCancel(ctx)
+		g, gctx := newTaskGroup(runCtx)
+		done := make(chan error, 1)
+		changed := make(chan struct{}, 1)
+		g.Go(func() error {
+			done <- updater.Run(gctx)
+			return nil
+		})
+		if *updater.config.WatchConfig {
+			files, loaded := updater.config.watchedFiles(), updater.config.fingerprint
+			interval, debounce := watchInterval, watchDebounce
+			g.Go(func() error {
+				watchFiles(gctx, files, loaded, interval, debounce, changed)
+				return nil
+			})
+		}
+
+		next, err := awaitReload(ctx, updater, load, done, changed, signals)
+		stop()
+		g.Wait()
+		if next == nil {
+			return err
+		}
+		updater = next
+		updater.logger.Info("Configuration reloaded")
+	}
+}
+
+// awaitReload waits until the running updater exits, which ends the run,
+// or a reload is requested and the new configuration loads, in which case
+// it returns the new updater.
+func awaitReload(ctx context.Context, updater *DDNSUpdater, load func() (*DDNSUpdater, error), done <-chan error, changed <-chan struct{}, signals <-chan os.Signal) (*DDNSUpdater, error) {
+	for {
+		var reason string
+		select {
+		case err := <-done:
+			return nil, err
+		case <-ctx.Done():
+			return nil, <-done
+		case sig := <-signals:
+			if slices.Contains(checkNowSignals, sig) {
+				updater.logger.Info("Received signal", "signal", sig)
+				updater.RequestCheck()
+				continue
+			}
+			if sig != syscall.SIGHUP {
+				updater.logger.Info("Received signal", "signal", sig)
+				updater.notifySystemd(ctx, "STOPPING=1")
+				return nil, nil
+			}
+			reason = "SIGHUP"
+		case <-changed:
+			reason = "config file changed"
+		}
+
+		updater.logger.Info("Reloading configuration", "reason", reason)
+		// The new updater's Run reports it ready again
+		updater.notifySystemd(ctx, "RELOADING=1")
+		next, err := load()
+		if err != nil {
+			updater.logger.Error("Reload failed; keeping the current configuration", "error", err)
+			updater.notifySystemd(ctx, "READY=1\nSTATUS="+updater.systemdStatus())
+			continue
+		}
+		return next, nil
+	}
+}
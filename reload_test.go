@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// TestWatchFiles tests that a change is reported once, after the files settle
+func TestWatchFiles(t *testing.T) {
+	dir := t.TempDir()
+	config := filepath.Join(dir, "config.yaml")
+	secret := filepath.Join(dir, "secret")
+	os.WriteFile(config, []byte("a"), 0600)
+	paths := []string{config, secret}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changed := make(chan struct{}, 1)
+	go watchFiles(ctx, paths, fileFingerprint(paths), 5*time.Millisecond, 50*time.Millisecond, changed)
+
+	time.Sleep(20 * time.Millisecond)
+	os.WriteFile(secret, []byte("appeared"), 0600)
+	time.Sleep(20 * time.Millisecond)
+	os.WriteFile(config, []byte("b"), 0600)
+
+	select {
+	case <-changed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("change was not reported")
+	}
+	select {
+	case <-changed:
+		t.Error("expected a single notification for settled changes")
+	case <-time.After(150 * time.Millisecond):
+	}
+}
+
+// TestFileFingerprintDirectory tests that adding a file to a watched directory changes the fingerprint
+func TestFileFingerprintDirectory(t *testing.T) {
+	dir := t.TempDir()
+	before := fileFingerprint([]string{dir})
+	os.WriteFile(filepath.Join(dir, "new.yaml"), nil, 0600)
+	if fileFingerprint([]string{dir}) == before {
+		t.Error("expected the fingerprint to change")
+	}
+}
+
+// TestRunWithReload tests reloading on SIGHUP and file changes, keeping the old config when a reload fails, and stopping on SIGTERM
+func TestRunWithReload(t *testing.T) {
+	defer func(i, d time.Duration) { watchInterval, watchDebounce = i, d }(watchInterval, watchDebounce)
+	watchInterval, watchDebounce = 5*time.Millisecond, 10*time.Millisecond
+
+	server := httptest.NewServer(newFakeDreamhost())
+	defer server.Close()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	writeConfig := func(extra string) {
+		t.Helper()
+		data := "dreamhost_api_key: test-key\n" +
+			"dreamhost_api_base: " + server.URL + "\n" +
+			"state_path: " + filepath.Join(dir, "state.json") + "\n" +
+			"watch_config: true\n" +
+			"webhook:\n  disable_polling: true\n" + extra
+		if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeConfig("")
+
+	var loads, failures atomic.Int32
+	load := func() (*DDNSUpdater, error) {
+		loads.Add(1)
+		d, err := newDDNSUpdater(path, configOptions{}, io.Discard)
+		if err != nil {
+			failures.Add(1)
+		}
+		return d, err
+	}
+	updater, err := load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	signals := make(chan os.Signal, 1)
+	done := make(chan error, 1)
+	go func() { done <- runWithReload(context.Background(), updater, load, signals) }()
+
+	waitFor := func(what string, cond func() bool) {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for !cond() {
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for %s", what)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	signals <- syscall.SIGHUP
+	waitFor("reload on SIGHUP", func() bool { return loads.Load() == 2 })
+
+	writeConfig("check_interval: 1m\n")
+	waitFor("reload on file change", func() bool { return loads.Load() == 3 })
+
+	writeConfig("check_interval: soon\n")
+	waitFor("failed reload", func() bool { return failures.Load() == 1 })
+
+	signals <- syscall.SIGTERM
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("expected a clean stop, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("updater did not stop on SIGTERM")
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// remoteConfigClient fetches remote configs.
+var remoteConfigClient = &http.Client{Timeout: 30 * time.Second}
+
+// isRemoteConfig reports whether the config path is an HTTP(S) URL.
+func isRemoteConfig(path string) bool {
+	return strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://")
+}
+
+// remoteConfigMeta is stored beside a cached remote config to revalidate it.
+type remoteConfigMeta struct {
+	URL          string `json:"url"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+}
+
+// remoteConfigCache returns the files caching the config at rawURL in dir:
+// its contents and their remoteConfigMeta.
+func remoteConfigCache(dir, rawURL string) (data, meta string) {
+	sum := sha256.Sum256([]byte(rawURL))
+	base := filepath.Join(dir, hex.EncodeToString(sum[:8]))
+	return base + ".config", base + ".json"
+}
+
+// loadRemoteConfig loads the config at rawURL, which is fetched on every
+// load with a request conditional on the cached copy's ETag and
+// Last-Modified. A fetched config that loads replaces the cached copy. If
+// the fetch fails, or the fetched config doesn't load, the cached copy is
+// used instead and the problem kept in the config's remoteError, so a
+// device keeps running on its last good config while the server is
+// unreachable. Fragments aren't read and relative secret files are taken
+// relative to the working directory.
+func loadRemoteConfig(rawURL string, opts configOptions) (*Config, error) {
+	var err error
+	if opts.Format, err = remoteConfigFormat(rawURL, opts.Format); err != nil {
+		return nil, err
+	}
+	cachePath, metaPath := remoteConfigCache(cmp.Or(opts.CacheDir, DefaultConfigCacheDir), rawURL)
+	var meta remoteConfigMeta
+	cached, cacheErr := os.ReadFile(cachePath)
+	if cacheErr == nil {
+		if metaData, err := os.ReadFile(metaPath); err == nil {
+			json.Unmarshal(metaData, &meta)
+		}
+	}
+
+	data, meta, fetchErr := fetchConfigURL(rawURL, meta, cacheErr == nil)
+	if fetchErr == nil && data != nil {
+		config, err := loadFetchedConfig(cachePath, metaPath, data, meta, opts)
+		if err == nil || cacheErr != nil {
+			return remoteConfig(config, rawURL, nil, err)
+		}
+		fetchErr = fmt.Errorf("fetched config doesn't load: %w", err)
+	}
+	if fetchErr != nil && cacheErr != nil {
+		return nil, fetchErr
+	}
+	config, err := decodeConfigFile(cachePath, cached, opts)
+	return remoteConfig(config, rawURL, fetchErr, err)
+}
+
+// loadFetchedConfig loads a newly fetched config and, if it loads, makes it
+// the cached copy. It is loaded from a file beside the cached copy, which
+// SOPS can decrypt. The copy can hold secrets, so it is readable only by
+// the daemon's user.
+func loadFetchedConfig(cachePath, metaPath string, data []byte, meta remoteConfigMeta, opts configOptions) (*Config, error) {
+	if err := os.MkdirAll(filepath.Dir(cachePath), 0700); err != nil {
+		return nil, fmt.Errorf("caching remote config: %w", err)
+	}
+	tmp := cachePath + ".new"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return nil, fmt.Errorf("caching remote config: %w", err)
+	}
+	config, err := decodeConfigFile(tmp, data, opts)
+	if err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+	if err := os.Rename(tmp, cachePath); err != nil {
+		return nil, fmt.Errorf("caching remote config: %w", err)
+	}
+	metaData, err := json.Marshal(meta)
+	if err == nil {
+		err = os.WriteFile(metaPath, metaData, 0600)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("caching remote config: %w", err)
+	}
+	return config, nil
+}
+
+// remoteConfig records that config was loaded from rawURL, and why the
+// cached copy was used if fetchErr is set.
+func remoteConfig(config *Config, rawURL string, fetchErr, err error) (*Config, error) {
+	if err != nil {
+		return nil, err
+	}
+	config.configPath = rawURL
+	config.remoteError = fetchErr
+	config.fingerprint = fileFingerprint(config.watchedFiles())
+	return config, nil
+}
+
+// fetchConfigURL requests the config at rawURL, revalidating the cached
+// copy described by meta if there is one. It returns nil data when the
+// cached copy is still current.
+func fetchConfigURL(rawURL string, meta remoteConfigMeta, cached bool) ([]byte, remoteConfigMeta, error) {
+	req, err := http.NewRequest(http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, meta, err
+	}
+	req.Header.Set("User-Agent", "dh-ddns-updater/"+version)
+	if cached {
+		if meta.ETag != "" {
+			req.Header.Set("If-None-Match", meta.ETag)
+		}
+		if meta.LastModified != "" {
+			req.Header.Set("If-Modified-Since", meta.LastModified)
+		}
+	}
+
+	resp, err := remoteConfigClient.Do(req)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			urlErr.URL = redactConfigURL(urlErr.URL)
+		}
+		return nil, meta, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified && cached:
+		return nil, meta, nil
+	case resp.StatusCode != http.StatusOK:
+		return nil, meta, fmt.Errorf("fetching %s: %s", redactConfigURL(rawURL), resp.Status)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, meta, fmt.Errorf("fetching %s: %w", redactConfigURL(rawURL), err)
+	}
+	if len(data) == 0 {
+		return nil, meta, fmt.Errorf("fetching %s: empty response", redactConfigURL(rawURL))
+	}
+	return data, remoteConfigMeta{
+		URL:          redactConfigURL(rawURL),
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+	}, nil
+}
+
+// remoteConfigFormat returns the format of a remote config: format if set,
+// otherwise the one the URL path's extension implies.
+func remoteConfigFormat(rawURL, format string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	return configFormat(u.Path, format)
+}
+
+// redactConfigURL returns a config URL with its password and secret query
+// parameters replaced, for logs and the cache metadata.
+func redactConfigURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), redacted)
+	}
+	return redactURL(u)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// DefaultRepeatedErrorInterval is how often an error that keeps recurring
+// is logged again unless repeated_error_interval says otherwise.
+const DefaultRepeatedErrorInterval = time.Hour
+
+// errorRepeats keeps a failure that recurs every cycle, such as an API
+// that is down, from flooding the logs. The first occurrence of an error
+// is logged; identical ones after it are only counted, and logged again
+// with the count once per interval. When the failure clears, that is
+// logged once too. Failures are identified by a key naming what failed,
+// such as a stage or a record.
+//
+// A nil *errorRepeats logs every error and no recoveries.
+type errorRepeats struct {
+	interval time.Duration
+	now      func() time.Time // For tests; nil means time.Now
+
+	mu      sync.Mutex
+	failing map[string]*repeatedError // By key
+}
+
+// repeatedError is the failure last logged for a key.
+type repeatedError struct {
+	err        string    // The error's text, which identifies a repeat
+	since      time.Time // When the failure began
+	lastLogged time.Time
+	count      int // Occurrences since the failure began
+	suppressed int // Occurrences not logged since lastLogged
+}
+
+func newErrorRepeats(interval time.Duration) *errorRepeats {
+	return &errorRepeats{interval: interval, failing: make(map[string]*repeatedError)}
+}
+
+func (r *errorRepeats) time() time.Time {
+	if r.now != nil {
+		return r.now()
+	}
+	return time.Now()
+}
+
+// Error logs msg with args at error level for a failure of key with the
+// given error text, unless it repeats the error last logged for key less
+// than the interval ago. A repeat logged after the interval carries how
+// many times the error repeated since it was last logged, and since when
+// it has been failing.
+func (r *errorRepeats) Error(ctx context.Context, logger *slog.Logger, key, err, msg string, args ...any) {
+	if r == nil {
+		logger.ErrorContext(ctx, msg, args...)
+		return
+	}
+	r.mu.Lock()
+	now := r.time()
+	f := r.failing[key]
+	switch {
+	case f == nil:
+		f = &repeatedError{err: err, since: now, lastLogged: now}
+		r.failing[key] = f
+	case f.err != err:
+		// A different error is news, though the failure goes on
+		f.err, f.lastLogged, f.suppressed = err, now, 0
+	case now.Sub(f.lastLogged) < r.interval:
+		f.count++
+		f.suppressed++
+		r.mu.Unlock()
+		return
+	default:
+		args = append(args, "repeated", f.suppressed+1, "failing_since", f.since)
+		f.lastLogged, f.suppressed = now, 0
+	}
+	f.count++
+	r.mu.Unlock()
+	logger.ErrorContext(ctx, msg, args...)
+}
+
+// Recovered logs msg with args at info level if key was failing, with how
+// many times and for how long, and forgets the failure.
+func (r *errorRepeats) Recovered(ctx context.Context, logger *slog.Logger, key, msg string, args ...any) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	f := r.failing[key]
+	delete(r.failing, key)
+	now := r.time()
+	r.mu.Unlock()
+	if f == nil {
+		return
+	}
+	args = append(args, "failures", f.count, "failed_for", now.Sub(f.since).Round(time.Second))
+	logger.InfoContext(ctx, msg, args...)
+}
+
+// recordRepeatKey is the errorRepeats key of a record's failures.
+func recordRepeatKey(domain DomainConfig) string {
+	return "record:" + domain.FQDN() + "/" + domain.Type
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+// TestErrorRepeats tests that a recurring error is logged once, then summarized once per interval, and its recovery logged
+func TestErrorRepeats(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+	now := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	r := newErrorRepeats(time.Hour)
+	r.now = func() time.Time { return now }
+
+	fail := func(err string) {
+		r.Error(context.Background(), logger, "stage:detection", err, "Pipeline stage failed", "stage", "detection", "error", err)
+		now = now.Add(5 * time.Minute)
+	}
+	fail("getting current IP: timeout")
+	for range 11 {
+		fail("getting current IP: timeout")
+	}
+	fail("getting current IP: timeout") // An hour after the first
+	fail("getting current IP: no route to host")
+	fail("getting current IP: no route to host")
+	r.Recovered(context.Background(), logger, "stage:detection", "Pipeline stage recovered", "stage", "detection")
+	r.Recovered(context.Background(), logger, "stage:detection", "Pipeline stage recovered", "stage", "detection")
+	r.Recovered(context.Background(), logger, "record:home.example.com/A", "DNS record recovered")
+	fail("getting current IP: timeout")
+
+	var lines []map[string]any
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var line map[string]any
+		if err := dec.Decode(&line); err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) != 5 {
+		t.Fatalf("expected 5 lines, got %d: %v", len(lines), lines)
+	}
+	if lines[0]["error"] != "getting current IP: timeout" || lines[0]["repeated"] != nil {
+		t.Errorf("expected the first occurrence logged as is, got %v", lines[0])
+	}
+	if lines[1]["repeated"] != 12.0 || lines[1]["failing_since"] != "2026-10-01T12:00:00Z" {
+		t.Errorf("expected the repeats summarized after an hour, got %v", lines[1])
+	}
+	if lines[2]["error"] != "getting current IP: no route to host" || lines[2]["repeated"] != nil {
+		t.Errorf("expected a different error logged at once, got %v", lines[2])
+	}
+	if lines[3]["msg"] != "Pipeline stage recovered" || lines[3]["level"] != "INFO" || lines[3]["failures"] != 15.0 || lines[3]["failed_for"] != float64(75*time.Minute) {
+		t.Errorf("expected the recovery logged with the failures, got %v", lines[3])
+	}
+	if lines[4]["msg"] != "Pipeline stage failed" || lines[4]["repeated"] != nil {
+		t.Errorf("expected a failure after recovering logged at once, got %v", lines[4])
+	}
+}
+
+// TestErrorRepeatsNil tests that without errorRepeats every error is logged
+func TestErrorRepeatsNil(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+	var r *errorRepeats
+	r.Error(context.Background(), logger, "stage:detection", "timeout", "Pipeline stage failed")
+	r.Error(context.Background(), logger, "stage:detection", "timeout", "Pipeline stage failed")
+	r.Recovered(context.Background(), logger, "stage:detection", "Pipeline stage recovered")
+	if n := bytes.Count(buf.Bytes(), []byte("\n")); n != 2 {
+		t.Errorf("expected both errors and no recovery logged, got %d lines: %s", n, buf.String())
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// dohTimeout bounds a DNS-over-HTTPS query, as the resolver's own timeout
+// bounds a query over DNS.
+const dohTimeout = 10 * time.Second
+
+// parseResolver parses an http.resolver setting: the IP address of a DNS
+// server, with an optional port (default 53), or the https:// URL of a
+// DNS-over-HTTPS endpoint. The returned address is host:port for a DNS
+// server, or "" for DNS over HTTPS.
+func parseResolver(resolver string) (addr string, doh *url.URL, err error) {
+	if strings.HasPrefix(resolver, "https://") {
+		u, err := url.Parse(resolver)
+		if err != nil {
+			return "", nil, fmt.Errorf("resolver: %w", err)
+		}
+		if u.Host == "" {
+			return "", nil, fmt.Errorf("resolver %q has no host", resolver)
+		}
+		return "", u, nil
+	}
+	host, port, err := net.SplitHostPort(resolver)
+	if err != nil {
+		host, port = strings.Trim(resolver, "[]"), "53"
+	}
+	// A name would have to be resolved by the resolver it stands in for
+	if net.ParseIP(host) == nil {
+		return "", nil, fmt.Errorf("resolver %q must be an IP address, optionally with a port, or an https:// DNS-over-HTTPS URL", resolver)
+	}
+	return net.JoinHostPort(host, port), nil, nil
+}
+
+// newResolver returns a resolver sending every query to resolver, which
+// parseResolver accepts, instead of the servers the host is configured
+// with. DNS-over-HTTPS queries are made with tlsConfig, and the endpoint's
+// own name, if it isn't an IP address, is looked up by the host.
+func newResolver(resolver string, tlsConfig *tls.Config) (*net.Resolver, error) {
+	addr, doh, err := parseResolver(resolver)
+	if err != nil {
+		return nil, err
+	}
+	if doh == nil {
+		return &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, addr)
+			},
+		}, nil
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	client := &http.Client{Timeout: dohTimeout, Transport: transport}
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return &dohConn{ctx: ctx, client: client, url: doh.String()}, nil
+		},
+	}, nil
+}
+
+// dohConn carries the Go resolver's queries over DNS over HTTPS (RFC 8484).
+// The resolver treats it as a TCP connection, writing each query and
+// reading each response with a two-byte length prefix; every query is
+// POSTed to the endpoint as it is written.
+type dohConn struct {
+	ctx    context.Context
+	client *http.Client
+	url    string
+
+	query    []byte // Written so far, with its length prefix
+	response bytes.Buffer
+}
+
+func (c *dohConn) Write(b []byte) (int, error) {
+	c.query = append(c.query, b...)
+	for len(c.query) >= 2 {
+		n := int(binary.BigEndian.Uint16(c.query))
+		if len(c.query) < 2+n {
+			break
+		}
+		resp, err := c.exchange(c.query[2 : 2+n])
+		if err != nil {
+			return 0, err
+		}
+		c.response.Write(binary.BigEndian.AppendUint16(nil, uint16(len(resp))))
+		c.response.Write(resp)
+		c.query = c.query[2+n:]
+	}
+	return len(b), nil
+}
+
+// exchange sends one query to the endpoint and returns its response.
+func (c *dohConn) exchange(query []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(c.ctx, http.MethodPost, c.url, bytes.NewReader(query))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("DNS over HTTPS: HTTP %d from %s", resp.StatusCode, req.URL.Host)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 65535+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > 65535 {
+		return nil, errors.New("DNS over HTTPS: response too large")
+	}
+	return body, nil
+}
+
+func (c *dohConn) Read(b []byte) (int, error) {
+	if c.response.Len() == 0 {
+		return 0, io.EOF
+	}
+	return c.response.Read(b)
+}
+
+func (c *dohConn) Close() error                       { return nil }
+func (c *dohConn) LocalAddr() net.Addr                { return dohAddr{} }
+func (c *dohConn) RemoteAddr() net.Addr               { return dohAddr{} }
+func (c *dohConn) SetDeadline(t time.Time) error      { return nil }
+func (c *dohConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *dohConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// dohAddr is the address of both ends of a dohConn, which has none.
+type dohAddr struct{}
+
+func (dohAddr) Network() string { return "doh" }
+func (dohAddr) String() string  { return "doh" }
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestParseResolver tests the accepted forms of http.resolver
+func TestParseResolver(t *testing.T) {
+	tests := []struct {
+		resolver string
+		wantAddr string
+		wantDoH  bool
+		wantErr  bool
+	}{
+		{resolver: "9.9.9.9", wantAddr: "9.9.9.9:53"},
+		{resolver: "9.9.9.9:5353", wantAddr: "9.9.9.9:5353"},
+		{resolver: "2620:fe::fe", wantAddr: "[2620:fe::fe]:53"},
+		{resolver: "[2620:fe::fe]:53", wantAddr: "[2620:fe::fe]:53"},
+		{resolver: "https://9.9.9.9/dns-query", wantDoH: true},
+		{resolver: "dns.quad9.net", wantErr: true},
+		{resolver: "https:///dns-query", wantErr: true},
+	}
+	for _, tt := range tests {
+		addr, doh, err := parseResolver(tt.resolver)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseResolver(%q) error = %v, want error %v", tt.resolver, err, tt.wantErr)
+			continue
+		}
+		if addr != tt.wantAddr || (doh != nil) != tt.wantDoH {
+			t.Errorf("parseResolver(%q) = %q, %v; want %q, DoH %v", tt.resolver, addr, doh, tt.wantAddr, tt.wantDoH)
+		}
+	}
+}
+
+// answerLocalhost answers A queries for any name with 127.0.0.1.
+func answerLocalhost(msg []byte) []byte {
+	if len(msg) < dnsHeaderLen {
+		return nil
+	}
+	q, err := parseDNSQuestion(msg[dnsHeaderLen:])
+	if err != nil {
+		return nil
+	}
+	var answers []net.IP
+	if q.Type == dnsTypeA {
+		answers = []net.IP{net.IPv4(127, 0, 0, 1)}
+	}
+	return buildDNSResponse(binary.BigEndian.Uint16(msg), binary.BigEndian.Uint16(msg[2:])&0x0100, dnsRcodeSuccess, &q, answers, 60)
+}
+
+// TestResolver tests lookups through a DNS server and a DNS-over-HTTPS
+// endpoint, and that the HTTP client connects to the address they resolve
+func TestResolver(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	go serveDNSUDP(pc, answerLocalhost)
+
+	doh := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/dns-message" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		query, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/dns-message")
+		w.Write(answerLocalhost(query))
+	}))
+	defer doh.Close()
+	roots := x509.NewCertPool()
+	roots.AddCert(doh.Certificate())
+
+	for _, resolver := range []string{pc.LocalAddr().String(), doh.URL + "/dns-query"} {
+		r, err := newResolver(resolver, &tls.Config{RootCAs: roots})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		addrs, err := r.LookupHost(ctx, "ipinfo.example")
+		cancel()
+		if err != nil || len(addrs) != 1 || addrs[0] != "127.0.0.1" {
+			t.Errorf("%s: LookupHost = %v, %v; want [127.0.0.1]", resolver, addrs, err)
+		}
+	}
+
+	ipService := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "203.0.113.42")
+	}))
+	defer ipService.Close()
+	client, err := newHTTPClient(HTTPConfig{Timeout: 5 * time.Second, Resolver: pc.LocalAddr().String()}, "tcp4", slog.New(slog.NewJSONHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, port, _ := net.SplitHostPort(strings.TrimPrefix(ipService.URL, "http://"))
+	resp, err := client.Get("http://ipinfo.example:" + port + "/")
+	if err != nil {
+		t.Fatalf("request through the resolver failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "203.0.113.42" {
+		t.Errorf("got %q from the IP service", body)
+	}
+}
@@ -0,0 +1,709 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RFC2136Config configures the listener accepting standard dynamic DNS
+// UPDATE messages (RFC 2136) and translating them into provider API calls.
+type RFC2136Config struct {
+	Listen string          `yaml:"listen"` // UDP and TCP listen address (e.g. ":5353"); empty disables the listener
+	Keys   []TSIGKeyConfig `yaml:"keys"`   // TSIG keys accepted; every update must be signed with one
+}
+
+// TSIGKeyConfig is a shared TSIG secret and the names it may update.
+type TSIGKeyConfig struct {
+	Name       string   `yaml:"name"`        // Key name, as configured in the client (e.g. "dhcp-key")
+	Algorithm  string   `yaml:"algorithm"`   // hmac-sha256 (default), hmac-sha512 or hmac-sha1
+	Secret     string   `yaml:"secret"`      // Base64-encoded secret
+	SecretFile string   `yaml:"secret_file"` // File holding secret instead
+	Names      []string `yaml:"names"`       // Names the key may update; "*.zone" matches any name below zone
+	Provider   string   `yaml:"provider"`    // Provider hosting the zones (default "dreamhost")
+}
+
+// DNS UPDATE and TSIG wire-format constants
+const (
+	dnsOpcodeUpdate = 5
+	dnsTypeCNAME    = 5
+	dnsTypeSOA      = 6
+	dnsTypeMX       = 15
+	dnsTypeTXT      = 16
+	dnsTypeSRV      = 33
+	dnsTypeCAA      = 257
+	dnsTypeTSIG     = 250
+	dnsClassNONE    = 254
+	dnsClassANY     = 255
+
+	dnsRcodeServFail = 2
+	dnsRcodeNotAuth  = 9
+	dnsRcodeNotZone  = 10
+
+	tsigErrBadSig  = 16
+	tsigErrBadKey  = 17
+	tsigErrBadTime = 18
+
+	tsigFudge = 300 // Allowed clock skew in seconds
+)
+
+// dnsTypeNames maps the record types a provider supports to their codes.
+var dnsTypeNames = map[uint16]string{
+	dnsTypeA:     "A",
+	dnsTypeAAAA:  "AAAA",
+	dnsTypeCNAME: "CNAME",
+	dnsTypeMX:    "MX",
+	dnsTypeTXT:   "TXT",
+	dnsTypeSRV:   "SRV",
+	dnsTypeCAA:   "CAA",
+}
+
+// tsigKey is a parsed TSIG key.
+type tsigKey struct {
+	name      string // Lower case, without the trailing dot
+	algorithm string // Lower case, without the trailing dot
+	secret    []byte
+	hash      func() hash.Hash
+	names     []string
+	provider  string
+}
+
+var tsigAlgorithms = map[string]func() hash.Hash{
+	"hmac-sha256": sha256.New,
+	"hmac-sha512": sha512.New,
+	"hmac-sha1":   sha1.New,
+}
+
+// newTSIGKey parses a configured key.
+func newTSIGKey(kc TSIGKeyConfig) (*tsigKey, error) {
+	if kc.Name == "" {
+		return nil, errors.New("key name is required")
+	}
+	algorithm := strings.ToLower(strings.TrimSuffix(kc.Algorithm, "."))
+	if algorithm == "" {
+		algorithm = "hmac-sha256"
+	}
+	h, ok := tsigAlgorithms[algorithm]
+	if !ok {
+		return nil, fmt.Errorf("key %s: unsupported algorithm %q", kc.Name, kc.Algorithm)
+	}
+	secret, err := base64.StdEncoding.DecodeString(kc.Secret)
+	if err != nil || len(secret) == 0 {
+		return nil, fmt.Errorf("key %s: secret must be non-empty base64", kc.Name)
+	}
+	if len(kc.Names) == 0 {
+		return nil, fmt.Errorf("key %s: at least one name is required", kc.Name)
+	}
+	provider := kc.Provider
+	if provider == "" {
+		provider = DefaultProvider
+	}
+	return &tsigKey{
+		name:      strings.ToLower(strings.TrimSuffix(kc.Name, ".")),
+		algorithm: algorithm,
+		secret:    secret,
+		hash:      h,
+		names:     kc.Names,
+		provider:  provider,
+	}, nil
+}
+
+// allows reports whether the key may update name.
+func (k *tsigKey) allows(name string) bool {
+	for _, pattern := range k.names {
+		pattern = strings.ToLower(strings.TrimSuffix(pattern, "."))
+		if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+			if strings.HasSuffix(name, "."+suffix) {
+				return true
+			}
+		} else if name == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// mac computes the TSIG MAC (RFC 8945 section 4.3) of msg, which must not
+// contain the TSIG record. requestMAC is set when signing a response.
+func (k *tsigKey) mac(requestMAC, msg []byte, timeSigned uint64, tsigErr uint16, other []byte) []byte {
+	h := hmac.New(k.hash, k.secret)
+	if requestMAC != nil {
+		h.Write(binary.BigEndian.AppendUint16(nil, uint16(len(requestMAC))))
+		h.Write(requestMAC)
+	}
+	h.Write(msg)
+
+	vars := appendDNSName(nil, k.name)
+	vars = binary.BigEndian.AppendUint16(vars, dnsClassANY)
+	vars = binary.BigEndian.AppendUint32(vars, 0)
+	vars = appendDNSName(vars, k.algorithm)
+	vars = appendUint48(vars, timeSigned)
+	vars = binary.BigEndian.AppendUint16(vars, tsigFudge)
+	vars = binary.BigEndian.AppendUint16(vars, tsigErr)
+	vars = binary.BigEndian.AppendUint16(vars, uint16(len(other)))
+	vars = append(vars, other...)
+	h.Write(vars)
+
+	return h.Sum(nil)
+}
+
+// sign appends a TSIG record to msg, signed at now, and returns the
+// message and the MAC.
+func (k *tsigKey) sign(msg, requestMAC []byte, now time.Time, tsigErr uint16, other []byte) ([]byte, []byte) {
+	timeSigned := uint64(now.Unix())
+	mac := k.mac(requestMAC, msg, timeSigned, tsigErr, other)
+	return appendTSIG(msg, k.name, k.algorithm, mac, timeSigned, tsigErr, other), mac
+}
+
+// appendTSIG appends a TSIG record carrying mac to msg and counts it in
+// ARCOUNT. The original ID is the message's own ID.
+func appendTSIG(msg []byte, keyName, algorithm string, mac []byte, timeSigned uint64, tsigErr uint16, other []byte) []byte {
+	rdata := appendDNSName(nil, algorithm)
+	rdata = appendUint48(rdata, timeSigned)
+	rdata = binary.BigEndian.AppendUint16(rdata, tsigFudge)
+	rdata = binary.BigEndian.AppendUint16(rdata, uint16(len(mac)))
+	rdata = append(rdata, mac...)
+	rdata = append(rdata, msg[0:2]...)
+	rdata = binary.BigEndian.AppendUint16(rdata, tsigErr)
+	rdata = binary.BigEndian.AppendUint16(rdata, uint16(len(other)))
+	rdata = append(rdata, other...)
+
+	out := append([]byte(nil), msg...)
+	binary.BigEndian.PutUint16(out[10:12], binary.BigEndian.Uint16(out[10:12])+1)
+	out = appendDNSName(out, keyName)
+	out = binary.BigEndian.AppendUint16(out, dnsTypeTSIG)
+	out = binary.BigEndian.AppendUint16(out, dnsClassANY)
+	out = binary.BigEndian.AppendUint32(out, 0)
+	out = binary.BigEndian.AppendUint16(out, uint16(len(rdata)))
+	return append(out, rdata...)
+}
+
+func appendUint48(b []byte, v uint64) []byte {
+	return append(b, byte(v>>40), byte(v>>32), byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+// appendDNSName appends name in uncompressed wire form.
+func appendDNSName(b []byte, name string) []byte {
+	name = strings.TrimSuffix(name, ".")
+	if name != "" {
+		for _, label := range strings.Split(name, ".") {
+			b = append(b, byte(len(label)))
+			b = append(b, label...)
+		}
+	}
+	return append(b, 0)
+}
+
+// readDNSName reads a possibly compressed name starting at off and returns
+// it in lower case without the trailing dot, plus the offset following it.
+func readDNSName(msg []byte, off int) (string, int, error) {
+	var labels []string
+	next := -1
+	for jumps := 0; ; {
+		if off >= len(msg) {
+			return "", 0, errors.New("truncated name")
+		}
+		l := int(msg[off])
+		switch {
+		case l == 0:
+			if next < 0 {
+				next = off + 1
+			}
+			return strings.ToLower(strings.Join(labels, ".")), next, nil
+		case l&0xC0 == 0xC0:
+			if off+1 >= len(msg) {
+				return "", 0, errors.New("truncated pointer")
+			}
+			if jumps++; jumps > 16 {
+				return "", 0, errors.New("compression loop")
+			}
+			if next < 0 {
+				next = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(msg[off:off+2]) & 0x3FFF)
+		case l&0xC0 != 0:
+			return "", 0, errors.New("unsupported label")
+		default:
+			if off+1+l > len(msg) {
+				return "", 0, errors.New("truncated label")
+			}
+			labels = append(labels, string(msg[off+1:off+1+l]))
+			off += 1 + l
+		}
+	}
+}
+
+// dnsRR is a resource record parsed from a message.
+type dnsRR struct {
+	Name    string
+	Type    uint16
+	Class   uint16
+	TTL     uint32
+	Data    []byte
+	dataOff int // Offset of Data in the message, for decompressing names in it
+}
+
+// parseDNSRR parses the record starting at off and returns the offset
+// following it.
+func parseDNSRR(msg []byte, off int) (dnsRR, int, error) {
+	name, off, err := readDNSName(msg, off)
+	if err != nil {
+		return dnsRR{}, 0, err
+	}
+	if off+10 > len(msg) {
+		return dnsRR{}, 0, errors.New("truncated record")
+	}
+	rr := dnsRR{
+		Name:  name,
+		Type:  binary.BigEndian.Uint16(msg[off:]),
+		Class: binary.BigEndian.Uint16(msg[off+2:]),
+		TTL:   binary.BigEndian.Uint32(msg[off+4:]),
+	}
+	length := int(binary.BigEndian.Uint16(msg[off+8:]))
+	off += 10
+	if off+length > len(msg) {
+		return dnsRR{}, 0, errors.New("truncated record data")
+	}
+	rr.Data = msg[off : off+length]
+	rr.dataOff = off
+	return rr, off + length, nil
+}
+
+// recordValue renders the record's data in the presentation form the
+// provider API uses.
+func (rr dnsRR) recordValue(msg []byte) (string, error) {
+	name := func(off int) (string, error) {
+		n, _, err := readDNSName(msg, off)
+		return n + ".", err
+	}
+	d := rr.Data
+	switch rr.Type {
+	case dnsTypeA:
+		if len(d) != 4 {
+			return "", errors.New("bad A record")
+		}
+		return net.IP(d).String(), nil
+	case dnsTypeAAAA:
+		if len(d) != 16 {
+			return "", errors.New("bad AAAA record")
+		}
+		return net.IP(d).String(), nil
+	case dnsTypeCNAME:
+		return name(rr.dataOff)
+	case dnsTypeMX:
+		if len(d) < 3 {
+			return "", errors.New("bad MX record")
+		}
+		target, err := name(rr.dataOff + 2)
+		return fmt.Sprintf("%d %s", binary.BigEndian.Uint16(d), target), err
+	case dnsTypeSRV:
+		if len(d) < 7 {
+			return "", errors.New("bad SRV record")
+		}
+		target, err := name(rr.dataOff + 6)
+		return fmt.Sprintf("%d %d %d %s", binary.BigEndian.Uint16(d), binary.BigEndian.Uint16(d[2:]), binary.BigEndian.Uint16(d[4:]), target), err
+	case dnsTypeTXT:
+		var sb strings.Builder
+		for i := 0; i < len(d); {
+			l := int(d[i])
+			if i+1+l > len(d) {
+				return "", errors.New("bad TXT record")
+			}
+			sb.Write(d[i+1 : i+1+l])
+			i += 1 + l
+		}
+		return sb.String(), nil
+	case dnsTypeCAA:
+		if len(d) < 2 || 2+int(d[1]) > len(d) {
+			return "", errors.New("bad CAA record")
+		}
+		tag := string(d[2 : 2+int(d[1])])
+		return fmt.Sprintf("%d %s %q", d[0], tag, string(d[2+int(d[1]):])), nil
+	}
+	return "", fmt.Errorf("unsupported record type %d", rr.Type)
+}
+
+// rfc2136Server accepts TSIG-signed DNS UPDATE messages and applies them
+// through a provider.
+type rfc2136Server struct {
+	updater *DDNSUpdater
+	keys    map[string]*tsigKey
+	now     func() time.Time
+
+	locks map[string]*sync.Mutex // Per provider, serializes its updates so they apply in arrival order
+}
+
+// newRFC2136Server validates the listener's keys against the providers.
+func newRFC2136Server(d *DDNSUpdater) (*rfc2136Server, error) {
+	if len(d.config.RFC2136.Keys) == 0 {
+		return nil, errors.New("rfc2136: at least one TSIG key is required")
+	}
+	s := &rfc2136Server{updater: d, keys: make(map[string]*tsigKey), now: time.Now, locks: make(map[string]*sync.Mutex)}
+	for _, kc := range d.config.RFC2136.Keys {
+		key, err := newTSIGKey(kc)
+		if err != nil {
+			return nil, fmt.Errorf("rfc2136: %w", err)
+		}
+		if _, ok := d.providers[key.provider]; !ok {
+			return nil, fmt.Errorf("rfc2136: key %s: unknown provider %q", kc.Name, key.provider)
+		}
+		s.keys[key.name] = key
+		s.locks[key.provider] = &sync.Mutex{}
+	}
+	return s, nil
+}
+
+// Serve accepts updates on UDP and TCP until the context is cancelled.
+func (s *rfc2136Server) Serve(ctx context.Context, addr string) error {
+	return serveDNS(ctx, addr, func(msg []byte) []byte {
+		return s.respond(ctx, msg)
+	}, func() {
+		s.updater.logger.Info("RFC 2136 update listener listening", "address", addr)
+	})
+}
+
+// dnsUpdate is a parsed UPDATE message.
+type dnsUpdate struct {
+	id        uint16
+	zone      string
+	zoneRaw   []byte // Zone section, echoed in the response
+	prereqs   int
+	updates   []dnsRR
+	tsig      *dnsRR
+	tsigStart int // Offset of the TSIG record
+}
+
+// parseDNSUpdate parses an UPDATE message.
+func parseDNSUpdate(msg []byte) (*dnsUpdate, error) {
+	u := &dnsUpdate{id: binary.BigEndian.Uint16(msg[0:2])}
+	zocount := binary.BigEndian.Uint16(msg[4:6])
+	u.prereqs = int(binary.BigEndian.Uint16(msg[6:8]))
+	upcount := int(binary.BigEndian.Uint16(msg[8:10]))
+	adcount := int(binary.BigEndian.Uint16(msg[10:12]))
+	if zocount != 1 {
+		return nil, errors.New("zone section must hold exactly one zone")
+	}
+
+	zone, off, err := readDNSName(msg, dnsHeaderLen)
+	if err != nil || off+4 > len(msg) {
+		return nil, errors.New("bad zone section")
+	}
+	if binary.BigEndian.Uint16(msg[off:]) != dnsTypeSOA {
+		return nil, errors.New("zone section must be of type SOA")
+	}
+	u.zone = zone
+	u.zoneRaw = msg[dnsHeaderLen : off+4]
+	off += 4
+
+	for i := 0; i < u.prereqs+upcount+adcount; i++ {
+		start := off
+		rr, next, err := parseDNSRR(msg, off)
+		if err != nil {
+			return nil, err
+		}
+		off = next
+		switch {
+		case i < u.prereqs:
+		case i < u.prereqs+upcount:
+			u.updates = append(u.updates, rr)
+		case rr.Type == dnsTypeTSIG:
+			if i != u.prereqs+upcount+adcount-1 {
+				return nil, errors.New("TSIG must be the last record")
+			}
+			u.tsig = &rr
+			u.tsigStart = start
+		}
+	}
+	return u, nil
+}
+
+// respond processes one message and returns the response, or nil to drop it.
+func (s *rfc2136Server) respond(ctx context.Context, msg []byte) []byte {
+	if len(msg) < dnsHeaderLen {
+		return nil
+	}
+	flags := binary.BigEndian.Uint16(msg[2:4])
+	if flags&0x8000 != 0 { // Not a request
+		return nil
+	}
+	id := binary.BigEndian.Uint16(msg[0:2])
+	logger := s.updater.logger
+
+	if (flags>>11)&0xF != dnsOpcodeUpdate {
+		return buildUpdateResponse(id, dnsRcodeNotImp, nil)
+	}
+	u, err := parseDNSUpdate(msg)
+	if err != nil {
+		logger.Warn("Rejected malformed DNS UPDATE", "error", err)
+		return buildUpdateResponse(id, dnsRcodeFormErr, nil)
+	}
+	if u.tsig == nil {
+		logger.Warn("Rejected unsigned DNS UPDATE", "zone", u.zone)
+		return buildUpdateResponse(id, dnsRcodeRefused, u.zoneRaw)
+	}
+
+	key, requestMAC, tsigErr := s.verify(msg, u)
+	if tsigErr != 0 {
+		logger.Warn("Rejected DNS UPDATE with bad TSIG", "zone", u.zone, "key", u.tsig.Name, "tsig_error", tsigErr)
+		resp := buildUpdateResponse(id, dnsRcodeNotAuth, u.zoneRaw)
+		if tsigErr == tsigErrBadTime {
+			other := appendUint48(nil, uint64(s.now().Unix()))
+			resp, _ = key.sign(resp, requestMAC, s.now(), tsigErr, other)
+			return resp
+		}
+		// Without a verified key the response can't be signed
+		return appendTSIG(resp, u.tsig.Name, tsigAlgorithmName(msg, u.tsig), nil, uint64(s.now().Unix()), tsigErr, nil)
+	}
+
+	rcode := s.update(ctx, msg, u, key)
+	resp, _ := key.sign(buildUpdateResponse(id, rcode, u.zoneRaw), requestMAC, s.now(), 0, nil)
+	return resp
+}
+
+// verify checks the message's TSIG. It returns the key, the request MAC
+// and a TSIG error code (0 if the signature is valid).
+func (s *rfc2136Server) verify(msg []byte, u *dnsUpdate) (*tsigKey, []byte, uint16) {
+	key, ok := s.keys[u.tsig.Name]
+	if !ok || tsigAlgorithmName(msg, u.tsig) != key.algorithm {
+		return nil, nil, tsigErrBadKey
+	}
+
+	_, off, err := readDNSName(msg, u.tsig.dataOff)
+	if err != nil || off+10 > len(msg) {
+		return nil, nil, tsigErrBadSig
+	}
+	timeSigned := uint64(msg[off])<<40 | uint64(msg[off+1])<<32 | uint64(binary.BigEndian.Uint32(msg[off+2:]))
+	fudge := binary.BigEndian.Uint16(msg[off+6:])
+	macLen := int(binary.BigEndian.Uint16(msg[off+8:]))
+	off += 10
+	if off+macLen+6 > len(msg) {
+		return nil, nil, tsigErrBadSig
+	}
+	mac := msg[off : off+macLen]
+	origID := msg[off+macLen : off+macLen+2]
+	off += macLen + 4
+	otherLen := int(binary.BigEndian.Uint16(msg[off:]))
+	if off+2+otherLen > len(msg) {
+		return nil, nil, tsigErrBadSig
+	}
+	other := msg[off+2 : off+2+otherLen]
+
+	// The MAC covers the message as it was before the TSIG was added
+	unsigned := append([]byte(nil), msg[:u.tsigStart]...)
+	copy(unsigned[0:2], origID)
+	binary.BigEndian.PutUint16(unsigned[10:12], binary.BigEndian.Uint16(unsigned[10:12])-1)
+
+	expected := key.mac(nil, unsigned, timeSigned, 0, other)
+	if !hmac.Equal(mac, expected) {
+		return nil, nil, tsigErrBadSig
+	}
+
+	now := uint64(s.now().Unix())
+	if now > timeSigned+uint64(fudge) || timeSigned > now+uint64(fudge) {
+		return key, mac, tsigErrBadTime
+	}
+	return key, mac, 0
+}
+
+// tsigAlgorithmName returns the algorithm named in a TSIG record.
+func tsigAlgorithmName(msg []byte, tsig *dnsRR) string {
+	name, _, err := readDNSName(msg, tsig.dataOff)
+	if err != nil {
+		return ""
+	}
+	return name
+}
+
+// rrChange is one validated change from the update section.
+type rrChange struct {
+	domain DomainConfig
+	value  string // Empty for RRset deletions
+	delete bool
+}
+
+// update validates the update section and applies it through the key's
+// provider, returning the response code.
+func (s *rfc2136Server) update(ctx context.Context, msg []byte, u *dnsUpdate, key *tsigKey) uint16 {
+	logger := s.updater.logger.With("zone", u.zone, "key", key.name)
+
+	if u.prereqs > 0 {
+		logger.Warn("Rejected DNS UPDATE with prerequisites, which are not supported")
+		return dnsRcodeNotImp
+	}
+
+	// Validate everything before changing anything (RFC 2136 section 3.4.1)
+	var changes []rrChange
+	for _, rr := range u.updates {
+		if rr.Name != u.zone && !strings.HasSuffix(rr.Name, "."+u.zone) {
+			return dnsRcodeNotZone
+		}
+		if !key.allows(rr.Name) {
+			logger.Warn("Rejected DNS UPDATE for name not allowed for key", "name", rr.Name)
+			return dnsRcodeRefused
+		}
+
+		domain := DomainConfig{Name: u.zone, Record: strings.TrimSuffix(strings.TrimSuffix(rr.Name, u.zone), "."), Provider: key.provider}
+		switch {
+		case rr.Class == dnsClassIN:
+			recordType, ok := dnsTypeNames[rr.Type]
+			if !ok {
+				return dnsRcodeRefused
+			}
+			value, err := rr.recordValue(msg)
+			if err != nil {
+				return dnsRcodeFormErr
+			}
+			domain.Type = recordType
+			changes = append(changes, rrChange{domain: domain, value: value})
+
+		case rr.Class == dnsClassANY && rr.Type == dnsTypeANY:
+			// Delete every RRset at the name
+			for _, recordType := range dnsTypeNames {
+				d := domain
+				d.Type = recordType
+				changes = append(changes, rrChange{domain: d, delete: true})
+			}
+
+		case rr.Class == dnsClassANY, rr.Class == dnsClassNONE:
+			recordType, ok := dnsTypeNames[rr.Type]
+			if !ok {
+				return dnsRcodeRefused
+			}
+			domain.Type = recordType
+			change := rrChange{domain: domain, delete: true}
+			if rr.Class == dnsClassNONE {
+				value, err := rr.recordValue(msg)
+				if err != nil {
+					return dnsRcodeFormErr
+				}
+				change.value = value
+			}
+			changes = append(changes, change)
+
+		default:
+			return dnsRcodeFormErr
+		}
+	}
+
+	if !s.updater.leading() {
+		logger.Warn("Refused DNS UPDATE; this instance is standing by for the leader", "leader", s.updater.election.Leader())
+		return dnsRcodeRefused
+	}
+
+	lock := s.locks[key.provider]
+	lock.Lock()
+	defer lock.Unlock()
+
+	provider := s.updater.providers[key.provider]
+	if !s.updater.config.ForceOverwrite {
+		for _, c := range changes {
+			err := checkRRChange(ctx, provider, c)
+			if errors.Is(err, errRecordNotManaged) {
+				logger.Warn("Refused DNS UPDATE of a record not managed by dh-ddns-updater", "name", c.domain.FQDN(), "type", c.domain.Type, "error", err)
+				return dnsRcodeRefused
+			}
+			if err != nil {
+				logger.Error("Failed to apply DNS UPDATE", "name", c.domain.FQDN(), "type", c.domain.Type, "error", err)
+				return dnsRcodeServFail
+			}
+		}
+	}
+	for _, c := range changes {
+		if err := applyRRChange(ctx, provider, c); err != nil {
+			logger.Error("Failed to apply DNS UPDATE", "name", c.domain.FQDN(), "type", c.domain.Type, "error", err)
+			return dnsRcodeServFail
+		}
+		logger.Info("Applied DNS UPDATE", "name", c.domain.FQDN(), "type", c.domain.Type, "value", c.value, "delete", c.delete)
+	}
+	return dnsRcodeSuccess
+}
+
+// errRecordNotManaged is returned by checkRRChange for a change to a
+// record the daemon didn't create.
+var errRecordNotManaged = errors.New("record not managed by dh-ddns-updater")
+
+// replaced returns the records among records, those at c's name and type,
+// that c removes or replaces.
+func (c rrChange) replaced(records []DNSRecord) []DNSRecord {
+	var out []DNSRecord
+	for _, record := range records {
+		switch {
+		case c.delete && (c.value == "" || recordValuesEqual(c.domain.Type, record.Value, c.value)):
+			out = append(out, record)
+		case !c.delete && c.domain.Type == "CNAME" && !recordValuesEqual(c.domain.Type, record.Value, c.value):
+			// A name holds at most one CNAME, so adding one replaces it
+			out = append(out, record)
+		}
+	}
+	return out
+}
+
+// checkRRChange checks that c only removes or replaces records the daemon
+// manages, as publication does unless force_overwrite is set, so that a
+// client can't wipe records made by hand.
+func checkRRChange(ctx context.Context, provider Provider, c rrChange) error {
+	records, err := provider.GetRecords(ctx, c.domain)
+	if err != nil {
+		return err
+	}
+	for _, record := range c.replaced(records) {
+		if !record.Managed() {
+			return fmt.Errorf("%w: %s %s %q (comment %q)", errRecordNotManaged, record.Type, c.domain.FQDN(), record.Value, record.Comment)
+		}
+	}
+	return nil
+}
+
+// applyRRChange makes one change through the provider. Adding a value that
+// exists and deleting one that doesn't are no-ops, as RFC 2136 requires.
+func applyRRChange(ctx context.Context, provider Provider, c rrChange) error {
+	records, err := provider.GetRecords(ctx, c.domain)
+	if err != nil {
+		return err
+	}
+	replaced := c.replaced(records)
+
+	if c.delete {
+		for _, record := range replaced {
+			if err := provider.RemoveRecord(ctx, c.domain, record.Value); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for _, record := range records {
+		if recordValuesEqual(c.domain.Type, record.Value, c.value) {
+			return nil
+		}
+	}
+	current := ""
+	if len(replaced) > 0 {
+		current = replaced[0].Value
+	}
+	return provider.UpdateRecord(ctx, c.domain, current, c.value)
+}
+
+// buildUpdateResponse encodes an UPDATE response echoing the zone section.
+func buildUpdateResponse(id, rcode uint16, zoneRaw []byte) []byte {
+	b := binary.BigEndian.AppendUint16(nil, id)
+	b = binary.BigEndian.AppendUint16(b, 0x8000|dnsOpcodeUpdate<<11|rcode)
+	var zocount uint16
+	if zoneRaw != nil {
+		zocount = 1
+	}
+	b = binary.BigEndian.AppendUint16(b, zocount)
+	b = append(b, 0, 0, 0, 0, 0, 0) // PRCOUNT, UPCOUNT, ARCOUNT
+	return append(b, zoneRaw...)
+}
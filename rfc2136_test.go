@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+// updateRR is a record to place in a test UPDATE message.
+type updateRR struct {
+	name  string
+	rtype uint16
+	class uint16
+	data  []byte
+}
+
+// buildDNSUpdateMessage encodes an UPDATE for zone with the given
+// prerequisite count (filled with "name in use" entries) and updates.
+func buildDNSUpdateMessage(id uint16, zone string, prereqs int, updates ...updateRR) []byte {
+	b := binary.BigEndian.AppendUint16(nil, id)
+	b = binary.BigEndian.AppendUint16(b, dnsOpcodeUpdate<<11)
+	b = binary.BigEndian.AppendUint16(b, 1)
+	b = binary.BigEndian.AppendUint16(b, uint16(prereqs))
+	b = binary.BigEndian.AppendUint16(b, uint16(len(updates)))
+	b = binary.BigEndian.AppendUint16(b, 0)
+	b = appendDNSName(b, zone)
+	b = binary.BigEndian.AppendUint16(b, dnsTypeSOA)
+	b = binary.BigEndian.AppendUint16(b, dnsClassIN)
+
+	for i := 0; i < prereqs; i++ {
+		updates = append([]updateRR{{name: zone, rtype: dnsTypeANY, class: dnsClassANY}}, updates...)
+	}
+	for _, rr := range updates {
+		b = appendDNSName(b, rr.name)
+		b = binary.BigEndian.AppendUint16(b, rr.rtype)
+		b = binary.BigEndian.AppendUint16(b, rr.class)
+		b = binary.BigEndian.AppendUint32(b, 300)
+		b = binary.BigEndian.AppendUint16(b, uint16(len(rr.data)))
+		b = append(b, rr.data...)
+	}
+	return b
+}
+
+// TestRFC2136Update tests TSIG authentication, authorization and translation of updates into API calls
+func TestRFC2136Update(t *testing.T) {
+	fake := newFakeDreamhost(
+		DNSRecord{Record: "old.lan.example.com", Type: "A", Value: "192.168.1.5", Comment: ManagedComment},
+		DNSRecord{Record: "printer.lan.example.com", Type: "A", Value: "192.168.1.9", Comment: "hand-made"},
+	)
+	d := newPlanTestUpdater(t, fake, "")
+	secret := base64.StdEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef"))
+	d.config.RFC2136 = RFC2136Config{Keys: []TSIGKeyConfig{
+		{Name: "dhcp-key", Secret: secret, Names: []string{"*.lan.example.com"}},
+	}}
+	server, err := newRFC2136Server(d)
+	if err != nil {
+		t.Fatalf("creating server: %v", err)
+	}
+	now := time.Unix(1700000000, 0)
+	server.now = func() time.Time { return now }
+
+	key := server.keys["dhcp-key"]
+	wrongKey, _ := newTSIGKey(TSIGKeyConfig{Name: "dhcp-key", Secret: base64.StdEncoding.EncodeToString([]byte("wrong")), Names: []string{"*"}})
+	addHost := updateRR{name: "host.lan.example.com", rtype: dnsTypeA, class: dnsClassIN, data: net.ParseIP("192.168.1.20").To4()}
+
+	tests := []struct {
+		name      string
+		msg       []byte
+		signWith  *tsigKey
+		signedAt  time.Time
+		wantRcode uint16
+	}{
+		{name: "unsigned", msg: buildDNSUpdateMessage(1, "example.com", 0, addHost), wantRcode: dnsRcodeRefused},
+		{name: "bad signature", msg: buildDNSUpdateMessage(2, "example.com", 0, addHost), signWith: wrongKey, signedAt: now, wantRcode: dnsRcodeNotAuth},
+		{name: "stale signature", msg: buildDNSUpdateMessage(3, "example.com", 0, addHost), signWith: key, signedAt: now.Add(-time.Hour), wantRcode: dnsRcodeNotAuth},
+		{name: "name not allowed", msg: buildDNSUpdateMessage(4, "example.com", 0, updateRR{name: "www.example.com", rtype: dnsTypeA, class: dnsClassIN, data: []byte{192, 168, 1, 1}}), signWith: key, signedAt: now, wantRcode: dnsRcodeRefused},
+		{name: "outside zone", msg: buildDNSUpdateMessage(5, "example.org", 0, addHost), signWith: key, signedAt: now, wantRcode: dnsRcodeNotZone},
+		{name: "prerequisites", msg: buildDNSUpdateMessage(6, "example.com", 1, addHost), signWith: key, signedAt: now, wantRcode: dnsRcodeNotImp},
+		{name: "add", msg: buildDNSUpdateMessage(7, "example.com", 0, addHost), signWith: key, signedAt: now, wantRcode: dnsRcodeSuccess},
+		{name: "delete RRset", msg: buildDNSUpdateMessage(8, "example.com", 0, updateRR{name: "old.lan.example.com", rtype: dnsTypeA, class: dnsClassANY}), signWith: key, signedAt: now, wantRcode: dnsRcodeSuccess},
+		{name: "delete unmanaged RRset", msg: buildDNSUpdateMessage(9, "example.com", 0, updateRR{name: "printer.lan.example.com", rtype: dnsTypeA, class: dnsClassANY}), signWith: key, signedAt: now, wantRcode: dnsRcodeRefused},
+		{name: "delete unmanaged name", msg: buildDNSUpdateMessage(10, "example.com", 0, updateRR{name: "printer.lan.example.com", rtype: dnsTypeANY, class: dnsClassANY}), signWith: key, signedAt: now, wantRcode: dnsRcodeRefused},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := tt.msg
+			var requestMAC []byte
+			if tt.signWith != nil {
+				msg, requestMAC = tt.signWith.sign(msg, nil, tt.signedAt, 0, nil)
+			}
+
+			resp := server.respond(context.Background(), msg)
+			if len(resp) < dnsHeaderLen {
+				t.Fatalf("short response: %x", resp)
+			}
+			if rcode := binary.BigEndian.Uint16(resp[2:4]) & 0xF; rcode != tt.wantRcode {
+				t.Fatalf("expected rcode %d, got %d", tt.wantRcode, rcode)
+			}
+
+			// Successful responses must carry a valid signature over the request MAC
+			if tt.wantRcode == dnsRcodeSuccess {
+				u, err := parseDNSUpdate(resp)
+				if err != nil || u.tsig == nil {
+					t.Fatalf("expected signed response, got %x (%v)", resp, err)
+				}
+				unsigned := append([]byte(nil), resp[:u.tsigStart]...)
+				binary.BigEndian.PutUint16(unsigned[10:12], 0)
+				if resigned, _ := key.sign(unsigned, requestMAC, now, 0, nil); !bytes.Equal(resigned, resp) {
+					t.Error("response signature does not verify")
+				}
+			}
+		})
+	}
+
+	if v := fake.value("host.lan.example.com", "A"); v != "192.168.1.20" {
+		t.Errorf("expected host.lan.example.com to be added, got %q", v)
+	}
+	if v := fake.value("old.lan.example.com", "A"); v != "" {
+		t.Errorf("expected old.lan.example.com to be deleted, got %q", v)
+	}
+	if v := fake.value("printer.lan.example.com", "A"); v != "192.168.1.9" {
+		t.Errorf("expected the unmanaged printer.lan.example.com to be kept, got %q", v)
+	}
+
+	deletePrinter := func(id uint16) uint16 {
+		msg, _ := key.sign(buildDNSUpdateMessage(id, "example.com", 0, updateRR{name: "printer.lan.example.com", rtype: dnsTypeA, class: dnsClassANY}), nil, now, 0, nil)
+		return binary.BigEndian.Uint16(server.respond(context.Background(), msg)[2:4]) & 0xF
+	}
+	d.config.ForceOverwrite = true
+	d.election = &leaderElection{now: time.Now}
+	if rcode := deletePrinter(11); rcode != dnsRcodeRefused || fake.value("printer.lan.example.com", "A") == "" {
+		t.Errorf("expected a standby instance to refuse updates, got rcode %d", rcode)
+	}
+	d.election = nil
+	if rcode := deletePrinter(12); rcode != dnsRcodeSuccess || fake.value("printer.lan.example.com", "A") != "" {
+		t.Errorf("expected force_overwrite to allow deleting an unmanaged record, got rcode %d", rcode)
+	}
+}
+
+// TestDNSRRRecordValue tests rendering of record data, including compressed names
+func TestDNSRRRecordValue(t *testing.T) {
+	// Header followed by "mail.example.com" at offset 12, which rdata points back to
+	msg := make([]byte, dnsHeaderLen)
+	msg = appendDNSName(msg, "mail.example.com")
+	mxOff := len(msg)
+	msg = append(msg, 0, 10, 0xC0, dnsHeaderLen)
+	txtOff := len(msg)
+	msg = append(msg, 5, 'h', 'e', 'l', 'l', 'o', 6, ' ', 'w', 'o', 'r', 'l', 'd')
+
+	tests := []struct {
+		name string
+		rr   dnsRR
+		want string
+	}{
+		{name: "A", rr: dnsRR{Type: dnsTypeA, Data: []byte{203, 0, 113, 42}}, want: "203.0.113.42"},
+		{name: "MX", rr: dnsRR{Type: dnsTypeMX, Data: msg[mxOff:txtOff], dataOff: mxOff}, want: "10 mail.example.com."},
+		{name: "CNAME", rr: dnsRR{Type: dnsTypeCNAME, Data: msg[mxOff+2 : txtOff], dataOff: mxOff + 2}, want: "mail.example.com."},
+		{name: "TXT", rr: dnsRR{Type: dnsTypeTXT, Data: msg[txtOff:], dataOff: txtOff}, want: "hello world"},
+		{name: "CAA", rr: dnsRR{Type: dnsTypeCAA, Data: append([]byte{0, 5}, "issueletsencrypt.org"...)}, want: `0 issue "letsencrypt.org"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.rr.recordValue(msg)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+// TestTSIGKeyAllows tests exact and wildcard name allow-lists
+func TestTSIGKeyAllows(t *testing.T) {
+	key := &tsigKey{names: []string{"host.example.com.", "*.lan.example.com"}}
+	for name, want := range map[string]bool{
+		"host.example.com":      true,
+		"a.lan.example.com":     true,
+		"a.b.lan.example.com":   true,
+		"lan.example.com":       false,
+		"other.example.com":     false,
+		"evil-lan.example.com":  false,
+		"host.example.com.evil": false,
+	} {
+		if got := key.allows(name); got != want {
+			t.Errorf("allows(%q): expected %v, got %v", name, want, got)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// ScheduleEntry gives a record a different value during a recurring time
+// window, optionally limited to a range of dates. While an entry is open its
+// value takes precedence over the detected IP, the record's own value and
+// any value pushed for the record; when several entries are open the first
+// one listed wins.
+type ScheduleEntry struct {
+	Value    string   `yaml:"value"`    // Value or template while the window is open
+	Days     []string `yaml:"days"`     // Days the window opens on (mon, tue, ...); empty means every day
+	From     string   `yaml:"from"`     // Time of day the window opens, HH:MM (default 00:00)
+	Until    string   `yaml:"until"`    // Time of day it closes, HH:MM (default 24:00); earlier than from spans midnight
+	Start    string   `yaml:"start"`    // First date the entry applies, YYYY-MM-DD (optional)
+	End      string   `yaml:"end"`      // Last date the entry applies, YYYY-MM-DD (optional)
+	Timezone string   `yaml:"timezone"` // IANA time zone for days, times and dates (default local time)
+
+	window *scheduleWindow // Parsed form, set by validateSchedule
+}
+
+// scheduleWindow is the parsed form of a ScheduleEntry.
+type scheduleWindow struct {
+	days        [7]bool // Indexed by time.Weekday
+	from, until int     // Minutes since midnight; until may be 24*60
+	start, end  string  // Inclusive date bounds as YYYY-MM-DD; empty means unbounded
+	loc         *time.Location
+}
+
+// scheduleDays maps the accepted day names to weekdays.
+var scheduleDays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// parseScheduleDay accepts a day as its three-letter abbreviation or full
+// English name, in any case.
+func parseScheduleDay(day string) (time.Weekday, bool) {
+	if len(day) < 3 {
+		return 0, false
+	}
+	wd, ok := scheduleDays[strings.ToLower(day[:3])]
+	if !ok || (len(day) > 3 && !strings.EqualFold(day, wd.String())) {
+		return 0, false
+	}
+	return wd, true
+}
+
+// parseTimeOfDay parses HH:MM into minutes since midnight, allowing 24:00.
+func parseTimeOfDay(s string, def int) (int, error) {
+	if s == "" {
+		return def, nil
+	}
+	var h, m int
+	if _, err := fmt.Sscanf(s, "%d:%d", &h, &m); err != nil || h < 0 || m < 0 || m > 59 || h > 24 || (h == 24 && m > 0) {
+		return 0, fmt.Errorf("invalid time of day %q (want HH:MM)", s)
+	}
+	return h*60 + m, nil
+}
+
+// validateSchedule checks a record's schedule and parses each entry's
+// window.
+func validateSchedule(domain *DomainConfig) error {
+	for i := range domain.Schedule {
+		entry := &domain.Schedule[i]
+		if err := validateScheduleEntry(domain.Type, entry); err != nil {
+			return fmt.Errorf("schedule entry %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+func validateScheduleEntry(recordType string, entry *ScheduleEntry) error {
+	if entry.Value == "" {
+		return fmt.Errorf("value is required")
+	}
+	if _, err := template.New("value").Parse(entry.Value); err != nil {
+		return fmt.Errorf("parsing value template: %w", err)
+	}
+	if isAddressType(recordType) && !strings.Contains(entry.Value, "{{") && !ipMatchesType(entry.Value, recordType) {
+		return fmt.Errorf("value %q is not an %s address", entry.Value, recordType)
+	}
+	w, err := parseWindow(entry.Days, entry.From, entry.Until, entry.Start, entry.End, entry.Timezone)
+	if err != nil {
+		return err
+	}
+	entry.window = w
+	return nil
+}
+
+// parseWindow parses the days, times of day, dates and time zone of a
+// recurring window, as given in a schedule entry or quiet_hours.
+func parseWindow(days []string, from, until, start, end, timezone string) (*scheduleWindow, error) {
+	w := &scheduleWindow{loc: time.Local}
+	if timezone != "" {
+		loc, err := time.LoadLocation(timezone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timezone: %w", err)
+		}
+		w.loc = loc
+	}
+	for _, day := range days {
+		wd, ok := parseScheduleDay(day)
+		if !ok {
+			return nil, fmt.Errorf("invalid day %q", day)
+		}
+		w.days[wd] = true
+	}
+	if len(days) == 0 {
+		w.days = [7]bool{true, true, true, true, true, true, true}
+	}
+
+	var err error
+	if w.from, err = parseTimeOfDay(from, 0); err != nil {
+		return nil, err
+	}
+	if w.until, err = parseTimeOfDay(until, 24*60); err != nil {
+		return nil, err
+	}
+	if w.from == w.until {
+		return nil, fmt.Errorf("from and until must differ")
+	}
+	for _, date := range []string{start, end} {
+		if _, err := time.Parse(time.DateOnly, date); date != "" && err != nil {
+			return nil, fmt.Errorf("invalid date %q (want YYYY-MM-DD)", date)
+		}
+	}
+	if start != "" && end != "" && end < start {
+		return nil, fmt.Errorf("end %s is before start %s", end, start)
+	}
+	w.start, w.end = start, end
+	return w, nil
+}
+
+// opensOn reports whether the window opens on the day of t.
+func (w *scheduleWindow) opensOn(t time.Time) bool {
+	date := t.Format(time.DateOnly)
+	return w.days[t.Weekday()] && (w.start == "" || date >= w.start) && (w.end == "" || date <= w.end)
+}
+
+// Contains reports whether the window is open at t. A window spanning
+// midnight belongs to the day it opens on.
+func (w *scheduleWindow) Contains(t time.Time) bool {
+	t = t.In(w.loc)
+	minute := t.Hour()*60 + t.Minute()
+	if w.from < w.until {
+		return minute >= w.from && minute < w.until && w.opensOn(t)
+	}
+	return (minute >= w.from && w.opensOn(t)) || (minute < w.until && w.opensOn(t.AddDate(0, 0, -1)))
+}
+
+// scheduledValue returns the value of the first schedule entry open at t.
+func scheduledValue(domain DomainConfig, t time.Time) (string, bool) {
+	for _, entry := range domain.Schedule {
+		if entry.window != nil && entry.window.Contains(t) {
+			return entry.Value, true
+		}
+	}
+	return "", false
+}
+
+// scheduleSource returns the desired-state source holding the scheduled
+// value currently in effect for a record, or "" when none is.
+func scheduleSource(fqdn, recordType string) string {
+	return "schedule:" + strings.ToLower(fqdn) + "/" + recordType
+}
+
+// hasSchedules reports whether any configured record has a schedule.
+func (d *DDNSUpdater) hasSchedules() bool {
+	for _, domain := range d.config.Domains {
+		if len(domain.Schedule) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// evaluateSchedules records the scheduled value in effect at now for every
+// record with a schedule. A window opening or closing changes the stored
+// value, which wakes the publication stages like any other change in
+// desired state.
+func (d *DDNSUpdater) evaluateSchedules(now time.Time) {
+	for _, domain := range d.config.Domains {
+		if len(domain.Schedule) == 0 {
+			continue
+		}
+		source := scheduleSource(domain.FQDN(), domain.Type)
+		value, _ := scheduledValue(domain, now)
+		_, seen := d.desired.Get(source)
+		if d.desired.Set(source, value) && (seen || value != "") {
+			d.logger.Info("Scheduled value changed",
+				"record", domain.FQDN(),
+				"type", domain.Type,
+				"value", value)
+		}
+	}
+}
+
+// runSchedules is the schedule stage's run function.
+func (d *DDNSUpdater) runSchedules(ctx context.Context) error {
+	now := time.Now()
+	d.evaluateSchedules(now)
+	d.evaluateQuietHours(now)
+	return nil
+}
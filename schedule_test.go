@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// TestScheduleWindowContains tests day, time-of-day, midnight-spanning and date-range windows
+func TestScheduleWindowContains(t *testing.T) {
+	// 2026-10-17 is a Saturday
+	at := func(s string) time.Time {
+		ts, err := time.ParseInLocation("2006-01-02 15:04", s, time.UTC)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ts
+	}
+
+	tests := []struct {
+		name  string
+		entry ScheduleEntry
+		at    time.Time
+		want  bool
+	}{
+		{name: "every day", entry: ScheduleEntry{}, at: at("2026-10-14 03:00"), want: true},
+		{name: "on the day", entry: ScheduleEntry{Days: []string{"sat"}}, at: at("2026-10-17 12:00"), want: true},
+		{name: "other day", entry: ScheduleEntry{Days: []string{"Saturday"}}, at: at("2026-10-18 12:00"), want: false},
+		{name: "inside hours", entry: ScheduleEntry{From: "10:00", Until: "18:00"}, at: at("2026-10-17 10:00"), want: true},
+		{name: "at closing time", entry: ScheduleEntry{From: "10:00", Until: "18:00"}, at: at("2026-10-17 18:00"), want: false},
+		{name: "overnight before midnight", entry: ScheduleEntry{Days: []string{"sat"}, From: "22:00", Until: "06:00"}, at: at("2026-10-17 23:30"), want: true},
+		{name: "overnight after midnight", entry: ScheduleEntry{Days: []string{"sat"}, From: "22:00", Until: "06:00"}, at: at("2026-10-18 05:59"), want: true},
+		{name: "overnight from the wrong day", entry: ScheduleEntry{Days: []string{"sat"}, From: "22:00", Until: "06:00"}, at: at("2026-10-17 05:00"), want: false},
+		{name: "before start date", entry: ScheduleEntry{Start: "2026-10-18"}, at: at("2026-10-17 12:00"), want: false},
+		{name: "on end date", entry: ScheduleEntry{End: "2026-10-17"}, at: at("2026-10-17 23:59"), want: true},
+		{name: "after end date", entry: ScheduleEntry{End: "2026-10-17"}, at: at("2026-10-18 00:00"), want: false},
+		{name: "time zone", entry: ScheduleEntry{From: "09:00", Until: "17:00", Timezone: "America/New_York"}, at: at("2026-10-17 14:00"), want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry := tt.entry
+			entry.Value = "192.0.2.1"
+			if entry.Timezone == "" {
+				entry.Timezone = "UTC"
+			}
+			if err := validateScheduleEntry("A", &entry); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := entry.window.Contains(tt.at); got != tt.want {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+// TestValidateSchedule tests rejection of malformed schedule entries
+func TestValidateSchedule(t *testing.T) {
+	tests := []struct {
+		name      string
+		domain    DomainConfig
+		wantError bool
+	}{
+		{name: "valid", domain: DomainConfig{Type: "A", Schedule: []ScheduleEntry{{Value: "192.0.2.1", Days: []string{"sat", "sun"}, From: "08:00", Until: "24:00"}}}},
+		{name: "template", domain: DomainConfig{Type: "TXT", Schedule: []ScheduleEntry{{Value: "away since {{.Timestamp}}"}}}},
+		{name: "missing value", domain: DomainConfig{Type: "A", Schedule: []ScheduleEntry{{Days: []string{"sat"}}}}, wantError: true},
+		{name: "wrong address family", domain: DomainConfig{Type: "AAAA", Schedule: []ScheduleEntry{{Value: "192.0.2.1"}}}, wantError: true},
+		{name: "bad day", domain: DomainConfig{Type: "A", Schedule: []ScheduleEntry{{Value: "192.0.2.1", Days: []string{"caturday"}}}}, wantError: true},
+		{name: "bad time", domain: DomainConfig{Type: "A", Schedule: []ScheduleEntry{{Value: "192.0.2.1", From: "25:00"}}}, wantError: true},
+		{name: "empty window", domain: DomainConfig{Type: "A", Schedule: []ScheduleEntry{{Value: "192.0.2.1", From: "10:00", Until: "10:00"}}}, wantError: true},
+		{name: "end before start", domain: DomainConfig{Type: "A", Schedule: []ScheduleEntry{{Value: "192.0.2.1", Start: "2026-10-17", End: "2026-10-01"}}}, wantError: true},
+		{name: "bad timezone", domain: DomainConfig{Type: "A", Schedule: []ScheduleEntry{{Value: "192.0.2.1", Timezone: "Mars/Olympus"}}}, wantError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			domain := tt.domain
+			err := validateSchedule(&domain)
+			if tt.wantError && err == nil {
+				t.Error("expected error but got none")
+			}
+			if !tt.wantError && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+// TestScheduledValuePrecedence tests that an open schedule entry overrides pushed and detected values and that closing it reverts them
+func TestScheduledValuePrecedence(t *testing.T) {
+	fake := newFakeDreamhost()
+	game := DomainConfig{Name: "example.com", Record: "game", Type: "A", Schedule: []ScheduleEntry{
+		{Value: "198.51.100.7", Days: []string{"sat"}, Timezone: "UTC"},
+	}}
+	if err := validateDomain(&game); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	d := newPlanTestUpdater(t, fake, "203.0.113.42", game)
+	d.desired.Set(recordSource("game.example.com", "A"), "203.0.113.99")
+	trigger := d.desired.Subscribe()
+
+	desiredValue := func() string {
+		plan, err := d.planAll(context.Background())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return plan.Actions[0].Desired
+	}
+
+	d.evaluateSchedules(time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC))
+	if got := desiredValue(); got != "198.51.100.7" {
+		t.Errorf("expected scheduled value during the window, got %s", got)
+	}
+	select {
+	case <-trigger:
+	default:
+		t.Error("expected the window opening to notify publication")
+	}
+
+	d.evaluateSchedules(time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC))
+	if got := desiredValue(); got != "203.0.113.99" {
+		t.Errorf("expected pushed value after the window, got %s", got)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// stallGrace is how long past its due time a stage's run may go on, beyond
+// its Timeout, before its loop is considered wedged and the systemd
+// watchdog keepalives stop; see stalledStages.
+const stallGrace = time.Minute
+
+// sdNotify sends state, newline-separated assignments such as READY=1, to
+// the service manager on the socket NOTIFY_SOCKET names, as sd_notify(3)
+// does. It does nothing unless the daemon runs as a systemd service of
+// Type=notify (or has NotifyAccess= set), which sets NOTIFY_SOCKET.
+func sdNotify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+	// A leading @ names an abstract socket, which net handles itself
+	conn, err := net.Dial("unixgram", socket)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// watchdogInterval returns how often the daemon must send WATCHDOG=1
+// keepalives: half of WatchdogSec= as systemd passes it in WATCHDOG_USEC,
+// or 0 if the watchdog isn't enabled for this process.
+func watchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0 // Meant for another process
+	}
+	return time.Duration(usec) * time.Microsecond / 2
+}
+
+// notifySystemd sends state to systemd, logging if that fails.
+func (d *DDNSUpdater) notifySystemd(ctx context.Context, state string) {
+	if err := sdNotify(state); err != nil {
+		d.repeats.Error(ctx, d.logger, "sd_notify", err.Error(), "Notifying systemd failed", "error", err)
+	}
+}
+
+// systemdStatus returns the STATUS= systemctl status shows for the daemon.
+func (d *DDNSUpdater) systemdStatus() string {
+	if desired, ok := d.desired.Get(DefaultSource); ok {
+		return "Public IP " + desired.Value
+	}
+	if d.startupIP != "" {
+		return "Public IP " + d.startupIP + " (last known; not checked yet)"
+	}
+	return "Waiting for the public IP"
+}
+
+// superviseSystemd keeps systemd informed until ctx is cancelled: it
+// updates STATUS= as the public IP changes, and if the watchdog is enabled
+// sends keepalives for as long as no stage has stalled, so that systemd
+// restarts the daemon if a stage's loop wedges.
+func (d *DDNSUpdater) superviseSystemd(ctx context.Context) {
+	if os.Getenv("NOTIFY_SOCKET") == "" {
+		return
+	}
+	changed := d.desired.Subscribe()
+	var keepalive <-chan time.Time
+	if interval := watchdogInterval(); interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		keepalive = ticker.C
+		d.notifySystemd(ctx, "WATCHDOG=1")
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-changed:
+			d.notifySystemd(ctx, "STATUS="+d.systemdStatus())
+		case <-keepalive:
+			if stalled := d.stalledStages(time.Now()); len(stalled) > 0 {
+				d.logger.ErrorContext(ctx, "Pipeline stages are stalled; withholding the watchdog keepalive", "stages", stalled)
+				continue
+			}
+			d.notifySystemd(ctx, "WATCHDOG=1")
+		}
+	}
+}
+
+// stalledStages returns the names of the stages whose run is overdue by
+// more than their Timeout and stallGrace at now: a run that long past its
+// due time hasn't finished despite its timeout, or never started.
+func (d *DDNSUpdater) stalledStages(now time.Time) []string {
+	var stalled []string
+	for _, stage := range d.stages() {
+		next := stage.Metrics().NextRun
+		if !next.IsZero() && now.Sub(next) > stage.Timeout+stallGrace {
+			stalled = append(stalled, stage.Name)
+		}
+	}
+	return stalled
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// listenNotifySocket points NOTIFY_SOCKET at a socket it returns the
+// messages of.
+func listenNotifySocket(t *testing.T) <-chan string {
+	t.Helper()
+	// Kept short: socket paths are limited to about 100 bytes
+	dir, err := os.MkdirTemp("", "sd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	socket := filepath.Join(dir, "notify")
+	conn, err := net.ListenPacket("unixgram", socket)
+	if err != nil {
+		t.Skipf("unixgram sockets unavailable: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	t.Setenv("NOTIFY_SOCKET", socket)
+
+	messages := make(chan string, 16)
+	go func() {
+		buf := make([]byte, 4096)
+		for {
+			n, _, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			messages <- string(buf[:n])
+		}
+	}()
+	return messages
+}
+
+// receive returns the next message, failing the test if none arrives.
+func receive(t *testing.T, messages <-chan string) string {
+	t.Helper()
+	select {
+	case msg := <-messages:
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a notification")
+		return ""
+	}
+}
+
+// TestSdNotify tests that state is sent to NOTIFY_SOCKET, and nothing is
+// done without it
+func TestSdNotify(t *testing.T) {
+	messages := listenNotifySocket(t)
+	if err := sdNotify("READY=1\nSTATUS=Public IP 203.0.113.42"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := receive(t, messages); got != "READY=1\nSTATUS=Public IP 203.0.113.42" {
+		t.Errorf("unexpected message %q", got)
+	}
+
+	t.Setenv("NOTIFY_SOCKET", "")
+	if err := sdNotify("READY=1"); err != nil {
+		t.Errorf("expected nothing to be done without NOTIFY_SOCKET, got %v", err)
+	}
+}
+
+// TestWatchdogInterval tests the keepalive interval taken from
+// WATCHDOG_USEC, for this process only
+func TestWatchdogInterval(t *testing.T) {
+	tests := []struct {
+		usec, pid string
+		want      time.Duration
+	}{
+		{"", "", 0},
+		{"120000000", "", time.Minute},
+		{"120000000", "1", 0},
+		{"120000000", "self", time.Minute},
+		{"-1", "", 0},
+		{"soon", "", 0},
+	}
+	for _, tt := range tests {
+		pid := tt.pid
+		if pid == "self" {
+			pid = strconv.Itoa(os.Getpid())
+		}
+		t.Setenv("WATCHDOG_USEC", tt.usec)
+		t.Setenv("WATCHDOG_PID", pid)
+		if got := watchdogInterval(); got != tt.want {
+			t.Errorf("WATCHDOG_USEC=%q WATCHDOG_PID=%q: expected %v, got %v", tt.usec, pid, tt.want, got)
+		}
+	}
+}
+
+// newSupervisedUpdater returns an updater whose detection and publication
+// stages exist but don't run.
+func newSupervisedUpdater(t *testing.T) *DDNSUpdater {
+	t.Helper()
+	updater := newPlanTestUpdater(t, newFakeDreamhost(), "", DomainConfig{Name: "example.com", Record: "home", Type: "A"})
+	idle := func(context.Context) error { return nil }
+	updater.detection = NewStage("detection", time.Minute, time.Minute, idle, nil)
+	updater.providers[DefaultProvider].stage = NewStage("publication:dreamhost", time.Hour, time.Minute, idle, nil)
+	return updater
+}
+
+// TestStalledStages tests that only a stage overdue by more than its
+// timeout and the grace is stalled
+func TestStalledStages(t *testing.T) {
+	updater := newSupervisedUpdater(t)
+	publication := updater.providers[DefaultProvider].stage
+	publication.Timeout = 5 * time.Minute
+
+	now := time.Now()
+	if stalled := updater.stalledStages(now); len(stalled) != 0 {
+		t.Errorf("expected stages that haven't started looping not to be stalled, got %v", stalled)
+	}
+	updater.detection.scheduled(-2 * time.Minute)
+	publication.scheduled(-2 * time.Minute)
+	if stalled := updater.stalledStages(now); len(stalled) != 1 || stalled[0] != "detection" {
+		t.Errorf("expected only detection to be stalled, got %v", stalled)
+	}
+}
+
+// TestSuperviseSystemd tests the watchdog keepalives, withheld while a
+// stage is stalled, and the status updates as the IP changes
+func TestSuperviseSystemd(t *testing.T) {
+	messages := listenNotifySocket(t)
+	t.Setenv("WATCHDOG_USEC", "20000")
+	t.Setenv("WATCHDOG_PID", "")
+	updater := newSupervisedUpdater(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		updater.superviseSystemd(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	if got := receive(t, messages); got != "WATCHDOG=1" {
+		t.Errorf("expected a keepalive, got %q", got)
+	}
+	updater.desired.Set(DefaultSource, "203.0.113.42")
+	for {
+		got := receive(t, messages)
+		if got == "STATUS=Public IP 203.0.113.42" {
+			break
+		}
+		if got != "WATCHDOG=1" {
+			t.Fatalf("unexpected message %q", got)
+		}
+	}
+
+	updater.detection.scheduled(-time.Hour)
+	// Drain a keepalive sent before the stall was seen
+	time.Sleep(50 * time.Millisecond)
+	for len(messages) > 0 {
+		<-messages
+	}
+	select {
+	case got := <-messages:
+		t.Errorf("expected no keepalive while detection is stalled, got %q", got)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
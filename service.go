@@ -0,0 +1,38 @@
+//go:build windows || darwin
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+// runServiceCommand installs, removes, starts and stops the daemon as a
+// system service: a Windows service, or a launchd LaunchDaemon on macOS.
+// serviceCommands holds the platform's implementation of each subcommand.
+func runServiceCommand(args []string) int {
+	fs := flag.NewFlagSet("service", flag.ContinueOnError)
+	opts := configFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: dh-ddns-updater service install|uninstall|start|stop [--config path]")
+		fmt.Fprintln(fs.Output(), "  install    register the service, started at boot with the given config")
+		fmt.Fprintln(fs.Output(), "  uninstall  remove the service")
+		fmt.Fprintln(fs.Output(), "  start      start the service")
+		fmt.Fprintln(fs.Output(), "  stop       stop the service")
+		fs.PrintDefaults()
+	}
+	if len(args) == 0 || serviceCommands[args[0]] == nil {
+		fs.Usage()
+		return 2
+	}
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+
+	if err := serviceCommands[args[0]](commandConfigPath(fs), *opts); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to %s the service: %v\n", args[0], err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// launchdPlistPath is where the daemon's LaunchDaemon is installed.
+const launchdPlistPath = "/Library/LaunchDaemons/" + launchdLabel + ".plist"
+
+// launchdTarget names the daemon's job in the system domain, for launchctl.
+const launchdTarget = "system/" + launchdLabel
+
+// serviceCommands are the subcommands of the service command.
+var serviceCommands = map[string]func(configPath string, opts configOptions) error{
+	"install":   installService,
+	"uninstall": uninstallService,
+	"start":     startService,
+	"stop":      stopService,
+}
+
+// launchctl runs launchctl with args. Its error output, which says why it
+// failed, is included in the error.
+func launchctl(args ...string) error {
+	cmd := exec.Command("launchctl", args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("launchctl %s: %w: %s", args[0], err, msg)
+		}
+		return fmt.Errorf("launchctl %s: %w", args[0], err)
+	}
+	return nil
+}
+
+// requireRoot fails unless the command runs as root, which managing a
+// LaunchDaemon takes.
+func requireRoot() error {
+	if os.Geteuid() != 0 {
+		return errors.New("LaunchDaemons are managed as root; run it with sudo")
+	}
+	return nil
+}
+
+// installService writes the LaunchDaemon running this executable with the
+// config at configPath and loads it, which starts the daemon. Installing
+// again replaces it, restarting the daemon with the new settings.
+func installService(configPath string, _ configOptions) error {
+	if err := requireRoot(); err != nil {
+		return err
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return err
+	}
+	if configPath, err = filepath.Abs(configPath); err != nil {
+		return err
+	}
+
+	// Unloaded first, or launchd keeps running the old one
+	if _, err := os.Stat(launchdPlistPath); err == nil {
+		_ = launchctl("bootout", launchdTarget)
+	}
+	if err := os.WriteFile(launchdPlistPath, launchdPlist(exe, configPath), 0644); err != nil {
+		return err
+	}
+	if err := launchctl("bootstrap", "system", launchdPlistPath); err != nil {
+		return err
+	}
+	fmt.Printf("Installed and started %s with config %s; logs go to %s\n", launchdLabel, configPath, launchdLogPath)
+	return nil
+}
+
+// uninstallService stops the daemon and removes its LaunchDaemon.
+func uninstallService(string, configOptions) error {
+	if err := requireRoot(); err != nil {
+		return err
+	}
+	if _, err := os.Stat(launchdPlistPath); err != nil {
+		return fmt.Errorf("%s is not installed: %w", launchdLabel, err)
+	}
+	// Fails if it isn't loaded, which is as good as unloading it
+	_ = launchctl("bootout", launchdTarget)
+	if err := os.Remove(launchdPlistPath); err != nil {
+		return err
+	}
+	fmt.Printf("Removed %s\n", launchdLabel)
+	return nil
+}
+
+func startService(string, configOptions) error {
+	if err := requireRoot(); err != nil {
+		return err
+	}
+	return launchctl("kickstart", launchdTarget)
+}
+
+// stopService stops the daemon with SIGTERM. It exits cleanly, so launchd
+// doesn't restart it until the next boot or start.
+func stopService(string, configOptions) error {
+	if err := requireRoot(); err != nil {
+		return err
+	}
+	return launchctl("kill", "SIGTERM", launchdTarget)
+}
@@ -0,0 +1,15 @@
+//go:build !windows && !darwin
+
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// runServiceCommand manages the Windows service or macOS LaunchDaemon,
+// which there is neither of here.
+func runServiceCommand(args []string) int {
+	fmt.Fprintln(os.Stderr, "The service command manages the Windows service or macOS LaunchDaemon; here, run the daemon from the systemd unit or your init system")
+	return 1
+}
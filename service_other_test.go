@@ -0,0 +1,15 @@
+//go:build !windows && !darwin
+
+package main
+
+import "testing"
+
+// TestServiceCommandOtherPlatforms tests that the service command fails
+// where there is no Windows service or LaunchDaemon to manage.
+func TestServiceCommandOtherPlatforms(t *testing.T) {
+	for _, args := range [][]string{nil, {"install"}, {"start", "--config", "config.yaml"}} {
+		if code := runServiceCommand(args); code != 1 {
+			t.Errorf("runServiceCommand(%q) = %d, want 1", args, code)
+		}
+	}
+}
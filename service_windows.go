@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// serviceName is the name the daemon is installed under as a Windows
+// service, for sc.exe and net start.
+const serviceName = "dh-ddns-updater"
+
+// serviceLogPath is where the service writes the logs the daemon writes to
+// stdout elsewhere, as a service has no console. It is rotated with the
+// log_file defaults.
+var serviceLogPath = filepath.Join(programDataDir, "service.log")
+
+// serviceCommands are the subcommands of the service command. run is
+// what the installed service runs, not for use from a prompt.
+var serviceCommands = map[string]func(configPath string, opts configOptions) error{
+	"install":   installService,
+	"uninstall": uninstallService,
+	"start":     startService,
+	"stop":      stopService,
+	"run":       runService,
+}
+
+// connectSCManager connects to the Service Control Manager, which takes
+// administrator rights.
+func connectSCManager() (*mgr.Mgr, error) {
+	m, err := mgr.Connect()
+	if err != nil {
+		return nil, fmt.Errorf("opening the service manager (run as administrator): %w", err)
+	}
+	return m, nil
+}
+
+// openService opens the daemon's service. The manager is disconnected when
+// the service is closed; see closeService.
+func openService() (*mgr.Mgr, *mgr.Service, error) {
+	m, err := connectSCManager()
+	if err != nil {
+		return nil, nil, err
+	}
+	s, err := m.OpenService(serviceName)
+	if err != nil {
+		m.Disconnect()
+		return nil, nil, fmt.Errorf("opening service %s: %w", serviceName, err)
+	}
+	return m, s, nil
+}
+
+// closeService closes what openService opened.
+func closeService(m *mgr.Mgr, s *mgr.Service) {
+	s.Close()
+	m.Disconnect()
+}
+
+// installService registers the service to run this executable with the
+// config at configPath, started at boot as LocalSystem, and creates the
+// directory the default config and state live in.
+func installService(configPath string, _ configOptions) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if configPath, err = filepath.Abs(configPath); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(programDataDir, 0755); err != nil {
+		return err
+	}
+
+	m, err := connectSCManager()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+	s, err := m.CreateService(serviceName, exe, mgr.Config{
+		DisplayName: "Dreamhost Dynamic DNS Updater",
+		Description: "Keeps Dreamhost DNS records pointed at this network's public IP.",
+		StartType:   mgr.StartAutomatic,
+	}, "service", "run", "--config", configPath)
+	if err != nil {
+		return err
+	}
+	s.Close()
+	fmt.Printf("Installed service %s with config %s; start it with: dh-ddns-updater service start\n", serviceName, configPath)
+	return nil
+}
+
+// uninstallService removes the service. A running service is removed once
+// it stops.
+func uninstallService(string, configOptions) error {
+	m, s, err := openService()
+	if err != nil {
+		return err
+	}
+	defer closeService(m, s)
+	if err := s.Delete(); err != nil {
+		return err
+	}
+	fmt.Printf("Removed service %s\n", serviceName)
+	return nil
+}
+
+func startService(string, configOptions) error {
+	m, s, err := openService()
+	if err != nil {
+		return err
+	}
+	defer closeService(m, s)
+	return s.Start()
+}
+
+// serviceStopTimeout bounds how long stopService waits for the service to
+// stop.
+const serviceStopTimeout = 30 * time.Second
+
+func stopService(string, configOptions) error {
+	m, s, err := openService()
+	if err != nil {
+		return err
+	}
+	defer closeService(m, s)
+	status, err := s.Control(svc.Stop)
+	if err != nil {
+		return err
+	}
+	for deadline := time.Now().Add(serviceStopTimeout); status.State != svc.Stopped; {
+		if time.Now().After(deadline) {
+			return errors.New("timed out waiting for the service to stop")
+		}
+		time.Sleep(300 * time.Millisecond)
+		if status, err = s.Query(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runService runs the daemon under the Service Control Manager until the
+// service is stopped. It fails unless the manager started the process.
+func runService(configPath string, opts configOptions) error {
+	if isService, err := svc.IsWindowsService(); err != nil || !isService {
+		return errors.New("not started by the service manager")
+	}
+	return svc.Run(serviceName, &ddnsService{configPath: configPath, opts: opts})
+}
+
+// ddnsService is the svc.Handler runService runs. It runs the daemon as
+// main does, with the service's stop and parameter change controls standing
+// in for SIGTERM and SIGHUP.
+type ddnsService struct {
+	configPath string
+	opts       configOptions
+}
+
+// Execute runs the daemon until it is stopped or fails. A config that
+// can't be loaded is reported by sc query as service-specific exit code 2,
+// as --once reports it, and any other failure as 1.
+func (s *ddnsService) Execute(_ []string, requests <-chan svc.ChangeRequest, changes chan<- svc.Status) (bool, uint32) {
+	pending := func(state svc.State) svc.Status {
+		return svc.Status{State: state, WaitHint: uint32((30 * time.Second).Milliseconds())}
+	}
+	changes <- pending(svc.StartPending)
+
+	out := &rotatingFile{path: serviceLogPath}
+	out.setLimits(LogFileConfig{MaxSizeMB: DefaultLogMaxSizeMB, MaxBackups: DefaultLogMaxBackups})
+	if err := out.open(time.Now()); err != nil {
+		return true, 1
+	}
+	defer out.Close()
+	logs := newDaemonLog(out)
+	defer logs.Close()
+	load := daemonLoader(s.configPath, s.opts, logs)
+	updater, err := load()
+	if err != nil {
+		// dh-ddns-updater validate says what is wrong with a config
+		newLogger(logs, "info").Error("Failed to initialize updater", "error", err)
+		if errors.As(err, new(configError)) {
+			return true, 2
+		}
+		return true, 1
+	}
+	defer updater.lock.Unlock()
+
+	signals := make(chan os.Signal, 1)
+	done := make(chan error, 1)
+	go func() { done <- runWithReload(context.Background(), updater, load, signals) }()
+	changes <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown | svc.AcceptParamChange}
+
+	for {
+		select {
+		case err := <-done:
+			if err != nil && err != context.Canceled {
+				updater.logger.Error("Updater failed", "error", err)
+				return true, 1
+			}
+			return false, 0
+		case req := <-requests:
+			switch req.Cmd {
+			case svc.Interrogate:
+				changes <- req.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				changes <- pending(svc.StopPending)
+				// Never dropped: sent once the daemon takes a pending
+				// reload, without holding up the requests meanwhile
+				go func() { signals <- syscall.SIGTERM }()
+			case svc.ParamChange:
+				// Dropped while another signal is pending, which covers it
+				select {
+				case signals <- syscall.SIGHUP:
+				default:
+				}
+			}
+		}
+	}
+}
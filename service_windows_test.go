@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/windows/svc"
+)
+
+// TestServiceExecute tests that the service reports its state to the
+// Service Control Manager, answers an interrogation, stops when told to,
+// and reports an invalid config with exit code 2
+func TestServiceExecute(t *testing.T) {
+	dir := t.TempDir()
+	defer func(path string) { serviceLogPath = path }(serviceLogPath)
+	serviceLogPath = filepath.Join(dir, "service.log")
+
+	configPath := filepath.Join(dir, "config.yaml")
+	config := fmt.Sprintf("dreamhost_api_key: key\nstate_path: %s\ndomains:\n  - name: example.com\n    record: home\n    type: A\n",
+		filepath.ToSlash(filepath.Join(dir, "state.json")))
+	if err := os.WriteFile(configPath, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	requests := make(chan svc.ChangeRequest)
+	changes := make(chan svc.Status, 10)
+	type result struct {
+		specific bool
+		code     uint32
+	}
+	done := make(chan result, 1)
+	go func() {
+		specific, code := (&ddnsService{configPath: configPath}).Execute(nil, requests, changes)
+		done <- result{specific, code}
+	}()
+
+	next := func() svc.Status {
+		t.Helper()
+		select {
+		case status := <-changes:
+			return status
+		case <-time.After(10 * time.Second):
+			t.Fatal("timed out waiting for a status")
+			return svc.Status{}
+		}
+	}
+	if status := next(); status.State != svc.StartPending {
+		t.Errorf("expected start pending, got %d", status.State)
+	}
+	running := next()
+	if running.State != svc.Running || running.Accepts&svc.AcceptParamChange == 0 {
+		t.Errorf("expected running and accepting reloads, got %+v", running)
+	}
+	requests <- svc.ChangeRequest{Cmd: svc.Interrogate, CurrentStatus: running}
+	if status := next(); status != running {
+		t.Errorf("expected the interrogation answered with %+v, got %+v", running, status)
+	}
+	requests <- svc.ChangeRequest{Cmd: svc.Stop}
+	if status := next(); status.State != svc.StopPending {
+		t.Errorf("expected stop pending, got %d", status.State)
+	}
+	select {
+	case r := <-done:
+		if r.specific || r.code != 0 {
+			t.Errorf("expected a clean stop, got %+v", r)
+		}
+	case <-time.After(30 * time.Second):
+		t.Fatal("service didn't stop")
+	}
+
+	if err := os.WriteFile(configPath, []byte("check_interval: soon\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	specific, code := (&ddnsService{configPath: configPath}).Execute(nil, requests, changes)
+	if !specific || code != 2 {
+		t.Errorf("expected service-specific exit code 2 for an invalid config, got %v %d", specific, code)
+	}
+}
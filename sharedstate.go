@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// sharedStateTimeout bounds each request to a store shared by several
+// instances, within whatever bound the request's context sets.
+var sharedStateTimeout = 10 * time.Second
+
+// sharedSaveAttempts is how many times a save to a shared store is retried
+// when another instance saves at the same time.
+const sharedSaveAttempts = 5
+
+// casStore holds the state as one value, shared by every instance pointed
+// at it, with a revision that changes whenever it is written.
+type casStore interface {
+	// get returns the stored state and its revision, or nil and 0 if
+	// nothing is stored.
+	get(ctx context.Context) (data []byte, revision int64, err error)
+	// put stores data if the stored revision is still revision (0 for
+	// nothing stored yet), reporting whether it did and the new revision.
+	put(ctx context.Context, data []byte, revision int64) (saved bool, newRevision int64, err error)
+}
+
+// sharedStateStore keeps the state in a store several instances share.
+// Saves are optimistic: when another instance has saved since the state was
+// loaded, its state is merged in (see mergeState) and the save retried, so
+// that neither instance loses what the other recorded.
+type sharedStateStore struct {
+	kv          casStore
+	historySize int
+}
+
+// Load implements StateStore. Nothing stored is an empty state.
+func (s sharedStateStore) Load() (*State, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), sharedStateTimeout)
+	defer cancel()
+	data, revision, err := s.kv.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return decodeSharedState(data, revision)
+}
+
+// decodeSharedState decodes a state read from a shared store, keeping its
+// revision in the state to detect a concurrent save.
+func decodeSharedState(data []byte, revision int64) (*State, error) {
+	state := &State{}
+	if data != nil {
+		if err := json.Unmarshal(data, state); err != nil {
+			return nil, fmt.Errorf("decoding shared state: %w", err)
+		}
+	}
+	if state.Records == nil {
+		state.Records = make(map[string]string)
+	}
+	state.revision = revision
+	return state, nil
+}
+
+// Save implements StateStore. When another instance has saved since state
+// was loaded, state is updated with the merged state saved. Each attempt
+// is given sharedStateTimeout.
+func (s sharedStateStore) Save(state *State) error {
+	saving, revision := state, state.revision
+	for range sharedSaveAttempts {
+		data, err := json.Marshal(saving)
+		if err != nil {
+			return err
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), sharedStateTimeout)
+		saved, newRevision, err := s.kv.put(ctx, data, revision)
+		if err == nil && !saved {
+			data, revision, err = s.kv.get(ctx)
+		}
+		cancel()
+		if err != nil {
+			return err
+		}
+		if saved {
+			if saving != state {
+				*state = *saving
+			}
+			state.revision = newRevision
+			return nil
+		}
+
+		stored, err := decodeSharedState(data, revision)
+		if err != nil {
+			return err
+		}
+		saving = mergeState(state, stored, s.historySize)
+	}
+	return errors.New("the shared state keeps changing; another instance is saving it")
+}
+
+// mergeState combines the state an instance is saving with the newer one
+// another instance saved. For each record the value and status most
+// recently verified or updated are kept, the last IP is taken from the
+// state updated last, and the histories are combined in time order with
+// the same change seen by both instances counted once.
+func mergeState(ours, theirs *State, historySize int) *State {
+	merged := &State{
+		LastIP:       ours.LastIP,
+		LastUpdated:  ours.LastUpdated,
+		Records:      make(map[string]string),
+		Version:      ours.Version,
+		RecordStatus: make(map[string]*RecordStatus),
+		Backoff:      ours.Backoff, // Each instance backs off by its own failures
+	}
+	if theirs.LastUpdated.After(ours.LastUpdated) {
+		merged.LastIP, merged.LastUpdated = theirs.LastIP, theirs.LastUpdated
+	}
+
+	for name, value := range theirs.Records {
+		merged.Records[name] = value
+	}
+	for name, status := range theirs.RecordStatus {
+		merged.RecordStatus[name] = status
+	}
+	for name, value := range ours.Records {
+		if recordActivity(ours.RecordStatus[name]).Before(recordActivity(theirs.RecordStatus[name])) {
+			continue
+		}
+		merged.Records[name] = value
+	}
+	for name, status := range ours.RecordStatus {
+		if recordActivity(status).Before(recordActivity(theirs.RecordStatus[name])) {
+			continue
+		}
+		merged.RecordStatus[name] = status
+	}
+	if len(merged.RecordStatus) == 0 {
+		merged.RecordStatus = nil
+	}
+
+	history := slices.Concat(theirs.History, ours.History)
+	slices.SortStableFunc(history, func(a, b IPChange) int { return a.Time.Compare(b.Time) })
+	for _, change := range history {
+		last := len(merged.History) - 1
+		if last >= 0 && merged.History[last].NewIP == change.NewIP {
+			if merged.History[last].Time.Equal(change.Time) {
+				// The same entry, which either may have counted more records in
+				merged.History[last].RecordsUpdated = max(merged.History[last].RecordsUpdated, change.RecordsUpdated)
+			} else {
+				merged.History[last].RecordsUpdated += change.RecordsUpdated
+			}
+			continue
+		}
+		merged.History = append(merged.History, change)
+	}
+	if historySize > 0 && len(merged.History) > historySize {
+		merged.History = merged.History[len(merged.History)-historySize:]
+	}
+	return merged
+}
+
+// recordActivity returns the last time anything happened to a record.
+func recordActivity(status *RecordStatus) time.Time {
+	if status == nil {
+		return time.Time{}
+	}
+	latest := status.LastUpdated
+	for _, t := range []time.Time{status.LastVerified, status.LastFailure} {
+		if t.After(latest) {
+			latest = t
+		}
+	}
+	return latest
+}
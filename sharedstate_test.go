@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// testSharedStateStore saves state from two instances through store, the
+// second of which loaded it before the first saved again, and checks that
+// both instances' records are kept.
+func testSharedStateStore(t *testing.T, store sharedStateStore) {
+	t.Helper()
+	state, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.LastIP != "" || state.Records == nil || len(state.Records) != 0 {
+		t.Fatalf("expected an empty state, got %+v", state)
+	}
+
+	start := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	a := &State{
+		LastIP:       "203.0.113.2",
+		LastUpdated:  start,
+		Records:      map[string]string{"a.example.com/A": "203.0.113.2"},
+		RecordStatus: map[string]*RecordStatus{"a.example.com/A": {LastUpdated: start}},
+	}
+	if err := store.Save(a); err != nil {
+		t.Fatal(err)
+	}
+	b, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Records["a.example.com/A"] = "203.0.113.3"
+	a.RecordStatus["a.example.com/A"] = &RecordStatus{LastUpdated: start.Add(time.Hour)}
+	if err := store.Save(a); err != nil {
+		t.Fatal(err)
+	}
+	b.Records["b.example.com/A"] = "203.0.113.3"
+	if err := store.Save(b); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"a.example.com/A": "203.0.113.3", "b.example.com/A": "203.0.113.3"}
+	if fmt.Sprint(loaded.Records) != fmt.Sprint(want) {
+		t.Errorf("expected records %v, got %v", want, loaded.Records)
+	}
+	if loaded.revision != b.revision || fmt.Sprint(b.Records) != fmt.Sprint(want) {
+		t.Errorf("expected the saving instance to hold the merged state, got %+v", b)
+	}
+}
+
+// TestMergeState tests which instance's values win when merging concurrent states
+func TestMergeState(t *testing.T) {
+	start := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	ours := &State{
+		LastIP:      "203.0.113.1",
+		LastUpdated: start,
+		Records:     map[string]string{"old.example.com/A": "203.0.113.1", "new.example.com/A": "203.0.113.2"},
+		RecordStatus: map[string]*RecordStatus{
+			"old.example.com/A": {LastVerified: start},
+			"new.example.com/A": {LastFailure: start.Add(time.Hour), ConsecutiveFailures: 1},
+		},
+		History: []IPChange{{Time: start, NewIP: "203.0.113.1"}, {Time: start.Add(2 * time.Hour), NewIP: "203.0.113.4"}},
+	}
+	theirs := &State{
+		LastIP:      "203.0.113.2",
+		LastUpdated: start.Add(time.Minute),
+		Records:     map[string]string{"old.example.com/A": "203.0.113.2", "new.example.com/A": "203.0.113.1"},
+		RecordStatus: map[string]*RecordStatus{
+			"old.example.com/A": {LastUpdated: start.Add(time.Minute)},
+			"new.example.com/A": {LastVerified: start},
+		},
+		History: []IPChange{{Time: start, NewIP: "203.0.113.1"}, {Time: start.Add(time.Hour), NewIP: "203.0.113.3"}},
+	}
+
+	merged := mergeState(ours, theirs, 2)
+	if merged.LastIP != "203.0.113.2" {
+		t.Errorf("expected the last IP of the later update, got %s", merged.LastIP)
+	}
+	want := map[string]string{"old.example.com/A": "203.0.113.2", "new.example.com/A": "203.0.113.2"}
+	if fmt.Sprint(merged.Records) != fmt.Sprint(want) {
+		t.Errorf("expected the most recently active records %v, got %v", want, merged.Records)
+	}
+	if merged.RecordStatus["new.example.com/A"].ConsecutiveFailures != 1 {
+		t.Errorf("expected our newer status kept, got %+v", merged.RecordStatus["new.example.com/A"])
+	}
+	if len(merged.History) != 2 || merged.History[0].NewIP != "203.0.113.3" || merged.History[1].NewIP != "203.0.113.4" {
+		t.Errorf("expected the newest 2 changes in order, got %+v", merged.History)
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"text/template"
+)
+
+// SlackNotificationConfig is a Slack incoming webhook receiving events as
+// short messages.
+type SlackNotificationConfig struct {
+	URL     string `yaml:"url"`      // Incoming webhook URL, which is its credential
+	URLFile string `yaml:"url_file"` // File holding url instead
+	// Templates replace the message of an event, by event name. They are Go
+	// templates executed with the Event; join and names are available, as
+	// in the defaults.
+	Templates map[string]string `yaml:"templates"`
+
+	NotificationRoute `yaml:",inline"` // Which events are sent, and when
+}
+
+// defaultSlackTemplates are the messages sent to Slack unless templates
+// replaces them.
+var defaultSlackTemplates = map[string]string{
+	EventIPChange:     `{{join (names .Records) ", "}} → {{.NewIP}}{{with .Geo}}{{if .String}} ({{.}}){{end}}{{if .Unexpected}} :warning: unexpected network{{end}}{{end}}{{if .Errors}} ({{len .Errors}} failed: {{join .Errors "; "}}){{end}}`,
+	EventUpdateFailed: `:warning: Updating records at {{.Provider}} failed: {{join .Errors "; "}}`,
+	EventRecovered:    `:white_check_mark: Records at {{.Provider}} are updating again`,
+}
+
+// notificationTemplateFuncs are the functions message templates can use.
+var notificationTemplateFuncs = template.FuncMap{
+	"join": strings.Join,
+	// names returns the names of records given as "name type", without
+	// repeating a name held by several types
+	"names": func(records []string) []string {
+		var names []string
+		for _, record := range records {
+			name, _, _ := strings.Cut(record, " ")
+			if !slices.Contains(names, name) {
+				names = append(names, name)
+			}
+		}
+		return names
+	},
+}
+
+// parseSlackTemplates returns the message template of each event: the
+// default, unless templates gives one.
+func parseSlackTemplates(templates map[string]string) (map[string]*template.Template, error) {
+	sources := maps.Clone(defaultSlackTemplates)
+	for _, event := range slices.Sorted(maps.Keys(templates)) {
+		if err := validateNotificationEvents([]string{event}); err != nil {
+			return nil, fmt.Errorf("templates: %w", err)
+		}
+		sources[event] = templates[event]
+	}
+	parsed := make(map[string]*template.Template, len(sources))
+	for event, source := range sources {
+		t, err := template.New(event).Funcs(notificationTemplateFuncs).Option("missingkey=error").Parse(source)
+		if err != nil {
+			return nil, fmt.Errorf("templates: %s: %w", event, err)
+		}
+		parsed[event] = t
+	}
+	return parsed, nil
+}
+
+// validateSlackNotification checks a Slack notification.
+func validateSlackNotification(s SlackNotificationConfig) error {
+	if !strings.HasPrefix(s.URL, "https://") {
+		return errors.New("url is not an https URL")
+	}
+	if err := s.NotificationRoute.validate(); err != nil {
+		return err
+	}
+	templates, err := parseSlackTemplates(s.Templates)
+	if err != nil {
+		return err
+	}
+	// Executing them catches fields Event doesn't have
+	for _, event := range slices.Sorted(maps.Keys(templates)) {
+		if err := templates[event].Execute(io.Discard, Event{Event: event}); err != nil {
+			return fmt.Errorf("templates: %w", err)
+		}
+	}
+	return nil
+}
+
+// slackNotifier posts events to a Slack incoming webhook as messages.
+type slackNotifier struct {
+	url       string
+	templates map[string]*template.Template // By event
+}
+
+func newSlackNotifier(config SlackNotificationConfig) (*slackNotifier, error) {
+	templates, err := parseSlackTemplates(config.Templates)
+	if err != nil {
+		return nil, err
+	}
+	return &slackNotifier{url: config.URL, templates: templates}, nil
+}
+
+func (s *slackNotifier) notify(ctx context.Context, client *http.Client, e Event) error {
+	var text bytes.Buffer
+	if err := s.templates[e.Event].Execute(&text, e); err != nil {
+		return err
+	}
+	body, err := json.Marshal(map[string]string{"text": text.String()})
+	if err != nil {
+		return err
+	}
+	return postNotification(ctx, client, s.url, "application/json", body, nil)
+}
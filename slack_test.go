@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestSlackNotifier tests the messages posted to Slack for each event, by
+// default and with a template replacing one
+func TestSlackNotifier(t *testing.T) {
+	tests := []struct {
+		name      string
+		templates map[string]string
+		event     Event
+		want      string
+	}{
+		{
+			name: "ip change",
+			event: Event{Event: EventIPChange, NewIP: "203.0.113.42",
+				Records: []string{"home.example.com A", "home.example.com TXT", "vpn.example.com A"}},
+			want: "home.example.com, vpn.example.com → 203.0.113.42",
+		},
+		{
+			name: "ip change with failures",
+			event: Event{Event: EventIPChange, NewIP: "203.0.113.42",
+				Records: []string{"home.example.com A"}, Errors: []string{"vpn.example.com: boom"}},
+			want: "home.example.com → 203.0.113.42 (1 failed: vpn.example.com: boom)",
+		},
+		{
+			name:  "failure",
+			event: Event{Event: EventUpdateFailed, Provider: "dreamhost", Errors: []string{"a: boom", "b: bang"}},
+			want:  ":warning: Updating records at dreamhost failed: a: boom; b: bang",
+		},
+		{
+			name:  "recovery",
+			event: Event{Event: EventRecovered, Provider: "dreamhost"},
+			want:  ":white_check_mark: Records at dreamhost are updating again",
+		},
+		{
+			name:      "template",
+			templates: map[string]string{EventIPChange: "{{.Host}}: {{.OldIP}} -> {{.NewIP}}"},
+			event:     Event{Event: EventIPChange, Host: "router", OldIP: "198.51.100.1", NewIP: "203.0.113.42"},
+			want:      "router: 198.51.100.1 -> 203.0.113.42",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got struct{ Text string }
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+				}
+			}))
+			defer server.Close()
+
+			notifier, err := newSlackNotifier(SlackNotificationConfig{URL: server.URL, Templates: tt.templates})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if err := notifier.notify(context.Background(), server.Client(), tt.event); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got.Text != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, got.Text)
+			}
+		})
+	}
+}
+
+// TestSlackNotifierHidesURL tests that a failed delivery doesn't put the
+// webhook URL, its credential, in the error
+func TestSlackNotifierHidesURL(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	url := server.URL + "/services/T000/B000/SECRET"
+	server.Close()
+
+	notifier, err := newSlackNotifier(SlackNotificationConfig{URL: url})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err = notifier.notify(context.Background(), http.DefaultClient, Event{Event: EventRecovered})
+	if err == nil || strings.Contains(err.Error(), "SECRET") {
+		t.Errorf("expected an error without the URL, got %v", err)
+	}
+}
+
+// TestValidateSlackNotification tests the checks of Slack notifications
+func TestValidateSlackNotification(t *testing.T) {
+	tests := []struct {
+		name    string
+		slack   SlackNotificationConfig
+		wantErr string
+	}{
+		{"valid", SlackNotificationConfig{URL: "https://hooks.slack.com/services/T/B/X", NotificationRoute: NotificationRoute{Events: notificationEvents}}, ""},
+		{"plain http", SlackNotificationConfig{URL: "http://hooks.slack.com/services/T/B/X"}, "not an https URL"},
+		{"unknown event", SlackNotificationConfig{URL: "https://hooks.slack.com/x", NotificationRoute: NotificationRoute{Events: []string{"change"}}}, `unknown event "change"`},
+		{"template event", SlackNotificationConfig{URL: "https://hooks.slack.com/x", Templates: map[string]string{"failure": "x"}}, `templates: unknown event "failure"`},
+		{"bad template", SlackNotificationConfig{URL: "https://hooks.slack.com/x", Templates: map[string]string{EventRecovered: "{{.Provider"}}, "templates: recovered"},
+		{"unknown field", SlackNotificationConfig{URL: "https://hooks.slack.com/x", Templates: map[string]string{EventIPChange: "{{.IP}}"}}, "can't evaluate field IP"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateSlackNotification(tt.slack)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
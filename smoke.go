@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// smokeOptions controls an end-to-end smoke test.
+type smokeOptions struct {
+	Zone         string
+	Type         string        // TXT or A
+	DNSTimeout   time.Duration // How long to wait for authoritative DNS; 0 skips DNS checks
+	PollInterval time.Duration
+}
+
+// smokeCleanupTimeout bounds deleting the disposable record, which happens
+// even after the smoke test itself was cancelled.
+const smokeCleanupTimeout = 30 * time.Second
+
+// smokeStep is the outcome of one step of a smoke test.
+type smokeStep struct {
+	Name     string
+	Duration time.Duration
+	Err      error
+}
+
+// dnsLookupFunc resolves the values of a record from authoritative DNS.
+type dnsLookupFunc func(ctx context.Context, name, recordType string) ([]string, error)
+
+// runSmoke creates a uniquely named record in the zone, checks it through
+// the API and authoritative DNS, updates it, and deletes it again. The
+// record is deleted even if an earlier step fails. It returns every step
+// attempted; the last one failed if any did.
+func runSmoke(ctx context.Context, provider Provider, lookup dnsLookupFunc, opts smokeOptions) []smokeStep {
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	domain := DomainConfig{Name: opts.Zone, Record: "_dh-ddns-smoke-" + hex.EncodeToString(suffix), Type: opts.Type}
+
+	first, second := "dh-ddns-updater smoke test 1", "dh-ddns-updater smoke test 2"
+	if opts.Type == "A" {
+		first, second = "192.0.2.1", "192.0.2.2" // TEST-NET-1, never routed
+	}
+
+	var steps []smokeStep
+	step := func(name string, fn func() error) bool {
+		start := time.Now()
+		err := fn()
+		steps = append(steps, smokeStep{Name: name, Duration: time.Since(start), Err: err})
+		return err == nil
+	}
+
+	apiHas := func(value string) func() error {
+		return func() error {
+			records, err := provider.GetRecords(ctx, domain)
+			if err != nil {
+				return err
+			}
+			return checkVerifiedRecords(domain, records, value)
+		}
+	}
+	dnsHas := func(value string) func() error {
+		return func() error {
+			return waitForDNS(ctx, lookup, domain, value, opts.DNSTimeout, opts.PollInterval)
+		}
+	}
+
+	created := step("create "+domain.FQDN(), func() error {
+		return provider.UpdateRecord(ctx, domain, "", first)
+	})
+	ok := created &&
+		step("verify via API", apiHas(first)) &&
+		(opts.DNSTimeout == 0 || step("verify via authoritative DNS", dnsHas(first))) &&
+		step("update", func() error { return provider.UpdateRecord(ctx, domain, first, second) }) &&
+		step("verify update via API", apiHas(second)) &&
+		(opts.DNSTimeout == 0 || step("verify update via authoritative DNS", dnsHas(second)))
+
+	if !created {
+		return steps
+	}
+
+	// Clean up regardless of the outcome, removing whichever value exists.
+	// This runs even if ctx was cancelled or timed out, under a deadline of
+	// its own so the command still terminates.
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), smokeCleanupTimeout)
+	defer cancel()
+	step("delete", func() error {
+		records, err := provider.GetRecords(ctx, domain)
+		if err != nil {
+			return err
+		}
+		for _, record := range records {
+			if err := provider.RemoveRecord(ctx, domain, record.Value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if ok && steps[len(steps)-1].Err == nil {
+		step("verify delete via API", func() error {
+			records, err := provider.GetRecords(ctx, domain)
+			if err != nil {
+				return err
+			}
+			if len(records) > 0 {
+				return fmt.Errorf("%d records still present", len(records))
+			}
+			return nil
+		})
+	}
+	return steps
+}
+
+// waitForDNS polls authoritative DNS until the record holds value.
+func waitForDNS(ctx context.Context, lookup dnsLookupFunc, domain DomainConfig, value string, timeout, interval time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var lastErr error
+	for {
+		values, err := lookup(ctx, domain.FQDN(), domain.Type)
+		if err == nil && slices.ContainsFunc(values, func(v string) bool { return recordValuesEqual(domain.Type, v, value) }) {
+			return nil
+		}
+		if err != nil {
+			lastErr = err
+		} else {
+			lastErr = fmt.Errorf("got %v", values)
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%s not visible after %s (last result: %v)", value, timeout, lastErr)
+		case <-time.After(interval):
+		}
+	}
+}
+
+// nameserverLookup returns a lookup that queries nameserver directly,
+// bypassing the system resolver and its caches.
+func nameserverLookup(nameserver string) dnsLookupFunc {
+	if _, _, err := net.SplitHostPort(nameserver); err != nil {
+		nameserver = net.JoinHostPort(nameserver, "53")
+	}
+	resolver := &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, nameserver)
+		},
+	}
+	return func(ctx context.Context, name, recordType string) ([]string, error) {
+		switch recordType {
+		case "TXT":
+			return resolver.LookupTXT(ctx, name)
+		case "A":
+			ips, err := resolver.LookupIP(ctx, "ip4", name)
+			var values []string
+			for _, ip := range ips {
+				values = append(values, ip.String())
+			}
+			return values, err
+		}
+		return nil, fmt.Errorf("unsupported record type %s", recordType)
+	}
+}
+
+// printSmokeSteps writes a table of the steps and their timings.
+func printSmokeSteps(w io.Writer, steps []smokeStep) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "STEP\tRESULT\tTIME")
+	for _, s := range steps {
+		result := "ok"
+		if s.Err != nil {
+			result = "FAILED: " + s.Err.Error()
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", s.Name, result, s.Duration.Round(time.Millisecond))
+	}
+	tw.Flush()
+}
+
+// runSmokeCommand runs an end-to-end check of credentials, connectivity and
+// propagation against a disposable record.
+//
+//	dh-ddns-updater smoke --zone example.com [--type TXT|A] [--provider name]
+//	    [--nameserver ns1.dreamhost.com] [--dns-timeout 5m] [--profile name]
+//	    [--timeout duration] [config]
+func runSmokeCommand(args []string) int {
+	fs := flag.NewFlagSet("smoke", flag.ContinueOnError)
+	zone := fs.String("zone", "", "zone to create the disposable record in (required)")
+	recordType := fs.String("type", "TXT", "record type to test: TXT or A")
+	providerName := fs.String("provider", DefaultProvider, "provider to test")
+	nameserver := fs.String("nameserver", "ns1.dreamhost.com", "authoritative nameserver to query")
+	dnsTimeout := fs.Duration("dns-timeout", 5*time.Minute, "how long to wait for authoritative DNS (0 skips DNS checks)")
+	opts := configFlags(fs)
+	timeout := timeoutFlag(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: dh-ddns-updater smoke --zone example.com [flags] [config]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	*recordType = strings.ToUpper(*recordType)
+	if *zone == "" || (*recordType != "TXT" && *recordType != "A") {
+		fs.Usage()
+		return 2
+	}
+
+	updater, err := newCommandUpdater(commandConfigPath(fs), *opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to initialize updater: %v\n", err)
+		return 1
+	}
+	provider, ok := updater.providers[*providerName]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown provider %q\n", *providerName)
+		return 1
+	}
+
+	ctx, cancel := commandContext(*timeout)
+	defer cancel()
+
+	start := time.Now()
+	steps := runSmoke(ctx, provider, nameserverLookup(*nameserver), smokeOptions{
+		Zone:         *zone,
+		Type:         *recordType,
+		DNSTimeout:   *dnsTimeout,
+		PollInterval: 5 * time.Second,
+	})
+	printSmokeSteps(os.Stdout, steps)
+
+	var errs []error
+	for _, s := range steps {
+		errs = append(errs, s.Err)
+	}
+	if err := errors.Join(errs...); err != nil {
+		fmt.Fprintf(os.Stderr, "Smoke test failed after %s\n", time.Since(start).Round(time.Millisecond))
+		return 1
+	}
+	fmt.Printf("Smoke test passed in %s\n", time.Since(start).Round(time.Millisecond))
+	return 0
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestRunSmoke tests the full create/verify/update/delete cycle and cleanup after a failure
+func TestRunSmoke(t *testing.T) {
+	tests := []struct {
+		name       string
+		recordType string
+		dropValue  string // Value whose add the fake API silently drops
+		cancelDNS  bool   // Cancel the run, as --timeout would, while waiting for DNS
+		wantSteps  int
+		wantFailed string
+	}{
+		{name: "TXT passes", recordType: "TXT", wantSteps: 8},
+		{name: "A passes", recordType: "A", wantSteps: 8},
+		{name: "lost update", recordType: "A", dropValue: "192.0.2.2", wantSteps: 6, wantFailed: "verify update via API"},
+		{name: "cancelled", recordType: "TXT", cancelDNS: true, wantSteps: 4, wantFailed: "verify via authoritative DNS"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := newFakeDreamhost()
+			if tt.dropValue != "" {
+				fake.dropAdds[tt.dropValue] = true
+			}
+			updater := newPlanTestUpdater(t, fake, "")
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			// Authoritative DNS serves whatever the fake API holds
+			lookup := func(ctx context.Context, name, recordType string) ([]string, error) {
+				if tt.cancelDNS {
+					cancel()
+					return nil, ctx.Err()
+				}
+				if v := fake.value(name, recordType); v != "" {
+					return []string{v}, nil
+				}
+				return nil, nil
+			}
+
+			steps := runSmoke(ctx, updater.providers[DefaultProvider], lookup, smokeOptions{
+				Zone:         "example.com",
+				Type:         tt.recordType,
+				DNSTimeout:   time.Second,
+				PollInterval: time.Millisecond,
+			})
+
+			var out bytes.Buffer
+			printSmokeSteps(&out, steps)
+			if len(steps) != tt.wantSteps {
+				t.Fatalf("expected %d steps, got:\n%s", tt.wantSteps, out.String())
+			}
+			for _, s := range steps {
+				if failed := s.Err != nil; failed != (s.Name == tt.wantFailed) {
+					t.Errorf("step %q: unexpected result %v\n%s", s.Name, s.Err, out.String())
+				}
+			}
+			if !strings.HasPrefix(steps[0].Name, "create _dh-ddns-smoke-") {
+				t.Errorf("expected a disposable record name, got %q", steps[0].Name)
+			}
+
+			fake.mu.Lock()
+			defer fake.mu.Unlock()
+			if len(fake.records) != 0 {
+				t.Errorf("expected the smoke record to be cleaned up, got %+v", fake.records)
+			}
+		})
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// sqliteCommand runs SQL against a state database. There is no SQLite
+// driver in the standard library, and the sqlite3 shell is packaged
+// everywhere SQLite is.
+var sqliteCommand = "sqlite3"
+
+// sqliteTimeout bounds one run of sqliteCommand, such as one state save.
+const sqliteTimeout = 30 * time.Second
+
+// sqliteTime is how times are stored, in UTC and with a fixed width so
+// that they sort as text.
+const sqliteTime = "2006-01-02T15:04:05.000000000Z"
+
+// sqliteSchema creates the state database's tables: the value of each
+// record, the remaining state fields by name, every IP change, and how each
+// record's updates have gone.
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS records (name TEXT PRIMARY KEY, value TEXT NOT NULL);
+CREATE TABLE IF NOT EXISTS meta (key TEXT PRIMARY KEY, value TEXT NOT NULL);
+CREATE TABLE IF NOT EXISTS events (
+	id INTEGER PRIMARY KEY,
+	time TEXT NOT NULL,
+	old_ip TEXT NOT NULL,
+	new_ip TEXT NOT NULL,
+	records_updated INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS record_status (
+	name TEXT PRIMARY KEY,
+	last_updated TEXT NOT NULL,
+	last_verified TEXT NOT NULL,
+	last_failure TEXT NOT NULL,
+	consecutive_failures INTEGER NOT NULL,
+	last_error TEXT NOT NULL
+);
+`
+
+// sqliteStateStore keeps the state in a SQLite database, for users who
+// want to query it. Unlike the JSON file's bounded history, the events
+// table keeps every IP change; the state loaded holds the newest
+// historySize of them.
+type sqliteStateStore struct {
+	path        string
+	historySize int
+}
+
+// Load implements StateStore. A missing database is created.
+func (s sqliteStateStore) Load() (*State, error) {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return nil, fmt.Errorf("creating state directory: %w", err)
+	}
+	if _, err := s.run(sqliteSchema); err != nil {
+		return nil, err
+	}
+
+	state := &State{Records: make(map[string]string)}
+	var records []struct{ Name, Value string }
+	if err := s.query("SELECT name, value FROM records", &records); err != nil {
+		return nil, err
+	}
+	for _, r := range records {
+		state.Records[r.Name] = r.Value
+	}
+
+	var statuses []struct {
+		Name                string `json:"name"`
+		LastUpdated         string `json:"last_updated"`
+		LastVerified        string `json:"last_verified"`
+		LastFailure         string `json:"last_failure"`
+		ConsecutiveFailures int    `json:"consecutive_failures"`
+		LastError           string `json:"last_error"`
+	}
+	if err := s.query("SELECT * FROM record_status", &statuses); err != nil {
+		return nil, err
+	}
+	for _, r := range statuses {
+		if state.RecordStatus == nil {
+			state.RecordStatus = make(map[string]*RecordStatus)
+		}
+		status := &RecordStatus{ConsecutiveFailures: r.ConsecutiveFailures, LastError: r.LastError}
+		status.LastUpdated = parseSQLiteTime(r.LastUpdated)
+		status.LastVerified = parseSQLiteTime(r.LastVerified)
+		status.LastFailure = parseSQLiteTime(r.LastFailure)
+		state.RecordStatus[r.Name] = status
+	}
+
+	var meta []struct{ Key, Value string }
+	if err := s.query("SELECT key, value FROM meta", &meta); err != nil {
+		return nil, err
+	}
+	for _, m := range meta {
+		switch m.Key {
+		case "last_ip":
+			state.LastIP = m.Value
+		case "last_updated":
+			state.LastUpdated, _ = time.Parse(sqliteTime, m.Value)
+		case "version":
+			state.Version = m.Value
+		case "backoff":
+			if err := json.Unmarshal([]byte(m.Value), &state.Backoff); err != nil {
+				return nil, fmt.Errorf("reading state database: %w", err)
+			}
+		}
+	}
+
+	var events []struct {
+		Time           string `json:"time"`
+		OldIP          string `json:"old_ip"`
+		NewIP          string `json:"new_ip"`
+		RecordsUpdated int    `json:"records_updated"`
+	}
+	query := fmt.Sprintf("SELECT time, old_ip, new_ip, records_updated FROM (SELECT * FROM events ORDER BY id DESC LIMIT %d) ORDER BY id", max(s.historySize, 1))
+	if err := s.query(query, &events); err != nil {
+		return nil, err
+	}
+	for _, e := range events {
+		t, _ := time.Parse(sqliteTime, e.Time)
+		state.History = append(state.History, IPChange{Time: t, OldIP: e.OldIP, NewIP: e.NewIP, RecordsUpdated: e.RecordsUpdated})
+	}
+	return state, nil
+}
+
+// Save implements StateStore in one transaction. History entries newer
+// than the newest event stored are added, and the newest event is updated
+// as records are counted towards it; see recordIPChange.
+func (s sqliteStateStore) Save(state *State) error {
+	var b strings.Builder
+	b.WriteString(sqliteSchema)
+	b.WriteString("BEGIN;\nDELETE FROM records;\n")
+	for name, value := range state.Records {
+		fmt.Fprintf(&b, "INSERT INTO records VALUES (%s, %s);\n", sqlQuote(name), sqlQuote(value))
+	}
+	b.WriteString("DELETE FROM record_status;\n")
+	for name, r := range state.RecordStatus {
+		fmt.Fprintf(&b, "INSERT INTO record_status VALUES (%s, %s, %s, %s, %d, %s);\n", sqlQuote(name),
+			sqlQuote(formatSQLiteTime(r.LastUpdated)), sqlQuote(formatSQLiteTime(r.LastVerified)),
+			sqlQuote(formatSQLiteTime(r.LastFailure)), r.ConsecutiveFailures, sqlQuote(r.LastError))
+	}
+	backoff, err := json.Marshal(state.Backoff)
+	if err != nil {
+		return err
+	}
+	meta := map[string]string{
+		"last_ip":      state.LastIP,
+		"last_updated": state.LastUpdated.UTC().Format(sqliteTime),
+		"version":      state.Version,
+		"backoff":      string(backoff),
+	}
+	for key, value := range meta {
+		fmt.Fprintf(&b, "INSERT OR REPLACE INTO meta VALUES (%s, %s);\n", sqlQuote(key), sqlQuote(value))
+	}
+	for _, e := range state.History {
+		t := sqlQuote(e.Time.UTC().Format(sqliteTime))
+		fmt.Fprintf(&b, "UPDATE events SET records_updated = %d WHERE time = %s AND new_ip = %s;\n", e.RecordsUpdated, t, sqlQuote(e.NewIP))
+		fmt.Fprintf(&b, "INSERT INTO events (time, old_ip, new_ip, records_updated) SELECT %s, %s, %s, %d WHERE %s > coalesce((SELECT max(time) FROM events), '');\n",
+			t, sqlQuote(e.OldIP), sqlQuote(e.NewIP), e.RecordsUpdated, t)
+	}
+	b.WriteString("COMMIT;\n")
+	_, err = s.run(b.String())
+	return err
+}
+
+// formatSQLiteTime formats t as sqliteTime, or as the empty string if it is
+// zero.
+func formatSQLiteTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(sqliteTime)
+}
+
+// parseSQLiteTime parses a time written by formatSQLiteTime.
+func parseSQLiteTime(s string) time.Time {
+	t, _ := time.Parse(sqliteTime, s)
+	return t
+}
+
+// query runs a SELECT and decodes its rows into out, a pointer to a slice
+// of structs.
+func (s sqliteStateStore) query(sql string, out any) error {
+	data, err := s.run(sql, "-json")
+	if err != nil {
+		return err
+	}
+	if len(strings.TrimSpace(string(data))) == 0 {
+		return nil // No rows
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("reading state database: %w", err)
+	}
+	return nil
+}
+
+// run runs SQL, given on standard input so its size is unlimited, against
+// the database, stopping at the first error.
+func (s sqliteStateStore) run(sql string, flags ...string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), sqliteTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, sqliteCommand, append(append([]string{"-bail"}, flags...), s.path)...)
+	cmd.Stdin = strings.NewReader(sql)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			err = fmt.Errorf("%w: %s", err, msg)
+		}
+		return nil, fmt.Errorf("state database %s: %w", s.path, err)
+	}
+	return out, nil
+}
+
+// sqlQuote returns s as an SQL string literal.
+func sqlQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
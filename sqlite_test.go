@@ -0,0 +1,64 @@
+package main
+
+import (
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestSQLiteStateStore tests saving and loading state through the sqlite3 shell, with every IP change kept
+func TestSQLiteStateStore(t *testing.T) {
+	if _, err := exec.LookPath(sqliteCommand); err != nil {
+		t.Skip("sqlite3 is not installed")
+	}
+	store := sqliteStateStore{path: filepath.Join(t.TempDir(), "state", "state.db"), historySize: 2}
+
+	state, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.LastIP != "" || len(state.Records) != 0 || len(state.History) != 0 {
+		t.Fatalf("expected an empty state, got %+v", state)
+	}
+
+	start := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	state = &State{
+		LastIP:      "203.0.113.2",
+		LastUpdated: start.Add(time.Hour),
+		Records:     map[string]string{"home.example.com/A": "203.0.113.2", "o'brien.example.com/A": "203.0.113.2"},
+		Version:     "1.1.0",
+		RecordStatus: map[string]*RecordStatus{
+			"home.example.com/A":    {LastUpdated: start, LastVerified: start.Add(time.Hour)},
+			"o'brien.example.com/A": {LastFailure: start, ConsecutiveFailures: 2, LastError: "o'brien.example.com: rate limited"},
+		},
+		History: []IPChange{
+			{Time: start, OldIP: "203.0.113.0", NewIP: "203.0.113.1", RecordsUpdated: 2},
+			{Time: start.Add(time.Hour), OldIP: "203.0.113.1", NewIP: "203.0.113.2", RecordsUpdated: 1},
+		},
+	}
+	if err := store.Save(state); err != nil {
+		t.Fatal(err)
+	}
+	// Another provider's update is counted, and a new change trims the loaded history
+	state.History[1].RecordsUpdated = 2
+	state.History = append(state.History[1:], IPChange{Time: start.Add(2 * time.Hour), OldIP: "203.0.113.2", NewIP: "203.0.113.3", RecordsUpdated: 2})
+	state.LastIP = "203.0.113.3"
+	if err := store.Save(state); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded, state) {
+		t.Errorf("expected %+v, got %+v", state, loaded)
+	}
+
+	var count []struct{ N int }
+	if err := store.query("SELECT count(*) AS n FROM events", &count); err != nil || count[0].N != 3 {
+		t.Errorf("expected every change kept in the events table, got %v (%v)", count, err)
+	}
+}
@@ -0,0 +1,456 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// StateStore persists the daemon's state between runs.
+type StateStore interface {
+	Load() (*State, error) // Returns the saved state, or an empty one if nothing was saved
+	Save(state *State) error
+}
+
+// stateStoreFor returns the store state_backend selects for config.
+func stateStoreFor(config *Config) StateStore {
+	switch config.StateBackend {
+	case "sqlite":
+		return sqliteStateStore{path: config.StatePath, historySize: config.HistorySize}
+	case "redis":
+		return sharedStateStore{kv: redisStore{config: config.Redis}, historySize: config.HistorySize}
+	case "etcd":
+		client := &http.Client{Timeout: sharedStateTimeout}
+		return sharedStateStore{kv: etcdStore{config: config.Etcd, client: client}, historySize: config.HistorySize}
+	case "consul":
+		client := &http.Client{Timeout: sharedStateTimeout}
+		return sharedStateStore{kv: consulStore{config: config.Consul, client: client}, historySize: config.HistorySize}
+	case "none":
+		return memoryStateStore{}
+	}
+	return jsonStateStore{path: config.StatePath}
+}
+
+// locksState reports whether config keeps its state in a file for one
+// instance, which is then locked; see lockState.
+func locksState(config *Config) bool {
+	switch config.StateBackend {
+	case "none", "redis", "etcd", "consul":
+		return false
+	}
+	return true
+}
+
+// memoryStateStore keeps no state between runs, for read-only filesystems
+// and containers. The daemon holds its state in memory, starting from an
+// empty one, so the current record values are read from the providers
+// when it starts.
+type memoryStateStore struct{}
+
+// Load implements StateStore with an empty state.
+func (memoryStateStore) Load() (*State, error) {
+	return &State{Records: make(map[string]string)}, nil
+}
+
+// Save implements StateStore, doing nothing.
+func (memoryStateStore) Save(state *State) error {
+	return nil
+}
+
+// jsonStateStore keeps the state in a JSON file, the default.
+type jsonStateStore struct {
+	path string
+}
+
+// Load implements StateStore; see loadState.
+func (s jsonStateStore) Load() (*State, error) {
+	return loadState(s.path)
+}
+
+// Save implements StateStore, creating the state directory if it doesn't
+// exist. The file is replaced whole, so a crash while saving leaves the
+// previous state rather than a truncated one, and it ends with a checksum
+// of its contents; see verifyStateChecksum.
+func (s jsonStateStore) Save(state *State) error {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := s.path + ".new"
+	if err := writeFileSync(tmp, addStateChecksum(data), 0644); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// writeFileSync is os.WriteFile, flushing the file to disk before closing
+// it.
+func writeFileSync(path string, data []byte, perm os.FileMode) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// stateChecksumField is appended to the state file as its last field,
+// followed by the hex SHA-256 of the file without it.
+const stateChecksumField = ",\n  \"checksum\": \"sha256:"
+
+// addStateChecksum appends the checksum field to a state marshaled as an
+// indented JSON object.
+func addStateChecksum(data []byte) []byte {
+	sum := sha256.Sum256(data)
+	body := bytes.TrimSuffix(data, []byte("\n}"))
+	return fmt.Appendf(body, "%s%s\"\n}\n", stateChecksumField, hex.EncodeToString(sum[:]))
+}
+
+// verifyStateChecksum checks the checksum a state file ends with and
+// returns the file without it. A file without a checksum, as written by
+// older releases or by hand, is returned unchanged.
+func verifyStateChecksum(data []byte) ([]byte, error) {
+	i := bytes.LastIndex(data, []byte(stateChecksumField))
+	if i < 0 {
+		return data, nil
+	}
+	want, rest, ok := strings.Cut(string(data[i+len(stateChecksumField):]), "\"")
+	if !ok || strings.TrimSpace(rest) != "}" {
+		return data, nil
+	}
+	body := append(data[:i:i], "\n}"...)
+	sum := sha256.Sum256(body)
+	if hex.EncodeToString(sum[:]) != want {
+		return nil, errors.New("checksum mismatch")
+	}
+	return body, nil
+}
+
+// stateCorruptError is returned by loadState when the state file is
+// truncated or otherwise damaged.
+type stateCorruptError struct {
+	path string
+	err  error
+}
+
+func (e *stateCorruptError) Error() string {
+	return fmt.Sprintf("state file %s is corrupt: %v", e.path, e.err)
+}
+
+func (e *stateCorruptError) Unwrap() error {
+	return e.err
+}
+
+// backupStateFile moves a corrupt state file aside, for inspection, and
+// returns where it went.
+func backupStateFile(path string) (string, error) {
+	backup := path + ".corrupt-" + time.Now().Format("20060102T150405")
+	return backup, os.Rename(path, backup)
+}
+
+// stateKey returns the key of a record in the state's Records and
+// RecordStatus, such as "home.example.com/A": a name can have records of
+// several types, each with its own value.
+func stateKey(fqdn, recordType string) string {
+	return fqdn + "/" + recordType
+}
+
+// splitStateKey returns the name and type of the record a state key is for.
+func splitStateKey(key string) (fqdn, recordType string) {
+	fqdn, recordType, _ = strings.Cut(key, "/")
+	return fqdn, recordType
+}
+
+// migrateStateKeys rekeys the records of a state written before the state
+// was keyed by name and type, and returns how many it rekeyed. The type of
+// a legacy entry is that of its value if it is an address, and otherwise
+// that of the one configured record with the name; entries whose type
+// can't be told are dropped, and are read from DNS again.
+func migrateStateKeys(state *State, config *Config) int {
+	legacyType := func(name, value string) string {
+		if ip := net.ParseIP(value); ip != nil {
+			if ip.To4() != nil {
+				return "A"
+			}
+			return "AAAA"
+		}
+		recordType := ""
+		for _, domain := range config.Domains {
+			if !strings.EqualFold(domain.FQDN(), name) || isAddressType(domain.Type) {
+				continue
+			}
+			if recordType != "" {
+				return "" // Several types the value could belong to
+			}
+			recordType = domain.Type
+		}
+		return recordType
+	}
+
+	migrated := 0
+	for name, value := range state.Records {
+		if strings.Contains(name, "/") {
+			continue
+		}
+		delete(state.Records, name)
+		status, hasStatus := state.RecordStatus[name]
+		delete(state.RecordStatus, name)
+		migrated++
+		if recordType := legacyType(name, value); recordType != "" {
+			key := stateKey(name, recordType)
+			state.Records[key] = value
+			if hasStatus {
+				state.RecordStatus[key] = status
+			}
+		}
+	}
+	for name := range state.RecordStatus {
+		if !strings.Contains(name, "/") {
+			// A status without a value, whose type is unknown
+			delete(state.RecordStatus, name)
+			migrated++
+		}
+	}
+	return migrated
+}
+
+// rebuildState fills the records of the state, which was lost to a corrupt
+// state file, with the values the providers hold for them. The last IP
+// stays unknown, so the first detection publishes as after an IP change.
+func (d *DDNSUpdater) rebuildState(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, d.config.CycleTimeout)
+	defer cancel()
+
+	rebuilt := 0
+	for _, domain := range d.config.Domains {
+		records, err := d.providers[domain.Provider].GetRecords(ctx, domain)
+		if err != nil {
+			d.logger.Warn("Couldn't read record to rebuild the state", "record", domain.FQDN(), "type", domain.Type, "error", err)
+			continue
+		}
+		// Only a record tagged as managed is known to be ours among the
+		// values a name can hold several of
+		if r := managedRecord(domain, records, "", ""); r != nil {
+			d.setRecordState(domain.FQDN(), domain.Type, r.Value)
+			rebuilt++
+		}
+	}
+
+	d.stateMu.Lock()
+	defer d.stateMu.Unlock()
+	if err := d.saveState(); err != nil {
+		d.logger.Error("Failed to save state", "error", err)
+	}
+	d.logger.Info("Rebuilt state from DNS", "records", rebuilt)
+}
+
+// stateCommands are the subcommands of the state command, which show the
+// state, move it between hosts or backends and clear it.
+var stateCommands = map[string]func(store StateStore, config *Config) error{
+	"show":   showStateCommand,
+	"export": exportStateCommand,
+	"import": importStateCommand,
+	"reset":  resetStateCommand,
+}
+
+func runStateCommand(args []string) int {
+	fs := flag.NewFlagSet("state", flag.ContinueOnError)
+	opts := configFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: dh-ddns-updater state show|export|import|reset [--profile name] [config]")
+		fmt.Fprintln(fs.Output(), "  show    print each record's value and status as a table")
+		fmt.Fprintln(fs.Output(), "  export  print the state as JSON")
+		fmt.Fprintln(fs.Output(), "  import  replace the state with JSON read from stdin")
+		fmt.Fprintln(fs.Output(), "  reset   forget the published values, so every record is checked again")
+		fs.PrintDefaults()
+	}
+	if len(args) == 0 || stateCommands[args[0]] == nil {
+		fs.Usage()
+		return 2
+	}
+	sub := stateCommands[args[0]]
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+
+	config, err := loadConfig(commandConfigPath(fs), *opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load config: %v\n", err)
+		return 1
+	}
+	applyConfigDefaults(config)
+	if config.StateBackend == "none" {
+		fmt.Fprintln(os.Stderr, "state_backend none keeps no state")
+		return 1
+	}
+	if args[0] != "show" && args[0] != "export" && locksState(config) {
+		// The daemon would overwrite the state with its own copy
+		lock, err := lockState(config.StatePath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to lock state, stop the daemon first: %v\n", err)
+			return 1
+		}
+		defer lock.Unlock()
+	}
+	if err := sub(stateStoreFor(config), config); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to %s state: %v\n", args[0], err)
+		return 1
+	}
+	return 0
+}
+
+func showStateCommand(store StateStore, config *Config) error {
+	state, err := store.Load()
+	if err != nil {
+		return err
+	}
+	migrateStateKeys(state, config) // As the daemon keeps it once upgraded
+	printState(os.Stdout, state, config)
+	return nil
+}
+
+// printState writes the last IP and a table of the configured records with
+// the value the state holds for each and how its updates have gone,
+// followed by any records the state holds that are no longer configured.
+func printState(w io.Writer, state *State, config *Config) {
+	when := func(t time.Time) string {
+		if t.IsZero() {
+			return "-"
+		}
+		return t.Local().Format(time.DateTime)
+	}
+	if state.LastIP == "" {
+		fmt.Fprintln(w, "Last IP: unknown")
+	} else {
+		fmt.Fprintf(w, "Last IP: %s (records last updated %s)\n", state.LastIP, when(state.LastUpdated))
+	}
+	fmt.Fprintln(w)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "RECORD\tTYPE\tVALUE\tLAST UPDATED\tLAST VERIFIED\tLAST ERROR\tNOTES")
+	row := func(key, name, recordType, value, notes string) {
+		status := state.RecordStatus[key]
+		if status == nil {
+			status = &RecordStatus{}
+		}
+		lastError := "-"
+		if status.ConsecutiveFailures > 0 {
+			lastError = fmt.Sprintf("%s (%d failures in a row since %s)", status.LastError, status.ConsecutiveFailures, when(status.LastFailure))
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", name, recordType, cmp.Or(value, "-"),
+			when(status.LastUpdated), when(status.LastVerified), lastError, cmp.Or(notes, "-"))
+	}
+	for _, domain := range config.Domains {
+		key := stateKey(domain.FQDN(), domain.Type)
+		row(key, domain.FQDN(), domain.Type, state.Records[key], domain.Notes)
+	}
+	stale := staleRecords(state, config)
+	for _, key := range slices.Sorted(maps.Keys(stale)) {
+		name, recordType := splitStateKey(key)
+		row(key, name+" *", recordType, stale[key], "")
+	}
+	tw.Flush()
+
+	if len(stale) > 0 {
+		fmt.Fprintln(w, "\nRecords marked * are no longer configured; the daemon drops them when it starts.")
+	}
+}
+
+// exportStateCommand prints the state as indented JSON, in the format of
+// state.json whichever backend holds it.
+func exportStateCommand(store StateStore, config *Config) error {
+	state, err := store.Load()
+	if err != nil {
+		return err
+	}
+	return writeStateJSON(os.Stdout, state)
+}
+
+// writeStateJSON writes state as indented JSON.
+func writeStateJSON(w io.Writer, state *State) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+func importStateCommand(store StateStore, config *Config) error {
+	state, err := readStateJSON(os.Stdin)
+	if err != nil {
+		return err
+	}
+	return store.Save(state)
+}
+
+// readStateJSON reads an exported state, or a copy of a state file with its
+// checksum. Unknown fields are rejected, as they mean the input isn't a
+// state or came from a newer release whose fields would be lost.
+func readStateJSON(r io.Reader) (*State, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("reading state: %w", err)
+	}
+	if data, err = verifyStateChecksum(data); err != nil {
+		return nil, fmt.Errorf("reading state: %w", err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	var state State
+	if err := dec.Decode(&state); err != nil {
+		return nil, fmt.Errorf("reading state: %w", err)
+	}
+	if state.Records == nil {
+		state.Records = make(map[string]string)
+	}
+	return &state, nil
+}
+
+func resetStateCommand(store StateStore, config *Config) error {
+	state, err := store.Load()
+	if err != nil {
+		return err
+	}
+	return store.Save(resetState(state))
+}
+
+// resetState returns state without the values it caches, so that the next
+// run treats the IP as new and checks every record against DNS. The
+// history of IP changes is kept.
+func resetState(state *State) *State {
+	return &State{
+		Records: make(map[string]string),
+		Version: state.Version,
+		History: state.History,
+	}
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestStateExportImport tests that an exported state imports unchanged into another store, and that other JSON is rejected
+func TestStateExportImport(t *testing.T) {
+	at := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	state := &State{
+		LastIP:       "203.0.113.2",
+		LastUpdated:  at,
+		Records:      map[string]string{"home.example.com/A": "203.0.113.2"},
+		Version:      "1.1.0",
+		History:      []IPChange{{Time: at, OldIP: "203.0.113.1", NewIP: "203.0.113.2", RecordsUpdated: 1}},
+		RecordStatus: map[string]*RecordStatus{"home.example.com/A": {LastUpdated: at, LastVerified: at}},
+	}
+
+	var out bytes.Buffer
+	if err := writeStateJSON(&out, state); err != nil {
+		t.Fatal(err)
+	}
+	imported, err := readStateJSON(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := jsonStateStore{path: filepath.Join(t.TempDir(), "state", "state.json")}
+	if err := store.Save(imported); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded, state) {
+		t.Errorf("expected %+v, got %+v", state, loaded)
+	}
+
+	tests := []struct {
+		input, wantErr string
+	}{
+		{`{"last_ip": "203.0.113.2"}`, ""},
+		{`{"last_ip": "203.0.113.2", "domains": []}`, `unknown field "domains"`},
+		{`domains: []`, "reading state"},
+	}
+	for _, tt := range tests {
+		state, err := readStateJSON(strings.NewReader(tt.input))
+		switch {
+		case tt.wantErr == "" && err != nil:
+			t.Errorf("%s: unexpected error: %v", tt.input, err)
+		case tt.wantErr == "" && state.Records == nil:
+			t.Errorf("%s: expected an empty records map", tt.input)
+		case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+			t.Errorf("%s: expected error containing %q, got %v", tt.input, tt.wantErr, err)
+		}
+	}
+}
+
+// TestResetState tests that a reset forgets the cached values but keeps the history
+func TestResetState(t *testing.T) {
+	history := []IPChange{{OldIP: "203.0.113.1", NewIP: "203.0.113.2", RecordsUpdated: 1}}
+	state := resetState(&State{
+		LastIP:       "203.0.113.2",
+		LastUpdated:  time.Now(),
+		Records:      map[string]string{"home.example.com/A": "203.0.113.2"},
+		Version:      "1.1.0",
+		History:      history,
+		RecordStatus: map[string]*RecordStatus{"home.example.com/A": {ConsecutiveFailures: 1}},
+	})
+
+	want := &State{Records: map[string]string{}, Version: "1.1.0", History: history}
+	if !reflect.DeepEqual(state, want) {
+		t.Errorf("expected %+v, got %+v", want, state)
+	}
+}
+
+// TestMemoryStateStore tests that state_backend none touches no files and always starts empty
+func TestMemoryStateStore(t *testing.T) {
+	dir := t.TempDir()
+	config := &Config{StateBackend: "none", StatePath: filepath.Join(dir, "state.json")}
+	if locksState(config) {
+		t.Error("expected no state file")
+	}
+
+	store := stateStoreFor(config)
+	if err := store.Save(&State{LastIP: "203.0.113.2", Records: map[string]string{"home.example.com/A": "203.0.113.2"}}); err != nil {
+		t.Fatal(err)
+	}
+	state, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.LastIP != "" || state.Records == nil || len(state.Records) != 0 {
+		t.Errorf("expected an empty state, got %+v", state)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("expected no files written, got %v", entries)
+	}
+}
+
+// TestStateChecksum tests that a saved state file is checked on load, and that damage is reported as corruption
+func TestStateChecksum(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	state := &State{LastIP: "203.0.113.2", Records: map[string]string{"home.example.com/A": "203.0.113.2"}}
+	if err := (jsonStateStore{path: path}).Save(state); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"checksum": "sha256:`) {
+		t.Fatalf("expected a checksum in:\n%s", data)
+	}
+
+	tests := []struct {
+		name    string
+		data    string
+		corrupt bool
+	}{
+		{"as saved", string(data), false},
+		{"without checksum", `{"last_ip": "203.0.113.2", "records": {}}`, false},
+		{"edited", strings.Replace(string(data), "203.0.113.2", "203.0.113.9", 1), true},
+		{"truncated", string(data[:len(data)/2]), true},
+		{"empty", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "state.json")
+			if err := os.WriteFile(path, []byte(tt.data), 0644); err != nil {
+				t.Fatal(err)
+			}
+			loaded, err := loadState(path)
+			var corrupt *stateCorruptError
+			switch {
+			case tt.corrupt && !errors.As(err, &corrupt):
+				t.Errorf("expected a corrupt state error, got %v", err)
+			case !tt.corrupt && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case !tt.corrupt && loaded.LastIP != "203.0.113.2":
+				t.Errorf("expected the state loaded, got %+v", loaded)
+			}
+		})
+	}
+
+	// A copy of the state file can be imported
+	if _, err := readStateJSON(strings.NewReader(string(data))); err != nil {
+		t.Errorf("unexpected error importing a state file: %v", err)
+	}
+}
+
+// TestCorruptStateRecovery tests that a corrupt state file is moved aside and the records read back from DNS
+func TestCorruptStateRecovery(t *testing.T) {
+	dir := t.TempDir()
+	statePath := filepath.Join(dir, "state.json")
+	if err := os.WriteFile(statePath, []byte(`{"last_ip": "203.0.1`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	configPath := filepath.Join(dir, "config.yaml")
+	config := fmt.Sprintf("dreamhost_api_key: key\nstate_path: %s\ndomains:\n  - name: example.com\n    record: home\n    type: A\n", statePath)
+	if err := os.WriteFile(configPath, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := newDDNSUpdater(configPath, configOptions{}, io.Discard)
+	if err != nil {
+		t.Fatalf("expected recovery from the corrupt state, got %v", err)
+	}
+	if !d.rebuild || len(d.state.Records) != 0 {
+		t.Errorf("expected an empty state to rebuild, got %+v", d.state)
+	}
+	if backups, _ := filepath.Glob(statePath + ".corrupt-*"); len(backups) != 1 {
+		t.Errorf("expected the corrupt file backed up, got %v", backups)
+	}
+
+	fake := newFakeDreamhost(DNSRecord{Record: "home.example.com", Type: "A", Value: "203.0.113.42", Comment: ManagedComment})
+	updater := newPlanTestUpdater(t, fake, "", DomainConfig{Name: "example.com", Record: "home", Type: "A"})
+	updater.config.CycleTimeout = time.Minute
+	updater.rebuildState(context.Background())
+	if got := updater.state.Records["home.example.com/A"]; got != "203.0.113.42" {
+		t.Errorf("expected the record read from DNS, got %q", got)
+	}
+	if loaded, err := loadState(updater.config.StatePath); err != nil || loaded.Records["home.example.com/A"] != "203.0.113.42" {
+		t.Errorf("expected the rebuilt state saved, got %+v (%v)", loaded, err)
+	}
+}
+
+// TestMigrateStateKeys tests that a state keyed by name alone is rekeyed by name and type
+func TestMigrateStateKeys(t *testing.T) {
+	config := &Config{Domains: []DomainConfig{
+		{Name: "example.com", Record: "home", Type: "A"},
+		{Name: "example.com", Record: "home", Type: "AAAA"},
+		{Name: "example.com", Record: "alias", Type: "CNAME"},
+		{Name: "example.com", Record: "both", Type: "TXT"},
+		{Name: "example.com", Record: "both", Type: "MX"},
+	}}
+	state := &State{
+		Records: map[string]string{
+			"home.example.com":   "2001:db8::1",
+			"v4.example.com":     "203.0.113.2",
+			"alias.example.com":  "target.example.net",
+			"both.example.com":   "v=spf1 -all",
+			"done.example.com/A": "203.0.113.3",
+		},
+		RecordStatus: map[string]*RecordStatus{
+			"home.example.com":  {ConsecutiveFailures: 1},
+			"alias.example.com": {ConsecutiveFailures: 2},
+			"both.example.com":  {ConsecutiveFailures: 3},
+			"lost.example.com":  {ConsecutiveFailures: 4},
+		},
+	}
+
+	if n := migrateStateKeys(state, config); n != 5 {
+		t.Errorf("expected 5 entries migrated, got %d", n)
+	}
+	wantRecords := map[string]string{
+		"home.example.com/AAAA":   "2001:db8::1",
+		"v4.example.com/A":        "203.0.113.2",
+		"alias.example.com/CNAME": "target.example.net",
+		"done.example.com/A":      "203.0.113.3",
+	}
+	if !maps.Equal(state.Records, wantRecords) {
+		t.Errorf("expected records %v, got %v", wantRecords, state.Records)
+	}
+	if len(state.RecordStatus) != 2 || state.RecordStatus["home.example.com/AAAA"].ConsecutiveFailures != 1 || state.RecordStatus["alias.example.com/CNAME"].ConsecutiveFailures != 2 {
+		t.Errorf("expected the statuses moved with their values, got %v", state.RecordStatus)
+	}
+	if n := migrateStateKeys(state, config); n != 0 {
+		t.Errorf("expected nothing left to migrate, got %d", n)
+	}
+}
+
+// TestPrintState tests the state table, including failing and no longer configured records
+func TestPrintState(t *testing.T) {
+	at := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	config := &Config{Domains: []DomainConfig{
+		{Name: "example.com", Record: "home", Type: "A"},
+		{Name: "example.com", Record: "home", Type: "AAAA"},
+		{Name: "example.com", Record: "txt", Type: "TXT", Notes: "SPF for the mail relay"},
+	}}
+	state := &State{
+		LastIP:      "203.0.113.2",
+		LastUpdated: at,
+		Records:     map[string]string{"home.example.com/A": "203.0.113.2", "home.example.com/AAAA": "2001:db8::2", "old.example.com/A": "203.0.113.1"},
+		RecordStatus: map[string]*RecordStatus{
+			"home.example.com/A":  {LastUpdated: at, LastVerified: at},
+			"txt.example.com/TXT": {LastFailure: at, ConsecutiveFailures: 2, LastError: "rate limited"},
+		},
+	}
+
+	var out bytes.Buffer
+	printState(&out, state, config)
+	stamp := at.Local().Format(time.DateTime)
+	lines := strings.Split(out.String(), "\n")
+	for _, want := range []struct {
+		line   int
+		fields []string
+	}{
+		{0, []string{"Last IP: 203.0.113.2", stamp}},
+		{2, []string{"RECORD", "TYPE", "VALUE", "LAST UPDATED", "LAST ERROR", "NOTES"}},
+		{3, []string{"home.example.com", "A", "203.0.113.2", stamp}},
+		{4, []string{"home.example.com", "AAAA", "2001:db8::2"}},
+		{5, []string{"txt.example.com", "TXT", "rate limited (2 failures in a row since " + stamp + ")", "SPF for the mail relay"}},
+		{6, []string{"old.example.com *", "A", "203.0.113.1"}},
+	} {
+		for _, field := range want.fields {
+			if len(lines) <= want.line || !strings.Contains(lines[want.line], field) {
+				t.Errorf("expected %q on line %d of:\n%s", field, want.line, out.String())
+			}
+		}
+	}
+	if !strings.Contains(out.String(), "no longer configured") {
+		t.Errorf("expected a note on records no longer configured in:\n%s", out.String())
+	}
+}
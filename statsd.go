@@ -0,0 +1,166 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// StatsDConfig configures sending metrics to a StatsD server over UDP, for
+// setups that collect metrics by push rather than by scraping.
+type StatsDConfig struct {
+	Address string            `yaml:"address"` // host:port of the server; empty sends no metrics
+	Prefix  string            `yaml:"prefix"`  // Prepended to every metric name (default "dh_ddns_updater.")
+	Tags    map[string]string `yaml:"tags"`    // Added to every metric; requires dogstatsd
+	// DogStatsD sends tags as DogStatsD does (|#key:value), which Datadog
+	// and Telegraf understand. Plain StatsD has no tags, so without it the
+	// values of a metric's tags are appended to its name instead.
+	DogStatsD bool `yaml:"dogstatsd"`
+}
+
+// DefaultStatsDPrefix is the metric name prefix used unless prefix says
+// otherwise.
+const DefaultStatsDPrefix = "dh_ddns_updater."
+
+// validateStatsD checks the statsd block.
+func validateStatsD(config StatsDConfig) error {
+	if config.Address == "" {
+		return nil
+	}
+	if _, _, err := net.SplitHostPort(config.Address); err != nil {
+		return fmt.Errorf("statsd: address %q is not host:port", config.Address)
+	}
+	if len(config.Tags) > 0 && !config.DogStatsD {
+		return errors.New("statsd: tags require dogstatsd")
+	}
+	return nil
+}
+
+// statsd sends metrics to a StatsD server. Metrics are best effort: each is
+// one UDP datagram, sent without waiting, and failures to send are dropped
+// after the first is logged. A nil *statsd sends nothing.
+type statsd struct {
+	config StatsDConfig
+	logger *slog.Logger
+	tags   []string // The configured tags, as key:value, sorted
+
+	mu      sync.Mutex
+	conn    net.Conn
+	failing bool
+	closed  bool
+}
+
+// newStatsD returns the client config asks for, or nil if it doesn't ask
+// for one.
+func newStatsD(config StatsDConfig, logger *slog.Logger) *statsd {
+	if config.Address == "" {
+		return nil
+	}
+	s := &statsd{config: config, logger: logger}
+	for _, key := range slices.Sorted(maps.Keys(config.Tags)) {
+		s.tags = append(s.tags, statsdTagPart(key)+":"+statsdTagPart(config.Tags[key]))
+	}
+	return s
+}
+
+// count adds n to a counter. tags are key, value pairs.
+func (s *statsd) count(name string, n int, tags ...string) {
+	s.send(name, strconv.Itoa(n), "c", tags)
+}
+
+// timing records a duration in a timer, in milliseconds.
+func (s *statsd) timing(name string, d time.Duration, tags ...string) {
+	s.send(name, strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', -1, 64), "ms", tags)
+}
+
+// send sends a metric of kind, dialing the server on first use.
+func (s *statsd) send(name, value, kind string, tags []string) {
+	if s == nil {
+		return
+	}
+	line := s.format(name, value, kind, tags)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	var err error
+	if s.conn == nil {
+		s.conn, err = net.Dial("udp", s.config.Address)
+	}
+	if err == nil {
+		_, err = s.conn.Write([]byte(line))
+	}
+	if err != nil && !s.failing {
+		s.logger.Warn("Failed to send metrics to StatsD; dropping them until it works again", "address", s.config.Address, "error", err)
+	}
+	s.failing = err != nil
+}
+
+// format returns the line of a metric: prefix, name, value and kind, and
+// the tags in DogStatsD's way or, for plain StatsD, their values appended
+// to the name.
+func (s *statsd) format(name, value, kind string, tags []string) string {
+	var b strings.Builder
+	b.WriteString(s.config.Prefix)
+	b.WriteString(name)
+	if !s.config.DogStatsD {
+		for i := 1; i < len(tags); i += 2 {
+			b.WriteString("." + statsdNamePart(tags[i]))
+		}
+	}
+	fmt.Fprintf(&b, ":%s|%s", value, kind)
+	if s.config.DogStatsD && (len(s.tags) > 0 || len(tags) > 1) {
+		all := slices.Clone(s.tags)
+		for i := 0; i+1 < len(tags); i += 2 {
+			all = append(all, statsdTagPart(tags[i])+":"+statsdTagPart(tags[i+1]))
+		}
+		b.WriteString("|#" + strings.Join(all, ","))
+	}
+	return b.String()
+}
+
+// statsdNamePart returns s made safe as a part of a metric name, which
+// dots separate.
+func statsdNamePart(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+// statsdTagPart returns s made safe as a DogStatsD tag key or value.
+func statsdTagPart(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ',', '|', '#', ':', '\n', ' ':
+			return '_'
+		}
+		return r
+	}, s)
+}
+
+// Close closes the connection; later metrics aren't sent.
+func (s *statsd) Close() error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	if s.conn == nil {
+		return nil
+	}
+	return s.conn.Close()
+}
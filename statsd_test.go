@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestStatsDFormat tests metric lines with tags sent the DogStatsD way and
+// appended to the name for plain StatsD
+func TestStatsDFormat(t *testing.T) {
+	tests := []struct {
+		name   string
+		config StatsDConfig
+		tags   []string
+		want   string
+	}{
+		{"plain", StatsDConfig{Prefix: "ddns."}, nil, "ddns.records.updated:1|c"},
+		{"plain tags", StatsDConfig{Prefix: "ddns."}, []string{"provider", "dreamhost", "type", "AAAA"}, "ddns.records.updated.dreamhost.AAAA:1|c"},
+		{"plain unsafe tag", StatsDConfig{Prefix: "ddns."}, []string{"stage", "publication:dreamhost"}, "ddns.records.updated.publication_dreamhost:1|c"},
+		{"dogstatsd", StatsDConfig{Prefix: "ddns.", DogStatsD: true}, []string{"provider", "dreamhost"}, "ddns.records.updated:1|c|#provider:dreamhost"},
+		{"dogstatsd constant tags", StatsDConfig{Prefix: "ddns.", DogStatsD: true, Tags: map[string]string{"host": "router", "env": "home"}}, []string{"type", "A"}, "ddns.records.updated:1|c|#env:home,host:router,type:A"},
+		{"dogstatsd unsafe tag", StatsDConfig{Prefix: "ddns.", DogStatsD: true}, []string{"stage", "publication:dreamhost"}, "ddns.records.updated:1|c|#stage:publication_dreamhost"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.config.Address = "127.0.0.1:8125"
+			s := newStatsD(tt.config, slog.New(slog.NewTextHandler(io.Discard, nil)))
+			if got := s.format("records.updated", "1", "c", tt.tags); got != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+// TestStatsDSend tests that stage runs and provider latency reach the
+// server, and that nothing is sent once closed
+func TestStatsDSend(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listening: %v", err)
+	}
+	defer conn.Close()
+	s := newStatsD(StatsDConfig{Address: conn.LocalAddr().String(), Prefix: DefaultStatsDPrefix, DogStatsD: true}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	stage := NewStage("detection", time.Minute, time.Minute, func(context.Context) error { return errors.New("timeout") }, nil)
+	stage.Stats = s
+	stage.Execute(context.Background())
+	h := &providerHandle{name: "dreamhost", breaker: &circuitBreaker{threshold: 5}, stats: s}
+	h.observe(context.Background(), "get_records", time.Now(), nil)
+
+	want := []string{
+		"dh_ddns_updater.stage.runs:1|c|#stage:detection",
+		"dh_ddns_updater.stage.failures:1|c|#stage:detection",
+		"dh_ddns_updater.stage.duration:",
+		"dh_ddns_updater.provider.latency:",
+	}
+	buf := make([]byte, 512)
+	var got string
+	for _, prefix := range want {
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("expected %s, got %v", prefix, err)
+		}
+		if got = string(buf[:n]); !strings.HasPrefix(got, prefix) {
+			t.Errorf("expected %s, got %s", prefix, got)
+		}
+	}
+	if !strings.HasSuffix(got, "|ms|#provider:dreamhost,operation:get_records,outcome:ok") {
+		t.Errorf("expected latency tagged with the call, got %s", got)
+	}
+
+	s.Close()
+	s.count("records.updated", 1)
+	conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if n, _, err := conn.ReadFrom(buf); err == nil {
+		t.Errorf("expected nothing after closing, got %s", buf[:n])
+	}
+}
+
+// TestValidateStatsD tests the checks of the statsd block
+func TestValidateStatsD(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  StatsDConfig
+		wantErr string
+	}{
+		{"disabled", StatsDConfig{}, ""},
+		{"valid", StatsDConfig{Address: "localhost:8125"}, ""},
+		{"no port", StatsDConfig{Address: "localhost"}, "not host:port"},
+		{"tags without dogstatsd", StatsDConfig{Address: "localhost:8125", Tags: map[string]string{"env": "home"}}, "tags require dogstatsd"},
+		{"tags with dogstatsd", StatsDConfig{Address: "localhost:8125", DogStatsD: true, Tags: map[string]string{"env": "home"}}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateStatsD(tt.config)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
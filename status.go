@@ -0,0 +1,310 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+// statusQueryTimeout bounds how long the status command waits for the
+// daemon before falling back to the saved state.
+const statusQueryTimeout = 5 * time.Second
+
+// Status is a snapshot of what the daemon is doing, as /status reports it
+// for dashboards and scripts.
+type Status struct {
+	Source        string    `json:"source"` // "daemon", or "state" when read from the saved state by the status command
+	Version       string    `json:"version"`
+	StartedAt     time.Time `json:"started_at,omitzero"`
+	UptimeSeconds int64     `json:"uptime_seconds"`
+	Ready         bool      `json:"ready"`
+	Problems      []string  `json:"problems,omitempty"` // Why the daemon isn't ready; see readiness
+
+	DetectedIP   string    `json:"detected_ip,omitempty"` // The public IP as last detected or pushed
+	DetectedAt   time.Time `json:"detected_at,omitzero"`
+	DetectedIPv6 string    `json:"detected_ipv6,omitempty"` // The IPv6 address, detected separately when the config is dual-stack
+	LastIP       string    `json:"last_ip,omitempty"`       // The IP the records were last updated to
+	LastUpdated  time.Time `json:"last_updated,omitzero"`
+	NextCheck    time.Time `json:"next_check,omitzero"` // When the public IP is next checked
+
+	// Leading and Leader report the leader election, when it is enabled:
+	// whether this instance holds the lease, and which one was last seen
+	// holding it.
+	Leading *bool  `json:"leading,omitempty"`
+	Leader  string `json:"leader,omitempty"`
+
+	// Pending holds the changes awaiting approval with require_approval,
+	// which the apply command makes.
+	Pending *Plan `json:"pending,omitempty"`
+
+	Records   []RecordReport          `json:"records"`
+	Stages    map[string]StageMetrics `json:"stages,omitempty"`
+	Providers []ProviderHealth        `json:"providers,omitempty"`
+
+	// Dependencies reports the latency and error rate of the IP source and
+	// of each provider, telling detection problems from provider ones.
+	Dependencies []DependencyMetrics `json:"dependencies,omitempty"`
+}
+
+// RecordReport is the status of one configured record.
+type RecordReport struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Provider string `json:"provider"`
+	Value    string `json:"value,omitempty"` // The value last published; empty if unknown
+	Notes    string `json:"notes,omitempty"` // The record's notes from the config
+	RecordStatus
+}
+
+// status returns the daemon's status at now.
+func (d *DDNSUpdater) status(now time.Time) Status {
+	problems := d.readiness(now)
+	status := Status{
+		Source:    "daemon",
+		Version:   version,
+		StartedAt: d.started,
+		Ready:     len(problems) == 0,
+		Problems:  problems,
+		Stages:    make(map[string]StageMetrics),
+		Providers: d.ProviderHealth(),
+
+		Dependencies: d.Dependencies(),
+	}
+	if !d.started.IsZero() {
+		status.UptimeSeconds = int64(now.Sub(d.started).Seconds())
+	}
+	if desired, ok := d.desired.Get(DefaultSource); ok {
+		status.DetectedIP, status.DetectedAt = desired.Value, desired.DetectedAt
+	}
+	if desired, ok := d.desired.Get(IPv6Source); ok {
+		status.DetectedIPv6 = desired.Value
+	}
+	status.Pending = d.PendingPlan()
+
+	if !d.config.Webhook.DisablePolling {
+		status.NextCheck = d.detection.Metrics().NextRun
+	}
+	if d.election != nil {
+		leading := d.election.Leading()
+		status.Leading, status.Leader = &leading, d.election.Leader()
+	}
+	for _, stage := range d.stages() {
+		status.Stages[stage.Name] = stage.Metrics()
+	}
+
+	d.stateMu.Lock()
+	defer d.stateMu.Unlock()
+	status.LastIP, status.LastUpdated = d.state.LastIP, d.state.LastUpdated
+	status.Records = recordReports(d.state, d.config)
+	return status
+}
+
+// recordReports returns the status of each configured record in state.
+func recordReports(state *State, config *Config) []RecordReport {
+	reports := []RecordReport{}
+	for _, domain := range config.Domains {
+		report := RecordReport{
+			Name:     domain.FQDN(),
+			Type:     domain.Type,
+			Provider: domain.Provider,
+			Value:    state.Records[stateKey(domain.FQDN(), domain.Type)],
+			Notes:    domain.Notes,
+		}
+		if s := state.RecordStatus[stateKey(report.Name, report.Type)]; s != nil {
+			report.RecordStatus = *s
+		}
+		reports = append(reports, report)
+	}
+	return reports
+}
+
+// serveStatus answers GET /status with the daemon's status as JSON.
+func (d *DDNSUpdater) serveStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(d.status(time.Now()))
+}
+
+// runStatusCommand prints the daemon's status. It is asked for on the
+// health listener when one is configured, and read from the saved state
+// when the daemon isn't running or has no listener.
+//
+//	dh-ddns-updater status [--json] [--profile name] [config]
+func runStatusCommand(args []string) int {
+	fs := flag.NewFlagSet("status", flag.ContinueOnError)
+	opts := configFlags(fs)
+	asJSON := fs.Bool("json", false, "print the status as JSON")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: dh-ddns-updater status [--json] [--profile name] [config]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	config, err := loadConfig(commandConfigPath(fs), *opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load config: %v\n", err)
+		return 1
+	}
+	applyConfigDefaults(config)
+
+	var status Status
+	if config.Health.Listen != "" {
+		url := healthURL(config.Health.Listen, "/status")
+		status, err = queryStatus(url)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "The daemon isn't answering at %s, so the saved state is shown: %v\n", url, err)
+		}
+	}
+	if status.Source == "" {
+		if config.StateBackend == "none" {
+			fmt.Fprintln(os.Stderr, "state_backend none keeps no state, and the daemon can only be asked with health.listen set")
+			return 1
+		}
+		state, err := stateStoreFor(config).Load()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to load state: %v\n", err)
+			return 1
+		}
+		status = Status{
+			Source:      "state",
+			LastIP:      state.LastIP,
+			LastUpdated: state.LastUpdated,
+			Records:     recordReports(state, config),
+		}
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(status)
+		return 0
+	}
+	printStatus(os.Stdout, status, 2*config.PublishInterval, time.Now())
+	return 0
+}
+
+// healthURL returns the URL of path on the health listener at listen,
+// asking the local host when it listens on all addresses.
+func healthURL(listen, path string) string {
+	host, port, err := net.SplitHostPort(listen)
+	if err != nil {
+		return "http://" + listen + path
+	}
+	if ip := net.ParseIP(host); host == "" || ip != nil && ip.IsUnspecified() {
+		host = "localhost"
+	}
+	return "http://" + net.JoinHostPort(host, port) + path
+}
+
+// queryStatus asks the daemon for its status at url.
+func queryStatus(url string) (Status, error) {
+	client := &http.Client{Timeout: statusQueryTimeout}
+	resp, err := client.Get(url)
+	if err != nil {
+		return Status{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return Status{}, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	var status Status
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return Status{}, fmt.Errorf("decoding status: %w", err)
+	}
+	if status.Source == "" {
+		return Status{}, errors.New("not a status report")
+	}
+	return status, nil
+}
+
+// printStatus writes the status as a table at now. A record not seen
+// holding its value for longer than staleAfter is marked stale.
+func printStatus(w io.Writer, status Status, staleAfter time.Duration, now time.Time) {
+	ago := func(t time.Time) string {
+		if t.IsZero() {
+			return "never"
+		}
+		return now.Sub(t).Round(time.Second).String() + " ago"
+	}
+
+	if status.Source == "daemon" {
+		readiness := "ready"
+		if !status.Ready {
+			readiness = "not ready"
+		}
+		fmt.Fprintf(w, "Daemon: %s, up %s, %s\n", status.Version,
+			(time.Duration(status.UptimeSeconds) * time.Second).String(), readiness)
+		for _, problem := range status.Problems {
+			fmt.Fprintf(w, "  %s\n", problem)
+		}
+		if status.DetectedIP == "" {
+			fmt.Fprint(w, "Detected IP: none yet")
+		} else {
+			fmt.Fprintf(w, "Detected IP: %s (checked %s)", status.DetectedIP, ago(status.DetectedAt))
+		}
+		if status.DetectedIPv6 != "" {
+			fmt.Fprintf(w, " and %s", status.DetectedIPv6)
+		}
+		if !status.NextCheck.IsZero() {
+			fmt.Fprintf(w, ", next check in %s", max(status.NextCheck.Sub(now), 0).Round(time.Second))
+		}
+		fmt.Fprintln(w)
+		switch {
+		case status.Leading == nil:
+		case *status.Leading:
+			fmt.Fprintln(w, "Leader: this instance")
+		default:
+			fmt.Fprintf(w, "Leader: %s; standing by\n", cmp.Or(status.Leader, "none"))
+		}
+		if status.Pending != nil {
+			fmt.Fprintln(w, "Awaiting approval (make these changes with the apply command):")
+			status.Pending.Print(w)
+		}
+	} else {
+		fmt.Fprintln(w, "Daemon: not queried; showing the saved state")
+	}
+	if status.LastIP == "" {
+		fmt.Fprintln(w, "Last IP: unknown")
+	} else {
+		fmt.Fprintf(w, "Last IP: %s (records last updated %s)\n", status.LastIP, ago(status.LastUpdated))
+	}
+	fmt.Fprintln(w)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "RECORD\tTYPE\tPROVIDER\tVALUE\tLAST VERIFIED\tLAST ERROR\tNOTES")
+	for _, r := range status.Records {
+		verified := ago(r.LastVerified)
+		if r.LastVerified.IsZero() || now.Sub(r.LastVerified) > staleAfter {
+			verified += " (stale)"
+		}
+		lastError := "-"
+		if r.ConsecutiveFailures > 0 {
+			lastError = fmt.Sprintf("%s (%d failures in a row)", r.LastError, r.ConsecutiveFailures)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", r.Name, r.Type, r.Provider, cmp.Or(r.Value, "-"), verified, lastError, cmp.Or(r.Notes, "-"))
+	}
+	tw.Flush()
+
+	if len(status.Dependencies) == 0 {
+		return
+	}
+	fmt.Fprintln(w)
+	tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "DEPENDENCY\tCALLS\tERRORS\tRECENT ERROR RATE\tP50\tP90\tP99")
+	for _, dep := range status.Dependencies {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%.0f%%\t%s\t%s\t%s\n", dep.Name, dep.Calls, dep.Errors, dep.ErrorRate*100,
+			dep.LatencyP50.Round(time.Millisecond), dep.LatencyP90.Round(time.Millisecond), dep.LatencyP99.Round(time.Millisecond))
+	}
+	tw.Flush()
+}
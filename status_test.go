@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestStatusEndpoint tests that /status reports the detected IP, each record's state and errors, the changes awaiting approval, the stages and the uptime as JSON
+func TestStatusEndpoint(t *testing.T) {
+	updater := newPlanTestUpdater(t, newFakeDreamhost(), "203.0.113.7",
+		DomainConfig{Name: "example.com", Record: "home", Type: "A"},
+		DomainConfig{Name: "example.com", Record: "home", Type: "AAAA"},
+		DomainConfig{Name: "example.com", Record: "vpn", Type: "A", Notes: "port-forward 51820 on router"})
+	updater.detection = NewStage("detection", time.Minute, time.Minute, func(context.Context) error { return nil }, nil)
+	updater.providers[DefaultProvider].stage = NewStage("publication:dreamhost", time.Hour, time.Minute, func(context.Context) error { return nil }, nil)
+	updated := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	updater.state = &State{
+		LastIP:      "203.0.113.7",
+		LastUpdated: updated,
+		Records:     map[string]string{"home.example.com/A": "203.0.113.7", "vpn.example.com/A": "203.0.113.6"},
+		RecordStatus: map[string]*RecordStatus{
+			"home.example.com/A": {LastUpdated: updated, LastVerified: updated},
+			"vpn.example.com/A":  {LastUpdated: updated, LastFailure: updated.Add(time.Hour), ConsecutiveFailures: 2, LastError: "record is locked"},
+		},
+	}
+	updater.pending = map[string]*Plan{DefaultProvider: {Actions: []Action{
+		{Kind: ActionUpdate, Record: "vpn.example.com", Type: "A", Current: "203.0.113.6", Desired: "203.0.113.7"},
+	}}}
+	updater.started = time.Now().Add(-90 * time.Second)
+	updater.detection.Execute(context.Background())
+	updater.detection.scheduled(time.Minute)
+
+	rec := httptest.NewRecorder()
+	updater.healthHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/status", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("expected a JSON 200, got %d %q: %s", rec.Code, rec.Header().Get("Content-Type"), rec.Body)
+	}
+	var status Status
+	if err := json.Unmarshal(rec.Body.Bytes(), &status); err != nil {
+		t.Fatal(err)
+	}
+
+	if status.DetectedIP != "203.0.113.7" || status.LastIP != "203.0.113.7" || !status.LastUpdated.Equal(updated) {
+		t.Errorf("expected the detected and last IP, got %+v", status)
+	}
+	if status.Pending == nil || len(status.Pending.Changes()) != 1 || status.Pending.Changes()[0].Record != "vpn.example.com" {
+		t.Errorf("expected the change awaiting approval, got %+v", status.Pending)
+	}
+	if status.UptimeSeconds < 90 || status.UptimeSeconds > 120 {
+		t.Errorf("expected an uptime of about 90s, got %d", status.UptimeSeconds)
+	}
+	if until := time.Until(status.NextCheck); until < 50*time.Second || until > time.Minute {
+		t.Errorf("expected the next check a minute away, got %s", status.NextCheck)
+	}
+	if status.Ready || len(status.Problems) != 1 {
+		t.Errorf("expected not ready until publication has run, got %v", status.Problems)
+	}
+	if m := status.Stages["detection"]; m.Runs != 1 {
+		t.Errorf("expected the detection stage's run reported, got %+v", status.Stages)
+	}
+	if _, ok := status.Stages["publication:dreamhost"]; !ok || len(status.Stages) != 2 {
+		t.Errorf("expected the detection and publication stages, got %+v", status.Stages)
+	}
+	if len(status.Providers) != 1 || status.Providers[0].Name != DefaultProvider {
+		t.Errorf("expected the provider's health, got %+v", status.Providers)
+	}
+
+	if len(status.Records) != 3 {
+		t.Fatalf("expected the 3 configured records, got %+v", status.Records)
+	}
+	home, home6, vpn := status.Records[0], status.Records[1], status.Records[2]
+	if home.Name != "home.example.com" || home.Type != "A" || home.Provider != DefaultProvider || home.Value != "203.0.113.7" || !home.LastVerified.Equal(updated) {
+		t.Errorf("unexpected A record %+v", home)
+	}
+	if home6.Type != "AAAA" || home6.Value != "" {
+		t.Errorf("expected the AAAA record's value unknown, got %+v", home6)
+	}
+	if vpn.ConsecutiveFailures != 2 || vpn.LastError != "record is locked" {
+		t.Errorf("expected the record's last error, got %+v", vpn)
+	}
+	if vpn.Notes != "port-forward 51820 on router" || home.Notes != "" {
+		t.Errorf("expected the notes of the records that have them, got %q and %q", vpn.Notes, home.Notes)
+	}
+}
+
+// TestHealthURL tests that the status and healthcheck commands ask the local host when the health listener listens on all addresses
+func TestHealthURL(t *testing.T) {
+	tests := []struct {
+		listen, want string
+	}{
+		{":8080", "http://localhost:8080/status"},
+		{"0.0.0.0:8080", "http://localhost:8080/status"},
+		{"[::]:8080", "http://localhost:8080/status"},
+		{"127.0.0.1:9000", "http://127.0.0.1:9000/status"},
+		{"[::1]:9000", "http://[::1]:9000/status"},
+		{"ddns.lan:80", "http://ddns.lan:80/status"},
+	}
+	for _, tt := range tests {
+		if got := healthURL(tt.listen, "/status"); got != tt.want {
+			t.Errorf("healthURL(%q) = %q, want %q", tt.listen, got, tt.want)
+		}
+	}
+}
+
+// TestQueryStatus tests that the status command reads the daemon's report and rejects other answers
+func TestQueryStatus(t *testing.T) {
+	updater := newPlanTestUpdater(t, newFakeDreamhost(), "203.0.113.7", DomainConfig{Name: "example.com", Record: "home", Type: "A"})
+	updater.detection = NewStage("detection", time.Minute, time.Minute, func(context.Context) error { return nil }, nil)
+	updater.providers[DefaultProvider].stage = NewStage("publication:dreamhost", time.Hour, time.Minute, func(context.Context) error { return nil }, nil)
+	server := httptest.NewServer(updater.healthHandler())
+	defer server.Close()
+
+	status, err := queryStatus(server.URL + "/status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Source != "daemon" || status.DetectedIP != "203.0.113.7" || len(status.Records) != 1 {
+		t.Errorf("unexpected status %+v", status)
+	}
+	if _, err := queryStatus(server.URL + "/healthz"); err == nil {
+		t.Error("expected an error for an answer that isn't a status report")
+	}
+	if _, err := queryStatus(server.URL + "/missing"); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("expected an error for a missing endpoint, got %v", err)
+	}
+}
+
+// TestPrintStatus tests the status command's table, with stale records marked
+func TestPrintStatus(t *testing.T) {
+	now := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	status := Status{
+		Source:        "daemon",
+		Version:       "v1.4.0",
+		UptimeSeconds: 3700,
+		Problems:      []string{"publication:dreamhost: last run failed: record is locked"},
+		DetectedIP:    "203.0.113.7",
+		DetectedAt:    now.Add(-time.Minute),
+		DetectedIPv6:  "2001:db8::7",
+		NextCheck:     now.Add(4 * time.Minute),
+		Leading:       new(bool),
+		Leader:        "router",
+		Pending: &Plan{Actions: []Action{
+			{Kind: ActionUpdate, Record: "vpn.example.com", Type: "A", Current: "203.0.113.6", Desired: "203.0.113.7"},
+		}},
+		LastIP:      "203.0.113.7",
+		LastUpdated: now.Add(-2 * time.Hour),
+		Records: []RecordReport{
+			{Name: "home.example.com", Type: "A", Provider: "dreamhost", Value: "203.0.113.7", RecordStatus: RecordStatus{LastVerified: now.Add(-5 * time.Minute)}},
+			{Name: "vpn.example.com", Type: "A", Provider: "dreamhost", Value: "203.0.113.6", Notes: "port-forward 51820 on router",
+				RecordStatus: RecordStatus{LastVerified: now.Add(-3 * time.Hour), ConsecutiveFailures: 2, LastError: "record is locked"}},
+			{Name: "home.example.com", Type: "AAAA", Provider: "dreamhost"},
+		},
+		Dependencies: []DependencyMetrics{
+			{Name: "ip_source:ipinfo", Calls: 40, ErrorRate: 0, LatencyP50: 84 * time.Millisecond, LatencyP90: 120 * time.Millisecond, LatencyP99: 310 * time.Millisecond},
+			{Name: "provider:dreamhost", Calls: 12, Errors: 3, ErrorRate: 0.25, LatencyP50: 640 * time.Millisecond, LatencyP90: 2100 * time.Millisecond, LatencyP99: 30 * time.Second},
+		},
+	}
+
+	var b strings.Builder
+	printStatus(&b, status, time.Hour, now)
+	want := `Daemon: v1.4.0, up 1h1m40s, not ready
+  publication:dreamhost: last run failed: record is locked
+Detected IP: 203.0.113.7 (checked 1m0s ago) and 2001:db8::7, next check in 4m0s
+Leader: router; standing by
+Awaiting approval (make these changes with the apply command):
+~ update vpn.example.com A 203.0.113.6 -> 203.0.113.7
+1 to change, 0 up to date, 0 skipped
+Last IP: 203.0.113.7 (records last updated 2h0m0s ago)
+
+RECORD            TYPE  PROVIDER   VALUE        LAST VERIFIED       LAST ERROR                              NOTES
+home.example.com  A     dreamhost  203.0.113.7  5m0s ago            -                                       -
+vpn.example.com   A     dreamhost  203.0.113.6  3h0m0s ago (stale)  record is locked (2 failures in a row)  port-forward 51820 on router
+home.example.com  AAAA  dreamhost  -            never (stale)       -                                       -
+
+DEPENDENCY          CALLS  ERRORS  RECENT ERROR RATE  P50    P90    P99
+ip_source:ipinfo    40     0       0%                 84ms   120ms  310ms
+provider:dreamhost  12     3       25%                640ms  2.1s   30s
+`
+	if b.String() != want {
+		t.Errorf("unexpected output:\n%s\nwant:\n%s", b.String(), want)
+	}
+
+	b.Reset()
+	printStatus(&b, Status{Source: "state"}, time.Hour, now)
+	if !strings.HasPrefix(b.String(), "Daemon: not queried; showing the saved state\nLast IP: unknown\n") {
+		t.Errorf("unexpected output for the saved state:\n%s", b.String())
+	}
+}
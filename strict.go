@@ -0,0 +1,148 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// unmarshalerType is implemented by types that decode themselves, whose
+// keys can't be checked.
+var unmarshalerType = reflect.TypeFor[yaml.Unmarshaler]()
+
+// checkKnownFields returns an error for every mapping key in the tree below
+// node that the corresponding field of t doesn't have, such as a misspelled
+// or misindented setting, positioned through origins like validation
+// errors. yaml.v3 can only reject unknown fields when decoding a stream, and
+// configs are decoded from node trees assembled from several files, so the
+// tree is walked along the type instead.
+func checkKnownFields(node *yaml.Node, t reflect.Type, origins map[*yaml.Node]string) []error {
+	var errs []error
+	var walk func(node *yaml.Node, t reflect.Type, path, file string)
+	walk = func(node *yaml.Node, t reflect.Type, path, file string) {
+		if node.Kind == yaml.AliasNode {
+			node = node.Alias
+		}
+		if f, ok := origins[node]; ok {
+			file = f
+		}
+		for t.Kind() == reflect.Pointer {
+			t = t.Elem()
+		}
+		if reflect.PointerTo(t).Implements(unmarshalerType) {
+			return
+		}
+
+		switch t.Kind() {
+		case reflect.Struct:
+			if node.Kind != yaml.MappingNode {
+				return // Left to the decoder to report
+			}
+			fields := yamlFields(t)
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				key, value := node.Content[i], node.Content[i+1]
+				field, ok := fields[key.Value]
+				if !ok {
+					msg := fmt.Sprintf("unknown key %q", key.Value)
+					if path != "" {
+						msg += " in " + path
+					}
+					if suggestion := closestKey(key.Value, fields); suggestion != "" {
+						msg += fmt.Sprintf(" (did you mean %q?)", suggestion)
+					}
+					errs = append(errs, atPosition(configPosition{File: file, Line: key.Line}, errors.New(msg)))
+					continue
+				}
+				walk(value, field, joinPath(path, key.Value), file)
+			}
+		case reflect.Map:
+			if node.Kind != yaml.MappingNode {
+				return
+			}
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				walk(node.Content[i+1], t.Elem(), joinPath(path, node.Content[i].Value), file)
+			}
+		case reflect.Slice, reflect.Array:
+			if node.Kind != yaml.SequenceNode {
+				return
+			}
+			for i, item := range node.Content {
+				walk(item, t.Elem(), fmt.Sprintf("%s[%d]", path, i), file)
+			}
+		}
+	}
+	walk(node, t, "", "")
+	return errs
+}
+
+// joinPath appends key to the dotted path of a setting.
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// yamlFields returns the types of a struct's fields by YAML key, following
+// yaml.v3's rules: the key is the tag's name, or the lower-cased field name
+// without one, and inlined structs contribute their own fields.
+func yamlFields(t reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, opts, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+		if name == "-" {
+			continue
+		}
+		if strings.Contains(opts, "inline") {
+			for key, ft := range yamlFields(f.Type) {
+				fields[key] = ft
+			}
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(f.Name)
+		}
+		fields[name] = f.Type
+	}
+	return fields
+}
+
+// closestKey returns the known key nearest to key when it is close enough
+// to be a likely typo, or "".
+func closestKey(key string, fields map[string]reflect.Type) string {
+	best, bestDistance := "", 3 // Suggest keys at most two edits away
+	for candidate := range fields {
+		if d := editDistance(key, candidate); d < bestDistance || d == bestDistance && candidate < best {
+			best, bestDistance = candidate, d
+		}
+	}
+	return best
+}
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
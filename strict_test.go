@@ -0,0 +1,71 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestStrictParsing tests that unknown and misindented keys are rejected with their line unless strict is off
+func TestStrictParsing(t *testing.T) {
+	tests := []struct {
+		name       string
+		yaml       string
+		wantErrors []string
+	}{
+		{name: "known keys", yaml: "check_interval: 5m\nproviders:\n  backup: {api_key: key}\ngroups:\n  office: {create: {ttl: 60}}\n"},
+		{name: "typo", yaml: "check_intervall: 5m\n", wantErrors: []string{`line 1: unknown key "check_intervall" (did you mean "check_interval"?)`}},
+		{
+			name:       "misindented",
+			yaml:       "domains:\n  - name: example.com\n    type: A\nrecord: home\n",
+			wantErrors: []string{`line 4: unknown key "record"`},
+		},
+		{
+			name: "nested",
+			yaml: "webhook:\n  listn: \":80\"\nproviders:\n  backup:\n    apikey: key\ndomains:\n  - name: example.com\n    schedule:\n      - {value: x, colour: red}\n",
+			wantErrors: []string{
+				`line 2: unknown key "listn" in webhook (did you mean "listen"?)`,
+				`line 5: unknown key "apikey" in providers.backup (did you mean "api_key"?)`,
+				`line 9: unknown key "colour" in domains[0].schedule[0]`,
+			},
+		},
+		{name: "no suggestion", yaml: "frobnicate: true\n", wantErrors: []string{`line 1: unknown key "frobnicate"`}},
+		{name: "strict off", yaml: "strict: false\ncheck_intervall: 5m\n"},
+		{name: "profile key", yaml: "profile: a\nprofiles:\n  a:\n    log_levle: debug\n", wantErrors: []string{`line 4: unknown key "log_levle" (did you mean "log_level"?)`}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseConfig([]byte(tt.yaml), configOptions{})
+			if len(tt.wantErrors) == 0 {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("expected error but got none")
+			}
+			if got := strings.Split(err.Error(), "\n"); strings.Join(got, "\n") != strings.Join(tt.wantErrors, "\n") {
+				t.Errorf("expected errors:\n%s\ngot:\n%s", strings.Join(tt.wantErrors, "\n"), err)
+			}
+		})
+	}
+}
+
+// TestStrictParsingFragments tests that unknown keys in a fragment are reported with its file
+func TestStrictParsingFragments(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	os.WriteFile(path, []byte("dreamhost_api_key: key\n"), 0600)
+	os.Mkdir(filepath.Join(dir, "conf.d"), 0700)
+	fragment := filepath.Join(dir, "conf.d", "home.yaml")
+	os.WriteFile(fragment, []byte("domains:\n  - name: example.com\n    typ: A\n"), 0600)
+
+	errs := checkConfigFile(path, configOptions{})
+	want := fragment + `: line 3: unknown key "typ" in domains[0] (did you mean "type"?)`
+	if len(errs) != 1 || errs[0].Error() != want {
+		t.Errorf("expected %q, got %v", want, errs)
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SyslogConfig configures sending the logs to a syslog server, as RFC 5424
+// messages.
+type SyslogConfig struct {
+	// Address is where the server listens: udp://host:port, tcp://host:port,
+	// unix:///path to a datagram socket, or "local" for the local daemon's
+	// socket (/dev/log, or /var/run/syslog on macOS). Empty disables syslog.
+	Address  string `yaml:"address"`
+	Facility string `yaml:"facility"` // Facility name, e.g. daemon or local0 (default daemon)
+	Tag      string `yaml:"tag"`      // APP-NAME of the messages (default dh-ddns-updater)
+	Stdout   bool   `yaml:"stdout"`   // Log to stdout as well
+}
+
+// DefaultSyslogFacility and DefaultSyslogTag are the syslog settings used
+// unless the syslog block gives others.
+const (
+	DefaultSyslogFacility = "daemon"
+	DefaultSyslogTag      = "dh-ddns-updater"
+)
+
+// syslogFacilities are the facility codes of RFC 5424, by name.
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5,
+	"lpr": 6, "news": 7, "uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// syslogRetryInterval is how long logs aren't sent to syslog after sending
+// failed, so that an unreachable server doesn't hold up every log line.
+const syslogRetryInterval = 30 * time.Second
+
+// localSyslogSockets are where the local syslog daemon is looked for.
+var localSyslogSockets = []string{"/dev/log", "/var/run/syslog", "/var/run/log"}
+
+// validateSyslog checks the syslog block.
+func validateSyslog(config SyslogConfig) error {
+	if config.Address == "" {
+		return nil
+	}
+	if _, _, err := syslogNetwork(config.Address); err != nil {
+		return fmt.Errorf("syslog: %w", err)
+	}
+	if _, ok := syslogFacilities[config.Facility]; !ok {
+		return fmt.Errorf("syslog: unknown facility %q (want kern, user, mail, daemon, auth, syslog, lpr, news, uucp, cron, authpriv, ftp or local0 to local7)", config.Facility)
+	}
+	return nil
+}
+
+// syslogNetwork returns the network and address to dial for a syslog
+// address; see SyslogConfig.Address. "local" yields the network "local".
+func syslogNetwork(address string) (network, addr string, err error) {
+	if address == "local" {
+		return "local", "", nil
+	}
+	u, err := url.Parse(address)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid address %q: %w", address, err)
+	}
+	switch u.Scheme {
+	case "udp", "tcp":
+		if u.Port() == "" {
+			return u.Scheme, net.JoinHostPort(u.Hostname(), "514"), nil
+		}
+		return u.Scheme, u.Host, nil
+	case "unix":
+		if u.Path == "" {
+			return "", "", fmt.Errorf("invalid address %q: no socket path", address)
+		}
+		return "unixgram", u.Path, nil
+	}
+	return "", "", fmt.Errorf("invalid address %q: want udp://, tcp://, unix:// or local", address)
+}
+
+// syslogWriter sends each log line it is given as one RFC 5424 message,
+// with the severity of the line's level. It connects when first written to
+// and reconnects after a failure, so a syslog server that is down never
+// stops the daemon. The lines logged in the syslogRetryInterval after a
+// failure are dropped; failures are reported on stderr, once until a
+// message gets through again.
+type syslogWriter struct {
+	config   SyslogConfig
+	hostname string
+	now      func() time.Time // For tests; nil means time.Now
+
+	mu      sync.Mutex
+	conn    net.Conn
+	network string
+	failing bool
+	retryAt time.Time // Lines are dropped until then
+}
+
+func newSyslogWriter(config SyslogConfig) *syslogWriter {
+	hostname, _ := os.Hostname()
+	return &syslogWriter{config: config, hostname: hostname}
+}
+
+// Write sends p, one JSON log line. It doesn't fail, so that the other
+// log outputs are still written.
+func (w *syslogWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.conn == nil && time.Now().Before(w.retryAt) {
+		return len(p), nil
+	}
+	err := w.send(p)
+	if err != nil && w.conn != nil {
+		// The server may have restarted; reconnect and try once more
+		w.conn.Close()
+		w.conn = nil
+		err = w.send(p)
+	}
+	if err != nil {
+		if !w.failing {
+			fmt.Fprintf(os.Stderr, "Failed to send logs to syslog, will retry: %v\n", err)
+		}
+		if w.conn != nil {
+			w.conn.Close()
+			w.conn = nil
+		}
+		w.retryAt = time.Now().Add(syslogRetryInterval)
+	}
+	w.failing = err != nil
+	return len(p), nil
+}
+
+func (w *syslogWriter) send(p []byte) error {
+	if w.conn == nil {
+		if err := w.dial(); err != nil {
+			return err
+		}
+	}
+	msg := w.format(p)
+	if w.network == "tcp" {
+		// Octet counting, as RFC 6587 frames messages on a stream
+		msg = append([]byte(strconv.Itoa(len(msg))+" "), msg...)
+	}
+	w.conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	_, err := w.conn.Write(msg)
+	return err
+}
+
+func (w *syslogWriter) dial() error {
+	network, addr, err := syslogNetwork(w.config.Address)
+	if err != nil {
+		return err
+	}
+	if network != "local" {
+		conn, err := net.DialTimeout(network, addr, 5*time.Second)
+		if err != nil {
+			return fmt.Errorf("connecting to syslog: %w", err)
+		}
+		w.conn, w.network = conn, network
+		return nil
+	}
+	for _, path := range localSyslogSockets {
+		if conn, err := net.Dial("unixgram", path); err == nil {
+			w.conn, w.network = conn, "unixgram"
+			return nil
+		}
+	}
+	return fmt.Errorf("connecting to syslog: no local syslog socket found (tried %s)", strings.Join(localSyslogSockets, ", "))
+}
+
+// format returns the RFC 5424 message for a JSON log line, which becomes
+// the message text.
+func (w *syslogWriter) format(line []byte) []byte {
+	now := time.Now
+	if w.now != nil {
+		now = w.now
+	}
+	var record struct {
+		Level string `json:"level"`
+	}
+	json.Unmarshal(line, &record)
+	pri := syslogFacilities[w.config.Facility]*8 + syslogSeverity(record.Level)
+	hostname := w.hostname
+	if hostname == "" {
+		hostname = "-"
+	}
+	header := fmt.Sprintf("<%d>1 %s %s %s %d - - ", pri, now().UTC().Format("2006-01-02T15:04:05.000000Z"),
+		hostname, w.config.Tag, os.Getpid())
+	return append([]byte(header), strings.TrimRight(string(line), "\n")...)
+}
+
+// syslogSeverity returns the syslog severity of a slog level name.
+func syslogSeverity(level string) int {
+	switch {
+	case strings.HasPrefix(level, "ERROR"):
+		return 3 // Error
+	case strings.HasPrefix(level, "WARN"):
+		return 4 // Warning
+	case strings.HasPrefix(level, "INFO"):
+		return 6 // Informational
+	}
+	return 7 // Debug, and trace
+}
+
+// Close closes the connection.
+func (w *syslogWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.conn == nil {
+		return nil
+	}
+	err := w.conn.Close()
+	w.conn = nil
+	return err
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestSyslogNetwork tests parsing the syslog addresses
+func TestSyslogNetwork(t *testing.T) {
+	tests := []struct {
+		address, network, addr string
+		wantErr                bool
+	}{
+		{address: "udp://logs.lan", network: "udp", addr: "logs.lan:514"},
+		{address: "udp://logs.lan:5514", network: "udp", addr: "logs.lan:5514"},
+		{address: "tcp://[2001:db8::1]:601", network: "tcp", addr: "[2001:db8::1]:601"},
+		{address: "unix:///dev/log", network: "unixgram", addr: "/dev/log"},
+		{address: "local", network: "local"},
+		{address: "unix://", wantErr: true},
+		{address: "logs.lan:514", wantErr: true},
+		{address: "https://logs.lan", wantErr: true},
+	}
+	for _, tt := range tests {
+		network, addr, err := syslogNetwork(tt.address)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("syslogNetwork(%q): expected an error", tt.address)
+			}
+			continue
+		}
+		if err != nil || network != tt.network || addr != tt.addr {
+			t.Errorf("syslogNetwork(%q) = %q, %q, %v; want %q, %q", tt.address, network, addr, err, tt.network, tt.addr)
+		}
+	}
+}
+
+// TestSyslogWriter tests the RFC 5424 messages sent over UDP, TCP and a Unix socket, with the severity of each line's level
+func TestSyslogWriter(t *testing.T) {
+	at := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	lines := []string{
+		`{"time":"2026-10-01T12:00:00Z","level":"INFO","msg":"Starting DDNS updater"}` + "\n",
+		`{"time":"2026-10-01T12:00:00Z","level":"ERROR","msg":"Pipeline stage failed"}` + "\n",
+		`{"time":"2026-10-01T12:00:00Z","level":"DEBUG-4","msg":"HTTP request"}` + "\n",
+	}
+	check := func(t *testing.T, got []string) {
+		t.Helper()
+		wantPRI := []string{"<134>", "<131>", "<135>"} // local0 with info, error and debug
+		if len(got) != len(lines) {
+			t.Fatalf("expected %d messages, got %q", len(lines), got)
+		}
+		for i, msg := range got {
+			prefix := wantPRI[i] + "1 2026-10-01T12:00:00.000000Z testhost ddns "
+			if !strings.HasPrefix(msg, prefix) || !strings.HasSuffix(msg, " - - "+strings.TrimSuffix(lines[i], "\n")) {
+				t.Errorf("unexpected message %q", msg)
+			}
+		}
+	}
+	write := func(t *testing.T, address string) {
+		t.Helper()
+		w := newSyslogWriter(SyslogConfig{Address: address, Facility: "local0", Tag: "ddns"})
+		w.hostname, w.now = "testhost", func() time.Time { return at }
+		defer w.Close()
+		for _, line := range lines {
+			if n, err := w.Write([]byte(line)); n != len(line) || err != nil {
+				t.Fatalf("Write = %d, %v", n, err)
+			}
+		}
+	}
+	readPackets := func(t *testing.T, conn net.PacketConn) []string {
+		t.Helper()
+		var got []string
+		buf := make([]byte, 4096)
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		for range lines {
+			n, _, err := conn.ReadFrom(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, string(buf[:n]))
+		}
+		return got
+	}
+
+	t.Run("udp", func(t *testing.T) {
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		write(t, "udp://"+conn.LocalAddr().String())
+		check(t, readPackets(t, conn))
+	})
+
+	t.Run("unix", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "log")
+		conn, err := net.ListenPacket("unixgram", path)
+		if err != nil {
+			t.Skipf("no unix datagram sockets: %v", err)
+		}
+		defer conn.Close()
+		write(t, "unix://"+path)
+		check(t, readPackets(t, conn))
+	})
+
+	t.Run("tcp", func(t *testing.T) {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ln.Close()
+		received := make(chan []string, 1)
+		go func() {
+			conn, err := ln.Accept()
+			if err != nil {
+				received <- nil
+				return
+			}
+			defer conn.Close()
+			r := bufio.NewReader(conn)
+			var got []string
+			for range lines {
+				prefix, err := r.ReadString(' ')
+				if err != nil {
+					break
+				}
+				length, _ := strconv.Atoi(strings.TrimSpace(prefix))
+				msg := make([]byte, length)
+				if _, err := io.ReadFull(r, msg); err != nil {
+					break
+				}
+				got = append(got, string(msg))
+			}
+			received <- got
+		}()
+		write(t, "tcp://"+ln.Addr().String())
+		check(t, <-received)
+	})
+}
+
+// TestSyslogWriterUnreachable tests that logging carries on while the syslog server is unreachable
+func TestSyslogWriterUnreachable(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := "tcp://" + ln.Addr().String()
+	ln.Close()
+
+	w := newSyslogWriter(SyslogConfig{Address: address, Facility: "daemon", Tag: "ddns"})
+	if n, err := w.Write([]byte("{}\n")); n != 3 || err != nil {
+		t.Errorf("expected the write to succeed, got %d, %v", n, err)
+	}
+	if !w.failing || time.Until(w.retryAt) < 25*time.Second {
+		t.Errorf("expected sending to be retried in %s, got %s", syslogRetryInterval, time.Until(w.retryAt))
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// TestBuildTelemetryReport tests that the report counts providers and records without naming them
+func TestBuildTelemetryReport(t *testing.T) {
+	config, err := parseConfig([]byte(`
+dreamhost_api_key: secret-key
+providers:
+  backup:
+    api_key: other-secret
+  unused:
+    api_key: unused-secret
+domains:
+  - {name: example.com, record: home, type: A}
+  - {name: example.com, record: home, type: AAAA}
+  - {name: example.org, type: TXT, value: hello, provider: backup}
+`), configOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	applyConfigDefaults(config)
+
+	report := buildTelemetryReport(config)
+	if report.OS != runtime.GOOS || report.Arch != runtime.GOARCH || report.Version != version {
+		t.Errorf("unexpected platform in %+v", report)
+	}
+	if report.Records != 3 || report.RecordTypes["A"] != 1 || report.RecordTypes["TXT"] != 1 {
+		t.Errorf("unexpected record counts in %+v", report)
+	}
+	if report.Providers["dreamhost"] != 2 {
+		t.Errorf("expected 2 dreamhost providers in use, got %v", report.Providers)
+	}
+
+	data, _ := json.Marshal(report)
+	for _, private := range []string{"example", "home", "hello", "secret", "backup"} {
+		if strings.Contains(string(data), private) {
+			t.Errorf("report contains %q: %s", private, data)
+		}
+	}
+}
+
+// TestSendTelemetry tests that the report is posted as JSON and that failures don't fail the stage
+func TestSendTelemetry(t *testing.T) {
+	var received telemetryReport
+	status := http.StatusNoContent
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("unexpected request %s %s", r.Method, r.Header.Get("Content-Type"))
+		}
+		json.NewDecoder(r.Body).Decode(&received)
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	config := &Config{
+		Domains:   []DomainConfig{{Name: "example.com", Type: "A", Provider: DefaultProvider}},
+		Telemetry: TelemetryConfig{Enabled: true, Endpoint: srv.URL},
+	}
+	d := &DDNSUpdater{config: config, httpClient: srv.Client(), logger: newLogger(io.Discard, "error")}
+
+	if err := d.sendTelemetry(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if received.Records != 1 {
+		t.Errorf("expected the report to be received, got %+v", received)
+	}
+
+	status = http.StatusInternalServerError
+	if err := d.sendTelemetry(context.Background()); err == nil {
+		t.Error("expected an error for a failed post")
+	}
+	if err := d.reportUsage(context.Background()); err != nil {
+		t.Errorf("expected the stage to ignore the failure, got %v", err)
+	}
+}
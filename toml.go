@@ -0,0 +1,551 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
+)
+
+// parseTOML parses a TOML document into the node tree yaml.v3 produces for
+// the equivalent YAML, so profiles, environment references, validation line
+// numbers and decoding into Config work the same in either format. It
+// implements TOML 1.0 except that dates and times are kept as strings,
+// which is all the config needs.
+func parseTOML(data []byte) (*yaml.Node, error) {
+	p := &tomlParser{
+		src:         strings.TrimPrefix(string(data), "\ufeff"),
+		line:        1,
+		root:        tomlTable(1),
+		defined:     make(map[*yaml.Node]bool),
+		arrayTables: make(map[*yaml.Node]bool),
+	}
+	p.current = p.root
+	if err := p.parse(); err != nil {
+		return nil, fmt.Errorf("toml: line %d: %w", p.line, err)
+	}
+	return &yaml.Node{Kind: yaml.DocumentNode, Line: 1, Column: 1, Content: []*yaml.Node{p.root}}, nil
+}
+
+// tomlParser holds the state of parseTOML.
+type tomlParser struct {
+	src  string
+	pos  int
+	line int
+
+	root, current *yaml.Node          // Top-level table and the one key/values go into
+	defined       map[*yaml.Node]bool // Tables defined by a [header], which can't be defined again
+	arrayTables   map[*yaml.Node]bool // Arrays created by [[headers]], which later headers extend
+}
+
+func tomlTable(line int) *yaml.Node {
+	return &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Line: line}
+}
+
+func tomlScalar(tag, value string, line int) *yaml.Node {
+	node := &yaml.Node{Kind: yaml.ScalarNode, Tag: tag, Value: value, Line: line}
+	if tag == "!!str" {
+		// Keeps the string a string after environment expansion
+		node.Style = yaml.DoubleQuotedStyle
+	}
+	return node
+}
+
+func (p *tomlParser) rest() string { return p.src[p.pos:] }
+
+func (p *tomlParser) peek() byte {
+	if p.pos >= len(p.src) {
+		return 0
+	}
+	return p.src[p.pos]
+}
+
+// found describes the next character for error messages.
+func (p *tomlParser) found() string {
+	switch c := p.peek(); {
+	case p.pos >= len(p.src):
+		return "end of file"
+	case c == '\n' || c == '\r':
+		return "end of line"
+	default:
+		r, _ := utf8.DecodeRuneInString(p.rest())
+		return strconv.QuoteRune(r)
+	}
+}
+
+func (p *tomlParser) expect(s string) error {
+	if !strings.HasPrefix(p.rest(), s) {
+		return fmt.Errorf("expected %q, found %s", s, p.found())
+	}
+	p.pos += len(s)
+	return nil
+}
+
+// skipSpace skips spaces and tabs.
+func (p *tomlParser) skipSpace() {
+	for c := p.peek(); c == ' ' || c == '\t'; c = p.peek() {
+		p.pos++
+	}
+}
+
+// skipComment skips a comment up to the end of the line.
+func (p *tomlParser) skipComment() {
+	if p.peek() == '#' {
+		if i := strings.IndexByte(p.rest(), '\n'); i >= 0 {
+			p.pos += i
+		} else {
+			p.pos = len(p.src)
+		}
+	}
+}
+
+// newline consumes a line ending if there is one.
+func (p *tomlParser) newline() bool {
+	if strings.HasPrefix(p.rest(), "\r\n") {
+		p.pos++
+	}
+	if p.peek() == '\n' {
+		p.pos++
+		p.line++
+		return true
+	}
+	return false
+}
+
+// skipBlank skips whitespace, comments and line endings.
+func (p *tomlParser) skipBlank() {
+	for {
+		p.skipSpace()
+		p.skipComment()
+		if !p.newline() {
+			return
+		}
+	}
+}
+
+// endLine consumes the rest of a line after a header or key/value pair,
+// which may only hold a comment.
+func (p *tomlParser) endLine() error {
+	p.skipSpace()
+	p.skipComment()
+	if p.pos >= len(p.src) || p.newline() {
+		return nil
+	}
+	return fmt.Errorf("expected end of line, found %s", p.found())
+}
+
+func (p *tomlParser) parse() error {
+	for {
+		p.skipBlank()
+		if p.pos >= len(p.src) {
+			return nil
+		}
+		line := p.line
+
+		var err error
+		switch {
+		case strings.HasPrefix(p.rest(), "[["):
+			p.pos += 2
+			var keys []string
+			if keys, err = p.parseKey(); err == nil {
+				if err = p.expect("]]"); err == nil {
+					err = p.startArrayTable(keys, line)
+				}
+			}
+		case p.peek() == '[':
+			p.pos++
+			var keys []string
+			if keys, err = p.parseKey(); err == nil {
+				if err = p.expect("]"); err == nil {
+					err = p.startTable(keys, line)
+				}
+			}
+		default:
+			err = p.parseKeyValue(p.current)
+		}
+		if err != nil {
+			return err
+		}
+		if err := p.endLine(); err != nil {
+			return err
+		}
+	}
+}
+
+func isBareKeyChar(c byte) bool {
+	return c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '_' || c == '-'
+}
+
+// parseKey parses a possibly dotted key into its parts.
+func (p *tomlParser) parseKey() ([]string, error) {
+	var keys []string
+	for {
+		p.skipSpace()
+		var key string
+		var err error
+		switch c := p.peek(); {
+		case c == '"':
+			key, err = p.parseBasicString()
+		case c == '\'':
+			key, err = p.parseLiteralString()
+		case isBareKeyChar(c):
+			start := p.pos
+			for isBareKeyChar(p.peek()) {
+				p.pos++
+			}
+			key = p.src[start:p.pos]
+		default:
+			return nil, fmt.Errorf("expected a key, found %s", p.found())
+		}
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+
+		p.skipSpace()
+		if p.peek() != '.' {
+			return keys, nil
+		}
+		p.pos++
+	}
+}
+
+// parseKeyValue parses key = value into table.
+func (p *tomlParser) parseKeyValue(table *yaml.Node) error {
+	line := p.line
+	keys, err := p.parseKey()
+	if err != nil {
+		return err
+	}
+	if err := p.expect("="); err != nil {
+		return err
+	}
+	p.skipSpace()
+	value, err := p.parseValue()
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys[:len(keys)-1] {
+		if table, err = p.descend(table, key, line); err != nil {
+			return err
+		}
+	}
+	last := keys[len(keys)-1]
+	if existing, _ := mappingValue(table, last); existing != nil {
+		return fmt.Errorf("duplicate key %q", strings.Join(keys, "."))
+	}
+	table.Content = append(table.Content, tomlScalar("!!str", last, line), value)
+	return nil
+}
+
+// descend returns the table stored under key in parent, creating it if
+// there is none. For an array of tables it returns the last table.
+func (p *tomlParser) descend(parent *yaml.Node, key string, line int) (*yaml.Node, error) {
+	child, _ := mappingValue(parent, key)
+	switch {
+	case child == nil:
+		child = tomlTable(line)
+		parent.Content = append(parent.Content, tomlScalar("!!str", key, line), child)
+		return child, nil
+	case child.Kind == yaml.MappingNode:
+		return child, nil
+	case p.arrayTables[child]:
+		return child.Content[len(child.Content)-1], nil
+	default:
+		return nil, fmt.Errorf("key %q already holds a value", key)
+	}
+}
+
+// startTable handles a [table] header.
+func (p *tomlParser) startTable(keys []string, line int) error {
+	table := p.root
+	for _, key := range keys {
+		var err error
+		if table, err = p.descend(table, key, line); err != nil {
+			return err
+		}
+	}
+	if p.defined[table] {
+		return fmt.Errorf("table %q is defined twice", strings.Join(keys, "."))
+	}
+	p.defined[table] = true
+	p.current = table
+	return nil
+}
+
+// startArrayTable handles an [[array]] header, appending a table to the
+// array.
+func (p *tomlParser) startArrayTable(keys []string, line int) error {
+	parent := p.root
+	for _, key := range keys[:len(keys)-1] {
+		var err error
+		if parent, err = p.descend(parent, key, line); err != nil {
+			return err
+		}
+	}
+	last := keys[len(keys)-1]
+	array, _ := mappingValue(parent, last)
+	switch {
+	case array == nil:
+		array = &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq", Line: line}
+		parent.Content = append(parent.Content, tomlScalar("!!str", last, line), array)
+		p.arrayTables[array] = true
+	case !p.arrayTables[array]:
+		return fmt.Errorf("key %q is not an array of tables", strings.Join(keys, "."))
+	}
+	table := tomlTable(line)
+	array.Content = append(array.Content, table)
+	p.current = table
+	return nil
+}
+
+func (p *tomlParser) parseValue() (*yaml.Node, error) {
+	line := p.line
+	rest := p.rest()
+	switch {
+	case strings.HasPrefix(rest, `"`) || strings.HasPrefix(rest, "'"):
+		s, err := p.parseString()
+		if err != nil {
+			return nil, err
+		}
+		return tomlScalar("!!str", s, line), nil
+	case strings.HasPrefix(rest, "["):
+		return p.parseArray()
+	case strings.HasPrefix(rest, "{"):
+		return p.parseInlineTable()
+	}
+
+	// Everything else is a bare token: a boolean, number, date or time
+	end := strings.IndexAny(rest, " \t\r\n,]}#")
+	if end < 0 {
+		end = len(rest)
+	}
+	token := rest[:end]
+	if token == "" {
+		return nil, fmt.Errorf("expected a value, found %s", p.found())
+	}
+	p.pos += end
+
+	switch token {
+	case "true", "false":
+		return tomlScalar("!!bool", token, line), nil
+	case "inf", "+inf":
+		return tomlScalar("!!float", ".inf", line), nil
+	case "-inf":
+		return tomlScalar("!!float", "-.inf", line), nil
+	case "nan", "+nan", "-nan":
+		return tomlScalar("!!float", ".nan", line), nil
+	}
+	if len(token) >= 5 && token[4] == '-' || len(token) >= 3 && token[2] == ':' {
+		// A local or offset date, time or date-time
+		return tomlScalar("!!str", token, line), nil
+	}
+
+	digits := strings.ReplaceAll(token, "_", "")
+	unsigned := strings.TrimLeft(digits, "+-")
+	if strings.HasPrefix(unsigned, "0x") || strings.HasPrefix(unsigned, "0o") || strings.HasPrefix(unsigned, "0b") {
+		if unsigned == digits {
+			if n, err := strconv.ParseInt(digits, 0, 64); err == nil {
+				return tomlScalar("!!int", strconv.FormatInt(n, 10), line), nil
+			}
+		}
+	} else if !strings.ContainsAny(digits, ".eE") {
+		if n, err := strconv.ParseInt(digits, 10, 64); err == nil && (len(unsigned) == 1 || unsigned[0] != '0') {
+			return tomlScalar("!!int", strconv.FormatInt(n, 10), line), nil
+		}
+	} else if _, err := strconv.ParseFloat(digits, 64); err == nil {
+		return tomlScalar("!!float", digits, line), nil
+	}
+	return nil, fmt.Errorf("invalid value %q", token)
+}
+
+// parseArray parses [values...], which may span lines.
+func (p *tomlParser) parseArray() (*yaml.Node, error) {
+	array := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq", Style: yaml.FlowStyle, Line: p.line}
+	p.pos++
+	for {
+		p.skipBlank()
+		if p.peek() == ']' {
+			p.pos++
+			return array, nil
+		}
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		array.Content = append(array.Content, value)
+
+		p.skipBlank()
+		switch p.peek() {
+		case ',':
+			p.pos++
+		case ']':
+			p.pos++
+			return array, nil
+		default:
+			return nil, fmt.Errorf("expected ',' or ']' in array, found %s", p.found())
+		}
+	}
+}
+
+// parseInlineTable parses {key = value, ...}, which must fit on one line.
+func (p *tomlParser) parseInlineTable() (*yaml.Node, error) {
+	table := tomlTable(p.line)
+	table.Style = yaml.FlowStyle
+	p.pos++
+	p.skipSpace()
+	if p.peek() == '}' {
+		p.pos++
+		return table, nil
+	}
+	for {
+		if err := p.parseKeyValue(table); err != nil {
+			return nil, err
+		}
+		p.skipSpace()
+		switch p.peek() {
+		case ',':
+			p.pos++
+		case '}':
+			p.pos++
+			return table, nil
+		default:
+			return nil, fmt.Errorf("expected ',' or '}' in inline table, found %s", p.found())
+		}
+	}
+}
+
+// parseString parses any of the four kinds of TOML string.
+func (p *tomlParser) parseString() (string, error) {
+	switch rest := p.rest(); {
+	case strings.HasPrefix(rest, `"""`):
+		return p.parseMultilineString(`"""`, true)
+	case strings.HasPrefix(rest, "'''"):
+		return p.parseMultilineString("'''", false)
+	case rest[0] == '"':
+		return p.parseBasicString()
+	default:
+		return p.parseLiteralString()
+	}
+}
+
+// parseBasicString parses a "string" with escapes.
+func (p *tomlParser) parseBasicString() (string, error) {
+	var b strings.Builder
+	p.pos++
+	for {
+		switch c := p.peek(); {
+		case p.pos >= len(p.src) || c == '\n' || c == '\r':
+			return "", fmt.Errorf("unterminated string")
+		case c == '"':
+			p.pos++
+			return b.String(), nil
+		case c == '\\':
+			if err := p.parseEscape(&b); err != nil {
+				return "", err
+			}
+		default:
+			b.WriteByte(c)
+			p.pos++
+		}
+	}
+}
+
+// parseLiteralString parses a 'string' without escapes.
+func (p *tomlParser) parseLiteralString() (string, error) {
+	p.pos++
+	end := strings.IndexAny(p.rest(), "'\n")
+	if end < 0 || p.rest()[end] != '\'' {
+		return "", fmt.Errorf("unterminated string")
+	}
+	s := p.rest()[:end]
+	p.pos += end + 1
+	return s, nil
+}
+
+// parseMultilineString parses a multi-line string delimited by quotes,
+// three double quotes for a basic string (with escapes) or three single
+// quotes for a literal one (without). A newline right after the opening
+// quotes is dropped.
+func (p *tomlParser) parseMultilineString(quotes string, escapes bool) (string, error) {
+	var b strings.Builder
+	p.pos += len(quotes)
+	p.newline()
+	for {
+		switch c := p.peek(); {
+		case p.pos >= len(p.src):
+			return "", fmt.Errorf("unterminated string")
+		case strings.HasPrefix(p.rest(), quotes):
+			// Up to two quotes may directly precede the closing ones
+			n := len(p.rest()) - len(strings.TrimLeft(p.rest(), quotes[:1]))
+			extra := min(n-3, 2)
+			b.WriteString(p.src[p.pos : p.pos+extra])
+			p.pos += extra + 3
+			return b.String(), nil
+		case c == '\n' || c == '\r':
+			if !p.newline() {
+				return "", fmt.Errorf("bare carriage return in string")
+			}
+			b.WriteByte('\n')
+		case c == '\\' && escapes:
+			// A backslash at the end of a line trims the following whitespace
+			trimmed := strings.TrimLeft(p.rest()[1:], " \t")
+			if strings.HasPrefix(trimmed, "\n") || strings.HasPrefix(trimmed, "\r\n") {
+				p.pos = len(p.src) - len(trimmed)
+				for {
+					p.skipSpace()
+					if !p.newline() {
+						break
+					}
+				}
+				continue
+			}
+			if err := p.parseEscape(&b); err != nil {
+				return "", err
+			}
+		default:
+			b.WriteByte(c)
+			p.pos++
+		}
+	}
+}
+
+// tomlEscapes maps the single-character escapes to what they stand for.
+var tomlEscapes = map[byte]string{
+	'b': "\b", 't': "\t", 'n': "\n", 'f': "\f", 'r': "\r", '"': `"`, '\\': `\`,
+}
+
+// parseEscape parses the escape sequence at p.pos into b.
+func (p *tomlParser) parseEscape(b *strings.Builder) error {
+	p.pos++ // The backslash
+	c := p.peek()
+	if s, ok := tomlEscapes[c]; ok {
+		b.WriteString(s)
+		p.pos++
+		return nil
+	}
+
+	var n int
+	switch c {
+	case 'u':
+		n = 4
+	case 'U':
+		n = 8
+	default:
+		return fmt.Errorf("invalid escape sequence \\%s", p.found())
+	}
+	p.pos++
+	if len(p.rest()) < n {
+		return fmt.Errorf("invalid unicode escape")
+	}
+	code, err := strconv.ParseUint(p.rest()[:n], 16, 32)
+	if err != nil || !utf8.ValidRune(rune(code)) {
+		return fmt.Errorf("invalid unicode escape \\%c%s", c, p.rest()[:n])
+	}
+	b.WriteRune(rune(code))
+	p.pos += n
+	return nil
+}
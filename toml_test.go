@@ -0,0 +1,119 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// TestParseTOML tests that TOML documents decode like the equivalent YAML
+func TestParseTOML(t *testing.T) {
+	tests := []struct {
+		name string
+		toml string
+		want string // Equivalent YAML
+	}{
+		{
+			name: "scalars",
+			toml: `s = "a\tb \u00e9"  # comment
+lit = 'C:\path'
+i = 1_000
+h = 0xff
+neg = -3
+f = 2.5e3
+b = true
+inf = -inf
+d = 2026-10-16
+`,
+			want: "s: \"a\\tb é\"\nlit: 'C:\\path'\ni: 1000\nh: 255\nneg: -3\nf: 2.5e3\nb: true\ninf: -.inf\nd: \"2026-10-16\"\n",
+		},
+		{
+			name: "multi-line strings",
+			toml: "a = \"\"\"\nline one\nline \\\n    two\"\"\"\nb = '''\nraw \\n ''\"'''\n",
+			want: "a: \"line one\\nline two\"\nb: 'raw \\n ''''\"'\n",
+		},
+		{
+			name: "tables",
+			toml: `top = 1
+dotted.key = "x"
+
+[webhook]
+listen = ":8080"
+
+[providers.backup]
+api_key = "k"
+
+[[domains]]
+name = "example.com"
+type = "A"
+
+[[domains]]
+name = "example.org"
+type = "TXT"
+value = "v"
+schedule = [
+  { value = "w", days = ["sat", "sun"] }, # trailing comma
+]
+`,
+			want: `top: 1
+dotted: {key: x}
+webhook: {listen: ":8080"}
+providers: {backup: {api_key: k}}
+domains:
+  - {name: example.com, type: A}
+  - {name: example.org, type: TXT, value: v, schedule: [{value: w, days: [sat, sun]}]}
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := parseTOML([]byte(tt.toml))
+			if err != nil {
+				t.Fatalf("parseTOML: %v", err)
+			}
+			var got, want any
+			if err := doc.Decode(&got); err != nil {
+				t.Fatalf("decoding: %v", err)
+			}
+			if err := yaml.Unmarshal([]byte(tt.want), &want); err != nil {
+				t.Fatalf("bad test YAML: %v", err)
+			}
+			gotYAML, _ := yaml.Marshal(got)
+			wantYAML, _ := yaml.Marshal(want)
+			if string(gotYAML) != string(wantYAML) {
+				t.Errorf("expected:\n%s\ngot:\n%s", wantYAML, gotYAML)
+			}
+		})
+	}
+}
+
+// TestParseTOMLErrors tests that invalid TOML is rejected with the line of the problem
+func TestParseTOMLErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		toml      string
+		wantError string
+	}{
+		{name: "duplicate key", toml: "a = 1\na = 2\n", wantError: `line 2: duplicate key "a"`},
+		{name: "table defined twice", toml: "[a]\n[b]\n[a]\n", wantError: `line 3: table "a" is defined twice`},
+		{name: "table over value", toml: "a = 1\n[a.b]\n", wantError: `line 2: key "a" already holds a value`},
+		{name: "array table over array", toml: "a = [1]\n[[a]]\n", wantError: `line 2: key "a" is not an array of tables`},
+		{name: "unterminated string", toml: "\na = \"x\n", wantError: "line 2: unterminated string"},
+		{name: "leading zero", toml: "a = 012\n", wantError: `line 1: invalid value "012"`},
+		{name: "trailing garbage", toml: "a = 1 2\n", wantError: "line 1: expected end of line"},
+		{name: "missing value", toml: "a =\n", wantError: "line 1: expected a value, found end of line"},
+		{name: "bad escape", toml: `a = "\q"`, wantError: `invalid escape sequence \'q'`},
+		{name: "unclosed array", toml: "a = [1,\n2\n", wantError: "line 3: expected ',' or ']' in array, found end of file"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseTOML([]byte(tt.toml))
+			if err == nil || !strings.Contains(err.Error(), tt.wantError) {
+				t.Errorf("expected error containing %q, got %v", tt.wantError, err)
+			}
+		})
+	}
+}
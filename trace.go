@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// LevelTrace is below debug and additionally logs every provider API
+// request and raw response, with secrets redacted.
+const LevelTrace = slog.Level(-8)
+
+// maxTraceBody bounds how much of a response body is logged.
+const maxTraceBody = 8192
+
+// secretParams lists query parameters whose values are never logged.
+var secretParams = []string{"key", "api_key", "token", "password"}
+
+// redactURL returns u as a string with secret query parameters replaced.
+func redactURL(u *url.URL) string {
+	q := u.Query()
+	changed := false
+	for _, name := range secretParams {
+		if q.Has(name) {
+			q.Set(name, "REDACTED")
+			changed = true
+		}
+	}
+	if !changed {
+		return u.String()
+	}
+	redacted := *u
+	redacted.RawQuery = q.Encode()
+	return redacted.String()
+}
+
+// redactError removes secrets from the URL that net/http embeds in
+// transport errors, which would otherwise leak the API key into logs.
+func redactError(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		if u, parseErr := url.Parse(urlErr.URL); parseErr == nil {
+			urlErr.URL = redactURL(u)
+		}
+	}
+	return err
+}
+
+// tracingTransport logs requests and responses at LevelTrace. When trace
+// logging is disabled it adds nothing but a level check.
+type tracingTransport struct {
+	base   http.RoundTripper
+	logger *slog.Logger
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	if !t.logger.Enabled(ctx, LevelTrace) {
+		return t.base.RoundTrip(req)
+	}
+
+	t.logger.Log(ctx, LevelTrace, "API request",
+		"method", req.Method,
+		"url", redactURL(req.URL))
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		t.logger.Log(ctx, LevelTrace, "API request failed",
+			"url", redactURL(req.URL),
+			"error", redactError(err))
+		return nil, err
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	logged := string(body)
+	if len(logged) > maxTraceBody {
+		logged = logged[:maxTraceBody] + "...(truncated)"
+	}
+	t.logger.Log(ctx, LevelTrace, "API response",
+		"url", redactURL(req.URL),
+		"status", resp.StatusCode,
+		"body", strings.TrimSpace(logged))
+
+	return resp, nil
+}
+
+// traceLevelName prints LevelTrace as "TRACE" rather than "DEBUG-4".
+func traceLevelName(_ []string, a slog.Attr) slog.Attr {
+	if a.Key == slog.LevelKey {
+		if level, ok := a.Value.Any().(slog.Level); ok && level == LevelTrace {
+			a.Value = slog.StringValue("TRACE")
+		}
+	}
+	return a
+}
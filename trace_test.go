@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// TestRedactURL tests that secret query parameters are hidden and others kept
+func TestRedactURL(t *testing.T) {
+	u, _ := url.Parse("https://api.dreamhost.com/?key=SECRET123&cmd=dns-list_records")
+	got := redactURL(u)
+	if strings.Contains(got, "SECRET123") {
+		t.Errorf("API key not redacted: %s", got)
+	}
+	if !strings.Contains(got, "cmd=dns-list_records") || !strings.Contains(got, "key=REDACTED") {
+		t.Errorf("unexpected redacted URL: %s", got)
+	}
+	if u.Query().Get("key") != "SECRET123" {
+		t.Error("redactURL must not modify its argument")
+	}
+}
+
+// TestRedactError tests that transport errors don't carry the API key
+func TestRedactError(t *testing.T) {
+	err := redactError(&url.Error{Op: "Get", URL: "https://api.dreamhost.com/?key=SECRET123", Err: errors.New("dial failed")})
+	if strings.Contains(err.Error(), "SECRET123") {
+		t.Errorf("API key not redacted: %v", err)
+	}
+}
+
+// TestTracingTransport tests that trace logging records redacted requests and raw responses
+func TestTracingTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"result":"success","data":[]}`)
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		level     string
+		wantTrace bool
+	}{
+		{level: "trace", wantTrace: true},
+		{level: "debug", wantTrace: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.level, func(t *testing.T) {
+			var logs bytes.Buffer
+			client := &http.Client{Transport: &tracingTransport{base: http.DefaultTransport, logger: newLogger(&logs, tt.level)}}
+
+			req, _ := http.NewRequestWithContext(context.Background(), "GET", server.URL+"/?key=SECRET123&cmd=dns-list_records", nil)
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if string(body) != `{"result":"success","data":[]}` {
+				t.Errorf("response body not preserved: %q", body)
+			}
+
+			out := logs.String()
+			if strings.Contains(out, "SECRET123") {
+				t.Errorf("API key leaked into logs:\n%s", out)
+			}
+			if got := strings.Contains(out, `"level":"TRACE"`) && strings.Contains(out, "dns-list_records"); got != tt.wantTrace {
+				t.Errorf("expected trace output %v, got logs:\n%s", tt.wantTrace, out)
+			}
+		})
+	}
+}
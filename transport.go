@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// HTTPConfig tunes the daemon's HTTP client, which calls the IP service,
+// the provider APIs and the notification targets, for slow links and
+// strict environments.
+type HTTPConfig struct {
+	Timeout             time.Duration `yaml:"timeout"`               // Whole request, response body included (default 30s)
+	DetectionTimeout    time.Duration `yaml:"detection_timeout"`     // Requests to the IP service (default timeout)
+	ProviderTimeout     time.Duration `yaml:"provider_timeout"`      // Provider API calls (default timeout)
+	DialTimeout         time.Duration `yaml:"dial_timeout"`          // Establishing a connection (default 30s)
+	TLSHandshakeTimeout time.Duration `yaml:"tls_handshake_timeout"` // The TLS handshake (default 10s)
+	MaxIdleConns        int           `yaml:"max_idle_conns"`        // Idle connections kept for reuse across hosts (default 100)
+	IdleConnTimeout     time.Duration `yaml:"idle_conn_timeout"`     // How long an idle connection is kept (default 90s)
+
+	// CAFile is a PEM bundle of certificates trusted as well as the
+	// system's, for a TLS-intercepting proxy or a private IP service.
+	CAFile string `yaml:"ca_file"`
+	// MinTLSVersion is the oldest TLS version accepted: 1.2 (the default)
+	// or 1.3.
+	MinTLSVersion string `yaml:"min_tls_version"`
+	// InsecureSkipVerify accepts any server certificate, for testing in a
+	// lab only: it lets anyone on the path read the API key.
+	InsecureSkipVerify bool `yaml:"insecure_skip_verify"`
+
+	// Resolver looks up the names of the hosts requests go to instead of
+	// the host's configured DNS servers: the IP address of a DNS server,
+	// such as 9.9.9.9, or the https:// URL of a DNS-over-HTTPS endpoint.
+	Resolver string `yaml:"resolver"`
+}
+
+// tlsVersions are the accepted values of min_tls_version.
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// validateHTTPTLS checks the TLS settings that can be checked without
+// reading ca_file.
+func validateHTTPTLS(config HTTPConfig) error {
+	if _, ok := tlsVersions[config.MinTLSVersion]; config.MinTLSVersion != "" && !ok {
+		return fmt.Errorf("http: unsupported min_tls_version %q (want 1.2 or 1.3)", config.MinTLSVersion)
+	}
+	return nil
+}
+
+// tlsConfig returns the TLS settings of config's transport, trusting the
+// certificates in ca_file as well as the system's.
+func (c HTTPConfig) tlsConfig() (*tls.Config, error) {
+	tc := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+	}
+	if v, ok := tlsVersions[c.MinTLSVersion]; ok {
+		tc.MinVersion = v
+	}
+	if c.CAFile == "" {
+		return tc, nil
+	}
+	pem, err := os.ReadFile(c.CAFile)
+	if err != nil {
+		return nil, fmt.Errorf("http: ca_file: %w", err)
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("http: ca_file %s holds no PEM certificates", c.CAFile)
+	}
+	tc.RootCAs = pool
+	return tc, nil
+}
+
+// The HTTP client settings used unless http gives others, those of Go's
+// default transport.
+const (
+	DefaultHTTPTimeout         = 30 * time.Second
+	DefaultDialTimeout         = 30 * time.Second
+	DefaultTLSHandshakeTimeout = 10 * time.Second
+	DefaultMaxIdleConns        = 100
+	DefaultIdleConnTimeout     = 90 * time.Second
+)
+
+// newHTTPClient returns a client with config's timeout, transport and TLS
+// settings, connecting over network: tcp4, tcp6 or tcp for either.
+// Requests are logged at LevelTrace.
+func newHTTPClient(config HTTPConfig, network string, logger *slog.Logger) (*http.Client, error) {
+	tlsConfig, err := config.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	dialer := &net.Dialer{Timeout: config.DialTimeout, KeepAlive: 30 * time.Second}
+	if config.Resolver != "" {
+		if dialer.Resolver, err = newResolver(config.Resolver, tlsConfig); err != nil {
+			return nil, fmt.Errorf("http: %w", err)
+		}
+	}
+	transport.DialContext = func(ctx context.Context, _, addr string) (net.Conn, error) {
+		return dialer.DialContext(ctx, network, addr)
+	}
+	transport.TLSHandshakeTimeout = config.TLSHandshakeTimeout
+	transport.MaxIdleConns = config.MaxIdleConns
+	transport.IdleConnTimeout = config.IdleConnTimeout
+	return &http.Client{
+		Timeout:   config.Timeout,
+		Transport: &tracingTransport{base: transport, logger: logger},
+	}, nil
+}
+
+// detectionNetworks are the accepted values of detection_network.
+var detectionNetworks = []string{"tcp", "tcp4", "tcp6"}
+
+// detectionNetwork returns the network the public IP is detected over:
+// detection_network if set, otherwise IPv4 when A records follow the IP,
+// IPv6 when only AAAA records do, or either. With both, the IPv6 address
+// is detected separately; see dualStack.
+func (c *Config) detectionNetwork() string {
+	if c.DetectionNetwork != "" {
+		return c.DetectionNetwork
+	}
+	v4, v6 := c.followedFamilies()
+	switch {
+	case v4:
+		return "tcp4"
+	case v6:
+		return "tcp6"
+	}
+	return "tcp"
+}
+
+// dualStack reports whether both A and AAAA records follow the detected IP
+// without detection_network picking a stack. The IPv6 address is then
+// detected over tcp6 and kept under IPv6Source for the AAAA records.
+func (c *Config) dualStack() bool {
+	v4, v6 := c.followedFamilies()
+	return c.DetectionNetwork == "" && v4 && v6
+}
+
+// followedFamilies reports whether any A and any AAAA records follow the
+// detected IP.
+func (c *Config) followedFamilies() (v4, v6 bool) {
+	for _, domain := range c.Domains {
+		if domain.Value != "" {
+			continue // Doesn't follow the detected IP
+		}
+		switch strings.ToUpper(domain.Type) {
+		case "A":
+			v4 = true
+		case "AAAA":
+			v6 = true
+		}
+	}
+	return v4, v6
+}
+
+// validateDetectionNetwork checks detection_network, and that it suits the
+// address records following the detected IP.
+func validateDetectionNetwork(c *Config) error {
+	if c.DetectionNetwork == "" {
+		return nil
+	}
+	if !slices.Contains(detectionNetworks, c.DetectionNetwork) {
+		return fmt.Errorf("unsupported detection_network %q (want tcp4, tcp6 or tcp)", c.DetectionNetwork)
+	}
+	mismatched := map[string]string{"tcp4": "AAAA", "tcp6": "A"}[c.DetectionNetwork]
+	for _, domain := range c.Domains {
+		if domain.Value == "" && strings.EqualFold(domain.Type, mismatched) {
+			return fmt.Errorf("detection_network %s detects no address for %s record %s", c.DetectionNetwork, mismatched, domain.FQDN())
+		}
+	}
+	return nil
+}
+
+// clientWithTimeout returns the daemon's client with timeout in place of
+// its own, sharing its connections, or the client itself if timeout isn't
+// positive.
+func (d *DDNSUpdater) clientWithTimeout(timeout time.Duration) *http.Client {
+	if timeout <= 0 || timeout == d.httpClient.Timeout {
+		return d.httpClient
+	}
+	client := *d.httpClient
+	client.Timeout = timeout
+	return &client
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/pem"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestNewHTTPClient tests that the http settings reach the client and its transport
+func TestNewHTTPClient(t *testing.T) {
+	config := &Config{HTTP: HTTPConfig{Timeout: 5 * time.Second, TLSHandshakeTimeout: 3 * time.Second, MaxIdleConns: 4}}
+	applyConfigDefaults(config)
+	client, err := newHTTPClient(config.HTTP, "tcp", slog.New(slog.NewJSONHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if client.Timeout != 5*time.Second {
+		t.Errorf("Timeout = %v, want 5s", client.Timeout)
+	}
+	transport := client.Transport.(*tracingTransport).base.(*http.Transport)
+	if transport.TLSHandshakeTimeout != 3*time.Second || transport.MaxIdleConns != 4 || transport.IdleConnTimeout != DefaultIdleConnTimeout {
+		t.Errorf("unexpected transport settings: TLS handshake %v, max idle %d, idle timeout %v", transport.TLSHandshakeTimeout, transport.MaxIdleConns, transport.IdleConnTimeout)
+	}
+	if transport.DialContext == nil {
+		t.Error("expected the dial timeout to be set")
+	}
+	if config.HTTP.DetectionTimeout != 5*time.Second || config.HTTP.ProviderTimeout != 5*time.Second {
+		t.Errorf("expected per-call timeouts to default to timeout, got %v and %v", config.HTTP.DetectionTimeout, config.HTTP.ProviderTimeout)
+	}
+}
+
+// TestClientWithTimeout tests that a per-call timeout gives a client sharing the daemon's transport
+func TestClientWithTimeout(t *testing.T) {
+	client, err := newHTTPClient(HTTPConfig{Timeout: 30 * time.Second}, "tcp", slog.New(slog.NewJSONHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &DDNSUpdater{httpClient: client}
+
+	if c := d.clientWithTimeout(0); c != d.httpClient {
+		t.Error("expected no timeout to return the shared client")
+	}
+	if c := d.clientWithTimeout(30 * time.Second); c != d.httpClient {
+		t.Error("expected the same timeout to return the shared client")
+	}
+	c := d.clientWithTimeout(10 * time.Second)
+	if c == d.httpClient || c.Timeout != 10*time.Second || c.Transport != d.httpClient.Transport {
+		t.Errorf("expected a 10s client on the shared transport, got %+v", c)
+	}
+}
+
+// TestHTTPClientTLS tests that ca_file adds trusted certificates and that
+// insecure_skip_verify and min_tls_version reach the transport
+func TestHTTPClientTLS(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "203.0.113.42")
+	}))
+	defer server.Close()
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.pem")
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	notPEM := filepath.Join(dir, "not.pem")
+	if err := os.WriteFile(notPEM, []byte("not a certificate"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		config    HTTPConfig
+		wantErr   string // From creating the client
+		wantFetch bool   // Whether the test server's certificate is accepted
+	}{
+		{name: "system roots only", config: HTTPConfig{}, wantFetch: false},
+		{name: "ca_file", config: HTTPConfig{CAFile: caFile}, wantFetch: true},
+		{name: "insecure", config: HTTPConfig{InsecureSkipVerify: true}, wantFetch: true},
+		{name: "missing ca_file", config: HTTPConfig{CAFile: filepath.Join(dir, "missing.pem")}, wantErr: "http: ca_file"},
+		{name: "ca_file without certificates", config: HTTPConfig{CAFile: notPEM}, wantErr: "holds no PEM certificates"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := newHTTPClient(tt.config, "tcp", slog.New(slog.NewJSONHandler(io.Discard, nil)))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := client.Get(server.URL)
+			if err == nil {
+				resp.Body.Close()
+			}
+			if (err == nil) != tt.wantFetch {
+				t.Errorf("fetch error = %v, want success %v", err, tt.wantFetch)
+			}
+		})
+	}
+
+	config := HTTPConfig{MinTLSVersion: "1.3"}
+	client, err := newHTTPClient(config, "tcp", slog.New(slog.NewJSONHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := client.Transport.(*tracingTransport).base.(*http.Transport).TLSClientConfig.MinVersion; v != tls.VersionTLS13 {
+		t.Errorf("MinVersion = %x, want TLS 1.3", v)
+	}
+}
+
+// TestDetectionNetwork tests that detection follows the address records
+// unless detection_network is set, and that it must suit them
+func TestDetectionNetwork(t *testing.T) {
+	a := DomainConfig{Name: "example.com", Record: "home", Type: "A"}
+	aaaa := DomainConfig{Name: "example.com", Record: "home", Type: "aaaa"}
+	txt := DomainConfig{Name: "example.com", Record: "txt", Type: "TXT", Value: "{{.IP}}"}
+	pinned := DomainConfig{Name: "example.com", Record: "pinned", Type: "AAAA", Value: "2001:db8::1"}
+	tests := []struct {
+		name     string
+		network  string
+		domains  []DomainConfig
+		want     string
+		wantDual bool
+		wantErr  string
+	}{
+		{name: "A records", domains: []DomainConfig{a, txt}, want: "tcp4"},
+		{name: "AAAA records", domains: []DomainConfig{aaaa}, want: "tcp6"},
+		{name: "both", domains: []DomainConfig{a, aaaa}, want: "tcp4", wantDual: true},
+		{name: "pinned AAAA doesn't follow the IP", domains: []DomainConfig{a, pinned}, want: "tcp4"},
+		{name: "no address records", domains: []DomainConfig{txt}, want: "tcp"},
+		{name: "explicit", network: "tcp", domains: []DomainConfig{a}, want: "tcp"},
+		{name: "mismatched", network: "tcp4", domains: []DomainConfig{aaaa}, wantErr: "detection_network tcp4 detects no address for AAAA record home.example.com"},
+		{name: "unsupported", network: "udp", domains: []DomainConfig{a}, wantErr: `unsupported detection_network "udp"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &Config{DetectionNetwork: tt.network, Domains: tt.domains}
+			err := validateDetectionNetwork(config)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := config.detectionNetwork(); got != tt.want {
+				t.Errorf("detectionNetwork() = %q, want %q", got, tt.want)
+			}
+			if got := config.dualStack(); got != tt.wantDual {
+				t.Errorf("dualStack() = %v, want %v", got, tt.wantDual)
+			}
+		})
+	}
+}
+
+// TestHTTPClientNetwork tests that the client only connects over its network
+func TestHTTPClientNetwork(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.RemoteAddr)
+	}))
+	defer server.Close() // Listens on 127.0.0.1
+
+	for network, wantOK := range map[string]bool{"tcp": true, "tcp4": true, "tcp6": false} {
+		client, err := newHTTPClient(HTTPConfig{Timeout: 5 * time.Second}, network, slog.New(slog.NewJSONHandler(io.Discard, nil)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := client.Get(server.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+		if (err == nil) != wantOK {
+			t.Errorf("%s: request error = %v, want success %v", network, err, wantOK)
+		}
+	}
+}
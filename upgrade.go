@@ -0,0 +1,134 @@
+package main
+
+import (
+	"cmp"
+	_ "embed"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// releaseNotesData lists the behavior-affecting changes of each release.
+//
+//go:embed release_notes.yaml
+var releaseNotesData []byte
+
+// releaseNotes are the behavior-affecting changes of one release.
+type releaseNotes struct {
+	Version string   `yaml:"version"`
+	Changes []string `yaml:"changes"`
+}
+
+// stateMigration converts the state file of versions before Version.
+type stateMigration struct {
+	Version     string // First version needing the migration
+	Description string
+	Migrate     func(state *State, config *Config) error
+}
+
+// stateMigrations are run in order on the state of an upgraded daemon, for
+// every migration after the version that last wrote the state.
+var stateMigrations = []stateMigration{
+	{
+		Version:     "1.1.0",
+		Description: "records kept by name and type",
+		Migrate: func(state *State, config *Config) error {
+			migrateStateKeys(state, config)
+			return nil
+		},
+	},
+}
+
+// parseVersion parses a "1.2.3" or "v1.2.3" release version.
+func parseVersion(v string) ([3]int, bool) {
+	var parsed [3]int
+	parts := strings.Split(strings.TrimPrefix(v, "v"), ".")
+	if len(parts) != 3 {
+		return parsed, false
+	}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return parsed, false
+		}
+		parsed[i] = n
+	}
+	return parsed, true
+}
+
+// compareVersions compares two release versions like cmp.Compare.
+// Versions that don't parse sort before all others.
+func compareVersions(a, b string) int {
+	pa, okA := parseVersion(a)
+	pb, okB := parseVersion(b)
+	if !okA || !okB {
+		return cmp.Compare(btoi(okA), btoi(okB))
+	}
+	return slices.Compare(pa[:], pb[:])
+}
+
+// btoi converts a bool to 1 or 0.
+func btoi(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// upgradedBetween reports whether a release at v came after from and at or
+// before to. An empty from is before every release.
+func upgradedBetween(v, from, to string) bool {
+	return (from == "" || compareVersions(v, from) > 0) && compareVersions(v, to) <= 0
+}
+
+// checkUpgrade compares the running version with the one that last wrote
+// the state. After an upgrade it logs the behavior-affecting changes of the
+// releases in between and runs their state migrations, then records the
+// running version. Development builds are never recorded.
+func (d *DDNSUpdater) checkUpgrade() error {
+	previous := d.state.Version
+	if previous == version {
+		return nil
+	}
+	if _, ok := parseVersion(version); !ok {
+		d.logger.Debug("Not checking for upgrade notes in a development build", "version", version, "state_version", previous)
+		return nil
+	}
+
+	// A state with nothing in it is a new installation, not an upgrade
+	fresh := previous == "" && d.state.LastIP == "" && len(d.state.Records) == 0
+	switch {
+	case fresh:
+	case previous != "" && compareVersions(previous, version) > 0:
+		d.logger.Warn("State was written by a newer version; downgrades are not migrated", "version", version, "state_version", previous)
+	default:
+		var notes []releaseNotes
+		if err := yaml.Unmarshal(releaseNotesData, &notes); err != nil {
+			return fmt.Errorf("reading release notes: %w", err)
+		}
+		d.logger.Info("Upgraded", "from", cmp.Or(previous, "unknown"), "to", version)
+		for _, release := range notes {
+			if !upgradedBetween(release.Version, previous, version) {
+				continue
+			}
+			for _, change := range release.Changes {
+				d.logger.Info("Upgrade note", "release", release.Version, "change", change)
+			}
+		}
+		for _, m := range stateMigrations {
+			if !upgradedBetween(m.Version, previous, version) {
+				continue
+			}
+			if err := m.Migrate(d.state, d.config); err != nil {
+				return fmt.Errorf("migrating state for %s (%s): %w", m.Version, m.Description, err)
+			}
+			d.logger.Info("Migrated state", "release", m.Version, "migration", m.Description)
+		}
+	}
+
+	d.state.Version = version
+	return d.saveState()
+}
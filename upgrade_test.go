@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"maps"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// TestCompareVersions tests ordering release versions
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.0.0", "1.0.0", 0},
+		{"v1.2.0", "1.10.0", -1},
+		{"2.0.0", "1.9.9", 1},
+		{"dev", "1.0.0", -1},
+		{"1.0", "dev", 0},
+	}
+	for _, tt := range tests {
+		if got := compareVersions(tt.a, tt.b); got != tt.want {
+			t.Errorf("compareVersions(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+// TestReleaseNotes tests that the embedded release notes parse and list valid versions in order
+func TestReleaseNotes(t *testing.T) {
+	var notes []releaseNotes
+	if err := yaml.Unmarshal(releaseNotesData, &notes); err != nil {
+		t.Fatal(err)
+	}
+	for i, release := range notes {
+		if _, ok := parseVersion(release.Version); !ok || len(release.Changes) == 0 {
+			t.Errorf("invalid release %+v", release)
+		}
+		if i > 0 && compareVersions(notes[i-1].Version, release.Version) >= 0 {
+			t.Errorf("release %s is out of order", release.Version)
+		}
+	}
+}
+
+// TestCheckUpgrade tests logging upgrade notes, running migrations and recording the version
+func TestCheckUpgrade(t *testing.T) {
+	defer func(v string) { version = v }(version)
+	defer func(m []stateMigration) { stateMigrations = m }(stateMigrations)
+	defer func(d []byte) { releaseNotesData = d }(releaseNotesData)
+	releaseNotesData = []byte(`
+- version: "1.1.0"
+  changes: ["first change"]
+- version: "1.2.0"
+  changes: ["second change"]
+- version: "2.0.0"
+  changes: ["future change"]
+`)
+
+	tests := []struct {
+		name          string
+		running       string
+		state         State
+		wantVersion   string
+		wantLogs      []string
+		wantNoLogs    []string
+		wantMigration bool
+	}{
+		{
+			name:        "new installation",
+			running:     "1.2.0",
+			wantVersion: "1.2.0",
+			wantNoLogs:  []string{"Upgrade note"},
+		},
+		{
+			name:          "upgrade",
+			running:       "1.2.0",
+			state:         State{LastIP: "203.0.113.1", Version: "1.0.0"},
+			wantVersion:   "1.2.0",
+			wantLogs:      []string{`"from":"1.0.0"`, "first change", "second change"},
+			wantNoLogs:    []string{"future change"},
+			wantMigration: true,
+		},
+		{
+			name:        "upgrade from before versions were recorded",
+			running:     "1.1.0",
+			state:       State{LastIP: "203.0.113.1"},
+			wantVersion: "1.1.0",
+			wantLogs:    []string{`"from":"unknown"`, "first change"},
+			wantNoLogs:  []string{"second change"},
+		},
+		{
+			name:        "same version",
+			running:     "1.2.0",
+			state:       State{LastIP: "203.0.113.1", Version: "1.2.0"},
+			wantVersion: "1.2.0",
+			wantNoLogs:  []string{"Upgrade"},
+		},
+		{
+			name:        "downgrade",
+			running:     "1.1.0",
+			state:       State{LastIP: "203.0.113.1", Version: "1.2.0"},
+			wantVersion: "1.1.0",
+			wantLogs:    []string{"newer version"},
+			wantNoLogs:  []string{"Upgrade note"},
+		},
+		{
+			name:        "development build",
+			running:     "dev",
+			state:       State{LastIP: "203.0.113.1", Version: "1.0.0"},
+			wantVersion: "1.0.0",
+			wantNoLogs:  []string{"Upgrade note"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			version = tt.running
+			migrated := false
+			stateMigrations = []stateMigration{{Version: "1.2.0", Description: "test", Migrate: func(*State, *Config) error {
+				migrated = true
+				return nil
+			}}}
+
+			var logs bytes.Buffer
+			state := tt.state
+			d := &DDNSUpdater{
+				config: &Config{StatePath: filepath.Join(t.TempDir(), "state.json")},
+				state:  &state,
+				logger: newLogger(&logs, "info"),
+			}
+			if err := d.checkUpgrade(); err != nil {
+				t.Fatal(err)
+			}
+
+			if state.Version != tt.wantVersion {
+				t.Errorf("expected state version %q, got %q", tt.wantVersion, state.Version)
+			}
+			if migrated != tt.wantMigration {
+				t.Errorf("expected migration run %v, got %v", tt.wantMigration, migrated)
+			}
+			for _, want := range tt.wantLogs {
+				if !strings.Contains(logs.String(), want) {
+					t.Errorf("expected %q in logs:\n%s", want, logs.String())
+				}
+			}
+			for _, unwanted := range tt.wantNoLogs {
+				if strings.Contains(logs.String(), unwanted) {
+					t.Errorf("unexpected %q in logs:\n%s", unwanted, logs.String())
+				}
+			}
+		})
+	}
+}
+
+// TestStateKeyMigration tests that upgrading from before 1.1.0 keys the
+// state's records by name and type
+func TestStateKeyMigration(t *testing.T) {
+	defer func(v string) { version = v }(version)
+	version = "1.1.0"
+
+	state := &State{Version: "1.0.0", Records: map[string]string{"home.example.com": "203.0.113.1"}}
+	d := &DDNSUpdater{
+		config: &Config{StatePath: filepath.Join(t.TempDir(), "state.json")},
+		state:  state,
+		logger: newLogger(io.Discard, "info"),
+	}
+	if err := d.checkUpgrade(); err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"home.example.com/A": "203.0.113.1"}; !maps.Equal(state.Records, want) {
+		t.Errorf("expected records %v, got %v", want, state.Records)
+	}
+}
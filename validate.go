@@ -0,0 +1,299 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// validateConfig checks a config with defaults applied and returns every
+// problem found, each prefixed with the file and line it concerns when the
+// config was read from files. Record values are normalized as they are
+// checked.
+func validateConfig(config *Config) []error {
+	var errs []error
+	add := func(pos configPosition, err error) {
+		errs = append(errs, atPosition(pos, err))
+	}
+
+	durations := []struct {
+		key     string
+		value   time.Duration
+		limited bool // Sets how often the IP service or a provider is called
+	}{
+		{"check_interval", config.CheckInterval, true},
+		{"publish_interval", config.PublishInterval, true},
+		{"retry_interval", config.RetryInterval, true},
+		{"cycle_timeout", config.CycleTimeout, false},
+		{"negative_cache_ttl", config.NegativeCacheTTL, false},
+		{"repeated_error_interval", config.RepeatedErrorInterval, false},
+		{"min_check_interval", config.MinCheckInterval, false},
+		{"jitter", config.Jitter, false},
+		{"max_retry_interval", config.MaxRetryInterval, false},
+		{"shutdown_grace_period", config.ShutdownGracePeriod, false},
+	}
+	for _, d := range durations {
+		// publish_interval defaults to check_interval; report a bad value once
+		if d.key == "publish_interval" && d.value == config.CheckInterval {
+			continue
+		}
+		switch {
+		case d.value < 0:
+			add(config.position(d.key), fmt.Errorf("%s must not be negative", d.key))
+		case d.limited && config.MinCheckInterval > 0 && d.value < config.MinCheckInterval:
+			add(config.position(d.key), fmt.Errorf("%s of %s is below the minimum of %s, which protects the IP service and provider APIs from being hammered; raise it or lower min_check_interval", d.key, d.value, config.MinCheckInterval))
+		}
+	}
+	for i := range config.QuietHours {
+		if err := validateQuietWindow(&config.QuietHours[i]); err != nil {
+			add(config.position("quiet_hours", i), fmt.Errorf("quiet_hours %d: %w", i, err))
+		}
+	}
+	if config.MaxRetryInterval > 0 && config.MaxRetryInterval < config.RetryInterval {
+		add(config.position("max_retry_interval"), fmt.Errorf("max_retry_interval of %s is below retry_interval of %s", config.MaxRetryInterval, config.RetryInterval))
+	}
+	for _, name := range slices.Sorted(maps.Keys(config.Providers)) {
+		pc := config.Providers[name]
+		for key, value := range map[string]time.Duration{
+			"min_request_interval":   pc.MinRequestInterval,
+			"rate_limit_cooldown":    pc.RateLimitCooldown,
+			"circuit_probe_interval": pc.CircuitProbeInterval,
+		} {
+			if value < 0 {
+				add(config.position("providers", name, key), fmt.Errorf("provider %q: %s must not be negative", name, key))
+			}
+		}
+	}
+
+	switch config.StateBackend {
+	case "json", "sqlite", "redis", "etcd", "consul", "none":
+	default:
+		add(config.position("state_backend"), fmt.Errorf("unsupported state_backend %q (want json, sqlite, redis, etcd, consul or none)", config.StateBackend))
+	}
+	sharedStateURLs := map[string]struct{ key, value string }{
+		"etcd":   {"endpoint", config.Etcd.Endpoint},
+		"consul": {"address", config.Consul.Address},
+	}
+	if u, ok := sharedStateURLs[config.StateBackend]; ok {
+		if parsed, err := url.Parse(u.value); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			add(config.position(config.StateBackend, u.key), fmt.Errorf("%s: %s %q is not an http or https URL", config.StateBackend, u.key, u.value))
+		}
+	}
+	if config.HistorySize < 0 {
+		add(config.position("history_size"), errors.New("history_size must not be negative"))
+	}
+	logLimits := []struct {
+		key      string
+		negative bool
+	}{
+		{"max_size_mb", config.LogFile.MaxSizeMB < 0},
+		{"rotate_every", config.LogFile.RotateEvery < 0},
+		{"max_backups", config.LogFile.MaxBackups < 0},
+		{"max_age", config.LogFile.MaxAge < 0},
+	}
+	for _, l := range logLimits {
+		if l.negative {
+			add(config.position("log_file", l.key), fmt.Errorf("log_file: %s must not be negative", l.key))
+		}
+	}
+	httpLimits := []struct {
+		key      string
+		negative bool
+	}{
+		{"timeout", config.HTTP.Timeout < 0},
+		{"detection_timeout", config.HTTP.DetectionTimeout < 0},
+		{"provider_timeout", config.HTTP.ProviderTimeout < 0},
+		{"dial_timeout", config.HTTP.DialTimeout < 0},
+		{"tls_handshake_timeout", config.HTTP.TLSHandshakeTimeout < 0},
+		{"max_idle_conns", config.HTTP.MaxIdleConns < 0},
+		{"idle_conn_timeout", config.HTTP.IdleConnTimeout < 0},
+	}
+	for _, l := range httpLimits {
+		if l.negative {
+			add(config.position("http", l.key), fmt.Errorf("http: %s must not be negative", l.key))
+		}
+	}
+	if err := validateDetectionNetwork(config); err != nil {
+		add(config.position("detection_network"), err)
+	}
+	if err := validateHTTPTLS(config.HTTP); err != nil {
+		add(config.position("http", "min_tls_version"), err)
+	}
+	if config.HTTP.Resolver != "" {
+		if _, _, err := parseResolver(config.HTTP.Resolver); err != nil {
+			add(config.position("http", "resolver"), fmt.Errorf("http: %w", err))
+		}
+	}
+	if err := validateLeader(config); err != nil {
+		add(config.position("leader_election"), err)
+	}
+	if err := validateSyslog(config.Syslog); err != nil {
+		add(config.position("syslog"), err)
+	}
+	for i, w := range config.Notifications.Webhooks {
+		if err := validateWebhookNotification(w); err != nil {
+			add(config.position("notifications", "webhooks", i), fmt.Errorf("notifications: webhook %d: %w", i, err))
+		}
+	}
+	for i, s := range config.Notifications.Slack {
+		if err := validateSlackNotification(s); err != nil {
+			add(config.position("notifications", "slack", i), fmt.Errorf("notifications: slack %d: %w", i, err))
+		}
+	}
+	for i, d := range config.Notifications.Discord {
+		if err := validateDiscordNotification(d); err != nil {
+			add(config.position("notifications", "discord", i), fmt.Errorf("notifications: discord %d: %w", i, err))
+		}
+	}
+	for i, n := range config.Notifications.Ntfy {
+		if err := validateNtfyNotification(n); err != nil {
+			add(config.position("notifications", "ntfy", i), fmt.Errorf("notifications: ntfy %d: %w", i, err))
+		}
+	}
+	for i, g := range config.Notifications.Gotify {
+		if err := validateGotifyNotification(g); err != nil {
+			add(config.position("notifications", "gotify", i), fmt.Errorf("notifications: gotify %d: %w", i, err))
+		}
+	}
+	for i, p := range config.Notifications.Pushover {
+		if err := validatePushoverNotification(p); err != nil {
+			add(config.position("notifications", "pushover", i), fmt.Errorf("notifications: pushover %d: %w", i, err))
+		}
+	}
+	for i, m := range config.Notifications.MQTT {
+		if err := validateMQTTNotification(m); err != nil {
+			add(config.position("notifications", "mqtt", i), fmt.Errorf("notifications: mqtt %d: %w", i, err))
+		}
+	}
+	if err := validateHeartbeat(config.Heartbeat); err != nil {
+		add(config.position("heartbeat"), err)
+	}
+	if err := validateStatsD(config.StatsD); err != nil {
+		add(config.position("statsd"), err)
+	}
+	if err := validateGeoIP(config.GeoIP); err != nil {
+		add(config.position("geoip"), err)
+	}
+	for _, r := range config.Notifications.routes() {
+		for _, provider := range r.route.Providers {
+			if _, ok := config.Providers[provider]; !ok && provider != DefaultProvider {
+				add(config.position("notifications", r.key, r.index, "providers"), fmt.Errorf("notifications: %s %d: unknown provider %q", r.label, r.index, provider))
+			}
+		}
+	}
+	if config.Defaults.TTL < 0 {
+		add(config.position("defaults", "ttl"), errors.New("defaults: ttl must not be negative"))
+	}
+
+	seen := make(map[string]int) // Record name and type to the index of its first entry
+	for i := range config.Domains {
+		domain := &config.Domains[i]
+		pos := config.position("domains", i)
+		fail := func(err error) {
+			add(pos, fmt.Errorf("domain %d (%s): %w", i, domain.Name, err))
+		}
+
+		if domain.Name == "" {
+			fail(errors.New("name is required"))
+			continue
+		}
+		if err := validateDomain(domain); err != nil {
+			fail(err)
+			continue
+		}
+		if domain.TTL < 0 {
+			fail(errors.New("ttl must not be negative"))
+			continue
+		}
+		if err := resolveCreationDefaults(config, domain); err != nil {
+			fail(err)
+			continue
+		}
+		key := strings.ToLower(domain.FQDN()) + "/" + domain.Type
+		if first, ok := seen[key]; ok {
+			fail(fmt.Errorf("duplicate of domain %d (%s %s)", first, config.Domains[first].FQDN(), domain.Type))
+			continue
+		}
+		seen[key] = i
+
+		pc, ok := config.Providers[domain.Provider]
+		switch {
+		case !ok && domain.Provider != DefaultProvider:
+			fail(fmt.Errorf("unknown provider %q", domain.Provider))
+		case !ok && config.DreamhostAPIKey == "":
+			fail(errors.New("dreamhost_api_key is required"))
+		case ok && (pc.Type == "" || pc.Type == "dreamhost") && pc.APIKey == "":
+			fail(fmt.Errorf("provider %q: api_key is required", domain.Provider))
+		}
+	}
+
+	if config.Webhook.Listen != "" && config.Webhook.Token == "" {
+		add(config.position("webhook"), errors.New("webhook: token is required"))
+	}
+	if err := validateDyndns2(config); err != nil {
+		add(config.position("dyndns2"), err)
+	}
+	if config.Telemetry.Enabled && config.Telemetry.Endpoint == "" {
+		add(config.position("telemetry"), errors.New("telemetry: endpoint is required"))
+	}
+	return errs
+}
+
+// runValidateCommand checks a configuration without starting the daemon,
+// listing every problem found with its line.
+//
+//	dh-ddns-updater validate [--profile name] [config]
+func runValidateCommand(args []string) int {
+	fs := flag.NewFlagSet("validate", flag.ContinueOnError)
+	opts := configFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: dh-ddns-updater validate [--profile name] [config]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	path := commandConfigPath(fs)
+
+	errs := checkConfigFile(path, *opts)
+	for _, err := range errs {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+	}
+	if len(errs) > 0 {
+		return 1
+	}
+	fmt.Printf("%s: configuration is valid\n", path)
+	return 0
+}
+
+// checkConfigFile loads the config at path and returns every problem that
+// would stop the daemon from starting with it, short of contacting the
+// providers.
+func checkConfigFile(path string, opts configOptions) []error {
+	config, err := loadConfig(path, opts)
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		return joined.Unwrap() // Such as every unknown key
+	}
+	if err != nil {
+		return []error{err}
+	}
+	applyConfigDefaults(config)
+	if errs := validateConfig(config); len(errs) > 0 {
+		return errs
+	}
+
+	d := &DDNSUpdater{config: config, httpClient: http.DefaultClient, logger: newLogger(io.Discard, "error")}
+	if d.providers, err = d.buildProviders(); err != nil {
+		return []error{err}
+	}
+	problems, _ := d.checkCapabilities()
+	return problems
+}
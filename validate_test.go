@@ -0,0 +1,279 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestValidateConfig tests that every problem is reported with the line it concerns
+func TestValidateConfig(t *testing.T) {
+	tests := []struct {
+		name       string
+		yaml       string
+		wantErrors []string
+	}{
+		{
+			name: "valid",
+			yaml: `dreamhost_api_key: key
+domains:
+  - name: example.com
+    record: home
+    type: A
+`,
+		},
+		{
+			name: "several problems",
+			yaml: `dreamhost_api_key: key
+check_interval: -5m
+domains:
+  - name: example.com
+    type: PTR
+  - type: A
+  - name: example.com
+    record: home
+    type: A
+  - name: EXAMPLE.com
+    record: home
+    type: a
+  - name: example.com
+    type: A
+    provider: other
+`,
+			wantErrors: []string{
+				"line 2: check_interval must not be negative",
+				"line 4: domain 0 (example.com): unsupported record type \"PTR\"",
+				"line 6: domain 1 (): name is required",
+				"line 10: domain 3 (EXAMPLE.com): duplicate of domain 2 (home.example.com A)",
+				"line 13: domain 4 (example.com): unknown provider \"other\"",
+			},
+		},
+		{
+			name: "state backend",
+			yaml: `dreamhost_api_key: key
+state_backend: mysql
+domains:
+  - name: example.com
+    type: A
+`,
+			wantErrors: []string{`line 2: unsupported state_backend "mysql" (want json, sqlite, redis, etcd, consul or none)`},
+		},
+		{
+			name: "log file limits",
+			yaml: `dreamhost_api_key: key
+log_file:
+  path: /var/log/dh-ddns-updater.log
+  max_size_mb: -1
+  max_age: -24h
+domains:
+  - name: example.com
+    type: A
+`,
+			wantErrors: []string{
+				"line 4: log_file: max_size_mb must not be negative",
+				"line 5: log_file: max_age must not be negative",
+			},
+		},
+		{
+			name: "http limits",
+			yaml: `dreamhost_api_key: key
+http:
+  provider_timeout: -1s
+  max_idle_conns: -1
+  min_tls_version: "1.1"
+  resolver: dns.quad9.net
+domains:
+  - name: example.com
+    type: A
+`,
+			wantErrors: []string{
+				"line 3: http: provider_timeout must not be negative",
+				"line 4: http: max_idle_conns must not be negative",
+				`line 5: http: unsupported min_tls_version "1.1" (want 1.2 or 1.3)`,
+				`line 6: http: resolver "dns.quad9.net" must be an IP address, optionally with a port, or an https:// DNS-over-HTTPS URL`,
+			},
+		},
+		{
+			name: "detection network",
+			yaml: `dreamhost_api_key: key
+detection_network: tcp6
+domains:
+  - name: example.com
+    record: home
+    type: A
+`,
+			wantErrors: []string{"line 2: detection_network tcp6 detects no address for A record home.example.com"},
+		},
+		{
+			name: "leader election",
+			yaml: `dreamhost_api_key: key
+leader_election:
+  enabled: true
+domains:
+  - name: example.com
+    type: A
+`,
+			wantErrors: []string{"line 3: leader_election: lease_file is required with state_backend json; only redis, etcd and consul can hold the lease"},
+		},
+		{
+			name: "syslog",
+			yaml: `dreamhost_api_key: key
+syslog:
+  address: udp://logs.lan
+  facility: local9
+domains:
+  - name: example.com
+    type: A
+`,
+			wantErrors: []string{`line 3: syslog: unknown facility "local9" (want kern, user, mail, daemon, auth, syslog, lpr, news, uucp, cron, authpriv, ftp or local0 to local7)`},
+		},
+		{
+			name: "notifications",
+			yaml: `dreamhost_api_key: key
+notifications:
+  webhooks:
+    - url: https://hooks.example.com/ddns
+      providers: [dreamhost, wrok]
+      failure_threshold: -1
+domains:
+  - name: example.com
+    type: A
+`,
+			wantErrors: []string{
+				"line 4: notifications: webhook 0: failure_threshold must not be negative",
+				`line 5: notifications: webhook 0: unknown provider "wrok"`,
+			},
+		},
+		{
+			name: "shared state URL",
+			yaml: `dreamhost_api_key: key
+state_backend: etcd
+etcd:
+  endpoint: localhost:2379
+domains:
+  - name: example.com
+    type: A
+`,
+			wantErrors: []string{`line 4: etcd: endpoint "localhost:2379" is not an http or https URL`},
+		},
+		{
+			name: "intervals below the minimum",
+			yaml: `dreamhost_api_key: key
+check_interval: 5s
+retry_interval: 10s
+negative_cache_ttl: -1m
+providers:
+  backup:
+    api_key: key
+    rate_limit_cooldown: -30s
+`,
+			wantErrors: []string{
+				"line 2: check_interval of 5s is below the minimum of 30s, which protects the IP service and provider APIs from being hammered; raise it or lower min_check_interval",
+				"line 3: retry_interval of 10s is below the minimum of 30s, which protects the IP service and provider APIs from being hammered; raise it or lower min_check_interval",
+				"line 4: negative_cache_ttl must not be negative",
+				"line 8: provider \"backup\": rate_limit_cooldown must not be negative",
+			},
+		},
+		{
+			name: "backoff capped below the retry interval",
+			yaml: `dreamhost_api_key: key
+retry_interval: 10m
+max_retry_interval: 5m
+`,
+			wantErrors: []string{"line 3: max_retry_interval of 5m0s is below retry_interval of 10m0s"},
+		},
+		{
+			name: "quiet hours",
+			yaml: `dreamhost_api_key: key
+quiet_hours:
+  - days: [mon, fri]
+    from: "09:00"
+    until: "17:00"
+  - days: [someday]
+`,
+			wantErrors: []string{`line 6: quiet_hours 1: invalid day "someday"`},
+		},
+		{
+			name: "minimum lowered",
+			yaml: `dreamhost_api_key: key
+min_check_interval: 1s
+check_interval: 5s
+publish_interval: 2s
+`,
+		},
+		{
+			name: "missing keys",
+			yaml: `webhook:
+  listen: ":8080"
+providers:
+  backup:
+    type: dreamhost
+domains:
+  - name: example.com
+    type: A
+  - name: example.org
+    type: A
+    provider: backup
+`,
+			wantErrors: []string{
+				"line 7: domain 0 (example.com): dreamhost_api_key is required",
+				"line 9: domain 1 (example.org): provider \"backup\": api_key is required",
+				"line 2: webhook: token is required",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := parseConfig([]byte(tt.yaml), configOptions{})
+			if err != nil {
+				t.Fatalf("parseConfig: %v", err)
+			}
+			applyConfigDefaults(config)
+
+			var got []string
+			for _, err := range validateConfig(config) {
+				got = append(got, err.Error())
+			}
+			if strings.Join(got, "\n") != strings.Join(tt.wantErrors, "\n") {
+				t.Errorf("expected errors:\n%s\ngot:\n%s", strings.Join(tt.wantErrors, "\n"), strings.Join(got, "\n"))
+			}
+		})
+	}
+}
+
+// TestCheckConfigFile tests that parse errors and capability problems are reported without starting the daemon
+func TestCheckConfigFile(t *testing.T) {
+	tests := []struct {
+		name      string
+		yaml      string
+		wantError string
+	}{
+		{name: "valid", yaml: "dreamhost_api_key: key\ndomains:\n  - name: example.com\n    type: A\n"},
+		{name: "bad duration", yaml: "dreamhost_api_key: key\ncheck_interval: soon\n", wantError: "line 2"},
+		{name: "duration without unit", yaml: "dreamhost_api_key: key\ncheck_interval: 300\n", wantError: "durations need a unit"},
+		{name: "bad syntax", yaml: "domains:\n  - name: example.com\n\ttype: A\n", wantError: "tab character"},
+		{name: "unsupported provider type", yaml: "providers:\n  other:\n    type: route53\n    api_key: key\n", wantError: "unsupported type"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			if err := os.WriteFile(path, []byte(tt.yaml), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			errs := checkConfigFile(path, configOptions{})
+			if tt.wantError == "" {
+				if len(errs) > 0 {
+					t.Errorf("unexpected errors: %v", errs)
+				}
+				return
+			}
+			if len(errs) != 1 || !strings.Contains(errs[0].Error(), tt.wantError) {
+				t.Errorf("expected one error containing %q, got %v", tt.wantError, errs)
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strings"
+)
+
+// commit and buildDate describe the build, set at build time with
+// -ldflags "-X main.commit=... -X main.buildDate=...". Builds without them,
+// such as go install, take them from the VCS information Go embeds.
+var (
+	commit    = ""
+	buildDate = ""
+)
+
+// BuildInfo identifies the binary, for the version command, the startup
+// log line and bug reports.
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	BuildDate string `json:"build_date,omitempty"`
+	GoVersion string `json:"go_version"`
+	Platform  string `json:"platform"` // GOOS/GOARCH
+}
+
+// currentBuild returns the running binary's build information.
+func currentBuild() BuildInfo {
+	bi, _ := debug.ReadBuildInfo()
+	return newBuildInfo(bi)
+}
+
+// newBuildInfo fills in what the ldflags left unset from bi, which may be
+// nil: the module version of a go install, and the commit and its time.
+// A commit with uncommitted changes is marked -dirty.
+func newBuildInfo(bi *debug.BuildInfo) BuildInfo {
+	info := BuildInfo{
+		Version:   version,
+		Commit:    commit,
+		BuildDate: buildDate,
+		GoVersion: runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+	}
+	if bi == nil {
+		return info
+	}
+	if info.Version == "dev" && bi.Main.Version != "" && bi.Main.Version != "(devel)" {
+		info.Version = bi.Main.Version
+	}
+	settings := make(map[string]string)
+	for _, s := range bi.Settings {
+		settings[s.Key] = s.Value
+	}
+	if info.Commit == "" && settings["vcs.revision"] != "" {
+		info.Commit = settings["vcs.revision"]
+		if settings["vcs.modified"] == "true" {
+			info.Commit += "-dirty"
+		}
+	}
+	info.BuildDate = cmp.Or(info.BuildDate, settings["vcs.time"])
+	return info
+}
+
+// ShortCommit returns the commit abbreviated as git does.
+func (b BuildInfo) ShortCommit() string {
+	hash, dirty, _ := strings.Cut(b.Commit, "-")
+	if len(hash) > 12 {
+		hash = hash[:12]
+	}
+	if dirty != "" {
+		return hash + "-" + dirty
+	}
+	return hash
+}
+
+func (b BuildInfo) String() string {
+	s := "dh-ddns-updater " + b.Version
+	var details []string
+	if b.Commit != "" {
+		details = append(details, "commit "+b.ShortCommit())
+	}
+	if b.BuildDate != "" {
+		details = append(details, "built "+b.BuildDate)
+	}
+	details = append(details, b.GoVersion, b.Platform)
+	return s + " (" + strings.Join(details, ", ") + ")"
+}
+
+func runVersionCommand(args []string) int {
+	fs := flag.NewFlagSet("version", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "print the build information as JSON")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: dh-ddns-updater version [--json]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	info := currentBuild()
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(info); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to print build information: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+	fmt.Println(info)
+	return 0
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"runtime"
+	"runtime/debug"
+	"testing"
+)
+
+// TestNewBuildInfo tests that build information missing from the ldflags is
+// taken from the information Go embeds
+func TestNewBuildInfo(t *testing.T) {
+	vcs := func(revision, modified string) *debug.BuildInfo {
+		return &debug.BuildInfo{
+			Main: debug.Module{Version: "(devel)"},
+			Settings: []debug.BuildSetting{
+				{Key: "vcs.revision", Value: revision},
+				{Key: "vcs.time", Value: "2026-10-01T12:00:00Z"},
+				{Key: "vcs.modified", Value: modified},
+			},
+		}
+	}
+	tests := []struct {
+		name                 string
+		version, commit      string // Set by ldflags
+		bi                   *debug.BuildInfo
+		wantVersion          string
+		wantCommit, wantDate string
+	}{
+		{"no build info", "dev", "", nil, "dev", "", ""},
+		{"vcs", "dev", "", vcs("0123456789abcdef0123", "false"), "dev", "0123456789abcdef0123", "2026-10-01T12:00:00Z"},
+		{"vcs modified", "dev", "", vcs("0123456789abcdef0123", "true"), "dev", "0123456789abcdef0123-dirty", "2026-10-01T12:00:00Z"},
+		{"ldflags win", "1.4.0", "fedcba", vcs("0123456789abcdef0123", "true"), "1.4.0", "fedcba", "2026-10-01T12:00:00Z"},
+		{"go install", "dev", "", &debug.BuildInfo{Main: debug.Module{Version: "v1.4.0"}}, "v1.4.0", "", ""},
+	}
+	oldVersion, oldCommit := version, commit
+	defer func() { version, commit = oldVersion, oldCommit }()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			version, commit = tt.version, tt.commit
+			info := newBuildInfo(tt.bi)
+			if info.Version != tt.wantVersion || info.Commit != tt.wantCommit || info.BuildDate != tt.wantDate {
+				t.Errorf("got version %q, commit %q, date %q; want %q, %q, %q", info.Version, info.Commit, info.BuildDate, tt.wantVersion, tt.wantCommit, tt.wantDate)
+			}
+			if info.GoVersion != runtime.Version() {
+				t.Errorf("got Go version %q, want %q", info.GoVersion, runtime.Version())
+			}
+		})
+	}
+}
+
+// TestBuildInfoString tests the line the version command prints
+func TestBuildInfoString(t *testing.T) {
+	tests := []struct {
+		info BuildInfo
+		want string
+	}{
+		{BuildInfo{Version: "dev", GoVersion: "go1.24.1", Platform: "linux/arm64"}, "dh-ddns-updater dev (go1.24.1, linux/arm64)"},
+		{
+			BuildInfo{Version: "1.4.0", Commit: "0123456789abcdef0123-dirty", BuildDate: "2026-10-01T12:00:00Z", GoVersion: "go1.24.1", Platform: "linux/amd64"},
+			"dh-ddns-updater 1.4.0 (commit 0123456789ab-dirty, built 2026-10-01T12:00:00Z, go1.24.1, linux/amd64)",
+		},
+	}
+	for _, tt := range tests {
+		if got := tt.info.String(); got != tt.want {
+			t.Errorf("String() = %q, want %q", got, tt.want)
+		}
+	}
+}
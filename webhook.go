@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// WebhookConfig configures the endpoint through which devices that learn
+// the WAN IP first (router scripts, modem APIs) push it to the daemon.
+type WebhookConfig struct {
+	Listen         string `yaml:"listen"`          // HTTP listen address (e.g. ":8053"); empty disables the receiver
+	Token          string `yaml:"token"`           // Bearer token required on every request
+	TokenFile      string `yaml:"token_file"`      // File holding token instead
+	DisablePolling bool   `yaml:"disable_polling"` // Rely on pushed updates only and stop polling ipinfo.io
+}
+
+// webhookUpdate is the body of POST /update.
+type webhookUpdate struct {
+	IP string `json:"ip"`
+}
+
+// webhookResult is the response to an accepted update.
+type webhookResult struct {
+	IP      string `json:"ip"`
+	Changed bool   `json:"changed"` // Whether the IP differed from the last known one
+}
+
+// validatePushedIP checks that ip is a public unicast address, so a
+// misconfigured router script can't publish its LAN or loopback address.
+func validatePushedIP(ip string) (string, error) {
+	parsed := net.ParseIP(strings.TrimSpace(ip))
+	if parsed == nil {
+		return "", fmt.Errorf("invalid IP address %q", ip)
+	}
+	if !parsed.IsGlobalUnicast() || parsed.IsPrivate() {
+		return "", fmt.Errorf("%s is not a public address", parsed)
+	}
+	return parsed.String(), nil
+}
+
+// checkPushedFamily checks that address records following the public IP
+// take ip's family, so that an IPv6 address pushed to a config whose A
+// records follow the IP isn't published to them, nor an IPv4 address to
+// AAAA records. Configs without such records take either.
+func checkPushedFamily(config *Config, ip string) error {
+	v4, v6 := config.followedFamilies()
+	switch ipv4 := ipMatchesType(ip, "A"); {
+	case !v4 && !v6, ipv4 && v4, !ipv4 && v6:
+		return nil
+	case ipv4:
+		return fmt.Errorf("%s is an IPv4 address, but only AAAA records follow the public IP", ip)
+	default:
+		return fmt.Errorf("%s is an IPv6 address, but only A records follow the public IP", ip)
+	}
+}
+
+// webhookHandler returns the handler serving POST /update.
+func (d *DDNSUpdater) webhookHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/update", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(d.config.Webhook.Token)) != 1 {
+			d.logger.Warn("Rejected webhook update with invalid token", "remote", r.RemoteAddr)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var update webhookUpdate
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&update); err != nil {
+			http.Error(w, "invalid JSON body", http.StatusBadRequest)
+			return
+		}
+
+		ip, err := validatePushedIP(update.IP)
+		if err == nil {
+			err = checkPushedFamily(d.config, ip)
+		}
+		if err != nil {
+			d.logger.Warn("Rejected webhook update", "remote", r.RemoteAddr, "error", err)
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+
+		d.logger.Debug("Received webhook update", "remote", r.RemoteAddr, "ip", ip)
+		changed := d.setPublicIP(r.Context(), ip, "webhook")
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(webhookResult{IP: ip, Changed: changed})
+	})
+	return mux
+}
+
+// serveWebhook runs the webhook receiver until the context is cancelled.
+func (d *DDNSUpdater) serveWebhook(ctx context.Context, addr string) error {
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           d.webhookHandler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	d.logger.Info("Webhook receiver listening", "address", addr)
+	return serveHTTP(ctx, server)
+}
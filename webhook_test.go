@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestValidatePushedIP tests that only public unicast addresses are accepted
+func TestValidatePushedIP(t *testing.T) {
+	tests := []struct {
+		ip        string
+		want      string
+		wantError bool
+	}{
+		{ip: "203.0.113.42", want: "203.0.113.42"},
+		{ip: " 2001:db8::1 ", want: "2001:db8::1"},
+		{ip: "192.168.1.1", wantError: true},
+		{ip: "127.0.0.1", wantError: true},
+		{ip: "fd00::1", wantError: true},
+		{ip: "224.0.0.1", wantError: true},
+		{ip: "not-an-ip", wantError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			got, err := validatePushedIP(tt.ip)
+			if tt.wantError {
+				if err == nil {
+					t.Errorf("expected error, got %q", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+// TestCheckPushedFamily tests that a pushed address must suit the address
+// records following the public IP
+func TestCheckPushedFamily(t *testing.T) {
+	a := DomainConfig{Name: "example.com", Record: "home", Type: "A"}
+	aaaa := DomainConfig{Name: "example.com", Record: "home", Type: "AAAA"}
+	pinned := DomainConfig{Name: "example.com", Record: "nas", Type: "AAAA", Value: "2001:db8::7"}
+	txt := DomainConfig{Name: "example.com", Record: "home", Type: "TXT", Value: "ip={{.IP}}"}
+	tests := []struct {
+		name      string
+		domains   []DomainConfig
+		ip        string
+		wantError bool
+	}{
+		{name: "IPv4 for A records", domains: []DomainConfig{a}, ip: "203.0.113.42"},
+		{name: "IPv6 for A records", domains: []DomainConfig{a, pinned}, ip: "2001:db8::1", wantError: true},
+		{name: "IPv4 for AAAA records", domains: []DomainConfig{aaaa}, ip: "203.0.113.42", wantError: true},
+		{name: "IPv6 for dual-stack records", domains: []DomainConfig{a, aaaa}, ip: "2001:db8::1"},
+		{name: "no address records", domains: []DomainConfig{txt}, ip: "2001:db8::1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkPushedFamily(&Config{Domains: tt.domains}, tt.ip)
+			if (err != nil) != tt.wantError {
+				t.Errorf("expected error %v, got %v", tt.wantError, err)
+			}
+		})
+	}
+}
+
+// TestWebhookHandler tests authentication, validation and publication of pushed IPs
+func TestWebhookHandler(t *testing.T) {
+	d := &DDNSUpdater{
+		config: &Config{Webhook: WebhookConfig{Token: "s3cret"},
+			Domains: []DomainConfig{{Name: "example.com", Record: "home", Type: "A"}}},
+		logger:  slog.New(slog.NewJSONHandler(io.Discard, nil)),
+		desired: NewDesiredStore(),
+	}
+	notify := d.desired.Subscribe()
+	server := httptest.NewServer(d.webhookHandler())
+	defer server.Close()
+
+	post := func(token, body string) *http.Response {
+		req, _ := http.NewRequest("POST", server.URL+"/update", strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		return resp
+	}
+
+	tests := []struct {
+		name       string
+		token      string
+		body       string
+		wantStatus int
+	}{
+		{name: "missing token", body: `{"ip":"203.0.113.42"}`, wantStatus: http.StatusUnauthorized},
+		{name: "wrong token", token: "guess", body: `{"ip":"203.0.113.42"}`, wantStatus: http.StatusUnauthorized},
+		{name: "bad JSON", token: "s3cret", body: `{`, wantStatus: http.StatusBadRequest},
+		{name: "private IP", token: "s3cret", body: `{"ip":"10.0.0.1"}`, wantStatus: http.StatusUnprocessableEntity},
+		{name: "IPv6 address for A records", token: "s3cret", body: `{"ip":"2001:db8::1"}`, wantStatus: http.StatusUnprocessableEntity},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := post(tt.token, tt.body)
+			resp.Body.Close()
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("expected status %d, got %d", tt.wantStatus, resp.StatusCode)
+			}
+		})
+	}
+	if _, ok := d.desired.Get(DefaultSource); ok {
+		t.Fatal("rejected updates must not change the desired IP")
+	}
+
+	resp := post("s3cret", `{"ip":"203.0.113.42"}`)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", resp.StatusCode)
+	}
+	var result webhookResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+	if !result.Changed || result.IP != "203.0.113.42" {
+		t.Errorf("unexpected result: %+v", result)
+	}
+
+	if v, _ := d.desired.Get(DefaultSource); v.Value != "203.0.113.42" {
+		t.Errorf("expected desired IP 203.0.113.42, got %q", v.Value)
+	}
+	select {
+	case <-notify:
+	default:
+		t.Error("expected publication to be notified")
+	}
+}